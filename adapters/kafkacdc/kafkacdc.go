@@ -0,0 +1,165 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kafkacdc implements a nutsdb.CDCSink producing the change events to
+// a Kafka topic through the Kafka REST Proxy API v2, served by the Confluent
+// REST Proxy or by Redpanda.
+//
+// Every event is produced as a JSON record keyed by the key of the entry, so
+// that the changes of a key keep their order in its partition. Publish returns
+// once the proxy acknowledged every record.
+package kafkacdc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+const (
+	contentType = "application/vnd.kafka.json.v2+json"
+	accept      = "application/vnd.kafka.v2+json"
+)
+
+// ErrTopicEmpty is returned when the config has no topic.
+var ErrTopicEmpty = errors.New("kafkacdc: topic is empty")
+
+// Config represents the REST proxy and the topic.
+type Config struct {
+	// Endpoint is the base URL of the REST proxy, e.g. http://localhost:8082.
+	Endpoint string
+
+	// Topic is the topic the events are produced to.
+	Topic string
+
+	// Username and Password are sent with HTTP basic authentication when Username is set.
+	Username string
+	Password string
+
+	// Client is the http client sending the requests. Default is http.DefaultClient.
+	Client *http.Client
+}
+
+// Sink represents a nutsdb.CDCSink producing to a Kafka topic.
+type Sink struct {
+	cfg Config
+}
+
+// New returns a newly initialized Sink at given config.
+func New(cfg Config) (*Sink, error) {
+	if cfg.Topic == "" {
+		return nil, ErrTopicEmpty
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+
+	return &Sink{cfg: cfg}, nil
+}
+
+type record struct {
+	Key   []byte             `json:"key"`
+	Value nutsdb.ChangeEvent `json:"value"`
+}
+
+type produceRequest struct {
+	Records []record `json:"records"`
+}
+
+type produceResponse struct {
+	Offsets []struct {
+		Partition int     `json:"partition"`
+		Offset    int64   `json:"offset"`
+		ErrorCode *int    `json:"error_code"`
+		Error     *string `json:"error"`
+	} `json:"offsets"`
+}
+
+// Publish implements the nutsdb.CDCSink interface.
+func (s *Sink) Publish(events []nutsdb.ChangeEvent) error {
+	req := produceRequest{Records: make([]record, len(events))}
+	for i, e := range events {
+		req.Records[i] = record{Key: e.Key, Value: e}
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, s.cfg.Endpoint+"/topics/"+url.PathEscape(s.cfg.Topic), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("Accept", accept)
+	if s.cfg.Username != "" {
+		httpReq.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.cfg.Client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return &ResponseError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var produced produceResponse
+	if err := json.Unmarshal(body, &produced); err != nil {
+		return err
+	}
+
+	if len(produced.Offsets) != len(events) {
+		return fmt.Errorf("kafkacdc: %d records acknowledged out of %d", len(produced.Offsets), len(events))
+	}
+
+	for _, o := range produced.Offsets {
+		if o.Error != nil || o.ErrorCode != nil {
+			msg := ""
+			if o.Error != nil {
+				msg = *o.Error
+			}
+			return fmt.Errorf("kafkacdc: record not produced to partition %d: %s", o.Partition, msg)
+		}
+	}
+
+	return nil
+}
+
+// ResponseError records an error response of the REST proxy.
+type ResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface.
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("kafkacdc: status %d: %s", e.StatusCode, e.Body)
+}
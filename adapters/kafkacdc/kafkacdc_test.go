@@ -0,0 +1,152 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafkacdc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// fakeProxy is a minimal in-memory Kafka REST proxy.
+type fakeProxy struct {
+	mu       sync.Mutex
+	records  []record
+	failNext int
+}
+
+func (f *fakeProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path != "/topics/changes" || r.Header.Get("Content-Type") != contentType {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if f.failNext > 0 {
+		f.failNext--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	var req produceRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var offsets []string
+	for _, rec := range req.Records {
+		offsets = append(offsets, fmt.Sprintf(`{"partition":0,"offset":%d,"error_code":null,"error":null}`, len(f.records)))
+		f.records = append(f.records, rec)
+	}
+
+	w.Header().Set("Content-Type", accept)
+	fmt.Fprintf(w, `{"offsets":[%s]}`, strings.Join(offsets, ","))
+}
+
+func TestSink(t *testing.T) {
+	proxy := &fakeProxy{failNext: 1}
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	if _, err := New(Config{Endpoint: server.URL}); err != ErrTopicEmpty {
+		t.Errorf("expected ErrTopicEmpty, got %v", err)
+	}
+
+	sink, err := New(Config{Endpoint: server.URL + "/", Topic: "changes", Username: "user", Password: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = t.TempDir()
+	opt.SegmentSize = 8 * 1024
+	opt.CDC = nutsdb.CDCOptions{Sink: sink, RetryInterval: time.Millisecond, OnError: func(err error) {}}
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *nutsdb.Tx) error {
+		if err := tx.Put("bucket", []byte("key1"), []byte("value1"), nutsdb.Persistent); err != nil {
+			return err
+		}
+		return tx.Delete("bucket", []byte("key2"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if txID, _ := db.CDCCheckpoint(); txID == db.LastCommittedTxID() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the events produced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+
+	if len(proxy.records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(proxy.records))
+	}
+	put, del := proxy.records[0], proxy.records[1]
+	if string(put.Key) != "key1" || string(put.Value.Value) != "value1" || put.Value.Flag != nutsdb.DataSetFlag || put.Value.Bucket != "bucket" {
+		t.Errorf("unexpected put record %+v", put)
+	}
+	if string(del.Key) != "key2" || del.Value.Flag != nutsdb.DataDeleteFlag || del.Value.TxID != put.Value.TxID {
+		t.Errorf("unexpected delete record %+v", del)
+	}
+}
+
+func TestSink_ResponseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "broken")
+	}))
+	defer server.Close()
+
+	sink, err := New(Config{Endpoint: server.URL, Topic: "changes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Publish([]nutsdb.ChangeEvent{{Bucket: "bucket", Key: []byte("key")}})
+	respErr, ok := err.(*ResponseError)
+	if !ok || respErr.StatusCode != http.StatusInternalServerError || respErr.Body != "broken" {
+		t.Errorf("expected a *ResponseError, got %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package natscdc implements a nutsdb.CDCSink publishing the change events to
+// a NATS subject, speaking the NATS client protocol.
+//
+// Every event is published as a JSON message. Publish returns once the server
+// processed every message, confirmed by a PING, or, with JetStream, once the
+// stream acknowledged every message, so that the events are persisted. A
+// connection failing is closed, and dialed again by the next Publish.
+package natscdc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// DefaultTimeout is the default timeout of the connection and of a Publish.
+const DefaultTimeout = 5 * time.Second
+
+// ErrSubjectEmpty is returned when the config has no subject.
+var ErrSubjectEmpty = errors.New("natscdc: subject is empty")
+
+// Config represents the server and the subject.
+type Config struct {
+	// Addr is the address of the server, e.g. localhost:4222 or nats://localhost:4222.
+	Addr string
+
+	// Subject is the subject the events are published to.
+	Subject string
+
+	// User and Password, or Token, authenticate the connection when set.
+	User     string
+	Password string
+	Token    string
+
+	// JetStream is true if the subject is captured by a JetStream stream, whose
+	// acknowledgements are waited for.
+	JetStream bool
+
+	// Timeout is the timeout of the connection and of every Publish. Default is DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Sink represents a nutsdb.CDCSink publishing to a NATS subject.
+type Sink struct {
+	cfg   Config
+	mu    sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	inbox string // the prefix of the reply subjects of the JetStream acknowledgements
+	seq   uint64
+}
+
+// New returns a newly initialized Sink at given config. The connection is dialed by the first Publish.
+func New(cfg Config) (*Sink, error) {
+	if cfg.Subject == "" {
+		return nil, ErrSubjectEmpty
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	cfg.Addr = strings.TrimPrefix(cfg.Addr, "nats://")
+
+	return &Sink{cfg: cfg}, nil
+}
+
+// Publish implements the nutsdb.CDCSink interface.
+func (s *Sink) Publish(events []nutsdb.ChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.publish(events); err != nil {
+		s.close()
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the connection.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.close()
+}
+
+func (s *Sink) close() error {
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn, s.r = nil, nil
+
+	return err
+}
+
+// connect dials the server, and subscribes to the acknowledgements with JetStream.
+func (s *Sink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.cfg.Addr, s.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	s.conn, s.r = conn, bufio.NewReader(conn)
+
+	if err := s.handshake(); err != nil {
+		s.close()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Sink) handshake() error {
+	if err := s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		return err
+	}
+
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("natscdc: unexpected greeting %q", line)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "nutsdb-cdc",
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 1,
+	}
+	if s.cfg.User != "" {
+		options["user"] = s.cfg.User
+		options["pass"] = s.cfg.Password
+	}
+	if s.cfg.Token != "" {
+		options["auth_token"] = s.cfg.Token
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	cmd := "CONNECT " + string(connect) + "\r\n"
+	if s.cfg.JetStream {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		s.inbox = "_INBOX.nutsdb." + hex.EncodeToString(id)
+		cmd += "SUB " + s.inbox + ".* 1\r\n"
+	}
+	if _, err := io.WriteString(s.conn, cmd+"PING\r\n"); err != nil {
+		return err
+	}
+
+	return s.wait(nil)
+}
+
+// publish publishes the events and waits for the server to process them.
+func (s *Sink) publish(events []nutsdb.ChangeEvent) error {
+	if err := s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		return err
+	}
+
+	var acks map[string]struct{}
+	if s.cfg.JetStream {
+		acks = make(map[string]struct{}, len(events))
+	}
+
+	w := bufio.NewWriter(s.conn)
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		reply := ""
+		if acks != nil {
+			s.seq++
+			reply = s.inbox + "." + strconv.FormatUint(s.seq, 10)
+			acks[reply] = struct{}{}
+			reply += " "
+		}
+
+		fmt.Fprintf(w, "PUB %s %s%d\r\n", s.cfg.Subject, reply, len(payload))
+		w.Write(payload)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return s.wait(acks)
+}
+
+// wait reads the messages of the server up to the PONG and the acknowledgements.
+func (s *Sink) wait(acks map[string]struct{}) error {
+	pong := false
+	for !pong || len(acks) > 0 {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case line == "PING":
+			if _, err := io.WriteString(s.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case line == "PONG":
+			pong = true
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("natscdc: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case strings.HasPrefix(line, "MSG "):
+			if err := s.readAck(line, acks); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readAck reads the payload of the MSG line, a JetStream acknowledgement.
+func (s *Sink) readAck(line string, acks map[string]struct{}) error {
+	// MSG <subject> <sid> [reply-to] <#bytes>
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return fmt.Errorf("natscdc: malformed message %q", line)
+	}
+
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return fmt.Errorf("natscdc: malformed message %q", line)
+	}
+
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(s.r, payload); err != nil {
+		return err
+	}
+
+	var ack struct {
+		Error *struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload[:size], &ack); err != nil {
+		return fmt.Errorf("natscdc: malformed acknowledgement: %w", err)
+	}
+	if ack.Error != nil {
+		return fmt.Errorf("natscdc: message not stored: %s", ack.Error.Description)
+	}
+
+	delete(acks, fields[1])
+
+	return nil
+}
+
+func (s *Sink) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
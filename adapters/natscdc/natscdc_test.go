@@ -0,0 +1,226 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natscdc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// fakeServer is a minimal NATS server storing the published messages.
+type fakeServer struct {
+	ln        net.Listener
+	jetStream bool
+	mu        sync.Mutex
+	messages  []nutsdb.ChangeEvent
+	connects  []string
+	reject    bool
+}
+
+func newFakeServer(t *testing.T, jetStream bool) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{ln: ln, jetStream: jetStream}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "INFO {\"server_id\":\"fake\",\"jetstream\":true}\r\n")
+
+	sid := ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "CONNECT":
+			s.mu.Lock()
+			s.connects = append(s.connects, strings.TrimSpace(strings.TrimPrefix(line, "CONNECT")))
+			s.mu.Unlock()
+		case "SUB":
+			sid = fields[2]
+		case "PING":
+			fmt.Fprint(conn, "PING\r\n")
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PONG":
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			reject := s.reject
+			if !reject {
+				var e nutsdb.ChangeEvent
+				json.Unmarshal(payload[:size], &e)
+				s.messages = append(s.messages, e)
+			}
+			seq := len(s.messages)
+			s.mu.Unlock()
+
+			if reject && !s.jetStream {
+				fmt.Fprint(conn, "-ERR 'Permissions Violation'\r\n")
+				continue
+			}
+
+			if len(fields) == 4 {
+				ack := fmt.Sprintf(`{"stream":"CHANGES","seq":%d}`, seq)
+				if reject {
+					ack = `{"error":{"code":503,"description":"no responders"}}`
+				}
+				fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", fields[2], sid, len(ack), ack)
+			}
+		}
+	}
+}
+
+func (s *fakeServer) setReject(reject bool) {
+	s.mu.Lock()
+	s.reject = reject
+	s.mu.Unlock()
+}
+
+func openDB(t *testing.T, sink nutsdb.CDCSink) *nutsdb.DB {
+	opt := nutsdb.DefaultOptions
+	opt.Dir = t.TempDir()
+	opt.SegmentSize = 8 * 1024
+	opt.CDC = nutsdb.CDCOptions{Sink: sink, RetryInterval: time.Millisecond, OnError: func(err error) {}}
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSink(t *testing.T) {
+	for _, jetStream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("jetstream=%v", jetStream), func(t *testing.T) {
+			server := newFakeServer(t, jetStream)
+			defer server.ln.Close()
+
+			if _, err := New(Config{Addr: server.ln.Addr().String()}); err != ErrSubjectEmpty {
+				t.Errorf("expected ErrSubjectEmpty, got %v", err)
+			}
+
+			sink, err := New(Config{Addr: "nats://" + server.ln.Addr().String(), Subject: "changes", Token: "secret", JetStream: jetStream})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+
+			events := []nutsdb.ChangeEvent{
+				{TxID: 1, Bucket: "bucket", Key: []byte("key1"), Value: []byte("value1"), Flag: nutsdb.DataSetFlag},
+				{TxID: 1, Bucket: "bucket", Key: []byte("key2"), Flag: nutsdb.DataDeleteFlag},
+			}
+			if err := sink.Publish(events); err != nil {
+				t.Fatal(err)
+			}
+
+			server.setReject(true)
+			if err := sink.Publish(events[:1]); err == nil {
+				t.Error("expected the rejected message to fail")
+			}
+
+			// the connection closed by the failure is dialed again.
+			server.setReject(false)
+			if err := sink.Publish(events[1:]); err != nil {
+				t.Fatal(err)
+			}
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+
+			if len(server.messages) != 3 || string(server.messages[0].Value) != "value1" || string(server.messages[2].Key) != "key2" {
+				t.Errorf("unexpected messages %+v", server.messages)
+			}
+			if len(server.connects) != 2 || !strings.Contains(server.connects[0], `"auth_token":"secret"`) {
+				t.Errorf("unexpected connects %v", server.connects)
+			}
+		})
+	}
+}
+
+func TestSink_DB(t *testing.T) {
+	server := newFakeServer(t, true)
+	defer server.ln.Close()
+
+	sink, err := New(Config{Addr: server.ln.Addr().String(), Subject: "changes", JetStream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	db := openDB(t, sink)
+	defer db.Close()
+
+	if err := db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), nutsdb.Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if txID, _ := db.CDCCheckpoint(); txID == db.LastCommittedTxID() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the events published")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.messages) != 1 || server.messages[0].TxID != db.LastCommittedTxID() {
+		t.Errorf("unexpected messages %+v", server.messages)
+	}
+}
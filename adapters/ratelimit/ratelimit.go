@@ -0,0 +1,108 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides a fixed-window rate-limit counter store backed by
+// nutsdb TTL entries, and an http middleware using it.
+package ratelimit
+
+import (
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// DefaultBucket is the bucket that stores the counters.
+const DefaultBucket = "ratelimit"
+
+// Store represents the counters of fixed windows, each one expiring with its window.
+type Store struct {
+	db     *nutsdb.DB
+	Bucket string
+}
+
+// New returns a newly initialized Store at given db.
+func New(db *nutsdb.DB) *Store {
+	return &Store{db: db, Bucket: DefaultBucket}
+}
+
+// Incr increments the counter of key in the current window and returns
+// the new count and the time the window resets.
+//
+// The counter value stored format:
+//
+//	|------------------|
+//	|  count | resetAt |
+//	|------------------|
+//	| uint64 |  int64  |
+//	|------------------|
+func (s *Store) Incr(key string, window time.Duration) (count int64, resetAt time.Time, err error) {
+	now := time.Now()
+
+	err = s.db.Update(func(tx *nutsdb.Tx) error {
+		count, resetAt = 0, now.Add(window)
+
+		if e, err := tx.Get(s.Bucket, []byte(key)); err == nil && len(e.Value) == 16 {
+			if r := time.Unix(int64(binary.BigEndian.Uint64(e.Value[8:16])), 0); r.After(now) {
+				count = int64(binary.BigEndian.Uint64(e.Value[0:8]))
+				resetAt = r
+			}
+		}
+
+		count++
+
+		buf := make([]byte, 16)
+		binary.BigEndian.PutUint64(buf[0:8], uint64(count))
+		binary.BigEndian.PutUint64(buf[8:16], uint64(resetAt.Unix()))
+
+		// the TTL is in seconds, round up so the entry never expires before the window.
+		ttl := uint32(resetAt.Sub(now)/time.Second) + 1
+
+		return tx.Put(s.Bucket, []byte(key), buf, ttl)
+	})
+
+	return
+}
+
+// Middleware returns an http middleware allowing at most limit requests per window
+// for each key returned by keyFunc, replying 429 Too Many Requests above it.
+func Middleware(s *Store, limit int64, window time.Duration, keyFunc func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count, resetAt, err := s.Incr(keyFunc(r), window)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			remaining := limit - count
+			if remaining < 0 {
+				remaining = 0
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(limit, 10))
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+
+			if count > limit {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+func TestMiddleware(t *testing.T) {
+	fileDir := "/tmp/nutsdbtestratelimit"
+	if err := os.RemoveAll(fileDir); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = fileDir
+	opt.SegmentSize = 8 * 1024
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	handler := Middleware(New(db), 2, time.Minute, func(r *http.Request) string {
+		return r.RemoteAddr
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, code := range want {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != code {
+			t.Errorf("err request %d. got %d want %d", i, rec.Code, code)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3backup implements a nutsdb.BackupSink uploading the backup files
+// to S3-compatible object storage, such as AWS S3, MinIO, or Google Cloud
+// Storage through its XML API with HMAC keys.
+//
+// Every file is sent with a multipart upload, and failed requests are
+// retried with exponential backoff. The requests are signed with AWS
+// Signature Version 4 and use path-style URLs.
+package s3backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name of the object listing the files of the backup,
+// uploaded last by Complete. A backup without it is incomplete.
+const ManifestName = "MANIFEST"
+
+const (
+	// DefaultPartSize is the default size of the uploaded parts.
+	DefaultPartSize = 8 << 20
+
+	// MinPartSize is the min size of the uploaded parts but the last one.
+	MinPartSize = 5 << 20
+
+	// DefaultMaxRetries is the default number of retries of a failed request.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryBackoff is the default wait before the first retry, doubled for every next one.
+	DefaultRetryBackoff = 200 * time.Millisecond
+)
+
+// ErrPartSize is returned when the part size is less than MinPartSize.
+var ErrPartSize = errors.New("s3backup: part size less than 5MB")
+
+// Config represents the object storage location and credentials.
+type Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.us-east-1.amazonaws.com.
+	Endpoint string
+
+	// Region is the region used to sign the requests, e.g. us-east-1.
+	Region string
+
+	// Bucket is the object storage bucket.
+	Bucket string
+
+	// Prefix is prepended to the object names, e.g. "backups/2019-01-01/".
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// PartSize is the size of the uploaded parts. Default is DefaultPartSize.
+	PartSize int64
+
+	// MaxRetries is the number of retries of a failed request. Default is DefaultMaxRetries.
+	MaxRetries int
+
+	// RetryBackoff is the wait before the first retry. Default is DefaultRetryBackoff.
+	RetryBackoff time.Duration
+
+	// Client is the http client sending the requests. Default is http.DefaultClient.
+	Client *http.Client
+}
+
+// Sink represents a nutsdb.BackupSink writing to object storage.
+type Sink struct {
+	cfg     Config
+	written []string
+}
+
+// New returns a newly initialized Sink at given config.
+func New(cfg Config) (*Sink, error) {
+	if cfg.PartSize == 0 {
+		cfg.PartSize = DefaultPartSize
+	}
+	if cfg.PartSize < MinPartSize {
+		return nil, ErrPartSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = DefaultRetryBackoff
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+
+	return &Sink{cfg: cfg}, nil
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// Write implements the nutsdb.BackupSink interface.
+func (s *Sink) Write(name string, r io.Reader) error {
+	key := s.cfg.Prefix + name
+
+	body, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+
+	var initiated initiateMultipartUploadResult
+	if err := xml.Unmarshal(body, &initiated); err != nil {
+		return err
+	}
+
+	if err := s.uploadParts(key, initiated.UploadID, r); err != nil {
+		_, _ = s.do(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil)
+		return err
+	}
+
+	s.written = append(s.written, key)
+
+	return nil
+}
+
+// uploadParts uploads the content of r as the parts of the upload and completes it.
+func (s *Sink) uploadParts(key, uploadID string, r io.Reader) error {
+	var parts []completedPart
+	buf := make([]byte, s.cfg.PartSize)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		// an empty file is uploaded as one empty part.
+		if n > 0 || len(parts) == 0 {
+			partNumber := len(parts) + 1
+			etag, uploadErr := s.uploadPart(key, uploadID, partNumber, buf[:n])
+			if uploadErr != nil {
+				return uploadErr
+			}
+			parts = append(parts, completedPart{PartNumber: partNumber, ETag: etag})
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	payload, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, payload)
+
+	return err
+}
+
+// uploadPart uploads one part and returns its ETag.
+func (s *Sink) uploadPart(key, uploadID string, partNumber int, data []byte) (etag string, err error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	err = s.retry(func() error {
+		resp, _, err := s.send(http.MethodPut, key, query, data)
+		if err == nil {
+			etag = resp.Header.Get("ETag")
+		}
+		return err
+	})
+
+	return
+}
+
+// Complete implements the nutsdb.BackupSink interface.
+// It uploads the manifest listing the written files.
+func (s *Sink) Complete() error {
+	var manifest bytes.Buffer
+	for _, key := range s.written {
+		manifest.WriteString(strings.TrimPrefix(key, s.cfg.Prefix))
+		manifest.WriteByte('\n')
+	}
+
+	_, err := s.do(http.MethodPut, s.cfg.Prefix+ManifestName, nil, manifest.Bytes())
+
+	return err
+}
+
+// Abort implements the nutsdb.BackupSink interface.
+// It deletes the written files.
+func (s *Sink) Abort() (err error) {
+	for _, key := range s.written {
+		if _, deleteErr := s.do(http.MethodDelete, key, nil, nil); deleteErr != nil && err == nil {
+			err = deleteErr
+		}
+	}
+	s.written = nil
+
+	return err
+}
+
+// do sends the request with retries and returns the response body.
+func (s *Sink) do(method, key string, query url.Values, payload []byte) (body []byte, err error) {
+	err = s.retry(func() error {
+		_, body, err = s.send(method, key, query, payload)
+		return err
+	})
+
+	return
+}
+
+// retry calls fn until it succeeds, fails with a non retryable error, or the retries are exhausted.
+func (s *Sink) retry(fn func() error) (err error) {
+	backoff := s.cfg.RetryBackoff
+
+	for i := 0; ; i++ {
+		err = fn()
+		if err == nil || i >= s.cfg.MaxRetries {
+			return err
+		}
+
+		var respErr *ResponseError
+		if errors.As(err, &respErr) && !respErr.Temporary() {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// ResponseError records an error response of the service.
+type ResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface.
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("s3backup: status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the request may succeed when retried.
+func (e *ResponseError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// send sends one signed request.
+func (s *Sink) send(method, key string, query url.Values, payload []byte) (*http.Response, []byte, error) {
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	u.Path += "/" + s.cfg.Bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	s.sign(req, payload, time.Now())
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// CompleteMultipartUpload may report an error with a 200 status.
+	if resp.StatusCode/100 != 2 || bytes.Contains(body, []byte("<Error>")) {
+		statusCode := resp.StatusCode
+		if statusCode/100 == 2 {
+			statusCode = http.StatusInternalServerError
+		}
+		return nil, nil, &ResponseError{StatusCode: statusCode, Body: string(body)}
+	}
+
+	return resp, body, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to the request.
+func (s *Sink) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+// canonicalQuery returns the query sorted by key and encoded as required by the signature.
+func canonicalQuery(query url.Values) string {
+	return strings.Replace(query.Encode(), "+", "%20", -1)
+}
+
+// escapePath escapes every segment of the slash separated path.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = strings.Replace(url.QueryEscape(segment), "+", "%20", -1)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
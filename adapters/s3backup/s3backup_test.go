@@ -0,0 +1,169 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3backup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// fakeS3 is a minimal in-memory S3 multipart upload service.
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	parts     map[string]map[string][]byte
+	failNext  int
+	uploadSeq int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, parts: map[string]map[string][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if f.failNext > 0 {
+		f.failNext--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	key := r.URL.Path
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.uploadSeq++
+		id := fmt.Sprint(f.uploadSeq)
+		f.parts[id] = map[string][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && q.Get("uploadId") != "":
+		f.parts[q.Get("uploadId")][q.Get("partNumber")] = body
+		w.Header().Set("ETag", `"etag-`+q.Get("partNumber")+`"`)
+	case r.Method == http.MethodPost && q.Get("uploadId") != "":
+		var data []byte
+		for i := 1; i <= len(f.parts[q.Get("uploadId")]); i++ {
+			data = append(data, f.parts[q.Get("uploadId")][fmt.Sprint(i)]...)
+		}
+		f.objects[key] = data
+		delete(f.parts, q.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodDelete && q.Get("uploadId") != "":
+		delete(f.parts, q.Get("uploadId"))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestSink(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	sink, err := New(Config{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "backups",
+		Prefix:          "db/",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PartSize:        MinPartSize,
+		RetryBackoff:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := strings.Repeat("x", MinPartSize+10)
+	fake.failNext = 2
+	if err := sink.Write("0.dat", strings.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write("bpt/root/empty", strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Complete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(fake.objects["/backups/db/0.dat"]); got != large {
+		t.Errorf("err uploaded object size. got %d want %d", len(got), len(large))
+	}
+	if got := string(fake.objects["/backups/db/"+ManifestName]); got != "0.dat\nbpt/root/empty\n" {
+		t.Errorf("err manifest. got %q", got)
+	}
+
+	if err := sink.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["/backups/db/0.dat"]; ok {
+		t.Error("err Abort. object not deleted")
+	}
+
+	fake.failNext = DefaultMaxRetries + 1
+	if err := sink.Write("1.dat", strings.NewReader("data")); err == nil {
+		t.Error("err Write. got nil error after exhausted retries")
+	}
+
+	if _, err := New(Config{PartSize: 1}); err != ErrPartSize {
+		t.Errorf("err New. got %v want %v", err, ErrPartSize)
+	}
+}
+
+func TestSink_BackupTo(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = "/tmp/nutsdbtests3backup"
+	opt.SegmentSize = 8 * 1024
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sink, err := New(Config{Endpoint: server.URL, Region: "us-east-1", Bucket: "backups", AccessKeyID: "key", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.BackupTo(sink); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := fake.objects["/backups/"+ManifestName]; !ok {
+		t.Error("err BackupTo. manifest not uploaded")
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sessionstore implements a gorilla/sessions Store backed by nutsdb.
+//
+// Only the session ID is kept in the cookie; the session values are stored in
+// a nutsdb bucket with a TTL matching the session MaxAge, or DefaultMaxAge
+// for the browser sessions whose MaxAge is 0.
+package sessionstore
+
+import (
+	"encoding/base32"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/xujiajun/nutsdb"
+)
+
+// DefaultBucket is the bucket that stores the sessions.
+const DefaultBucket = "sessions"
+
+// Store represents a sessions.Store which saves the sessions in nutsdb.
+type Store struct {
+	db      *nutsdb.DB
+	Bucket  string
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	// DefaultMaxAge is the TTL in seconds of the sessions whose MaxAge is 0,
+	// which would otherwise be stored without expiration.
+	DefaultMaxAge int
+}
+
+// New returns a newly initialized Store at given db and key pairs.
+// See securecookie.CodecsFromPairs for the key pairs format.
+func New(db *nutsdb.DB, keyPairs ...[]byte) *Store {
+	return &Store{
+		db:     db,
+		Bucket: DefaultBucket,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		DefaultMaxAge: 60 * 20,
+	}
+}
+
+// Get returns a cached session for the request, see sessions.Registry.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session loaded from nutsdb if the request has a valid session cookie,
+// or a new session otherwise.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	if err := s.load(session); err != nil {
+		// unknown or expired session, start a new one.
+		return session, nil
+	}
+
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Save stores the session in nutsdb and writes the session ID cookie.
+// A negative MaxAge deletes the session.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.db.Update(func(tx *nutsdb.Tx) error {
+				return tx.Delete(s.Bucket, []byte(session.ID))
+			}); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	if err := s.save(session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+func (s *Store) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	age := session.Options.MaxAge
+	if age == 0 {
+		age = s.DefaultMaxAge
+	}
+
+	return s.db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(s.Bucket, []byte(session.ID), []byte(encoded), uint32(age))
+	})
+}
+
+func (s *Store) load(session *sessions.Session) error {
+	return s.db.View(func(tx *nutsdb.Tx) error {
+		e, err := tx.Get(s.Bucket, []byte(session.ID))
+		if err != nil {
+			return err
+		}
+
+		return securecookie.DecodeMulti(session.Name(), string(e.Value), &session.Values, s.Codecs...)
+	})
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+func TestStore_SaveLoad(t *testing.T) {
+	fileDir := "/tmp/nutsdbtestsessionstore"
+	if err := os.RemoveAll(fileDir); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = fileDir
+	opt.SegmentSize = 8 * 1024
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := New(db, []byte("secret-key"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := store.New(req, "sid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.IsNew {
+		t.Error("err New. want new session")
+	}
+
+	session.Values["user"] = "nuts"
+	rec := httptest.NewRecorder()
+	if err := store.Save(req, rec, session); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("err Save. got %d cookies want 1", len(cookies))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	loaded, err := store.New(req, "sid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.IsNew || loaded.Values["user"] != "nuts" {
+		t.Errorf("err load. got %+v", loaded.Values)
+	}
+
+	loaded.Options.MaxAge = -1
+	if err := store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, _ = store.New(req, "sid")
+	if !loaded.IsNew {
+		t.Error("err Save. session should be deleted")
+	}
+}
+
+func TestStore_DefaultMaxAge(t *testing.T) {
+	fileDir := "/tmp/nutsdbtestsessionstoremaxage"
+	if err := os.RemoveAll(fileDir); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = fileDir
+	opt.SegmentSize = 8 * 1024
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := New(db, []byte("secret-key"))
+	store.Options.MaxAge = 0
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := store.New(req, "sid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		e, err := tx.Get(store.Bucket, []byte(session.ID))
+		if err != nil {
+			return err
+		}
+		if e.Meta.TTL != uint32(store.DefaultMaxAge) {
+			t.Errorf("err Save. got TTL %d want %d", e.Meta.TTL, store.DefaultMaxAge)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	fp "path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrAdminToken is returned by ServeAdmin when Options.AdminToken is empty, which
+	// would leave the admin endpoint unprotected.
+	ErrAdminToken = errors.New("admin token is empty")
+
+	// ErrAdminServing is returned by ServeAdmin when the admin endpoint is already served.
+	ErrAdminServing = errors.New("admin endpoint is already served")
+
+	// ErrAdminBackupDir is returned by the backup of the admin endpoint when the dir is not
+	// in Options.AdminBackupDir, or Options.AdminBackupDir is empty.
+	ErrAdminBackupDir = errors.New("backup dir is not in the admin backup dir")
+)
+
+// defaultAdminProfileSeconds is the duration of the CPU profiles of the admin endpoint
+// without the seconds parameter.
+const defaultAdminProfileSeconds = 30
+
+// adminServer records the server of ServeAdmin.
+type adminServer struct {
+	mu  sync.Mutex
+	srv *http.Server
+}
+
+// AdminBucket describes a bucket listed by the admin endpoint.
+type AdminBucket struct {
+	Name string `json:"name"`
+
+	// DS is the data structure of the bucket, see DataStructureBPTree.
+	DS uint16 `json:"ds"`
+}
+
+// AdminStats represents the statistics returned by the admin endpoint.
+type AdminStats struct {
+	// Health is the Health of the database, its LastError being reported apart.
+	Health    Health `json:"health"`
+	LastError string `json:"last_error,omitempty"`
+
+	LastTxID       uint64          `json:"last_tx_id"`
+	OpenTxs        int             `json:"open_txs"`
+	IndexCache     IndexCacheStats `json:"index_cache"`
+	SkippedRecords int             `json:"skipped_records"`
+
+	// Files and Buckets are the FileStats and the BucketStats, omitted in HintBPTSparseIdxMode.
+	Files   []FileStat   `json:"files,omitempty"`
+	Buckets []BucketStat `json:"buckets,omitempty"`
+}
+
+// AdminHandler returns the http.Handler of the admin endpoint of the database, e.g. to
+// mount it on the server of the application, see ServeAdmin.
+func (db *DB) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", db.adminGet(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return db.adminStats()
+	}))
+	mux.HandleFunc("/manifest", db.adminGet(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return db.Manifest()
+	}))
+	mux.HandleFunc("/buckets", db.adminGet(func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return db.adminBuckets()
+	}))
+	mux.HandleFunc("/merge", db.adminPost(func(r *http.Request) error {
+		return db.Merge()
+	}))
+	mux.HandleFunc("/backup", db.adminPost(func(r *http.Request) error {
+		dir, err := db.adminBackupDir(r.FormValue("dir"))
+		if err != nil {
+			return err
+		}
+		return db.Backup(dir)
+	}))
+	mux.HandleFunc("/debug/pprof/", adminPprof)
+
+	return db.adminAuth(mux)
+}
+
+// ServeAdmin serves the admin endpoint of the database on addr in a background goroutine
+// until Close, for operating an embedded database without redeploying its application:
+//
+//	GET  /stats                the AdminStats
+//	GET  /manifest             the Manifest
+//	GET  /buckets              the AdminBuckets
+//	POST /merge                runs Merge
+//	POST /backup?dir=DIR       runs Backup to DIR of Options.AdminBackupDir
+//	GET  /debug/pprof/         the profiles of runtime/pprof, /debug/pprof/profile the CPU one
+//
+// Every request must carry the Options.AdminToken in an "Authorization: Bearer" header.
+// It returns the address listened, e.g. for an addr with port 0.
+func (db *DB) ServeAdmin(addr string) (net.Addr, error) {
+	if db.opt.AdminToken == "" {
+		return nil, ErrAdminToken
+	}
+
+	if err := db.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	db.admin.mu.Lock()
+	defer db.admin.mu.Unlock()
+
+	if db.admin.srv != nil {
+		return nil, ErrAdminServing
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: db.AdminHandler(), ReadHeaderTimeout: 10 * time.Second}
+	db.admin.srv = srv
+	go srv.Serve(ln)
+
+	return ln.Addr(), nil
+}
+
+// stopAdmin stops the server of ServeAdmin, if any.
+func (db *DB) stopAdmin() {
+	db.admin.mu.Lock()
+	defer db.admin.mu.Unlock()
+
+	if db.admin.srv != nil {
+		db.admin.srv.Close()
+		db.admin.srv = nil
+	}
+}
+
+// adminAuth returns next, answering 401 to the requests without the Options.AdminToken.
+func (db *DB) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if db.opt.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(db.opt.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminGet returns the handler of the GET requests writing the result of fn in JSON.
+func (db *DB) adminGet(fn func(w http.ResponseWriter, r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		v, err := fn(w, r)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// adminPost returns the handler of the POST requests triggering fn.
+func (db *DB) adminPost(fn func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := fn(r); err != nil {
+			adminError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// adminBackupDir returns the dir of Options.AdminBackupDir of a backup requested to the
+// admin endpoint, relative to it, or ErrAdminBackupDir if it resolves outside of it.
+func (db *DB) adminBackupDir(dir string) (string, error) {
+	if dir == "" {
+		return "", errors.New("missing dir")
+	}
+
+	root := db.opt.AdminBackupDir
+	if root == "" {
+		return "", ErrAdminBackupDir
+	}
+
+	path := fp.Join(root, dir)
+	rel, err := fp.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(fp.Separator)) {
+		return "", ErrAdminBackupDir
+	}
+
+	return path, nil
+}
+
+// adminError writes err, as a 403 for a dir outside of Options.AdminBackupDir, a 409
+// for a merge while another one runs and a 503 if the database is closed.
+func adminError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrAdminBackupDir):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrMergeInProgress):
+		status = http.StatusConflict
+	case errors.Is(err, ErrDBClosed):
+		status = http.StatusServiceUnavailable
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+// adminStats returns the AdminStats of the database.
+func (db *DB) adminStats() (*AdminStats, error) {
+	if err := db.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	s := &AdminStats{
+		Health:         db.Health(),
+		LastTxID:       db.LastCommittedTxID(),
+		OpenTxs:        len(db.OpenTxs()),
+		IndexCache:     db.IndexCacheStats(),
+		SkippedRecords: len(db.skippedRecords),
+	}
+	if s.Health.LastError != nil {
+		s.LastError = s.Health.LastError.Error()
+		s.Health.LastError = nil
+	}
+
+	if db.opt.EntryIdxMode != HintBPTSparseIdxMode {
+		files, err := db.FileStats()
+		if err != nil {
+			return nil, err
+		}
+		s.Files = files
+
+		buckets, err := db.BucketStats()
+		if err != nil {
+			return nil, err
+		}
+		s.Buckets = buckets
+	}
+
+	return s, nil
+}
+
+// adminBuckets returns the buckets of the database, sorted by name and data structure.
+func (db *DB) adminBuckets() ([]AdminBucket, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	buckets := []AdminBucket{}
+	for bucket := range db.BPTreeIdx {
+		buckets = append(buckets, AdminBucket{Name: bucket, DS: DataStructureBPTree})
+	}
+	for bucket := range db.lazyBuckets {
+		if _, ok := db.BPTreeIdx[bucket]; !ok {
+			buckets = append(buckets, AdminBucket{Name: bucket, DS: DataStructureBPTree})
+		}
+	}
+	for bucket := range db.SetIdx {
+		buckets = append(buckets, AdminBucket{Name: bucket, DS: DataStructureSet})
+	}
+	for bucket := range db.SortedSetIdx {
+		buckets = append(buckets, AdminBucket{Name: bucket, DS: DataStructureSortedSet})
+	}
+	for bucket := range db.ListIdx {
+		buckets = append(buckets, AdminBucket{Name: bucket, DS: DataStructureList})
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Name != buckets[j].Name {
+			return buckets[i].Name < buckets[j].Name
+		}
+		return buckets[i].DS < buckets[j].DS
+	})
+
+	return buckets, nil
+}
+
+// adminPprof serves the profiles of runtime/pprof, without registering the handlers of
+// net/http/pprof on http.DefaultServeMux.
+func adminPprof(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	switch name {
+	case "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%d\t%s\n", p.Count(), p.Name())
+		}
+		fmt.Fprintf(w, "-\tprofile\n")
+	case "profile":
+		seconds, err := strconv.Atoi(r.FormValue("seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = defaultAdminProfileSeconds
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-r.Context().Done():
+		}
+		pprof.StopCPUProfile()
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			http.NotFound(w, r)
+			return
+		}
+		debug, _ := strconv.Atoi(r.FormValue("debug"))
+		if debug == 0 {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		p.WriteTo(w, debug)
+	}
+}
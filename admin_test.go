@@ -0,0 +1,193 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func adminRequest(t *testing.T, method, url, token string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, body
+}
+
+func TestDB_AdminHandler(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestadmin", true)
+	opt.AdminToken = "secret"
+	opt.AdminBackupDir = "/tmp/nutsdbtestadminbackup"
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the data files of the merge.
+	for i := 0; i < 100; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), make([]byte, 100), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Update(func(tx *Tx) error {
+		return tx.SAdd("set", []byte("key"), []byte("member"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(db.AdminHandler())
+	defer srv.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		if resp, _ := adminRequest(t, http.MethodGet, srv.URL+"/stats", token); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("err token %q: status %d", token, resp.StatusCode)
+		}
+	}
+
+	resp, body := adminRequest(t, http.MethodGet, srv.URL+"/stats", "secret")
+	var stats AdminStats
+	if err := json.Unmarshal(body, &stats); resp.StatusCode != http.StatusOK || err != nil ||
+		stats.LastTxID != db.LastCommittedTxID() || len(stats.Files) < 2 || stats.Files[0].FileID != 0 || len(stats.Buckets) == 0 {
+		t.Errorf("err stats %d %s: %v", resp.StatusCode, body, err)
+	}
+
+	resp, body = adminRequest(t, http.MethodGet, srv.URL+"/manifest", "secret")
+	var m Manifest
+	if err := json.Unmarshal(body, &m); resp.StatusCode != http.StatusOK || err != nil || len(m.Files) != len(stats.Files) || m.Dir != opt.Dir {
+		t.Errorf("err manifest %d %s: %v", resp.StatusCode, body, err)
+	}
+
+	resp, body = adminRequest(t, http.MethodGet, srv.URL+"/buckets", "secret")
+	var buckets []AdminBucket
+	want := []AdminBucket{{Name: "bucket", DS: DataStructureBPTree}, {Name: "set", DS: DataStructureSet}}
+	if err := json.Unmarshal(body, &buckets); resp.StatusCode != http.StatusOK || err != nil || !reflect.DeepEqual(buckets, want) {
+		t.Errorf("err buckets %d %s: %v", resp.StatusCode, body, err)
+	}
+
+	// the triggers are POST only.
+	if resp, _ := adminRequest(t, http.MethodGet, srv.URL+"/merge", "secret"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("err GET merge: status %d", resp.StatusCode)
+	}
+	if resp, body := adminRequest(t, http.MethodPost, srv.URL+"/merge", "secret"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("err merge: status %d %s", resp.StatusCode, body)
+	}
+
+	// a merge is refused while another one runs.
+	if err := db.startMerge(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := adminRequest(t, http.MethodPost, srv.URL+"/merge", "secret"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("err concurrent merge: status %d %s", resp.StatusCode, body)
+	}
+	db.endMerge()
+
+	os.RemoveAll(opt.AdminBackupDir)
+	defer os.RemoveAll(opt.AdminBackupDir)
+	if resp, _ := adminRequest(t, http.MethodPost, srv.URL+"/backup", "secret"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("err backup without dir: status %d", resp.StatusCode)
+	}
+	if resp, body := adminRequest(t, http.MethodPost, srv.URL+"/backup?dir=daily/1", "secret"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("err backup: status %d %s", resp.StatusCode, body)
+	}
+	if _, err := ReadBackupManifest(opt.AdminBackupDir + "/daily/1"); err != nil {
+		t.Errorf("err backup manifest: %v", err)
+	}
+
+	// the dirs resolving outside of AdminBackupDir are rejected.
+	for _, dir := range []string{"..", "../nutsdbtestadminescape", "daily/../../nutsdbtestadminescape", "."} {
+		if resp, body := adminRequest(t, http.MethodPost, srv.URL+"/backup?dir="+url.QueryEscape(dir), "secret"); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("err backup to %s: status %d %s", dir, resp.StatusCode, body)
+		}
+	}
+	if _, err := os.Stat("/tmp/nutsdbtestadminescape"); !os.IsNotExist(err) {
+		t.Errorf("err backup written outside of AdminBackupDir: %v", err)
+	}
+
+	resp, body = adminRequest(t, http.MethodGet, srv.URL+"/debug/pprof/", "secret")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine") {
+		t.Errorf("err pprof index %d %s", resp.StatusCode, body)
+	}
+	resp, body = adminRequest(t, http.MethodGet, srv.URL+"/debug/pprof/goroutine?debug=1", "secret")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine profile") {
+		t.Errorf("err pprof goroutine %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := adminRequest(t, http.MethodGet, srv.URL+"/debug/pprof/unknown", "secret"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("err pprof unknown: status %d", resp.StatusCode)
+	}
+}
+
+func TestDB_ServeAdmin(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestadmin", true)
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ServeAdmin("127.0.0.1:0"); err != ErrAdminToken {
+		t.Errorf("err ServeAdmin without token: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opt.AdminToken = "secret"
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := db.ServeAdmin("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ServeAdmin("127.0.0.1:0"); err != ErrAdminServing {
+		t.Errorf("err ServeAdmin twice: %v", err)
+	}
+
+	url := "http://" + addr.String() + "/stats"
+	if resp, body := adminRequest(t, http.MethodGet, url, "secret"); resp.StatusCode != http.StatusOK {
+		t.Errorf("err stats: status %d %s", resp.StatusCode, body)
+	}
+
+	// Close stops serving.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("err the admin endpoint is served after Close")
+	}
+}
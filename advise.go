@@ -0,0 +1,100 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"os"
+)
+
+// fileAdvice is a hint given to the OS of how a data file will be read, see fadvise(2)
+// and madvise(2).
+type fileAdvice int
+
+const (
+	// adviceSequential hints the file is read from start to end, doubling the readahead.
+	adviceSequential fileAdvice = iota
+
+	// adviceRandom hints the file is read at random offsets, disabling the readahead.
+	adviceRandom
+
+	// adviceDontNeed hints the pages of the file are not read again, dropping them from
+	// the page cache.
+	adviceDontNeed
+)
+
+// adviser is implemented by the RWManagers passing the hints to the OS.
+type adviser interface {
+	advise(a fileAdvice) error
+}
+
+// advise gives the hint a to the OS for the data file. The hints are best effort, they
+// are ignored on the systems and the RWManagers not supporting them.
+func (df *DataFile) advise(a fileAdvice) {
+	if ad, ok := df.rwManager.(adviser); ok {
+		_ = ad.advise(a)
+	}
+}
+
+// advise gives the hint a to the OS for the file if it is an *os.File.
+func (fm *FileIORWManager) advise(a fileAdvice) error {
+	return adviseFile(fm.fd, a)
+}
+
+// adviseFile gives the hint a to the OS for the whole of fd if it is an *os.File.
+func adviseFile(fd StorageFile, a fileAdvice) error {
+	f, ok := fd.(*os.File)
+	if !ok {
+		return nil
+	}
+
+	return fadvise(f.Fd(), a)
+}
+
+// closeScanDataFile closes the data file read once from start to end, e.g. by a merge,
+// dropping its pages from the page cache so that the scan does not evict the pages of
+// the hot reads.
+func closeScanDataFile(df *DataFile) error {
+	df.advise(adviceDontNeed)
+	return df.Close()
+}
+
+// scanFile is a file read once from start to end, e.g. by a backup, whose pages are
+// dropped from the page cache on Close.
+type scanFile struct {
+	*os.File
+}
+
+// openScanFile opens the named file read-only for reading it once from start to end,
+// with direct I/O if direct is set.
+func openScanFile(name string, direct bool) (io.ReadCloser, error) {
+	if direct {
+		return openDirectFile(name)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	_ = adviseFile(f, adviceSequential)
+
+	return scanFile{f}, nil
+}
+
+// Close drops the pages of the file from the page cache and closes it.
+func (f scanFile) Close() error {
+	_ = adviseFile(f.File, adviceDontNeed)
+	return f.File.Close()
+}
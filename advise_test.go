@@ -0,0 +1,120 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestAdvise(t *testing.T) {
+	path := "/tmp/nutsdbtestadvisefile"
+	defer os.Remove(path)
+
+	for _, rwMode := range []RWMode{FileIO, MMap} {
+		rw, err := NewRWManager(path, 8*1024, rwMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ad, ok := rw.(adviser)
+		if !ok {
+			t.Fatalf("err RWMode %d: the RWManager does not advise", rwMode)
+		}
+		for _, a := range []fileAdvice{adviceSequential, adviceRandom, adviceDontNeed} {
+			if err := ad.advise(a); err != nil {
+				t.Errorf("err RWMode %d advice %d: %v", rwMode, a, err)
+			}
+		}
+
+		if err := rw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_AdviseRandomReads(t *testing.T) {
+	for _, rwMode := range []RWMode{FileIO, MMap} {
+		t.Run(fmt.Sprintf("RWMode %d", rwMode), func(t *testing.T) {
+			InitOpt("/tmp/nutsdbtestadvise", true)
+			opt.EntryIdxMode = HintKeyAndRAMIdxMode
+			opt.RWMode = rwMode
+			opt.StartFileLoadingMode = rwMode
+			opt.SegmentSize = 8 * 1024
+			opt.AdviseRandomReads = true
+			backup := "/tmp/nutsdbtestadvise_backup"
+			os.RemoveAll(backup)
+			defer os.RemoveAll(backup)
+
+			db, err = Open(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for version := 0; version < 3; version++ {
+				for i := 0; i < 100; i++ {
+					if err := db.Update(func(tx *Tx) error {
+						return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%080d", i, version)), Persistent)
+					}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			check := func(db *DB) {
+				if err := db.View(func(tx *Tx) error {
+					for i := 0; i < 100; i++ {
+						e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+						if err != nil {
+							return err
+						}
+						if want := fmt.Sprintf("val_%d_%080d", i, 2); string(e.Value) != want {
+							t.Errorf("key_%d: got %s, want %s", i, e.Value, want)
+						}
+					}
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := db.Merge(); err != nil {
+				t.Fatal(err)
+			}
+			check(db)
+
+			if err := db.Backup(backup); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, dir := range []string{opt.Dir, backup} {
+				o := opt
+				o.Dir = dir
+				db, err := Open(o)
+				if err != nil {
+					t.Fatal(err)
+				}
+				check(db)
+				if err := db.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
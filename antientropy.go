@@ -0,0 +1,314 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+)
+
+// DefaultRepairLeafSize is the max entries of the ranges whose entries CompareAndRepair
+// copies instead of splitting them further.
+const DefaultRepairLeafSize = 64
+
+// ErrRepairEntryInvalid is returned by CompareAndRepair when the MerkleProvider returns
+// an entry which is neither a put nor a delete of a BPTree bucket.
+var ErrRepairEntryInvalid = errors.New("repair entry is invalid")
+
+// MerkleRange is the Merkle hash of the entries of a range of keys of a bucket.
+type MerkleRange struct {
+	// Hash is the Merkle root hash of the live entries of the range, see Tx.RootHash.
+	Hash []byte
+
+	// Count is the number of live entries of the range.
+	Count int
+
+	// Split is the median key of the range, splitting it in two halves, nil if it holds
+	// fewer than 2 entries.
+	Split []byte
+}
+
+// MerkleProvider is the replica compared by CompareAndRepair, implemented by DB. A
+// replica in another process implements it over any transport, serving the calls with
+// the methods of its DB.
+type MerkleProvider interface {
+	// MerkleBuckets returns the BPTree buckets.
+	MerkleBuckets() ([]string, error)
+
+	// MerkleRange returns the MerkleRange of the keys from start, included, to end,
+	// excluded, of the bucket, a nil start or end leaving the range open.
+	MerkleRange(bucket string, start, end []byte) (*MerkleRange, error)
+
+	// MerkleEntries returns the live entries and the deletions of the keys from start
+	// to end of the bucket, keeping their stamps, TTLs and site IDs.
+	MerkleEntries(bucket string, start, end []byte) (Entries, error)
+}
+
+// RepairReport is the report of a CompareAndRepair.
+type RepairReport struct {
+	// Buckets counts the buckets compared.
+	Buckets int
+
+	// Ranges counts the ranges whose hashes differed.
+	Ranges int
+
+	// Entries counts the entries copied from the replica.
+	Entries int
+}
+
+// CompareAndRepair copies the entries of the replica remote newer than the ones of the
+// database, for the periodic reconciliation of loosely-coupled replicas. The RootHash of
+// every bucket is compared, and the ranges of keys whose MerkleRange differ are split in
+// two halves until they hold at most DefaultRepairLeafSize entries, whose entries are
+// copied when they are newer, like by ApplySyncDelta: the last writer wins, by the
+// stamps of the writes, see MetaData.Seq, the deletions included while
+// the replica has their tombstones. Only the entries of the replica are copied, so
+// repairing both ways makes the two replicas converge. The internal buckets are not
+// compared, and the replicas must use the same KeyComparator for every bucket. It is
+// not supported in HintBPTSparseIdxMode.
+func (db *DB) CompareAndRepair(remote MerkleProvider) (*RepairReport, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	local, err := db.MerkleBuckets()
+	if err != nil {
+		return nil, err
+	}
+	others, err := remote.MerkleBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[string]struct{}, len(local))
+	for _, bucket := range append(local, others...) {
+		if !strings.HasPrefix(bucket, internalBucketPrefix) {
+			buckets[bucket] = struct{}{}
+		}
+	}
+
+	report := &RepairReport{}
+	for bucket := range buckets {
+		report.Buckets++
+		if err := db.repairRange(remote, bucket, nil, nil, report); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// repairRange repairs the keys of the bucket from start to end, see CompareAndRepair.
+func (db *DB) repairRange(remote MerkleProvider, bucket string, start, end []byte, report *RepairReport) error {
+	l, err := db.MerkleRange(bucket, start, end)
+	if err != nil {
+		return err
+	}
+	r, err := remote.MerkleRange(bucket, start, end)
+	if err != nil {
+		return err
+	}
+
+	if string(l.Hash) == string(r.Hash) {
+		return nil
+	}
+	report.Ranges++
+
+	// the larger side is split in two halves, so the ranges shrink.
+	split := r.Split
+	if l.Count > r.Count {
+		split = l.Split
+	}
+
+	if l.Count+r.Count <= DefaultRepairLeafSize || split == nil {
+		entries, err := remote.MerkleEntries(bucket, start, end)
+		if err != nil {
+			return err
+		}
+
+		n, err := db.applyRepairEntries(bucket, entries)
+		report.Entries += n
+
+		return err
+	}
+
+	if err := db.repairRange(remote, bucket, start, split, report); err != nil {
+		return err
+	}
+
+	return db.repairRange(remote, bucket, split, end, report)
+}
+
+// applyRepairEntries writes the entries of the bucket newer than the committed values of
+// their keys and returns their number.
+func (db *DB) applyRepairEntries(bucket string, entries Entries) (n int, err error) {
+	for _, e := range entries {
+		if e.Meta == nil || e.Meta.Flag != DataSetFlag && e.Meta.Flag != DataDeleteFlag {
+			return 0, ErrRepairEntryInvalid
+		}
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		tx.keepStamps = true
+		n = 0
+		for _, e := range entries {
+			if !tx.isNewerSyncEntry(bucket, e) || tx.isRepaired(bucket, e) {
+				continue
+			}
+
+			if err := tx.putKeeping(bucket, e, DataStructureBPTree); err != nil {
+				return err
+			}
+			n++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// isRepaired returns if the key of the entry already has its value, or is already
+// missing for a deletion, the entries of a differing range being mostly the same.
+func (tx *Tx) isRepaired(bucket string, e *Entry) bool {
+	le, err := tx.Get(bucket, e.Key)
+	if e.Meta.Flag == DataDeleteFlag {
+		return err != nil
+	}
+
+	return err == nil && bytes.Equal(le.Value, e.Value)
+}
+
+// MerkleBuckets implements the MerkleProvider interface.
+func (db *DB) MerkleBuckets() (buckets []string, err error) {
+	err = db.View(func(tx *Tx) error {
+		for bucket := range tx.db.BPTreeIdx {
+			buckets = append(buckets, bucket)
+		}
+		return nil
+	})
+
+	return
+}
+
+// MerkleRange implements the MerkleProvider interface.
+func (db *DB) MerkleRange(bucket string, start, end []byte) (mr *MerkleRange, err error) {
+	err = db.View(func(tx *Tx) error {
+		mr, err = tx.merkleRange(bucket, start, end)
+		return err
+	})
+
+	return
+}
+
+// MerkleEntries implements the MerkleProvider interface.
+func (db *DB) MerkleEntries(bucket string, start, end []byte) (entries Entries, err error) {
+	err = db.View(func(tx *Tx) error {
+		entries, err = tx.rangeEntries(bucket, start, end, true)
+		return err
+	})
+
+	return
+}
+
+// merkleRange returns the MerkleRange of the keys of the bucket from start to end.
+func (tx *Tx) merkleRange(bucket string, start, end []byte) (*MerkleRange, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	entries, err := tx.rangeEntries(bucket, start, end, false)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	level := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		level = append(level, merkleLeaf(h, e.Key, e.Value))
+	}
+
+	mr := &MerkleRange{Hash: merkleRoot(h, level), Count: len(entries)}
+	if len(entries) >= 2 {
+		mr.Split = entries[len(entries)/2].Key
+	}
+
+	return mr, nil
+}
+
+// rangeEntries returns the live entries of the keys of the bucket from start to end, in
+// the order of the keys, and their deletions if tombstones is set. The entries are puts
+// or deletes holding the full value, keeping the stamps, TTLs and site IDs.
+func (tx *Tx) rangeEntries(bucket string, start, end []byte, tombstones bool) (Entries, error) {
+	index, ok := tx.bptreeIdx(bucket)
+	if !ok {
+		return nil, nil
+	}
+
+	var entries Entries
+	now := tx.db.now()
+	_, keys, pointers := index.getAll()
+	for i, p := range pointers {
+		key := keys[i]
+		if start != nil && index.compare(key, start) < 0 {
+			continue
+		}
+		if end != nil && index.compare(key, end) >= 0 {
+			break
+		}
+
+		r := p.(*Record)
+		if isExpiredAt(r.H.meta.TTL, r.H.meta.timestamp, now) {
+			continue
+		}
+
+		// the keys of the tree are shared with the index.
+		key = append([]byte(nil), key...)
+		meta := *r.H.meta
+		meta.ds = DataStructureBPTree
+		meta.bucket, meta.bucketSize = []byte(bucket), uint32(len(bucket))
+		if meta.Flag == DataDeleteFlag {
+			if tombstones {
+				meta.keySize, meta.valueSize = uint32(len(key)), 0
+				entries = append(entries, &Entry{Key: key, Meta: &meta})
+			}
+			continue
+		}
+
+		e, err := tx.db.recordEntry(r)
+		if err != nil {
+			return nil, err
+		}
+		if e, err = tx.db.resolveDeltas(e); err != nil {
+			return nil, err
+		}
+
+		meta.Flag = DataSetFlag
+		meta.keySize, meta.valueSize = uint32(len(key)), uint32(len(e.Value))
+		// so are the values kept in RAM.
+		entries = append(entries, &Entry{Key: key, Value: append([]byte(nil), e.Value...), Meta: &meta})
+	}
+
+	return entries, nil
+}
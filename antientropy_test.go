@@ -0,0 +1,181 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDB_CompareAndRepair(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	a := openSyncDB(t, "/tmp/nutsdbtestrepair_a", 1, clock)
+	defer a.Close()
+	b := openSyncDB(t, "/tmp/nutsdbtestrepair_b", 2, clock)
+	defer b.Close()
+
+	update := func(db *DB, fn func(tx *Tx) error) {
+		if err := db.Update(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, db := range []*DB{a, b} {
+		update(db, func(tx *Tx) error {
+			for i := 0; i < 500; i++ {
+				if err := tx.Put("bucket", []byte(fmt.Sprintf("key_%03d", i)), []byte("v0"), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	clock.Advance(10 * time.Second)
+	update(b, func(tx *Tx) error {
+		if err := tx.Put("bucket", []byte("key_100"), []byte("new"), Persistent); err != nil {
+			return err
+		}
+		if err := tx.Delete("bucket", []byte("key_200")); err != nil {
+			return err
+		}
+		if err := tx.Put("bucket", []byte("key_600"), []byte("only_b"), Persistent); err != nil {
+			return err
+		}
+		return tx.Put("other", []byte("key"), []byte("val"), Persistent)
+	})
+	clock.Advance(10 * time.Second)
+	update(a, func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key_300"), []byte("a_new"), Persistent)
+	})
+
+	report, err := a.CompareAndRepair(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Buckets != 2 || report.Entries != 4 || report.Ranges < 3 {
+		t.Errorf("err report %+v", report)
+	}
+
+	for key, want := range map[string]string{"key_100": "new", "key_200": "", "key_300": "a_new", "key_600": "only_b", "key_000": "v0"} {
+		if got := getSyncValue(t, a, key); got != want {
+			t.Errorf("err %s. got %q want %q", key, got, want)
+		}
+	}
+
+	if _, err := b.CompareAndRepair(a); err != nil {
+		t.Fatal(err)
+	}
+	if got := getSyncValue(t, b, "key_300"); got != "a_new" {
+		t.Errorf("err key_300 repaired back. got %q want %q", got, "a_new")
+	}
+
+	for _, bucket := range []string{"bucket", "other"} {
+		ra, err := a.RootHash(bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rb, err := b.RootHash(bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ra, rb) {
+			t.Errorf("err RootHash of %s after repairing both ways: different", bucket)
+		}
+	}
+
+	report, err = a.CompareAndRepair(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Ranges != 0 || report.Entries != 0 {
+		t.Errorf("err report of converged replicas %+v", report)
+	}
+}
+
+func TestDB_CompareAndRepair_SameSecond(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	a := openSyncDB(t, "/tmp/nutsdbtestrepair_a", 1, clock)
+	defer a.Close()
+	b := openSyncDB(t, "/tmp/nutsdbtestrepair_b", 2, clock)
+	defer b.Close()
+
+	update := func(db *DB, fn func(tx *Tx) error) {
+		if err := db.Update(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update(a, func(tx *Tx) error {
+		return tx.Put("bucket", []byte("k"), []byte("v1"), Persistent)
+	})
+	if _, err := b.CompareAndRepair(a); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writes of the same second are ordered after the repaired one.
+	update(a, func(tx *Tx) error {
+		return tx.Append("bucket", []byte("k"), []byte("+more"))
+	})
+	update(a, func(tx *Tx) error {
+		return tx.Copy("bucket", []byte("k"), "bucket", []byte("dst"))
+	})
+	if _, err := b.CompareAndRepair(a); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"k", "dst"} {
+		if got := getSyncValue(t, b, key); got != "v1+more" {
+			t.Errorf("err %s. got %q want %q", key, got, "v1+more")
+		}
+	}
+
+	ra, err := a.RootHash("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := b.RootHash("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ra, rb) {
+		t.Errorf("err RootHash after repairing: different")
+	}
+}
+
+func TestDB_MerkleEntries_Copy(t *testing.T) {
+	db := openSyncDB(t, "/tmp/nutsdbtestmerkleentries", 1, NewManualClock(time.Unix(1000, 0)))
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.MerkleEntries("bucket", nil, nil)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("err MerkleEntries. got %d entries %v", len(entries), err)
+	}
+
+	// the entries returned do not share the memory of the index.
+	copy(entries[0].Key, "XXX")
+	copy(entries[0].Value, "XXX")
+	if got := getSyncValue(t, db, "key"); got != "val" {
+		t.Errorf("err Get after modifying the entries returned. got %q want %q", got, "val")
+	}
+}
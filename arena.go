@@ -0,0 +1,123 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+// arenaSlabSize is the number of objects of every slab of a recordArena.
+const arenaSlabSize = 1024
+
+// recordArena allocates the records, hints, entries and metas built while the
+// indexes are recovered or a file is merged in slabs, cutting the allocations of
+// every entry from several to a few per thousand entries, and so the GC work.
+// The arena is dropped wholesale once the indexes are built; a slab is freed by
+// the GC with the last object of it the indexes still point to.
+// A nil arena allocates every object on its own.
+type recordArena struct {
+	slabSize int // the objects of a slab, 0 for arenaSlabSize
+	records  []Record
+	hints    []Hint
+	entries  []Entry
+	metas    []MetaData
+}
+
+// newRecordArena returns an arena for about n objects of every kind, in slabs of
+// at most arenaSlabSize objects.
+func newRecordArena(n int) *recordArena {
+	if n > arenaSlabSize {
+		n = arenaSlabSize
+	}
+
+	return &recordArena{slabSize: n}
+}
+
+// size returns the number of objects of a new slab.
+func (a *recordArena) size() int {
+	if a.slabSize <= 0 {
+		return arenaSlabSize
+	}
+
+	return a.slabSize
+}
+
+// newRecord returns a zero Record.
+func (a *recordArena) newRecord() *Record {
+	if a == nil {
+		return &Record{}
+	}
+
+	if len(a.records) == 0 {
+		a.records = make([]Record, a.size())
+	}
+
+	r := &a.records[0]
+	a.records = a.records[1:]
+
+	return r
+}
+
+// newHint returns a zero Hint.
+func (a *recordArena) newHint() *Hint {
+	if a == nil {
+		return &Hint{}
+	}
+
+	if len(a.hints) == 0 {
+		a.hints = make([]Hint, a.size())
+	}
+
+	h := &a.hints[0]
+	a.hints = a.hints[1:]
+
+	return h
+}
+
+// newEntry returns a zero Entry.
+func (a *recordArena) newEntry() *Entry {
+	if a == nil {
+		return &Entry{}
+	}
+
+	if len(a.entries) == 0 {
+		a.entries = make([]Entry, a.size())
+	}
+
+	e := &a.entries[0]
+	a.entries = a.entries[1:]
+
+	return e
+}
+
+// readMetaData returns the MetaData at given buf slice, see readMetaData.
+func (a *recordArena) readMetaData(buf []byte) *MetaData {
+	m := a.newMetaData()
+	decodeMetaData(m, buf)
+
+	return m
+}
+
+// newMetaData returns a zero MetaData.
+func (a *recordArena) newMetaData() *MetaData {
+	if a == nil {
+		return &MetaData{}
+	}
+
+	if len(a.metas) == 0 {
+		a.metas = make([]MetaData, a.size())
+	}
+
+	m := &a.metas[0]
+	a.metas = a.metas[1:]
+
+	return m
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecordArena(t *testing.T) {
+	for _, a := range []*recordArena{nil, {}, newRecordArena(3)} {
+		seen := make(map[*Record]bool)
+		for i := 0; i < 2*arenaSlabSize; i++ {
+			r := a.newRecord()
+			if seen[r] || r.H != nil || r.E != nil {
+				t.Fatalf("err TestRecordArena: record %d reused or not zero", i)
+			}
+			seen[r] = true
+			r.H, r.E = a.newHint(), a.newEntry()
+		}
+	}
+
+	e := &Entry{
+		Key:   []byte("key"),
+		Value: []byte("val"),
+		Meta: &MetaData{
+			keySize:    3,
+			valueSize:  3,
+			timestamp:  1547707905,
+			bucket:     []byte("bucket"),
+			bucketSize: 6,
+			Flag:       DataSetFlag,
+			status:     Committed,
+			txID:       42,
+		},
+	}
+	buf := e.Encode()
+
+	meta := (&recordArena{}).readMetaData(buf)
+	if !reflect.DeepEqual(meta, readMetaData(buf)) {
+		t.Errorf("err TestRecordArena got %+v want %+v", meta, readMetaData(buf))
+	}
+}
+
+func TestRecordArena_Allocs(t *testing.T) {
+	a := &recordArena{}
+	allocs := testing.AllocsPerRun(10, func() {
+		for i := 0; i < arenaSlabSize; i++ {
+			a.newRecord()
+			a.newHint()
+		}
+	})
+
+	// a slab of records and one of hints for arenaSlabSize of each.
+	if allocs > 2 {
+		t.Errorf("err TestRecordArena_Allocs got %v allocs want 2", allocs)
+	}
+
+	if got := newRecordArena(10).size(); got != 10 {
+		t.Errorf("err newRecordArena got slabs of %d want 10", got)
+	}
+	if got := newRecordArena(10 * arenaSlabSize).size(); got != arenaSlabSize {
+		t.Errorf("err newRecordArena got slabs of %d want %d", got, arenaSlabSize)
+	}
+}
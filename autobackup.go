@@ -0,0 +1,191 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AutoBackupPrefix is the prefix of the names of the automatic backups.
+const AutoBackupPrefix = "backup-"
+
+// autoBackupTimeFormat formats the backup times so that the names sort by time.
+const autoBackupTimeFormat = "20060102T150405.000000000Z"
+
+// AutoBackupOptions represents the automatic backups of Options.AutoBackup.
+type AutoBackupOptions struct {
+	// Interval represents the time between two backups. 0 disables the automatic backups.
+	Interval time.Duration
+
+	// Dir represents the dir of the backups, each one written to a sub dir named after its time.
+	Dir string
+
+	// NewSink represents the function returning the sink of the backup at given name.
+	// When set, it is used instead of Dir.
+	NewSink func(name string) (BackupSink, error)
+
+	// Keep represents the number of backups kept, the older ones are removed.
+	// In Dir, all the backups are considered. With NewSink, only the backups
+	// taken since Open are, and they are removed by calling Remove.
+	// Default Keep is 0, which means keeping all the backups.
+	Keep int
+
+	// Remove represents the function removing the backup at given name written by NewSink.
+	Remove func(name string) error
+
+	// OnSuccess is called after every successful backup.
+	OnSuccess func(name string)
+
+	// OnFailure is called after every failed backup or pruning.
+	OnFailure func(name string, err error)
+}
+
+// autoBackup runs the automatic backups of the db.
+type autoBackup struct {
+	mu    sync.Mutex
+	opt   AutoBackupOptions
+	names []string // the backups written by NewSink since Open
+}
+
+// startAutoBackup starts the background goroutine of the automatic backups, if enabled.
+func (db *DB) startAutoBackup() {
+	if db.opt.AutoBackup.Interval <= 0 {
+		return
+	}
+
+	db.autoBackupStop = make(chan struct{})
+	db.autoBackupDone = make(chan struct{})
+	ab := &autoBackup{opt: db.opt.AutoBackup}
+
+	go func() {
+		defer close(db.autoBackupDone)
+
+		ticker := time.NewTicker(ab.opt.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ab.run(db, time.Now())
+			case <-db.autoBackupStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopAutoBackup stops the automatic backups and waits for the running one, if any.
+func (db *DB) stopAutoBackup() {
+	if db.autoBackupStop == nil {
+		return
+	}
+
+	select {
+	case <-db.autoBackupStop:
+	default:
+		close(db.autoBackupStop)
+	}
+
+	<-db.autoBackupDone
+}
+
+// run takes one backup at given time and prunes the old ones.
+func (ab *autoBackup) run(db *DB, now time.Time) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	name := AutoBackupPrefix + now.UTC().Format(autoBackupTimeFormat)
+
+	var sink BackupSink
+	var err error
+	if ab.opt.NewSink != nil {
+		sink, err = ab.opt.NewSink(name)
+	} else {
+		sink = NewDirSink(ab.opt.Dir + "/" + name)
+	}
+
+	if err == nil {
+		err = db.BackupTo(sink)
+	}
+
+	if err != nil {
+		db.health.recordError(err)
+		if ab.opt.OnFailure != nil {
+			ab.opt.OnFailure(name, err)
+		}
+		return
+	}
+
+	if ab.opt.NewSink != nil {
+		ab.names = append(ab.names, name)
+	}
+
+	if ab.opt.OnSuccess != nil {
+		ab.opt.OnSuccess(name)
+	}
+
+	if err := ab.prune(); err != nil {
+		db.health.recordError(err)
+		if ab.opt.OnFailure != nil {
+			ab.opt.OnFailure(name, err)
+		}
+	}
+}
+
+// prune removes the backups older than the last Keep ones.
+func (ab *autoBackup) prune() error {
+	if ab.opt.Keep <= 0 {
+		return nil
+	}
+
+	if ab.opt.NewSink != nil {
+		for len(ab.names) > ab.opt.Keep {
+			if ab.opt.Remove != nil {
+				if err := ab.opt.Remove(ab.names[0]); err != nil {
+					return err
+				}
+			}
+			ab.names = ab.names[1:]
+		}
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(ab.opt.Dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, f := range files {
+		if f.IsDir() && strings.HasPrefix(f.Name(), AutoBackupPrefix) && !strings.HasSuffix(f.Name(), ".tmp") {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for len(names) > ab.opt.Keep {
+		if err := os.RemoveAll(ab.opt.Dir + "/" + names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+
+	return nil
+}
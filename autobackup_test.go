@@ -0,0 +1,91 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_AutoBackup(t *testing.T) {
+	dir := "/tmp/nutsdbtestautobackup"
+	os.RemoveAll(dir)
+
+	successes := make(chan string, 100)
+	InitOpt("/tmp/nutsdbtestautobackupdb", true)
+	opt.AutoBackup = AutoBackupOptions{
+		Interval:  10 * time.Millisecond,
+		Dir:       dir,
+		Keep:      2,
+		OnSuccess: func(name string) { successes <- name },
+		OnFailure: func(name string, err error) { t.Errorf("err auto backup %s: %v", name, err) },
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-successes:
+		case <-time.After(5 * time.Second):
+			t.Fatal("err auto backup. no backup taken")
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("err auto backup pruning. got %d backups want 2", len(files))
+	}
+}
+
+func TestAutoBackup_PruneSinks(t *testing.T) {
+	dir := "/tmp/nutsdbtestautobackupsinks"
+	os.RemoveAll(dir)
+
+	InitOpt("/tmp/nutsdbtestautobackupdb", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var removed []string
+	ab := &autoBackup{opt: AutoBackupOptions{
+		NewSink: func(name string) (BackupSink, error) { return NewDirSink(dir + "/" + name), nil },
+		Keep:    1,
+		Remove: func(name string) error {
+			removed = append(removed, name)
+			return os.RemoveAll(dir + "/" + name)
+		},
+	}}
+
+	first := time.Unix(1, 0)
+	ab.run(db, first)
+	ab.run(db, first.Add(time.Second))
+
+	if len(removed) != 1 || removed[0] != AutoBackupPrefix+first.UTC().Format(autoBackupTimeFormat) {
+		t.Errorf("err prune of sinks. got %v", removed)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultAutoMergeMaxFiles is the max number of files merged by Options.MergeOnClose
+// and Options.CompactOnOpen when AutoMergeOptions.MaxFiles is 0.
+const DefaultAutoMergeMaxFiles = 8
+
+// AutoMergeOptions represents the merges run by Close and Open, see Options.MergeOnClose
+// and Options.CompactOnOpen. Like MergeWithOptions, they rewrite the live entries of the
+// dirtiest sealed files into the active file, skipping the files holding list or sorted
+// set entries. They are not run when ReadOnly is set or in HintBPTSparseIdxMode.
+type AutoMergeOptions struct {
+	// MinDirtyRatio represents the min part of the size of a sealed file a merge would
+	// free, see FileStat.DirtyRatio, for the file to be merged.
+	// Default MinDirtyRatio is 0, which means no merge.
+	MinDirtyRatio float64
+
+	// MaxFiles represents the max number of files merged, the dirtiest first.
+	// Default MaxFiles is 0, which means DefaultAutoMergeMaxFiles.
+	MaxFiles int
+
+	// Timeout represents the max time of the merge, the files merged when it expires
+	// staying merged.
+	// Default Timeout is 0, which means no limit.
+	Timeout time.Duration
+}
+
+// autoMerge runs the merge of the options, if enabled. It returns nil if no file is
+// dirty enough or the timeout expires.
+func (db *DB) autoMerge(o AutoMergeOptions) error {
+	if o.MinDirtyRatio <= 0 || db.opt.ReadOnly || db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil
+	}
+
+	opts := MergeOptions{MaxFiles: o.MaxFiles, MinDirtyRatio: o.MinDirtyRatio}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = DefaultAutoMergeMaxFiles
+	}
+
+	ctx := context.Background()
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	err := db.MergeWithContext(ctx, opts)
+	if err == ErrMergeFileCount || err == context.DeadlineExceeded {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_AutoMerge(t *testing.T) {
+	// write returns the number of data files after overwriting every key.
+	write := func(t *testing.T, versions int) int {
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for version := 0; version < versions; version++ {
+			for i := 0; i < 10; i++ {
+				if err := db.Update(func(tx *Tx) error {
+					return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%080d", i, version)), Persistent)
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		stats, err := db.FileStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(stats)
+	}
+
+	// check returns the number of data files after checking the values of the keys.
+	check := func(t *testing.T, version int) int {
+		if err := db.View(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+				if err != nil {
+					return err
+				}
+				if want := fmt.Sprintf("val_%d_%080d", i, version); string(e.Value) != want {
+					t.Errorf("key_%d: got %s, want %s", i, e.Value, want)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := db.FileStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(stats)
+	}
+
+	t.Run("MergeOnClose", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestautomerge", true)
+		opt.SegmentSize = 1024
+		opt.MergeOnClose = AutoMergeOptions{MinDirtyRatio: 0.5, MaxFiles: 2}
+
+		files := write(t, 5)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		opt.MergeOnClose = AutoMergeOptions{}
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := check(t, 4); got != files-2 {
+			t.Errorf("expected 2 files to be merged, got %d files from %d", got, files)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("CompactOnOpen", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestautomerge", true)
+		opt.SegmentSize = 1024
+
+		files := write(t, 5)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		opt.CompactOnOpen = AutoMergeOptions{MinDirtyRatio: 1.1}
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := check(t, 4); got != files {
+			t.Errorf("expected no file to be merged, got %d files from %d", got, files)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		opt.CompactOnOpen = AutoMergeOptions{MinDirtyRatio: 0.5}
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := check(t, 4); got >= files {
+			t.Errorf("expected the dirty files to be merged, got %d files from %d", got, files)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestautomerge", true)
+		opt.SegmentSize = 1024
+
+		files := write(t, 5)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		opt.ReadOnly = true
+		opt.CompactOnOpen = AutoMergeOptions{MinDirtyRatio: 0.5}
+		opt.MergeOnClose = AutoMergeOptions{MinDirtyRatio: 0.5}
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := check(t, 4); got != files {
+			t.Errorf("expected no file to be merged, got %d files from %d", got, files)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// BackupSink represents the destination of a backup streamed by DB.BackupTo.
+// Write is called once for every file of the backup, then Complete when all
+// the files are written, or Abort when the backup fails.
+type BackupSink interface {
+	// Write stores the file at given name, a slash separated path relative to the backup root.
+	Write(name string, r io.Reader) error
+
+	// Complete makes the backup visible.
+	Complete() error
+
+	// Abort discards the files written so far.
+	Abort() error
+}
+
+// DirSink represents a BackupSink writing to a local dir. The files are
+// written to a temporary sibling dir, renamed to dir on Complete.
+type DirSink struct {
+	dir    string
+	tmpDir string
+}
+
+// NewDirSink returns a newly initialized DirSink at given dir, which must not exist.
+func NewDirSink(dir string) *DirSink {
+	dir = path.Clean(dir)
+	return &DirSink{dir: dir, tmpDir: dir + ".tmp"}
+}
+
+// Write implements the BackupSink interface.
+func (s *DirSink) Write(name string, r io.Reader) error {
+	p := s.tmpDir + "/" + name
+	if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if _, err := io.Copy(fd, r); err != nil {
+		return err
+	}
+
+	return fd.Sync()
+}
+
+// Complete implements the BackupSink interface.
+func (s *DirSink) Complete() error {
+	if err := os.MkdirAll(s.tmpDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Rename(s.tmpDir, s.dir)
+}
+
+// Abort implements the BackupSink interface.
+func (s *DirSink) Abort() error {
+	return os.RemoveAll(s.tmpDir)
+}
+
+// BackupTo streams a consistent backup of the database to the sink. The
+// backup is a Checkpoint with a BackupManifest, taken in a temporary sibling
+// dir of the database, so the writable transactions are only blocked while
+// the active file is copied, not while the sink is written.
+func (db *DB) BackupTo(sink BackupSink) (err error) {
+	if db.opt.Storage != nil {
+		return ErrNotSupportStorage
+	}
+
+	dbDir := path.Clean(db.opt.Dir)
+	tmpDir, err := ioutil.TempDir(path.Dir(dbDir), path.Base(dbDir)+".backup")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	defer func() {
+		if err != nil {
+			_ = sink.Abort()
+		}
+	}()
+
+	m, err := db.checkpoint(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	if err = db.writeBackupManifest(tmpDir, m); err != nil {
+		return err
+	}
+
+	if err = writeDirToSink(sink, tmpDir, "", db.useDirectIO()); err != nil {
+		return err
+	}
+
+	return sink.Complete()
+}
+
+// writeDirToSink recursively writes the files of dir to the sink, named after their path relative to the root,
+// reading them with direct I/O if direct is set.
+func writeDirToSink(sink BackupSink, dir, prefix string, direct bool) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			if err := writeDirToSink(sink, dir+"/"+f.Name(), prefix+f.Name()+"/", direct); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fd, err := openScanFile(dir+"/"+f.Name(), direct)
+		if err != nil {
+			return err
+		}
+
+		err = sink.Write(prefix+f.Name(), fd)
+		fd.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyScanDir recursively copies the files of src to dst, reading them with direct I/O if
+// direct is set, see openScanFile, creating the dirs with dirPerm and the files with filePerm.
+func copyScanDir(src, dst string, dirPerm, filePerm os.FileMode, direct bool) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dst, dirPerm); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		var err error
+		if f.IsDir() {
+			err = copyScanDir(src+"/"+f.Name(), dst+"/"+f.Name(), dirPerm, filePerm, direct)
+		} else {
+			err = copyScanFile(src+"/"+f.Name(), dst+"/"+f.Name(), filePerm, direct)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyScanFile copies src to dst, created with perm, and syncs it.
+func copyScanFile(src, dst string, perm os.FileMode, direct bool) error {
+	in, err := openScanFile(src, direct)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+
+	return out.Sync()
+}
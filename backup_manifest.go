@@ -0,0 +1,170 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// BackupManifestFileName is the name of the file of the BackupManifest written by Backup and BackupTo.
+const BackupManifestFileName = "backup.manifest"
+
+// BackupManifest describes the transactions held by a backup. It is written last, so a
+// backup dir without it is incomplete.
+type BackupManifest struct {
+	// LastTxID is the ID of the last transaction committed in the backup. The IDs increasing
+	// in the order of the commits, the backup holds every transaction up to it and none after.
+	LastTxID uint64 `json:"last_tx_id"`
+
+	// DataFileIDs are the IDs of the data files of the backup, in ascending order.
+	DataFileIDs []int64 `json:"data_file_ids"`
+
+	// ActiveFileID is the ID of the active file when the backup was taken, copied only up to
+	// ActiveFileSize, the end of the last committed transaction.
+	ActiveFileID int64 `json:"active_file_id"`
+
+	// ActiveFileSize is the size in bytes of the committed entries of the active file.
+	ActiveFileSize int64 `json:"active_file_size"`
+
+	// CreatedAt is the time the backup was taken at in Unix seconds, see Options.Clock.
+	CreatedAt uint64 `json:"created_at"`
+}
+
+// ReadBackupManifest returns the BackupManifest of the backup at given dir.
+func ReadBackupManifest(dir string) (*BackupManifest, error) {
+	buf, err := ioutil.ReadFile(dir + "/" + BackupManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeBackupManifest(buf)
+}
+
+// decodeBackupManifest returns the BackupManifest encoded in buf.
+func decodeBackupManifest(buf []byte) (*BackupManifest, error) {
+	m := &BackupManifest{}
+	if err := json.Unmarshal(buf, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// writeBackupManifest writes m to the backup at given dir.
+func (db *DB) writeBackupManifest(dir string, m *BackupManifest) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return writeStorageFileAtomic(osStorage{}, dir+"/"+BackupManifestFileName, buf, true, db.filePerm())
+}
+
+// snapshotActiveFile returns the BackupManifest of the database and the committed part of
+// the active file. It must be called with db.writeMu held, so no commit is half written.
+func (db *DB) snapshotActiveFile() (*BackupManifest, []byte, error) {
+	buf, err := db.readActiveFile()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := &BackupManifest{
+		LastTxID:       db.LastCommittedTxID(),
+		ActiveFileID:   db.ActiveFile.fileID,
+		ActiveFileSize: int64(len(buf)),
+		CreatedAt:      db.now(),
+	}
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	for _, id := range dataFileIds {
+		if int64(id) <= m.ActiveFileID {
+			m.DataFileIDs = append(m.DataFileIDs, int64(id))
+		}
+	}
+
+	return m, buf, nil
+}
+
+// copyBackup copies the files of the database described by m to dir, writing the active
+// file from active, its committed part read by snapshotActiveFile, and then m.
+func (db *DB) copyBackup(dir string, m *BackupManifest, active []byte) error {
+	files, err := ioutil.ReadDir(db.opt.Dir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, db.dirPerm()); err != nil {
+		return err
+	}
+
+	dataFiles := make(map[int64]struct{}, len(m.DataFileIDs))
+	for _, id := range m.DataFileIDs {
+		dataFiles[id] = struct{}{}
+	}
+
+	direct := db.useDirectIO()
+	for _, f := range files {
+		src, dst := db.opt.Dir+"/"+f.Name(), dir+"/"+f.Name()
+
+		var err error
+		switch {
+		case f.Name() == getFileName(m.ActiveFileID, DataSuffix):
+			err = writeSegmentFile(dst, active, db.opt.SegmentSize, os.O_TRUNC, db.filePerm())
+		case !isBackupFile(f.Name(), m.ActiveFileID, dataFiles):
+			continue
+		case f.IsDir():
+			err = copyScanDir(src, dst, db.dirPerm(), db.filePerm(), direct)
+		default:
+			err = copyScanFile(src, dst, db.filePerm(), direct)
+		}
+		// a file removed by a running Merge has already been rewritten to the active file.
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return db.writeBackupManifest(dir, m)
+}
+
+// isBackupFile returns if the file of the db dir with given name is copied to a backup of
+// the data files dataFiles, the active file activeID being copied apart. The temporary
+// files, the WAL, whose entries are in the data files read, and the data files created
+// or the hint files written after the backup was taken, are not.
+func isBackupFile(name string, activeID int64, dataFiles map[int64]struct{}) bool {
+	if strings.HasSuffix(name, TempSuffix) || strings.HasSuffix(name, WALSuffix) {
+		return false
+	}
+
+	for _, suffix := range []string{DataSuffix, HintSuffix} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+
+		id, err := strconv2.StrToInt64(strings.TrimSuffix(name, suffix))
+		if err != nil {
+			return true
+		}
+		_, ok := dataFiles[id]
+
+		return ok && id != activeID
+	}
+
+	return true
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDB_BackupManifest(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbackupmanifest", true)
+	opt.SegmentSize = 8 * 1024
+	backup := "/tmp/nutsdbtestbackupmanifest_backup"
+	os.RemoveAll(backup)
+	defer os.RemoveAll(backup)
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%040d", i, i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the torn tail of a commit interrupted by a crash.
+	tail := bytes.Repeat([]byte{0xab}, 100)
+	if _, err := db.ActiveFile.rwManager.WriteAt(tail, db.ActiveFile.writeOff); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadBackupManifest(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.LastTxID != db.LastCommittedTxID() {
+		t.Errorf("err LastTxID. got %d want %d", m.LastTxID, db.LastCommittedTxID())
+	}
+	if m.ActiveFileID != db.ActiveFile.fileID || m.ActiveFileSize != db.ActiveFile.writeOff {
+		t.Errorf("err active file. got %d at %d want %d at %d", m.ActiveFileID, m.ActiveFileSize, db.ActiveFile.fileID, db.ActiveFile.writeOff)
+	}
+	if len(m.DataFileIDs) < 2 || m.DataFileIDs[len(m.DataFileIDs)-1] != m.ActiveFileID {
+		t.Errorf("err DataFileIDs %v", m.DataFileIDs)
+	}
+	for _, id := range m.DataFileIDs {
+		if _, err := os.Stat(backup + "/" + getFileName(id, DataSuffix)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	buf, err := ioutil.ReadFile(backup + "/" + getFileName(m.ActiveFileID, DataSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(buf)) != opt.SegmentSize {
+		t.Errorf("err active file size. got %d want %d", len(buf), opt.SegmentSize)
+	}
+	if !bytes.Equal(buf[m.ActiveFileSize:], make([]byte, opt.SegmentSize-m.ActiveFileSize)) {
+		t.Error("err active file: the uncommitted tail is copied")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	o := opt
+	o.Dir = backup
+	db, err := Open(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *Tx) error {
+		for i := 0; i < 200; i++ {
+			e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+			if err != nil {
+				return err
+			}
+			if want := fmt.Sprintf("val_%d_%040d", i, i); string(e.Value) != want {
+				t.Errorf("key_%d: got %s, want %s", i, e.Value, want)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.LastCommittedTxID(); got != m.LastTxID {
+		t.Errorf("err LastCommittedTxID of the backup. got %d want %d", got, m.LastTxID)
+	}
+}
+
+func TestDB_BackupToManifest(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbackupmanifest", true)
+	dir := "/tmp/nutsdbtestbackupmanifest_sink"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.BackupTo(NewDirSink(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadBackupManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.LastTxID != db.LastCommittedTxID() || m.ActiveFileSize != db.ActiveFile.writeOff {
+		t.Errorf("err manifest %+v", m)
+	}
+}
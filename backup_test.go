@@ -0,0 +1,81 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+type failingSink struct {
+	*DirSink
+	aborted bool
+}
+
+func (s *failingSink) Write(name string, r io.Reader) error {
+	return errors.New("sink failure")
+}
+
+func (s *failingSink) Abort() error {
+	s.aborted = true
+	return s.DirSink.Abort()
+}
+
+func TestDB_BackupTo(t *testing.T) {
+	dir := "/tmp/nutsdbtestbackupto"
+	os.RemoveAll(dir)
+
+	InitOpt("/tmp/nutsdbtestbackuptodb", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &failingSink{DirSink: NewDirSink(dir)}
+	if err := db.BackupTo(sink); err == nil || !sink.aborted {
+		t.Errorf("err BackupTo with failing sink. got %v aborted %v", err, sink.aborted)
+	}
+
+	if err := db.BackupTo(NewDirSink(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	backupOpt := opt
+	backupOpt.Dir = dir
+	backup, err := Open(backupOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+
+	if err := backup.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket", []byte("key"))
+		if err == nil && string(e.Value) != "value" {
+			t.Errorf("err backup value. got %s want value", e.Value)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
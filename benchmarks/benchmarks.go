@@ -0,0 +1,132 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package benchmarks provides reproducible workloads to evaluate nutsdb performance.
+//
+// Run them with:
+//
+//	go test -run=NONE -bench=. -benchmem ./benchmarks > new.txt
+//
+// and compare two runs with benchstat.
+package benchmarks
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// Bucket is the bucket used by the workloads.
+const Bucket = "bench"
+
+// Config represents one combination of options a workload runs against.
+type Config struct {
+	Name         string
+	EntryIdxMode nutsdb.EntryIdxMode
+	RWMode       nutsdb.RWMode
+}
+
+// Configs returns all the EntryIdxMode and RWMode combinations.
+func Configs() []Config {
+	modes := []struct {
+		name string
+		mode nutsdb.EntryIdxMode
+	}{
+		{"KeyValRAM", nutsdb.HintKeyValAndRAMIdxMode},
+		{"KeyRAM", nutsdb.HintKeyAndRAMIdxMode},
+		{"BPTSparse", nutsdb.HintBPTSparseIdxMode},
+	}
+
+	rwModes := []struct {
+		name string
+		mode nutsdb.RWMode
+	}{
+		{"FileIO", nutsdb.FileIO},
+		{"MMap", nutsdb.MMap},
+	}
+
+	var configs []Config
+	for _, m := range modes {
+		for _, rw := range rwModes {
+			configs = append(configs, Config{
+				Name:         m.name + "/" + rw.name,
+				EntryIdxMode: m.mode,
+				RWMode:       rw.mode,
+			})
+		}
+	}
+
+	return configs
+}
+
+// Options returns the options of the config for a DB in dir.
+// SyncEnable is disabled so the workloads measure nutsdb rather than the disk.
+func (c Config) Options(dir string) nutsdb.Options {
+	opt := nutsdb.DefaultOptions
+	opt.Dir = dir
+	opt.EntryIdxMode = c.EntryIdxMode
+	opt.RWMode = c.RWMode
+	opt.SegmentSize = 1024 * 1024
+	opt.SyncEnable = false
+
+	return opt
+}
+
+// Open opens an empty DB in dir at given config.
+func Open(dir string, c Config) (*nutsdb.DB, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+
+	return nutsdb.Open(c.Options(dir))
+}
+
+// Key returns the fixed width key of i, so that keys sort in numeric order.
+func Key(i int) []byte {
+	return []byte(fmt.Sprintf("key_%016d", i))
+}
+
+// Value returns a value of size bytes.
+func Value(size int) []byte {
+	value := make([]byte, size)
+	for i := range value {
+		value[i] = 'a' + byte(i%26)
+	}
+	return value
+}
+
+// NewRand returns a deterministic random source, so runs are comparable.
+func NewRand() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
+// Fill writes the keys [0, n) in batches of batchSize entries per transaction.
+func Fill(db *nutsdb.DB, n, batchSize int, value []byte) error {
+	for i := 0; i < n; i += batchSize {
+		if err := db.Update(func(tx *nutsdb.Tx) error {
+			for j := i; j < i+batchSize && j < n; j++ {
+				if err := tx.Put(Bucket, Key(j), value, nutsdb.Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package benchmarks
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+const (
+	benchDir   = "/tmp/nutsdbbench"
+	valueSize  = 100
+	preloadNum = 10000
+)
+
+func runConfigs(b *testing.B, fn func(b *testing.B, db *nutsdb.DB, c Config)) {
+	for _, c := range Configs() {
+		b.Run(c.Name, func(b *testing.B) {
+			db, err := Open(benchDir, c)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+
+			fn(b, db, c)
+		})
+	}
+}
+
+func BenchmarkFillSeq(b *testing.B) {
+	value := Value(valueSize)
+	runConfigs(b, func(b *testing.B, db *nutsdb.DB, c Config) {
+		b.SetBytes(valueSize)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := db.Update(func(tx *nutsdb.Tx) error {
+				return tx.Put(Bucket, Key(i), value, nutsdb.Persistent)
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFillRandom(b *testing.B) {
+	value := Value(valueSize)
+	runConfigs(b, func(b *testing.B, db *nutsdb.DB, c Config) {
+		r := NewRand()
+		b.SetBytes(valueSize)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := db.Update(func(tx *nutsdb.Tx) error {
+				return tx.Put(Bucket, Key(r.Intn(b.N)), value, nutsdb.Persistent)
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkReadRandom(b *testing.B) {
+	runConfigs(b, func(b *testing.B, db *nutsdb.DB, c Config) {
+		if err := Fill(db, preloadNum, 100, Value(valueSize)); err != nil {
+			b.Fatal(err)
+		}
+
+		r := NewRand()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := db.View(func(tx *nutsdb.Tx) error {
+				_, err := tx.Get(Bucket, Key(r.Intn(preloadNum)))
+				return err
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkScan(b *testing.B) {
+	runConfigs(b, func(b *testing.B, db *nutsdb.DB, c Config) {
+		if err := Fill(db, preloadNum, 100, Value(valueSize)); err != nil {
+			b.Fatal(err)
+		}
+
+		r := NewRand()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			start := r.Intn(preloadNum - 100)
+			if err := db.View(func(tx *nutsdb.Tx) error {
+				_, err := tx.RangeScan(Bucket, Key(start), Key(start+99))
+				return err
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkOpen measures the recovery of the indexes from the data files,
+// dominated by reading and checksumming the entries.
+func BenchmarkOpen(b *testing.B) {
+	for _, c := range Configs() {
+		b.Run(c.Name, func(b *testing.B) {
+			db, err := Open(benchDir, c)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := Fill(db, preloadNum, 100, Value(valueSize)); err != nil {
+				b.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				db, err := nutsdb.Open(c.Options(benchDir))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := db.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMergeUnderLoad interleaves a batch of overwrites with a merge,
+// measuring the cost of compaction while the DB keeps receiving writes.
+func BenchmarkMergeUnderLoad(b *testing.B) {
+	value := Value(valueSize)
+	runConfigs(b, func(b *testing.B, db *nutsdb.DB, c Config) {
+		if c.EntryIdxMode == nutsdb.HintBPTSparseIdxMode {
+			b.Skip("merge does not support HintBPTSparseIdxMode")
+		}
+
+		if err := Fill(db, preloadNum, 100, value); err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := Fill(db, preloadNum, 100, value); err != nil {
+				b.Fatal(err)
+			}
+			if err := db.Merge(); err != nil && err != nutsdb.ErrMergeFileCount {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkConcurrentReadsUnderWrites runs parallel View transactions on one
+// bucket while a writer keeps updating another bucket, measuring how much the
+// readers are held up by the writer. The writable transactions hold the write
+// lock while they run and only lock the indexes of their buckets while they
+// commit, so Commits and LongWriter, whose transactions read before writing,
+// are about as fast as NoWriter, while SameBucket, whose commits update the
+// bucket read, holds up the readers.
+func BenchmarkConcurrentReadsUnderWrites(b *testing.B) {
+	value := Value(valueSize)
+	writers := []struct {
+		name   string
+		reads  int    // the keys read by a writable transaction before its write
+		bucket string // the bucket written
+	}{
+		{"NoWriter", -1, ""},
+		{"Commits", 0, Bucket + "_write"},
+		{"SameBucket", 0, Bucket},
+		{"LongWriter", 100, Bucket + "_write"},
+	}
+
+	for _, w := range writers {
+		w := w
+		b.Run(w.name, func(b *testing.B) {
+			runConfigs(b, func(b *testing.B, db *nutsdb.DB, c Config) {
+				if err := Fill(db, preloadNum, 100, value); err != nil {
+					b.Fatal(err)
+				}
+
+				stop := make(chan struct{})
+				var wg sync.WaitGroup
+				if w.reads >= 0 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := 0; ; i++ {
+							select {
+							case <-stop:
+								return
+							default:
+							}
+							if err := db.Update(func(tx *nutsdb.Tx) error {
+								for j := 0; j < w.reads; j++ {
+									if _, err := tx.Get(Bucket, Key((i+j)%preloadNum)); err != nil {
+										return err
+									}
+								}
+								return tx.Put(w.bucket, Key(i%preloadNum), value, nutsdb.Persistent)
+							}); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					}()
+				}
+
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					r := rand.New(rand.NewSource(rand.Int63()))
+					for pb.Next() {
+						if err := db.View(func(tx *nutsdb.Tx) error {
+							_, err := tx.Get(Bucket, Key(r.Intn(preloadNum)))
+							return err
+						}); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+				b.StopTimer()
+
+				close(stop)
+				wg.Wait()
+			})
+		})
+	}
+}
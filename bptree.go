@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"unsafe"
 
 	"github.com/xujiajun/utils/strconv2"
@@ -83,6 +84,9 @@ type (
 		bucketSize       uint32
 		keyPosMap        map[string]int64
 		enabledKeyPosMap bool
+		comparator       KeyComparator
+		frontCoding      bool   // if the keys of the leaves are front-coded
+		bucket           []byte // the bucket of the records of a front-coded tree
 	}
 
 	// Records records multi-records as result when is called Range or PrefixScan.
@@ -91,6 +95,7 @@ type (
 	// Node records keys and pointers and parent node.
 	Node struct {
 		Keys     [][]byte
+		prefix   []byte // the prefix shared by the keys of a front-coded leaf
 		pointers []interface{}
 		parent   *Node
 		isLeaf   bool
@@ -184,7 +189,7 @@ func (t *BPTree) FindLeaf(key []byte) *Node {
 	for !curr.isLeaf {
 		i = 0
 		for i < curr.KeysNum {
-			if compare(key, curr.Keys[i]) >= 0 {
+			if t.compare(key, curr.Keys[i]) >= 0 {
 				i++
 			} else {
 				break
@@ -299,44 +304,44 @@ func (t *BPTree) WriteNode(n *Node, off int64, syncEnable bool, fd *os.File) (nu
 	return
 }
 
-// WriteNodes writes all nodes in the b+ tree to the File starting at byte offset off.
+// WriteNodes writes all nodes in the b+ tree to a temporary file renamed to the File,
+// so that a crash never leaves a partially written index.
 func (t *BPTree) WriteNodes(rwMode RWMode, syncEnable bool, flag int) error {
-	var (
-		n   *Node
-		i   int
-		err error
-	)
-
-	fd, err := os.OpenFile(t.Filepath, os.O_CREATE|os.O_RDWR, 0644)
-	defer fd.Close()
+	return t.writeNodes(rwMode, syncEnable, flag, DefaultFilePerm)
+}
 
-	if err != nil {
-		return err
-	}
+// writeNodes is WriteNodes with the File created with perm.
+func (t *BPTree) writeNodes(rwMode RWMode, syncEnable bool, flag int, perm os.FileMode) error {
+	return writeAtomicPerm(t.Filepath, syncEnable, perm, func(fd *os.File) error {
+		var (
+			n *Node
+			i int
+		)
 
-	queue = nil
+		queue = nil
 
-	enqueue(t.root)
+		enqueue(t.root)
 
-	for queue != nil {
-		n = dequeue()
+		for queue != nil {
+			n = dequeue()
 
-		_, err := t.WriteNode(n, -1, syncEnable, fd)
-		if err != nil {
-			return err
-		}
+			_, err := t.WriteNode(n, -1, false, fd)
+			if err != nil {
+				return err
+			}
 
-		if n != nil {
-			if !n.isLeaf {
-				for i = 0; i <= n.KeysNum; i++ {
-					c, _ := n.pointers[i].(*Node)
-					enqueue(c)
+			if n != nil {
+				if !n.isLeaf {
+					for i = 0; i <= n.KeysNum; i++ {
+						c, _ := n.pointers[i].(*Node)
+						enqueue(c)
+					}
 				}
 			}
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 // isValidAddress checks if the address is invalidate.
@@ -394,6 +399,15 @@ func compare(a, b []byte) int {
 	return bytes.Compare(a, b)
 }
 
+// compare compares the keys with the comparator of the tree, bytewise if it is nil.
+func (t *BPTree) compare(a, b []byte) int {
+	if t.comparator == nil {
+		return bytes.Compare(a, b)
+	}
+
+	return t.comparator.Compare(a, b)
+}
+
 func (t *BPTree) getAll() (numFound int, keys [][]byte, pointers []interface{}) {
 	var (
 		n    *Node
@@ -408,7 +422,7 @@ func (t *BPTree) getAll() (numFound int, keys [][]byte, pointers []interface{})
 
 	for n != nil {
 		for i = j; i < n.KeysNum; i++ {
-			keys = append(keys, n.Keys[i])
+			keys = append(keys, n.leafKey(i))
 			pointers = append(pointers, n.pointers[i])
 			numFound++
 		}
@@ -433,18 +447,18 @@ func (t *BPTree) findRange(start, end []byte) (numFound int, keys [][]byte, poin
 		return 0, nil, nil
 	}
 
-	for j = 0; j < n.KeysNum && compare(n.Keys[j], start) < 0; {
+	for j = 0; j < n.KeysNum && t.compareLeafKey(n, j, start) < 0; {
 		j++
 	}
 
 	scanFlag = true
 	for n != nil && scanFlag {
 		for i = j; i < n.KeysNum; i++ {
-			if compare(n.Keys[i], end) > 0 {
+			if t.compareLeafKey(n, i, end) > 0 {
 				scanFlag = false
 				break
 			}
-			keys = append(keys, n.Keys[i])
+			keys = append(keys, n.leafKey(i))
 			pointers = append(pointers, n.pointers[i])
 			numFound++
 		}
@@ -464,7 +478,7 @@ func (t *BPTree) All() (records Records, err error) {
 
 // Range returns records at the given start key and end key.
 func (t *BPTree) Range(start, end []byte) (records Records, err error) {
-	if compare(start, end) > 0 {
+	if t.compare(start, end) > 0 {
 		return nil, ErrStartKey
 	}
 
@@ -488,21 +502,26 @@ func getRecordWrapper(numFound int, keys [][]byte, pointers []interface{}) (reco
 // PrefixScan returns records at the given prefix and limitNum
 // limitNum: limit the number of the scanned records return.
 func (t *BPTree) PrefixScan(prefix []byte, limitNum int) (records Records, err error) {
+	if t.root == nil {
+		return nil, ErrPrefixScansNoResult
+	}
+
+	return getRecordWrapper(t.prefixScan(prefix, limitNum))
+}
+
+// prefixScan returns numFound,keys and pointers at the given prefix and limitNum.
+func (t *BPTree) prefixScan(prefix []byte, limitNum int) (numFound int, keys [][]byte, pointers []interface{}) {
 	var (
-		n              *Node
-		scanFlag       bool
-		keys           [][]byte
-		pointers       []interface{}
-		i, j, numFound int
+		n        *Node
+		scanFlag bool
+		i, j     int
 	)
 
-	n = t.FindLeaf(prefix)
-
-	if n == nil {
-		return nil, ErrPrefixScansNoResult
+	if n = t.FindLeaf(prefix); n == nil {
+		return 0, nil, nil
 	}
 
-	for j = 0; j < n.KeysNum && compare(n.Keys[j], prefix) < 0; {
+	for j = 0; j < n.KeysNum && t.compareLeafKey(n, j, prefix) < 0; {
 		j++
 	}
 
@@ -510,12 +529,12 @@ func (t *BPTree) PrefixScan(prefix []byte, limitNum int) (records Records, err e
 	numFound = 0
 	for n != nil && scanFlag {
 		for i = j; i < n.KeysNum; i++ {
-			if !bytes.HasPrefix(n.Keys[i], prefix) {
+			if !n.leafKeyHasPrefix(i, prefix) {
 				scanFlag = false
 				break
 			}
 
-			keys = append(keys, n.Keys[i])
+			keys = append(keys, n.leafKey(i))
 			pointers = append(pointers, n.pointers[i])
 			numFound++
 
@@ -529,7 +548,7 @@ func (t *BPTree) PrefixScan(prefix []byte, limitNum int) (records Records, err e
 		j = 0
 	}
 
-	return getRecordWrapper(numFound, keys, pointers)
+	return
 }
 
 // Find retrieves record at the given key.
@@ -547,7 +566,7 @@ func (t *BPTree) Find(key []byte) (*Record, error) {
 	}
 
 	for i = 0; i < leaf.KeysNum; i++ {
-		if compare(key, leaf.Keys[i]) == 0 {
+		if t.compareLeafKey(leaf, i, key) == 0 {
 			break
 		}
 	}
@@ -565,29 +584,33 @@ func (t *BPTree) startNewTree(key []byte, pointer *Record) error {
 	t.root.Keys[0] = key
 	t.root.pointers[0] = pointer
 	t.root.KeysNum = 1
+	t.encodeLeaf(t.root)
 
 	return nil
 }
 
 func (t *BPTree) checkAndSetFirstKey(key []byte, h *Hint) {
 	if len(t.FirstKey) == 0 {
-		t.FirstKey = key
+		t.FirstKey = t.ownKey(key)
 	} else {
-		if compare(key, t.FirstKey) < 0 && h.meta.Flag != DataDeleteFlag {
-			t.FirstKey = key
+		if t.compare(key, t.FirstKey) < 0 && h.meta.Flag != DataDeleteFlag {
+			t.FirstKey = t.ownKey(key)
 		}
 	}
 }
 
 func (t *BPTree) checkAndSetLastKey(key []byte, h *Hint) {
-	if compare(key, t.LastKey) > 0 && h.meta.Flag != DataDeleteFlag {
-		t.LastKey = key
+	if (len(t.LastKey) == 0 || t.compare(key, t.LastKey) > 0) && h.meta.Flag != DataDeleteFlag {
+		t.LastKey = t.ownKey(key)
 	}
 }
 
 // Insert inserts record to the b+ tree,
 // and if the key exists, update the record and the counter(if countFlag set true,it will start count).
+// In a front-coded tree, the key of h is dropped, see the front-coded leaves.
 func (t *BPTree) Insert(key []byte, e *Entry, h *Hint, countFlag bool) error {
+	t.stripHint(h)
+
 	t.checkAndSetFirstKey(key, h)
 
 	t.checkAndSetLastKey(key, h)
@@ -622,7 +645,7 @@ func (t *BPTree) Insert(key []byte, e *Entry, h *Hint, countFlag bool) error {
 	// Check if the leaf node is full or not
 	// if not full insert into the leaf node.
 	if leaf.KeysNum < order-1 {
-		insertIntoLeaf(leaf, key, pointer)
+		t.insertIntoLeaf(leaf, key, pointer)
 		return nil
 	}
 
@@ -630,6 +653,97 @@ func (t *BPTree) Insert(key []byte, e *Entry, h *Hint, countFlag bool) error {
 	return t.splitLeaf(leaf, key, pointer)
 }
 
+// BatchItem represents a record inserted by InsertBatch.
+type BatchItem struct {
+	Key []byte
+	E   *Entry
+	H   *Hint
+}
+
+// InsertBatch inserts the items as calling Insert for each of them in order would.
+// The items are sorted by key first, so that a key falling in the leaf of the
+// previous one is inserted there instead of searching the tree from the root.
+func (t *BPTree) InsertBatch(items []BatchItem, countFlag bool) error {
+	sort.SliceStable(items, func(i, j int) bool {
+		return t.compare(items[i].Key, items[j].Key) < 0
+	})
+
+	var leaf *Node
+	for _, item := range items {
+		if leaf != nil && !t.leafCovers(leaf, item.Key) {
+			leaf = nil
+		}
+
+		var err error
+		if leaf, err = t.insertIntoKnownLeaf(leaf, item.Key, item.E, item.H, countFlag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// leafCovers reports whether the key belongs to the leaf, whose keys are
+// not less than its first key and less than the first key of the next leaf.
+func (t *BPTree) leafCovers(leaf *Node, key []byte) bool {
+	if leaf.KeysNum == 0 || t.compareLeafKey(leaf, 0, key) > 0 {
+		return false
+	}
+
+	next, _ := leaf.pointers[order-1].(*Node)
+
+	return next == nil || t.compareLeafKey(next, 0, key) > 0
+}
+
+// insertIntoKnownLeaf inserts the record as Insert does, into the given leaf
+// if it is not nil. It returns the leaf of the key, nil if it was split.
+func (t *BPTree) insertIntoKnownLeaf(leaf *Node, key []byte, e *Entry, h *Hint, countFlag bool) (*Node, error) {
+	t.stripHint(h)
+
+	t.checkAndSetFirstKey(key, h)
+
+	t.checkAndSetLastKey(key, h)
+
+	if leaf == nil {
+		leaf = t.FindLeaf(key)
+	}
+
+	if leaf != nil {
+		for i := 0; i < leaf.KeysNum; i++ {
+			if t.compareLeafKey(leaf, i, key) != 0 {
+				continue
+			}
+
+			r := leaf.pointers[i].(*Record)
+			if countFlag && h.meta.Flag == DataDeleteFlag && r.H.meta.Flag != DataDeleteFlag && t.ValidKeyCount > 0 {
+				t.ValidKeyCount--
+			}
+
+			if countFlag && h.meta.Flag != DataDeleteFlag && r.H.meta.Flag == DataDeleteFlag {
+				t.ValidKeyCount++
+			}
+
+			return leaf, r.UpdateRecord(h, e)
+		}
+	}
+
+	pointer := &Record{H: h, E: e}
+
+	t.ValidKeyCount++
+
+	if t.root == nil {
+		err := t.startNewTree(key, pointer)
+		return t.root, err
+	}
+
+	if leaf.KeysNum < order-1 {
+		t.insertIntoLeaf(leaf, key, pointer)
+		return leaf, nil
+	}
+
+	return nil, t.splitLeaf(leaf, key, pointer)
+}
+
 // getSplitIndex returns split index at the given length.
 func getSplitIndex(length int) int {
 	if length%2 == 0 {
@@ -643,12 +757,14 @@ func getSplitIndex(length int) int {
 func (t *BPTree) splitLeaf(leaf *Node, key []byte, pointer *Record) error {
 	var j, k, i int
 
+	t.decodeLeaf(leaf)
+
 	tmpKeys := make([][]byte, order)
 	tmpPointers := make([]interface{}, order)
 
 	// Find the ready position of the insertion.
 	for i < order-1 {
-		if compare(leaf.Keys[i], key) < 0 {
+		if t.compare(leaf.Keys[i], key) < 0 {
 			i++
 		} else {
 			break
@@ -703,8 +819,11 @@ func (t *BPTree) splitLeaf(leaf *Node, key []byte, pointer *Record) error {
 	// Set the parent.
 	newLeaf.parent = leaf.parent
 
+	t.encodeLeaf(leaf)
+	t.encodeLeaf(newLeaf)
+
 	// Insert into the parent node at the given the the first key of the new leaf node.
-	newKey := newLeaf.Keys[0]
+	newKey := newLeaf.leafKey(0)
 	return t.insertIntoParent(leaf, newKey, newLeaf)
 }
 
@@ -840,10 +959,12 @@ func (t *BPTree) splitParent(node *Node, leftIndex int, key []byte, right *Node)
 }
 
 // insertIntoLeaf inserts the given node at the given key and pointer.
-func insertIntoLeaf(leaf *Node, key []byte, pointer *Record) {
+func (t *BPTree) insertIntoLeaf(leaf *Node, key []byte, pointer *Record) {
+	t.decodeLeaf(leaf)
+
 	i := 0
 	for i < leaf.KeysNum {
-		if compare(key, leaf.Keys[i]) > 0 {
+		if t.compare(key, leaf.Keys[i]) > 0 {
 			i++
 		} else {
 			break
@@ -858,4 +979,6 @@ func insertIntoLeaf(leaf *Node, key []byte, pointer *Record) {
 	leaf.Keys[i] = key
 	leaf.pointers[i] = pointer
 	leaf.KeysNum++
+
+	t.encodeLeaf(leaf)
 }
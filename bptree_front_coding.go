@@ -0,0 +1,149 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "bytes"
+
+// The leaves of a front-coded BPTree store the longest common prefix of their keys
+// once, in Node.prefix, and the suffixes of the keys after it in Node.Keys, all in
+// one buffer owned by the leaf. The internal nodes keep full keys.
+//
+// The records of a front-coded tree do not keep their key, as the leaf does, so
+// Hint.key is nil, and their bucket is the one of the tree: the buffers the keys
+// were read from, e.g. the data file entries with their values, are not kept in RAM.
+
+// leafKey returns the key at given index i of the leaf n, allocated if n is front-coded.
+func (n *Node) leafKey(i int) []byte {
+	if len(n.prefix) == 0 {
+		return n.Keys[i]
+	}
+
+	key := make([]byte, len(n.prefix)+len(n.Keys[i]))
+	copy(key, n.prefix)
+	copy(key[len(n.prefix):], n.Keys[i])
+
+	return key
+}
+
+// leafKeyHasPrefix reports whether the key at given index i of the leaf n begins with prefix.
+func (n *Node) leafKeyHasPrefix(i int, prefix []byte) bool {
+	if len(prefix) <= len(n.prefix) {
+		return bytes.HasPrefix(n.prefix, prefix)
+	}
+
+	return bytes.HasPrefix(prefix, n.prefix) && bytes.HasPrefix(n.Keys[i], prefix[len(n.prefix):])
+}
+
+// compareLeafKey compares the key at given index i of the leaf n with key, like compare.
+// Bytewise, the shared prefix is compared without rebuilding the key.
+func (t *BPTree) compareLeafKey(n *Node, i int, key []byte) int {
+	p := n.prefix
+	if len(p) == 0 {
+		return t.compare(n.Keys[i], key)
+	}
+
+	if t.comparator != nil {
+		return t.compare(n.leafKey(i), key)
+	}
+
+	if len(key) < len(p) {
+		if c := bytes.Compare(p[:len(key)], key); c != 0 {
+			return c
+		}
+		return 1
+	}
+
+	if c := bytes.Compare(p, key[:len(p)]); c != 0 {
+		return c
+	}
+
+	return bytes.Compare(n.Keys[i], key[len(p):])
+}
+
+// decodeLeaf sets the keys of the leaf n back to full keys before it is modified.
+func (t *BPTree) decodeLeaf(n *Node) {
+	if len(n.prefix) == 0 {
+		return
+	}
+
+	for i := 0; i < n.KeysNum; i++ {
+		n.Keys[i] = n.leafKey(i)
+	}
+	n.prefix = nil
+}
+
+// encodeLeaf front-codes the full keys of the leaf n, if the tree is front-coded,
+// copying the prefix and the suffixes into one buffer.
+func (t *BPTree) encodeLeaf(n *Node) {
+	if !t.frontCoding || n.KeysNum == 0 {
+		return
+	}
+
+	prefix := n.Keys[0]
+	size := 0
+	for i := 0; i < n.KeysNum; i++ {
+		prefix = prefix[:commonPrefixLen(prefix, n.Keys[i])]
+		size += len(n.Keys[i])
+	}
+	size -= (n.KeysNum - 1) * len(prefix)
+
+	buf := make([]byte, size)
+	off := copy(buf, prefix)
+	for i := 0; i < n.KeysNum; i++ {
+		end := off + copy(buf[off:], n.Keys[i][len(prefix):])
+		n.Keys[i] = buf[off:end:end]
+		off = end
+	}
+	n.prefix = buf[:len(prefix):len(prefix)]
+}
+
+// ownKey returns key, copied if the tree is front-coded, so that the tree does
+// not keep the buffer of key in RAM.
+func (t *BPTree) ownKey(key []byte) []byte {
+	if !t.frontCoding {
+		return key
+	}
+
+	return append([]byte(nil), key...)
+}
+
+// stripHint drops the key of the hint h of a front-coded tree, and points its bucket
+// to the one of the tree, see the front-coded leaves.
+func (t *BPTree) stripHint(h *Hint) {
+	if !t.frontCoding {
+		return
+	}
+
+	h.key = nil
+	if h.meta != nil {
+		h.meta.bucket = t.bucket
+	}
+}
+
+// commonPrefixLen returns the length of the longest common prefix of a and b.
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return n
+}
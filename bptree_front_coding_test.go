@@ -0,0 +1,177 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestBPTree_FrontCoding(t *testing.T) {
+	for _, cmp := range []KeyComparator{nil, CaseInsensitiveComparator{}} {
+		want := NewTree()
+		want.comparator = cmp
+		got := NewTree()
+		got.comparator = cmp
+		got.frontCoding = true
+		got.bucket = []byte("bucket")
+
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 2000; i++ {
+			key := []byte(fmt.Sprintf("user:%04d:orders:%04d", r.Intn(50), r.Intn(100)))
+			if i%2 == 0 {
+				if err := want.Insert(key, nil, &Hint{key: key, meta: &MetaData{Flag: DataSetFlag}}, CountFlagEnabled); err != nil {
+					t.Fatal(err)
+				}
+				if err := got.Insert(key, nil, &Hint{key: key, meta: &MetaData{Flag: DataSetFlag, bucket: []byte("bucket")}}, CountFlagEnabled); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+
+			items := []BatchItem{{Key: key, H: &Hint{key: key, meta: &MetaData{Flag: DataSetFlag}}}}
+			if err := want.InsertBatch(items, CountFlagEnabled); err != nil {
+				t.Fatal(err)
+			}
+			items = []BatchItem{{Key: key, H: &Hint{key: key, meta: &MetaData{Flag: DataSetFlag, bucket: []byte("bucket")}}}}
+			if err := got.InsertBatch(items, CountFlagEnabled); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got.ValidKeyCount != want.ValidKeyCount || !bytes.Equal(got.FirstKey, want.FirstKey) || !bytes.Equal(got.LastKey, want.LastKey) {
+			t.Errorf("err FrontCoding. got %d %s %s want %d %s %s", got.ValidKeyCount, got.FirstKey, got.LastKey,
+				want.ValidKeyCount, want.FirstKey, want.LastKey)
+		}
+
+		_, gotKeys, gotPointers := got.getAll()
+		_, wantKeys, _ := want.getAll()
+		if !reflect.DeepEqual(gotKeys, wantKeys) {
+			t.Fatalf("err FrontCoding getAll. got %d keys want %d", len(gotKeys), len(wantKeys))
+		}
+
+		for _, p := range gotPointers {
+			if h := p.(*Record).H; h.key != nil || &h.meta.bucket[0] != &got.bucket[0] {
+				t.Fatal("err FrontCoding: the hint keeps its key or bucket")
+			}
+		}
+
+		var compressed bool
+		for n := got.FindLeaf(got.FirstKey); n != nil; n, _ = n.pointers[order-1].(*Node) {
+			compressed = compressed || len(n.prefix) > 0
+		}
+		if !compressed {
+			t.Error("err FrontCoding: no leaf front-coded")
+		}
+
+		for _, key := range wantKeys {
+			if _, err := got.Find(key); err != nil {
+				t.Errorf("err FrontCoding Find(%s): %v", key, err)
+			}
+		}
+		for _, key := range []string{"user:0007:orders:9999", "user:00", "user:0007:orders:00000", "zzz", ""} {
+			_, wantErr := want.Find([]byte(key))
+			if _, err := got.Find([]byte(key)); err != wantErr {
+				t.Errorf("err FrontCoding Find(%s). got %v want %v", key, err, wantErr)
+			}
+		}
+
+		for _, prefix := range []string{"user:0007", "user:0007:orders:00", "user:", "USER:0007", "nope"} {
+			gotN, gotKeys, _ := got.prefixScan([]byte(prefix), 0)
+			wantN, wantKeys, _ := want.prefixScan([]byte(prefix), 0)
+			if gotN != wantN || !reflect.DeepEqual(gotKeys, wantKeys) {
+				t.Errorf("err FrontCoding prefixScan(%s). got %d want %d", prefix, gotN, wantN)
+			}
+		}
+
+		gotN, gotKeys, _ := got.findRange([]byte("user:0010"), []byte("user:0020:orders:0050"))
+		wantN, wantKeys, _ := want.findRange([]byte("user:0010"), []byte("user:0020:orders:0050"))
+		if gotN != wantN || !reflect.DeepEqual(gotKeys, wantKeys) {
+			t.Errorf("err FrontCoding findRange. got %d want %d", gotN, wantN)
+		}
+	}
+}
+
+func TestDB_FrontCodedKeys(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestfrontcoding", true)
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	opt.FrontCodedKeys = true
+	opt.ParanoidChecks = true
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "bucket"
+	for i := 0; i < 100; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			key := []byte(fmt.Sprintf("tenant:acme:user:%04d", i))
+			return tx.Put(bucket, key, []byte(fmt.Sprintf("val%d", i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.CheckpointIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Purge(bucket, []byte("tenant:acme:user:009")); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		if err := db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, []byte("tenant:acme:user:0042"))
+			if err != nil {
+				return err
+			}
+			if string(e.Value) != "val42" {
+				t.Errorf("err FrontCodedKeys Get. got %s want val42", e.Value)
+			}
+
+			es, err := tx.PrefixScan(bucket, []byte("tenant:acme:user:00"), 100)
+			if err != nil {
+				return err
+			}
+			if len(es) != 90 || string(es[0].Key) != "tenant:acme:user:0000" {
+				t.Errorf("err FrontCodedKeys PrefixScan. got %d entries", len(es))
+			}
+
+			if _, err := tx.Get(bucket, []byte("tenant:acme:user:0095")); err == nil {
+				t.Error("err FrontCodedKeys: purged key found")
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	check()
+}
@@ -17,6 +17,7 @@ package nutsdb
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"io/ioutil"
 	"os"
 	"sort"
 )
@@ -127,24 +128,29 @@ func ReadBPTreeRootIdxAt(path string, off int64) (*BPTreeRootIdx, error) {
 }
 
 // Persistence writes BPTreeRootIdx entry to the File starting at byte offset off.
+// The File is replaced atomically by a temporary copy including the entry.
 func (bri *BPTreeRootIdx) Persistence(path string, offset int64, syncEnable bool) (number int, err error) {
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
-	defer fd.Close()
+	return bri.persistence(path, offset, syncEnable, DefaultFilePerm)
+}
+
+// persistence is Persistence with the File created with perm.
+func (bri *BPTreeRootIdx) persistence(path string, offset int64, syncEnable bool, perm os.FileMode) (number int, err error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
 
 	data := bri.Encode()
+	if end := offset + int64(len(data)); int64(len(buf)) < end {
+		buf = append(buf, make([]byte, end-int64(len(buf)))...)
+	}
+	copy(buf[offset:], data)
 
-	n, err := fd.WriteAt(data, offset)
-	if err != nil {
+	if err := writeStorageFileAtomic(osStorage{}, path, buf, syncEnable, perm); err != nil {
 		return 0, err
 	}
 
-	if syncEnable {
-		err = fd.Sync()
-		if err != nil {
-			return 0, err
-		}
-	}
-	return n, err
+	return len(data), nil
 }
 
 // BPTreeRootIdxWrapper records BSGroup and by, in order to sort.
@@ -268,3 +268,49 @@ func TestBPTree_Update(t *testing.T) {
 		t.Error("err TestBPTree_Update")
 	}
 }
+
+func TestBPTree_InsertBatch(t *testing.T) {
+	want := NewTree()
+	got := NewTree()
+
+	for round := 0; round < 3; round++ {
+		var items []BatchItem
+		for i := 0; i < 500; i++ {
+			flag := DataSetFlag
+			if (i+round)%7 == 0 {
+				flag = DataDeleteFlag
+			}
+			key := []byte(fmt.Sprintf("key_%04d", (i*37+round*11)%400))
+			h := &Hint{key: key, meta: &MetaData{Flag: flag, timestamp: uint64(round*1000 + i)}}
+			items = append(items, BatchItem{Key: key, H: h})
+
+			if err := want.Insert(key, nil, h, CountFlagEnabled); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := got.InsertBatch(items, CountFlagEnabled); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got.ValidKeyCount != want.ValidKeyCount {
+		t.Errorf("err InsertBatch ValidKeyCount. got %d want %d", got.ValidKeyCount, want.ValidKeyCount)
+	}
+
+	if !reflect.DeepEqual(got.FirstKey, want.FirstKey) || !reflect.DeepEqual(got.LastKey, want.LastKey) {
+		t.Errorf("err InsertBatch first and last keys. got %s %s want %s %s", got.FirstKey, got.LastKey, want.FirstKey, want.LastKey)
+	}
+
+	gotRecords, _ := got.All()
+	wantRecords, _ := want.All()
+	if len(gotRecords) != len(wantRecords) {
+		t.Fatalf("err InsertBatch records. got %d want %d", len(gotRecords), len(wantRecords))
+	}
+	for i := range wantRecords {
+		if !reflect.DeepEqual(gotRecords[i].H.key, wantRecords[i].H.key) || gotRecords[i].H.meta.timestamp != wantRecords[i].H.meta.timestamp {
+			t.Errorf("err InsertBatch record %d. got %s %d want %s %d", i,
+				gotRecords[i].H.key, gotRecords[i].H.meta.timestamp, wantRecords[i].H.key, wantRecords[i].H.meta.timestamp)
+		}
+	}
+}
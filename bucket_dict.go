@@ -0,0 +1,222 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sync"
+)
+
+// BucketDictFileName is the name of the file persisting the IDs of the buckets, see
+// Options.BucketDictionary.
+const BucketDictFileName = "bucket.dict"
+
+// bucketIDSize is the size of the bucket ID stored by an entry instead of its bucket.
+const bucketIDSize = 4
+
+// ErrBucketIDNotFound is returned when reading an entry whose bucket ID is not in the
+// dictionary of the buckets, see Options.BucketDictionary.
+var ErrBucketIDNotFound = wrapError("bucket ID not found", ErrCorrupted)
+
+// bucketDict maps the buckets to the IDs stored by the entries of the frames flagged
+// frameBucketIDs instead of their names. The IDs are assigned in sequence from 1 and
+// never reused, so the dictionary only grows, persisted before the entries using them.
+type bucketDict struct {
+	mu    sync.RWMutex
+	ids   map[string]uint32
+	names [][]byte // the bucket of every ID, from 1
+	dirty bool     // IDs were added since the dictionary was persisted
+	load  func() ([]byte, error)
+}
+
+// newBucketDict returns the dictionary read by load, empty if its file does not exist.
+func newBucketDict(load func() ([]byte, error)) (*bucketDict, error) {
+	d := &bucketDict{ids: make(map[string]uint32), load: load}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// reload adds the IDs persisted since the dictionary was read, e.g. by the writer of a
+// follower. It must be called with the d.mu lock held, but by newBucketDict.
+func (d *bucketDict) reload() error {
+	buf, err := d.load()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	names, err := decodeBucketDict(buf)
+	if err != nil {
+		return err
+	}
+	for i := len(d.names); i < len(names); i++ {
+		d.names = append(d.names, names[i])
+		d.ids[string(names[i])] = uint32(i + 1)
+	}
+
+	return nil
+}
+
+// id returns the ID of bucket, adding it to the dictionary if needed.
+func (d *bucketDict) id(bucket []byte) uint32 {
+	d.mu.RLock()
+	id, ok := d.ids[string(bucket)]
+	d.mu.RUnlock()
+	if ok {
+		return id
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id, ok := d.ids[string(bucket)]; ok {
+		return id
+	}
+	d.names = append(d.names, append([]byte(nil), bucket...))
+	id = uint32(len(d.names))
+	d.ids[string(bucket)] = id
+	d.dirty = true
+
+	return id
+}
+
+// name returns the bucket of given id, reloading the dictionary once if it is unknown.
+func (d *bucketDict) name(id uint32) ([]byte, error) {
+	if d == nil {
+		return nil, ErrBucketIDNotFound
+	}
+
+	d.mu.RLock()
+	name, ok := d.lookup(id)
+	d.mu.RUnlock()
+	if ok {
+		return name, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if name, ok := d.lookup(id); ok {
+		return name, nil
+	}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	if name, ok := d.lookup(id); ok {
+		return name, nil
+	}
+
+	return nil, ErrBucketIDNotFound
+}
+
+// lookup returns the bucket of given id. It must be called with the d.mu lock held.
+func (d *bucketDict) lookup(id uint32) ([]byte, bool) {
+	if id == 0 || int(id) > len(d.names) {
+		return nil, false
+	}
+
+	return d.names[id-1], true
+}
+
+// encodeBucketDict returns the encoding of the buckets, in the order of their IDs:
+// a crc followed by the size and the name of every bucket.
+func encodeBucketDict(names [][]byte) []byte {
+	buf := make([]byte, 4)
+	for _, name := range names {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(name)))
+		buf = append(buf, size[:]...)
+		buf = append(buf, name...)
+	}
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	return buf
+}
+
+// decodeBucketDict returns the buckets encoded by encodeBucketDict.
+func decodeBucketDict(buf []byte) ([][]byte, error) {
+	if len(buf) < 4 || crc32.ChecksumIEEE(buf[4:]) != binary.LittleEndian.Uint32(buf[0:4]) {
+		return nil, ErrCrc
+	}
+
+	var names [][]byte
+	for off := 4; off < len(buf); {
+		if len(buf)-off < 4 {
+			return nil, ErrCorrupted
+		}
+		size := int(binary.LittleEndian.Uint32(buf[off : off+4]))
+		off += 4
+		if size < 0 || len(buf)-off < size {
+			return nil, ErrCorrupted
+		}
+		names = append(names, buf[off:off+size:off+size])
+		off += size
+	}
+
+	return names, nil
+}
+
+// getBucketDictPath returns the path of the dictionary of the buckets.
+func (db *DB) getBucketDictPath() string {
+	return db.opt.Dir + "/" + BucketDictFileName
+}
+
+// openBucketDict reads the dictionary of the buckets.
+func (db *DB) openBucketDict() error {
+	d, err := newBucketDict(func() ([]byte, error) {
+		return db.readFile(db.getBucketDictPath())
+	})
+	if err != nil {
+		return err
+	}
+	db.buckets = d
+
+	return nil
+}
+
+// persistBucketDict writes the dictionary of the buckets if IDs were added, before the
+// entries using them are written. It must be called with the db.writeMu lock held.
+func (db *DB) persistBucketDict() error {
+	d := db.buckets
+	d.mu.RLock()
+	dirty, names := d.dirty, d.names
+	d.mu.RUnlock()
+	if !dirty {
+		return nil
+	}
+
+	if err := db.writeFileAtomic(db.getBucketDictPath(), encodeBucketDict(names), db.opt.SyncEnable); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.dirty = len(d.names) != len(names)
+	d.mu.Unlock()
+
+	return nil
+}
+
+// useBucketDict returns if the entries of the frames written store the IDs of their buckets,
+// see Options.BucketDictionary.
+func (db *DB) useBucketDict() bool {
+	return db.opt.BucketDictionary && db.buckets != nil
+}
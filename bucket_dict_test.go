@@ -0,0 +1,241 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const dictBucket = "bucket_with_a_name_longer_than_its_id"
+
+func putDictEntries(t *testing.T, db *DB, n, version int) {
+	for i := 0; i < n; i += 10 {
+		if err := db.Update(func(tx *Tx) error {
+			for j := i; j < i+10 && j < n; j++ {
+				if err := tx.Put(dictBucket, []byte(fmt.Sprintf("key_%d", j)), []byte(fmt.Sprintf("val_%d_%d", j, version)), Persistent); err != nil {
+					return err
+				}
+			}
+			return tx.Put("other", []byte(fmt.Sprintf("key_%d", i)), []byte("val"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkDictEntries(t *testing.T, db *DB, n, version int) {
+	if err := db.View(func(tx *Tx) error {
+		for i := 0; i < n; i++ {
+			e, err := tx.Get(dictBucket, []byte(fmt.Sprintf("key_%d", i)))
+			if err != nil {
+				return fmt.Errorf("key_%d: %w", i, err)
+			}
+			if want := fmt.Sprintf("val_%d_%d", i, version); string(e.Value) != want || string(e.Meta.bucket) != dictBucket {
+				t.Errorf("key_%d: got %s in %s, want %s", i, e.Value, e.Meta.bucket, want)
+			}
+		}
+		_, err := tx.Get("other", []byte("key_0"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dataFilesWithBucket returns the data files storing the name of dictBucket.
+func dataFilesWithBucket(t *testing.T, db *DB) []int64 {
+	var fIDs []int64
+	_, dataFileIDs := db.getMaxFileIDAndFileIDs()
+	for _, fID := range dataFileIDs {
+		b, err := ioutil.ReadFile(db.getDataPath(int64(fID)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b, []byte(dictBucket)) {
+			fIDs = append(fIDs, int64(fID))
+		}
+	}
+
+	return fIDs
+}
+
+func TestBucketDict(t *testing.T) {
+	names := [][]byte{[]byte("a"), []byte(dictBucket), {}}
+	got, err := decodeBucketDict(encodeBucketDict(names))
+	if err != nil || !reflect.DeepEqual(got, names) {
+		t.Fatalf("err decode: got %q, %v", got, err)
+	}
+
+	buf := encodeBucketDict(names)
+	buf[len(buf)-1] ^= 1
+	if _, err := decodeBucketDict(buf); err != ErrCrc {
+		t.Errorf("err decode corrupted: got %v want ErrCrc", err)
+	}
+
+	var persisted []byte
+	d, err := newBucketDict(func() ([]byte, error) {
+		if persisted == nil {
+			return nil, os.ErrNotExist
+		}
+		return persisted, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := d.id([]byte("a")); id != 1 || d.id([]byte("b")) != 2 || d.id([]byte("a")) != 1 {
+		t.Errorf("err ids")
+	}
+	if _, err := d.name(3); !errors.Is(err, ErrBucketIDNotFound) || !errors.Is(err, ErrCorrupted) {
+		t.Errorf("err name of unknown id: %v", err)
+	}
+
+	// the IDs persisted since are reloaded.
+	persisted = encodeBucketDict([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if name, err := d.name(3); err != nil || string(name) != "c" {
+		t.Errorf("err name reloaded: got %q, %v", name, err)
+	}
+}
+
+func TestDB_BucketDictionary(t *testing.T) {
+	for _, format := range []RecordFormat{RecordFormatV2, RecordFormatV3} {
+		for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode, HintBPTSparseIdxMode} {
+			t.Run(fmt.Sprintf("RecordFormat %d EntryIdxMode %d", format, mode), func(t *testing.T) {
+				InitOpt("/tmp/nutsdbtestbucketdict", true)
+				opt.EntryIdxMode = mode
+				opt.SegmentSize = 8 * 1024
+				opt.RecordFormat = format
+				opt.BucketDictionary = true
+
+				db, err := Open(opt)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for version := 0; version < 3; version++ {
+					putDictEntries(t, db, 200, version)
+				}
+				checkDictEntries(t, db, 200, 2)
+				if fIDs := dataFilesWithBucket(t, db); len(fIDs) != 0 {
+					t.Errorf("err data files %v store the bucket name", fIDs)
+				}
+
+				if mode != HintBPTSparseIdxMode {
+					if err := db.Merge(); err != nil {
+						t.Fatal(err)
+					}
+					checkDictEntries(t, db, 200, 2)
+				}
+
+				if err := db.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				db, err = Open(opt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+				checkDictEntries(t, db, 200, 2)
+			})
+		}
+	}
+}
+
+func TestDB_BucketDictionarySize(t *testing.T) {
+	var sizes [2]int64
+	for i, enabled := range []bool{false, true} {
+		InitOpt("/tmp/nutsdbtestbucketdict", true)
+		opt.SegmentSize = 1024 * 1024
+		opt.RecordFormat = RecordFormatV3
+		opt.BucketDictionary = enabled
+
+		db, err := Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putDictEntries(t, db, 100, 0)
+		sizes[i] = db.ActiveFile.writeOff
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every entry of dictBucket stores 4 bytes instead of 37.
+	if sizes[0]-sizes[1] < 100*(int64(len(dictBucket))-bucketIDSize) {
+		t.Errorf("err sizes %v", sizes)
+	}
+}
+
+func TestDB_BucketDictionaryMerge(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbucketdict", true)
+	opt.SegmentSize = 8 * 1024
+	opt.RecordFormat = RecordFormatV2
+
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putDictEntries(t, db, 200, 0)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries written before are read as they are, and rewritten with the IDs by Merge.
+	opt.BucketDictionary = true
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDictEntries(t, db, 200, 0)
+	putDictEntries(t, db, 300, 1)
+	if len(dataFilesWithBucket(t, db)) < 2 {
+		t.Fatal("err no data files to merge")
+	}
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if fIDs := dataFilesWithBucket(t, db); len(fIDs) != 0 {
+		t.Errorf("err data files %v store the bucket name after Merge", fIDs)
+	}
+	checkDictEntries(t, db, 300, 1)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDictEntries(t, db, 300, 1)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries cannot be read without the dictionary.
+	if err := os.Remove(opt.Dir + "/" + BucketDictFileName); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(opt); !errors.Is(err, ErrBucketIDNotFound) {
+		t.Errorf("err Open without the dictionary: got %v", err)
+		if err == nil {
+			db.Close()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+)
+
+// MaxBucketNameSize is the max size in bytes of a bucket name.
+const MaxBucketNameSize = math.MaxUint16
+
+// SparseIndexFormatFileName is the name of the file in the bpt dir recording the format of the keys of the sparse index.
+const SparseIndexFormatFileName = "format"
+
+// sparseIndexFormat is the format of the keys of the sparse index, the length-prefixed bucket followed by the key.
+// The index files without format file were written with the bucket and the key concatenated.
+const sparseIndexFormat = 2
+
+var (
+	// ErrInvalidBucketName is returned when writing to a bucket whose name is empty or longer than MaxBucketNameSize.
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+
+	// ErrSparseIndexFormat is returned when opening a read-only database whose sparse index
+	// was written in an older format, which only a writable Open migrates.
+	ErrSparseIndexFormat = errors.New("sparse index written in an older format, open the db writable to migrate it")
+)
+
+// checkBucketName returns ErrInvalidBucketName if the bucket name is empty or too long.
+func checkBucketName(bucket string) error {
+	if len(bucket) == 0 || len(bucket) > MaxBucketNameSize {
+		return ErrInvalidBucketName
+	}
+
+	return nil
+}
+
+// getNewKey returns the key of the sparse index of the key in the bucket. The bucket is
+// length-prefixed, so that the keys of distinct buckets never collide, whatever their bytes.
+//
+//	|------------------------------|
+//	| bucketSize | bucket |   key  |
+//	|------------------------------|
+//	|   uint16   | []byte | []byte |
+//	|------------------------------|
+func getNewKey(bucket string, key []byte) []byte {
+	newKey := make([]byte, 2+len(bucket)+len(key))
+	binary.BigEndian.PutUint16(newKey[0:2], uint16(len(bucket)))
+	copy(newKey[2:], bucket)
+	copy(newKey[2+len(bucket):], key)
+
+	return newKey
+}
+
+// getSparseIndexFormatPath returns the path of the format file of the sparse index.
+func (db *DB) getSparseIndexFormatPath() string {
+	return db.getBPTDir() + "/" + SparseIndexFormatFileName
+}
+
+// migrateSparseIndex rewrites the index files of the sealed data files if they were
+// written in an older format, then records the current format.
+func (db *DB) migrateSparseIndex() error {
+	buf, err := db.readFile(db.getSparseIndexFormatPath())
+	if err == nil {
+		if format, err := strconv.Atoi(string(buf)); err != nil || format != sparseIndexFormat {
+			return ErrCorrupted
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	if db.opt.ReadOnly || db.fsys != nil {
+		// without sealed data file, no index file was written yet.
+		if _, dataFileIds := db.getMaxFileIDAndFileIDs(); len(dataFileIds) < 2 {
+			return nil
+		}
+		return ErrSparseIndexFormat
+	}
+
+	return db.rebuildSparseIndex()
+}
+
+// persistSparseIndexFormat writes the format file of the sparse index.
+func (db *DB) persistSparseIndexFormat() error {
+	return db.writeFileAtomic(db.getSparseIndexFormatPath(), []byte(strconv.Itoa(sparseIndexFormat)), db.opt.SyncEnable)
+}
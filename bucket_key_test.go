@@ -0,0 +1,125 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGetNewKey(t *testing.T) {
+	if string(getNewKey("a", []byte("bc"))) == string(getNewKey("ab", []byte("c"))) {
+		t.Error("err getNewKey. the keys of distinct buckets collide")
+	}
+
+	if got := getNewKey("ab", []byte("c")); string(got) != "\x00\x02abc" {
+		t.Errorf("err getNewKey. got %q", got)
+	}
+}
+
+func TestTx_PutInvalidBucketName(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbucketkey", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, bucket := range []string{"", strings.Repeat("b", MaxBucketNameSize+1)} {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("key"), []byte("val"), Persistent)
+		}); err != ErrInvalidBucketName {
+			t.Errorf("err Put in a bucket named with %d bytes. got %v want ErrInvalidBucketName", len(bucket), err)
+		}
+	}
+}
+
+func TestDB_SparseIndexBucketKeys(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbucketkey", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys of the buckets concatenate to the same bytes.
+	put := func(i int) {
+		if err := db.Update(func(tx *Tx) error {
+			if err := tx.Put("a", []byte(fmt.Sprintf("b_%03d", i)), []byte("a"), Persistent); err != nil {
+				return err
+			}
+			return tx.Put("ab", []byte(fmt.Sprintf("_%03d", i)), []byte("ab"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		put(i)
+	}
+
+	check := func() {
+		if err := db.View(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				e, err := tx.Get("a", []byte(fmt.Sprintf("b_%03d", i)))
+				if err != nil {
+					return err
+				}
+				if string(e.Value) != "a" {
+					t.Errorf("err Get b_%03d in bucket a. got %s", i, e.Value)
+				}
+
+				e, err = tx.Get("ab", []byte(fmt.Sprintf("_%03d", i)))
+				if err != nil {
+					return err
+				}
+				if string(e.Value) != "ab" {
+					t.Errorf("err Get _%03d in bucket ab. got %s", i, e.Value)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index files without format file are rewritten.
+	if err := os.Remove(opt.Dir + "/" + bptDir + "/" + SparseIndexFormatFileName); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnlyOpt := opt
+	readOnlyOpt.ReadOnly = true
+	if _, err := Open(readOnlyOpt); err != ErrSparseIndexFormat {
+		t.Errorf("err Open read-only without format file. got %v want ErrSparseIndexFormat", err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := os.Stat(opt.Dir + "/" + bptDir + "/" + SparseIndexFormatFileName); err != nil {
+		t.Errorf("err format file not written by the migration: %v", err)
+	}
+	check()
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sort"
+	"sync"
+)
+
+// bucketLocks represents the locks of the indexes of the buckets. A commit only updating
+// the indexes of existing buckets write-locks them while holding db.mu for reading, so the
+// View transactions only wait for the commits of the buckets they read, see Tx.lockIndexes.
+type bucketLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.RWMutex
+}
+
+// heldBucketLock represents the read lock of a bucket held by a View transaction.
+type heldBucketLock struct {
+	bucket string
+	mu     *sync.RWMutex
+}
+
+// get returns the lock of the bucket.
+func (l *bucketLocks) get(bucket string) *sync.RWMutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	mu, ok := l.locks[bucket]
+	if !ok {
+		if l.locks == nil {
+			l.locks = make(map[string]*sync.RWMutex)
+		}
+		mu = &sync.RWMutex{}
+		l.locks[bucket] = mu
+	}
+
+	return mu
+}
+
+// lockAll write-locks the locks of the buckets. It never waits for a lock while holding
+// another one, as the View transactions read-lock their buckets in any order.
+func (l *bucketLocks) lockAll(buckets []string) []*sync.RWMutex {
+	locks := make([]*sync.RWMutex, len(buckets))
+	for i, bucket := range buckets {
+		locks[i] = l.get(bucket)
+	}
+
+	for wait := 0; ; {
+		locks[wait].Lock()
+
+		failed := -1
+		for i, mu := range locks {
+			if i != wait && !mu.TryLock() {
+				failed = i
+				break
+			}
+		}
+		if failed < 0 {
+			return locks
+		}
+
+		for i, mu := range locks[:failed] {
+			if i != wait {
+				mu.Unlock()
+			}
+		}
+		locks[wait].Unlock()
+		wait = failed
+	}
+}
+
+// rlockAllBuckets read-locks the locks of the buckets of the indexes, e.g. for reading all of
+// them outside of a transaction, and returns the function unlocking them. The caller
+// holds db.mu for reading.
+func (db *DB) rlockAllBuckets() (unlock func()) {
+	buckets := make(map[string]struct{})
+	for bucket := range db.BPTreeIdx {
+		buckets[bucket] = struct{}{}
+	}
+	for bucket := range db.SetIdx {
+		buckets[bucket] = struct{}{}
+	}
+	for bucket := range db.SortedSetIdx {
+		buckets[bucket] = struct{}{}
+	}
+	for bucket := range db.ListIdx {
+		buckets[bucket] = struct{}{}
+	}
+
+	return db.rlockBuckets(buckets)
+}
+
+// rlockEntryBuckets read-locks the locks of the buckets whose indexes are read when
+// merging the entry, and returns the function unlocking them. The caller holds db.mu
+// for reading.
+func (db *DB) rlockEntryBuckets(entry *Entry) (unlock func()) {
+	buckets := map[string]struct{}{string(entry.Meta.bucket): {}}
+	if dst, ok := lMoveDstBucket(entry); ok {
+		buckets[dst] = struct{}{}
+	}
+
+	return db.rlockBuckets(buckets)
+}
+
+// rlockBuckets read-locks the locks of the buckets and returns the function unlocking them.
+func (db *DB) rlockBuckets(buckets map[string]struct{}) (unlock func()) {
+	locks := make([]*sync.RWMutex, 0, len(buckets))
+	for bucket := range buckets {
+		mu := db.bucketLocks.get(bucket)
+		mu.RLock()
+		locks = append(locks, mu)
+	}
+
+	return func() {
+		for _, mu := range locks {
+			mu.RUnlock()
+		}
+	}
+}
+
+// lMoveDstBucket returns the destination bucket of the LMove entry, if it is one.
+func lMoveDstBucket(entry *Entry) (string, bool) {
+	if entry.Meta.ds != DataStructureList || entry.Meta.Flag != DataLMoveFlag {
+		return "", false
+	}
+
+	m, err := decodeLMove(entry.Value)
+	if err != nil {
+		return "", false
+	}
+
+	return m.dstBucket, true
+}
+
+// lockBucket read-locks the index of the bucket until the View transaction ends, so that
+// the commits of the bucket wait for it. The writable transactions take no lock, the
+// indexes only being updated by their commits.
+func (tx *Tx) lockBucket(bucket string) {
+	if tx.writable || tx.db == nil {
+		return
+	}
+
+	tx.bucketLocksMu.Lock()
+	defer tx.bucketLocksMu.Unlock()
+
+	for _, held := range tx.bucketLocks {
+		if held.bucket == bucket {
+			return
+		}
+	}
+
+	mu := tx.db.bucketLocks.get(bucket)
+	mu.RLock()
+
+	if tx.bucketLocks == nil {
+		// most transactions read a single bucket, held without allocating.
+		tx.bucketLocks = tx.bucketLockBuf[:0]
+	}
+	tx.bucketLocks = append(tx.bucketLocks, heldBucketLock{bucket: bucket, mu: mu})
+}
+
+// unlockBuckets releases the locks of lockBucket.
+func (tx *Tx) unlockBuckets() {
+	tx.bucketLocksMu.Lock()
+	defer tx.bucketLocksMu.Unlock()
+
+	for _, held := range tx.bucketLocks {
+		held.mu.RUnlock()
+	}
+	tx.bucketLocks = nil
+}
+
+// bptreeIdx returns the b+ tree index of the bucket, see lockBucket and DB.bptreeIdx.
+func (tx *Tx) bptreeIdx(bucket string) (*BPTree, bool) {
+	tx.lockBucket(bucket)
+
+	return tx.db.bptreeIdx(bucket)
+}
+
+// lockIndexes locks the indexes updated by the commit of the first writesLen pending
+// writes and returns the function unlocking them. If they all exist, only the indexes
+// of their buckets are write-locked, with db.mu held for reading. Otherwise, as well as
+// when the commit may update the indexes of other buckets, evicting their values for
+// MaxIndexMemory or applying the repairs of ParanoidChecks, db.mu is write-locked.
+func (tx *Tx) lockIndexes(writesLen int) (unlock func()) {
+	db := tx.db
+
+	db.mu.RLock()
+
+	buckets, ok := tx.indexedBuckets(writesLen)
+	if !ok || db.indexMemory != nil || db.opt.ParanoidChecks {
+		db.mu.RUnlock()
+		db.mu.Lock()
+		return db.mu.Unlock
+	}
+
+	locks := db.bucketLocks.lockAll(buckets)
+
+	return func() {
+		for _, mu := range locks {
+			mu.Unlock()
+		}
+		db.mu.RUnlock()
+	}
+}
+
+// indexedBuckets returns the buckets of the first writesLen pending writes, sorted, and
+// if the indexes of all of them exist. The caller holds db.mu.
+func (tx *Tx) indexedBuckets(writesLen int) ([]string, bool) {
+	db := tx.db
+
+	seen := make(map[string]struct{})
+	add := func(bucket string, ds uint16) bool {
+		var ok bool
+		switch ds {
+		case DataStructureBPTree:
+			_, ok = db.BPTreeIdx[bucket]
+		case DataStructureSet:
+			_, ok = db.SetIdx[bucket]
+		case DataStructureSortedSet:
+			_, ok = db.SortedSetIdx[bucket]
+		case DataStructureList:
+			_, ok = db.ListIdx[bucket]
+		}
+		seen[bucket] = struct{}{}
+		return ok
+	}
+
+	for _, e := range tx.pendingWrites[:writesLen] {
+		if !add(string(e.Meta.bucket), e.Meta.ds) {
+			return nil, false
+		}
+		if dst, ok := lMoveDstBucket(e); ok && !add(dst, DataStructureList) {
+			return nil, false
+		}
+	}
+
+	buckets := make([]string, 0, len(seen))
+	for bucket := range seen {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	return buckets, true
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTx_BucketLocks(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbucketlocks", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	put := func(buckets ...string) error {
+		return db.Update(func(tx *Tx) error {
+			for _, bucket := range buckets {
+				if err := tx.Put(bucket, []byte("key"), []byte("val"), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := put("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// view reads the buckets in order, then waits for release.
+	view := func(read chan<- struct{}, release <-chan struct{}, buckets ...string) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- db.View(func(tx *Tx) error {
+				for _, bucket := range buckets {
+					if _, err := tx.Get(bucket, []byte("key")); err != nil {
+						return err
+					}
+					read <- struct{}{}
+				}
+				<-release
+				return nil
+			})
+		}()
+		return done
+	}
+	wait := func(done <-chan error, want bool) {
+		t.Helper()
+		select {
+		case err := <-done:
+			if !want {
+				t.Fatal("err done while the bucket is read")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Fatal("err not done")
+			}
+		}
+	}
+	async := func(fn func() error) <-chan error {
+		done := make(chan error, 1)
+		go func() { done <- fn() }()
+		return done
+	}
+
+	// the commits of the other buckets do not wait for the View.
+	read, release := make(chan struct{}, 2), make(chan struct{})
+	viewDone := view(read, release, "a")
+	<-read
+	wait(async(func() error { return put("b") }), true)
+
+	// the commits of the buckets read wait for it.
+	commitDone := async(func() error { return put("a") })
+	wait(commitDone, false)
+	close(release)
+	wait(viewDone, true)
+	wait(commitDone, true)
+
+	// a View reading the buckets in another order than a commit does not deadlock.
+	read, release = make(chan struct{}, 2), make(chan struct{})
+	viewDone = view(read, release, "b", "a")
+	<-read
+	commitDone = async(func() error { return put("a", "b") })
+	<-read
+	close(release)
+	wait(viewDone, true)
+	wait(commitDone, true)
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync"
+)
+
+// errFlightPanicked is returned to the callers waiting for a load which panicked.
+var errFlightPanicked = errors.New("the load of the key panicked")
+
+// BucketOptions represents the hooks of a bucket, see Options.BucketOptions.
+type BucketOptions struct {
+	// OnMissing represents the function loading the value of a key not in the bucket
+	// for GetThrough, e.g. from a slower source the bucket is a read-through cache of.
+	// The value is stored with the returned TTL before being returned. An error is
+	// returned by GetThrough, storing nothing.
+	OnMissing func(key []byte) (value []byte, ttl uint32, err error)
+
+	// OnWrite represents the function called by Commit for every key put in the bucket,
+	// before the entries are written, e.g. for writing them through to the source. An
+	// error fails the commit, which writes nothing. The deletions are not passed, as
+	// deleting a cached key only evicts it, and neither are the values stored by
+	// GetThrough, the deltas and the rewrites of the merges.
+	OnWrite func(key, value []byte, ttl uint32) error
+}
+
+// bucketOptions returns the hooks of the bucket.
+func (db *DB) bucketOptions(bucket string) BucketOptions {
+	if db.opt.BucketOptions == nil {
+		return BucketOptions{}
+	}
+
+	return db.opt.BucketOptions(bucket)
+}
+
+// GetThrough returns the value of the key in the bucket, loading it by the OnMissing hook
+// of the bucket if it is not there, see Options.BucketOptions. The concurrent misses of
+// a key call OnMissing once, every caller getting its value or error.
+func (db *DB) GetThrough(bucket string, key []byte) ([]byte, error) {
+	value, err := db.getValue(bucket, key)
+	if !isMissing(err) {
+		return value, err
+	}
+
+	onMissing := db.bucketOptions(bucket).OnMissing
+	if onMissing == nil {
+		return nil, err
+	}
+
+	return db.loadMissing(bucket, key, onMissing)
+}
+
+// loadMissing returns the value of the key in the bucket, storing the value returned by
+// load with its TTL if the key is not there. The concurrent calls for a key call load
+// once, every caller getting a copy of its value or its error. The value stored is not
+// passed to OnWrite.
+func (db *DB) loadMissing(bucket string, key []byte, load func(key []byte) ([]byte, uint32, error)) ([]byte, error) {
+	value, err := db.flights.do(string(getNewKey(bucket, key)), func() ([]byte, error) {
+		// the key may have been stored by a load finished since the miss.
+		value, err := db.getValue(bucket, key)
+		if !isMissing(err) {
+			return value, err
+		}
+
+		value, ttl, err := load(key)
+		if err != nil {
+			return nil, err
+		}
+
+		err = db.Update(func(tx *Tx) error {
+			tx.readThrough = true
+			return tx.Put(bucket, key, value, ttl)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the value is shared by the callers.
+	return append([]byte(nil), value...), nil
+}
+
+// getValue returns a copy of the value of the key in the bucket.
+func (db *DB) getValue(bucket string, key []byte) (value []byte, err error) {
+	err = db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, key)
+		if err != nil {
+			return err
+		}
+		value = append([]byte(nil), e.Value...)
+
+		return nil
+	})
+
+	return value, err
+}
+
+// isMissing returns if err reports a key or a bucket not found.
+func isMissing(err error) bool {
+	return errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrBucketNotFound)
+}
+
+// writeThrough calls the OnWrite hooks of the buckets for the keys put by the tx.
+func (tx *Tx) writeThrough() error {
+	if tx.db.opt.BucketOptions == nil || tx.merge || tx.readThrough {
+		return nil
+	}
+
+	hooks := make(map[string]func(key, value []byte, ttl uint32) error)
+	for _, e := range tx.pendingWrites {
+		if e.Meta.ds != DataStructureBPTree || e.Meta.Flag != DataSetFlag {
+			continue
+		}
+
+		bucket := string(e.Meta.bucket)
+		onWrite, ok := hooks[bucket]
+		if !ok {
+			onWrite = tx.db.bucketOptions(bucket).OnWrite
+			hooks[bucket] = onWrite
+		}
+		if onWrite == nil {
+			continue
+		}
+
+		if err := onWrite(e.Key, e.Value, e.Meta.TTL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flightGroup deduplicates the concurrent loads of the same key.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a load in progress, its value and error set once done is closed.
+type flight struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// do calls load unless a load of the key is in progress, and returns the value and the
+// error of the load of the key. The value is shared by the callers.
+func (g *flightGroup) do(key string, load func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return f.value, f.err
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{}), err: errFlightPanicked}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	f.value, f.err = load()
+
+	return f.value, f.err
+}
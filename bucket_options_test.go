@@ -0,0 +1,163 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDB_GetThrough(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestgetthrough", true)
+
+	var loads int32
+	release := make(chan struct{})
+	errSource := errors.New("source down")
+	opt.BucketOptions = func(bucket string) BucketOptions {
+		if bucket != "cache" {
+			return BucketOptions{}
+		}
+		return BucketOptions{
+			OnMissing: func(key []byte) ([]byte, uint32, error) {
+				atomic.AddInt32(&loads, 1)
+				if string(key) == "down" {
+					return nil, 0, errSource
+				}
+				<-release
+				return append([]byte("loaded:"), key...), 0, nil
+			},
+		}
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	values := make([][]byte, 8)
+	errs := make([]error, len(values))
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = db.GetThrough("cache", []byte("key"))
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range values {
+		if errs[i] != nil || string(values[i]) != "loaded:key" {
+			t.Errorf("TestDB_GetThrough err: got %q, %v", values[i], errs[i])
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("TestDB_GetThrough err: %d loads for concurrent misses, want 1", n)
+	}
+
+	// the loaded value is stored.
+	if value, err := db.GetThrough("cache", []byte("key")); err != nil || string(value) != "loaded:key" {
+		t.Errorf("TestDB_GetThrough err: got %q, %v", value, err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("TestDB_GetThrough err: %d loads, want 1", n)
+	}
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get("cache", []byte("key"))
+		return err
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := db.GetThrough("cache", []byte("down")); err != errSource {
+		t.Errorf("expected errSource, got %v", err)
+	}
+	if _, err := db.GetThrough("other", []byte("key")); !errors.Is(err, ErrBucketNotFound) && !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("TestDB_GetThrough err: expected a missing key without hook, got %v", err)
+	}
+}
+
+func TestTx_OnWrite(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestonwrite", true)
+
+	source := make(map[string]string)
+	errReject := errors.New("rejected")
+	opt.BucketOptions = func(bucket string) BucketOptions {
+		if bucket != "cache" {
+			return BucketOptions{}
+		}
+		return BucketOptions{
+			OnMissing: func(key []byte) ([]byte, uint32, error) {
+				return []byte("loaded"), 0, nil
+			},
+			OnWrite: func(key, value []byte, ttl uint32) error {
+				if string(key) == "reject" {
+					return errReject
+				}
+				source[string(key)] = string(value)
+				return nil
+			},
+		}
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.Put("cache", []byte("key1"), []byte("val1"), 0); err != nil {
+			return err
+		}
+		if err := tx.Put("other", []byte("key2"), []byte("val2"), 0); err != nil {
+			return err
+		}
+		return tx.Delete("cache", []byte("key3"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(source) != 1 || source["key1"] != "val1" {
+		t.Errorf("TestTx_OnWrite err: source %v", source)
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.Put("cache", []byte("key4"), []byte("val4"), 0); err != nil {
+			return err
+		}
+		return tx.Put("cache", []byte("reject"), []byte("val"), 0)
+	})
+	if err != errReject {
+		t.Errorf("expected errReject, got %v", err)
+	}
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get("cache", []byte("key4"))
+		return err
+	}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("TestTx_OnWrite err: key of a failed commit written, %v", err)
+	}
+
+	// the values loaded by OnMissing are not written through.
+	if _, err := db.GetThrough("cache", []byte("key5")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := source["key5"]; ok {
+		t.Error("TestTx_OnWrite err: loaded value written through")
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrBucketNotInDB is returned when writing a bucket to the DB of another bucket of a
+	// BucketDB, whose data files only hold the entries of their bucket.
+	ErrBucketNotInDB = errors.New("bucket not stored in the db")
+
+	// ErrNotSupportBucketDB is returned by OpenBucketDB when the options enable
+	// AutoBackup or CDC, which the DBs of the buckets would run each on their own.
+	ErrNotSupportBucketDB = errors.New("option not supported by a BucketDB")
+)
+
+// internalBucketPrefix is the prefix of the buckets written by the database itself,
+// e.g. IdempotencyBucket, which the DB of every bucket of a BucketDB may write.
+const internalBucketPrefix = "__nutsdb_"
+
+// BucketDB represents a database storing every bucket in its own series of data files,
+// a DB in the sub dir of Dir named after the bucket, e.g. Dir/users/0.dat. Removing
+// or archiving a bucket is a dir removal or rename, and Merge merges the buckets in
+// parallel. A transaction only writes the bucket of its DB: the writes of several
+// buckets are not atomic.
+type BucketDB struct {
+	opt     Options
+	storage Storage
+	mu      sync.Mutex
+	dbs     map[string]*DB // the DBs of the buckets opened so far
+	closed  bool
+}
+
+// OpenBucketDB returns a newly initialized BucketDB storing its buckets in opt.Dir.
+// The DB of a bucket is opened on its first access with the options, Dir being the
+// sub dir of the bucket.
+func OpenBucketDB(opt Options) (*BucketDB, error) {
+	if opt.AutoBackup.Interval > 0 || opt.CDC.Sink != nil {
+		return nil, ErrNotSupportBucketDB
+	}
+
+	b := &BucketDB{
+		opt:     opt,
+		storage: opt.Storage,
+		dbs:     make(map[string]*DB),
+	}
+	if b.storage == nil {
+		b.storage = osStorage{}
+	}
+
+	if !opt.ReadOnly {
+		perm := opt.DirPerm
+		if perm == 0 {
+			perm = DefaultDirPerm
+		}
+		if err := b.storage.MkdirAll(opt.Dir, perm); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// Bucket returns the DB of the bucket, creating its dir unless ReadOnly is set, e.g.
+// for running Merge or Backup on the bucket alone. It is closed by Close.
+func (b *BucketDB) Bucket(bucket string) (*DB, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.db(bucket, !b.opt.ReadOnly)
+}
+
+// Update executes a function within a managed read/write transaction of the DB of the bucket.
+func (b *BucketDB) Update(bucket string, fn func(tx *Tx) error) error {
+	db, err := b.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(fn)
+}
+
+// View executes a function within a managed read-only transaction of the DB of the bucket.
+// It returns ErrBucketNotFound if the bucket has no dir.
+func (b *BucketDB) View(bucket string, fn func(tx *Tx) error) error {
+	b.mu.Lock()
+	db, err := b.db(bucket, false)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return db.View(fn)
+}
+
+// Buckets returns the buckets stored, sorted by name.
+func (b *BucketDB) Buckets() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrDBClosed
+	}
+
+	files, err := b.storage.ReadDir(b.opt.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []string
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if bucket, err := url.PathUnescape(f.Name()); err == nil {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Strings(buckets)
+
+	return buckets, nil
+}
+
+// DropBucket removes the bucket, closing its DB and removing its dir.
+// It waits for the transactions of the bucket to be done.
+func (b *BucketDB) DropBucket(bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.closeBucket(bucket); err != nil {
+		return err
+	}
+
+	return removeStorageAll(b.storage, b.getBucketDir(bucket))
+}
+
+// ArchiveBucket moves the dir of the bucket to dir, closing its DB, e.g. for keeping
+// the bucket out of the database. dir must be on the file system of the database
+// and not exist. The dir can be opened by Open, or moved back into a BucketDB.
+func (b *BucketDB) ArchiveBucket(bucket, dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.closeBucket(bucket); err != nil {
+		return err
+	}
+
+	bucketDir := b.getBucketDir(bucket)
+	if _, err := b.storage.Stat(bucketDir); os.IsNotExist(err) {
+		return ErrBucketNotFound
+	}
+
+	return b.storage.Rename(bucketDir, dir)
+}
+
+// Merge merges the data files of every bucket, see DB.Merge, the buckets in parallel,
+// at most GOMAXPROCS at a time. The buckets with fewer than 2 files are skipped.
+func (b *BucketDB) Merge() error {
+	buckets, err := b.Buckets()
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, runtime.GOMAXPROCS(0))
+	)
+
+	setErr := func(err error) {
+		errMu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errMu.Unlock()
+	}
+
+	for _, bucket := range buckets {
+		db, err := b.Bucket(bucket)
+		if err != nil {
+			setErr(err)
+			break
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(db *DB) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := db.Merge(); err != nil && err != ErrMergeFileCount {
+				setErr(err)
+			}
+		}(db)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// Close closes the DBs of the buckets, returning the first error.
+func (b *BucketDB) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrDBClosed
+	}
+	b.closed = true
+
+	var firstErr error
+	for bucket, db := range b.dbs {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(b.dbs, bucket)
+	}
+
+	return firstErr
+}
+
+// db returns the DB of the bucket, opening it, and creating its dir if create is set.
+// The caller holds b.mu.
+func (b *BucketDB) db(bucket string, create bool) (*DB, error) {
+	if b.closed {
+		return nil, ErrDBClosed
+	}
+
+	if err := checkBucketName(bucket); err != nil {
+		return nil, err
+	}
+
+	if db, ok := b.dbs[bucket]; ok {
+		return db, nil
+	}
+
+	opt := b.opt
+	opt.Dir = b.getBucketDir(bucket)
+	if _, err := b.storage.Stat(opt.Dir); os.IsNotExist(err) && !create {
+		return nil, ErrBucketNotFound
+	}
+
+	db, err := Open(opt)
+	if err != nil {
+		return nil, err
+	}
+	db.bucket = bucket
+	b.dbs[bucket] = db
+
+	return db, nil
+}
+
+// closeBucket closes the DB of the bucket, if open. The caller holds b.mu.
+func (b *BucketDB) closeBucket(bucket string) error {
+	if b.closed {
+		return ErrDBClosed
+	}
+
+	if err := checkBucketName(bucket); err != nil {
+		return err
+	}
+
+	db, ok := b.dbs[bucket]
+	if !ok {
+		return nil
+	}
+	delete(b.dbs, bucket)
+
+	return db.Close()
+}
+
+// getBucketDir returns the dir of the data files of the bucket, its name escaped,
+// a leading dot included, so that every bucket maps to a sub dir of Dir.
+func (b *BucketDB) getBucketDir(bucket string) string {
+	name := url.PathEscape(bucket)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+
+	return b.opt.Dir + "/" + name
+}
+
+// checkBucketInDB returns ErrBucketNotInDB if the db is the DB of another bucket of a
+// BucketDB, unless the bucket is written by the database itself.
+func (db *DB) checkBucketInDB(bucket string) error {
+	if db.bucket == "" || bucket == db.bucket || strings.HasPrefix(bucket, internalBucketPrefix) {
+		return nil
+	}
+
+	return ErrBucketNotInDB
+}
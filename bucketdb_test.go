@@ -0,0 +1,151 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBucketDB(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbucketdb", true)
+	opt.SegmentSize = 1024
+	archive := "/tmp/nutsdbtestbucketdb_archive"
+	os.RemoveAll(archive)
+	defer os.RemoveAll(archive)
+
+	b, err := OpenBucketDB(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buckets := []string{"users", "a/b", ".."}
+	for _, bucket := range buckets {
+		for version := 0; version < 3; version++ {
+			for i := 0; i < 10; i++ {
+				if err := b.Update(bucket, func(tx *Tx) error {
+					return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("%s_%d_%060d", bucket, i, version)), Persistent)
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	for dir, bucket := range map[string]string{"users": "users", "a%2Fb": "a/b", "%2E.": ".."} {
+		if _, err := os.Stat(opt.Dir + "/" + dir + "/0.dat"); err != nil {
+			t.Errorf("bucket %s: %v", bucket, err)
+		}
+	}
+
+	if err := b.Update("users", func(tx *Tx) error {
+		return tx.Put("orders", []byte("key"), []byte("value"), Persistent)
+	}); err != ErrBucketNotInDB {
+		t.Errorf("err Put to another bucket. got %v want %v", err, ErrBucketNotInDB)
+	}
+
+	if err := b.View("missing", func(tx *Tx) error { return nil }); err != ErrBucketNotFound {
+		t.Errorf("err View of a missing bucket. got %v want %v", err, ErrBucketNotFound)
+	}
+
+	if err := b.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(bucket string) {
+		if err := b.View(bucket, func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%d", i)))
+				if err != nil {
+					return err
+				}
+				if want := fmt.Sprintf("%s_%d_%060d", bucket, i, 2); string(e.Value) != want {
+					t.Errorf("bucket %s key_%d: got %s, want %s", bucket, i, e.Value, want)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("bucket %s: %v", bucket, err)
+		}
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = OpenBucketDB(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	got, err := b.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"..", "a/b", "users"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("err Buckets. got %v want %v", got, want)
+	}
+	for _, bucket := range buckets {
+		check(bucket)
+	}
+
+	if err := b.DropBucket("a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(opt.Dir + "/a%2Fb"); !os.IsNotExist(err) {
+		t.Errorf("expected the dir of the dropped bucket to be removed, got %v", err)
+	}
+
+	if err := b.ArchiveBucket("users", archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ArchiveBucket("users", archive); err != ErrBucketNotFound {
+		t.Errorf("err ArchiveBucket of a missing bucket. got %v want %v", err, ErrBucketNotFound)
+	}
+
+	got, err = b.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("err Buckets. got %v want %v", got, want)
+	}
+
+	archiveOpt := opt
+	archiveOpt.Dir = archive
+	archived, err := Open(archiveOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archived.Close()
+	if err := archived.View(func(tx *Tx) error {
+		_, err := tx.Get("users", []byte("key_0"))
+		return err
+	}); err != nil {
+		t.Errorf("err Get from the archived bucket: %v", err)
+	}
+}
+
+func TestOpenBucketDB_NotSupport(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbucketdb", true)
+	opt.CDC.Sink = &memorySink{}
+
+	if _, err := OpenBucketDB(opt); err != ErrNotSupportBucketDB {
+		t.Errorf("err OpenBucketDB with CDC. got %v want %v", err, ErrNotSupportBucketDB)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache provides a persistent TTL-aware LRU cache on top of a nutsdb bucket.
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// DefaultBucket is the bucket used when Options.Bucket is empty.
+const DefaultBucket = "cache"
+
+// Options records params for creating Cache object.
+type Options struct {
+	// Bucket represents the bucket that stores the cache entries.
+	Bucket string
+
+	// MaxEntries is the maximum number of entries before an entry is evicted.
+	// Zero means no limit.
+	MaxEntries int
+
+	// MaxBytes is the maximum total size of keys and values before an entry is evicted.
+	// Zero means no limit.
+	MaxBytes int64
+}
+
+// Cache is a LRU cache persisted in a nutsdb bucket. It is safe for concurrent access.
+type Cache struct {
+	db    *nutsdb.DB
+	opt   Options
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+}
+
+type item struct {
+	key  string
+	size int64
+}
+
+// New returns a newly initialized Cache, loading the entries already stored in the bucket.
+// Recency is not persisted, so reloaded entries start in key order.
+func New(db *nutsdb.DB, opt Options) (*Cache, error) {
+	if opt.Bucket == "" {
+		opt.Bucket = DefaultBucket
+	}
+
+	c := &Cache{
+		db:    db,
+		opt:   opt,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+
+	err := db.View(func(tx *nutsdb.Tx) error {
+		entries, err := tx.GetAll(opt.Bucket)
+		if err != nil {
+			if err == nutsdb.ErrBucketEmpty {
+				return nil
+			}
+			return err
+		}
+
+		for _, e := range entries {
+			c.add(string(e.Key), int64(len(e.Key)+len(e.Value)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.evict(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get returns a copy of the value stored at key, and whether it was found.
+func (c *Cache) Get(key []byte) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var value []byte
+	err := c.db.View(func(tx *nutsdb.Tx) error {
+		e, err := tx.Get(c.opt.Bucket, key)
+		if err != nil {
+			return err
+		}
+		value = append([]byte(nil), e.Value...)
+		return nil
+	})
+	if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrBucketNotFound) {
+		// expired or deleted behind our back.
+		c.remove(string(key))
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if el, ok := c.items[string(key)]; ok {
+		c.ll.MoveToFront(el)
+	}
+
+	return value, true, nil
+}
+
+// Set stores value at key. A ttl of zero means the entry never expires, and
+// the others are rounded up to whole seconds.
+func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(c.opt.Bucket, key, value, uint32((ttl+time.Second-1)/time.Second))
+	}); err != nil {
+		return err
+	}
+
+	c.remove(string(key))
+	c.add(string(key), int64(len(key)+len(value)))
+
+	return c.evict()
+}
+
+// Delete removes the value stored at key.
+func (c *Cache) Delete(key []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.items[string(key)]; !ok {
+		return nil
+	}
+
+	if err := c.db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Delete(c.opt.Bucket, key)
+	}); err != nil {
+		return err
+	}
+
+	c.remove(string(key))
+
+	return nil
+}
+
+// Len returns the number of entries in the cache, which may include expired entries not yet evicted.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// Bytes returns the total size of the keys and values in the cache.
+func (c *Cache) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+func (c *Cache) add(key string, size int64) {
+	c.items[key] = c.ll.PushFront(&item{key: key, size: size})
+	c.bytes += size
+}
+
+func (c *Cache) remove(key string) {
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		c.bytes -= el.Value.(*item).size
+	}
+}
+
+// evict removes the least recently used entries until the cache fits its limits.
+func (c *Cache) evict() error {
+	var keys [][]byte
+
+	for c.ll.Len() > 0 && (c.opt.MaxEntries > 0 && c.ll.Len() > c.opt.MaxEntries ||
+		c.opt.MaxBytes > 0 && c.bytes > c.opt.MaxBytes) {
+		it := c.ll.Back().Value.(*item)
+		keys = append(keys, []byte(it.key))
+		c.remove(it.key)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return c.db.Update(func(tx *nutsdb.Tx) error {
+		for _, key := range keys {
+			if err := tx.Delete(c.opt.Bucket, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+func openDB(t *testing.T, clean bool) *nutsdb.DB {
+	fileDir := "/tmp/nutsdbtestcache"
+	if clean {
+		if err := os.RemoveAll(fileDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = fileDir
+	opt.SegmentSize = 8 * 1024
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestCache_MaxEntries(t *testing.T) {
+	db := openDB(t, true)
+
+	c, err := New(db, Options{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "b"} {
+		if err := c.Set([]byte(k), []byte("val_"+k), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// touch a so b becomes the least recently used
+	if v, ok, err := c.Get([]byte("a")); err != nil || !ok || string(v) != "val_a" {
+		t.Errorf("err Get. got %s %v %v", v, ok, err)
+	}
+
+	if err := c.Set([]byte("c"), []byte("val_c"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := c.Get([]byte("b")); err != nil || ok {
+		t.Error("err Get. b should be evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("err Len. got %d want 2", c.Len())
+	}
+
+	if err := c.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// entries survive a reopen
+	db = openDB(t, false)
+	defer db.Close()
+
+	c, err = New(db, Options{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 1 {
+		t.Errorf("err Len after reopen. got %d want 1", c.Len())
+	}
+	if v, ok, err := c.Get([]byte("c")); err != nil || !ok || string(v) != "val_c" {
+		t.Errorf("err Get after reopen. got %s %v %v", v, ok, err)
+	}
+}
+
+func TestCache_MaxBytes(t *testing.T) {
+	db := openDB(t, true)
+	defer db.Close()
+
+	c, err := New(db, Options{MaxBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := c.Set([]byte(k), []byte("1234"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.Bytes() > 10 || c.Len() != 2 {
+		t.Errorf("err MaxBytes. got %d bytes %d entries", c.Bytes(), c.Len())
+	}
+}
+
+func TestCache_GetCopy(t *testing.T) {
+	db := openDB(t, true)
+	defer db.Close()
+
+	c, err := New(db, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Set([]byte("a"), []byte("val_a"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the value returned is not the one of the index.
+	v, ok, err := c.Get([]byte("a"))
+	if err != nil || !ok {
+		t.Fatalf("err Get. a not found: %v", err)
+	}
+	copy(v, "XXX")
+
+	if v, ok, err := c.Get([]byte("a")); err != nil || !ok || string(v) != "val_a" {
+		t.Errorf("err Get after modifying a value returned. got %s %v %v", v, ok, err)
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	db := openDB(t, true)
+
+	c, err := New(db, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a ttl under a second is not taken for a persistent one.
+	if err := c.Set([]byte("a"), []byte("val_a"), 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		e, err := tx.Get(DefaultBucket, []byte("a"))
+		if err != nil {
+			return err
+		}
+		if e.Meta.TTL != 1 {
+			t.Errorf("err TTL. got %d want 1", e.Meta.TTL)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the errors other than a missing key are returned, and keep the entry.
+	db.Close()
+	if _, ok, err := c.Get([]byte("a")); err == nil || ok {
+		t.Errorf("err Get on a closed db. got %v %v", ok, err)
+	}
+	if c.Len() != 1 {
+		t.Errorf("err Len after a failed Get. got %d want 1", c.Len())
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CDCCheckpointFileName is the name of the file holding the ID of the last transaction
+// shipped to Options.CDC.Sink.
+const CDCCheckpointFileName = "cdc.checkpoint"
+
+const (
+	// DefaultCDCBatchSize is the default max events of a Publish.
+	DefaultCDCBatchSize = 256
+
+	// DefaultCDCRetryInterval is the default wait before publishing the events again after a failure.
+	DefaultCDCRetryInterval = time.Second
+)
+
+// ErrCDCCheckpointCorrupted is returned when the CDC checkpoint file is not 8 bytes long.
+var ErrCDCCheckpointCorrupted = wrapError("cdc checkpoint corrupted", ErrCorrupted)
+
+// ChangeEvent represents an entry committed by a transaction, shipped to a CDCSink.
+type ChangeEvent struct {
+	TxID      uint64 `json:"txId"`
+	Bucket    string `json:"bucket"`
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	Flag      uint16 `json:"flag"` // the operation, e.g. DataSetFlag or DataDeleteFlag
+	DS        uint16 `json:"ds"`   // the data structure, e.g. DataStructureBPTree
+	TTL       uint32 `json:"ttl"`
+	Timestamp uint64 `json:"timestamp"`
+	SiteID    uint16 `json:"siteId"`
+}
+
+// CDCSink represents the downstream system the changes of the database are shipped to,
+// e.g. the Kafka and NATS sinks of the adapters/kafkacdc and adapters/natscdc packages.
+type CDCSink interface {
+	// Publish ships the events, in the order of their transactions, which are not split
+	// across two calls. It returns nil once the events are durably received: on an error,
+	// the events are published again, so a sink may receive an event more than once.
+	Publish(events []ChangeEvent) error
+}
+
+// CDCOptions represents the change data capture of Options.CDC.
+type CDCOptions struct {
+	// Sink represents the sink the events are published to. nil disables the capture.
+	Sink CDCSink
+
+	// BatchSize represents the max events of a Publish, unless a transaction has more.
+	// Default BatchSize is 0, which means DefaultCDCBatchSize.
+	BatchSize int
+
+	// RetryInterval represents the wait before publishing the events again after a failure.
+	// Default RetryInterval is 0, which means DefaultCDCRetryInterval.
+	RetryInterval time.Duration
+
+	// OnError is called after every failed Publish or checkpoint, whose error is also
+	// recorded as the LastError of DB.Health.
+	// Default OnError is nil, which means only recording the error.
+	OnError func(err error)
+}
+
+// cdcShipper ships the events of the committed transactions in a background goroutine.
+type cdcShipper struct {
+	mu      sync.Mutex
+	events  []ChangeEvent // not shipped yet, in the order of their transactions
+	shipped uint64        // the ID of the last transaction shipped
+	notify  chan struct{} // signaled when events are queued
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newChangeEvent returns the event of the entry of a committed transaction.
+func newChangeEvent(e *Entry) ChangeEvent {
+	return ChangeEvent{
+		TxID:      e.Meta.txID,
+		Bucket:    string(e.Meta.bucket),
+		Key:       e.Key,
+		Value:     e.Value,
+		Flag:      e.Meta.Flag,
+		DS:        e.Meta.ds,
+		TTL:       e.Meta.TTL,
+		Timestamp: e.Meta.timestamp,
+		SiteID:    e.Meta.siteID,
+	}
+}
+
+// startCDC starts the background goroutine shipping the events, if enabled. The events of
+// the transactions committed after the checkpoint are read back from the data files first,
+// except the ones of the entries a merge dropped since. A database without a checkpoint
+// ships the transactions committed from now on.
+func (db *DB) startCDC() error {
+	opt := db.opt.CDC
+	if opt.Sink == nil || db.opt.ReadOnly {
+		return nil
+	}
+
+	c := &cdcShipper{
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	buf, err := db.readFile(db.getCDCCheckpointPath())
+	switch {
+	case os.IsNotExist(err):
+		c.shipped = db.lastTxID
+		if err := db.persistCDCCheckpoint(c.shipped); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case len(buf) != 8:
+		return ErrCDCCheckpointCorrupted
+	default:
+		c.shipped = binary.LittleEndian.Uint64(buf)
+		if c.events, err = db.replayCDC(c.shipped); err != nil {
+			return err
+		}
+	}
+
+	db.cdc = c
+
+	batchSize := opt.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultCDCBatchSize
+	}
+
+	retryInterval := opt.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultCDCRetryInterval
+	}
+
+	go func() {
+		defer close(c.done)
+
+		for {
+			events := c.next(batchSize)
+			if len(events) == 0 {
+				select {
+				case <-c.notify:
+					continue
+				case <-c.stop:
+					return
+				}
+			}
+
+			if err := opt.Sink.Publish(events); err != nil {
+				db.reportCDCError(err)
+
+				select {
+				case <-time.After(retryInterval):
+					continue
+				case <-c.stop:
+					return
+				}
+			}
+
+			txID := events[len(events)-1].TxID
+			// the events shipped again after a restart are delivered at least once.
+			if err := db.persistCDCCheckpoint(txID); err != nil {
+				db.reportCDCError(err)
+			}
+			c.ack(len(events), txID)
+		}
+	}()
+
+	if len(c.events) > 0 {
+		c.signal()
+	}
+
+	return nil
+}
+
+// stopCDC stops the shipping of the events, the events not shipped yet being shipped
+// after the database is opened again.
+func (db *DB) stopCDC() {
+	c := db.cdc
+	if c == nil {
+		return
+	}
+
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+
+	<-c.done
+}
+
+// replayCDC returns the events of the transactions committed after since in the data files.
+func (db *DB) replayCDC(since uint64) ([]ChangeEvent, error) {
+	var entries []*Entry
+	// a transaction is committed when its last entry, possibly in a later file, is.
+	committedTxIds := make(map[uint64]struct{})
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	for _, dataID := range dataFileIds {
+		err := db.scanDataFile(int64(dataID), func(e *Entry, off int64) {
+			if e.Meta.status == Committed {
+				committedTxIds[e.Meta.txID] = struct{}{}
+			}
+			if e.Meta.txID > since {
+				entries = append(entries, e)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Meta.txID < entries[j].Meta.txID
+	})
+
+	var events []ChangeEvent
+	for _, e := range entries {
+		if _, ok := committedTxIds[e.Meta.txID]; ok {
+			events = append(events, newChangeEvent(e))
+		}
+	}
+
+	return events, nil
+}
+
+// enqueue queues the events of the entries of a committed transaction.
+func (c *cdcShipper) enqueue(entries []*Entry) {
+	c.mu.Lock()
+	for _, e := range entries {
+		c.events = append(c.events, newChangeEvent(e))
+	}
+	c.mu.Unlock()
+
+	c.signal()
+}
+
+// signal wakes the shipping goroutine up.
+func (c *cdcShipper) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next events to publish, at most n but ending at a transaction boundary.
+func (c *cdcShipper) next(n int) []ChangeEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n > len(c.events) {
+		n = len(c.events)
+	}
+	for n > 0 && n < len(c.events) && c.events[n].TxID == c.events[n-1].TxID {
+		n++
+	}
+
+	return c.events[:n:n]
+}
+
+// ack removes the n events published, up to the transaction txID.
+func (c *cdcShipper) ack(n int, txID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.events = c.events[n:]
+	c.shipped = txID
+}
+
+// CDCCheckpoint returns the ID of the last transaction shipped to Options.CDC.Sink, and the
+// number of events queued, not shipped yet. It returns 0, 0 if the capture is disabled.
+func (db *DB) CDCCheckpoint() (txID uint64, pending int) {
+	c := db.cdc
+	if c == nil {
+		return 0, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.shipped, len(c.events)
+}
+
+// persistCDCCheckpoint writes the ID of the last transaction shipped.
+func (db *DB) persistCDCCheckpoint(txID uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, txID)
+
+	return db.writeFileAtomic(db.getCDCCheckpointPath(), buf, db.opt.SyncEnable)
+}
+
+// reportCDCError reports the error of a Publish or of a checkpoint.
+func (db *DB) reportCDCError(err error) {
+	db.health.recordError(err)
+
+	if db.opt.CDC.OnError != nil {
+		db.opt.CDC.OnError(err)
+	}
+}
+
+func (db *DB) getCDCCheckpointPath() string {
+	return db.opt.Dir + "/" + CDCCheckpointFileName
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	fail    bool
+	batches [][]ChangeEvent
+}
+
+func (s *memorySink) Publish(events []ChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail {
+		return errors.New("sink unavailable")
+	}
+	s.batches = append(s.batches, append([]ChangeEvent(nil), events...))
+
+	return nil
+}
+
+func (s *memorySink) setFail(fail bool) {
+	s.mu.Lock()
+	s.fail = fail
+	s.mu.Unlock()
+}
+
+func (s *memorySink) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for _, batch := range s.batches {
+		for _, e := range batch {
+			keys = append(keys, string(e.Key))
+		}
+	}
+
+	return keys
+}
+
+func waitCDC(t *testing.T, db *DB) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		txID, pending := db.CDCCheckpoint()
+		if pending == 0 && txID == db.LastCommittedTxID() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the events shipped, %d pending", pending)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDB_CDC(t *testing.T) {
+	sink := &memorySink{}
+	InitOpt("/tmp/nutsdbtestcdc", true)
+	opt.SegmentSize = 1024
+	opt.CDC = CDCOptions{Sink: sink, BatchSize: 3, RetryInterval: time.Millisecond, OnError: func(err error) {}}
+	defer func() { opt.CDC = CDCOptions{} }()
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			if err := tx.Put("bucket", []byte(fmt.Sprintf("a%d", i)), []byte("v"), Persistent); err != nil {
+				return err
+			}
+			return tx.Put("bucket", []byte(fmt.Sprintf("b%d", i)), []byte("v"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitCDC(t, db)
+
+	want := "[a0 b0 a1 b1 a2 b2]"
+	if got := fmt.Sprint(sink.keys()); got != want {
+		t.Errorf("expected the events %s, got %s", want, got)
+	}
+	for _, batch := range sink.batches {
+		if len(batch) != 2 && len(batch) != 4 {
+			t.Errorf("expected the batches to end at a transaction boundary, got %d events", len(batch))
+		}
+	}
+
+	// the events not shipped when closing are shipped after opening again.
+	sink.setFail(true)
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Delete("bucket", []byte("a0"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, pending := db.CDCCheckpoint(); pending != 1 {
+		t.Errorf("expected 1 event pending, got %d", pending)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.setFail(false)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCDC(t, db)
+
+	keys := sink.keys()
+	if last := sink.batches[len(sink.batches)-1][0]; last.Flag != DataDeleteFlag || string(last.Key) != "a0" || len(keys) != 7 {
+		t.Errorf("expected the deletion of a0 shipped after reopening, got %v", keys)
+	}
+
+	// the entries rewritten by a merge are not shipped again.
+	for i := 0; i < 20; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("m"), []byte(fmt.Sprintf("%080d", i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("end"), []byte("v"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitCDC(t, db)
+	if got := len(sink.keys()); got != 28 {
+		t.Errorf("expected the merge not shipped, got %d events", got)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_CDC_Disabled(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcdc", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if txID, pending := db.CDCCheckpoint(); txID != 0 || pending != 0 {
+		t.Errorf("expected no capture, got %d, %d", txID, pending)
+	}
+}
+
+func TestDB_CDC_Error(t *testing.T) {
+	sink := &memorySink{fail: true}
+	InitOpt("/tmp/nutsdbtestcdc", true)
+	opt.CDC = CDCOptions{Sink: sink, RetryInterval: time.Millisecond}
+	defer func() { opt.CDC = CDCOptions{} }()
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("v"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without OnError, the failures are recorded by the health.
+	deadline := time.Now().Add(5 * time.Second)
+	for db.Health().LastError == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failed Publish recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := db.Health().LastError.Error(); got != "sink unavailable" {
+		t.Errorf("expected the error of the sink, got %q", got)
+	}
+
+	sink.setFail(false)
+	waitCDC(t, db)
+}
@@ -0,0 +1,279 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const (
+	// CheckpointFileName returns the index checkpoint file name
+	CheckpointFileName = "index.checkpoint"
+
+	// checkpointHeaderSize returns the index checkpoint header size
+	checkpointHeaderSize = 36
+
+	// checkpointNoValue marks a checkpoint record without value
+	checkpointNoValue = ^uint32(0)
+)
+
+// indexCheckpoint represents a snapshot of the in-memory indexes covering
+// every entry before the offset off of the data file fileID.
+type indexCheckpoint struct {
+	fileID   int64
+	off      int64
+	maxTxID  uint64
+	keyCount int
+	records  []*Record
+}
+
+// CheckpointIndex serializes the in-memory B+ tree, Set, ZSet and List indexes
+// to the checkpoint file, with the position of the log it covers and the max txID
+// of the indexed entries. The next Open loads it and only replays newer entries.
+// It is not supported in HintBPTSparseIdxMode, whose indexes are already on disk.
+//
+//	the checkpoint file stored format:
+//	|--------------------------------------------------------|
+//	|  crc  | fileID |  off  | maxTxID | keyCount | records  |
+//	|--------------------------------------------------------|
+//	| uint32| uint64 | uint64|  uint64 |  uint64  |  []byte  |
+//	|--------------------------------------------------------|
+//
+//	every record stored format:
+//	|--------------------------------------------|
+//	| fileID | valueSize | hint record |  value  |
+//	|--------------------------------------------|
+//	| uint64 |  uint32   |   []byte    |  []byte |
+//	|--------------------------------------------|
+func (db *DB) CheckpointIndex() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if db.opt.ReadOnly {
+		return ErrReadOnly
+	}
+
+	buf := make([]byte, checkpointHeaderSize)
+	var maxTxID uint64
+
+	appendRecord := func(key []byte, r *Record) {
+		if r.H.meta.txID > maxTxID {
+			maxTxID = r.H.meta.txID
+		}
+		buf = appendCheckpointRecord(buf, key, r)
+	}
+
+	db.loadBPTreeIdxes()
+	for _, tree := range db.BPTreeIdx {
+		// the records of a front-coded tree do not keep their key.
+		_, keys, pointers := tree.getAll()
+		for i, p := range pointers {
+			appendRecord(keys[i], p.(*Record))
+		}
+	}
+
+	for bucket, s := range db.SetIdx {
+		for key, members := range s.M {
+			for member := range members {
+				r := newCheckpointRecord(db.now(), bucket, []byte(key), []byte(member), DataSetFlag, DataStructureSet)
+				appendRecord(r.H.key, r)
+			}
+		}
+	}
+
+	for bucket, ss := range db.SortedSetIdx {
+		for key, node := range ss.Dict {
+			newKey := encodeCompositeKey([]byte(key), encodeScore(float64(node.Score())))
+			r := newCheckpointRecord(db.now(), bucket, newKey, node.Value, DataZAddFlag, DataStructureSortedSet)
+			appendRecord(r.H.key, r)
+		}
+	}
+
+	for bucket, l := range db.ListIdx {
+		for key, items := range l.Items {
+			for _, item := range items {
+				r := newCheckpointRecord(db.now(), bucket, []byte(key), item, DataRPushFlag, DataStructureList)
+				appendRecord(r.H.key, r)
+			}
+		}
+	}
+
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(db.ActiveFile.fileID))
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(db.ActiveFile.writeOff))
+	binary.LittleEndian.PutUint64(buf[20:28], maxTxID)
+	binary.LittleEndian.PutUint64(buf[28:36], uint64(db.KeyCount))
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	return db.writeFileAtomic(db.getCheckpointPath(), buf, db.opt.SyncEnable)
+}
+
+// newCheckpointRecord returns the record re-creating a Set, ZSet or List member.
+func newCheckpointRecord(timestamp uint64, bucket string, key, value []byte, flag uint16, ds uint16) *Record {
+	meta := &MetaData{
+		keySize:    uint32(len(key)),
+		valueSize:  uint32(len(value)),
+		timestamp:  timestamp,
+		TTL:        Persistent,
+		bucket:     []byte(bucket),
+		bucketSize: uint32(len(bucket)),
+		Flag:       flag,
+		status:     Committed,
+		ds:         ds,
+	}
+
+	return &Record{
+		H: &Hint{key: key, meta: meta},
+		E: &Entry{Key: key, Value: value, Meta: meta},
+	}
+}
+
+// appendCheckpointRecord appends the encoded record of the key to buf.
+func appendCheckpointRecord(buf []byte, key []byte, r *Record) []byte {
+	valueSize := checkpointNoValue
+	if r.E != nil {
+		valueSize = uint32(len(r.E.Value))
+	}
+
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint64(header[0:8], uint64(r.H.fileID))
+	binary.LittleEndian.PutUint32(header[8:12], valueSize)
+
+	buf = append(buf, header...)
+	buf = append(buf, encodeHint(&Entry{Key: key, Meta: r.H.meta}, r.H.dataPos)...)
+	if r.E != nil {
+		buf = append(buf, r.E.Value...)
+	}
+
+	return buf
+}
+
+// readIndexCheckpoint returns the index checkpoint from the checkpoint file.
+func (db *DB) readIndexCheckpoint() (*indexCheckpoint, error) {
+	buf, err := db.readFile(db.getCheckpointPath())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) < checkpointHeaderSize {
+		return nil, ErrCorrupted
+	}
+
+	if crc32.ChecksumIEEE(buf[4:]) != binary.LittleEndian.Uint32(buf[0:4]) {
+		return nil, ErrCrc
+	}
+
+	cp := &indexCheckpoint{
+		fileID:   int64(binary.LittleEndian.Uint64(buf[4:12])),
+		off:      int64(binary.LittleEndian.Uint64(buf[12:20])),
+		maxTxID:  binary.LittleEndian.Uint64(buf[20:28]),
+		keyCount: int(binary.LittleEndian.Uint64(buf[28:36])),
+	}
+
+	for off := checkpointHeaderSize; off < len(buf); {
+		if len(buf)-off < 12+hintHeaderSize {
+			return nil, ErrCorrupted
+		}
+
+		fileID := int64(binary.LittleEndian.Uint64(buf[off : off+8]))
+		valueSize := binary.LittleEndian.Uint32(buf[off+8 : off+12])
+		off += 12
+
+		meta := readMetaData(buf[off : off+DataEntryHeaderSize])
+		hintSize := hintHeaderSize + int(meta.bucketSize) + int(meta.keySize)
+		if len(buf)-off < hintSize {
+			return nil, ErrCorrupted
+		}
+
+		hints, err := decodeHints(buf[off:off+hintSize], fileID, nil)
+		if err != nil {
+			return nil, err
+		}
+		off += hintSize
+
+		r := &Record{H: hints[0]}
+		if valueSize != checkpointNoValue {
+			if len(buf)-off < int(valueSize) {
+				return nil, ErrCorrupted
+			}
+			r.E = &Entry{Key: r.H.key, Value: buf[off : off+int(valueSize)], Meta: r.H.meta}
+			off += int(valueSize)
+		}
+
+		cp.records = append(cp.records, r)
+	}
+
+	return cp, nil
+}
+
+// loadIndexCheckpoint rebuilds the indexes from the index checkpoint.
+func (db *DB) loadIndexCheckpoint(cp *indexCheckpoint) error {
+	for _, r := range cp.records {
+		bucket := string(r.H.meta.bucket)
+
+		if r.H.meta.ds == DataStructureBPTree {
+			if err := db.buildBPTreeIdx(bucket, r); err != nil {
+				return err
+			}
+		}
+
+		if err := db.buildOtherIdxes(bucket, r); err != nil {
+			return err
+		}
+	}
+
+	// deleted keys are inserted fresh, so count the valid keys again.
+	for _, tree := range db.BPTreeIdx {
+		tree.ValidKeyCount = 0
+		if records, err := tree.All(); err == nil {
+			for _, r := range records {
+				if r.H.meta.Flag != DataDeleteFlag {
+					tree.ValidKeyCount++
+				}
+			}
+		}
+	}
+
+	db.KeyCount = cp.keyCount
+
+	return nil
+}
+
+// skipCheckpointHints returns the hints at or after the checkpoint offset off.
+func skipCheckpointHints(hints []*Hint, off int64) []*Hint {
+	for i, h := range hints {
+		if int64(h.dataPos) >= off {
+			return hints[i:]
+		}
+	}
+
+	return nil
+}
+
+// getCheckpointPath returns the index checkpoint path.
+func (db *DB) getCheckpointPath() string {
+	return db.opt.Dir + "/" + CheckpointFileName
+}
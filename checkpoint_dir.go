@@ -0,0 +1,214 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+var (
+	// ErrCheckpointDirNotEmpty is returned when the checkpoint dir already contains files.
+	ErrCheckpointDirNotEmpty = errors.New("checkpoint dir is not empty")
+
+	// ErrCheckpointInDBDir is returned when the checkpoint dir is the dir of the database.
+	ErrCheckpointInDBDir = errors.New("checkpoint dir is the db dir")
+)
+
+// Checkpoint writes a consistent copy of the database to dir, which must
+// not exist or be empty. The sealed data files and their hint and index
+// files are hard-linked, falling back to a copy across file systems, and
+// the written part of the active file is copied, so the checkpoint costs
+// little disk space and only blocks the writable transactions while the
+// active file is copied. The copy can be opened with Options.ReadOnly by
+// another process, using the same SegmentSize and EntryIdxMode.
+func (db *DB) Checkpoint(dir string) error {
+	_, err := db.checkpoint(dir)
+	return err
+}
+
+// checkpoint writes the Checkpoint to dir and returns its BackupManifest.
+func (db *DB) checkpoint(dir string) (*BackupManifest, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if err := db.checkOSFiles(); err != nil {
+		return nil, err
+	}
+
+	if dir == db.opt.Dir {
+		return nil, ErrCheckpointInDBDir
+	}
+
+	if err := os.MkdirAll(dir, db.dirPerm()); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		return nil, ErrCheckpointDirNotEmpty
+	}
+
+	m, active, err := db.snapshotActiveFile()
+	if err != nil {
+		return nil, err
+	}
+
+	dataFileIds := m.DataFileIDs
+	m.DataFileIDs = nil
+	for _, fID := range dataFileIds {
+		if fID == m.ActiveFileID {
+			m.DataFileIDs = append(m.DataFileIDs, fID)
+			continue
+		}
+
+		// a file removed by a running Merge has already been rewritten to the active file.
+		for i, name := range []string{getFileName(fID, DataSuffix), getFileName(fID, HintSuffix)} {
+			err := linkOrCopyFile(db.opt.Dir+"/"+name, dir+"/"+name, db.filePerm())
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			if i == 0 && err == nil {
+				m.DataFileIDs = append(m.DataFileIDs, fID)
+			}
+		}
+	}
+
+	activePath := dir + "/" + getFileName(m.ActiveFileID, DataSuffix)
+	if err := writeSegmentFile(activePath, active, db.opt.SegmentSize, os.O_EXCL, db.filePerm()); err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{CheckpointFileName, KeyComparatorFileName, IDLeaseFileName, LastTxIDFileName, BucketDictFileName} {
+		if err := copyFile(db.opt.Dir+"/"+name, dir+"/"+name, db.filePerm()); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		if err := linkDir(db.opt.Dir+"/"+bptDir, dir+"/"+bptDir, db.dirPerm(), db.filePerm()); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// readActiveFile returns the written part of the active file.
+func (db *DB) readActiveFile() ([]byte, error) {
+	buf := make([]byte, db.ActiveFile.writeOff)
+	if _, err := db.ActiveFile.rwManager.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// writeSegmentFile writes buf to path, created with perm and opened with the extra flag,
+// padded to segmentSize, and syncs it.
+func writeSegmentFile(path string, buf []byte, segmentSize int64, flag int, perm os.FileMode) error {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|flag, perm)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if _, err := fd.Write(buf); err != nil {
+		return err
+	}
+
+	if err := fd.Truncate(segmentSize); err != nil {
+		return err
+	}
+
+	return fd.Sync()
+}
+
+// getFileName returns the name of the file with given fid and suffix.
+func getFileName(fID int64, suffix string) string {
+	return strconv2.Int64ToStr(fID) + suffix
+}
+
+// linkOrCopyFile hard-links src to dst, or copies it to a file created with perm when it cannot be linked.
+func linkOrCopyFile(src, dst string, perm os.FileMode) error {
+	if err := os.Link(src, dst); err != nil {
+		if os.IsNotExist(err) {
+			return err
+		}
+
+		return copyFile(src, dst, perm)
+	}
+
+	return nil
+}
+
+// copyFile copies src to dst, created with perm, and syncs it.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_RDWR|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+
+	return out.Sync()
+}
+
+// linkDir recursively hard-links the files of src to dst, creating the dirs with dirPerm
+// and the files copied with filePerm.
+func linkDir(src, dst string, dirPerm, filePerm os.FileMode) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dst, dirPerm); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		var err error
+		if f.IsDir() {
+			err = linkDir(src+"/"+f.Name(), dst+"/"+f.Name(), dirPerm, filePerm)
+		} else {
+			err = linkOrCopyFile(src+"/"+f.Name(), dst+"/"+f.Name(), filePerm)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDB_Checkpoint(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode, HintBPTSparseIdxMode} {
+		testCheckpointDir(t, mode)
+	}
+}
+
+func testCheckpointDir(t *testing.T, mode EntryIdxMode) {
+	bucket := "bucket"
+	dir := "/tmp/nutsdbtestcheckpointdir"
+	os.RemoveAll(dir)
+
+	InitOpt("/tmp/nutsdbtestcheckpointdb", true)
+	opt.EntryIdxMode = mode
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	put := func(from, to int, value string) {
+		for i := from; i < to; i++ {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put(bucket, []byte(fmt.Sprintf("key_%03d", i)), []byte(value), Persistent)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	put(0, 200, "old")
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+	put(0, 10, "new")
+
+	if err := db.Checkpoint(dir); err != ErrCheckpointDirNotEmpty {
+		t.Errorf("err Checkpoint to used dir. got %v want %v", err, ErrCheckpointDirNotEmpty)
+	}
+
+	cpOpt := opt
+	cpOpt.Dir = dir
+	cpOpt.ReadOnly = true
+	cp, err := Open(cpOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+
+	if err := cp.View(func(tx *Tx) error {
+		for i := 0; i < 200; i++ {
+			e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%03d", i)))
+			if err != nil {
+				return err
+			}
+			if string(e.Value) != "old" {
+				t.Errorf("err checkpoint value of key_%03d. got %s want old", i, e.Value)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("mode %d: %v", mode, err)
+	}
+
+	if err := cp.Update(func(tx *Tx) error { return nil }); err != ErrReadOnly {
+		t.Errorf("err Update on read-only db. got %v want %v", err, ErrReadOnly)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("key_000"))
+		if err == nil && string(e.Value) != "new" {
+			t.Errorf("err primary value. got %s want new", e.Value)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
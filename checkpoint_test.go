@@ -0,0 +1,139 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDB_CheckpointIndex(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		fileDir := "/tmp/nutsdbtestcheckpoint"
+		InitOpt(fileDir, true)
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 1024
+
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bucket := "bucket_checkpoint"
+		put := func(from, to int) {
+			for i := from; i < to; i++ {
+				if err := db.Update(func(tx *Tx) error {
+					return tx.Put(bucket, []byte(fmt.Sprintf("key_%03d", i)), []byte(fmt.Sprintf("val_%03d", i)), Persistent)
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		put(0, 50)
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Delete(bucket, []byte("key_000"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if mode == HintKeyValAndRAMIdxMode {
+			if err := db.Update(func(tx *Tx) error {
+				if err := tx.SAdd("set", []byte("key"), []byte("a"), []byte("b")); err != nil {
+					return err
+				}
+				if err := tx.ZAdd("zset", []byte("key"), 1.5, []byte("z")); err != nil {
+					return err
+				}
+				return tx.RPush("list", []byte("key"), []byte("1"), []byte("2"))
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := db.CheckpointIndex(); err != nil {
+			t.Fatal(err)
+		}
+
+		// entries after the checkpoint are replayed
+		put(50, 60)
+		keyCount := db.KeyCount
+		validKeyCount := db.BPTreeIdx[bucket].ValidKeyCount
+		db.Close()
+
+		if _, err := os.Stat(fileDir + "/" + CheckpointFileName); err != nil {
+			t.Fatal(err)
+		}
+
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if db.KeyCount != keyCount || db.BPTreeIdx[bucket].ValidKeyCount != validKeyCount {
+			t.Errorf("err counts. got %d %d want %d %d", db.KeyCount, db.BPTreeIdx[bucket].ValidKeyCount, keyCount, validKeyCount)
+		}
+
+		if err := db.View(func(tx *Tx) error {
+			if _, err := tx.Get(bucket, []byte("key_000")); err == nil {
+				t.Error("err Get deleted key after checkpoint")
+			}
+
+			for i := 1; i < 60; i++ {
+				e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%03d", i)))
+				if err != nil {
+					return err
+				}
+				if string(e.Value) != fmt.Sprintf("val_%03d", i) {
+					t.Errorf("err Get. got %s want val_%03d", e.Value, i)
+				}
+			}
+
+			if mode == HintKeyValAndRAMIdxMode {
+				if ok, _ := tx.SIsMember("set", []byte("key"), []byte("b")); !ok {
+					t.Error("err SIsMember after checkpoint")
+				}
+				if n, err := tx.ZGetByKey("zset", []byte("key")); err != nil || n.Score() != 1.5 {
+					t.Errorf("err ZGetByKey after checkpoint. got %v %v", n, err)
+				}
+				if items, err := tx.LRange("list", []byte("key"), 0, -1); err != nil || len(items) != 2 || string(items[0]) != "1" {
+					t.Errorf("err LRange after checkpoint. got %s %v", items, err)
+				}
+			}
+
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		db.Close()
+	}
+}
+
+func TestDB_CheckpointIndex_Err(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpointerr", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.CheckpointIndex(); err != ErrNotSupportHintBPTSparseIdxMode {
+		t.Errorf("err CheckpointIndex. got %v want %v", err, ErrNotSupportHintBPTSparseIdxMode)
+	}
+}
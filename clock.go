@@ -0,0 +1,151 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock represents the source of the entry timestamps and of the current
+// time used to expire entries, in Unix seconds.
+type Clock interface {
+	Now() uint64
+}
+
+// SystemClock represents the Clock which using the wall clock.
+type SystemClock struct{}
+
+// Now returns the current Unix time in seconds.
+func (SystemClock) Now() uint64 {
+	return uint64(time.Now().Unix())
+}
+
+// ManualClock represents the Clock whose time is only moved explicitly, e.g. in tests.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock returns a newly initialized ManualClock at given time.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the current Unix time of the clock in seconds.
+func (c *ManualClock) Now() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return uint64(c.now.Unix())
+}
+
+// Set sets the time of the clock.
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+// Advance moves the time of the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// HLCTimestamp represents a hybrid logical clock timestamp: the wall time
+// in milliseconds in the high 48 bits and a logical counter in the low 16 bits.
+// HLCTimestamps order events across nodes consistently with causality.
+type HLCTimestamp uint64
+
+const hlcLogicalBits = 16
+
+// newHLCTimestamp returns the HLCTimestamp at given wall time with a zero logical counter.
+func newHLCTimestamp(t time.Time) HLCTimestamp {
+	return HLCTimestamp(uint64(t.UnixNano()/int64(time.Millisecond)) << hlcLogicalBits)
+}
+
+// WallTime returns the wall time part of the timestamp.
+func (ts HLCTimestamp) WallTime() time.Time {
+	ms := int64(ts >> hlcLogicalBits)
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+// Logical returns the logical counter part of the timestamp.
+func (ts HLCTimestamp) Logical() uint16 {
+	return uint16(ts)
+}
+
+// HLC represents a hybrid logical clock. Its timestamps never go backwards,
+// even when the wall clock does, and never fall behind the timestamps
+// received from other nodes through Update, which bounds the effect of
+// clock skew on entry timestamps and TTLs in replication.
+type HLC struct {
+	mu       sync.Mutex
+	physical func() time.Time
+	last     HLCTimestamp
+}
+
+// NewHLC returns a newly initialized HLC reading the wall clock from physical.
+// If physical is nil, time.Now is used.
+func NewHLC(physical func() time.Time) *HLC {
+	if physical == nil {
+		physical = time.Now
+	}
+
+	return &HLC{physical: physical}
+}
+
+// Tick returns the timestamp of a local or send event.
+func (c *HLC) Tick() HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pt := newHLCTimestamp(c.physical()); pt > c.last {
+		c.last = pt
+	} else {
+		c.last++
+	}
+
+	return c.last
+}
+
+// Update merges the timestamp received from another node and returns
+// the timestamp of the receive event.
+func (c *HLC) Update(remote HLCTimestamp) HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	latest := c.last
+	if remote > latest {
+		latest = remote
+	}
+
+	if pt := newHLCTimestamp(c.physical()); pt > latest {
+		c.last = pt
+	} else {
+		c.last = latest + 1
+	}
+
+	return c.last
+}
+
+// Now returns the wall time of a new timestamp in Unix seconds.
+func (c *HLC) Now() uint64 {
+	return uint64(c.Tick().WallTime().Unix())
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHLC(t *testing.T) {
+	wall := time.Unix(1000, 0)
+	c := NewHLC(func() time.Time { return wall })
+
+	ts1 := c.Tick()
+	ts2 := c.Tick()
+	if ts2 <= ts1 || ts2.Logical() != 1 {
+		t.Errorf("err Tick. got %d after %d", ts2, ts1)
+	}
+
+	// the wall clock going backwards does not move the clock backwards
+	wall = time.Unix(900, 0)
+	if ts3 := c.Tick(); ts3 <= ts2 {
+		t.Errorf("err Tick after clock skew. got %d after %d", ts3, ts2)
+	}
+	if now := c.Now(); now != 1000 {
+		t.Errorf("err Now. got %d want 1000", now)
+	}
+
+	// a remote timestamp ahead of the local clock is observed
+	remote := newHLCTimestamp(time.Unix(2000, 0))
+	if ts := c.Update(remote); ts <= remote {
+		t.Errorf("err Update. got %d want > %d", ts, remote)
+	}
+	if wallTime := c.Tick().WallTime(); wallTime.Unix() != 2000 {
+		t.Errorf("err WallTime. got %v want 2000", wallTime.Unix())
+	}
+}
+
+func TestDB_Clock(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestclock", true)
+	clock := NewManualClock(time.Unix(1000, 0))
+	opt.Clock = clock
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bucket := "bucket_clock"
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), []byte("val"), 10)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() error {
+		return db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, []byte("key"))
+			if err == nil && e.Meta.timestamp != 1000 {
+				t.Errorf("err timestamp. got %d want 1000", e.Meta.timestamp)
+			}
+			return err
+		})
+	}
+
+	clock.Advance(9 * time.Second)
+	if err := get(); err != nil {
+		t.Errorf("err Get before TTL. got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := get(); err == nil {
+		t.Error("err Get after TTL. got nil want error")
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// KeyComparatorFileName returns the file name persisting the key comparator of every bucket
+const KeyComparatorFileName = "key.comparators"
+
+// ErrKeyComparatorMismatch is returned when a bucket is opened with another key comparator than the one it was written with.
+var ErrKeyComparatorMismatch = errors.New("key comparator mismatch")
+
+// KeyComparator represents the order of the keys in the B+ tree index of a bucket.
+// Keys comparing equal are the same key.
+// PrefixScan assumes that keys sharing a prefix are contiguous in the order.
+type KeyComparator interface {
+	// Name identifies the comparator. It is persisted and checked when opening the database.
+	Name() string
+
+	// Compare returns an integer comparing two keys, like bytes.Compare.
+	Compare(a, b []byte) int
+}
+
+// KeyComparatorError records the bucket opened with a mismatched key comparator.
+// It unwraps to ErrKeyComparatorMismatch.
+type KeyComparatorError struct {
+	Bucket    string
+	Persisted string
+	Got       string
+}
+
+// Error implements the error interface.
+func (e *KeyComparatorError) Error() string {
+	return fmt.Sprintf("bucket %s: key comparator %s, but persisted with %s", e.Bucket, e.Got, e.Persisted)
+}
+
+// Unwrap returns ErrKeyComparatorMismatch.
+func (e *KeyComparatorError) Unwrap() error {
+	return ErrKeyComparatorMismatch
+}
+
+// BytewiseComparator represents the KeyComparator ordering keys bytewise, the default order.
+type BytewiseComparator struct{}
+
+// Name returns the name of the comparator.
+func (BytewiseComparator) Name() string {
+	return "nutsdb.Bytewise"
+}
+
+// Compare compares the keys bytewise.
+func (BytewiseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+// CaseInsensitiveComparator represents the KeyComparator ordering keys bytewise
+// ignoring ASCII case, so keys only differing in case are the same key.
+type CaseInsensitiveComparator struct{}
+
+// Name returns the name of the comparator.
+func (CaseInsensitiveComparator) Name() string {
+	return "nutsdb.CaseInsensitive"
+}
+
+// Compare compares the keys bytewise ignoring ASCII case.
+func (CaseInsensitiveComparator) Compare(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := toLowerASCII(a[i]), toLowerASCII(b[i])
+		if ca != cb {
+			if ca < cb {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+
+	return 0
+}
+
+func toLowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+
+	return c
+}
+
+// NumericComparator represents the KeyComparator ordering keys made of decimal
+// digits numerically, e.g. "9" before "10". Keys with other bytes are ordered
+// bytewise after the numeric keys.
+type NumericComparator struct{}
+
+// Name returns the name of the comparator.
+func (NumericComparator) Name() string {
+	return "nutsdb.Numeric"
+}
+
+// Compare compares the keys numerically.
+func (NumericComparator) Compare(a, b []byte) int {
+	na, nb := isDigits(a), isDigits(b)
+
+	switch {
+	case na && !nb:
+		return -1
+	case !na && nb:
+		return 1
+	case !na && !nb:
+		return bytes.Compare(a, b)
+	}
+
+	ta, tb := bytes.TrimLeft(a, "0"), bytes.TrimLeft(b, "0")
+	switch {
+	case len(ta) < len(tb):
+		return -1
+	case len(ta) > len(tb):
+		return 1
+	}
+
+	if c := bytes.Compare(ta, tb); c != 0 {
+		return c
+	}
+
+	// "01" and "1" are distinct keys.
+	return bytes.Compare(a, b)
+}
+
+func isDigits(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// keyComparator returns the key comparator of the bucket, nil meaning bytewise.
+func (db *DB) keyComparator(bucket string) KeyComparator {
+	if db.opt.KeyComparator == nil {
+		return nil
+	}
+
+	return db.opt.KeyComparator(bucket)
+}
+
+// keyComparatorName returns the name of the key comparator of the bucket.
+func (db *DB) keyComparatorName(bucket string) string {
+	if cmp := db.keyComparator(bucket); cmp != nil {
+		return cmp.Name()
+	}
+
+	return BytewiseComparator{}.Name()
+}
+
+// newBPTree returns a newly initialized BPTree ordered by the key comparator of the bucket,
+// recording the comparator name of a new bucket.
+func (db *DB) newBPTree(bucket string) *BPTree {
+	t := NewTree()
+	t.comparator = db.keyComparator(bucket)
+	if db.opt.FrontCodedKeys && db.opt.EntryIdxMode == HintKeyAndRAMIdxMode {
+		t.frontCoding = true
+		t.bucket = []byte(bucket)
+	}
+
+	if _, ok := db.keyComparatorNames[bucket]; !ok {
+		db.keyComparatorNames[bucket] = db.keyComparatorName(bucket)
+		db.keyComparatorNamesDirty = true
+	}
+
+	return t
+}
+
+// checkKeyComparators checks the key comparator of every bucket against the persisted ones.
+func (db *DB) checkKeyComparators() error {
+	persisted, err := db.readKeyComparators()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for bucket, name := range persisted {
+		if got := db.keyComparatorName(bucket); got != name {
+			return &KeyComparatorError{Bucket: bucket, Persisted: name, Got: got}
+		}
+	}
+
+	db.keyComparatorNamesDirty = false
+	for bucket, name := range db.keyComparatorNames {
+		if _, ok := persisted[bucket]; !ok {
+			persisted[bucket] = name
+			db.keyComparatorNamesDirty = true
+		}
+	}
+
+	db.keyComparatorNames = persisted
+
+	return db.persistKeyComparators()
+}
+
+// persistKeyComparators writes the comparator names of the buckets if any bucket was added,
+// unless the db is read-only.
+//
+//	every bucket stored format:
+//	|-----------------------------------------|
+//	| bucketSize | nameSize | bucket |  name  |
+//	|-----------------------------------------|
+//	|   uint32   |  uint32  | []byte | []byte |
+//	|-----------------------------------------|
+func (db *DB) persistKeyComparators() error {
+	if !db.keyComparatorNamesDirty || db.opt.ReadOnly {
+		return nil
+	}
+
+	buf := make([]byte, 4)
+	for bucket, name := range db.keyComparatorNames {
+		header := make([]byte, 8)
+		binary.LittleEndian.PutUint32(header[0:4], uint32(len(bucket)))
+		binary.LittleEndian.PutUint32(header[4:8], uint32(len(name)))
+		buf = append(buf, header...)
+		buf = append(buf, bucket...)
+		buf = append(buf, name...)
+	}
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	if err := db.writeFileAtomic(db.opt.Dir+"/"+KeyComparatorFileName, buf, db.opt.SyncEnable); err != nil {
+		return err
+	}
+
+	db.keyComparatorNamesDirty = false
+
+	return nil
+}
+
+// readKeyComparators returns the persisted comparator names of the buckets.
+func (db *DB) readKeyComparators() (map[string]string, error) {
+	names := make(map[string]string)
+
+	buf, err := db.readFile(db.opt.Dir + "/" + KeyComparatorFileName)
+	if err != nil {
+		return names, err
+	}
+
+	if len(buf) < 4 || crc32.ChecksumIEEE(buf[4:]) != binary.LittleEndian.Uint32(buf[0:4]) {
+		return nil, ErrCrc
+	}
+
+	for off := 4; off < len(buf); {
+		if len(buf)-off < 8 {
+			return nil, ErrCorrupted
+		}
+
+		bucketSize := int(binary.LittleEndian.Uint32(buf[off : off+4]))
+		nameSize := int(binary.LittleEndian.Uint32(buf[off+4 : off+8]))
+		off += 8
+
+		if len(buf)-off < bucketSize+nameSize {
+			return nil, ErrCorrupted
+		}
+
+		names[string(buf[off:off+bucketSize])] = string(buf[off+bucketSize : off+bucketSize+nameSize])
+		off += bucketSize + nameSize
+	}
+
+	return names, nil
+}
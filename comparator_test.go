@@ -0,0 +1,116 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeyComparators(t *testing.T) {
+	tests := []struct {
+		cmp  KeyComparator
+		a, b string
+		want int
+	}{
+		{NumericComparator{}, "9", "10", -1},
+		{NumericComparator{}, "010", "9", 1},
+		{NumericComparator{}, "1", "01", 1},
+		{NumericComparator{}, "9", "a", -1},
+		{CaseInsensitiveComparator{}, "Key", "key", 0},
+		{CaseInsensitiveComparator{}, "A", "b", -1},
+		{BytewiseComparator{}, "A", "b", -1},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cmp.Compare([]byte(tt.a), []byte(tt.b)); got != tt.want {
+			t.Errorf("err %s Compare(%s, %s). got %d want %d", tt.cmp.Name(), tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDB_KeyComparator(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestkeycomparator", true)
+	bucket := "bucket_numeric"
+	opt.KeyComparator = func(b string) KeyComparator {
+		if b == bucket {
+			return NumericComparator{}
+		}
+		return nil
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		for _, key := range []string{"1", "2", "9", "10", "11", "100"} {
+			if err := tx.Put(bucket, []byte(key), []byte(key), Persistent); err != nil {
+				return err
+			}
+		}
+		return tx.Put("bucket_bytewise", []byte("1"), nil, Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		entries, err := tx.RangeScan(bucket, []byte("2"), []byte("11"))
+		if err != nil {
+			return err
+		}
+
+		want := []string{"2", "9", "10", "11"}
+		if len(entries) != len(want) {
+			t.Fatalf("err RangeScan. got %d entries want %d", len(entries), len(want))
+		}
+		for i, e := range entries {
+			if string(e.Key) != want[i] {
+				t.Errorf("err RangeScan. got %s want %s", e.Key, want[i])
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// opening with another comparator fails
+	opt.KeyComparator = nil
+	db, err = Open(opt)
+	if !errors.Is(err, ErrKeyComparatorMismatch) {
+		t.Errorf("err Open. got %v want %v", err, ErrKeyComparatorMismatch)
+	}
+	if err == nil {
+		db.Close()
+	}
+}
+
+func TestDB_KeyComparator_Sparse(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestkeycomparatorsparse", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+	opt.KeyComparator = func(string) KeyComparator { return NumericComparator{} }
+
+	if _, err := Open(opt); !errors.Is(err, ErrUnsupportedMode) {
+		t.Errorf("err Open. got %v want %v", err, ErrUnsupportedMode)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// compositeKeyMarker ends the composite keys. The keys written with SeparatorForZSetKey
+// or SeparatorForListKey before end with the text of a score, an index or a position.
+const compositeKeyMarker = 0x00
+
+// encodeCompositeKey returns the key of an entry holding the key of a sorted set or a
+// list and a suffix, e.g. the score of ZAdd or the index of LSet. The suffix is sized,
+// so the key may hold any byte.
+//
+//	|-----------------------------------------------|
+//	|   key  | suffix | suffixSize | marker (0x00)  |
+//	|-----------------------------------------------|
+//	| []byte | []byte |   uint16   |     uint8      |
+//	|-----------------------------------------------|
+func encodeCompositeKey(key, suffix []byte) []byte {
+	buf := make([]byte, len(key)+len(suffix)+3)
+	copy(buf, key)
+	copy(buf[len(key):], suffix)
+	binary.BigEndian.PutUint16(buf[len(buf)-3:], uint16(len(suffix)))
+	buf[len(buf)-1] = compositeKeyMarker
+
+	return buf
+}
+
+// decodeCompositeKey returns the key and the suffix of a composite key, also decoding
+// the keys written with the separator before, i.e. `key separator suffix`.
+func decodeCompositeKey(buf []byte, separator string) (key, suffix []byte, err error) {
+	if n := len(buf); n >= 3 && buf[n-1] == compositeKeyMarker {
+		size := int(binary.BigEndian.Uint16(buf[n-3 : n-1]))
+		if size > n-3 {
+			return nil, nil, ErrCorrupted
+		}
+		return buf[:n-3-size], buf[n-3-size : n-3], nil
+	}
+
+	i := bytes.Index(buf, []byte(separator))
+	if i < 0 || bytes.Contains(buf[i+len(separator):], []byte(separator)) {
+		return nil, nil, ErrCorrupted
+	}
+
+	return buf[:i], buf[i+len(separator):], nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+)
+
+func TestCompositeKey(t *testing.T) {
+	for _, key := range []string{"", "key", "a|b", "a\x00b|", "\x00"} {
+		for _, suffix := range []string{"", "1", "1.5", "a|b\x00"} {
+			gotKey, gotSuffix, err := decodeCompositeKey(encodeCompositeKey([]byte(key), []byte(suffix)), SeparatorForZSetKey)
+			if err != nil || string(gotKey) != key || string(gotSuffix) != suffix {
+				t.Errorf("err decodeCompositeKey of %q %q. got %q %q %v", key, suffix, gotKey, gotSuffix, err)
+			}
+		}
+	}
+
+	// the keys written with the separator before.
+	key, suffix, err := decodeCompositeKey([]byte("key|1.5"), SeparatorForZSetKey)
+	if err != nil || string(key) != "key" || string(suffix) != "1.5" {
+		t.Errorf("err decodeCompositeKey of a legacy key. got %q %q %v", key, suffix, err)
+	}
+
+	if _, _, err := decodeCompositeKey([]byte("key"), SeparatorForZSetKey); err != ErrCorrupted {
+		t.Errorf("err decodeCompositeKey without separator. got %v want ErrCorrupted", err)
+	}
+}
+
+func TestDB_CompositeKeysWithSeparators(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcompositekey", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zsetBucket, listBucket := "zset", "list"
+	list := []byte("my|list")
+
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.ZAdd(zsetBucket, []byte("a|b"), 1.5, []byte("v1")); err != nil {
+			return err
+		}
+		if err := tx.ZAdd(zsetBucket, []byte("a\x00"), 2, []byte("v2")); err != nil {
+			return err
+		}
+		return tx.RPush(listBucket, list, []byte("1"), []byte("2"), []byte("3"), []byte("4"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the list is read from the index, updated by the commit of the push.
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.LSet(listBucket, list, 0, []byte("0")); err != nil {
+			return err
+		}
+		if err := tx.LTrim(listBucket, list, 0, 2); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.LInsert(listBucket, list, true, []byte("3"), []byte("2.5"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		if err := db.View(func(tx *Tx) error {
+			for key, score := range map[string]float64{"a|b": 1.5, "a\x00": 2} {
+				n, err := tx.ZGetByKey(zsetBucket, []byte(key))
+				if err != nil {
+					return err
+				}
+				if float64(n.Score()) != score {
+					t.Errorf("err ZGetByKey %q. got score %v want %v", key, n.Score(), score)
+				}
+			}
+
+			items, err := tx.LRange(listBucket, list, 0, -1)
+			if err != nil {
+				return err
+			}
+			want := []string{"0", "2", "2.5", "3"}
+			if len(items) != len(want) {
+				t.Fatalf("err LRange. got %q want %q", items, want)
+			}
+			for i := range want {
+				if string(items[i]) != want[i] {
+					t.Errorf("err LRange. got %q want %q", items, want)
+					break
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	check()
+}
@@ -0,0 +1,177 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdt
+
+import (
+	"sort"
+)
+
+// GCounter represents a grow-only counter: every replica increments its own count,
+// and the value is the sum of the counts. It is not safe for concurrent use.
+type GCounter struct {
+	counts map[uint16]uint64
+}
+
+// NewGCounter returns a newly initialized GCounter of value 0.
+func NewGCounter() *GCounter {
+	return &GCounter{counts: make(map[uint16]uint64)}
+}
+
+// Increment adds n to the count of the replica, e.g. the Options.SiteID of its database.
+func (c *GCounter) Increment(replica uint16, n uint64) {
+	c.counts[replica] += n
+}
+
+// Value returns the value of the counter.
+func (c *GCounter) Value() uint64 {
+	var v uint64
+	for _, n := range c.counts {
+		v += n
+	}
+
+	return v
+}
+
+// Merge merges the counter of another replica, keeping the max count of every replica.
+func (c *GCounter) Merge(other *GCounter) {
+	for replica, n := range other.counts {
+		if n > c.counts[replica] {
+			c.counts[replica] = n
+		}
+	}
+}
+
+// mergeState implements the State interface.
+func (c *GCounter) mergeState(other State) error {
+	o, ok := other.(*GCounter)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	c.Merge(o)
+
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+//
+//	|----------------------------------------------------|
+//	| tag | count |  replica  |  value  | replica | ...  |
+//	|----------------------------------------------------|
+//	| 1B  | uvar  | uint16 BE |  uvar   |   ...   | ...  |
+//	|----------------------------------------------------|
+func (c *GCounter) MarshalBinary() ([]byte, error) {
+	return c.appendTo([]byte{tagGCounter}), nil
+}
+
+// appendTo appends the counts, sorted by replica, to buf.
+func (c *GCounter) appendTo(buf []byte) []byte {
+	replicas := make([]int, 0, len(c.counts))
+	for replica := range c.counts {
+		replicas = append(replicas, int(replica))
+	}
+	sort.Ints(replicas)
+
+	buf = appendUvarint(buf, uint64(len(replicas)))
+	for _, replica := range replicas {
+		buf = appendReplica(buf, uint16(replica))
+		buf = appendUvarint(buf, c.counts[uint16(replica)])
+	}
+
+	return buf
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (c *GCounter) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	d.tag(tagGCounter)
+	c.decode(d)
+	if d.err == nil && len(d.buf) != 0 {
+		return ErrInvalidState
+	}
+
+	return d.err
+}
+
+// decode reads the counts.
+func (c *GCounter) decode(d *decoder) {
+	c.counts = make(map[uint16]uint64)
+	for n := d.uvarint(); n > 0 && d.err == nil; n-- {
+		replica := d.replica()
+		c.counts[replica] = d.uvarint()
+	}
+}
+
+// PNCounter represents a counter incremented and decremented by every replica, made of
+// a GCounter of the increments and one of the decrements. It is not safe for concurrent use.
+type PNCounter struct {
+	p, n *GCounter
+}
+
+// NewPNCounter returns a newly initialized PNCounter of value 0.
+func NewPNCounter() *PNCounter {
+	return &PNCounter{p: NewGCounter(), n: NewGCounter()}
+}
+
+// Increment adds n to the counter on behalf of the replica.
+func (c *PNCounter) Increment(replica uint16, n uint64) {
+	c.p.Increment(replica, n)
+}
+
+// Decrement subtracts n from the counter on behalf of the replica.
+func (c *PNCounter) Decrement(replica uint16, n uint64) {
+	c.n.Increment(replica, n)
+}
+
+// Value returns the value of the counter.
+func (c *PNCounter) Value() int64 {
+	return int64(c.p.Value() - c.n.Value())
+}
+
+// Merge merges the counter of another replica.
+func (c *PNCounter) Merge(other *PNCounter) {
+	c.p.Merge(other.p)
+	c.n.Merge(other.n)
+}
+
+// mergeState implements the State interface.
+func (c *PNCounter) mergeState(other State) error {
+	o, ok := other.(*PNCounter)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	c.Merge(o)
+
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+// The tag is followed by the counts of the increments and of the decrements, see GCounter.
+func (c *PNCounter) MarshalBinary() ([]byte, error) {
+	return c.n.appendTo(c.p.appendTo([]byte{tagPNCounter})), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (c *PNCounter) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	d.tag(tagPNCounter)
+	c.p, c.n = NewGCounter(), NewGCounter()
+	c.p.decode(d)
+	c.n.decode(d)
+	if d.err == nil && len(d.buf) != 0 {
+		return ErrInvalidState
+	}
+
+	return d.err
+}
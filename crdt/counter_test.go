@@ -0,0 +1,74 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdt
+
+import (
+	"testing"
+)
+
+func TestGCounter(t *testing.T) {
+	a, b := NewGCounter(), NewGCounter()
+	a.Increment(1, 3)
+	b.Increment(2, 4)
+	b.Increment(1, 1)
+
+	a.Merge(b)
+	b.Merge(a)
+	// merging again changes nothing.
+	a.Merge(b)
+
+	if a.Value() != 7 || b.Value() != 7 {
+		t.Errorf("err GCounter Value. got %d and %d want 7", a.Value(), b.Value())
+	}
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewGCounter()
+	if err := c.UnmarshalBinary(data); err != nil || c.Value() != 7 {
+		t.Errorf("err GCounter UnmarshalBinary. got %d %v", c.Value(), err)
+	}
+
+	if err := c.UnmarshalBinary(data[:len(data)-1]); err != ErrInvalidState {
+		t.Errorf("err GCounter UnmarshalBinary of a truncated state. got %v want ErrInvalidState", err)
+	}
+}
+
+func TestPNCounter(t *testing.T) {
+	a, b := NewPNCounter(), NewPNCounter()
+	a.Increment(1, 5)
+	b.Decrement(2, 8)
+
+	a.Merge(b)
+	b.Merge(a)
+
+	if a.Value() != -3 || b.Value() != -3 {
+		t.Errorf("err PNCounter Value. got %d and %d want -3", a.Value(), b.Value())
+	}
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewPNCounter()
+	if err := c.UnmarshalBinary(data); err != nil || c.Value() != -3 {
+		t.Errorf("err PNCounter UnmarshalBinary. got %d %v", c.Value(), err)
+	}
+
+	if err := NewGCounter().UnmarshalBinary(data); err != ErrTypeMismatch {
+		t.Errorf("err GCounter UnmarshalBinary of a PNCounter. got %v want ErrTypeMismatch", err)
+	}
+}
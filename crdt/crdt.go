@@ -0,0 +1,222 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crdt provides conflict-free replicated data types stored in nutsdb buckets:
+// a G-Counter, a PN-Counter and an OR-Set. Every replica updates its own copy of a
+// value, and the copies converge whatever the order in which they are merged, e.g.
+// on edge devices synchronizing intermittently.
+//
+// The values are state-based: the state of a value on a replica is sent as is to the
+// others, e.g. the value of its entry read from a changefeed, and merged with Apply.
+//
+//	err := db.Update(func(tx *nutsdb.Tx) error {
+//		c := crdt.NewGCounter()
+//		if err := crdt.Load(tx, bucket, key, c); err != nil {
+//			return err
+//		}
+//		c.Increment(replica, 1)
+//		return crdt.Save(tx, bucket, key, c)
+//	})
+package crdt
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+var (
+	// ErrTypeMismatch is returned when a state is decoded or merged into a value of another type.
+	ErrTypeMismatch = errors.New("crdt: type mismatch")
+
+	// ErrInvalidState is returned when a state cannot be decoded.
+	ErrInvalidState = errors.New("crdt: invalid state")
+)
+
+// the type tags starting the encoded states.
+const (
+	tagGCounter byte = iota + 1
+	tagPNCounter
+	tagORSet
+)
+
+// State is the state of a replicated value, implemented by *GCounter, *PNCounter and *ORSet.
+type State interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+
+	// mergeState merges other, of the same type, into the state.
+	mergeState(other State) error
+}
+
+// Decode returns the state encoded by MarshalBinary, whatever its type.
+func Decode(data []byte) (State, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidState
+	}
+
+	var s State
+	switch data[0] {
+	case tagGCounter:
+		s = NewGCounter()
+	case tagPNCounter:
+		s = NewPNCounter()
+	case tagORSet:
+		s = NewORSet()
+	default:
+		return nil, ErrInvalidState
+	}
+
+	if err := s.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Load sets s to the state stored at key in the bucket, leaving it empty if the key is not found.
+func Load(tx *nutsdb.Tx, bucket string, key []byte, s State) error {
+	e, err := tx.Get(bucket, key)
+	if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrBucketNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.UnmarshalBinary(e.Value)
+}
+
+// Save stores the state s at key in the bucket.
+func Save(tx *nutsdb.Tx, bucket string, key []byte, s State) error {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return tx.Put(bucket, key, data, nutsdb.Persistent)
+}
+
+// Apply merges the encoded state of another replica into the state stored at key in the
+// bucket, storing it if the key is not found. It returns ErrTypeMismatch if the stored
+// state is of another type.
+func Apply(tx *nutsdb.Tx, bucket string, key []byte, data []byte) error {
+	remote, err := Decode(data)
+	if err != nil {
+		return err
+	}
+
+	e, err := tx.Get(bucket, key)
+	if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrBucketNotFound) {
+		return Save(tx, bucket, key, remote)
+	}
+	if err != nil {
+		return err
+	}
+
+	local, err := Decode(e.Value)
+	if err != nil {
+		return err
+	}
+
+	if err := local.mergeState(remote); err != nil {
+		return err
+	}
+
+	return Save(tx, bucket, key, local)
+}
+
+// appendUvarint appends the uvarint encoding of v to buf.
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+
+	return append(buf, b[:n]...)
+}
+
+// decoder reads the fields of an encoded state, recording the first error.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+// uvarint reads an uvarint.
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrInvalidState
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+// replica reads a replica ID.
+func (d *decoder) replica() uint16 {
+	if d.err != nil {
+		return 0
+	}
+
+	if len(d.buf) < 2 {
+		d.err = ErrInvalidState
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+
+	return v
+}
+
+// bytes reads a sized byte slice.
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+
+	if uint64(len(d.buf)) < n {
+		d.err = ErrInvalidState
+		return nil
+	}
+	v := append([]byte(nil), d.buf[:n]...)
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+// tag reads the type tag, checking it is want.
+func (d *decoder) tag(want byte) {
+	if len(d.buf) == 0 {
+		d.err = ErrInvalidState
+		return
+	}
+
+	if d.buf[0] != want {
+		d.err = ErrTypeMismatch
+		return
+	}
+	d.buf = d.buf[1:]
+}
+
+// appendReplica appends the replica ID to buf.
+func appendReplica(buf []byte, replica uint16) []byte {
+	return append(buf, byte(replica>>8), byte(replica))
+}
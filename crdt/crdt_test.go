@@ -0,0 +1,131 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdt
+
+import (
+	"os"
+	"testing"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+func openDB(t *testing.T, dir string) *nutsdb.DB {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = dir
+	opt.SegmentSize = 8 * 1024
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestApply(t *testing.T) {
+	bucket, key := "crdt", []byte("visits")
+
+	dbs := []*nutsdb.DB{openDB(t, "/tmp/nutsdbtestcrdt1"), openDB(t, "/tmp/nutsdbtestcrdt2")}
+	for _, db := range dbs {
+		defer db.Close()
+	}
+
+	// every replica increments its counter.
+	for i, db := range dbs {
+		if err := db.Update(func(tx *nutsdb.Tx) error {
+			c := NewPNCounter()
+			if err := Load(tx, bucket, key, c); err != nil {
+				return err
+			}
+			c.Increment(uint16(i+1), uint64(10*(i+1)))
+			c.Decrement(uint16(i+1), 1)
+			return Save(tx, bucket, key, c)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states := make([][]byte, len(dbs))
+	for i, db := range dbs {
+		if err := db.View(func(tx *nutsdb.Tx) error {
+			e, err := tx.Get(bucket, key)
+			if err != nil {
+				return err
+			}
+			states[i] = e.Value
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the replicas exchange their states, twice to check that applying is idempotent.
+	for i, db := range dbs {
+		for n := 0; n < 2; n++ {
+			if err := db.Update(func(tx *nutsdb.Tx) error {
+				return Apply(tx, bucket, key, states[1-i])
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i, db := range dbs {
+		if err := db.View(func(tx *nutsdb.Tx) error {
+			c := NewPNCounter()
+			if err := Load(tx, bucket, key, c); err != nil {
+				return err
+			}
+			if c.Value() != 28 {
+				t.Errorf("err replica %d value. got %d want 28", i+1, c.Value())
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a state of another type is not merged.
+	set := NewORSet()
+	set.Add(1, []byte("x"))
+	data, _ := set.MarshalBinary()
+	if err := dbs[0].Update(func(tx *nutsdb.Tx) error {
+		return Apply(tx, bucket, key, data)
+	}); err != ErrTypeMismatch {
+		t.Errorf("err Apply of another type. got %v want ErrTypeMismatch", err)
+	}
+
+	// a state of a key not found is stored.
+	if err := dbs[0].Update(func(tx *nutsdb.Tx) error {
+		return Apply(tx, bucket, []byte("members"), data)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbs[0].View(func(tx *nutsdb.Tx) error {
+		s := NewORSet()
+		if err := Load(tx, bucket, []byte("members"), s); err != nil {
+			return err
+		}
+		if !s.Contains([]byte("x")) {
+			t.Error("err Apply of a key not found")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
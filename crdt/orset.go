@@ -0,0 +1,213 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdt
+
+import (
+	"bytes"
+	"sort"
+)
+
+// dot identifies an add of an element by a replica.
+type dot struct {
+	replica uint16
+	counter uint64
+}
+
+// ORSet represents an observed-remove set: an element removed by a replica is only
+// removed for the adds the replica observed, so an add concurrent with a remove wins.
+// The removed adds are kept as tombstones. It is not safe for concurrent use.
+type ORSet struct {
+	clock   map[uint16]uint64           // the last counter of the dots of every replica
+	adds    map[string]map[dot]struct{} // the dots of the elements not removed
+	removed map[dot]struct{}
+}
+
+// NewORSet returns a newly initialized empty ORSet.
+func NewORSet() *ORSet {
+	return &ORSet{
+		clock:   make(map[uint16]uint64),
+		adds:    make(map[string]map[dot]struct{}),
+		removed: make(map[dot]struct{}),
+	}
+}
+
+// Add adds the element on behalf of the replica.
+func (s *ORSet) Add(replica uint16, element []byte) {
+	s.clock[replica]++
+	s.addDot(string(element), dot{replica: replica, counter: s.clock[replica]})
+}
+
+// addDot records the dot of an add of the element, unless it is removed.
+func (s *ORSet) addDot(element string, d dot) {
+	if _, ok := s.removed[d]; ok {
+		return
+	}
+
+	if s.adds[element] == nil {
+		s.adds[element] = make(map[dot]struct{})
+	}
+	s.adds[element][d] = struct{}{}
+}
+
+// Remove removes the element, i.e. the adds of it observed so far.
+func (s *ORSet) Remove(element []byte) {
+	for d := range s.adds[string(element)] {
+		s.removed[d] = struct{}{}
+	}
+	delete(s.adds, string(element))
+}
+
+// Contains returns if the set contains the element.
+func (s *ORSet) Contains(element []byte) bool {
+	return len(s.adds[string(element)]) > 0
+}
+
+// Elements returns the elements of the set, sorted bytewise.
+func (s *ORSet) Elements() [][]byte {
+	elements := make([][]byte, 0, len(s.adds))
+	for element := range s.adds {
+		elements = append(elements, []byte(element))
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return bytes.Compare(elements[i], elements[j]) < 0
+	})
+
+	return elements
+}
+
+// Merge merges the set of another replica.
+func (s *ORSet) Merge(other *ORSet) {
+	for replica, counter := range other.clock {
+		if counter > s.clock[replica] {
+			s.clock[replica] = counter
+		}
+	}
+
+	for d := range other.removed {
+		s.removed[d] = struct{}{}
+	}
+
+	for element, dots := range s.adds {
+		for d := range dots {
+			if _, ok := s.removed[d]; ok {
+				delete(dots, d)
+			}
+		}
+		if len(dots) == 0 {
+			delete(s.adds, element)
+		}
+	}
+
+	for element, dots := range other.adds {
+		for d := range dots {
+			s.addDot(element, d)
+		}
+	}
+}
+
+// mergeState implements the State interface.
+func (s *ORSet) mergeState(other State) error {
+	o, ok := other.(*ORSet)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	s.Merge(o)
+
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+//
+//	|-----------------------------------------------------------------------------|
+//	| tag | clock, see GCounter | elementCount | element | dots | ... | tombstones |
+//	|-----------------------------------------------------------------------------|
+//	| 1B  |       []byte        |     uvar     | []byte  | dots | ... |    dots    |
+//	|-----------------------------------------------------------------------------|
+//
+// An element is its uvarint size followed by its bytes, and the dots are their uvarint
+// count followed by the replica and the uvarint counter of every dot.
+func (s *ORSet) MarshalBinary() ([]byte, error) {
+	buf := (&GCounter{counts: s.clock}).appendTo([]byte{tagORSet})
+
+	elements := s.Elements()
+	buf = appendUvarint(buf, uint64(len(elements)))
+	for _, element := range elements {
+		buf = appendUvarint(buf, uint64(len(element)))
+		buf = append(buf, element...)
+		buf = appendDots(buf, s.adds[string(element)])
+	}
+
+	return appendDots(buf, s.removed), nil
+}
+
+// appendDots appends the dots, sorted, to buf.
+func appendDots(buf []byte, dots map[dot]struct{}) []byte {
+	sorted := make([]dot, 0, len(dots))
+	for d := range dots {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].replica != sorted[j].replica {
+			return sorted[i].replica < sorted[j].replica
+		}
+		return sorted[i].counter < sorted[j].counter
+	})
+
+	buf = appendUvarint(buf, uint64(len(sorted)))
+	for _, d := range sorted {
+		buf = appendReplica(buf, d.replica)
+		buf = appendUvarint(buf, d.counter)
+	}
+
+	return buf
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (s *ORSet) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	d.tag(tagORSet)
+
+	clock := NewGCounter()
+	clock.decode(d)
+
+	adds := make(map[string]map[dot]struct{})
+	for n := d.uvarint(); n > 0 && d.err == nil; n-- {
+		element := string(d.bytes())
+		adds[element] = decodeDots(d)
+	}
+
+	removed := decodeDots(d)
+	if d.err == nil && len(d.buf) != 0 {
+		return ErrInvalidState
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	s.clock, s.adds, s.removed = clock.counts, adds, removed
+
+	return nil
+}
+
+// decodeDots reads the dots.
+func decodeDots(d *decoder) map[dot]struct{} {
+	dots := make(map[dot]struct{})
+	for n := d.uvarint(); n > 0 && d.err == nil; n-- {
+		replica := d.replica()
+		dots[dot{replica: replica, counter: d.uvarint()}] = struct{}{}
+	}
+
+	return dots
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestORSet(t *testing.T) {
+	a, b := NewORSet(), NewORSet()
+	a.Add(1, []byte("x"))
+	a.Add(1, []byte("y"))
+	b.Merge(a)
+
+	// b removes x while a adds it again: the add b did not observe wins.
+	b.Remove([]byte("x"))
+	a.Add(1, []byte("x"))
+	// y is removed by both.
+	a.Remove([]byte("y"))
+	b.Remove([]byte("y"))
+	b.Add(2, []byte("z"))
+
+	a.Merge(b)
+	b.Merge(a)
+
+	want := [][]byte{[]byte("x"), []byte("z")}
+	if got := a.Elements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("err ORSet Elements of a. got %q want %q", got, want)
+	}
+	if got := b.Elements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("err ORSet Elements of b. got %q want %q", got, want)
+	}
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewORSet()
+	if err := c.UnmarshalBinary(data); err != nil || !reflect.DeepEqual(c.Elements(), want) {
+		t.Errorf("err ORSet UnmarshalBinary. got %q %v", c.Elements(), err)
+	}
+
+	// the tombstones are kept, so merging an old state does not add y back.
+	old := NewORSet()
+	old.Add(1, []byte("y"))
+	old.clock[1] = 0
+	c.Merge(old)
+	if c.Contains([]byte("y")) {
+		t.Error("err ORSet Merge of a removed add")
+	}
+}
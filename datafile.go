@@ -17,17 +17,22 @@ package nutsdb
 import (
 	"encoding/binary"
 	"errors"
+	"sync/atomic"
 )
 
 var (
 	// ErrCrcZero is returned when crc is 0
-	ErrCrcZero = errors.New("error crc is 0")
+	ErrCrcZero = wrapError("error crc is 0", ErrCorrupted)
 
 	// ErrCrc is returned when crc is error
-	ErrCrc = errors.New(" crc error")
+	ErrCrc = wrapError(" crc error", ErrCorrupted)
 
 	// ErrCapacity is returned when capacity is error.
 	ErrCapacity = errors.New("capacity error")
+
+	// ErrDataFileClosed is returned when reading or writing a data file after its Close,
+	// e.g. the ActiveFile of a closed db. It unwraps to ErrDBClosed.
+	ErrDataFileClosed = wrapError("data file is closed", ErrDBClosed)
 )
 
 const (
@@ -45,28 +50,30 @@ type DataFile struct {
 	writeOff   int64
 	ActualSize int64
 	rwManager  RWManager
+	closed     bool
+	format     atomic.Uint32 // the RecordFormat plus one, 0 until known, see recordFormat
+	buckets    *bucketDict   // the buckets of the IDs stored by the frames flagged frameBucketIDs
 }
 
 // NewDataFile returns a newly initialized DataFile object.
 func NewDataFile(path string, capacity int64, rwMode RWMode) (df *DataFile, err error) {
-	var rwManager RWManager
+	return newDataFileWithFactory(path, capacity, rwMode, nil)
+}
 
+// newDataFileWithFactory returns a newly initialized DataFile object whose
+// RWManager is created by factory, falling back to NewRWManager if it is nil.
+func newDataFileWithFactory(path string, capacity int64, rwMode RWMode, factory RWManagerFactory) (df *DataFile, err error) {
 	if capacity <= 0 {
 		return nil, ErrCapacity
 	}
 
-	if rwMode == FileIO {
-		rwManager, err = NewFileIORWManager(path, capacity)
-		if err != nil {
-			return nil, err
-		}
+	if factory == nil {
+		factory = NewRWManager
 	}
 
-	if rwMode == MMap {
-		rwManager, err = NewMMapRWManager(path, capacity)
-		if err != nil {
-			return nil, err
-		}
+	rwManager, err := factory(path, capacity, rwMode)
+	if err != nil {
+		return nil, err
 	}
 
 	return &DataFile{
@@ -79,6 +86,26 @@ func NewDataFile(path string, capacity int64, rwMode RWMode) (df *DataFile, err
 
 // ReadAt returns entry at the given off(offset).
 func (df *DataFile) ReadAt(off int) (e *Entry, err error) {
+	e, err = df.readAt(off)
+	if err != nil {
+		return nil, newEntryError(df.fileID, int64(off), err)
+	}
+
+	return e, nil
+}
+
+// readAt reads the entry at given off.
+func (df *DataFile) readAt(off int) (e *Entry, err error) {
+	if df.closed {
+		return nil, ErrDataFileClosed
+	}
+
+	if f, _, err := df.recordFormat(); err != nil {
+		return nil, err
+	} else if f.framed() {
+		return df.readFrameEntryAt(off, f)
+	}
+
 	buf := make([]byte, DataEntryHeaderSize)
 
 	if _, err := df.rwManager.ReadAt(buf, int64(off)); err != nil {
@@ -96,37 +123,17 @@ func (df *DataFile) ReadAt(off int) (e *Entry, err error) {
 		return nil, nil
 	}
 
-	// read bucket
-	off += DataEntryHeaderSize
-	bucketBuf := make([]byte, meta.bucketSize)
-	_, err = df.rwManager.ReadAt(bucketBuf, int64(off))
-	if err != nil {
+	// read bucket, key and value at once
+	payload := make([]byte, int(meta.bucketSize)+int(meta.keySize)+int(meta.valueSize))
+	if _, err = df.rwManager.ReadAt(payload, int64(off+DataEntryHeaderSize)); err != nil {
 		return nil, err
 	}
 
-	e.Meta.bucket = bucketBuf
-
-	// read key
-	off += int(meta.bucketSize)
-	keyBuf := make([]byte, meta.keySize)
+	e.Meta.bucket = payload[:meta.bucketSize]
+	e.Key = payload[meta.bucketSize : meta.bucketSize+meta.keySize]
+	e.Value = payload[meta.bucketSize+meta.keySize:]
 
-	_, err = df.rwManager.ReadAt(keyBuf, int64(off))
-	if err != nil {
-		return nil, err
-	}
-	e.Key = keyBuf
-
-	// read value
-	off += int(meta.keySize)
-	valBuf := make([]byte, meta.valueSize)
-	_, err = df.rwManager.ReadAt(valBuf, int64(off))
-	if err != nil {
-		return nil, err
-	}
-	e.Value = valBuf
-
-	crc := e.GetCrc(buf)
-	if crc != e.crc {
+	if checksum(buf, payload) != e.crc {
 		return nil, ErrCrc
 	}
 
@@ -136,6 +143,10 @@ func (df *DataFile) ReadAt(off int) (e *Entry, err error) {
 // WriteAt copies data to mapped region from the b slice starting at
 // given off and returns number of bytes copied to the mapped region.
 func (df *DataFile) WriteAt(b []byte, off int64) (n int, err error) {
+	if df.closed {
+		return 0, ErrDataFileClosed
+	}
+
 	return df.rwManager.WriteAt(b, off)
 }
 
@@ -143,6 +154,10 @@ func (df *DataFile) WriteAt(b []byte, off int64) (n int, err error) {
 // Typically, this means flushing the file system's in-memory copy
 // of recently written data to disk.
 func (df *DataFile) Sync() (err error) {
+	if df.closed {
+		return ErrDataFileClosed
+	}
+
 	return df.rwManager.Sync()
 }
 
@@ -151,21 +166,42 @@ func (df *DataFile) Sync() (err error) {
 // rendering it unusable for I/O.
 // If RWManager is a MMapRWManager represents Unmap deletes the memory mapped region,
 // flushes any remaining changes.
+// Closing it again returns ErrDataFileClosed.
 func (df *DataFile) Close() (err error) {
+	if df.closed {
+		return ErrDataFileClosed
+	}
+
+	df.closed = true
+
 	return df.rwManager.Close()
 }
 
 // readMetaData returns MetaData at given buf slice.
 func readMetaData(buf []byte) *MetaData {
-	return &MetaData{
-		timestamp:  binary.LittleEndian.Uint64(buf[4:12]),
+	meta := &MetaData{}
+	decodeMetaData(meta, buf)
+
+	return meta
+}
+
+// decodeMetaData sets meta to the MetaData at given buf slice.
+func decodeMetaData(meta *MetaData, buf []byte) {
+	status := binary.LittleEndian.Uint16(buf[30:32])
+	ds := binary.LittleEndian.Uint16(buf[32:34])
+	timestamp, seq := unpackTimestamp(binary.LittleEndian.Uint64(buf[4:12]))
+
+	*meta = MetaData{
+		timestamp:  timestamp,
 		keySize:    binary.LittleEndian.Uint32(buf[12:16]),
 		valueSize:  binary.LittleEndian.Uint32(buf[16:20]),
 		Flag:       binary.LittleEndian.Uint16(buf[20:22]),
 		TTL:        binary.LittleEndian.Uint32(buf[22:26]),
 		bucketSize: binary.LittleEndian.Uint32(buf[26:30]),
-		status:     binary.LittleEndian.Uint16(buf[30:32]),
-		ds:         binary.LittleEndian.Uint16(buf[32:34]),
+		status:     status & 0xff,
+		ds:         ds & 0xff,
 		txID:       binary.LittleEndian.Uint64(buf[34:42]),
+		siteID:     status>>8 | ds&0xff00,
+		seq:        seq,
 	}
 }
@@ -0,0 +1,205 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// DataFileReader reads the entries of a DataFile sequentially through a buffer,
+// so that one syscall serves many entries instead of several per entry.
+type DataFileReader struct {
+	r        *bufio.Reader
+	fileID   int64
+	off      int64
+	entryOff int64
+	format   RecordFormat
+	buckets  *bucketDict               // resolves the bucket IDs of the frames
+	header   [DataEntryHeaderSize]byte // reused by every entry, the meta being decoded from it
+	arena    *recordArena              // allocates the entries, nil for one by one
+	frame    []*Entry                  // the entries of the frame read not returned yet
+	offs     []int64                   // the offsets of the entries of frame
+	err      error                     // the error reading the format of the file
+	torn     bool                      // the last Next ended the file in a record not written completely
+	corrupt  int64                     // the size of the record failing its checksum returned by the last Next
+}
+
+// NewDataFileReader returns a newly initialized DataFileReader reading the
+// first capacity bytes of df through a buffer of bufSize bytes.
+func NewDataFileReader(df *DataFile, capacity int64, bufSize int) *DataFileReader {
+	return newDataFileReaderAt(df, 0, capacity, bufSize)
+}
+
+// newDataFileReaderAt returns a DataFileReader reading the first capacity bytes of df from off.
+func newDataFileReaderAt(df *DataFile, off, capacity int64, bufSize int) *DataFileReader {
+	format, _, err := df.recordFormat()
+	if format.framed() && off < dataFileHeaderSize {
+		off = dataFileHeaderSize
+	}
+
+	return &DataFileReader{
+		r:       bufio.NewReaderSize(io.NewSectionReader(df.rwManager, off, capacity-off), bufSize),
+		fileID:  df.fileID,
+		off:     off,
+		format:  format,
+		buckets: df.buckets,
+		err:     err,
+	}
+}
+
+// Offset returns the offset of the next record, the next entry, or the next frame
+// once the entries of a frame are read.
+func (dr *DataFileReader) Offset() int64 {
+	return dr.off
+}
+
+// EntryOffset returns the offset of the entry last returned by Next.
+func (dr *DataFileReader) EntryOffset() int64 {
+	return dr.entryOff
+}
+
+// Next returns the next entry.
+// It returns nil entry at the zero tail of the file and io.EOF at its end,
+// and an EntryError when the entry cannot be read.
+func (dr *DataFileReader) Next() (e *Entry, err error) {
+	e, err = dr.next()
+	if err != nil {
+		return nil, newEntryError(dr.fileID, dr.off, err)
+	}
+
+	return e, nil
+}
+
+func (dr *DataFileReader) next() (e *Entry, err error) {
+	if dr.err != nil {
+		return nil, dr.err
+	}
+	dr.torn, dr.corrupt = false, 0
+
+	if dr.format.framed() {
+		return dr.nextFrameEntry()
+	}
+
+	buf := dr.header[:]
+	if err := dr.readFull(buf); err != nil {
+		return nil, err
+	}
+
+	meta := dr.arena.readMetaData(buf)
+
+	e = dr.arena.newEntry()
+	e.crc = binary.LittleEndian.Uint32(buf[0:4])
+	e.Meta = meta
+
+	if e.IsZero() {
+		return nil, nil
+	}
+
+	// read bucket, key and value at once
+	payload := make([]byte, int(meta.bucketSize)+int(meta.keySize)+int(meta.valueSize))
+	if err := dr.readFull(payload); err != nil {
+		dr.torn = err == io.EOF
+		return nil, err
+	}
+
+	e.Meta.bucket = payload[:meta.bucketSize]
+	e.Key = payload[meta.bucketSize : meta.bucketSize+meta.keySize]
+	e.Value = payload[meta.bucketSize+meta.keySize:]
+
+	if checksum(buf, payload) != e.crc {
+		dr.corrupt = e.Size()
+		return nil, ErrCrc
+	}
+
+	dr.entryOff = dr.off
+	dr.off += e.Size()
+
+	return e, nil
+}
+
+// nextFrameEntry returns the next entry of the frame being read, reading the next frame
+// at once if all its entries were returned.
+func (dr *DataFileReader) nextFrameEntry() (*Entry, error) {
+	for len(dr.frame) == 0 {
+		buf := dr.header[:frameHeaderSize]
+		if err := dr.readFull(buf); err != nil {
+			return nil, err
+		}
+
+		h, err := decodeFrameHeader(buf)
+		if err != nil || h == nil {
+			return nil, err
+		}
+
+		size := int(h.size)
+		if h.flags&frameCommitted != 0 {
+			size += frameMarkerSize
+		}
+		body := make([]byte, size)
+		if err := dr.readFull(body); err != nil {
+			dr.torn = err == io.EOF
+			return nil, err
+		}
+
+		// a torn frame, its commit marker not written, ends the file like its zero tail.
+		if h.flags&frameCommitted != 0 && bytes.Equal(body[h.size:], make([]byte, frameMarkerSize)) {
+			dr.torn = true
+			return nil, nil
+		}
+
+		dr.frame, dr.offs, err = decodeFrame(dr.format, h, body, dr.off+frameHeaderSize, dr.buckets, dr.arena, dr.frame[:0], dr.offs[:0])
+		if err != nil {
+			dr.corrupt = frameHeaderSize + int64(size)
+			return nil, err
+		}
+		dr.off += frameHeaderSize + int64(size)
+	}
+
+	e := dr.frame[0]
+	dr.entryOff = dr.offs[0]
+	dr.frame, dr.offs = dr.frame[1:], dr.offs[1:]
+
+	return e, nil
+}
+
+// readFull reads exactly len(b) bytes, reporting a truncated entry as io.EOF, torn
+// unless the bytes read are the zero tail of the file.
+func (dr *DataFileReader) readFull(b []byte) error {
+	if n, err := io.ReadFull(dr.r, b); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			dr.torn = !bytes.Equal(b[:n], make([]byte, n))
+			return io.EOF
+		}
+		return err
+	}
+
+	return nil
+}
+
+// skipCorrupt skips the record failing its checksum returned by the last Next and returns
+// its size, or false if it cannot be skipped, its size being unknown.
+func (dr *DataFileReader) skipCorrupt() (int64, bool) {
+	size := dr.corrupt
+	if size == 0 {
+		return 0, false
+	}
+	dr.corrupt = 0
+	dr.off += size
+
+	return size, true
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDataFileReader_Next(t *testing.T) {
+	path := "/tmp/nutsdbtestdatafilereader"
+	defer os.Remove(path)
+
+	for _, rwMode := range []RWMode{FileIO, MMap} {
+		os.Remove(path)
+		df, err := NewDataFile(path, 1024, rwMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var off int64
+		for i := 0; i < 3; i++ {
+			n, err := df.WriteAt(entry.Encode(), off)
+			if err != nil {
+				t.Fatal(err)
+			}
+			off += int64(n)
+		}
+
+		// a tiny buffer makes the entries span several reads
+		r := NewDataFileReader(df, 1024, 16)
+		num := 0
+		for {
+			e, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e == nil {
+				break
+			}
+			if string(e.Key) != string(entry.Key) || string(e.Value) != string(entry.Value) {
+				t.Errorf("err Next. got %s %s", e.Key, e.Value)
+			}
+			num++
+		}
+
+		if num != 3 || r.Offset() != off {
+			t.Errorf("err Next. got %d entries at %d want 3 at %d", num, r.Offset(), off)
+		}
+
+		df.rwManager.Close()
+	}
+}
+
+func TestDataFileReader_EOF(t *testing.T) {
+	path := "/tmp/nutsdbtestdatafilereadereof"
+	defer os.Remove(path)
+
+	size := entry.Size()
+	df, err := NewDataFile(path, size+DataEntryHeaderSize/2, FileIO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.rwManager.Close()
+
+	if _, err := df.WriteAt(entry.Encode(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := df.WriteAt([]byte{1}, size); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewDataFileReader(df, size+DataEntryHeaderSize/2, 64)
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("err Next. got %v want %v", err, io.EOF)
+	}
+}
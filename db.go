@@ -15,10 +15,11 @@
 package nutsdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"io/fs"
 	"os"
 	"path"
 	"sort"
@@ -36,8 +37,11 @@ var (
 	// ErrDBClosed is returned when db is closed.
 	ErrDBClosed = errors.New("db is closed")
 
+	// ErrReadOnly is returned when writing to a db opened with Options.ReadOnly.
+	ErrReadOnly = errors.New("db is read-only")
+
 	// ErrBucket is returned when bucket is not in the HintIdx.
-	ErrBucket = errors.New("err bucket")
+	ErrBucket = wrapError("err bucket", ErrBucketNotFound)
 
 	// ErrEntryIdxModeOpt is returned when set db EntryIdxMode option is wrong.
 	ErrEntryIdxModeOpt = errors.New("err EntryIdxMode option set")
@@ -88,6 +92,21 @@ const (
 
 	// DataZPopMinFlag represents the data aZPopMin flag
 	DataZPopMinFlag
+
+	// DataZRemRangeByLexFlag represents the data ZRemRangeByLex flag
+	DataZRemRangeByLexFlag
+
+	// DataLInsertFlag represents the data LInsert flag
+	DataLInsertFlag
+
+	// DataLMoveFlag represents the data LMove flag
+	DataLMoveFlag
+
+	// DataAppendFlag represents the data Append flag
+	DataAppendFlag
+
+	// DataSetRangeFlag represents the data SetRange flag
+	DataSetRangeFlag
 )
 
 const (
@@ -133,10 +152,46 @@ type (
 		ActiveCommittedTxIdsIdx *BPTree
 		committedTxIds          map[uint64]struct{}
 		MaxFileID               int64
-		mu                      sync.RWMutex
-		KeyCount                int // total key number ,include expired, deleted, repeated.
+		mu                      sync.RWMutex // guards the indexes of the buckets, their contents also by bucketLocks
+		bucketLocks             bucketLocks  // the locks of the indexes of the buckets, see Tx.lockIndexes
+		txIDsMu                 sync.RWMutex // guards committedTxIds and lastTxID
+		writeMu                 writeLock    // serializes the writable transactions
+		KeyCount                int          // total key number ,include expired, deleted, repeated.
 		closed                  bool
 		isMerging               bool
+		merging                 int32  // 1 while a merge runs, see startMerge
+		activeHints             []byte // encoded hint records of the active file
+		indexMemory             *indexMemory
+		keyComparatorNames      map[string]string // the comparator name of every bucket
+		keyComparatorNamesDirty bool
+		ids                     *idGenerator // the generator of the tx IDs
+		autoBackupStop          chan struct{}
+		autoBackupDone          chan struct{}
+		txLeakStop              chan struct{}
+		txLeakDone              chan struct{}
+		fsys                    fs.FS   // the file system of OpenFS, nil for the OS one
+		storage                 Storage // the files written, see Options.Storage
+		registryKey             string  // the dir of a DB shared by OpenOnce
+		refs                    int     // the references to a DB shared by OpenOnce
+		health                  healthState
+		sealedSize              int64 // SegmentSize for every data file but the active one
+		throttle                *writeThrottle
+		wal                     *wal                   // the write-ahead log of Options.WAL, nil if disabled
+		buckets                 *bucketDict            // the IDs of the buckets, see Options.BucketDictionary
+		skippedRecords          []SkippedRecord        // the records skipped by Open, see Options.RecoveryMode
+		admin                   adminServer            // the server of ServeAdmin
+		fileCounters            map[int64]*fileCounter // loaded by the first FileStats
+		repairs                 indexRepairs           // the index repairs found by ParanoidChecks
+		lastTxID                uint64                 // the ID of the last committed transaction
+		filesRemoved            chan struct{}          // closed when a merge removes a data file
+		nodeCache               *indexNodeCache        // the nodes of the index files, see Options.IndexCacheSize
+		lazyBuckets             map[string]*lazyBucket // the buckets not accessed yet, see Options.LazyIndexLoad
+		openTxs                 openTxs                // the transactions not committed or rolled back yet
+		follower                *follower              // the tailing of the data files, see OpenFollower
+		cdc                     *cdcShipper            // the shipping of the changes, see Options.CDC
+		bucket                  string                 // the only bucket written, for the DB of a bucket of a BucketDB
+		flights                 flightGroup            // the loads of the missing keys, see GetThrough
+		stamps                  stampClock             // the stamps of the writes, see ApplySyncDelta
 	}
 
 	// BPTreeIdx represents the B+ tree index
@@ -157,6 +212,11 @@ type (
 
 // Open returns a newly initialized DB object.
 func Open(opt Options) (*DB, error) {
+	return open(opt, nil)
+}
+
+// open opens the database at opt.Dir, in fsys if it is not nil.
+func open(opt Options, fsys fs.FS) (*DB, error) {
 	db := &DB{
 		BPTreeIdx:               make(BPTreeIdx),
 		SetIdx:                  make(SetIdx),
@@ -170,10 +230,24 @@ func Open(opt Options) (*DB, error) {
 		committedTxIds:          make(map[uint64]struct{}),
 		BPTreeKeyEntryPosMap:    make(map[string]int64),
 		ActiveCommittedTxIdsIdx: NewTree(),
+		keyComparatorNames:      make(map[string]string),
+		fsys:                    fsys,
+		storage:                 opt.Storage,
+		throttle:                newWriteThrottle(opt),
+	}
+
+	if db.storage == nil {
+		db.storage = osStorage{}
+	} else if fsys == nil && opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, &ModeError{Reason: "not support Options.Storage in mode `HintBPTSparseIdxMode`", Mode: opt.EntryIdxMode}
 	}
 
-	if ok := filesystem.PathIsExist(db.opt.Dir); !ok {
-		if err := os.MkdirAll(db.opt.Dir, os.ModePerm); err != nil {
+	if opt.EntryIdxMode == HintBPTSparseIdxMode && fsys == nil {
+		db.nodeCache = newIndexNodeCache(opt.IndexCacheSize)
+	}
+
+	if _, err := db.storage.Stat(db.opt.Dir); os.IsNotExist(err) && !opt.ReadOnly {
+		if err := db.storage.MkdirAll(db.opt.Dir, db.dirPerm()); err != nil {
 			return nil, err
 		}
 	}
@@ -182,26 +256,92 @@ func Open(opt Options) (*DB, error) {
 		return nil, err
 	}
 
+	if !opt.ReadOnly {
+		if err := db.removeTempFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.openWAL(); err != nil {
+		return nil, err
+	}
+
+	if err := db.openBucketDict(); err != nil {
+		return nil, err
+	}
+
+	ids, err := newIDGenerator(opt, db.storage, db.filePerm())
+	if err != nil {
+		return nil, err
+	}
+	db.ids = ids
+
+	if opt.EntryIdxMode == HintBPTSparseIdxMode && opt.KeyComparator != nil {
+		return nil, &ModeError{Reason: "not support KeyComparator in mode `HintBPTSparseIdxMode`", Mode: opt.EntryIdxMode}
+	}
+
+	if db.lazyIndexLoad() {
+		db.lazyBuckets = make(map[string]*lazyBucket)
+	}
+
+	if opt.EntryIdxMode == HintKeyValAndRAMIdxMode && opt.MaxIndexMemory > 0 {
+		db.indexMemory = newIndexMemory(opt.MaxIndexMemory)
+	}
+
 	if opt.EntryIdxMode == HintBPTSparseIdxMode {
 		bptRootIdxDir := db.opt.Dir + "/" + bptDir + "/root"
 		if ok := filesystem.PathIsExist(bptRootIdxDir); !ok {
-			if err := os.MkdirAll(bptRootIdxDir, os.ModePerm); err != nil {
+			if err := os.MkdirAll(bptRootIdxDir, db.dirPerm()); err != nil {
 				return nil, err
 			}
 		}
 
 		bptTxIDIdxDir := db.opt.Dir + "/" + bptDir + "/txid"
 		if ok := filesystem.PathIsExist(bptTxIDIdxDir); !ok {
-			if err := os.MkdirAll(bptTxIDIdxDir, os.ModePerm); err != nil {
+			if err := os.MkdirAll(bptTxIDIdxDir, db.dirPerm()); err != nil {
 				return nil, err
 			}
 		}
+
+		if err := db.migrateSparseIndex(); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := db.buildIndexes(); err != nil {
-		return nil, fmt.Errorf("db.buildIndexes error: %s", err)
+	db.health.setRecovering(true)
+	err = db.buildIndexes()
+	db.health.setRecovering(false)
+	if err != nil {
+		if opt.EntryIdxMode == HintBPTSparseIdxMode {
+			return nil, fmt.Errorf("db.buildIndexes error: %w (the bpt index files may be out of sync with the data files, see RebuildSparseIndex)", err)
+		}
+		return nil, fmt.Errorf("db.buildIndexes error: %w", err)
 	}
 
+	if err := db.checkKeyComparators(); err != nil {
+		db.ActiveFile.rwManager.Close()
+		return nil, err
+	}
+
+	if err := db.loadLastTxID(); err != nil {
+		db.ActiveFile.rwManager.Close()
+		return nil, err
+	}
+
+	if err := db.autoMerge(opt.CompactOnOpen); err != nil {
+		db.ActiveFile.rwManager.Close()
+		return nil, err
+	}
+
+	if err := db.startCDC(); err != nil {
+		db.ActiveFile.rwManager.Close()
+		return nil, err
+	}
+
+	db.startWAL()
+	db.startAutoBackup()
+	db.startTxLeakDetection()
+
 	return db, nil
 }
 
@@ -209,7 +349,7 @@ func (db *DB) checkEntryIdxMode() error {
 	hasDataFlag := false
 	hasBptDirFlag := false
 
-	files, err := ioutil.ReadDir(db.opt.Dir)
+	files, err := db.readDir(db.opt.Dir)
 	if err != nil {
 		return err
 	}
@@ -231,11 +371,11 @@ func (db *DB) checkEntryIdxMode() error {
 	}
 
 	if db.opt.EntryIdxMode != HintBPTSparseIdxMode && hasDataFlag && hasBptDirFlag {
-		return errors.New("not support HintBPTSparseIdxMode switch to the other EntryIdxMode")
+		return &ModeError{Reason: "not support HintBPTSparseIdxMode switch to the other EntryIdxMode", Mode: db.opt.EntryIdxMode}
 	}
 
 	if db.opt.EntryIdxMode == HintBPTSparseIdxMode && hasBptDirFlag == false && hasDataFlag == true {
-		return errors.New("not support the other EntryIdxMode switch to HintBPTSparseIdxMode")
+		return &ModeError{Reason: "not support the other EntryIdxMode switch to HintBPTSparseIdxMode", Mode: db.opt.EntryIdxMode}
 	}
 
 	return nil
@@ -247,7 +387,16 @@ func (db *DB) Update(fn func(tx *Tx) error) error {
 		return ErrFn
 	}
 
-	return db.managed(true, fn)
+	return db.managed(true, TxOptions{}, fn)
+}
+
+// UpdateWithOptions executes a function within a managed read/write transaction with the options.
+func (db *DB) UpdateWithOptions(opts TxOptions, fn func(tx *Tx) error) error {
+	if fn == nil {
+		return ErrFn
+	}
+
+	return db.managed(true, opts, fn)
 }
 
 // View executes a function within a managed read-only transaction.
@@ -256,7 +405,7 @@ func (db *DB) View(fn func(tx *Tx) error) error {
 		return ErrFn
 	}
 
-	return db.managed(false, fn)
+	return db.managed(false, TxOptions{}, fn)
 }
 
 // Merge removes dirty data and reduce data redundancy,following these steps:
@@ -272,96 +421,295 @@ func (db *DB) View(fn func(tx *Tx) error) error {
 // Caveat: Merge is Called means starting multiple write transactions, and it
 // will effect the other write request. so execute it at the appropriate time.
 func (db *DB) Merge() error {
-	var (
-		off                 int64
-		pendingMergeFIds    []int
-		pendingMergeEntries []*Entry
-	)
+	return db.MergeContext(context.Background(), nil)
+}
 
+// MergeContext merges like Merge, calling onProgress, if not nil, after every merged file.
+// It stops at the next file boundary when ctx is done and returns ctx.Err(),
+// the files merged so far staying merged.
+func (db *DB) MergeContext(ctx context.Context, onProgress func(MergeProgress)) error {
 	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
-		return errors.New("not support mode `HintBPTSparseIdxMode`")
+		return ErrNotSupportHintBPTSparseIdxMode
 	}
 
-	db.isMerging = true
+	if db.opt.ReadOnly {
+		return ErrReadOnly
+	}
 
-	_, pendingMergeFIds = db.getMaxFileIDAndFileIDs()
+	if err := db.checkClosed(); err != nil {
+		return err
+	}
+
+	if err := db.startMerge(); err != nil {
+		return err
+	}
+	defer db.endMerge()
+
+	_, pendingMergeFIds := db.getMaxFileIDAndFileIDs()
 
 	if len(pendingMergeFIds) < 2 {
-		db.isMerging = false
-		return errors.New("the number of files waiting to be merged is at least 2")
+		return ErrMergeFileCount
 	}
 
-	for _, pendingMergeFId := range pendingMergeFIds {
-		off = 0
-		f, err := NewDataFile(db.getDataPath(int64(pendingMergeFId)), db.opt.SegmentSize, db.opt.RWMode)
+	return db.mergeFiles(ctx, pendingMergeFIds, false, 1, onProgress)
+}
+
+// mergeFiles merges the data files, see mergeFile, checking ctx before every file.
+// Up to parallelism files are read at the same time, the files being rewritten and
+// removed one by one in order, see mergeScanner.
+func (db *DB) mergeFiles(ctx context.Context, fIDs []int, partial bool, parallelism int, onProgress func(MergeProgress)) error {
+	progress := MergeProgress{FilesTotal: len(fIDs)}
+
+	scanner := db.scanMergeFiles(fIDs, parallelism)
+	defer scanner.stop()
+
+	for i, fID := range fIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		s, err := scanner.next(i)
+		if err != nil {
+			return err
+		}
+
+		rewritten, err := db.rewriteMergeFile(s, partial)
+		scanner.release()
 		if err != nil {
-			db.isMerging = false
 			return err
 		}
 
-		pendingMergeEntries = []*Entry{}
+		progress.FileID = int64(fID)
+		progress.FilesDone++
+		if rewritten < db.opt.SegmentSize {
+			progress.BytesReclaimed += db.opt.SegmentSize - rewritten
+		}
 
-		for {
-			if entry, err := f.ReadAt(int(off)); err == nil {
-				if entry == nil {
-					break
-				}
+		if onProgress != nil {
+			onProgress(progress)
+		}
+	}
 
-				if db.isFilterEntry(entry) {
-					off += entry.Size()
-					if off >= db.opt.SegmentSize {
-						break
-					}
-					continue
-				}
+	return nil
+}
 
-				pendingMergeEntries = db.getPendingMergeEntries(entry, pendingMergeEntries)
+// appendMergeEntry appends the entries rewriting the entry at off of the data file fID to
+// pendingMergeEntries, if it is live, see mergeFile. The caller holds db.mu.
+func (db *DB) appendMergeEntry(entry *Entry, fID int64, off int64, folded map[string]struct{}, pendingMergeEntries []*Entry) ([]*Entry, error) {
+	if db.isFilterEntry(entry) {
+		return pendingMergeEntries, nil
+	}
 
-				off += entry.Size()
-				if off >= db.opt.SegmentSize {
-					break
-				}
+	pendingMergeEntries, handled, err := db.getDeltaMergeEntries(entry, folded, pendingMergeEntries)
+	if err != nil {
+		return nil, err
+	}
 
-			} else {
-				if err == io.EOF {
-					break
+	switch {
+	case handled:
+	case !db.keepOnCompaction(entry):
+		pendingMergeEntries = db.getCompactionTombstone(entry, fID, off, pendingMergeEntries)
+	default:
+		pendingMergeEntries = db.getPendingLiveMergeEntries(entry, fID, off, pendingMergeEntries)
+	}
+
+	return pendingMergeEntries, nil
+}
+
+// mergeFile rewrites the live entries of the data file fID and removes it,
+// returning the size of the rewritten entries.
+// Only the BPTree entries the index points to are rewritten. A partial merge
+// only merges sealed files, so it rewrites them into the active file, instead of
+// into temp segments published once committed, see reWriteData.
+func (db *DB) mergeFile(fID int, partial bool) (int64, error) {
+	s, err := db.scanMergeFile(fID)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.rewriteMergeFile(s, partial)
+}
+
+// scanMergeFile reads the live entries of the data file fID, see appendMergeEntry.
+func (db *DB) scanMergeFile(fID int) (*mergeScan, error) {
+	var off int64
+
+	f, err := db.openScanDataFile(int64(fID), db.opt.RWMode)
+	if err != nil {
+		return nil, err
+	}
+	defer closeScanDataFile(f)
+
+	s := &mergeScan{fID: fID}
+	pendingMergeEntries := []*Entry{}
+	folded := make(map[string]struct{})
+
+	r := db.newDataFileReader(f)
+	for {
+		if entry, err := r.Next(); err == nil {
+			if entry == nil {
+				break
+			}
+			off = r.EntryOffset()
+
+			// the index is read while the commits of other transactions update it,
+			// and is dropped by Close, so every entry would look stale.
+			db.mu.RLock()
+			if db.closed {
+				db.mu.RUnlock()
+				return nil, ErrDBClosed
+			}
+			unlock := db.rlockEntryBuckets(entry)
+			n := len(pendingMergeEntries)
+			pendingMergeEntries, err = db.appendMergeEntry(entry, int64(fID), off, folded, pendingMergeEntries)
+			if err == nil {
+				for _, e := range pendingMergeEntries[n:] {
+					s.positions = append(s.positions, db.indexPosition(e))
 				}
-				f.rwManager.Close()
-				return fmt.Errorf("when merge operation build hintIndex readAt err: %s", err)
 			}
-		}
+			unlock()
+			db.mu.RUnlock()
+			if err != nil {
+				return nil, err
+			}
 
-		if err := db.reWriteData(pendingMergeEntries); err != nil {
-			f.rwManager.Close()
-			return err
+		} else {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("when merge operation build hintIndex readAt err: %w", err)
 		}
+	}
 
-		if err := os.Remove(db.getDataPath(int64(pendingMergeFId))); err != nil {
-			db.isMerging = false
-			f.rwManager.Close()
-			return fmt.Errorf("when merge err: %s", err)
-		}
+	s.entries = pendingMergeEntries
 
-		f.rwManager.Close()
+	return s, nil
+}
+
+// rewriteMergeFile rewrites the entries read by scanMergeFile and removes their data
+// file, returning the size of the rewritten entries, see mergeFile.
+func (db *DB) rewriteMergeFile(s *mergeScan, partial bool) (int64, error) {
+	fID := s.fID
+	pendingMergeEntries := s.entries
+
+	var err error
+	if partial {
+		err = db.UpdateWithOptions(TxOptions{Priority: PriorityLow}, func(tx *Tx) error {
+			tx.merge = true
+			// the file may have been read while other files were rewritten.
+			db.mu.RLock()
+			pendingMergeEntries = s.liveEntries(db)
+			db.mu.RUnlock()
+			return tx.putEntries(pendingMergeEntries)
+		})
+	} else {
+		err = db.reWriteData(pendingMergeEntries)
+	}
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	var rewritten int64
+	for _, e := range pendingMergeEntries {
+		rewritten += e.Size()
+	}
+
+	// the entries of the last transactions may be removed with the file.
+	db.mu.RLock()
+	err = db.persistLastTxID()
+	db.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	afterMergeStep(mergeStepLastTxIDPersisted)
+
+	// the data files are read under db.mu, e.g. the deltas of a key by the files read
+	// ahead of their rewrites, which would miss the file.
+	db.mu.Lock()
+	err = db.storage.Remove(db.getDataPath(int64(fID)))
+	db.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("when merge err: %w", err)
+	}
+	afterMergeStep(mergeStepFileRemoved)
+
+	db.writeMu.Lock()
+	db.sealedSize -= db.opt.SegmentSize
+	if db.fileCounters != nil {
+		delete(db.fileCounters, int64(fID))
+	}
+	db.notifyFilesRemoved()
+	db.writeMu.Unlock()
+
+	if err := db.storage.Remove(db.getCheckpointPath()); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("when merge err: %w", err)
+	}
+	afterMergeStep(mergeStepCheckpointRemoved)
+
+	if err := db.storage.Remove(db.getHintPath(int64(fID))); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("when merge err: %w", err)
+	}
+	afterMergeStep(mergeStepHintRemoved)
+
+	return rewritten, nil
 }
 
-// Backup copies the database to file directory at the given dir.
+// Backup copies the database to file directory at the given dir, with a BackupManifest.
+// The sealed data files are copied as they are and the active file only up to the end of
+// the last committed transaction, so the backup holds no uncommitted or torn tail. The
+// writable transactions are only blocked while the active file is read.
 func (db *DB) Backup(dir string) error {
-	err := db.View(func(tx *Tx) error {
-		return filesystem.CopyDir(db.opt.Dir, dir)
-	})
-	if err != nil {
+	if err := db.checkOSFiles(); err != nil {
 		return err
 	}
 
-	return nil
+	db.writeMu.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			db.writeMu.Unlock()
+		}
+	}()
+
+	return db.View(func(tx *Tx) error {
+		m, active, err := db.snapshotActiveFile()
+		db.writeMu.Unlock()
+		locked = false
+		if err != nil {
+			return err
+		}
+
+		return db.copyBackup(dir, m, active)
+	})
 }
 
-// Close releases all db resources.
+// Close releases all db resources, after the merge of Options.MergeOnClose.
+// A DB returned by OpenOnce is only closed by the Close of its last reference.
 func (db *DB) Close() error {
+	if !db.release() {
+		return nil
+	}
+
+	mergeErr := db.autoMerge(db.opt.MergeOnClose)
+	if err := db.close(); err != nil {
+		return err
+	}
+
+	return mergeErr
+}
+
+// close releases all db resources.
+func (db *DB) close() error {
+	db.stopAdmin()
+	db.stopAutoBackup()
+	db.stopTxLeakDetection()
+	db.stopFollowing()
+	db.stopCDC()
+	db.stopWAL()
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -371,11 +719,32 @@ func (db *DB) Close() error {
 
 	db.closed = true
 
-	db.ActiveFile.rwManager.Close()
+	persistErr := db.persistLastTxID()
+	if err := db.nodeCache.close(); err != nil && persistErr == nil {
+		persistErr = err
+	}
+	if err := db.wal.close(); err != nil && persistErr == nil {
+		persistErr = err
+	}
+
+	db.ActiveFile.Close()
 
 	db.ActiveFile = nil
 
 	db.BPTreeIdx = nil
+	db.lazyBuckets = nil
+
+	return persistErr
+}
+
+// checkClosed returns ErrDBClosed if the db is closed.
+func (db *DB) checkClosed() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
 
 	return nil
 }
@@ -383,7 +752,7 @@ func (db *DB) Close() error {
 // setActiveFile sets the ActiveFile (DataFile object).
 func (db *DB) setActiveFile() (err error) {
 	filepath := db.getDataPath(db.MaxFileID)
-	db.ActiveFile, err = NewDataFile(filepath, db.opt.SegmentSize, db.opt.RWMode)
+	db.ActiveFile, err = db.newDataFile(filepath, db.opt.RWMode)
 	if err != nil {
 		return
 	}
@@ -395,7 +764,7 @@ func (db *DB) setActiveFile() (err error) {
 
 // getMaxFileIDAndFileIds returns max fileId and fileIds.
 func (db *DB) getMaxFileIDAndFileIDs() (maxFileID int64, dataFileIds []int) {
-	files, _ := ioutil.ReadDir(db.opt.Dir)
+	files, _ := db.readDir(db.opt.Dir)
 	if len(files) == 0 {
 		return 0, nil
 	}
@@ -426,30 +795,26 @@ func (db *DB) getMaxFileIDAndFileIDs() (maxFileID int64, dataFileIds []int) {
 
 // getActiveFileWriteOff returns the write offset of activeFile.
 func (db *DB) getActiveFileWriteOff() (off int64, err error) {
-	off = 0
+	r := db.newDataFileReader(db.ActiveFile)
 	for {
-		if item, err := db.ActiveFile.ReadAt(int(off)); err == nil {
-			if item == nil {
-				break
-			}
-
-			off += item.Size()
-			//set ActiveFileActualSize
-			db.ActiveFile.ActualSize = off
-
-		} else {
-			if err == io.EOF {
-				break
-			}
-
-			return -1, fmt.Errorf("when build activeDataIndex readAt err: %s", err)
+		item, err := db.recoverNext(r, db.ActiveFile, true)
+		if err != nil {
+			return -1, fmt.Errorf("when build activeDataIndex readAt err: %w", err)
+		}
+		if item == nil {
+			break
 		}
 	}
 
+	// the corrupt records skipped at the end of the file are overwritten.
+	off = r.Offset()
+	//set ActiveFileActualSize
+	db.ActiveFile.ActualSize = off
+
 	return
 }
 
-func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, committedTxIds map[uint64]struct{}, err error) {
+func (db *DB) parseDataFiles(dataFileIds []int, cp *indexCheckpoint) (unconfirmedRecords []*Record, committedTxIds map[uint64]struct{}, err error) {
 	var (
 		off int64
 		e   *Entry
@@ -457,6 +822,9 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 
 	committedTxIds = make(map[uint64]struct{})
 
+	// the records are allocated in slabs, the arena being dropped on return.
+	arena := &recordArena{}
+
 	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		dataFileIds = dataFileIds[len(dataFileIds)-1:]
 	}
@@ -464,24 +832,59 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 	for _, dataID := range dataFileIds {
 		off = 0
 		fID := int64(dataID)
-		f, err := NewDataFile(db.getDataPath(fID), db.opt.SegmentSize, db.opt.StartFileLoadingMode)
+		if cp != nil && fID < cp.fileID {
+			continue
+		}
+
+		if db.isHintEnabled() && fID != db.MaxFileID {
+			if hints, err := db.readHintFile(fID, arena); err == nil {
+				if cp != nil && fID == cp.fileID {
+					hints = skipCheckpointHints(hints, cp.off)
+				}
+				unconfirmedRecords = db.appendHintRecords(unconfirmedRecords, hints, committedTxIds, arena)
+				continue
+			}
+		}
+
+		var f *DataFile
+		if fID == db.MaxFileID {
+			f, err = db.openDataFile(fID, db.opt.StartFileLoadingMode)
+			if err == nil {
+				f.advise(adviceSequential)
+			}
+		} else {
+			f, err = db.openScanDataFile(fID, db.opt.StartFileLoadingMode)
+		}
 		if err != nil {
 			return nil, nil, err
 		}
 
+		// the pages of the active file are kept for the reads following the recovery.
+		closeFile := closeScanDataFile
+		if fID == db.MaxFileID {
+			closeFile = (*DataFile).Close
+		}
+
+		r := db.newDataFileReader(f)
+		r.arena = arena
 		for {
-			if entry, err := f.ReadAt(int(off)); err == nil {
+			// the records of the active file were reported by getActiveFileWriteOff.
+			if entry, err := db.recoverNext(r, f, fID != db.MaxFileID); err == nil {
 				if entry == nil {
 					break
 				}
+				off = r.EntryOffset()
 
 				e = nil
 				if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
-					e = &Entry{
-						Key:   entry.Key,
-						Value: entry.Value,
-						Meta:  entry.Meta,
-					}
+					e = arena.newEntry()
+					e.Key = entry.Key
+					e.Value = entry.Value
+					e.Meta = entry.Meta
+				}
+
+				if fID == db.MaxFileID {
+					db.addActiveHint(entry, off)
 				}
 
 				if entry.Meta.status == Committed {
@@ -490,41 +893,57 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 						&Hint{meta: &MetaData{Flag: DataSetFlag}}, CountFlagEnabled)
 				}
 
-				unconfirmedRecords = append(unconfirmedRecords, &Record{
-					H: &Hint{
-						key:     entry.Key,
-						fileID:  fID,
-						meta:    entry.Meta,
-						dataPos: uint64(off),
-					},
-					E: e,
-				})
-
-				if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
-					db.BPTreeKeyEntryPosMap[string(entry.Meta.bucket)+string(entry.Key)] = off
+				if cp != nil && fID == cp.fileID && off < cp.off {
+					continue
 				}
 
-				off += entry.Size()
+				h := arena.newHint()
+				*h = Hint{
+					key:     entry.Key,
+					fileID:  fID,
+					meta:    entry.Meta,
+					dataPos: uint64(off),
+				}
+				record := arena.newRecord()
+				record.H, record.E = h, e
+				unconfirmedRecords = append(unconfirmedRecords, record)
 
-			} else {
-				if err == io.EOF {
-					break
+				if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+					db.BPTreeKeyEntryPosMap[string(getNewKey(string(entry.Meta.bucket), entry.Key))] = off
 				}
 
-				if off >= db.opt.SegmentSize {
+			} else {
+				if r.Offset() >= db.opt.SegmentSize {
 					break
 				}
-				f.rwManager.Close()
-				return nil, nil, fmt.Errorf("when build hintIndex readAt err: %s", err)
+				closeFile(f)
+				return nil, nil, fmt.Errorf("when build hintIndex readAt err: %w", err)
 			}
 		}
 
-		f.rwManager.Close()
+		closeFile(f)
 	}
 
 	return
 }
 
+// appendHintRecords appends the records of the hints loaded from a hint file, allocated from arena.
+func (db *DB) appendHintRecords(records []*Record, hints []*Hint, committedTxIds map[uint64]struct{}, arena *recordArena) []*Record {
+	for _, h := range hints {
+		if h.meta.status == Committed {
+			committedTxIds[h.meta.txID] = struct{}{}
+			db.ActiveCommittedTxIdsIdx.Insert([]byte(strconv2.Int64ToStr(int64(h.meta.txID))), nil,
+				&Hint{meta: &MetaData{Flag: DataSetFlag}}, CountFlagEnabled)
+		}
+
+		record := arena.newRecord()
+		record.H = h
+		records = append(records, record)
+	}
+
+	return records
+}
+
 func (db *DB) buildBPTreeRootIdxes(dataFileIds []int) error {
 	var off int64
 
@@ -561,22 +980,27 @@ func (db *DB) buildBPTreeRootIdxes(dataFileIds []int) error {
 
 func (db *DB) buildBPTreeIdx(bucket string, r *Record) error {
 	if _, ok := db.BPTreeIdx[bucket]; !ok {
-		db.BPTreeIdx[bucket] = NewTree()
+		db.BPTreeIdx[bucket] = db.newBPTree(bucket)
+	}
+
+	key := r.H.key
+	if err := db.BPTreeIdx[bucket].Insert(key, r.E, r.H, CountFlagEnabled); err != nil {
+		return fmt.Errorf("when build BPTreeIdx insert index err: %w", err)
 	}
 
-	if err := db.BPTreeIdx[bucket].Insert(r.H.key, r.E, r.H, CountFlagEnabled); err != nil {
-		return fmt.Errorf("when build BPTreeIdx insert index err: %s", err)
+	if db.indexMemory != nil {
+		r, _ := db.BPTreeIdx[bucket].Find(key)
+		db.indexMemory.admit(r)
 	}
 
 	return nil
 }
 
 func (db *DB) buildActiveBPTreeIdx(r *Record) error {
-	newKey := r.H.meta.bucket
-	newKey = append(newKey, r.H.key...)
+	newKey := getNewKey(string(r.H.meta.bucket), r.H.key)
 
 	if err := db.ActiveBPTreeIdx.Insert(newKey, r.E, r.H, CountFlagEnabled); err != nil {
-		return fmt.Errorf("when build BPTreeIdx insert index err: %s", err)
+		return fmt.Errorf("when build BPTreeIdx insert index err: %w", err)
 	}
 
 	return nil
@@ -605,41 +1029,36 @@ func (db *DB) buildOtherIdxes(bucket string, r *Record) error {
 }
 
 // buildHintIdx builds the Hint Indexes.
-func (db *DB) buildHintIdx(dataFileIds []int) error {
-	unconfirmedRecords, committedTxIds, err := db.parseDataFiles(dataFileIds)
+// If cp is not nil, the entries it covers are skipped.
+func (db *DB) buildHintIdx(dataFileIds []int, cp *indexCheckpoint) error {
+	unconfirmedRecords, committedTxIds, err := db.parseDataFiles(dataFileIds, cp)
 	db.committedTxIds = committedTxIds
 
 	if err != nil {
 		return err
 	}
 
+	if cp != nil {
+		for _, r := range cp.records {
+			db.committedTxIds[r.H.meta.txID] = struct{}{}
+			db.stamps.observe(r.H.meta.timestamp, r.H.meta.seq)
+		}
+	}
+
+	for txID := range db.committedTxIds {
+		db.recordCommittedTxID(txID)
+	}
+
 	if len(unconfirmedRecords) == 0 {
 		return nil
 	}
 
 	for _, r := range unconfirmedRecords {
 		if _, ok := db.committedTxIds[r.H.meta.txID]; ok {
-			bucket := string(r.H.meta.bucket)
-
-			if r.H.meta.ds == DataStructureBPTree {
-				r.H.meta.status = Committed
-
-				if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
-					if err = db.buildActiveBPTreeIdx(r); err != nil {
-						return err
-					}
-				} else {
-					if err = db.buildBPTreeIdx(bucket, r); err != nil {
-						return err
-					}
-				}
-			}
-
-			if err = db.buildOtherIdxes(bucket, r); err != nil {
+			db.stamps.observe(r.H.meta.timestamp, r.H.meta.seq)
+			if err = db.buildCommittedRecordIdx(r); err != nil {
 				return err
 			}
-
-			db.KeyCount++
 		}
 	}
 
@@ -652,6 +1071,35 @@ func (db *DB) buildHintIdx(dataFileIds []int) error {
 	return nil
 }
 
+// buildCommittedRecordIdx builds the indexes of the record of a committed transaction.
+func (db *DB) buildCommittedRecordIdx(r *Record) error {
+	bucket := string(r.H.meta.bucket)
+
+	if r.H.meta.ds == DataStructureBPTree {
+		r.H.meta.status = Committed
+
+		if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+			if err := db.buildActiveBPTreeIdx(r); err != nil {
+				return err
+			}
+		} else if db.lazyBuckets != nil {
+			db.deferBPTreeIdx(bucket, r)
+		} else {
+			if err := db.buildBPTreeIdx(bucket, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := db.buildOtherIdxes(bucket, r); err != nil {
+		return err
+	}
+
+	db.KeyCount++
+
+	return nil
+}
+
 // buildSetIdx builds set index when opening the DB.
 func (db *DB) buildSetIdx(bucket string, r *Record) error {
 	if _, ok := db.SetIdx[bucket]; !ok {
@@ -664,13 +1112,13 @@ func (db *DB) buildSetIdx(bucket string, r *Record) error {
 
 	if r.H.meta.Flag == DataSetFlag {
 		if err := db.SetIdx[bucket].SAdd(string(r.E.Key), r.E.Value); err != nil {
-			return fmt.Errorf("when build SetIdx SAdd index err: %s", err)
+			return fmt.Errorf("when build SetIdx SAdd index err: %w", err)
 		}
 	}
 
 	if r.H.meta.Flag == DataDeleteFlag {
 		if err := db.SetIdx[bucket].SRem(string(r.E.Key), r.E.Value); err != nil {
-			return fmt.Errorf("when build SetIdx SRem index err: %s", err)
+			return fmt.Errorf("when build SetIdx SRem index err: %w", err)
 		}
 	}
 
@@ -684,22 +1132,33 @@ func (db *DB) buildSortedSetIdx(bucket string, r *Record) error {
 	}
 
 	if r.H.meta.Flag == DataZAddFlag {
-		keyAndScore := strings.Split(string(r.E.Key), SeparatorForZSetKey)
-		if len(keyAndScore) == 2 {
-			key := keyAndScore[0]
-			score, _ := strconv2.StrToFloat64(keyAndScore[1])
-			if r.E == nil {
-				return ErrEntryIdxModeOpt
-			}
-			_ = db.SortedSetIdx[bucket].Put(key, zset.SCORE(score), r.E.Value)
+		if r.E == nil {
+			return ErrEntryIdxModeOpt
+		}
+		key, scoreBytes, err := decodeCompositeKey(r.E.Key, SeparatorForZSetKey)
+		if err != nil {
+			return db.recoveryError(r, err)
+		}
+		score, err := decodeScore(scoreBytes)
+		if err := db.recoveryError(r, err); err != nil {
+			return err
+		}
+		if err := db.SortedSetIdx[bucket].Put(string(key), zset.SCORE(score), r.E.Value); err != nil {
+			return db.recoveryError(r, err)
 		}
 	}
 	if r.H.meta.Flag == DataZRemFlag {
 		_ = db.SortedSetIdx[bucket].Remove(string(r.E.Key))
 	}
 	if r.H.meta.Flag == DataZRemRangeByRankFlag {
-		start, _ := strconv2.StrToInt(string(r.E.Key))
-		end, _ := strconv2.StrToInt(string(r.E.Value))
+		start, err := strconv2.StrToInt(string(r.E.Key))
+		if err := db.recoveryError(r, err); err != nil {
+			return err
+		}
+		end, err := strconv2.StrToInt(string(r.E.Value))
+		if err := db.recoveryError(r, err); err != nil {
+			return err
+		}
 		_ = db.SortedSetIdx[bucket].GetByRankRange(start, end, true)
 	}
 	if r.H.meta.Flag == DataZPopMaxFlag {
@@ -708,11 +1167,35 @@ func (db *DB) buildSortedSetIdx(bucket string, r *Record) error {
 	if r.H.meta.Flag == DataZPopMinFlag {
 		_ = db.SortedSetIdx[bucket].PopMin()
 	}
+	if r.H.meta.Flag == DataZRemRangeByLexFlag {
+		lexRange, err := zset.ParseLexRange(string(r.E.Key), string(r.E.Value))
+		if err != nil {
+			return db.recoveryError(r, err)
+		}
+		_ = db.SortedSetIdx[bucket].GetByLexRange(lexRange, true)
+	}
 
 	return nil
 }
 
-//buildListIdx builds List index when opening the DB.
+// recoveryError returns a *RecoveryError recording the entry of r failing to decode with err
+// when opening the DB with RecoveryStrict, or nil, the failure being ignored like before.
+func (db *DB) recoveryError(r *Record, err error) error {
+	if err == nil || db.opt.RecoveryMode != RecoveryStrict {
+		return nil
+	}
+
+	return &RecoveryError{
+		FileID: r.H.fileID,
+		Offset: r.H.dataPos,
+		Bucket: string(r.H.meta.bucket),
+		Key:    r.H.key,
+		Flag:   r.H.meta.Flag,
+		Cause:  err,
+	}
+}
+
+// buildListIdx builds List index when opening the DB.
 func (db *DB) buildListIdx(bucket string, r *Record) error {
 	if _, ok := db.ListIdx[bucket]; !ok {
 		db.ListIdx[bucket] = list.New()
@@ -728,7 +1211,10 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 	case DataRPushFlag:
 		_, _ = db.ListIdx[bucket].RPush(string(r.E.Key), r.E.Value)
 	case DataLRemFlag:
-		count, _ := strconv2.StrToInt(string(r.E.Value))
+		count, err := strconv2.StrToInt(string(r.E.Value))
+		if err := db.recoveryError(r, err); err != nil {
+			return err
+		}
 		if _, err := db.ListIdx[bucket].LRem(string(r.E.Key), count); err != nil {
 			return ErrWhenBuildListIdx(err)
 		}
@@ -741,18 +1227,47 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 			return ErrWhenBuildListIdx(err)
 		}
 	case DataLSetFlag:
-		keyAndIndex := strings.Split(string(r.E.Key), SeparatorForListKey)
-		newKey := keyAndIndex[0]
-		index, _ := strconv2.StrToInt(keyAndIndex[1])
-		if err := db.ListIdx[bucket].LSet(newKey, index, r.E.Value); err != nil {
+		newKey, indexBytes, err := decodeCompositeKey(r.E.Key, SeparatorForListKey)
+		if err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
+		index, err := strconv2.StrToInt(string(indexBytes))
+		if err := db.recoveryError(r, err); err != nil {
+			return err
+		}
+		if err := db.ListIdx[bucket].LSet(string(newKey), index, r.E.Value); err != nil {
 			return ErrWhenBuildListIdx(err)
 		}
 	case DataLTrimFlag:
-		keyAndStartIndex := strings.Split(string(r.E.Key), SeparatorForListKey)
-		newKey := keyAndStartIndex[0]
-		start, _ := strconv2.StrToInt(keyAndStartIndex[1])
-		end, _ := strconv2.StrToInt(string(r.E.Value))
-		if err := db.ListIdx[bucket].Ltrim(newKey, start, end); err != nil {
+		newKey, startBytes, err := decodeCompositeKey(r.E.Key, SeparatorForListKey)
+		if err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
+		start, err := strconv2.StrToInt(string(startBytes))
+		if err := db.recoveryError(r, err); err != nil {
+			return err
+		}
+		end, err := strconv2.StrToInt(string(r.E.Value))
+		if err := db.recoveryError(r, err); err != nil {
+			return err
+		}
+		if err := db.ListIdx[bucket].Ltrim(string(newKey), start, end); err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
+	case DataLInsertFlag:
+		newKey, before, pivot, value, err := decodeLInsert(r.E.Key, r.E.Value)
+		if err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
+		if _, err := db.ListIdx[bucket].LInsert(newKey, before, pivot, value); err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
+	case DataLMoveFlag:
+		m, err := decodeLMove(r.E.Value)
+		if err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
+		if err := db.applyLMove(bucket, string(r.E.Key), m); err != nil {
 			return ErrWhenBuildListIdx(err)
 		}
 	}
@@ -762,7 +1277,7 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 
 // ErrWhenBuildListIdx returns err when build listIdx
 func ErrWhenBuildListIdx(err error) error {
-	return fmt.Errorf("when build listIdx LRem err: %s", err)
+	return fmt.Errorf("when build listIdx LRem err: %w", err)
 }
 
 // buildIndexes builds indexes when db initialize resource.
@@ -777,6 +1292,12 @@ func (db *DB) buildIndexes() (err error) {
 	//init db.ActiveFile
 	db.MaxFileID = maxFileID
 
+	for _, id := range dataFileIds {
+		if int64(id) != maxFileID {
+			db.sealedSize += db.opt.SegmentSize
+		}
+	}
+
 	//set ActiveFile
 	if err = db.setActiveFile(); err != nil {
 		return
@@ -790,15 +1311,29 @@ func (db *DB) buildIndexes() (err error) {
 		return
 	}
 
+	// load the index checkpoint, if any, and only replay the newer entries
+	var cp *indexCheckpoint
+	if db.opt.EntryIdxMode != HintBPTSparseIdxMode {
+		if cp, err = db.readIndexCheckpoint(); err != nil || cp.fileID > maxFileID {
+			cp = nil
+		}
+	}
+
+	if cp != nil {
+		if err = db.loadIndexCheckpoint(cp); err != nil {
+			return
+		}
+	}
+
 	// build hint index
-	return db.buildHintIdx(dataFileIds)
+	return db.buildHintIdx(dataFileIds, cp)
 }
 
 // managed calls a block of code that is fully contained in a transaction.
-func (db *DB) managed(writable bool, fn func(tx *Tx) error) error {
+func (db *DB) managed(writable bool, opts TxOptions, fn func(tx *Tx) error) error {
 	var tx *Tx
 
-	tx, err := db.Begin(writable)
+	tx, err := db.begin(writable, opts)
 	if err != nil {
 		return err
 	}
@@ -822,6 +1357,114 @@ func (db *DB) managed(writable bool, fn func(tx *Tx) error) error {
 
 const bptDir = "bpt"
 
+// defaultRWManagerFactory returns the RWManagerFactory used when the options have none: the
+// built-in managers creating the files with the FilePerm of the options, or the FileIO
+// managers of the files of the Storage of the options.
+func (db *DB) defaultRWManagerFactory() RWManagerFactory {
+	perm := db.filePerm()
+
+	if storage := db.opt.Storage; storage != nil {
+		return func(p string, capacity int64, rwMode RWMode) (RWManager, error) {
+			return newStorageFileIORWManager(storage, p, capacity, perm)
+		}
+	}
+
+	return func(p string, capacity int64, rwMode RWMode) (RWManager, error) {
+		return newRWManager(p, capacity, rwMode, perm)
+	}
+}
+
+// dirPerm returns the permission of the dirs created, see Options.DirPerm.
+func (db *DB) dirPerm() os.FileMode {
+	if db.opt.DirPerm == 0 {
+		return DefaultDirPerm
+	}
+
+	return db.opt.DirPerm
+}
+
+// filePerm returns the permission of the files created, see Options.FilePerm.
+func (db *DB) filePerm() os.FileMode {
+	if db.opt.FilePerm == 0 {
+		return DefaultFilePerm
+	}
+
+	return db.opt.FilePerm
+}
+
+// newDataFile returns a newly initialized DataFile at given path and rwMode,
+// using the SegmentSize and RWManagerFactory of the options.
+func (db *DB) newDataFile(path string, rwMode RWMode) (*DataFile, error) {
+	factory := db.opt.RWManagerFactory
+	if factory == nil {
+		factory = db.defaultRWManagerFactory()
+	}
+
+	df, err := newDataFileWithFactory(path, db.opt.SegmentSize, rwMode, factory)
+	if err != nil {
+		return nil, err
+	}
+	df.buckets = db.buckets
+
+	return df, nil
+}
+
+// openDataFile returns the DataFile at given fid and rwMode, advised random if
+// AdviseRandomReads is set.
+func (db *DB) openDataFile(fID int64, rwMode RWMode) (*DataFile, error) {
+	df, err := db.newDataFile(db.getDataPath(fID), rwMode)
+	if err != nil {
+		return nil, err
+	}
+
+	df.fileID = fID
+	if db.opt.AdviseRandomReads {
+		df.advise(adviceRandom)
+	}
+
+	return df, nil
+}
+
+// newDataFileReader returns the DataFileReader of df using the RecoveryReadBufferSize of the options.
+func (db *DB) newDataFileReader(df *DataFile) *DataFileReader {
+	return db.newDataFileReaderAt(df, 0)
+}
+
+// newDataFileReaderAt returns the DataFileReader of df starting at off.
+func (db *DB) newDataFileReaderAt(df *DataFile, off int64) *DataFileReader {
+	bufSize := db.opt.RecoveryReadBufferSize
+	if bufSize <= 0 {
+		bufSize = defaultRecoveryReadBufferSize
+	}
+
+	return newDataFileReaderAt(df, off, db.opt.SegmentSize, bufSize)
+}
+
+// now returns the current time of the Clock of the options in Unix seconds.
+func (db *DB) now() uint64 {
+	if db.opt.Clock == nil {
+		return SystemClock{}.Now()
+	}
+
+	return db.opt.Clock.Now()
+}
+
+// isExpired checks the ttl if expired or not at the current time of the Clock.
+func (db *DB) isExpired(ttl uint32, timestamp uint64) bool {
+	return isExpiredAt(ttl, timestamp, db.now())
+}
+
+// removeTempFiles removes the temporary files of the index files interrupted by a crash.
+func (db *DB) removeTempFiles() error {
+	for _, dir := range []string{db.opt.Dir, db.getBPTDir(), db.getBPTDir() + "/root", db.getBPTDir() + "/txid"} {
+		if err := removeStorageTempFiles(db.storage, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // getDataPath returns the data path at given fid.
 func (db *DB) getDataPath(fID int64) string {
 	return db.opt.Dir + "/" + strconv2.Int64ToStr(fID) + DataSuffix
@@ -849,7 +1492,8 @@ func (db *DB) getBPTRootTxIdPath(fID int64) string {
 
 func (db *DB) getPendingMergeEntries(entry *Entry, pendingMergeEntries []*Entry) []*Entry {
 	if entry.Meta.ds == DataStructureBPTree {
-		if r, err := db.BPTreeIdx[string(entry.Meta.bucket)].Find(entry.Key); err == nil {
+		t, _ := db.bptreeIdx(string(entry.Meta.bucket))
+		if r, err := t.Find(entry.Key); err == nil {
 			if r.H.meta.Flag == DataSetFlag {
 				pendingMergeEntries = append(pendingMergeEntries, entry)
 			}
@@ -863,10 +1507,8 @@ func (db *DB) getPendingMergeEntries(entry *Entry, pendingMergeEntries []*Entry)
 	}
 
 	if entry.Meta.ds == DataStructureSortedSet {
-		keyAndScore := strings.Split(string(entry.Key), SeparatorForZSetKey)
-		if len(keyAndScore) == 2 {
-			key := keyAndScore[0]
-			n := db.SortedSetIdx[string(entry.Meta.bucket)].GetByKey(key)
+		if key, _, err := decodeCompositeKey(entry.Key, SeparatorForZSetKey); err == nil {
+			n := db.SortedSetIdx[string(entry.Meta.bucket)].GetByKey(string(key))
 			if n != nil {
 				pendingMergeEntries = append(pendingMergeEntries, entry)
 			}
@@ -887,44 +1529,152 @@ func (db *DB) getPendingMergeEntries(entry *Entry, pendingMergeEntries []*Entry)
 				pendingMergeEntries = append(pendingMergeEntries, entry)
 			}
 		}
+		if entry.Meta.Flag == DataLMoveFlag {
+			if e := db.lMoveMergeEntry(entry); e != nil {
+				pendingMergeEntries = append(pendingMergeEntries, e)
+			}
+		}
 	}
 
 	return pendingMergeEntries
 }
 
+// reWriteData commits the live entries of a merged file to new data files, one transaction
+// per file so that a commit never rotates the active file, see reWriteSegment.
 func (db *DB) reWriteData(pendingMergeEntries []*Entry) error {
-	tx, err := db.Begin(true)
+	for len(pendingMergeEntries) > 0 {
+		format := db.opt.RecordFormat
+		n, size := 0, format.overhead()
+		for n < len(pendingMergeEntries) && (n == 0 || size+format.entrySize(pendingMergeEntries[n]) <= db.opt.SegmentSize) {
+			size += format.entrySize(pendingMergeEntries[n])
+			n++
+		}
+
+		if err := db.reWriteSegment(pendingMergeEntries[:n]); err != nil {
+			return err
+		}
+		pendingMergeEntries = pendingMergeEntries[n:]
+	}
+
+	return nil
+}
+
+// reWriteSegment commits the entries to a new data file replacing the active one. The file
+// is written as a temporary one, removed by Open after a crash, and renamed to its data file
+// once the entries are on disk, so that a crash never leaves a stray data file behind.
+func (db *DB) reWriteSegment(entries []*Entry) error {
+	tx, err := db.begin(true, TxOptions{Priority: PriorityLow})
 	if err != nil {
-		db.isMerging = false
 		return err
 	}
+	tx.merge = true
 
-	dataFile, err := NewDataFile(db.getDataPath(db.MaxFileID+1), db.opt.SegmentSize, db.opt.RWMode)
+	fID := db.MaxFileID + 1
+	path := db.getDataPath(fID)
+	dataFile, err := db.newDataFile(path+TempSuffix, db.opt.RWMode)
 	if err != nil {
-		db.isMerging = false
+		tx.Rollback()
+		return err
+	}
+	dataFile.fileID = fID
+	afterMergeStep(mergeStepSegmentCreated)
+
+	if err := db.sealActiveFile(); err != nil {
+		dataFile.rwManager.Close()
+		db.storage.Remove(path + TempSuffix)
+		tx.Rollback()
 		return err
 	}
+
+	if err := db.closeActiveFile(); err != nil {
+		dataFile.rwManager.Close()
+		db.storage.Remove(path + TempSuffix)
+		tx.Rollback()
+		return err
+	}
+	db.sealedSize += db.opt.SegmentSize
 	db.ActiveFile = dataFile
-	db.MaxFileID++
+	db.MaxFileID = fID
+	afterMergeStep(mergeStepActiveSealed)
 
-	for _, e := range pendingMergeEntries {
-		err := tx.put(string(e.Meta.bucket), e.Key, e.Value, e.Meta.TTL, e.Meta.Flag, e.Meta.timestamp, e.Meta.ds)
-		if err != nil {
-			tx.Rollback()
-			db.isMerging = false
+	published := false
+	publish := func() error {
+		if published {
+			return nil
+		}
+		if err := db.publishDataFile(dataFile, path); err != nil {
 			return err
 		}
+		published = true
+		afterMergeStep(mergeStepSegmentPublished)
+		return nil
+	}
+	tx.publish = publish
+
+	err = tx.putEntries(entries)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		// the segment is the active file now, so it is published even if the commit failed.
+		if perr := publish(); perr != nil {
+			db.health.recordError(perr)
+		}
+		tx.Rollback()
+		return err
 	}
-	tx.Commit()
+	afterMergeStep(mergeStepCommitted)
+
 	return nil
 }
 
+// publishDataFile renames the temporary file of df to the data file at given path, syncing
+// its dir if SyncEnable is set. The file is closed and reopened around the rename, which
+// fails on open files on some systems.
+func (db *DB) publishDataFile(df *DataFile, path string) error {
+	if err := df.rwManager.Close(); err != nil {
+		return err
+	}
+
+	p := path
+	err := db.storage.Rename(path+TempSuffix, path)
+	if err != nil {
+		p = path + TempSuffix
+	} else if db.opt.SyncEnable {
+		err = syncStorageDir(db.storage, db.opt.Dir)
+	}
+
+	reopened, rerr := db.newDataFile(p, db.opt.RWMode)
+	if rerr != nil {
+		return rerr
+	}
+	df.rwManager = reopened.rwManager
+	df.path = p
+
+	return err
+}
+
+// closeActiveFile closes the active file before it is replaced, syncing it first
+// in MMap mode without SyncEnable, as its writes were never synced, and with a WAL,
+// whose checkpoints only sync the active file.
+func (db *DB) closeActiveFile() error {
+	if !db.opt.SyncEnable && db.opt.RWMode == MMap || db.wal != nil {
+		if err := db.ActiveFile.rwManager.Sync(); err != nil {
+			return err
+		}
+		db.health.recordSync()
+	}
+
+	return db.ActiveFile.Close()
+}
+
 func (db *DB) isFilterEntry(entry *Entry) bool {
 	if entry.Meta.Flag == DataDeleteFlag || entry.Meta.Flag == DataRPopFlag ||
 		entry.Meta.Flag == DataLPopFlag || entry.Meta.Flag == DataLRemFlag ||
 		entry.Meta.Flag == DataLTrimFlag || entry.Meta.Flag == DataZRemFlag ||
 		entry.Meta.Flag == DataZRemRangeByRankFlag || entry.Meta.Flag == DataZPopMaxFlag ||
-		entry.Meta.Flag == DataZPopMinFlag || IsExpired(entry.Meta.TTL, entry.Meta.timestamp) {
+		entry.Meta.Flag == DataZPopMinFlag || entry.Meta.Flag == DataZRemRangeByLexFlag ||
+		db.isExpired(entry.Meta.TTL, entry.Meta.timestamp) {
 		return true
 	}
 
@@ -15,9 +15,11 @@
 package nutsdb
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"testing"
 
 	"github.com/xujiajun/utils/strconv2"
@@ -963,6 +965,69 @@ func TestOpen(t *testing.T) {
 	}
 }
 
+func TestOpen_Perm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission bits on windows")
+	}
+
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintBPTSparseIdxMode} {
+		permOpt := DefaultOptions
+		permOpt.Dir = t.TempDir() + "/db"
+		permOpt.SegmentSize = 8 * 1024
+		permOpt.EntryIdxMode = mode
+		permOpt.DirPerm = 0700
+		permOpt.FilePerm = 0600
+
+		db, err := Open(permOpt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 200; i++ {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put("bucket", []byte(fmt.Sprintf("key_%03d", i)), []byte("value"), Persistent)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Checkpoint(permOpt.Dir + ".checkpoint"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the umask only clears bits, the group and others ones must stay cleared.
+		for _, dir := range []string{permOpt.Dir, permOpt.Dir + ".checkpoint"} {
+			if info, err := os.Stat(dir); err != nil || info.Mode().Perm()&0077 != 0 {
+				t.Errorf("mode %d: %s is %v %v", mode, dir, info, err)
+			}
+
+			files := 0
+			var walk func(dir string)
+			walk = func(dir string) {
+				infos, err := ioutil.ReadDir(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, info := range infos {
+					if info.Mode().Perm()&0077 != 0 {
+						t.Errorf("mode %d: %s/%s is %v", mode, dir, info.Name(), info.Mode())
+					}
+					if info.IsDir() {
+						walk(dir + "/" + info.Name())
+					} else {
+						files++
+					}
+				}
+			}
+			walk(dir)
+			if files < 3 {
+				t.Errorf("mode %d: expected the files written in %s, got %d", mode, dir, files)
+			}
+		}
+	}
+}
+
 func TestDB_Backup(t *testing.T) {
 	InitOpt("", false)
 	db, err = Open(opt)
@@ -987,3 +1052,95 @@ func TestDB_Close(t *testing.T) {
 		t.Error("err TestDB_Close")
 	}
 }
+
+func TestDB_UseAfterClose(t *testing.T) {
+	InitOpt("", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var captured *Tx
+	for i := 0; i < 50; i++ {
+		err = db.Update(func(tx *Tx) error {
+			captured = tx
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%03d", i)), []byte("value of the key"), Persistent)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	active := db.ActiveFile
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(opt.Dir)
+
+	t.Run("tx", func(t *testing.T) {
+		if err := captured.Put("bucket", []byte("key"), []byte("value"), Persistent); err != ErrTxClosed {
+			t.Errorf("err Put. got %v want %v", err, ErrTxClosed)
+		}
+		if _, err := captured.Get("bucket", []byte("key_000")); err != ErrTxClosed {
+			t.Errorf("err Get. got %v want %v", err, ErrTxClosed)
+		}
+		if _, err := captured.FindOnDisk(0, 0, []byte("key_000")); err != ErrTxClosed {
+			t.Errorf("err FindOnDisk. got %v want %v", err, ErrTxClosed)
+		}
+		if _, err := captured.FindLeafOnDisk(0, 0, []byte("key_000")); err != ErrTxClosed {
+			t.Errorf("err FindLeafOnDisk. got %v want %v", err, ErrTxClosed)
+		}
+		if _, err := captured.FindTxIdOnDisk(0, 0); err != ErrTxClosed {
+			t.Errorf("err FindTxIdOnDisk. got %v want %v", err, ErrTxClosed)
+		}
+		if err := captured.Commit(); err != ErrDBClosed {
+			t.Errorf("err Commit. got %v want %v", err, ErrDBClosed)
+		}
+		if err := captured.Rollback(); err != ErrDBClosed {
+			t.Errorf("err Rollback. got %v want %v", err, ErrDBClosed)
+		}
+	})
+
+	t.Run("db", func(t *testing.T) {
+		calls := map[string]func() error{
+			"Begin":            func() error { _, err := db.Begin(true); return err },
+			"Update":           func() error { return db.Update(func(tx *Tx) error { return nil }) },
+			"View":             func() error { return db.View(func(tx *Tx) error { return nil }) },
+			"Merge":            db.Merge,
+			"MergeWorst":       func() error { return db.MergeWorst(2) },
+			"CheckpointIndex":  db.CheckpointIndex,
+			"CommitPrepared":   func() error { return db.CommitPrepared(1) },
+			"RollbackPrepared": func() error { return db.RollbackPrepared(1) },
+			"PreparedTxIDs":    func() error { _, err := db.PreparedTxIDs(); return err },
+			"NewMonotonicID":   func() error { _, err := db.NewMonotonicID(); return err },
+			"Ping":             db.Ping,
+		}
+		for name, call := range calls {
+			if err := call(); err != ErrDBClosed {
+				t.Errorf("err %s. got %v want %v", name, err, ErrDBClosed)
+			}
+		}
+
+		// the index is dropped by Close, so a merge would find no live entry and remove the files.
+		after, _ := ioutil.ReadDir(opt.Dir)
+		if len(after) != len(files) {
+			t.Errorf("err files after Close. got %d want %d", len(after), len(files))
+		}
+	})
+
+	t.Run("active file", func(t *testing.T) {
+		if _, err := active.ReadAt(0); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("err ReadAt. got %v want %v", err, ErrDataFileClosed)
+		}
+		if _, err := active.WriteAt([]byte("value"), 0); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("err WriteAt. got %v want %v", err, ErrDataFileClosed)
+		}
+		if err := active.Sync(); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("err Sync. got %v want %v", err, ErrDataFileClosed)
+		}
+		if err := active.Close(); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("err Close. got %v want %v", err, ErrDataFileClosed)
+		}
+	})
+}
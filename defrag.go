@@ -0,0 +1,85 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	// ErrDefragActiveFile is returned when defragmenting the active file.
+	ErrDefragActiveFile = errors.New("cannot defrag the active file")
+
+	// ErrDefragOrderedEntries is returned when defragmenting a file holding list or
+	// sorted set entries, which cannot be rewritten out of order.
+	ErrDefragOrderedEntries = errors.New("cannot defrag a file holding list or sorted set entries")
+)
+
+// Defrag rewrites the live entries of the sealed data file fID into the active
+// file and removes it, an incremental compaction of a single file, e.g. one chosen
+// from FileStats. The entries are rewritten in one transaction, so the readers see
+// either the old or the new entries.
+func (db *DB) Defrag(fID int64) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if db.opt.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if err := db.checkDefragFile(fID); err != nil {
+		return err
+	}
+
+	if err := db.startMerge(); err != nil {
+		return err
+	}
+	defer db.endMerge()
+
+	_, err := db.mergeFile(int(fID), true)
+
+	return err
+}
+
+// checkDefragFile returns an error if the data file fID cannot be defragmented.
+func (db *DB) checkDefragFile(fID int64) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	if fID == db.MaxFileID {
+		return ErrDefragActiveFile
+	}
+
+	if err := db.loadFileCounters(); err != nil {
+		return err
+	}
+
+	c, ok := db.fileCounters[fID]
+	if !ok {
+		return &os.PathError{Op: "defrag", Path: db.getDataPath(fID), Err: os.ErrNotExist}
+	}
+
+	if c.orderedEntries > 0 {
+		return ErrDefragOrderedEntries
+	}
+
+	return nil
+}
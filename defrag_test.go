@@ -0,0 +1,97 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDB_Defrag(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdefrag", true)
+	opt.SegmentSize = 1024
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	put := func(i int, val string) {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("%s_%080d", val, i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		put(i, "old")
+	}
+	for i := 0; i < 10; i += 2 {
+		put(i, "new")
+	}
+
+	if err := db.Defrag(db.MaxFileID); err != ErrDefragActiveFile {
+		t.Fatalf("expected ErrDefragActiveFile, got %v", err)
+	}
+
+	if err := db.Defrag(db.MaxFileID + 1); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+
+	if err := db.Defrag(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(db.getDataPath(0)); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed, got %v", err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			want := "old"
+			if i%2 == 0 {
+				want = "new"
+			}
+			e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+			if err != nil {
+				return err
+			}
+			if string(e.Value) != fmt.Sprintf("%s_%080d", want, i) {
+				t.Errorf("key_%d: unexpected value %s", i, e.Value)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	zsetFileID := db.MaxFileID
+	if err := db.Update(func(tx *Tx) error {
+		return tx.ZAdd("zset", []byte("key"), 1, []byte("val"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 10; db.MaxFileID == zsetFileID; i++ {
+		put(i, "old")
+	}
+
+	if err := db.Defrag(zsetFileID); err != ErrDefragOrderedEntries {
+		t.Fatalf("expected ErrDefragOrderedEntries, got %v", err)
+	}
+}
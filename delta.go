@@ -0,0 +1,360 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// maxDeltaChain is the max number of Append and SetRange entries read to resolve a
+	// value. Over it, the value of the key is written in full instead.
+	maxDeltaChain = 16
+
+	// deltaHeaderSize is the size of the header of the value of a delta entry.
+	deltaHeaderSize = 28
+)
+
+// ErrOffsetOutOfRange is returned when SetRange is called with a negative offset.
+var ErrOffsetOutOfRange = errors.New("offset out of range")
+
+// delta represents the value of an Append or SetRange entry: the data written at offset,
+// and the position of the previous entry of the key, so that the full value is resolved
+// by reading the entries back to the last Put.
+//
+//	|-------------------------------------------------|
+//	| prevFileID | prevPos | depth | offset |   data  |
+//	|-------------------------------------------------|
+//	|   uint64   | uint64  | uint32| uint64 |  []byte |
+//	|-------------------------------------------------|
+type delta struct {
+	prevFileID int64
+	prevPos    uint64
+	depth      uint32 // the number of deltas before this one
+	offset     uint64
+	data       []byte
+}
+
+// isDeltaFlag returns if the flag is the one of an Append or SetRange entry.
+func isDeltaFlag(flag uint16) bool {
+	return flag == DataAppendFlag || flag == DataSetRangeFlag
+}
+
+// encode returns the value of the delta entry.
+func (d *delta) encode() []byte {
+	buf := make([]byte, deltaHeaderSize+len(d.data))
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(d.prevFileID))
+	binary.LittleEndian.PutUint64(buf[8:16], d.prevPos)
+	binary.LittleEndian.PutUint32(buf[16:20], d.depth)
+	binary.LittleEndian.PutUint64(buf[20:28], d.offset)
+	copy(buf[deltaHeaderSize:], d.data)
+
+	return buf
+}
+
+// decodeDelta returns the delta of the value of a delta entry.
+func decodeDelta(value []byte) (*delta, error) {
+	if len(value) < deltaHeaderSize {
+		return nil, ErrCorrupted
+	}
+
+	return &delta{
+		prevFileID: int64(binary.LittleEndian.Uint64(value[0:8])),
+		prevPos:    binary.LittleEndian.Uint64(value[8:16]),
+		depth:      binary.LittleEndian.Uint32(value[16:20]),
+		offset:     binary.LittleEndian.Uint64(value[20:28]),
+		data:       value[deltaHeaderSize:],
+	}, nil
+}
+
+// apply returns a new value, the value with the delta of the flag applied.
+// SetRange pads the value with zeros up to the offset.
+func (d *delta) apply(flag uint16, value []byte) []byte {
+	if flag == DataAppendFlag {
+		out := make([]byte, 0, len(value)+len(d.data))
+		out = append(out, value...)
+		return append(out, d.data...)
+	}
+
+	size := len(value)
+	if end := int(d.offset) + len(d.data); end > size {
+		size = end
+	}
+
+	out := make([]byte, size)
+	copy(out, value)
+	copy(out[d.offset:], d.data)
+
+	return out
+}
+
+// Append appends the data to the value of the key in the bucket, setting it if the key
+// does not exist. Only the data is written, in a delta entry resolved on read, and the
+// merges write the value in full again. The delta is written with the remaining TTL of
+// the key, so that the key keeps expiring at the same time.
+// It is not supported in HintBPTSparseIdxMode.
+func (tx *Tx) Append(bucket string, key, data []byte) error {
+	return tx.putDelta(bucket, key, DataAppendFlag, &delta{data: data})
+}
+
+// SetRange overwrites the value of the key in the bucket with the data from offset,
+// padding it with zeros if it is shorter than offset, like Append.
+// It is not supported in HintBPTSparseIdxMode.
+func (tx *Tx) SetRange(bucket string, key []byte, offset int, data []byte) error {
+	if offset < 0 {
+		return ErrOffsetOutOfRange
+	}
+
+	return tx.putDelta(bucket, key, DataSetRangeFlag, &delta{offset: uint64(offset), data: data})
+}
+
+// putDelta writes the delta entry of the key after the committed entry of the key. A key
+// without a committed entry, written by the transaction or at the end of a long chain of
+// deltas is written in full instead.
+func (tx *Tx) putDelta(bucket string, key []byte, flag uint16, d *delta) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if r := tx.liveRecord(bucket, key); r != nil && !tx.hasPendingWrite(bucket, key) {
+		if isDeltaFlag(r.H.meta.Flag) {
+			e, err := tx.db.recordEntry(r)
+			if err != nil {
+				return err
+			}
+			prev, err := decodeDelta(e.Value)
+			if err != nil {
+				return err
+			}
+			d.depth = prev.depth + 1
+		}
+
+		if d.depth < maxDeltaChain {
+			d.prevFileID, d.prevPos = r.H.fileID, r.H.dataPos
+			now := tx.now()
+			ttl := remainingTTL(r.H.meta.TTL, r.H.meta.timestamp, now)
+			return tx.put(bucket, key, d.encode(), ttl, flag, now, DataStructureBPTree)
+		}
+	}
+
+	value, meta, err := tx.currentValue(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	now := tx.now()
+	ttl := Persistent
+	if meta != nil {
+		ttl = remainingTTL(meta.TTL, meta.timestamp, now)
+	}
+
+	return tx.put(bucket, key, d.apply(flag, value), ttl, DataSetFlag, now, DataStructureBPTree)
+}
+
+// liveRecord returns the committed record of the key in the bucket, or nil if the key
+// was deleted or expired.
+func (tx *Tx) liveRecord(bucket string, key []byte) *Record {
+	idx, ok := tx.bptreeIdx(bucket)
+	if !ok {
+		return nil
+	}
+
+	r, err := idx.Find(key)
+	if err != nil {
+		return nil
+	}
+
+	if !tx.db.isCommittedTx(r.H.meta.txID) {
+		return nil
+	}
+
+	if r.H.meta.Flag == DataDeleteFlag || tx.db.isExpired(r.H.meta.TTL, r.H.meta.timestamp) {
+		return nil
+	}
+
+	return r
+}
+
+// hasPendingWrite returns if the transaction wrote the key in the bucket.
+func (tx *Tx) hasPendingWrite(bucket string, key []byte) bool {
+	return tx.pendingWrite(bucket, key) != nil
+}
+
+// pendingWrite returns the last entry of the key in the bucket written by the transaction.
+func (tx *Tx) pendingWrite(bucket string, key []byte) *Entry {
+	for i := len(tx.pendingWrites) - 1; i >= 0; i-- {
+		e := tx.pendingWrites[i]
+		if e.Meta.ds == DataStructureBPTree && string(e.Meta.bucket) == bucket && bytes.Equal(e.Key, key) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// currentValue returns the full value of the key in the bucket and its meta, including
+// the writes of the transaction, or a nil meta if the key does not exist.
+func (tx *Tx) currentValue(bucket string, key []byte) ([]byte, *MetaData, error) {
+	pending := tx.pendingWrite(bucket, key)
+	if pending != nil && !isDeltaFlag(pending.Meta.Flag) {
+		if pending.Meta.Flag == DataDeleteFlag {
+			return nil, nil, nil
+		}
+		return pending.Value, pending.Meta, nil
+	}
+
+	// a pending delta follows the committed entry of the key.
+	e, err := tx.Get(bucket, key)
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrBucketNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pending == nil {
+		return e.Value, e.Meta, nil
+	}
+
+	d, err := decodeDelta(pending.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return d.apply(pending.Meta.Flag, e.Value), e.Meta, nil
+}
+
+// recordEntry returns the entry of the record, read from the data file if not in RAM.
+func (db *DB) recordEntry(r *Record) (*Entry, error) {
+	if r.E != nil {
+		return r.E, nil
+	}
+
+	df, err := db.openDataFile(r.H.fileID, db.opt.RWMode)
+	if err != nil {
+		return nil, err
+	}
+	defer df.rwManager.Close()
+
+	return df.ReadAt(int(r.H.dataPos))
+}
+
+// resolveDeltas returns e, or a copy of it holding the full value if it is a delta entry,
+// reading the previous entries of the key back to the last Put.
+func (db *DB) resolveDeltas(e *Entry) (*Entry, error) {
+	if e == nil || !isDeltaFlag(e.Meta.Flag) {
+		return e, nil
+	}
+
+	files := make(map[int64]*DataFile)
+	defer func() {
+		for _, df := range files {
+			df.rwManager.Close()
+		}
+	}()
+
+	var (
+		deltas []*delta
+		flags  []uint16
+	)
+
+	curr := e
+	for isDeltaFlag(curr.Meta.Flag) {
+		d, err := decodeDelta(curr.Value)
+		if err != nil || len(deltas) > maxDeltaChain {
+			return nil, ErrCorrupted
+		}
+		deltas = append(deltas, d)
+		flags = append(flags, curr.Meta.Flag)
+
+		df, ok := files[d.prevFileID]
+		if !ok {
+			if df, err = db.openDataFile(d.prevFileID, db.opt.RWMode); err != nil {
+				return nil, err
+			}
+			files[d.prevFileID] = df
+		}
+
+		if curr, err = df.ReadAt(int(d.prevPos)); err != nil {
+			return nil, err
+		}
+
+		if curr == nil || !bytes.Equal(curr.Meta.bucket, e.Meta.bucket) || !bytes.Equal(curr.Key, e.Key) {
+			return nil, ErrCorrupted
+		}
+	}
+
+	if curr.Meta.Flag != DataSetFlag {
+		return nil, ErrCorrupted
+	}
+
+	value := curr.Value
+	for i := len(deltas) - 1; i >= 0; i-- {
+		value = deltas[i].apply(flags[i], value)
+	}
+
+	meta := *e.Meta
+	meta.Flag = DataSetFlag
+	meta.valueSize = uint32(len(value))
+
+	return &Entry{Key: e.Key, Value: value, Meta: &meta}, nil
+}
+
+// getDeltaMergeEntries appends the entry of the key of the entry holding its full value to
+// pendingMergeEntries, once by merged file, if the index points to a delta entry, as the
+// deltas point to the entries of the merged file. It returns if the entry is handled,
+// i.e. also for a delta entry of a key written in full since, which is dropped.
+func (db *DB) getDeltaMergeEntries(entry *Entry, folded map[string]struct{}, pendingMergeEntries []*Entry) ([]*Entry, bool, error) {
+	if entry.Meta.ds != DataStructureBPTree {
+		return pendingMergeEntries, false, nil
+	}
+
+	bucket := string(entry.Meta.bucket)
+
+	var r *Record
+	if t, ok := db.bptreeIdx(bucket); ok {
+		r, _ = t.Find(entry.Key)
+	}
+
+	if r == nil || !isDeltaFlag(r.H.meta.Flag) {
+		return pendingMergeEntries, isDeltaFlag(entry.Meta.Flag), nil
+	}
+
+	if _, ok := folded[bucket+string(entry.Key)]; ok {
+		return pendingMergeEntries, true, nil
+	}
+	folded[bucket+string(entry.Key)] = struct{}{}
+
+	e, err := db.recordEntry(r)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if e, err = db.resolveDeltas(e); err != nil {
+		return nil, true, err
+	}
+
+	return append(pendingMergeEntries, e), true, nil
+}
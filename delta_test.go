@@ -0,0 +1,245 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func getDeltaTestValue(t *testing.T, bucket string, key []byte) (string, *Record) {
+	var (
+		value string
+		r     *Record
+	)
+
+	if err := db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, key)
+		if err != nil {
+			return err
+		}
+		value = string(e.Value)
+
+		idx, _ := tx.db.bptreeIdx(bucket)
+		r, err = idx.Find(key)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return value, r
+}
+
+func TestTx_AppendAndSetRange(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestdelta", true)
+		opt.EntryIdxMode = mode
+		clock := NewManualClock(time.Unix(1600000000, 0))
+		opt.Clock = clock
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bucket, key := "bucket", []byte("key")
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, key, []byte("hello"), 1000)
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the deltas are written now, with the remaining TTL.
+		clock.Set(time.Unix(1600000010, 0))
+
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Append(bucket, key, []byte(" world"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Update(func(tx *Tx) error {
+			return tx.SetRange(bucket, key, 6, []byte("W"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		value, r := getDeltaTestValue(t, bucket, key)
+		if value != "hello World" || r.H.meta.Flag != DataSetRangeFlag || r.H.meta.TTL != 990 || r.H.meta.timestamp != 1600000010 {
+			t.Errorf("err Get after Append and SetRange. got %q flag %d ttl %d timestamp %d", value, r.H.meta.Flag, r.H.meta.TTL, r.H.meta.timestamp)
+		}
+
+		if err := db.View(func(tx *Tx) error {
+			es, err := tx.GetAll(bucket)
+			if err != nil {
+				return err
+			}
+			if len(es) != 1 || string(es[0].Value) != "hello World" {
+				t.Errorf("err GetAll after Append and SetRange. got %v", es)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the entries are replayed when opening the database.
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := getDeltaTestValue(t, bucket, key); value != "hello World" {
+			t.Errorf("err Get after reopening. got %q", value)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTx_AppendWrittenInFull(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdelta", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bucket := "bucket"
+
+	// a missing key is set, padded by SetRange.
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.Append(bucket, []byte("key1"), []byte("a")); err != nil {
+			return err
+		}
+		return tx.SetRange(bucket, []byte("key2"), 2, []byte("b"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if value, r := getDeltaTestValue(t, bucket, []byte("key1")); value != "a" || r.H.meta.Flag != DataSetFlag {
+		t.Errorf("err Append of a missing key. got %q flag %d", value, r.H.meta.Flag)
+	}
+	if value, _ := getDeltaTestValue(t, bucket, []byte("key2")); value != "\x00\x00b" {
+		t.Errorf("err SetRange of a missing key. got %q", value)
+	}
+
+	// a key written by the transaction is written in full.
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.Append(bucket, []byte("key1"), []byte("b")); err != nil {
+			return err
+		}
+		if err := tx.Append(bucket, []byte("key1"), []byte("c")); err != nil {
+			return err
+		}
+		return tx.SetRange(bucket, []byte("key1"), 0, []byte("A"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if value, r := getDeltaTestValue(t, bucket, []byte("key1")); value != "Abc" || r.H.meta.Flag != DataSetFlag {
+		t.Errorf("err Append in one transaction. got %q flag %d", value, r.H.meta.Flag)
+	}
+
+	// a long chain of deltas is written in full.
+	want := "Abc"
+	for i := 0; i < maxDeltaChain; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Append(bucket, []byte("key1"), []byte{byte('a' + i)})
+		}); err != nil {
+			t.Fatal(err)
+		}
+		want += string(rune('a' + i))
+	}
+	value, r := getDeltaTestValue(t, bucket, []byte("key1"))
+	if value != want || r.H.meta.Flag != DataAppendFlag {
+		t.Errorf("err Append chain. got %q flag %d", value, r.H.meta.Flag)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Append(bucket, []byte("key1"), []byte("!"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if value, r := getDeltaTestValue(t, bucket, []byte("key1")); value != want+"!" || r.H.meta.Flag != DataSetFlag {
+		t.Errorf("err Append over the max chain. got %q flag %d", value, r.H.meta.Flag)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.SetRange(bucket, []byte("key1"), -1, []byte("!"))
+	}); err != ErrOffsetOutOfRange {
+		t.Errorf("err SetRange at a negative offset. got %v want ErrOffsetOutOfRange", err)
+	}
+	if err := db.View(func(tx *Tx) error {
+		return tx.Append(bucket, []byte("key1"), []byte("!"))
+	}); err != ErrTxNotWritable {
+		t.Errorf("err Append in a read-only tx. got %v want ErrTxNotWritable", err)
+	}
+}
+
+func TestDB_MergeFoldsDeltas(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdelta", true)
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bucket := "bucket"
+	chunk := bytes.Repeat([]byte("x"), 100)
+
+	// the chains of deltas span several files.
+	for i := 0; i < 10; i++ {
+		for k := 0; k < 10; k++ {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Append(bucket, []byte(fmt.Sprintf("key_%d", k)), chunk)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	for k := 0; k < 10; k++ {
+		value, r := getDeltaTestValue(t, bucket, []byte(fmt.Sprintf("key_%d", k)))
+		if value != string(bytes.Repeat(chunk, 10)) {
+			t.Errorf("err Get key_%d after Merge. got %d bytes want %d", k, len(value), 10*len(chunk))
+		}
+		if r.H.meta.Flag != DataSetFlag && r.H.fileID != db.MaxFileID {
+			t.Errorf("err key_%d not folded by Merge. flag %d file %d", k, r.H.meta.Flag, r.H.fileID)
+		}
+	}
+}
+
+func TestTx_AppendSparseIndex(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdelta", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Append("bucket", []byte("key"), []byte("a"))
+	}); err != ErrNotSupportHintBPTSparseIdxMode {
+		t.Errorf("err Append in HintBPTSparseIdxMode. got %v want ErrNotSupportHintBPTSparseIdxMode", err)
+	}
+}
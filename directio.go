@@ -0,0 +1,159 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"unsafe"
+)
+
+const (
+	// directIOAlignment is the alignment of the offsets and buffers of the direct I/O reads.
+	directIOAlignment = 4096
+
+	// directIOWindowSize is the size of the reads of a directFile.
+	directIOWindowSize = 1 << 20
+)
+
+// errDirectFileReadOnly is returned when writing a directFile.
+var errDirectFileReadOnly = errors.New("direct I/O file is read-only")
+
+// directFile reads a file bypassing the page cache, see Options.UseDirectIO. The reads are
+// served from a window of directIOWindowSize bytes read at an aligned offset into an aligned
+// buffer, so that the unaligned entries read one after the other take one syscall per window.
+// It implements RWManager for reading a DataFile, and io.Reader for copying the file.
+type directFile struct {
+	fd  *os.File
+	buf []byte // the window
+	off int64  // the offset of the window in the file, -1 before the first read
+	n   int    // the bytes read in the window
+	pos int64  // the offset of the next Read
+}
+
+// openDirectFile opens the named file for reading it with direct I/O.
+func openDirectFile(name string) (*directFile, error) {
+	fd, err := openFileDirect(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &directFile{fd: fd, buf: alignedBlock(directIOWindowSize), off: -1}, nil
+}
+
+// alignedBlock returns a buffer of size bytes starting at an address aligned to directIOAlignment.
+func alignedBlock(size int) []byte {
+	b := make([]byte, size+directIOAlignment)
+
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&b[0])) & (directIOAlignment - 1)); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+
+	return b[offset : offset+size : offset+size]
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *directFile) ReadAt(b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		o := off + int64(n)
+		if f.off < 0 || o < f.off || o >= f.off+int64(f.n) {
+			if err := f.fill(o); err != nil {
+				return n, err
+			}
+			if o >= f.off+int64(f.n) {
+				return n, io.EOF
+			}
+		}
+
+		n += copy(b[n:], f.buf[o-f.off:f.n])
+	}
+
+	return n, nil
+}
+
+// fill reads the window holding off.
+func (f *directFile) fill(off int64) error {
+	f.off = off &^ (directIOAlignment - 1)
+	n, err := preadDirect(f.fd, f.buf, f.off)
+	if err != nil {
+		f.off, f.n = -1, 0
+		return err
+	}
+	f.n = n
+
+	return nil
+}
+
+// Read implements io.Reader.
+func (f *directFile) Read(b []byte) (n int, err error) {
+	n, err = f.ReadAt(b, f.pos)
+	f.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+// WriteAt returns errDirectFileReadOnly.
+func (f *directFile) WriteAt(b []byte, off int64) (n int, err error) {
+	return 0, errDirectFileReadOnly
+}
+
+// Sync does nothing, the file is only read.
+func (f *directFile) Sync() error {
+	return nil
+}
+
+// Close closes the file.
+func (f *directFile) Close() error {
+	return f.fd.Close()
+}
+
+// useDirectIO returns if the files read sequentially are read with direct I/O, see
+// Options.UseDirectIO. It is ignored with a Storage.
+func (db *DB) useDirectIO() bool {
+	if !db.opt.UseDirectIO || db.fsys != nil {
+		return false
+	}
+
+	_, ok := db.storage.(osStorage)
+
+	return ok
+}
+
+// openScanDataFile returns the DataFile at given fid and rwMode for reading it once from
+// start to end, e.g. by a merge, with direct I/O if UseDirectIO is set or else advised
+// sequential, see closeScanDataFile.
+func (db *DB) openScanDataFile(fID int64, rwMode RWMode) (*DataFile, error) {
+	if !db.useDirectIO() {
+		df, err := db.openDataFile(fID, rwMode)
+		if err != nil {
+			return nil, err
+		}
+		df.advise(adviceSequential)
+
+		return df, nil
+	}
+
+	f, err := openDirectFile(db.getDataPath(fID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataFile{path: db.getDataPath(fID), fileID: fID, rwManager: f, buckets: db.buckets}, nil
+}
@@ -0,0 +1,35 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFileDirect opens the named file read-only with F_NOCACHE.
+func openFileDirect(name string) (*os.File, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd.Fd(), syscall.F_NOCACHE, 1); errno != 0 {
+		fd.Close()
+		return nil, &os.PathError{Op: "fcntl", Path: name, Err: errno}
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin
+
+package nutsdb
+
+import (
+	"io"
+	"os"
+)
+
+// openFileDirect opens the named file read-only, direct I/O being only supported on
+// Linux and macOS.
+func openFileDirect(name string) (*os.File, error) {
+	return os.Open(name)
+}
+
+// preadDirect reads the file at off, see the Linux and macOS version.
+func preadDirect(fd *os.File, b []byte, off int64) (int, error) {
+	n, err := fd.ReadAt(b, off)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestDirectFile(t *testing.T) {
+	path := "/tmp/nutsdbtestdirectfile"
+	defer os.Remove(path)
+
+	// the size is neither aligned nor a multiple of the window.
+	data := make([]byte, 2*directIOWindowSize+12345)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := openDirectFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, r := range []struct{ off, size int }{
+		{0, 42}, {42, 100}, {4095, 2}, {directIOWindowSize - 10, 20}, {3, directIOWindowSize + 7}, {len(data) - 5, 5},
+	} {
+		b := make([]byte, r.size)
+		if _, err := f.ReadAt(b, int64(r.off)); err != nil {
+			t.Fatalf("err ReadAt %d at %d: %v", r.size, r.off, err)
+		}
+		if !bytes.Equal(b, data[r.off:r.off+r.size]) {
+			t.Errorf("err ReadAt %d at %d: wrong bytes", r.size, r.off)
+		}
+	}
+
+	if n, err := f.ReadAt(make([]byte, 10), int64(len(data)-5)); n != 5 || err != io.EOF {
+		t.Errorf("err ReadAt past the end. got %d, %v want 5, %v", n, err, io.EOF)
+	}
+
+	read, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Error("err Read: wrong bytes")
+	}
+
+	if _, err := f.WriteAt([]byte("x"), 0); err != errDirectFileReadOnly {
+		t.Errorf("err WriteAt. got %v want %v", err, errDirectFileReadOnly)
+	}
+}
+
+func TestDB_UseDirectIO(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdirectio", true)
+	opt.SegmentSize = 8 * 1024
+	opt.UseDirectIO = true
+	backup := "/tmp/nutsdbtestdirectio_backup"
+	os.RemoveAll(backup)
+	defer os.RemoveAll(backup)
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for version := 0; version < 3; version++ {
+		for i := 0; i < 100; i++ {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%080d", i, version)), Persistent)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	check := func(db *DB) {
+		if err := db.View(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+				if err != nil {
+					return err
+				}
+				if want := fmt.Sprintf("val_%d_%080d", i, 2); string(e.Value) != want {
+					t.Errorf("key_%d: got %s, want %s", i, e.Value, want)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+
+	if err := db.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{opt.Dir, backup} {
+		o := opt
+		o.Dir = dir
+		db, err := Open(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(db)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
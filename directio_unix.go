@@ -0,0 +1,38 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+
+package nutsdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// preadDirect reads the file at off with a single pread, as the next one of os.File.ReadAt
+// after a short read at the end of the file would be unaligned.
+func preadDirect(fd *os.File, b []byte, off int64) (int, error) {
+	for {
+		n, err := syscall.Pread(int(fd.Fd()), b, off)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, &os.PathError{Op: "pread", Path: fd.Name(), Err: err}
+		}
+
+		return n, nil
+	}
+}
@@ -15,6 +15,7 @@
 package list
 
 import (
+	"bytes"
 	"errors"
 )
 
@@ -27,6 +28,9 @@ var (
 
 	//ErrCount is returned when count is error.
 	ErrCount = errors.New("err count")
+
+	// ErrPivotNotFound is returned when the pivot of LInsert is not in the list.
+	ErrPivotNotFound = errors.New("the pivot not found")
 )
 
 // List represents the list.
@@ -248,3 +252,89 @@ func (l *List) Ltrim(key string, start, end int) error {
 
 	return nil
 }
+
+// LInsert inserts value before or after the first element equal to pivot in the
+// list stored at key, and returns the new size of the list.
+func (l *List) LInsert(key string, before bool, pivot, value []byte) (int, error) {
+	if _, ok := l.Items[key]; !ok {
+		return 0, ErrListNotFound
+	}
+
+	items := l.Items[key]
+	for i, item := range items {
+		if !bytes.Equal(item, pivot) {
+			continue
+		}
+
+		if !before {
+			i++
+		}
+
+		newList := make([][]byte, 0, len(items)+1)
+		newList = append(newList, items[:i]...)
+		newList = append(newList, value)
+		newList = append(newList, items[i:]...)
+		l.Items[key] = newList
+
+		return len(newList), nil
+	}
+
+	return 0, ErrPivotNotFound
+}
+
+// LPosOptions represents the options of LPos, like the ones of the Redis LPOS.
+type LPosOptions struct {
+	// Rank represents the match to start from: 1 the first one, 2 the second one,
+	// -1 the last one scanning from the tail, and so on. 0 means 1.
+	Rank int
+
+	// Count represents the max number of positions returned, 0 means all of them.
+	Count int
+
+	// MaxLen represents the max number of elements compared, 0 means all of them.
+	MaxLen int
+}
+
+// LPos returns the indexes of the elements equal to value in the list stored at key.
+// If opts is nil, only the index of the first match is returned.
+func (l *List) LPos(key string, value []byte, opts *LPosOptions) ([]int, error) {
+	if _, ok := l.Items[key]; !ok {
+		return nil, ErrListNotFound
+	}
+
+	if opts == nil {
+		opts = &LPosOptions{Count: 1}
+	}
+
+	rank, step, start := opts.Rank, 1, 0
+	if rank == 0 {
+		rank = 1
+	}
+
+	items := l.Items[key]
+	if rank < 0 {
+		rank, step, start = -rank, -1, len(items)-1
+	}
+
+	var positions []int
+	for i, compared := start, 0; i >= 0 && i < len(items); i, compared = i+step, compared+1 {
+		if opts.MaxLen > 0 && compared >= opts.MaxLen {
+			break
+		}
+
+		if !bytes.Equal(items[i], value) {
+			continue
+		}
+
+		if rank--; rank > 0 {
+			continue
+		}
+
+		positions = append(positions, i)
+		if opts.Count > 0 && len(positions) >= opts.Count {
+			break
+		}
+	}
+
+	return positions, nil
+}
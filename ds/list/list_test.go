@@ -15,6 +15,7 @@
 package list
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -374,3 +375,69 @@ func getExpectResult(items ...string) map[string]struct{} {
 
 	return expectResult
 }
+
+func TestList_LInsert(t *testing.T) {
+	list, key := InitListData()
+
+	if size, err := list.LInsert(key, true, []byte("b"), []byte("x")); err != nil || size != 5 {
+		t.Fatalf("TestList_LInsert err: %d, %v", size, err)
+	}
+
+	if _, err := list.LInsert(key, false, []byte("d"), []byte("y")); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _ := list.LRange(key, 0, -1)
+	if got := string(bytesJoin(items)); got != "axbcdy" {
+		t.Errorf("TestList_LInsert err: got %s", got)
+	}
+
+	if _, err := list.LInsert(key, true, []byte("z"), []byte("x")); err != ErrPivotNotFound {
+		t.Errorf("expected ErrPivotNotFound, got %v", err)
+	}
+
+	if _, err := list.LInsert("fake", true, []byte("a"), []byte("x")); err != ErrListNotFound {
+		t.Errorf("expected ErrListNotFound, got %v", err)
+	}
+}
+
+func TestList_LPos(t *testing.T) {
+	list := New()
+	key := "myList"
+	for _, v := range []string{"a", "b", "c", "b", "b", "d"} {
+		list.RPush(key, []byte(v))
+	}
+
+	tests := []struct {
+		opts *LPosOptions
+		want string
+	}{
+		{nil, "[1]"},
+		{&LPosOptions{}, "[1 3 4]"},
+		{&LPosOptions{Rank: 2}, "[3 4]"},
+		{&LPosOptions{Rank: -1, Count: 2}, "[4 3]"},
+		{&LPosOptions{MaxLen: 4}, "[1 3]"},
+	}
+
+	for _, test := range tests {
+		positions, err := list.LPos(key, []byte("b"), test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(positions); got != test.want {
+			t.Errorf("LPos(%+v): got %s, want %s", test.opts, got, test.want)
+		}
+	}
+
+	if positions, _ := list.LPos(key, []byte("z"), nil); len(positions) != 0 {
+		t.Errorf("expected no position, got %v", positions)
+	}
+}
+
+func bytesJoin(items [][]byte) []byte {
+	var b []byte
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package set
+
+import (
+	"errors"
+)
+
+// ErrCapacity is returned when the capacity of a capped set is not positive.
+var ErrCapacity = errors.New("the capacity of the set must be positive")
+
+// EvictPolicy represents which members are evicted from a set over its capacity.
+type EvictPolicy int
+
+const (
+	// EvictFIFO represents evicting the members added first.
+	EvictFIFO EvictPolicy = iota
+
+	// EvictRandom represents evicting random members.
+	EvictRandom
+)
+
+// orderedItem represents a member at its insertion sequence.
+type orderedItem struct {
+	item string
+	seq  uint64
+}
+
+// pushOrder records the member newly added to the set stored at key.
+func (s *Set) pushOrder(key, item string) {
+	if s.seq == nil {
+		s.seq = make(map[string]map[string]uint64)
+		s.order = make(map[string][]orderedItem)
+	}
+
+	if _, ok := s.seq[key]; !ok {
+		s.seq[key] = make(map[string]uint64)
+	}
+
+	s.nextSeq++
+	s.seq[key][item] = s.nextSeq
+	s.order[key] = append(s.order[key], orderedItem{item: item, seq: s.nextSeq})
+}
+
+// removeOrder forgets the member removed from the set stored at key. The stale entries of
+// the order are skipped lazily, and dropped once they outnumber the members.
+func (s *Set) removeOrder(key, item string) {
+	if _, ok := s.seq[key]; !ok {
+		return
+	}
+
+	delete(s.seq[key], item)
+
+	if order := s.order[key]; len(order) > 2*len(s.seq[key])+16 {
+		compacted := make([]orderedItem, 0, len(s.seq[key]))
+		for _, o := range order {
+			if s.seq[key][o.item] == o.seq {
+				compacted = append(compacted, o)
+			}
+		}
+		s.order[key] = compacted
+	}
+}
+
+// oldest returns at most n members of the set stored at key in insertion order.
+func (s *Set) oldest(key string, n int) (items []string) {
+	for _, o := range s.order[key] {
+		if len(items) >= n {
+			break
+		}
+		if s.seq[key][o.item] == o.seq {
+			items = append(items, o.item)
+		}
+	}
+
+	return
+}
+
+// CapEvictions returns the members of items to add to the set stored at key and the
+// members to remove from it, for the set holding at most max members after adding items.
+// The new members are the youngest ones: when they alone are more than max, the first
+// ones are not added. The set is not modified.
+func (s *Set) CapEvictions(key string, max int, policy EvictPolicy, items ...[]byte) (added, evicted [][]byte, err error) {
+	if max <= 0 {
+		return nil, nil, ErrCapacity
+	}
+
+	seen := make(map[string]struct{}, len(items))
+	var fresh [][]byte
+	for _, item := range items {
+		if _, ok := s.M[key][string(item)]; ok {
+			continue
+		}
+		if _, ok := seen[string(item)]; ok {
+			continue
+		}
+		seen[string(item)] = struct{}{}
+		fresh = append(fresh, item)
+	}
+
+	if len(fresh) > max {
+		fresh = fresh[len(fresh)-max:]
+	}
+
+	over := len(s.M[key]) + len(fresh) - max
+	if over <= 0 {
+		return fresh, nil, nil
+	}
+
+	switch policy {
+	case EvictRandom:
+		for item := range s.M[key] {
+			if len(evicted) >= over {
+				break
+			}
+			evicted = append(evicted, []byte(item))
+		}
+	default:
+		for _, item := range s.oldest(key, over) {
+			evicted = append(evicted, []byte(item))
+		}
+	}
+
+	return fresh, evicted, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package set
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSet_CapEvictions(t *testing.T) {
+	s := New()
+	key := "mySet"
+	s.SAdd(key, []byte("a"), []byte("b"), []byte("c"))
+	s.SRem(key, []byte("a"))
+	s.SAdd(key, []byte("a"))
+
+	if _, _, err := s.CapEvictions(key, 0, EvictFIFO); err != ErrCapacity {
+		t.Errorf("expected ErrCapacity, got %v", err)
+	}
+
+	added, evicted, err := s.CapEvictions(key, 4, EvictFIFO, []byte("d"), []byte("b"), []byte("e"), []byte("d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%s %s", added, evicted) != "[d e] [b]" {
+		t.Errorf("TestSet_CapEvictions err: added %s, evicted %s", added, evicted)
+	}
+
+	added, evicted, _ = s.CapEvictions(key, 2, EvictFIFO, []byte("d"), []byte("e"), []byte("f"))
+	if fmt.Sprintf("%s %s", added, evicted) != "[e f] [b c a]" {
+		t.Errorf("TestSet_CapEvictions err: added %s, evicted %s", added, evicted)
+	}
+
+	added, evicted, _ = s.CapEvictions(key, 3, EvictRandom, []byte("d"))
+	if len(added) != 1 || len(evicted) != 1 || !s.SIsMember(key, evicted[0]) {
+		t.Errorf("TestSet_CapEvictions err: added %s, evicted %s", added, evicted)
+	}
+}
+
+func TestSet_OrderCompaction(t *testing.T) {
+	s := New()
+	key := "mySet"
+	for i := 0; i < 100; i++ {
+		s.SAdd(key, []byte(fmt.Sprint(i)))
+		if i >= 2 {
+			s.SRem(key, []byte(fmt.Sprint(i-2)))
+		}
+	}
+
+	if len(s.order[key]) > 2*s.SCard(key)+16 {
+		t.Errorf("TestSet_OrderCompaction err: %d stale entries", len(s.order[key]))
+	}
+
+	if got := fmt.Sprint(s.oldest(key, 5)); got != "[98 99]" {
+		t.Errorf("TestSet_OrderCompaction err: got %s", got)
+	}
+}
@@ -16,11 +16,21 @@ package set
 
 import (
 	"errors"
+	"path"
+	"sort"
 )
 
+// DefaultScanCount represents the page size of SScan when count is not positive.
+const DefaultScanCount = 10
+
 // Set represents the Set.
 type Set struct {
 	M map[string]map[string]struct{}
+
+	// seq and order record the insertion order of the members, for evicting the oldest ones.
+	seq     map[string]map[string]uint64
+	order   map[string][]orderedItem
+	nextSeq uint64
 }
 
 // New returns a newly initialized Set Object that implements the Set.
@@ -37,13 +47,16 @@ func (s *Set) SAdd(key string, items ...[]byte) error {
 	}
 
 	for _, item := range items {
+		if _, ok := s.M[key][string(item)]; !ok {
+			s.pushOrder(key, string(item))
+		}
 		s.M[key][string(item)] = struct{}{}
 	}
 
 	return nil
 }
 
-//SRem removes the specified members from the set stored at key.
+// SRem removes the specified members from the set stored at key.
 func (s *Set) SRem(key string, items ...[]byte) error {
 	if _, ok := s.M[key]; !ok {
 		return errors.New("key not found")
@@ -55,6 +68,7 @@ func (s *Set) SRem(key string, items ...[]byte) error {
 
 	for _, item := range items {
 		delete(s.M[key], string(item))
+		s.removeOrder(key, string(item))
 	}
 
 	return nil
@@ -77,13 +91,14 @@ func (s *Set) SPop(key string) []byte {
 
 	for item := range s.M[key] {
 		delete(s.M[key], item)
+		s.removeOrder(key, item)
 		return []byte(item)
 	}
 
 	return nil
 }
 
-//SCard Returns the set cardinality (number of elements) of the set stored at key.
+// SCard Returns the set cardinality (number of elements) of the set stored at key.
 func (s *Set) SCard(key string) int {
 	if !s.SHasKey(key) {
 		return 0
@@ -92,7 +107,7 @@ func (s *Set) SCard(key string) int {
 	return len(s.M[key])
 }
 
-//SDiff Returns the members of the set resulting from the difference between the first set and all the successive sets.
+// SDiff Returns the members of the set resulting from the difference between the first set and all the successive sets.
 func (s *Set) SDiff(key1, key2 string) (list [][]byte, err error) {
 	if _, err = s.checkKey1AndKey2(key1, key2); err != nil {
 		return
@@ -107,7 +122,7 @@ func (s *Set) SDiff(key1, key2 string) (list [][]byte, err error) {
 	return
 }
 
-//SInter Returns the members of the set resulting from the intersection of all the given sets.
+// SInter Returns the members of the set resulting from the intersection of all the given sets.
 func (s *Set) SInter(key1, key2 string) (list [][]byte, err error) {
 	if _, err = s.checkKey1AndKey2(key1, key2); err != nil {
 		return
@@ -135,7 +150,7 @@ func (s *Set) checkKey1AndKey2(key1, key2 string) (list [][]byte, err error) {
 	return nil, nil
 }
 
-//SIsMember Returns if member is a member of the set stored at key.
+// SIsMember Returns if member is a member of the set stored at key.
 func (s *Set) SIsMember(key string, item []byte) bool {
 	if _, ok := s.M[key]; !ok {
 		return false
@@ -196,7 +211,7 @@ func (s *Set) SMove(key1, key2 string, item []byte) (bool, error) {
 	return true, nil
 }
 
-//SUnion returns the members of the set resulting from the union of all the given sets.
+// SUnion returns the members of the set resulting from the union of all the given sets.
 func (s *Set) SUnion(key1, key2 string) (list [][]byte, err error) {
 	if _, err = s.checkKey1AndKey2(key1, key2); err != nil {
 		return
@@ -214,3 +229,49 @@ func (s *Set) SUnion(key1, key2 string) (list [][]byte, err error) {
 
 	return
 }
+
+// SScan returns a page of at most count members of the set stored at key in
+// bytewise order, from the member following cursor, nil meaning the first one.
+// When match is not empty, only the members matching the pattern, with the
+// syntax of path.Match, are returned. The returned next cursor is nil after the
+// last page. A member added or removed during the scan may be returned or not,
+// the others are returned exactly once.
+func (s *Set) SScan(key string, cursor []byte, match string, count int) (list [][]byte, next []byte, err error) {
+	if _, ok := s.M[key]; !ok {
+		return nil, nil, errors.New("set not exists")
+	}
+
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	if _, err := path.Match(match, ""); err != nil {
+		return nil, nil, err
+	}
+
+	var members []string
+	for item := range s.M[key] {
+		if cursor != nil && item <= string(cursor) {
+			continue
+		}
+		if match != "" {
+			if ok, _ := path.Match(match, item); !ok {
+				continue
+			}
+		}
+		members = append(members, item)
+	}
+
+	sort.Strings(members)
+
+	if len(members) > count {
+		members = members[:count]
+		next = []byte(members[count-1])
+	}
+
+	for _, item := range members {
+		list = append(list, []byte(item))
+	}
+
+	return list, next, nil
+}
@@ -302,3 +302,45 @@ func TestSet_SUnion(t *testing.T) {
 		}
 	}
 }
+
+func TestSet_SScan(t *testing.T) {
+	s := New()
+	key := "mySet"
+	s.SAdd(key, []byte("a1"), []byte("a2"), []byte("b1"), []byte("a3"), []byte("b2"))
+
+	var (
+		cursor []byte
+		got    string
+		pages  int
+	)
+	for {
+		list, next, err := s.SScan(key, cursor, "", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range list {
+			got += string(item) + " "
+		}
+		pages++
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	if got != "a1 a2 a3 b1 b2 " || pages != 3 {
+		t.Errorf("TestSet_SScan err: got %q in %d pages", got, pages)
+	}
+
+	list, next, err := s.SScan(key, nil, "b*", 0)
+	if err != nil || next != nil || len(list) != 2 || string(list[0]) != "b1" {
+		t.Errorf("TestSet_SScan err: got %q, %q, %v", list, next, err)
+	}
+
+	if _, _, err := s.SScan(key, nil, "[", 0); err == nil {
+		t.Error("TestSet_SScan err: expected the bad pattern error")
+	}
+
+	if _, _, err := s.SScan("fake", nil, "", 0); err == nil {
+		t.Error("TestSet_SScan err: expected the set not exists error")
+	}
+}
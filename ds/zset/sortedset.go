@@ -20,6 +20,7 @@
 package zset
 
 import (
+	"errors"
 	"math/rand"
 )
 
@@ -461,6 +462,96 @@ func (ss *SortedSet) GetByRankRange(start, end int, remove bool) []*SortedSetNod
 	return nodes
 }
 
+// ErrInvalidLexRange is returned when a lex range bound is not valid.
+var ErrInvalidLexRange = errors.New("lex range bound must start with '[', '(' or be '-' or '+'")
+
+// LexRange represents a range of member keys, with the bounds of ZRANGEBYLEX:
+// "[a" includes a, "(a" excludes a, "-" and "+" are the lowest and the highest keys.
+type LexRange struct {
+	min, max       string
+	minInf, maxInf bool
+	excludeMin     bool
+	excludeMax     bool
+}
+
+// ParseLexRange returns the LexRange between the bounds min and max.
+func ParseLexRange(min, max string) (*LexRange, error) {
+	r := &LexRange{}
+
+	var err error
+	if r.min, r.minInf, r.excludeMin, err = parseLexBound(min, "-"); err != nil {
+		return nil, err
+	}
+
+	if r.max, r.maxInf, r.excludeMax, err = parseLexBound(max, "+"); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func parseLexBound(bound, inf string) (key string, isInf, exclude bool, err error) {
+	switch {
+	case bound == inf:
+		return "", true, false, nil
+	case len(bound) > 0 && bound[0] == '[':
+		return bound[1:], false, false, nil
+	case len(bound) > 0 && bound[0] == '(':
+		return bound[1:], false, true, nil
+	}
+
+	return "", false, false, ErrInvalidLexRange
+}
+
+// aboveMin reports whether key is not below the min bound of r.
+func (r *LexRange) aboveMin(key string) bool {
+	if r.minInf {
+		return true
+	}
+
+	if r.excludeMin {
+		return key > r.min
+	}
+
+	return key >= r.min
+}
+
+// belowMax reports whether key is not above the max bound of r.
+func (r *LexRange) belowMax(key string) bool {
+	if r.maxInf {
+		return true
+	}
+
+	if r.excludeMax {
+		return key < r.max
+	}
+
+	return key <= r.max
+}
+
+// GetByLexRange returns the nodes whose key is within the lex range, in the order of the set.
+// Like ZRANGEBYLEX, it is meant for the members sharing a score, which are ordered by key.
+// If remove is true, the returned nodes are removed.
+//
+// Time complexity of this method is : O(N).
+func (ss *SortedSet) GetByLexRange(r *LexRange, remove bool) []*SortedSetNode {
+	var nodes []*SortedSetNode
+
+	for x := ss.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if r.aboveMin(x.key) && r.belowMax(x.key) {
+			nodes = append(nodes, x)
+		}
+	}
+
+	if remove {
+		for _, n := range nodes {
+			ss.Remove(n.key)
+		}
+	}
+
+	return nodes
+}
+
 func (ss *SortedSet) sanitizeIndexes(start, end int) (newStart, newEnd int) {
 	if start < 0 {
 		start = int(ss.length) + start + 1
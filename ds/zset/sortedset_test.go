@@ -4,7 +4,7 @@
 // you may not use this file except in compliance with the License.
 // You may obtain a copy of the License at
 //
-//     http://www.apache.org/licenses/LICENSE-2.0
+//	http://www.apache.org/licenses/LICENSE-2.0
 //
 // Unless required by applicable law or agreed to in writing, software
 // distributed under the License is distributed on an "AS IS" BASIS,
@@ -364,3 +364,50 @@ func getResultSet(items ...string) map[string]struct{} {
 
 	return resultSet
 }
+
+func TestSortedSet_GetByLexRange(t *testing.T) {
+	ss = New()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		ss.Put(key, 0, []byte(key))
+	}
+
+	tests := []struct {
+		min, max string
+		want     string
+	}{
+		{"-", "+", "abcde"},
+		{"[b", "[d", "bcd"},
+		{"(b", "(d", "c"},
+		{"-", "(c", "ab"},
+		{"[d", "+", "de"},
+		{"[x", "+", ""},
+	}
+
+	for _, test := range tests {
+		r, err := ParseLexRange(test.min, test.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := ""
+		for _, n := range ss.GetByLexRange(r, false) {
+			got += n.Key()
+		}
+		if got != test.want {
+			t.Errorf("GetByLexRange(%s, %s): got %q, want %q", test.min, test.max, got, test.want)
+		}
+	}
+
+	if _, err := ParseLexRange("b", "+"); err != ErrInvalidLexRange {
+		t.Errorf("expected ErrInvalidLexRange, got %v", err)
+	}
+
+	r, _ := ParseLexRange("[b", "(d")
+	if n := len(ss.GetByLexRange(r, true)); n != 2 {
+		t.Fatalf("expected 2 removed nodes, got %d", n)
+	}
+
+	if ss.Size() != 3 || ss.GetByKey("b") != nil || ss.GetByKey("c") != nil {
+		t.Error("expected b and c to be removed")
+	}
+}
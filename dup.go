@@ -0,0 +1,207 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	// ErrNotDupBucket is returned when PutDup, GetDups, DeleteDup, DeleteDups or ForEachDup
+	// is called on a bucket not allowing duplicate keys, see Options.DupBucket.
+	ErrNotDupBucket = errors.New("bucket does not allow duplicate keys")
+
+	// ErrDupBucket is returned when Put is called on a bucket allowing duplicate keys.
+	ErrDupBucket = errors.New("bucket allows duplicate keys, use PutDup")
+)
+
+// dupKeyEscape, dupKeyEnd escape the 0x00 bytes of the keys of the duplicate key buckets
+// and end them, so that the stored keys are ordered by key, then by value.
+var (
+	dupKeyEscape = []byte{0x00, 0xff}
+	dupKeyEnd    = []byte{0x00, 0x01}
+)
+
+// isDupBucket returns if the bucket allows duplicate keys.
+func (db *DB) isDupBucket(bucket string) bool {
+	return db.opt.DupBucket != nil && db.opt.DupBucket(bucket)
+}
+
+// checkDupBucket returns an error if the bucket does not allow duplicate keys.
+func (tx *Tx) checkDupBucket(bucket string) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if !tx.db.isDupBucket(bucket) {
+		return ErrNotDupBucket
+	}
+
+	return nil
+}
+
+// encodeDupKey returns the stored key of the value of the key, the escaped key, its end and the value.
+func encodeDupKey(key, value []byte) []byte {
+	buf := make([]byte, 0, len(key)+len(dupKeyEnd)+len(value)+bytes.Count(key, []byte{0x00}))
+	buf = appendDupKeyPrefix(buf, key)
+	buf = append(buf, dupKeyEnd...)
+
+	return append(buf, value...)
+}
+
+// appendDupKeyPrefix appends the escaped key, the prefix of the stored keys of the keys it prefixes.
+func appendDupKeyPrefix(buf, key []byte) []byte {
+	for _, c := range key {
+		if c == 0x00 {
+			buf = append(buf, dupKeyEscape...)
+		} else {
+			buf = append(buf, c)
+		}
+	}
+
+	return buf
+}
+
+// decodeDupKey returns the key and the value of a stored key.
+func decodeDupKey(stored []byte) (key, value []byte, err error) {
+	key = make([]byte, 0, len(stored))
+	for i := 0; i < len(stored); i++ {
+		if stored[i] != 0x00 {
+			key = append(key, stored[i])
+			continue
+		}
+
+		if i+1 == len(stored) {
+			break
+		}
+
+		switch stored[i+1] {
+		case dupKeyEscape[1]:
+			key = append(key, 0x00)
+			i++
+		case dupKeyEnd[1]:
+			return key, stored[i+2:], nil
+		default:
+			return nil, nil, ErrCorrupted
+		}
+	}
+
+	return nil, nil, ErrCorrupted
+}
+
+// PutDup adds the value to the values of the key in the bucket, which must allow duplicate
+// keys, see Options.DupBucket. The values of a key are sorted, and adding a value twice
+// only sets its TTL. It is not supported in HintBPTSparseIdxMode.
+func (tx *Tx) PutDup(bucket string, key, value []byte, ttl uint32) error {
+	if err := tx.checkDupBucket(bucket); err != nil {
+		return err
+	}
+
+	if len(key) == 0 {
+		return ErrKeyEmpty
+	}
+
+	return tx.put(bucket, encodeDupKey(key, value), nil, ttl, DataSetFlag, tx.now(), DataStructureBPTree)
+}
+
+// GetDups returns the sorted values of the key in the bucket, which must allow duplicate keys.
+func (tx *Tx) GetDups(bucket string, key []byte) ([][]byte, error) {
+	if err := tx.checkDupBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	var values [][]byte
+
+	prefix := append(appendDupKeyPrefix(nil, key), dupKeyEnd...)
+	err := tx.forEachDup(bucket, prefix, func(_, value []byte) bool {
+		values = append(values, value)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values) == 0 {
+		return nil, ErrNotFoundKey
+	}
+
+	return values, nil
+}
+
+// DeleteDup removes the value from the values of the key in the bucket, which must allow
+// duplicate keys.
+func (tx *Tx) DeleteDup(bucket string, key, value []byte) error {
+	if err := tx.checkDupBucket(bucket); err != nil {
+		return err
+	}
+
+	return tx.Delete(bucket, encodeDupKey(key, value))
+}
+
+// DeleteDups removes all the values of the key in the bucket, which must allow duplicate keys.
+func (tx *Tx) DeleteDups(bucket string, key []byte) error {
+	values, err := tx.GetDups(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		if err := tx.Delete(bucket, encodeDupKey(key, value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ForEachDup calls fn for every value of the keys of the bucket starting with prefix, sorted
+// by key then by value, until fn returns false. The bucket must allow duplicate keys.
+func (tx *Tx) ForEachDup(bucket string, prefix []byte, fn func(key, value []byte) bool) error {
+	if err := tx.checkDupBucket(bucket); err != nil {
+		return err
+	}
+
+	return tx.forEachDup(bucket, appendDupKeyPrefix(nil, prefix), fn)
+}
+
+// forEachDup calls fn for every value of the stored keys of the bucket starting with the
+// stored prefix, until fn returns false.
+func (tx *Tx) forEachDup(bucket string, prefix []byte, fn func(key, value []byte) bool) error {
+	entries, err := tx.PrefixScan(bucket, prefix, ScanNoLimit)
+	if err == ErrPrefixScan {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		key, value, err := decodeDupKey(e.Key)
+		if err != nil {
+			return err
+		}
+
+		if !fn(key, value) {
+			return nil
+		}
+	}
+
+	return nil
+}
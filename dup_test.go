@@ -0,0 +1,135 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDupKey_Encoding(t *testing.T) {
+	pairs := [][2][]byte{
+		{[]byte("a"), []byte("z")},
+		{[]byte("a"), []byte("zz")},
+		{[]byte("a\x00"), []byte("")},
+		{[]byte("a\x00b"), []byte("\x00")},
+		{[]byte("ab"), []byte("a")},
+		{[]byte("b"), nil},
+	}
+
+	var prev []byte
+	for _, p := range pairs {
+		stored := encodeDupKey(p[0], p[1])
+		if prev != nil && bytes.Compare(prev, stored) >= 0 {
+			t.Errorf("err encodeDupKey order. %q not after %q", stored, prev)
+		}
+		prev = stored
+
+		key, value, err := decodeDupKey(stored)
+		if err != nil || !bytes.Equal(key, p[0]) || !bytes.Equal(value, p[1]) {
+			t.Errorf("err decodeDupKey %q. got %q, %q, %v", stored, key, value, err)
+		}
+	}
+
+	if _, _, err := decodeDupKey([]byte("a\x00\x02")); err != ErrCorrupted {
+		t.Errorf("err decodeDupKey of a bad key. got %v want ErrCorrupted", err)
+	}
+}
+
+func TestTx_PutDup(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdup", true)
+	opt.DupBucket = func(bucket string) bool {
+		return bucket == "followers"
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		for _, f := range []string{"carol", "alice", "bob", "alice"} {
+			if err := tx.PutDup("followers", []byte("user1"), []byte(f), Persistent); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < 3; i++ {
+			if err := tx.PutDup("followers", []byte("user10"), []byte(fmt.Sprintf("f%d", i)), Persistent); err != nil {
+				return err
+			}
+		}
+		return tx.PutDup("followers", []byte("user2"), []byte("dave"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	getDups := func(key string) string {
+		var values [][]byte
+		if err := db.View(func(tx *Tx) error {
+			values, err = tx.GetDups("followers", []byte(key))
+			return err
+		}); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Fatal(err)
+		}
+		return string(bytes.Join(values, []byte(",")))
+	}
+
+	if got := getDups("user1"); got != "alice,bob,carol" {
+		t.Errorf("err GetDups. got %s", got)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		var pairs []string
+		err := tx.ForEachDup("followers", []byte("user1"), func(key, value []byte) bool {
+			pairs = append(pairs, string(key)+"="+string(value))
+			return len(pairs) < 5
+		})
+		if got := fmt.Sprint(pairs); got != "[user1=alice user1=bob user1=carol user10=f0 user10=f1]" {
+			t.Errorf("err ForEachDup. got %s", got)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.DeleteDup("followers", []byte("user1"), []byte("bob")); err != nil {
+			return err
+		}
+		return tx.DeleteDups("followers", []byte("user10"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getDups("user1"); got != "alice,carol" {
+		t.Errorf("err GetDups after DeleteDup. got %s", got)
+	}
+	if got := getDups("user10"); got != "" {
+		t.Errorf("err GetDups after DeleteDups. got %s", got)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("followers", []byte("user1"), []byte("eve"), Persistent)
+	}); err != ErrDupBucket {
+		t.Errorf("err Put in a dup bucket. got %v want ErrDupBucket", err)
+	}
+	if err := db.Update(func(tx *Tx) error {
+		return tx.PutDup("bucket", []byte("user1"), []byte("eve"), Persistent)
+	}); err != ErrNotDupBucket {
+		t.Errorf("err PutDup in a bucket. got %v want ErrNotDupBucket", err)
+	}
+}
@@ -49,9 +49,28 @@ type (
 		txID       uint64
 		status     uint16 // committed / uncommitted
 		ds         uint16 // data structure
+		siteID     uint16 // the SiteID of the options of the writer
+		seq        uint32 // orders the writes of a second, see stampClock
 	}
 )
 
+// SiteID returns the site ID of the database which wrote the entry, see Options.SiteID.
+func (meta *MetaData) SiteID() uint16 {
+	return meta.siteID
+}
+
+// Timestamp returns the time the entry was written in Unix seconds, e.g. for
+// reconciling the writes of several sites by last-writer-wins.
+func (meta *MetaData) Timestamp() uint64 {
+	return meta.timestamp
+}
+
+// Seq returns the rank of the entry among the writes of its timestamp, which orders the
+// writes of a database with a SiteID within a second, 0 for the other databases.
+func (meta *MetaData) Seq() uint32 {
+	return meta.seq
+}
+
 // Size returns the size of the entry.
 func (e *Entry) Size() int64 {
 	return int64(DataEntryHeaderSize + e.Meta.keySize + e.Meta.valueSize + e.Meta.bucketSize)
@@ -59,20 +78,34 @@ func (e *Entry) Size() int64 {
 
 // Encode returns the slice after the entry be encoded.
 //
-//  the entry stored format:
-//  |----------------------------------------------------------------------------------------------------------------|
-//  |  crc  | timestamp | ksz | valueSize | flag  | TTL  |bucketSize| status | ds   | txId |  bucket |  key  | value |
-//  |----------------------------------------------------------------------------------------------------------------|
-//  | uint32| uint64  |uint32 |  uint32 | uint16  | uint32| uint32 | uint16 | uint16 |uint64 |[]byte|[]byte | []byte |
-//  |----------------------------------------------------------------------------------------------------------------|
+//	the entry stored format:
+//	|----------------------------------------------------------------------------------------------------------------|
+//	|  crc  | timestamp | ksz | valueSize | flag  | TTL  |bucketSize| status | ds   | txId |  bucket |  key  | value |
+//	|----------------------------------------------------------------------------------------------------------------|
+//	| uint32| uint64  |uint32 |  uint32 | uint16  | uint32| uint32 | uint16 | uint16 |uint64 |[]byte|[]byte | []byte |
+//	|----------------------------------------------------------------------------------------------------------------|
 //
+// The crc covers everything after it, the value included, so a corrupted value is
+// detected when the entry is read rather than returned. The status and the ds only
+// use the low byte of their field, the high bytes hold the low and the high byte of
+// the site ID, 0 in the entries written without Options.SiteID.
 func (e *Entry) Encode() []byte {
+	return e.encodeTo(nil)
+}
+
+// encodeTo encodes the entry into buf, reusing its capacity, and returns it.
+// The crc is computed in one pass over the header, bucket, key and value.
+func (e *Entry) encodeTo(buf []byte) []byte {
 	keySize := e.Meta.keySize
 	valueSize := e.Meta.valueSize
 	bucketSize := e.Meta.bucketSize
 
 	//set DataItemHeader buf
-	buf := make([]byte, e.Size())
+	size := int(e.Size())
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
 	buf = e.setEntryHeaderBuf(buf)
 	//set bucket\key\value
 	copy(buf[DataEntryHeaderSize:(DataEntryHeaderSize+bucketSize)], e.Meta.bucket)
@@ -87,14 +120,14 @@ func (e *Entry) Encode() []byte {
 
 // setEntryHeaderBuf sets the entry header buff.
 func (e *Entry) setEntryHeaderBuf(buf []byte) []byte {
-	binary.LittleEndian.PutUint64(buf[4:12], e.Meta.timestamp)
+	binary.LittleEndian.PutUint64(buf[4:12], packTimestamp(e.Meta.timestamp, e.Meta.seq))
 	binary.LittleEndian.PutUint32(buf[12:16], e.Meta.keySize)
 	binary.LittleEndian.PutUint32(buf[16:20], e.Meta.valueSize)
 	binary.LittleEndian.PutUint16(buf[20:22], e.Meta.Flag)
 	binary.LittleEndian.PutUint32(buf[22:26], e.Meta.TTL)
 	binary.LittleEndian.PutUint32(buf[26:30], e.Meta.bucketSize)
-	binary.LittleEndian.PutUint16(buf[30:32], e.Meta.status)
-	binary.LittleEndian.PutUint16(buf[32:34], e.Meta.ds)
+	binary.LittleEndian.PutUint16(buf[30:32], e.Meta.status|e.Meta.siteID<<8)
+	binary.LittleEndian.PutUint16(buf[32:34], e.Meta.ds|e.Meta.siteID&0xff00)
 	binary.LittleEndian.PutUint64(buf[34:42], e.Meta.txID)
 
 	return buf
@@ -117,3 +150,10 @@ func (e *Entry) GetCrc(buf []byte) uint32 {
 
 	return crc
 }
+
+// checksum returns the crc of the entry with given header, followed by payload,
+// the bucket, key and value read at once. hash/crc32 uses the CLMUL instructions
+// where available, so hashing them in one call is as fast as the CPU goes.
+func checksum(header, payload []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, payload)
+}
@@ -15,6 +15,7 @@
 package nutsdb
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -48,3 +49,99 @@ func TestEntry_All(t *testing.T) {
 		t.Errorf("err entry.GetCrc got %d want %d", entry.GetCrc(entry.Encode()), 2777557425)
 	}
 }
+
+func TestEntry_EncodeTo(t *testing.T) {
+	newEntry := func(key, value string) *Entry {
+		return &Entry{
+			Key:   []byte(key),
+			Value: []byte(value),
+			Meta: &MetaData{
+				keySize:    uint32(len(key)),
+				valueSize:  uint32(len(value)),
+				timestamp:  1547707905,
+				TTL:        Persistent,
+				bucket:     []byte("test_entry"),
+				bucketSize: uint32(len("test_entry")),
+				Flag:       DataSetFlag,
+			},
+		}
+	}
+
+	buf := make([]byte, 0, 128)
+	for _, e := range []*Entry{newEntry("key_0001", "a long value"), newEntry("k", "v")} {
+		got := e.encodeTo(buf)
+		if !bytes.Equal(got, e.Encode()) {
+			t.Errorf("err TestEntry_EncodeTo got %v want %v", got, e.Encode())
+		}
+		if &got[0] != &buf[:1][0] {
+			t.Error("err TestEntry_EncodeTo: buffer not reused")
+		}
+
+		header, payload := got[:DataEntryHeaderSize], got[DataEntryHeaderSize:]
+		if crc := checksum(header, payload); crc != e.GetCrc(header) {
+			t.Errorf("err checksum got %d want %d", crc, e.GetCrc(header))
+		}
+	}
+}
+
+func TestEntry_SiteID(t *testing.T) {
+	entry := Entry{
+		Key:   []byte("key"),
+		Value: []byte("val"),
+		Meta: &MetaData{
+			keySize:   3,
+			valueSize: 3,
+			timestamp: 1547707905,
+			Flag:      DataRPushFlag,
+			status:    Committed,
+			ds:        DataStructureList,
+			siteID:    0x1234,
+		},
+	}
+
+	meta := readMetaData(entry.Encode())
+	if meta.SiteID() != 0x1234 || meta.status != Committed || meta.ds != DataStructureList {
+		t.Errorf("err decode the site ID. got site %#x status %d ds %d", meta.SiteID(), meta.status, meta.ds)
+	}
+
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestsiteid", true)
+		opt.EntryIdxMode = mode
+		opt.SiteID = 0x0102
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the entries written by another site keep their site ID.
+		opt.SiteID = 7
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.View(func(tx *Tx) error {
+			e, err := tx.Get("bucket", []byte("key"))
+			if err != nil {
+				return err
+			}
+			if e.Meta.SiteID() != 0x0102 || e.Meta.Timestamp() == 0 {
+				t.Errorf("err Get in mode %d. got site %#x timestamp %d", mode, e.Meta.SiteID(), e.Meta.Timestamp())
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
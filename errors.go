@@ -0,0 +1,161 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"io"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+var (
+	// ErrCorrupted is the sentinel matched by errors.Is for every error
+	// caused by data that fails to decode or verify.
+	ErrCorrupted = errors.New("data corrupted")
+
+	// ErrUnsupportedMode is the sentinel matched by errors.Is when an operation
+	// is not supported under the configured EntryIdxMode.
+	ErrUnsupportedMode = errors.New("unsupported mode")
+
+	// ErrBucketNotFound is the sentinel matched by errors.Is when a bucket does not exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+
+	// ErrNotSupportHintBPTSparseIdxMode is returned when the operation does not support HintBPTSparseIdxMode.
+	ErrNotSupportHintBPTSparseIdxMode = wrapError("not support mode `HintBPTSparseIdxMode`", ErrUnsupportedMode)
+
+	// ErrMergeFileCount is returned when there are not enough files to merge.
+	ErrMergeFileCount = errors.New("the number of files waiting to be merged is at least 2")
+
+	// ErrMergeInProgress is returned when a merge is started while another one runs.
+	ErrMergeInProgress = errors.New("a merge is already in progress")
+)
+
+// sentinelError is an error with its own message that also matches a more general sentinel.
+type sentinelError struct {
+	msg string
+	err error
+}
+
+// wrapError returns an error with the given message that unwraps to err.
+func wrapError(msg string, err error) error {
+	return &sentinelError{msg: msg, err: err}
+}
+
+// Error implements the error interface.
+func (e *sentinelError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the general sentinel.
+func (e *sentinelError) Unwrap() error {
+	return e.err
+}
+
+// BucketError records a failed lookup of a key in a bucket.
+// It unwraps to ErrBucketNotFound or ErrKeyNotFound.
+type BucketError struct {
+	Bucket string
+	Key    []byte
+	Err    error
+}
+
+// Error implements the error interface.
+func (e *BucketError) Error() string {
+	if e.Err == ErrKeyNotFound {
+		return string(e.Key) + " is not in the" + e.Bucket
+	}
+
+	return "not found bucket:" + e.Bucket + ",key:" + string(e.Key)
+}
+
+// Unwrap returns the underlying sentinel error.
+func (e *BucketError) Unwrap() error {
+	return e.Err
+}
+
+// ModeError records an operation rejected because of the EntryIdxMode option.
+// It unwraps to ErrUnsupportedMode.
+type ModeError struct {
+	Reason string
+	Mode   EntryIdxMode
+}
+
+// Error implements the error interface.
+func (e *ModeError) Error() string {
+	return e.Reason
+}
+
+// Unwrap returns ErrUnsupportedMode.
+func (e *ModeError) Unwrap() error {
+	return ErrUnsupportedMode
+}
+
+// EntryError records a failed read of the entry at Offset of the data file FileID,
+// so that the corrupt region can be located. It unwraps to Cause.
+type EntryError struct {
+	FileID int64
+	Offset int64
+	Cause  error
+}
+
+// Error implements the error interface.
+func (e *EntryError) Error() string {
+	return "entry at offset " + strconv2.Int64ToStr(e.Offset) + " of data file " +
+		strconv2.Int64ToStr(e.FileID) + DataSuffix + ": " + e.Cause.Error()
+}
+
+// Unwrap returns the cause.
+func (e *EntryError) Unwrap() error {
+	return e.Cause
+}
+
+// newEntryError returns an EntryError at given fid and off, or err itself if it is nil or io.EOF,
+// which only marks the end of the entries.
+func newEntryError(fID int64, off int64, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	return &EntryError{FileID: fID, Offset: off, Cause: err}
+}
+
+// RecoveryError records an entry of the data file FileID at Offset failing to decode
+// when opening the database with RecoveryStrict. It unwraps to Cause and matches ErrCorrupted.
+type RecoveryError struct {
+	FileID int64
+	Offset uint64
+	Bucket string
+	Key    []byte
+	Flag   uint16
+	Cause  error
+}
+
+// Error implements the error interface.
+func (e *RecoveryError) Error() string {
+	return "recovery of the entry at offset " + strconv2.Int64ToStr(int64(e.Offset)) + " of data file " +
+		strconv2.Int64ToStr(e.FileID) + DataSuffix + " (bucket " + e.Bucket + ", flag " +
+		strconv2.IntToStr(int(e.Flag)) + "): " + e.Cause.Error()
+}
+
+// Unwrap returns the cause.
+func (e *RecoveryError) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports if target is ErrCorrupted.
+func (e *RecoveryError) Is(target error) bool {
+	return target == ErrCorrupted
+}
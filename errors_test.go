@@ -0,0 +1,156 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestErrors_Is(t *testing.T) {
+	cases := []struct {
+		err    error
+		target error
+	}{
+		{ErrCrc, ErrCorrupted},
+		{ErrCrcZero, ErrCorrupted},
+		{ErrKeyAndValSize, ErrCapacity},
+		{ErrNotFoundKey, ErrKeyNotFound},
+		{ErrBucket, ErrBucketNotFound},
+		{ErrNotSupportHintBPTSparseIdxMode, ErrUnsupportedMode},
+		{ErrBucketAndKey("bucket", []byte("key")), ErrBucketNotFound},
+		{ErrNotFoundKeyInBucket("bucket", []byte("key")), ErrKeyNotFound},
+		{&ModeError{Reason: "reason", Mode: HintBPTSparseIdxMode}, ErrUnsupportedMode},
+		{&EntryError{FileID: 1, Offset: 2, Cause: ErrCrc}, ErrCorrupted},
+		{&RecoveryError{FileID: 1, Offset: 2, Cause: errors.New("cause")}, ErrCorrupted},
+	}
+
+	for _, c := range cases {
+		if !errors.Is(c.err, c.target) {
+			t.Errorf("err errors.Is(%q, %q) got false want true", c.err, c.target)
+		}
+	}
+
+	var bucketErr *BucketError
+	if !errors.As(ErrBucketAndKey("bucket", []byte("key")), &bucketErr) || bucketErr.Bucket != "bucket" {
+		t.Error("err errors.As BucketError")
+	}
+}
+
+func TestDB_Merge_Unsupported(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesterrors", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Merge(); !errors.Is(err, ErrUnsupportedMode) {
+		t.Errorf("err Merge got %v want %v", err, ErrUnsupportedMode)
+	}
+}
+
+func TestEntryError(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestentryerror", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var secondOff int64
+	for i, key := range []string{"key1", "key2"} {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(key), []byte("value"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			secondOff = db.ActiveFile.writeOff
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip the last byte of the value of the second entry
+	fd, err := os.OpenFile(opt.Dir+"/0"+DataSuffix, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	end := 2 * secondOff
+	if _, err := fd.ReadAt(b, end-1); err != nil {
+		t.Fatal(err)
+	}
+	b[0]++
+	if _, err := fd.WriteAt(b, end-1); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	_, err = Open(opt)
+
+	var entryErr *EntryError
+	if !errors.As(err, &entryErr) {
+		t.Fatalf("err Open with corrupted entry. got %v want an EntryError", err)
+	}
+	if entryErr.FileID != 0 || entryErr.Offset != secondOff || !errors.Is(err, ErrCrc) {
+		t.Errorf("err EntryError. got %+v want file 0 offset %d cause %v", entryErr, secondOff, ErrCrc)
+	}
+}
+
+func TestRecoveryStrict(t *testing.T) {
+	InitOpt("/tmp/nutsdbteststrictrecovery", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a sorted set entry whose score fails to decode.
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.ZAdd("zset", []byte("key1"), 1, []byte("val1")); err != nil {
+			return err
+		}
+		return tx.put("zset", encodeCompositeKey([]byte("key2"), []byte("bad")), []byte("val2"),
+			Persistent, DataZAddFlag, tx.now(), DataStructureSortedSet)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatalf("err Open without RecoveryStrict: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	strictOpt := opt
+	strictOpt.RecoveryMode = RecoveryStrict
+	_, err = Open(strictOpt)
+
+	var recoveryErr *RecoveryError
+	if !errors.As(err, &recoveryErr) {
+		t.Fatalf("err Open with RecoveryStrict. got %v want a RecoveryError", err)
+	}
+	if recoveryErr.Bucket != "zset" || recoveryErr.Flag != DataZAddFlag || !errors.Is(err, ErrCorrupted) {
+		t.Errorf("err RecoveryError. got %+v", recoveryErr)
+	}
+}
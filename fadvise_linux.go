@@ -0,0 +1,43 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && (amd64 || arm64)
+
+package nutsdb
+
+import "syscall"
+
+// The advices of posix_fadvise(2), missing from the syscall package.
+const (
+	fadvRandom     = 1
+	fadvSequential = 2
+	fadvDontNeed   = 4
+)
+
+// fadvise gives the hint a to the OS for the whole of the file fd.
+func fadvise(fd uintptr, a fileAdvice) error {
+	advice := fadvSequential
+	switch a {
+	case adviceRandom:
+		advice = fadvRandom
+	case adviceDontNeed:
+		advice = fadvDontNeed
+	}
+
+	if _, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, fd, 0, 0, uintptr(advice), 0, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
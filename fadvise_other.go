@@ -0,0 +1,23 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux || !(amd64 || arm64)
+
+package nutsdb
+
+// fadvise gives the hint a to the OS for the file fd, only supported on Linux amd64 and
+// arm64 where the syscall does not split its 64-bit arguments.
+func fadvise(fd uintptr, a fileAdvice) error {
+	return nil
+}
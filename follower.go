@@ -0,0 +1,274 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultFollowInterval is the default time between two polls of the data files by a follower.
+const DefaultFollowInterval = 100 * time.Millisecond
+
+// ErrNotFollower is returned when CatchUp is called on a db not opened with OpenFollower.
+var ErrNotFollower = errors.New("db is not a follower")
+
+// follower tails the data files written by the process writing the database.
+type follower struct {
+	mu      sync.Mutex // serializes the polls
+	off     int64      // the offset of the next entry of the active file
+	pending []*Record  // the records of the transactions not committed yet
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// OpenFollower opens the database at dir read-only, next to the process writing it, and
+// tails the entries it appends, polling the data files every Options.FollowInterval, so
+// that its indexes keep up with the commits of the writer, e.g. for a reporting process
+// serving reads. A transaction sees the commits tailed before it began. The options are
+// used as in Open, with ReadOnly forced to true and LazyIndexLoad to false, and must use
+// the SegmentSize and EntryIdxMode of the writer. HintBPTSparseIdxMode is not supported.
+// In HintKeyAndRAMIdxMode, a key whose entry is rewritten by a merge of the writer may not
+// be read until the rewritten entry is tailed.
+func OpenFollower(dir string, opt Options) (*DB, error) {
+	if opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	opt.Dir = dir
+	opt.ReadOnly = true
+	opt.LazyIndexLoad = false
+	opt.AutoBackup = AutoBackupOptions{}
+
+	db, err := Open(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	db.startFollowing()
+
+	return db, nil
+}
+
+// startFollowing starts the background goroutine polling the data files.
+func (db *DB) startFollowing() {
+	f := &follower{
+		off:  db.lastCommittedOff(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	db.follower = f
+
+	interval := db.opt.FollowInterval
+	if interval <= 0 {
+		interval = DefaultFollowInterval
+	}
+
+	go func() {
+		defer close(f.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := db.CatchUp(); err != nil {
+					db.health.recordError(err)
+				}
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopFollowing stops the polling of the data files.
+func (db *DB) stopFollowing() {
+	f := db.follower
+	if f == nil {
+		return
+	}
+
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+
+	<-f.done
+}
+
+// lastCommittedOff returns the offset after the last entry of a committed transaction in
+// the active file. The entries after it were not indexed when opening the database, their
+// transactions committing later, so the follower starts reading from it. The entries of
+// such a transaction written in the previous data files are not indexed.
+func (db *DB) lastCommittedOff() (off int64) {
+	r := db.newDataFileReader(db.ActiveFile)
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			// an entry being written by the writer is read again by the next poll.
+			return off
+		}
+
+		if e.Meta.status == Committed {
+			off = r.Offset()
+		}
+	}
+}
+
+// CatchUp indexes the entries committed by the writer since the last poll, without waiting
+// for the next one, e.g. to read a write known committed. It returns ErrNotFollower unless
+// the database was opened with OpenFollower.
+func (db *DB) CatchUp() error {
+	f := db.follower
+	if f == nil {
+		return ErrNotFollower
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		// a data file is sealed once a later one exists, so it is read to its end first.
+		maxFileID, dataFileIds := db.getMaxFileIDAndFileIDs()
+
+		if err := db.tailActiveFile(f); err != nil {
+			return err
+		}
+
+		if maxFileID <= db.MaxFileID {
+			return nil
+		}
+
+		if err := db.followNextFile(f, dataFileIds); err != nil {
+			return err
+		}
+	}
+}
+
+// tailActiveFile indexes the entries appended to the active file since f.off whose
+// transactions are committed, keeping the others pending.
+func (db *DB) tailActiveFile(f *follower) error {
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+	if closed {
+		return ErrDBClosed
+	}
+
+	var records []*Record
+	committed := make(map[uint64]struct{})
+
+	off := f.off
+	dr := db.newDataFileReaderAt(db.ActiveFile, off)
+	for {
+		entry, err := dr.Next()
+		if err != nil || entry == nil {
+			// an entry being written by the writer is read again by the next poll.
+			break
+		}
+
+		var e *Entry
+		if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
+			e = entry
+		}
+
+		records = append(records, &Record{
+			H: &Hint{
+				key:     entry.Key,
+				fileID:  db.ActiveFile.fileID,
+				meta:    entry.Meta,
+				dataPos: uint64(dr.EntryOffset()),
+			},
+			E: e,
+		})
+
+		if entry.Meta.status == Committed {
+			committed[entry.Meta.txID] = struct{}{}
+		}
+
+		off = dr.Offset()
+	}
+
+	if off == f.off {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	for txID := range committed {
+		db.addCommittedTxID(txID)
+	}
+
+	f.pending = append(f.pending, records...)
+	pending := f.pending[:0]
+	for _, r := range f.pending {
+		if !db.isCommittedTx(r.H.meta.txID) {
+			pending = append(pending, r)
+			continue
+		}
+
+		if err := db.buildCommittedRecordIdx(r); err != nil {
+			return err
+		}
+	}
+	f.pending = pending
+
+	db.ActiveFile.writeOff = off
+	db.ActiveFile.ActualSize = off
+	f.off = off
+
+	return nil
+}
+
+// followNextFile makes the data file following the active one in dataFileIds active.
+func (db *DB) followNextFile(f *follower, dataFileIds []int) error {
+	next := db.MaxFileID
+	for _, id := range dataFileIds {
+		if int64(id) > db.MaxFileID {
+			next = int64(id)
+			break
+		}
+	}
+
+	df, err := db.openDataFile(next, db.opt.RWMode)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		df.rwManager.Close()
+		return ErrDBClosed
+	}
+
+	db.ActiveFile.Close()
+	db.ActiveFile = df
+	db.MaxFileID = next
+	db.sealedSize += db.opt.SegmentSize
+	f.off = 0
+
+	return nil
+}
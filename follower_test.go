@@ -0,0 +1,156 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOpenFollower(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestfollower", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	put := func(key, value string) {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(key), []byte(value), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(f *DB, key string) string {
+		var value string
+		err := f.View(func(tx *Tx) error {
+			e, err := tx.Get("bucket", []byte(key))
+			if err != nil {
+				return err
+			}
+			value = string(e.Value)
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	put("before", "1")
+
+	followerOpt := opt
+	followerOpt.FollowInterval = time.Hour
+	f, err := OpenFollower(opt.Dir, followerOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if got := get(f, "before"); got != "1" {
+		t.Errorf("expected the entries written before opening, got %q", got)
+	}
+
+	put("after", "2")
+	if err := db.Update(func(tx *Tx) error {
+		return tx.RPush("list", []byte("l"), []byte("a"), []byte("b"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(f, "after"); got != "" {
+		t.Errorf("expected the entries not tailed yet, got %q", got)
+	}
+
+	if err := f.CatchUp(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(f, "after"); got != "2" {
+		t.Errorf("expected the entries tailed, got %q", got)
+	}
+	if err := f.View(func(tx *Tx) error {
+		size, err := tx.LSize("list", []byte("l"))
+		if size != 2 {
+			t.Errorf("expected the list tailed, got size %d", size)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer rotates the data files.
+	maxFileID := db.MaxFileID
+	for i := 0; db.MaxFileID < maxFileID+2; i++ {
+		put(fmt.Sprintf("key%03d", i), "value")
+	}
+	put("last", "3")
+
+	if err := f.CatchUp(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(f, "last"); got != "3" {
+		t.Errorf("expected the entries of the new data files tailed, got %q", got)
+	}
+	if got := get(f, "key000"); got != "value" {
+		t.Errorf("expected the entries of the sealed data files tailed, got %q", got)
+	}
+
+	if err := f.Update(func(tx *Tx) error { return nil }); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if err := db.CatchUp(); err != ErrNotFollower {
+		t.Errorf("expected ErrNotFollower, got %v", err)
+	}
+}
+
+func TestOpenFollower_Polling(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestfollower", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	followerOpt := opt
+	followerOpt.FollowInterval = time.Millisecond
+	f, err := OpenFollower(opt.Dir, followerOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := f.View(func(tx *Tx) error {
+			_, err := tx.Get("bucket", []byte("key"))
+			return err
+		})
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the entry tailed, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,646 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// RecordFormat represents the format the entries are written to the data files in.
+type RecordFormat int
+
+const (
+	// RecordFormatV1 represents the entries written one by one, every one with its
+	// own header holding the txID and the status of its transaction, see Entry.Encode.
+	RecordFormatV1 RecordFormat = iota
+
+	// RecordFormatV2 represents the entries of a transaction written in one frame:
+	// one frame header holding the txID, the compact entries without it and a commit
+	// marker, cutting the bytes written for small entries and the work of recovering them.
+	RecordFormatV2
+
+	// RecordFormatV3 represents the frames of RecordFormatV2 whose entry headers are
+	// encoded in varints, see appendVarintFrameEntry, cutting the bytes of small entries
+	// further.
+	RecordFormatV3
+)
+
+const (
+	// dataFileHeaderSize is the size of the header of a data file written in frames.
+	dataFileHeaderSize = 8
+
+	// frameHeaderSize is the size of the header of a frame.
+	frameHeaderSize = 22
+
+	// frameMarkerSize is the size of the commit marker of a frame.
+	frameMarkerSize = 8
+
+	// frameEntryHeaderSize is the size of the header of an entry in a frame.
+	frameEntryHeaderSize = 38
+
+	// frameCommitted flags the frame followed by its commit marker.
+	frameCommitted uint16 = 1
+
+	// frameBucketIDs flags the frame whose entries store the ID of their bucket in the
+	// dictionary of the buckets instead of its name, see Options.BucketDictionary.
+	frameBucketIDs uint16 = 2
+
+	// frameCommitMagic is the first half of a commit marker.
+	frameCommitMagic uint32 = 0x74786f6b
+)
+
+// dataFileMagicV2 and dataFileMagicV3 are the headers of the data files of RecordFormatV2
+// and RecordFormatV3, flagging the format of their entries. The files of RecordFormatV1
+// start with an entry, whose crc and timestamp do not match them.
+var (
+	dataFileMagicV2 = []byte("nutsdbv2")
+	dataFileMagicV3 = []byte("nutsdbv3")
+)
+
+// framed returns if the entries of the format f are written in frames.
+func (f RecordFormat) framed() bool {
+	return f == RecordFormatV2 || f == RecordFormatV3
+}
+
+// magic returns the header of the data files of the format f written in frames.
+func (f RecordFormat) magic() []byte {
+	if f == RecordFormatV3 {
+		return dataFileMagicV3
+	}
+
+	return dataFileMagicV2
+}
+
+// entrySize returns the size of e written in the format f, at most for RecordFormatV3
+// and the bucket IDs, the frame header, the commit marker and the file header excluded.
+func (f RecordFormat) entrySize(e *Entry) int64 {
+	bucketSize := int64(e.Meta.bucketSize)
+	if bucketSize < bucketIDSize {
+		bucketSize = bucketIDSize
+	}
+
+	switch f {
+	case RecordFormatV2:
+		return frameEntryHeaderSize + bucketSize + int64(e.Meta.keySize) + int64(e.Meta.valueSize)
+	case RecordFormatV3:
+		return int64(varintFrameEntryHeaderSize(e, math.MaxUint32)) + bucketSize + int64(e.Meta.keySize) + int64(e.Meta.valueSize)
+	}
+
+	return e.Size()
+}
+
+// overhead returns the size written beside the entries of a transaction written in
+// one frame at the start of a data file of the format f.
+func (f RecordFormat) overhead() int64 {
+	if f.framed() {
+		return dataFileHeaderSize + frameHeaderSize + frameMarkerSize
+	}
+
+	return 0
+}
+
+// appendEntry appends e encoded in a frame of the format f to buf, storing bucket as its
+// bucket, see appendFrameEntry.
+func (f RecordFormat) appendEntry(buf []byte, e *Entry, bucket []byte, frameOff uint32) []byte {
+	if f == RecordFormatV3 {
+		return appendVarintFrameEntry(buf, e, bucket, frameOff)
+	}
+
+	return appendFrameEntry(buf, e, bucket, frameOff)
+}
+
+// decodeEntryHeader sets meta to the MetaData of the entry header of a frame of the format
+// f at given buf slice, returning the distance of the entry from the frame header and the
+// size of the header.
+func (f RecordFormat) decodeEntryHeader(meta *MetaData, buf []byte) (frameOff uint32, n int, err error) {
+	if f == RecordFormatV3 {
+		return decodeVarintFrameMetaData(meta, buf)
+	}
+
+	if len(buf) < frameEntryHeaderSize {
+		return 0, 0, ErrCrc
+	}
+
+	return decodeFrameMetaData(meta, buf), frameEntryHeaderSize, nil
+}
+
+// maxEntryHeaderSize returns the largest size of an entry header of a frame of the format f.
+func (f RecordFormat) maxEntryHeaderSize() int {
+	if f == RecordFormatV3 {
+		return maxVarintFrameEntryHeaderSize
+	}
+
+	return frameEntryHeaderSize
+}
+
+// recordFormat returns the RecordFormat of df, read from its first bytes the first
+// time, and false if nothing was written to it yet.
+func (df *DataFile) recordFormat() (RecordFormat, bool, error) {
+	if f := df.format.Load(); f != 0 {
+		return RecordFormat(f - 1), true, nil
+	}
+
+	buf := make([]byte, dataFileHeaderSize)
+	if _, err := df.rwManager.ReadAt(buf, 0); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return RecordFormatV1, false, nil
+		}
+		return RecordFormatV1, false, err
+	}
+
+	var f RecordFormat
+	switch {
+	case bytes.Equal(buf, dataFileMagicV2):
+		f = RecordFormatV2
+	case bytes.Equal(buf, dataFileMagicV3):
+		f = RecordFormatV3
+	case bytes.Equal(buf, make([]byte, dataFileHeaderSize)):
+		return RecordFormatV1, false, nil
+	default:
+		f = RecordFormatV1
+	}
+	df.setRecordFormat(f)
+
+	return f, true, nil
+}
+
+// setRecordFormat records f as the RecordFormat of df once written to it.
+func (df *DataFile) setRecordFormat(f RecordFormat) {
+	df.format.Store(uint32(f) + 1)
+}
+
+// resetRecordFormat forgets the RecordFormat of df, e.g. once its writes are zeroed.
+func (df *DataFile) resetRecordFormat() {
+	df.format.Store(0)
+}
+
+// appendFrameEntry appends e encoded in a frame to buf, frameOff being the distance
+// from the frame header, storing bucket as its bucket: the one of e, or its ID in the
+// frames flagged frameBucketIDs.
+//
+//	the entry stored format in a frame:
+//	|------------------------------------------------------------------------------------------------------------|
+//	|  crc  | timestamp | ksz | valueSize | flag  | TTL  |bucketSize| ds   | siteID | frameOff | bucket | key | value |
+//	|------------------------------------------------------------------------------------------------------------|
+//	| uint32| uint64  |uint32 |  uint32 | uint16  | uint32| uint32 | uint16 | uint16 |  uint32  | []byte |[]byte|[]byte|
+//	|------------------------------------------------------------------------------------------------------------|
+//
+// The txID and the status are the ones of the frame, read from its header by the point
+// reads through frameOff.
+func appendFrameEntry(buf []byte, e *Entry, bucket []byte, frameOff uint32) []byte {
+	start := len(buf)
+	size := frameEntryHeaderSize + len(bucket) + int(e.Meta.keySize+e.Meta.valueSize)
+	if cap(buf)-start < size {
+		grown := make([]byte, start, 2*cap(buf)+size)
+		copy(grown, buf)
+		buf = grown
+	}
+	buf = buf[:start+size]
+	b := buf[start:]
+
+	binary.LittleEndian.PutUint64(b[4:12], packTimestamp(e.Meta.timestamp, e.Meta.seq))
+	binary.LittleEndian.PutUint32(b[12:16], e.Meta.keySize)
+	binary.LittleEndian.PutUint32(b[16:20], e.Meta.valueSize)
+	binary.LittleEndian.PutUint16(b[20:22], e.Meta.Flag)
+	binary.LittleEndian.PutUint32(b[22:26], e.Meta.TTL)
+	binary.LittleEndian.PutUint32(b[26:30], uint32(len(bucket)))
+	binary.LittleEndian.PutUint16(b[30:32], e.Meta.ds)
+	binary.LittleEndian.PutUint16(b[32:34], e.Meta.siteID)
+	binary.LittleEndian.PutUint32(b[34:38], frameOff)
+
+	n := copy(b[frameEntryHeaderSize:], bucket)
+	n += copy(b[frameEntryHeaderSize+n:], e.Key)
+	copy(b[frameEntryHeaderSize+n:], e.Value)
+
+	binary.LittleEndian.PutUint32(b[0:4], crc32.ChecksumIEEE(b[4:]))
+
+	return buf
+}
+
+// decodeFrameMetaData sets meta to the MetaData of the entry header of a frame at given buf
+// slice and returns the distance of the entry from the frame header.
+func decodeFrameMetaData(meta *MetaData, buf []byte) uint32 {
+	timestamp, seq := unpackTimestamp(binary.LittleEndian.Uint64(buf[4:12]))
+	*meta = MetaData{
+		timestamp:  timestamp,
+		keySize:    binary.LittleEndian.Uint32(buf[12:16]),
+		valueSize:  binary.LittleEndian.Uint32(buf[16:20]),
+		Flag:       binary.LittleEndian.Uint16(buf[20:22]),
+		TTL:        binary.LittleEndian.Uint32(buf[22:26]),
+		bucketSize: binary.LittleEndian.Uint32(buf[26:30]),
+		ds:         binary.LittleEndian.Uint16(buf[30:32]),
+		siteID:     binary.LittleEndian.Uint16(buf[32:34]),
+		seq:        seq,
+	}
+
+	return binary.LittleEndian.Uint32(buf[34:38])
+}
+
+// frameHeader represents the header of a frame.
+//
+//	the frame stored format:
+//	|------------------------------------------------------------------------------------|
+//	|  crc  | txId  | count | size  | flags |  entries  | commit magic | crc (of header) |
+//	|------------------------------------------------------------------------------------|
+//	| uint32| uint64| uint32| uint32| uint16| size bytes|    uint32    |     uint32      |
+//	|------------------------------------------------------------------------------------|
+//
+// The crc of the header covers the fields after it, the entries having their own. The
+// commit marker follows the entries of the frame flagged frameCommitted, the last one of
+// a transaction, the others being written before the active file is rotated. A torn
+// frame misses the marker, written last.
+type frameHeader struct {
+	crc   uint32
+	txID  uint64
+	count uint32
+	size  uint32
+	flags uint16
+}
+
+// encode encodes h to buf, computing its crc.
+func (h *frameHeader) encode(buf []byte) {
+	binary.LittleEndian.PutUint64(buf[4:12], h.txID)
+	binary.LittleEndian.PutUint32(buf[12:16], h.count)
+	binary.LittleEndian.PutUint32(buf[16:20], h.size)
+	binary.LittleEndian.PutUint16(buf[20:22], h.flags)
+	h.crc = crc32.ChecksumIEEE(buf[4:frameHeaderSize])
+	binary.LittleEndian.PutUint32(buf[0:4], h.crc)
+}
+
+// decodeFrameHeader returns the frameHeader at given buf slice, nil at the zero tail of a file.
+func decodeFrameHeader(buf []byte) (*frameHeader, error) {
+	h := &frameHeader{
+		crc:   binary.LittleEndian.Uint32(buf[0:4]),
+		txID:  binary.LittleEndian.Uint64(buf[4:12]),
+		count: binary.LittleEndian.Uint32(buf[12:16]),
+		size:  binary.LittleEndian.Uint32(buf[16:20]),
+		flags: binary.LittleEndian.Uint16(buf[20:22]),
+	}
+
+	if h.crc == 0 && h.txID == 0 && h.size == 0 {
+		return nil, nil
+	}
+
+	if crc32.ChecksumIEEE(buf[4:frameHeaderSize]) != h.crc {
+		return nil, ErrCrc
+	}
+
+	return h, nil
+}
+
+// checkMarker checks the commit marker of the frame of h at given buf slice.
+func (h *frameHeader) checkMarker(buf []byte) error {
+	if binary.LittleEndian.Uint32(buf[0:4]) != frameCommitMagic || binary.LittleEndian.Uint32(buf[4:8]) != h.crc {
+		return ErrCrc
+	}
+
+	return nil
+}
+
+// appendMarker appends the commit marker of the frame of h to buf.
+func (h *frameHeader) appendMarker(buf []byte) []byte {
+	var m [frameMarkerSize]byte
+	binary.LittleEndian.PutUint32(m[0:4], frameCommitMagic)
+	binary.LittleEndian.PutUint32(m[4:8], h.crc)
+
+	return append(buf, m[:]...)
+}
+
+// readFrameEntryAt reads the entry of a frame of the format f at given off, and the txID
+// from the frame header.
+func (df *DataFile) readFrameEntryAt(off int, f RecordFormat) (e *Entry, err error) {
+	// the header of RecordFormatV3 is read at most, so it may run past the end of the file.
+	buf := make([]byte, f.maxEntryHeaderSize())
+	n, err := df.rwManager.ReadAt(buf, int64(off))
+	if err != nil && (err != io.EOF || n == 0) {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	meta := &MetaData{}
+	frameOff, n, err := f.decodeEntryHeader(meta, buf)
+	if err != nil {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	e = &Entry{
+		crc:  binary.LittleEndian.Uint32(buf[0:4]),
+		Meta: meta,
+	}
+
+	if e.IsZero() {
+		return nil, nil
+	}
+
+	payload := make([]byte, int(meta.bucketSize)+int(meta.keySize)+int(meta.valueSize))
+	if _, err = df.rwManager.ReadAt(payload, int64(off+n)); err != nil {
+		return nil, err
+	}
+
+	if checksum(buf, payload) != e.crc {
+		return nil, ErrCrc
+	}
+
+	e.Meta.bucket = payload[:meta.bucketSize]
+	e.Key = payload[meta.bucketSize : meta.bucketSize+meta.keySize]
+	e.Value = payload[meta.bucketSize+meta.keySize:]
+
+	header := make([]byte, frameHeaderSize)
+	if int64(frameOff) > int64(off) {
+		return nil, ErrCrc
+	}
+	if _, err = df.rwManager.ReadAt(header, int64(off)-int64(frameOff)); err != nil {
+		return nil, err
+	}
+	h, err := decodeFrameHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, ErrCrc
+	}
+	e.Meta.txID = h.txID
+	if h.flags&frameBucketIDs != 0 {
+		if err = resolveBucketID(e.Meta, df.buckets); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// resolveBucketID replaces the bucket ID stored by the entry of meta, in a frame flagged
+// frameBucketIDs, by its bucket.
+func resolveBucketID(meta *MetaData, buckets *bucketDict) error {
+	if len(meta.bucket) != bucketIDSize {
+		return ErrCrc
+	}
+
+	bucket, err := buckets.name(binary.LittleEndian.Uint32(meta.bucket))
+	if err != nil {
+		return err
+	}
+	meta.bucket, meta.bucketSize = bucket, uint32(len(bucket))
+
+	return nil
+}
+
+// decodeFrame decodes the entries of the frame of h at given buf slice, the entries
+// followed by the commit marker if flagged, at given off of the file, appending them
+// and their offsets to entries and offs. The bucket IDs are resolved by buckets.
+func decodeFrame(f RecordFormat, h *frameHeader, buf []byte, off int64, buckets *bucketDict, arena *recordArena, entries []*Entry, offs []int64) ([]*Entry, []int64, error) {
+	if h.flags&frameCommitted != 0 {
+		if err := h.checkMarker(buf[h.size:]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	body := buf[:h.size]
+	for i, pos := uint32(0), int64(0); i < h.count; i++ {
+		meta := arena.newMetaData()
+		_, n, err := f.decodeEntryHeader(meta, body)
+		if err != nil {
+			return nil, nil, err
+		}
+		size := n + int(meta.bucketSize) + int(meta.keySize) + int(meta.valueSize)
+		if size < n || len(body) < size {
+			return nil, nil, ErrCrc
+		}
+
+		e := arena.newEntry()
+		e.crc = binary.LittleEndian.Uint32(body[0:4])
+		e.Meta = meta
+		if crc32.ChecksumIEEE(body[4:size]) != e.crc {
+			return nil, nil, ErrCrc
+		}
+
+		payload := body[n:size]
+		meta.bucket = payload[:meta.bucketSize]
+		e.Key = payload[meta.bucketSize : meta.bucketSize+meta.keySize]
+		e.Value = payload[meta.bucketSize+meta.keySize:]
+		meta.txID = h.txID
+		if h.flags&frameBucketIDs != 0 {
+			if err := resolveBucketID(meta, buckets); err != nil {
+				return nil, nil, err
+			}
+		}
+		if i == h.count-1 && h.flags&frameCommitted != 0 {
+			meta.status = Committed
+		}
+
+		entries = append(entries, e)
+		offs = append(offs, off+pos)
+		body = body[size:]
+		pos += int64(size)
+	}
+
+	return entries, offs, nil
+}
+
+// recordWriter writes the entries of a commit to the active file in its RecordFormat,
+// the one of the options once it is rotated. The entries of the formats written in frames
+// are written by flush, in one frame per data file.
+type recordWriter struct {
+	tx     *Tx
+	format RecordFormat
+	buf    []byte // the last entry of RecordFormatV1, or the frame being built
+	start  int64  // the offset of the frame header in the active file
+	header frameHeader
+}
+
+// newRecordWriter returns the recordWriter of the commit of tx.
+func (tx *Tx) newRecordWriter() (*recordWriter, error) {
+	w := &recordWriter{tx: tx}
+
+	return w, w.reset()
+}
+
+// reset sets the format of w to the one of the active file.
+func (w *recordWriter) reset() error {
+	df := w.tx.db.ActiveFile
+	w.format = w.tx.db.opt.RecordFormat
+	if df.writeOff == 0 {
+		return nil
+	}
+
+	f, written, err := df.recordFormat()
+	if err != nil {
+		return err
+	}
+	if written {
+		w.format = f
+	}
+
+	return nil
+}
+
+// end returns the offset of the end of the active file once the entries written are flushed.
+func (w *recordWriter) end() int64 {
+	df := w.tx.db.ActiveFile
+	if !w.format.framed() {
+		return df.ActualSize
+	}
+
+	if w.header.count == 0 {
+		end := df.writeOff + frameHeaderSize + frameMarkerSize
+		if df.writeOff == 0 {
+			end += dataFileHeaderSize
+		}
+		return end
+	}
+
+	return df.writeOff + int64(len(w.buf)) + frameMarkerSize
+}
+
+// write writes e, rotating the active file if it is full, and returns its offset.
+func (w *recordWriter) write(e *Entry) (int64, error) {
+	segmentSize := w.tx.db.opt.SegmentSize
+	if w.format.entrySize(e)+w.format.overhead() > segmentSize {
+		return 0, ErrKeyAndValSize
+	}
+
+	if w.end()+w.format.entrySize(e) > segmentSize {
+		if err := w.flush(false); err != nil {
+			return 0, err
+		}
+		if err := w.tx.rotateActiveFile(); err != nil {
+			return 0, err
+		}
+		if err := w.reset(); err != nil {
+			return 0, err
+		}
+		if w.format.entrySize(e)+w.format.overhead() > segmentSize {
+			return 0, ErrKeyAndValSize
+		}
+	}
+
+	if w.format.framed() {
+		return w.appendFrameEntry(e), nil
+	}
+
+	return w.writeEntry(e)
+}
+
+// writeEntry writes e in RecordFormatV1 and returns its offset.
+func (w *recordWriter) writeEntry(e *Entry) (int64, error) {
+	db := w.tx.db
+	df := db.ActiveFile
+	off := df.writeOff
+
+	w.buf = e.encodeTo(w.buf)
+	if _, err := df.WriteAt(w.buf, off); err != nil {
+		return 0, err
+	}
+	db.wal.add(df.fileID, off, w.buf)
+
+	if db.opt.SyncEnable && db.wal == nil {
+		if err := df.rwManager.Sync(); err != nil {
+			return 0, err
+		}
+		db.health.recordSync()
+	}
+
+	if off == 0 {
+		df.setRecordFormat(RecordFormatV1)
+	}
+
+	size := e.Size()
+	df.ActualSize += size
+	df.writeOff += size
+
+	return off, nil
+}
+
+// appendFrameEntry appends e to the frame being built and returns its offset.
+func (w *recordWriter) appendFrameEntry(e *Entry) int64 {
+	df := w.tx.db.ActiveFile
+	if w.header.count == 0 {
+		w.buf = w.buf[:0]
+		if df.writeOff == 0 {
+			w.buf = append(w.buf, w.format.magic()...)
+		}
+		w.start = df.writeOff + int64(len(w.buf))
+		w.buf = append(w.buf, make([]byte, frameHeaderSize)...)
+		w.header = frameHeader{txID: e.Meta.txID}
+		if w.tx.db.useBucketDict() {
+			w.header.flags = frameBucketIDs
+		}
+	}
+
+	bucket := e.Meta.bucket
+	if w.header.flags&frameBucketIDs != 0 {
+		bucket = make([]byte, bucketIDSize)
+		binary.LittleEndian.PutUint32(bucket, w.tx.db.buckets.id(e.Meta.bucket))
+	}
+
+	off := df.writeOff + int64(len(w.buf))
+	w.buf = w.format.appendEntry(w.buf, e, bucket, uint32(off-w.start))
+	w.header.count++
+
+	return off
+}
+
+// flush writes the frame being built, followed by its commit marker if commit is set.
+func (w *recordWriter) flush(commit bool) error {
+	if !w.format.framed() || w.header.count == 0 {
+		return nil
+	}
+
+	db := w.tx.db
+	df := db.ActiveFile
+	off := df.writeOff
+
+	headerOff := w.start - off
+	w.header.size = uint32(int64(len(w.buf)) - headerOff - frameHeaderSize)
+	if commit {
+		w.header.flags |= frameCommitted
+	}
+	w.header.encode(w.buf[headerOff : headerOff+frameHeaderSize])
+	if commit {
+		w.buf = w.header.appendMarker(w.buf)
+	}
+	w.header = frameHeader{}
+
+	if err := db.persistBucketDict(); err != nil {
+		return err
+	}
+
+	if _, err := df.WriteAt(w.buf, off); err != nil {
+		return err
+	}
+	db.wal.add(df.fileID, off, w.buf)
+
+	if db.opt.SyncEnable && db.wal == nil {
+		if err := df.rwManager.Sync(); err != nil {
+			return err
+		}
+		db.health.recordSync()
+	}
+
+	if off == 0 {
+		df.setRecordFormat(w.format)
+	}
+
+	size := int64(len(w.buf))
+	df.ActualSize += size
+	df.writeOff += size
+
+	return nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func putFrames(t *testing.T, db *DB, n, perTx, version int) {
+	for i := 0; i < n; i += perTx {
+		if err := db.Update(func(tx *Tx) error {
+			for j := i; j < i+perTx && j < n; j++ {
+				if err := tx.Put("bucket", []byte(fmt.Sprintf("key_%d", j)), []byte(fmt.Sprintf("val_%d_%040d", j, version)), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkFrames(t *testing.T, db *DB, n, version int) {
+	if err := db.View(func(tx *Tx) error {
+		for i := 0; i < n; i++ {
+			e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+			if err != nil {
+				return fmt.Errorf("key_%d: %w", i, err)
+			}
+			if want := fmt.Sprintf("val_%d_%040d", i, version); string(e.Value) != want {
+				t.Errorf("key_%d: got %s, want %s", i, e.Value, want)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dataFileFormat(t *testing.T, db *DB, fID int64) RecordFormat {
+	b, err := ioutil.ReadFile(db.getDataPath(fID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(b, dataFileMagicV2) {
+		return RecordFormatV2
+	}
+
+	return RecordFormatV1
+}
+
+func TestDB_RecordFormatV2(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode, HintBPTSparseIdxMode} {
+		t.Run(fmt.Sprintf("EntryIdxMode %d", mode), func(t *testing.T) {
+			InitOpt("/tmp/nutsdbtestframe", true)
+			opt.EntryIdxMode = mode
+			opt.SegmentSize = 8 * 1024
+			opt.RecordFormat = RecordFormatV2
+
+			db, err := Open(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the transactions span several files, their frames being split.
+			for version := 0; version < 3; version++ {
+				putFrames(t, db, 300, 30, version)
+			}
+			if db.ActiveFile.fileID < 3 {
+				t.Fatalf("err %d data files, want several", db.ActiveFile.fileID+1)
+			}
+			checkFrames(t, db, 300, 2)
+
+			for fID := int64(0); fID <= db.ActiveFile.fileID; fID++ {
+				if f := dataFileFormat(t, db, fID); f != RecordFormatV2 {
+					t.Errorf("err file %d: format %d", fID, f)
+				}
+			}
+
+			if mode != HintBPTSparseIdxMode {
+				if err := db.Merge(); err != nil {
+					t.Fatal(err)
+				}
+				checkFrames(t, db, 300, 2)
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = Open(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFrames(t, db, 300, 2)
+
+			putFrames(t, db, 300, 30, 3)
+			checkFrames(t, db, 300, 3)
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDB_RecordFormatSize(t *testing.T) {
+	var sizes [2]int64
+	for _, format := range []RecordFormat{RecordFormatV1, RecordFormatV2} {
+		InitOpt("/tmp/nutsdbtestframe", true)
+		opt.SegmentSize = 1024 * 1024
+		opt.RecordFormat = format
+
+		db, err := Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putFrames(t, db, 100, 100, 0)
+		sizes[format] = db.ActiveFile.writeOff
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sizes[RecordFormatV2] >= sizes[RecordFormatV1] {
+		t.Errorf("err the frame is %d bytes, the entries %d", sizes[RecordFormatV2], sizes[RecordFormatV1])
+	}
+}
+
+func TestDB_RecordFormatMixed(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestframe", true)
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	opt.SegmentSize = 8 * 1024
+
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFrames(t, db, 100, 10, 0)
+	v1 := db.ActiveFile.fileID
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the active file is written in its format until it is rotated.
+	opt.RecordFormat = RecordFormatV2
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFrames(t, db, 1, 1, 1)
+	if f := dataFileFormat(t, db, v1); f != RecordFormatV1 || db.ActiveFile.fileID != v1 {
+		t.Fatalf("err active file %d: format %d, want file %d written in format %d", db.ActiveFile.fileID, f, v1, RecordFormatV1)
+	}
+	putFrames(t, db, 100, 10, 1)
+	if f := dataFileFormat(t, db, db.ActiveFile.fileID); db.ActiveFile.fileID == v1 || f != RecordFormatV2 {
+		t.Fatalf("err active file %d: format %d, want format %d", db.ActiveFile.fileID, f, RecordFormatV2)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opt.RecordFormat = RecordFormatV1
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkFrames(t, db, 100, 1)
+}
+
+func TestDB_RecordFormatV2TornFrame(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestframe", true)
+	opt.RecordFormat = RecordFormatV2
+
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFrames(t, db, 10, 5, 0)
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("torn"), []byte("value"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fID, end := db.ActiveFile.fileID, db.ActiveFile.writeOff
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the commit marker of the last frame is lost.
+	fd, err := os.OpenFile(db.getDataPath(fID), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.WriteAt(make([]byte, frameMarkerSize), end-frameMarkerSize); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFrames(t, db, 10, 0)
+	if err := db.View(func(tx *Tx) error {
+		if _, err := tx.Get("bucket", []byte("torn")); err == nil {
+			t.Error("err found the key of the torn frame")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the torn frame is overwritten by the next commit.
+	putFrames(t, db, 10, 5, 1)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkFrames(t, db, 10, 1)
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// maxVarintFrameEntryHeaderSize is the largest size of an entry header of RecordFormatV3.
+const maxVarintFrameEntryHeaderSize = 4 + binary.MaxVarintLen64 + 5*binary.MaxVarintLen32 + 3*binary.MaxVarintLen16
+
+// uvarintSize returns the size of x encoded in a uvarint.
+func uvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+
+	return n
+}
+
+// varintFrameEntryHeaderSize returns the size of the entry header of e in a frame of
+// RecordFormatV3, at given distance from the frame header.
+func varintFrameEntryHeaderSize(e *Entry, frameOff uint32) int {
+	return 4 + uvarintSize(packTimestamp(e.Meta.timestamp, e.Meta.seq)) + uvarintSize(uint64(e.Meta.keySize)) +
+		uvarintSize(uint64(e.Meta.valueSize)) + uvarintSize(uint64(e.Meta.Flag)) +
+		uvarintSize(uint64(e.Meta.TTL)) + uvarintSize(uint64(e.Meta.bucketSize)) +
+		uvarintSize(uint64(e.Meta.ds)) + uvarintSize(uint64(e.Meta.siteID)) + uvarintSize(uint64(frameOff))
+}
+
+// appendVarintFrameEntry appends e encoded in a frame of RecordFormatV3 to buf, frameOff
+// being the distance from the frame header, storing bucket as its bucket, see appendFrameEntry.
+//
+//	the entry stored format in a frame of RecordFormatV3:
+//	|------------------------------------------------------------------------------------------------------------|
+//	|  crc  | timestamp | ksz | valueSize | flag  | TTL  |bucketSize| ds   | siteID | frameOff | bucket | key | value |
+//	|------------------------------------------------------------------------------------------------------------|
+//	| uint32|  uvarint  |uvarint| uvarint |uvarint|uvarint| uvarint |uvarint|uvarint| uvarint  | []byte |[]byte|[]byte|
+//	|------------------------------------------------------------------------------------------------------------|
+//
+// The header of a small entry takes about 18 bytes instead of 38, the timestamp 5 of them.
+func appendVarintFrameEntry(buf []byte, e *Entry, bucket []byte, frameOff uint32) []byte {
+	start := len(buf)
+	size := varintFrameEntryHeaderSize(e, frameOff) - uvarintSize(uint64(e.Meta.bucketSize)) + uvarintSize(uint64(len(bucket))) +
+		len(bucket) + int(e.Meta.keySize+e.Meta.valueSize)
+	if cap(buf)-start < size {
+		grown := make([]byte, start, 2*cap(buf)+size)
+		copy(grown, buf)
+		buf = grown
+	}
+	buf = buf[:start+size]
+	b := buf[start:]
+
+	n := 4
+	for _, x := range []uint64{
+		packTimestamp(e.Meta.timestamp, e.Meta.seq), uint64(e.Meta.keySize), uint64(e.Meta.valueSize), uint64(e.Meta.Flag),
+		uint64(e.Meta.TTL), uint64(len(bucket)), uint64(e.Meta.ds), uint64(e.Meta.siteID), uint64(frameOff),
+	} {
+		n += binary.PutUvarint(b[n:], x)
+	}
+
+	n += copy(b[n:], bucket)
+	n += copy(b[n:], e.Key)
+	copy(b[n:], e.Value)
+
+	binary.LittleEndian.PutUint32(b[0:4], crc32.ChecksumIEEE(b[4:]))
+
+	return buf
+}
+
+// decodeVarintFrameMetaData sets meta to the MetaData of the entry header of a frame of
+// RecordFormatV3 at given buf slice, returning the distance of the entry from the frame
+// header and the size of the header.
+func decodeVarintFrameMetaData(meta *MetaData, buf []byte) (frameOff uint32, n int, err error) {
+	if len(buf) < 4 {
+		return 0, 0, ErrCrc
+	}
+
+	var fields [9]uint64
+	n = 4
+	for i := range fields {
+		x, m := binary.Uvarint(buf[n:])
+		if m <= 0 {
+			return 0, 0, ErrCrc
+		}
+		fields[i] = x
+		n += m
+	}
+
+	for i, max := range [9]uint64{1<<64 - 1, 1<<32 - 1, 1<<32 - 1, 1<<16 - 1, 1<<32 - 1, 1<<32 - 1, 1<<16 - 1, 1<<16 - 1, 1<<32 - 1} {
+		if fields[i] > max {
+			return 0, 0, ErrCrc
+		}
+	}
+
+	timestamp, seq := unpackTimestamp(fields[0])
+	*meta = MetaData{
+		timestamp:  timestamp,
+		keySize:    uint32(fields[1]),
+		valueSize:  uint32(fields[2]),
+		Flag:       uint16(fields[3]),
+		TTL:        uint32(fields[4]),
+		bucketSize: uint32(fields[5]),
+		ds:         uint16(fields[6]),
+		siteID:     uint16(fields[7]),
+		seq:        seq,
+	}
+
+	return uint32(fields[8]), n, nil
+}
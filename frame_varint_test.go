@@ -0,0 +1,179 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestVarintFrameEntry(t *testing.T) {
+	e := &Entry{
+		Key:   []byte("key"),
+		Value: []byte("value"),
+		Meta: &MetaData{
+			keySize:    3,
+			valueSize:  5,
+			timestamp:  1700000000,
+			TTL:        60,
+			Flag:       DataSetFlag,
+			bucket:     []byte("bucket"),
+			bucketSize: 6,
+			ds:         DataStructureBPTree,
+			siteID:     300,
+		},
+	}
+
+	buf := appendVarintFrameEntry([]byte("prefix"), e, e.Meta.bucket, 1000)
+	b := buf[len("prefix"):]
+	if want := varintFrameEntryHeaderSize(e, 1000) + 14; len(b) != want {
+		t.Fatalf("err size %d, want %d", len(b), want)
+	}
+	if int64(len(b)) > RecordFormatV3.entrySize(e) || RecordFormatV3.entrySize(e) >= RecordFormatV2.entrySize(e) {
+		t.Errorf("err size %d, at most %d, V2 %d", len(b), RecordFormatV3.entrySize(e), RecordFormatV2.entrySize(e))
+	}
+
+	meta := &MetaData{}
+	frameOff, n, err := decodeVarintFrameMetaData(meta, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.bucket = e.Meta.bucket
+	if frameOff != 1000 || n != varintFrameEntryHeaderSize(e, 1000) || !reflect.DeepEqual(meta, e.Meta) {
+		t.Errorf("err decoded %+v at %d, %d bytes, want %+v", meta, frameOff, n, e.Meta)
+	}
+	if !bytes.Equal(b[n:], []byte("bucketkeyvalue")) {
+		t.Errorf("err payload %q", b[n:])
+	}
+
+	// truncated and overflowing headers.
+	for _, b := range [][]byte{b[:3], b[:6], append([]byte{0, 0, 0, 0, 1}, 0xff, 0xff, 0xff, 0xff, 0x7f)} {
+		if _, _, err := decodeVarintFrameMetaData(meta, b); err != ErrCrc {
+			t.Errorf("err decode %x: got %v want ErrCrc", b, err)
+		}
+	}
+}
+
+func TestDB_RecordFormatV3(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode, HintBPTSparseIdxMode} {
+		t.Run(fmt.Sprintf("EntryIdxMode %d", mode), func(t *testing.T) {
+			InitOpt("/tmp/nutsdbtestframe", true)
+			opt.EntryIdxMode = mode
+			opt.SegmentSize = 8 * 1024
+			opt.RecordFormat = RecordFormatV3
+
+			db, err := Open(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for version := 0; version < 3; version++ {
+				putFrames(t, db, 300, 30, version)
+			}
+			checkFrames(t, db, 300, 2)
+			if f, written, err := db.ActiveFile.recordFormat(); err != nil || !written || f != RecordFormatV3 {
+				t.Errorf("err active file format %d, %v, %v", f, written, err)
+			}
+
+			if mode != HintBPTSparseIdxMode {
+				if err := db.Merge(); err != nil {
+					t.Fatal(err)
+				}
+				checkFrames(t, db, 300, 2)
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = Open(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			checkFrames(t, db, 300, 2)
+		})
+	}
+}
+
+func TestDB_RecordFormatV3Size(t *testing.T) {
+	var sizes [3]int64
+	for _, format := range []RecordFormat{RecordFormatV1, RecordFormatV2, RecordFormatV3} {
+		InitOpt("/tmp/nutsdbtestframe", true)
+		opt.SegmentSize = 1024 * 1024
+		opt.RecordFormat = format
+
+		db, err := Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putFrames(t, db, 100, 10, 0)
+		sizes[format] = db.ActiveFile.writeOff
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the bucket, key and value of about 60 bytes take 100 bytes with their header
+	// in RecordFormatV1 and 80 in RecordFormatV3 in transactions of 10 entries.
+	if sizes[RecordFormatV3] >= sizes[RecordFormatV2] || sizes[RecordFormatV3]*10 > sizes[RecordFormatV1]*8 {
+		t.Errorf("err sizes %v", sizes)
+	}
+}
+
+func TestDB_RecordFormatV2ToV3(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestframe", true)
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	opt.SegmentSize = 8 * 1024
+	opt.RecordFormat = RecordFormatV2
+
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFrames(t, db, 100, 10, 0)
+	v2 := db.ActiveFile.fileID
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the files of RecordFormatV2 are still read, and the active one written in it.
+	opt.RecordFormat = RecordFormatV3
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFrames(t, db, 100, 0)
+	putFrames(t, db, 1, 1, 1)
+	if f := dataFileFormat(t, db, v2); f != RecordFormatV2 || db.ActiveFile.fileID != v2 {
+		t.Fatalf("err active file %d: format %d", db.ActiveFile.fileID, f)
+	}
+	putFrames(t, db, 200, 10, 1)
+	if f, _, _ := db.ActiveFile.recordFormat(); db.ActiveFile.fileID == v2 || f != RecordFormatV3 {
+		t.Fatalf("err active file %d: format %d", db.ActiveFile.fileID, f)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkFrames(t, db, 200, 1)
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrNotSupportFS is returned when the operation does not support databases opened with OpenFS.
+var ErrNotSupportFS = errors.New("not support databases opened with OpenFS")
+
+// OpenFS opens the read-only database at opt.Dir in fsys, e.g. a dataset
+// shipped inside an embed.FS, without extracting it. The options are used
+// as in Open, with ReadOnly forced to true and the data files read from fsys.
+// HintBPTSparseIdxMode is not supported.
+func OpenFS(fsys fs.FS, opt Options) (*DB, error) {
+	if opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if opt.Dir == "" {
+		opt.Dir = "."
+	}
+
+	opt.ReadOnly = true
+	opt.AutoBackup = AutoBackupOptions{}
+	opt.RWManagerFactory = func(p string, capacity int64, rwMode RWMode) (RWManager, error) {
+		return newFSRWManager(fsys, p)
+	}
+
+	return open(opt, fsys)
+}
+
+// fsPath returns the path p of the OS layout as a path of an fs.FS.
+func fsPath(p string) string {
+	return strings.TrimPrefix(path.Clean(p), "/")
+}
+
+// readDir returns the files of the dir, from the fs.FS of OpenFS if any.
+func (db *DB) readDir(dir string) ([]os.FileInfo, error) {
+	if db.fsys == nil {
+		return db.storage.ReadDir(dir)
+	}
+
+	entries, err := fs.ReadDir(db.fsys, fsPath(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+
+	return files, nil
+}
+
+// readFile returns the content of the file, from the fs.FS of OpenFS if any.
+func (db *DB) readFile(p string) ([]byte, error) {
+	if db.fsys == nil {
+		return readStorageFile(db.storage, p)
+	}
+
+	return fs.ReadFile(db.fsys, fsPath(p))
+}
+
+// FSRWManager represents a read-only RWManager of a file in an fs.FS.
+type FSRWManager struct {
+	f fs.File
+	r io.ReaderAt
+}
+
+// newFSRWManager returns a newly initialized FSRWManager of the file at given path.
+// Files without ReadAt method are read in memory.
+func newFSRWManager(fsys fs.FS, p string) (*FSRWManager, error) {
+	f, err := fsys.Open(fsPath(p))
+	if err != nil {
+		return nil, err
+	}
+
+	if r, ok := f.(io.ReaderAt); ok {
+		return &FSRWManager{f: f, r: r}, nil
+	}
+
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &FSRWManager{f: f, r: bytes.NewReader(buf)}, nil
+}
+
+// WriteAt returns ErrReadOnly.
+func (fm *FSRWManager) WriteAt(b []byte, off int64) (n int, err error) {
+	return 0, ErrReadOnly
+}
+
+// ReadAt reads len(b) bytes from the file starting at byte offset off.
+func (fm *FSRWManager) ReadAt(b []byte, off int64) (n int, err error) {
+	return fm.r.ReadAt(b, off)
+}
+
+// Sync does nothing, the file is never written.
+func (fm *FSRWManager) Sync() (err error) {
+	return nil
+}
+
+// Close closes the file.
+func (fm *FSRWManager) Close() (err error) {
+	return fm.f.Close()
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"testing"
+	"testing/fstest"
+)
+
+// noReaderAtFS hides the ReadAt method of the files of the fs.FS.
+type noReaderAtFS struct {
+	fs.FS
+}
+
+func (f noReaderAtFS) Open(name string) (fs.File, error) {
+	file, err := f.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := file.(fs.ReadDirFile); ok {
+		return file, nil
+	}
+
+	return struct{ fs.File }{file}, nil
+}
+
+func TestOpenFS(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		testOpenFS(t, mode)
+	}
+}
+
+func testOpenFS(t *testing.T, mode EntryIdxMode) {
+	InitOpt("/tmp/nutsdbtestopenfs", true)
+	opt.EntryIdxMode = mode
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{}
+	files, err := ioutil.ReadDir(opt.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		buf, err := ioutil.ReadFile(opt.Dir + "/" + f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys["dataset/"+f.Name()] = &fstest.MapFile{Data: buf}
+	}
+
+	fsOpt := opt
+	fsOpt.Dir = "dataset"
+	for _, fsys := range []fs.FS{fsys, noReaderAtFS{fsys}} {
+		fsDB, err := OpenFS(fsys, fsOpt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fsDB.View(func(tx *Tx) error {
+			e, err := tx.Get("bucket", []byte("key"))
+			if err != nil {
+				return err
+			}
+			if string(e.Value) != "value" {
+				t.Errorf("err Get. got %s want value", e.Value)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fsDB.Update(func(tx *Tx) error { return nil }); err != ErrReadOnly {
+			t.Errorf("err Update. got %v want %v", err, ErrReadOnly)
+		}
+
+		if err := fsDB.Checkpoint("/tmp/nutsdbtestopenfscheckpoint"); err != ErrNotSupportFS {
+			t.Errorf("err Checkpoint. got %v want %v", err, ErrNotSupportFS)
+		}
+
+		if err := fsDB.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
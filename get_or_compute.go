@@ -0,0 +1,36 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+// GetOrCompute returns the value of the key in the bucket, computing it by compute and
+// storing it with the ttl if the key is not there. The concurrent calls for a missing key
+// call compute once and write its value once, every caller getting the value or the error
+// of compute, which stores nothing. The value stored is not passed to the OnWrite hook of
+// the bucket, see Options.BucketOptions.
+func (db *DB) GetOrCompute(bucket string, key []byte, ttl uint32, compute func() ([]byte, error)) ([]byte, error) {
+	if compute == nil {
+		return nil, ErrFn
+	}
+
+	value, err := db.getValue(bucket, key)
+	if !isMissing(err) {
+		return value, err
+	}
+
+	return db.loadMissing(bucket, key, func(key []byte) ([]byte, uint32, error) {
+		value, err := compute()
+		return value, ttl, err
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDB_GetOrCompute(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestgetorcompute", true)
+
+	var writes int32
+	opt.BucketOptions = func(bucket string) BucketOptions {
+		return BucketOptions{
+			OnWrite: func(key, value []byte, ttl uint32) error {
+				atomic.AddInt32(&writes, 1)
+				return nil
+			},
+		}
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bucket := "bucket"
+	var computes int32
+	release := make(chan struct{})
+	compute := func() ([]byte, error) {
+		atomic.AddInt32(&computes, 1)
+		<-release
+		return []byte("computed"), nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([][]byte, 8)
+	errs := make([]error, len(values))
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = db.GetOrCompute(bucket, []byte("key"), 0, compute)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range values {
+		if errs[i] != nil || string(values[i]) != "computed" {
+			t.Errorf("TestDB_GetOrCompute err: got %q, %v", values[i], errs[i])
+		}
+	}
+	if n := atomic.LoadInt32(&computes); n != 1 {
+		t.Errorf("TestDB_GetOrCompute err: %d computes for concurrent misses, want 1", n)
+	}
+
+	// the stored value is returned without computing it.
+	value, err := db.GetOrCompute(bucket, []byte("key"), 0, func() ([]byte, error) {
+		t.Error("TestDB_GetOrCompute err: stored value computed again")
+		return nil, nil
+	})
+	if err != nil || string(value) != "computed" {
+		t.Errorf("TestDB_GetOrCompute err: got %q, %v", value, err)
+	}
+	if n := atomic.LoadInt32(&writes); n != 0 {
+		t.Errorf("TestDB_GetOrCompute err: computed value passed to OnWrite %d times", n)
+	}
+
+	errCompute := errors.New("compute failed")
+	if _, err := db.GetOrCompute(bucket, []byte("failed"), 0, func() ([]byte, error) {
+		return nil, errCompute
+	}); err != errCompute {
+		t.Errorf("expected errCompute, got %v", err)
+	}
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("failed"))
+		return err
+	}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("TestDB_GetOrCompute err: value of a failed compute stored, %v", err)
+	}
+
+	// a compute which panics does not block the next ones.
+	func() {
+		defer func() { _ = recover() }()
+		_, _ = db.GetOrCompute(bucket, []byte("panic"), 0, func() ([]byte, error) {
+			panic("compute")
+		})
+	}()
+	if value, err := db.GetOrCompute(bucket, []byte("panic"), 0, func() ([]byte, error) {
+		return []byte("recovered"), nil
+	}); err != nil || string(value) != "recovered" {
+		t.Errorf("TestDB_GetOrCompute err: got %q, %v", value, err)
+	}
+
+	if _, err := db.GetOrCompute(bucket, []byte("key"), 0, nil); err != ErrFn {
+		t.Errorf("expected ErrFn, got %v", err)
+	}
+}
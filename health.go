@@ -0,0 +1,189 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sync"
+	"time"
+)
+
+// Health represents a snapshot of the state of a DB, e.g. for a readiness probe.
+type Health struct {
+	// Closed represents if the DB was closed.
+	Closed bool
+
+	// ReadOnly represents if the DB was opened read-only, or is read-only as it ran out of space.
+	ReadOnly bool
+
+	// NoSpace represents if the DB ran out of space, and writable transactions return ErrNoSpace.
+	NoSpace bool
+
+	// Recovering represents if the indexes are being rebuilt from the data files.
+	Recovering bool
+
+	// Merging represents if a merge is running.
+	Merging bool
+
+	// LastSync represents the time of the last successful fsync of the active file,
+	// zero if none happened yet.
+	LastSync time.Time
+
+	// LastError represents the last error of a commit, automatic backup or change data
+	// capture, or the last ErrTxLeaked, nil if none.
+	LastError error
+
+	// LastErrorTime represents the time of LastError.
+	LastErrorTime time.Time
+
+	// IndexDivergences represents the number of index lookups found diverging from
+	// the data files by Options.ParanoidChecks.
+	IndexDivergences uint64
+
+	// WriteSlowdowns represents the number of writable transactions delayed by Options.WriteStall.
+	WriteSlowdowns uint64
+
+	// WriteStops represents the number of writable transactions stopped by Options.WriteStall,
+	// whether a merge let them go on or they returned a *WriteStallError.
+	WriteStops uint64
+
+	// WriteStallTime represents the total time the writable transactions were stalled.
+	WriteStallTime time.Duration
+}
+
+// healthState records the state reported by Health that is not kept elsewhere.
+type healthState struct {
+	mu            sync.Mutex
+	recovering    bool
+	noSpace       bool
+	lastSync      time.Time
+	lastError     error
+	lastErrorTime time.Time
+	divergences   uint64
+	slowdowns     uint64
+	stops         uint64
+	stallTime     time.Duration
+}
+
+func (h *healthState) setRecovering(recovering bool) {
+	h.mu.Lock()
+	h.recovering = recovering
+	h.mu.Unlock()
+}
+
+func (h *healthState) setNoSpace(noSpace bool) {
+	h.mu.Lock()
+	h.noSpace = noSpace
+	h.mu.Unlock()
+}
+
+func (h *healthState) isNoSpace() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.noSpace
+}
+
+func (h *healthState) recordSync() {
+	h.mu.Lock()
+	h.lastSync = time.Now()
+	h.mu.Unlock()
+}
+
+// recordError records err, if not nil, as the last error.
+func (h *healthState) recordError(err error) {
+	if err == nil {
+		return
+	}
+
+	h.mu.Lock()
+	h.lastError = err
+	h.lastErrorTime = time.Now()
+	h.mu.Unlock()
+}
+
+// recordDivergence records err, the divergence of the index from a data file.
+func (h *healthState) recordDivergence(err error) {
+	h.mu.Lock()
+	h.divergences++
+	h.lastError = err
+	h.lastErrorTime = time.Now()
+	h.mu.Unlock()
+}
+
+func (h *healthState) recordWriteSlowdown() {
+	h.mu.Lock()
+	h.slowdowns++
+	h.mu.Unlock()
+}
+
+func (h *healthState) recordWriteStop() {
+	h.mu.Lock()
+	h.stops++
+	h.mu.Unlock()
+}
+
+// recordWriteStall records d, the time a writable transaction was stalled.
+func (h *healthState) recordWriteStall(d time.Duration) {
+	h.mu.Lock()
+	h.stallTime += d
+	h.mu.Unlock()
+}
+
+// Ping returns nil if the DB is open and its directory is reachable.
+// It returns ErrDBClosed after Close.
+func (db *DB) Ping() error {
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+
+	if closed {
+		return ErrDBClosed
+	}
+
+	if db.fsys != nil {
+		_, err := db.readDir(db.opt.Dir)
+		return err
+	}
+
+	_, err := db.storage.Stat(db.opt.Dir)
+
+	return err
+}
+
+// Health returns the current state of the DB.
+func (db *DB) Health() Health {
+	db.health.mu.Lock()
+	h := Health{
+		ReadOnly:      db.opt.ReadOnly || db.health.noSpace,
+		NoSpace:       db.health.noSpace,
+		Recovering:    db.health.recovering,
+		LastSync:      db.health.lastSync,
+		LastError:     db.health.lastError,
+		LastErrorTime: db.health.lastErrorTime,
+
+		IndexDivergences: db.health.divergences,
+		WriteSlowdowns:   db.health.slowdowns,
+		WriteStops:       db.health.stops,
+		WriteStallTime:   db.health.stallTime,
+	}
+	db.health.mu.Unlock()
+
+	db.mu.RLock()
+	h.Closed = db.closed
+	h.Merging = db.isMerging
+	db.mu.RUnlock()
+
+	return h
+}
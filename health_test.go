@@ -0,0 +1,84 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+)
+
+func TestDB_Health(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesthealth", true)
+	opt.SyncEnable = true
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := db.Health()
+	if h.Closed || h.ReadOnly || h.Recovering || h.Merging || !h.LastSync.IsZero() || h.LastError != nil {
+		t.Fatalf("unexpected health %+v", h)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := db.Health(); h.LastSync.IsZero() {
+		t.Fatal("expected the commit to record the sync time")
+	}
+
+	// make the next commit fail writing the active file.
+	db.ActiveFile.rwManager.Close()
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("val2"), Persistent)
+	}); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+
+	h = db.Health()
+	if h.LastError == nil || h.LastErrorTime.IsZero() {
+		t.Fatalf("expected the failed commit to be recorded, got %+v", h)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != ErrDBClosed {
+		t.Fatalf("expected ErrDBClosed, got %v", err)
+	}
+
+	if h := db.Health(); !h.Closed {
+		t.Fatal("expected the health to report the db closed")
+	}
+
+	opt.ReadOnly = true
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if h := db.Health(); !h.ReadOnly {
+		t.Fatal("expected the health to report the db read-only")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+const (
+	// HintSuffix returns the hint file suffix
+	HintSuffix = ".hint"
+
+	// hintHeaderSize returns the hint record header size
+	hintHeaderSize = DataEntryHeaderSize + 8
+)
+
+// encodeHint returns the slice after the hint record of the entry at given dataPos be encoded.
+// The meta fields keep the layout of the entry header, followed by the entry position:
+//
+//	the hint record stored format:
+//	|--------------------------------------------------------------------------------------------------------|
+//	|  crc  | timestamp | ksz | valueSize | flag  | TTL  |bucketSize| status | ds   | txId | dataPos | bucket | key |
+//	|--------------------------------------------------------------------------------------------------------|
+//	| uint32| uint64  |uint32 |  uint32 | uint16  | uint32| uint32 | uint16 | uint16 |uint64 | uint64 |[]byte|[]byte|
+//	|--------------------------------------------------------------------------------------------------------|
+func encodeHint(e *Entry, dataPos uint64) []byte {
+	bucketSize := e.Meta.bucketSize
+
+	buf := make([]byte, hintHeaderSize+int(bucketSize)+int(e.Meta.keySize))
+	buf = e.setEntryHeaderBuf(buf)
+	binary.LittleEndian.PutUint64(buf[DataEntryHeaderSize:hintHeaderSize], dataPos)
+	copy(buf[hintHeaderSize:hintHeaderSize+bucketSize], e.Meta.bucket)
+	copy(buf[hintHeaderSize+bucketSize:], e.Key)
+
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	return buf
+}
+
+// decodeHints returns the hints of the data file at given fID from the encoded hint records,
+// allocated from arena.
+func decodeHints(buf []byte, fID int64, arena *recordArena) (hints []*Hint, err error) {
+	for off := 0; off < len(buf); {
+		if len(buf)-off < hintHeaderSize {
+			return nil, ErrCorrupted
+		}
+
+		meta := arena.readMetaData(buf[off : off+DataEntryHeaderSize])
+		size := hintHeaderSize + int(meta.bucketSize) + int(meta.keySize)
+		if len(buf)-off < size {
+			return nil, ErrCorrupted
+		}
+
+		record := buf[off : off+size]
+		if crc32.ChecksumIEEE(record[4:]) != binary.LittleEndian.Uint32(record[0:4]) {
+			return nil, ErrCrc
+		}
+
+		meta.bucket = record[hintHeaderSize : hintHeaderSize+meta.bucketSize]
+
+		h := arena.newHint()
+		*h = Hint{
+			key:     record[hintHeaderSize+meta.bucketSize:],
+			fileID:  fID,
+			meta:    meta,
+			dataPos: binary.LittleEndian.Uint64(record[DataEntryHeaderSize:hintHeaderSize]),
+		}
+		hints = append(hints, h)
+
+		off += size
+	}
+
+	return hints, nil
+}
+
+// readHintFile returns the hints of the data file at given fID from its hint file,
+// allocated from arena.
+func (db *DB) readHintFile(fID int64, arena *recordArena) ([]*Hint, error) {
+	buf, err := db.readFile(db.getHintPath(fID))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHints(buf, fID, arena)
+}
+
+// isHintEnabled reports whether hint files are written and loaded.
+// Only HintKeyAndRAMIdxMode benefits from them: the other modes need
+// the values or only parse the active file.
+func (db *DB) isHintEnabled() bool {
+	return db.opt.EntryIdxMode == HintKeyAndRAMIdxMode
+}
+
+// addActiveHint records the hint of the entry written to the active file at given off.
+func (db *DB) addActiveHint(e *Entry, off int64) {
+	if db.isHintEnabled() {
+		db.activeHints = append(db.activeHints, encodeHint(e, uint64(off))...)
+	}
+}
+
+// sealActiveFile writes the hint file of the active file before it is replaced.
+func (db *DB) sealActiveFile() error {
+	if !db.isHintEnabled() {
+		return nil
+	}
+
+	hints := db.activeHints
+	db.activeHints = nil
+
+	return db.writeFileAtomic(db.getHintPath(db.ActiveFile.fileID), hints, db.opt.SyncEnable)
+}
+
+// getHintPath returns the hint path at given fid.
+func (db *DB) getHintPath(fID int64) string {
+	return db.opt.Dir + "/" + strconv2.Int64ToStr(fID) + HintSuffix
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestHint_EncodeDecode(t *testing.T) {
+	buf := append(encodeHint(&entry, 0), encodeHint(&entry, 100)...)
+
+	hints, err := decodeHints(buf, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hints) != 2 {
+		t.Fatalf("err decodeHints. got %d want 2", len(hints))
+	}
+
+	h := hints[1]
+	if string(h.key) != string(entry.Key) || h.dataPos != 100 || h.fileID != 3 ||
+		string(h.meta.bucket) != string(entry.Meta.bucket) || h.meta.Flag != entry.Meta.Flag {
+		t.Errorf("err decodeHints. got %+v", h)
+	}
+
+	buf[len(buf)-1]++
+	if _, err := decodeHints(buf, 3, nil); err != ErrCrc {
+		t.Errorf("err decodeHints. got %v want %v", err, ErrCrc)
+	}
+
+	if _, err := decodeHints(buf[:len(buf)-1], 3, nil); err != ErrCorrupted {
+		t.Errorf("err decodeHints. got %v want %v", err, ErrCorrupted)
+	}
+}
+
+func TestDB_HintFile(t *testing.T) {
+	fileDir := "/tmp/nutsdbtesthint"
+	InitOpt(fileDir, true)
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	opt.SegmentSize = 1024
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "bucket_hint"
+	for i := 0; i < 100; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%03d", i)), []byte(fmt.Sprintf("val_%03d", i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if db.MaxFileID == 0 {
+		t.Fatal("err rotate. no sealed file")
+	}
+	maxFileID := db.MaxFileID
+	db.Close()
+
+	for fID := int64(0); fID < maxFileID; fID++ {
+		if _, err := os.Stat(fmt.Sprintf("%s/%d%s", fileDir, fID, HintSuffix)); err != nil {
+			t.Fatalf("err hint file. %v", err)
+		}
+	}
+
+	// a broken hint file falls back to parsing the data file
+	if err := os.WriteFile(fmt.Sprintf("%s/0%s", fileDir, HintSuffix), []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *Tx) error {
+		for i := 0; i < 100; i++ {
+			e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%03d", i)))
+			if err != nil {
+				return err
+			}
+			if string(e.Value) != fmt.Sprintf("val_%03d", i) {
+				t.Errorf("err Get. got %s want val_%03d", e.Value, i)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
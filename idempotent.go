@@ -0,0 +1,80 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// IdempotencyBucket is the bucket in which the IDs of the operations applied by PutIdempotent are stored.
+const IdempotencyBucket = "__nutsdb_ops__"
+
+// ErrOpIDEmpty is returned when PutIdempotent is called with an empty operation ID.
+var ErrOpIDEmpty = errors.New("operation id cannot be empty")
+
+// PutIdempotent sets the value for a key in the bucket like Put, unless the operation
+// opID was already applied, and returns if it applied the operation. The operation IDs
+// are recorded in IdempotencyBucket by the transaction, so a retried operation of an
+// at-least-once pipeline is applied exactly once. The IDs share one namespace across
+// the buckets, and are kept for Options.IdempotencyTTL.
+func (tx *Tx) PutIdempotent(bucket string, key, value, opID []byte) (applied bool, err error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return false, err
+	}
+
+	if len(opID) == 0 {
+		return false, ErrOpIDEmpty
+	}
+
+	ok, err := tx.isApplied(opID)
+	if err != nil || ok {
+		return false, err
+	}
+
+	if err := tx.Put(bucket, key, value, Persistent); err != nil {
+		return false, err
+	}
+
+	record := make([]byte, 8)
+	binary.BigEndian.PutUint64(record, tx.id)
+
+	if err := tx.Put(IdempotencyBucket, opID, record, tx.db.opt.IdempotencyTTL); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// isApplied returns if the operation opID was applied, by a committed transaction or by this one.
+func (tx *Tx) isApplied(opID []byte) (bool, error) {
+	for _, e := range tx.pendingWrites {
+		if string(e.Meta.bucket) == IdempotencyBucket && bytes.Equal(e.Key, opID) {
+			return true, nil
+		}
+	}
+
+	_, err := tx.Get(IdempotencyBucket, opID)
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrBucketNotFound) {
+		return false, nil
+	}
+
+	return false, err
+}
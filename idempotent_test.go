@@ -0,0 +1,135 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTx_PutIdempotent(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestidempotent", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "bucket"
+	put := func(key, value, opID string) bool {
+		var applied bool
+		if err := db.Update(func(tx *Tx) error {
+			var err error
+			applied, err = tx.PutIdempotent(bucket, []byte(key), []byte(value), []byte(opID))
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return applied
+	}
+
+	if !put("key1", "val1", "op1") {
+		t.Error("TestTx_PutIdempotent err: op1 not applied")
+	}
+	if put("key1", "val2", "op1") {
+		t.Error("TestTx_PutIdempotent err: op1 applied twice")
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		if _, err := tx.PutIdempotent(bucket, []byte("key2"), []byte("val"), nil); err != ErrOpIDEmpty {
+			t.Errorf("expected ErrOpIDEmpty, got %v", err)
+		}
+		if applied, err := tx.PutIdempotent(bucket, []byte("key2"), []byte("val1"), []byte("op2")); err != nil || !applied {
+			t.Errorf("TestTx_PutIdempotent err: op2 not applied, %v", err)
+		}
+		if applied, err := tx.PutIdempotent(bucket, []byte("key2"), []byte("val2"), []byte("op2")); err != nil || applied {
+			t.Errorf("TestTx_PutIdempotent err: op2 applied twice in a transaction, %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if put("key1", "val3", "op1") || put("key2", "val3", "op2") {
+		t.Error("TestTx_PutIdempotent err: operation applied again after reopening")
+	}
+
+	err = db.View(func(tx *Tx) error {
+		for key, want := range map[string]string{"key1": "val1", "key2": "val1"} {
+			e, err := tx.Get(bucket, []byte(key))
+			if err != nil {
+				return err
+			}
+			if string(e.Value) != want {
+				t.Errorf("TestTx_PutIdempotent err: %s = %s, want %s", key, e.Value, want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTx_PutIdempotentTTL(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestidempotentttl", true)
+	clock := NewManualClock(time.Unix(1000, 0))
+	opt.Clock = clock
+	opt.IdempotencyTTL = 60
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i, want := range []bool{true, false} {
+		if i == 1 {
+			clock.Advance(30 * time.Second)
+		}
+		err = db.Update(func(tx *Tx) error {
+			applied, err := tx.PutIdempotent("bucket", []byte("key"), []byte("val"), []byte("op"))
+			if applied != want {
+				t.Errorf("TestTx_PutIdempotentTTL err: applied %v, want %v", applied, want)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock.Advance(31 * time.Second)
+	err = db.Update(func(tx *Tx) error {
+		applied, err := tx.PutIdempotent("bucket", []byte("key"), []byte("val"), []byte("op"))
+		if !applied {
+			t.Error("TestTx_PutIdempotentTTL err: expired operation id not applied")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
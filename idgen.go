@@ -0,0 +1,132 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+)
+
+// IDLeaseFileName is the name of the file holding the upper bound of the handed out IDs.
+const IDLeaseFileName = "id.lease"
+
+// idLeaseSpan is the range of IDs reserved by one write of the lease file,
+// one minute of snowflake time.
+const idLeaseSpan = 60 * 1000 << 22
+
+// ErrIDLeaseCorrupted is returned when the lease file is not 8 bytes long.
+var ErrIDLeaseCorrupted = wrapError("id lease corrupted", ErrCorrupted)
+
+// idGenerator generates the snowflake layout IDs of the transactions and of
+// NewMonotonicID. IDs never go backwards: when the clock does, the last ID
+// is incremented instead. The IDs are reserved ahead in the lease file, so
+// that they are not reused after a restart either.
+type idGenerator struct {
+	mu      sync.Mutex
+	storage Storage
+	perm    os.FileMode
+	path    string
+	node    uint64
+	nodeErr error
+	sync    bool
+	noLease bool
+	last    uint64
+	lease   uint64
+}
+
+// newIDGenerator returns a newly initialized idGenerator, starting after the lease file of the dir
+// in storage, written with perm.
+func newIDGenerator(opt Options, storage Storage, perm os.FileMode) (*idGenerator, error) {
+	g := &idGenerator{
+		storage: storage,
+		perm:    perm,
+		path:    opt.Dir + "/" + IDLeaseFileName,
+		node:    uint64(opt.NodeNum),
+		sync:    opt.SyncEnable,
+		noLease: opt.ReadOnly,
+	}
+
+	// an invalid node number fails the transactions, not Open.
+	_, g.nodeErr = snowflake.NewNode(opt.NodeNum)
+
+	// no IDs are handed out by read-only databases.
+	if g.noLease {
+		return g, nil
+	}
+
+	buf, err := readStorageFile(g.storage, g.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		if len(buf) != 8 {
+			return nil, ErrIDLeaseCorrupted
+		}
+		g.lease = binary.LittleEndian.Uint64(buf)
+		g.last = g.lease
+	}
+
+	return g, nil
+}
+
+// next returns an ID greater than all the IDs returned before.
+func (g *idGenerator) next() (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.nodeErr != nil {
+		return 0, g.nodeErr
+	}
+
+	ms := time.Now().UnixNano()/int64(time.Millisecond) - snowflake.Epoch
+	id := uint64(ms)<<(snowflake.NodeBits+snowflake.StepBits) | g.node<<snowflake.StepBits
+	if id <= g.last {
+		id = g.last + 1
+	}
+
+	if id >= g.lease && !g.noLease {
+		lease := id + idLeaseSpan
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, lease)
+		if err := writeStorageFileAtomic(g.storage, g.path, buf, g.sync, g.perm); err != nil {
+			return 0, err
+		}
+		g.lease = lease
+	}
+
+	g.last = id
+
+	return id, nil
+}
+
+// NewMonotonicID returns an ID from the generator of the transaction IDs.
+// The IDs are greater than all the IDs returned before, also across restarts
+// and when the clock goes backwards, and they are never used by a transaction.
+func (db *DB) NewMonotonicID() (uint64, error) {
+	if db.closed {
+		return 0, ErrDBClosed
+	}
+
+	if db.opt.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	return db.ids.next()
+}
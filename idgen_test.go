@@ -0,0 +1,70 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDB_NewMonotonicID(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmonotonicid", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last uint64
+	for i := 0; i < 10000; i++ {
+		id, err := db.NewMonotonicID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("err NewMonotonicID. got %d after %d", id, last)
+		}
+		last = id
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewMonotonicID(); err != ErrDBClosed {
+		t.Errorf("err NewMonotonicID on closed db. got %v want %v", err, ErrDBClosed)
+	}
+
+	// pretend the clock went backwards: the lease keeps the IDs after the old ones.
+	buf, err := ioutil.ReadFile(opt.Dir + "/" + IDLeaseFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease := binary.LittleEndian.Uint64(buf) + 1<<40
+	binary.LittleEndian.PutUint64(buf, lease)
+	if err := ioutil.WriteFile(opt.Dir+"/"+IDLeaseFileName, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	id, err := db.NewMonotonicID()
+	if err != nil || id <= lease {
+		t.Errorf("err NewMonotonicID after reopen. got %d %v want > %d", id, err, lease)
+	}
+}
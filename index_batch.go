@@ -0,0 +1,38 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+// indexBatch records the index updates of a commit, so that they are applied
+// at once after the entries are written instead of entry by entry.
+type indexBatch struct {
+	txID      uint64
+	countFlag bool
+	writesLen int
+	buckets   []string // the buckets of the b+ tree items, in the order of their first item
+	items     map[string][]BatchItem
+}
+
+// add records the b+ tree item of the key in the bucket.
+func (b *indexBatch) add(bucket string, key []byte, e *Entry, h *Hint) {
+	if b.items == nil {
+		b.items = make(map[string][]BatchItem)
+	}
+
+	if _, ok := b.items[bucket]; !ok {
+		b.buckets = append(b.buckets, bucket)
+	}
+
+	b.items[bucket] = append(b.items[bucket], BatchItem{Key: key, E: e, H: h})
+}
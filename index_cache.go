@@ -0,0 +1,218 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// indexNodeCache serves the B+ tree nodes of the key index files of HintBPTSparseIdxMode
+// from the files memory-mapped, instead of opening a file for every node, and caches the
+// decoded nodes of the least recently used ones up to a capacity. The nodes stay off the
+// Go heap but for the cache, so the GC work does not grow with the number of keys.
+// A key index file is written once, when its data file is sealed, so its mapping and
+// its cached nodes never go stale.
+type indexNodeCache struct {
+	mu       sync.Mutex
+	capacity int
+	files    map[string]mappedFile
+	lru      *list.List
+	nodes    map[indexNodeKey]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+// indexNodeKey identifies a node by its index file and address.
+type indexNodeKey struct {
+	path    string
+	address int64
+}
+
+// indexNodeItem records a cached node.
+type indexNodeItem struct {
+	key  indexNodeKey
+	node *BinaryNode
+}
+
+// IndexCacheStats represents the statistics of the node cache of Options.IndexCacheSize.
+type IndexCacheStats struct {
+	// Nodes is the number of nodes cached.
+	Nodes int
+
+	// MappedFiles is the number of index files memory-mapped.
+	MappedFiles int
+
+	// Hits and Misses count the node reads served from the cache or not.
+	Hits   uint64
+	Misses uint64
+}
+
+// newIndexNodeCache returns a newly initialized indexNodeCache of capacity nodes,
+// nil if capacity is not positive.
+func newIndexNodeCache(capacity int) *indexNodeCache {
+	if capacity <= 0 {
+		return nil
+	}
+
+	return &indexNodeCache{
+		capacity: capacity,
+		files:    make(map[string]mappedFile),
+		lru:      list.New(),
+		nodes:    make(map[indexNodeKey]*list.Element),
+	}
+}
+
+// readNode returns the node at given address of the index file at given path.
+// The node is shared by the readers and must not be modified.
+func (c *indexNodeCache) readNode(path string, address int64) (*BinaryNode, error) {
+	if !isValidAddress(address) {
+		return nil, fmt.Errorf("Invalid address. Cannot read node at %v ", address)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := indexNodeKey{path: path, address: address}
+	if el, ok := c.nodes[key]; ok {
+		c.hits++
+		c.lru.MoveToFront(el)
+		return el.Value.(*indexNodeItem).node, nil
+	}
+	c.misses++
+
+	m, err := c.mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the nodes are getBinaryNodeSize apart, but encoded without the padding of the struct.
+	bn := new(BinaryNode)
+	size := int64(binary.Size(bn))
+	if address+size > int64(len(m)) {
+		return nil, io.EOF
+	}
+
+	if err := binary.Read(bytes.NewReader(m[address:address+size]), binary.LittleEndian, bn); err != nil {
+		return nil, err
+	}
+
+	c.nodes[key] = c.lru.PushFront(&indexNodeItem{key: key, node: bn})
+	for c.lru.Len() > c.capacity {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.nodes, el.Value.(*indexNodeItem).key)
+	}
+
+	return bn, nil
+}
+
+// mapFile returns the mapping of the index file at given path, mapping it if needed.
+// It must be called with c.mu held.
+func (c *indexNodeCache) mapFile(path string) (mappedFile, error) {
+	if m, ok := c.files[path]; ok {
+		return m, nil
+	}
+
+	m, err := mapIndexFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c.files[path] = m
+
+	return m, nil
+}
+
+// forget unmaps the index file at given path and drops its nodes,
+// e.g. before it is rewritten or removed.
+func (c *indexNodeCache) forget(path string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if item := el.Value.(*indexNodeItem); item.key.path == path {
+			c.lru.Remove(el)
+			delete(c.nodes, item.key)
+		}
+		el = next
+	}
+
+	m, ok := c.files[path]
+	if !ok {
+		return nil
+	}
+	delete(c.files, path)
+
+	return m.Unmap()
+}
+
+// close unmaps all the index files and drops the nodes.
+func (c *indexNodeCache) close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for path, m := range c.files {
+		if e := m.Unmap(); e != nil && err == nil {
+			err = e
+		}
+		delete(c.files, path)
+	}
+
+	c.lru.Init()
+	c.nodes = make(map[indexNodeKey]*list.Element)
+
+	return err
+}
+
+// stats returns the statistics of the cache.
+func (c *indexNodeCache) stats() IndexCacheStats {
+	if c == nil {
+		return IndexCacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return IndexCacheStats{Nodes: c.lru.Len(), MappedFiles: len(c.files), Hits: c.hits, Misses: c.misses}
+}
+
+// readIndexNode returns the node at given address of the key index file at given path,
+// through the node cache if Options.IndexCacheSize is set.
+func (db *DB) readIndexNode(path string, address int64) (*BinaryNode, error) {
+	if db.nodeCache == nil {
+		return ReadNode(path, address)
+	}
+
+	return db.nodeCache.readNode(path, address)
+}
+
+// IndexCacheStats returns the statistics of the node cache of Options.IndexCacheSize.
+func (db *DB) IndexCacheStats() IndexCacheStats {
+	return db.nodeCache.stats()
+}
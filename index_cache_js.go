@@ -0,0 +1,31 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "io/ioutil"
+
+// mappedFile represents the content of an index file read by the indexNodeCache,
+// there is no mmap under GOOS=js.
+type mappedFile []byte
+
+// mapIndexFile reads the index file at given path in memory.
+func mapIndexFile(path string) (mappedFile, error) {
+	return ioutil.ReadFile(path)
+}
+
+// Unmap releases the content of the file.
+func (m mappedFile) Unmap() error {
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+
+package nutsdb
+
+import (
+	"os"
+
+	mmap "github.com/xujiajun/mmap-go"
+)
+
+// mappedFile represents the content of an index file mapped by the indexNodeCache.
+type mappedFile = mmap.MMap
+
+// mapIndexFile maps the index file at given path read-only.
+func mapIndexFile(path string) (mappedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return mmap.Map(f, mmap.RDONLY, 0)
+}
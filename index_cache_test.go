@@ -0,0 +1,83 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_IndexCache(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestindexcache", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+	opt.IndexCacheSize = 8
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 300; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%03d", i)), []byte(fmt.Sprintf("val_%03d", i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func() {
+		for round := 0; round < 2; round++ {
+			checkSparseTestDB(t)
+		}
+
+		stats := db.IndexCacheStats()
+		if stats.Hits == 0 || stats.Misses == 0 || stats.MappedFiles == 0 || stats.Nodes > 8 {
+			t.Errorf("err IndexCacheStats. got %+v", stats)
+		}
+	}
+
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.IndexCacheStats(); stats.Nodes != 0 || stats.MappedFiles != 0 {
+		t.Errorf("err IndexCacheStats after Close. got %+v", stats)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	check()
+}
+
+func TestDB_IndexCacheDisabled(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestindexcache", true)
+	opt.IndexCacheSize = 8
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.nodeCache != nil {
+		t.Error("err IndexCache enabled out of HintBPTSparseIdxMode")
+	}
+	if stats := db.IndexCacheStats(); stats != (IndexCacheStats{}) {
+		t.Errorf("err IndexCacheStats. got %+v", stats)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// indexMemory caps the memory used by the values kept in the B+ tree
+// indexes in HintKeyValAndRAMIdxMode. When the cap is exceeded, the values
+// of the least recently used records are dropped from RAM and read from
+// disk again, like in HintKeyAndRAMIdxMode.
+type indexMemory struct {
+	mu       sync.Mutex
+	max      int64
+	used     int64
+	lru      *list.List
+	elements map[*Record]*list.Element
+}
+
+// indexMemoryItem records the size of the value of a record kept in RAM.
+type indexMemoryItem struct {
+	r    *Record
+	size int64
+}
+
+// newIndexMemory returns a newly initialized indexMemory at given max bytes.
+func newIndexMemory(max int64) *indexMemory {
+	return &indexMemory{
+		max:      max,
+		lru:      list.New(),
+		elements: make(map[*Record]*list.Element),
+	}
+}
+
+// admit accounts the value of the record as the most recently used,
+// then drops the values of the least recently used records until the
+// memory fits the cap. It must be called with the db write lock held.
+func (m *indexMemory) admit(r *Record) {
+	if m == nil || r == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.elements[r]; ok {
+		m.used -= el.Value.(*indexMemoryItem).size
+		m.lru.Remove(el)
+		delete(m.elements, r)
+	}
+
+	if r.E != nil {
+		size := int64(len(r.E.Key) + len(r.E.Value))
+		m.elements[r] = m.lru.PushFront(&indexMemoryItem{r: r, size: size})
+		m.used += size
+	}
+
+	for m.used > m.max && m.lru.Len() > 1 {
+		el := m.lru.Back()
+		item := el.Value.(*indexMemoryItem)
+		m.lru.Remove(el)
+		delete(m.elements, item.r)
+		m.used -= item.size
+		item.r.E = nil
+	}
+}
+
+// touch marks the record as the most recently used, keeping its value hot.
+func (m *indexMemory) touch(r *Record) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.elements[r]; ok {
+		m.lru.MoveToFront(el)
+	}
+}
+
+// Used returns the bytes of the values kept in RAM.
+func (m *indexMemory) Used() int64 {
+	if m == nil {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.used
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestDB_MaxIndexMemory(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestindexmemory", true)
+	opt.MaxIndexMemory = 2 * 1024
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "bucket_index_memory"
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 100)
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%03d", i)), value(i), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if used := db.indexMemory.Used(); used > opt.MaxIndexMemory {
+		t.Errorf("err Used. got %d want <= %d", used, opt.MaxIndexMemory)
+	}
+
+	check := func() {
+		if err := db.View(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%03d", i)))
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(e.Value, value(i)) {
+					t.Errorf("err Get key_%03d. got %v", i, e.Value[:1])
+				}
+			}
+
+			entries, err := tx.GetAll(bucket)
+			if err != nil {
+				return err
+			}
+			if len(entries) != 100 {
+				t.Errorf("err GetAll. got %d want 100", len(entries))
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// cold values are read from disk
+	check()
+
+	db.Close()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if used := db.indexMemory.Used(); used > opt.MaxIndexMemory {
+		t.Errorf("err Used after reopen. got %d want <= %d", used, opt.MaxIndexMemory)
+	}
+
+	check()
+}
@@ -0,0 +1,275 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keys provides order-preserving key encoders: the encoded keys
+// compare bytewise in the same order as the values, so range scans over
+// numbers, times and composite keys work with the default key order.
+//
+//	key, _ := keys.Tuple("user", uint64(42), time.Now())
+//	entries, _ := tx.PrefixScan(bucket, keys.MustTuple("user", uint64(42)), nutsdb.ScanNoLimit)
+package keys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	// ErrInvalidLength is returned when a fixed size value is decoded from a slice of another length.
+	ErrInvalidLength = errors.New("keys: invalid length")
+
+	// ErrUnsupportedType is returned when a tuple element has a type without encoder.
+	ErrUnsupportedType = errors.New("keys: unsupported type")
+
+	// ErrInvalidTuple is returned when a tuple cannot be decoded.
+	ErrInvalidTuple = errors.New("keys: invalid tuple")
+)
+
+// tuple element type tags, ordering elements of different types.
+const (
+	tagBytes byte = iota + 1
+	tagString
+	tagUint64
+	tagInt64
+	tagFloat64
+	tagTime
+)
+
+const (
+	escapeByte     = 0x00
+	escapedZero    = 0xFF
+	terminatorByte = 0x01
+)
+
+// EncodeUint64 returns the big-endian encoding of v.
+func EncodeUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// DecodeUint64 returns the uint64 encoded by EncodeUint64.
+func DecodeUint64(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, ErrInvalidLength
+	}
+
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// EncodeInt64 returns the encoding of v with the sign bit flipped,
+// so negative values sort before positive ones.
+func EncodeInt64(v int64) []byte {
+	return EncodeUint64(uint64(v) ^ (1 << 63))
+}
+
+// DecodeInt64 returns the int64 encoded by EncodeInt64.
+func DecodeInt64(b []byte) (int64, error) {
+	u, err := DecodeUint64(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(u ^ (1 << 63)), nil
+}
+
+// EncodeFloat64 returns the encoding of the IEEE 754 bits of v, with every
+// bit flipped for negative values and only the sign bit for the others.
+// NaN sorts after +Inf.
+func EncodeFloat64(v float64) []byte {
+	u := math.Float64bits(v)
+	if u&(1<<63) != 0 {
+		u = ^u
+	} else {
+		u ^= 1 << 63
+	}
+
+	return EncodeUint64(u)
+}
+
+// DecodeFloat64 returns the float64 encoded by EncodeFloat64.
+func DecodeFloat64(b []byte) (float64, error) {
+	u, err := DecodeUint64(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if u&(1<<63) != 0 {
+		u ^= 1 << 63
+	} else {
+		u = ^u
+	}
+
+	return math.Float64frombits(u), nil
+}
+
+// EncodeTime returns the encoding of the Unix nanoseconds of t.
+// Times outside the years 1678 to 2262 are not representable.
+func EncodeTime(t time.Time) []byte {
+	return EncodeInt64(t.UnixNano())
+}
+
+// DecodeTime returns the time encoded by EncodeTime, in UTC.
+func DecodeTime(b []byte) (time.Time, error) {
+	n, err := DecodeInt64(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, n).UTC(), nil
+}
+
+// Tuple returns the order-preserving encoding of the elements, which may be
+// []byte, string, uint64, int64, int, float64 or time.Time. Tuples compare
+// element by element, and the encoding of a tuple is a prefix of the
+// encoding of every longer tuple starting with the same elements.
+func Tuple(elems ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, elem := range elems {
+		switch v := elem.(type) {
+		case []byte:
+			buf.WriteByte(tagBytes)
+			writeEscaped(&buf, v)
+		case string:
+			buf.WriteByte(tagString)
+			writeEscaped(&buf, []byte(v))
+		case uint64:
+			buf.WriteByte(tagUint64)
+			buf.Write(EncodeUint64(v))
+		case int64:
+			buf.WriteByte(tagInt64)
+			buf.Write(EncodeInt64(v))
+		case int:
+			buf.WriteByte(tagInt64)
+			buf.Write(EncodeInt64(int64(v)))
+		case float64:
+			buf.WriteByte(tagFloat64)
+			buf.Write(EncodeFloat64(v))
+		case time.Time:
+			buf.WriteByte(tagTime)
+			buf.Write(EncodeTime(v))
+		default:
+			return nil, ErrUnsupportedType
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MustTuple is like Tuple but panics if an element has an unsupported type.
+func MustTuple(elems ...interface{}) []byte {
+	b, err := Tuple(elems...)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// DecodeTuple returns the elements encoded by Tuple. int elements are decoded as int64.
+func DecodeTuple(b []byte) ([]interface{}, error) {
+	var elems []interface{}
+
+	for len(b) > 0 {
+		tag := b[0]
+		b = b[1:]
+
+		switch tag {
+		case tagBytes, tagString:
+			v, rest, err := readEscaped(b)
+			if err != nil {
+				return nil, err
+			}
+			b = rest
+			if tag == tagString {
+				elems = append(elems, string(v))
+			} else {
+				elems = append(elems, v)
+			}
+		case tagUint64, tagInt64, tagFloat64, tagTime:
+			if len(b) < 8 {
+				return nil, ErrInvalidTuple
+			}
+			elems = append(elems, decodeFixed(tag, b[:8]))
+			b = b[8:]
+		default:
+			return nil, ErrInvalidTuple
+		}
+	}
+
+	return elems, nil
+}
+
+func decodeFixed(tag byte, b []byte) interface{} {
+	switch tag {
+	case tagUint64:
+		v, _ := DecodeUint64(b)
+		return v
+	case tagInt64:
+		v, _ := DecodeInt64(b)
+		return v
+	case tagFloat64:
+		v, _ := DecodeFloat64(b)
+		return v
+	default:
+		v, _ := DecodeTime(b)
+		return v
+	}
+}
+
+// writeEscaped writes b with every 0x00 escaped as 0x00 0xFF, terminated by 0x00 0x01,
+// so shorter values sort before the longer values they prefix.
+func writeEscaped(buf *bytes.Buffer, b []byte) {
+	for _, c := range b {
+		buf.WriteByte(c)
+		if c == escapeByte {
+			buf.WriteByte(escapedZero)
+		}
+	}
+
+	buf.WriteByte(escapeByte)
+	buf.WriteByte(terminatorByte)
+}
+
+// readEscaped returns the value written by writeEscaped and the remaining bytes.
+func readEscaped(b []byte) (v []byte, rest []byte, err error) {
+	v = []byte{}
+
+	for i := 0; i < len(b); i++ {
+		if b[i] != escapeByte {
+			v = append(v, b[i])
+			continue
+		}
+
+		if i+1 >= len(b) {
+			return nil, nil, ErrInvalidTuple
+		}
+
+		switch b[i+1] {
+		case escapedZero:
+			v = append(v, escapeByte)
+			i++
+		case terminatorByte:
+			return v, b[i+2:], nil
+		default:
+			return nil, nil, ErrInvalidTuple
+		}
+	}
+
+	return nil, nil, ErrInvalidTuple
+}
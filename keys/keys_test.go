@@ -0,0 +1,110 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func checkOrder(t *testing.T, name string, encoded [][]byte) {
+	if !sort.SliceIsSorted(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	}) {
+		t.Errorf("err %s order. got %x", name, encoded)
+	}
+}
+
+func TestEncode_Order(t *testing.T) {
+	var encoded [][]byte
+
+	for _, v := range []uint64{0, 1, 255, 256, math.MaxUint64} {
+		encoded = append(encoded, EncodeUint64(v))
+	}
+	checkOrder(t, "uint64", encoded)
+
+	encoded = nil
+	for _, v := range []int64{math.MinInt64, -256, -1, 0, 1, math.MaxInt64} {
+		encoded = append(encoded, EncodeInt64(v))
+	}
+	checkOrder(t, "int64", encoded)
+
+	encoded = nil
+	for _, v := range []float64{math.Inf(-1), -1.5, -0.5, 0, 0.5, 1.5, math.Inf(1)} {
+		encoded = append(encoded, EncodeFloat64(v))
+	}
+	checkOrder(t, "float64", encoded)
+
+	encoded = nil
+	now := time.Now()
+	for _, v := range []time.Time{now.Add(-time.Hour), now, now.Add(time.Nanosecond)} {
+		encoded = append(encoded, EncodeTime(v))
+	}
+	checkOrder(t, "time", encoded)
+
+	encoded = nil
+	for _, v := range [][]interface{}{
+		{"a"},
+		{"a", uint64(1)},
+		{"a", uint64(2)},
+		{"a\x00"},
+		{"ab"},
+		{"b", int64(-1)},
+		{"b", int64(0)},
+	} {
+		encoded = append(encoded, MustTuple(v...))
+	}
+	checkOrder(t, "tuple", encoded)
+}
+
+func TestDecode(t *testing.T) {
+	if v, err := DecodeInt64(EncodeInt64(-42)); err != nil || v != -42 {
+		t.Errorf("err DecodeInt64. got %d %v", v, err)
+	}
+
+	if v, err := DecodeFloat64(EncodeFloat64(-1.25)); err != nil || v != -1.25 {
+		t.Errorf("err DecodeFloat64. got %v %v", v, err)
+	}
+
+	now := time.Unix(0, time.Now().UnixNano()).UTC()
+	if v, err := DecodeTime(EncodeTime(now)); err != nil || !v.Equal(now) {
+		t.Errorf("err DecodeTime. got %v %v", v, err)
+	}
+
+	if _, err := DecodeUint64([]byte{1}); err != ErrInvalidLength {
+		t.Errorf("err DecodeUint64. got %v want %v", err, ErrInvalidLength)
+	}
+
+	elems := []interface{}{[]byte{0, 1, 0}, "user", uint64(7), int64(-7), 1.5, now}
+	decoded, err := DecodeTuple(MustTuple(elems...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, elems) {
+		t.Errorf("err DecodeTuple. got %v want %v", decoded, elems)
+	}
+
+	if _, err := DecodeTuple([]byte{tagString, 'a'}); err != ErrInvalidTuple {
+		t.Errorf("err DecodeTuple. got %v want %v", err, ErrInvalidTuple)
+	}
+
+	if _, err := Tuple(struct{}{}); err != ErrUnsupportedType {
+		t.Errorf("err Tuple. got %v want %v", err, ErrUnsupportedType)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// lazyBucket records the b+ tree items of a bucket replayed when opening the
+// database, inserted into its index on the first access, see Options.LazyIndexLoad.
+type lazyBucket struct {
+	once   sync.Once
+	items  []BatchItem
+	loaded atomic.Bool
+}
+
+// lazyIndexLoad returns if the b+ tree indexes of the buckets are built on the first access.
+// The values admitted by MaxIndexMemory are evicted across the buckets, so it needs the
+// indexes to be built with the write lock held.
+func (db *DB) lazyIndexLoad() bool {
+	return db.opt.LazyIndexLoad && db.opt.EntryIdxMode != HintBPTSparseIdxMode && db.opt.MaxIndexMemory <= 0
+}
+
+// deferBPTreeIdx records the item of the record to be inserted into the index of the
+// bucket on its first access. The index is created empty, so that the bucket exists.
+func (db *DB) deferBPTreeIdx(bucket string, r *Record) {
+	if _, ok := db.BPTreeIdx[bucket]; !ok {
+		db.BPTreeIdx[bucket] = db.newBPTree(bucket)
+	}
+
+	lb, ok := db.lazyBuckets[bucket]
+	if !ok {
+		lb = &lazyBucket{}
+		db.lazyBuckets[bucket] = lb
+	}
+
+	lb.items = append(lb.items, BatchItem{Key: r.H.key, E: r.E, H: r.H})
+}
+
+// bptreeIdx returns the b+ tree index of the bucket, inserting the items deferred by
+// LazyIndexLoad first. The lazy buckets are only added when opening the database,
+// so it is safe under the read lock.
+func (db *DB) bptreeIdx(bucket string) (*BPTree, bool) {
+	t, ok := db.BPTreeIdx[bucket]
+	if !ok {
+		return nil, false
+	}
+
+	if lb, ok := db.lazyBuckets[bucket]; ok {
+		lb.once.Do(func() {
+			if err := t.InsertBatch(lb.items, CountFlagEnabled); err != nil {
+				db.health.recordError(err)
+			}
+			lb.items = nil
+			lb.loaded.Store(true)
+		})
+	}
+
+	return t, true
+}
+
+// loadBPTreeIdxes inserts the items deferred by LazyIndexLoad into the index of every bucket.
+func (db *DB) loadBPTreeIdxes() {
+	for bucket := range db.lazyBuckets {
+		db.bptreeIdx(bucket)
+	}
+}
+
+// LazyBuckets returns the number of buckets whose b+ tree index is not built yet,
+// see Options.LazyIndexLoad.
+func (db *DB) LazyBuckets() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	n := 0
+	for _, lb := range db.lazyBuckets {
+		if !lb.loaded.Load() {
+			n++
+		}
+	}
+
+	return n
+}
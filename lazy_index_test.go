@@ -0,0 +1,156 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestDB_LazyIndexLoad(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestlazyindex", true)
+	opt.LazyIndexLoad = true
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucketName := func(b int) string {
+		return fmt.Sprintf("bucket_%d", b)
+	}
+
+	for b := 0; b < 10; b++ {
+		for i := 0; i < 20; i++ {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put(bucketName(b), []byte(fmt.Sprintf("key_%02d", i)), []byte(fmt.Sprintf("val_%d_%02d", b, i)), Persistent)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Delete(bucketName(0), []byte("key_00"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := db.LazyBuckets(); n != 0 {
+		t.Errorf("err LazyBuckets before reopening. got %d want 0", n)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if n := db.LazyBuckets(); n != 10 {
+		t.Errorf("err LazyBuckets after reopening. got %d want 10", n)
+	}
+
+	// concurrent readers of the same bucket build its index once.
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = db.View(func(tx *Tx) error {
+				e, err := tx.Get(bucketName(1), []byte("key_05"))
+				if err != nil || string(e.Value) != "val_1_05" {
+					t.Errorf("err Get. got %v, %v", e, err)
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if n := db.LazyBuckets(); n != 9 {
+		t.Errorf("err LazyBuckets after a Get. got %d want 9", n)
+	}
+
+	// writing into a bucket not accessed yet keeps its older keys.
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put(bucketName(2), []byte("key_20"), []byte("val_2_20"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll(bucketName(2))
+		if err != nil {
+			return err
+		}
+		if len(entries) != 21 {
+			t.Errorf("err GetAll. got %d entries want 21", len(entries))
+		}
+
+		if _, err := tx.Get(bucketName(0), []byte("key_00")); err != ErrNotFoundKey {
+			t.Errorf("err Get deleted key. got %v want ErrNotFoundKey", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := db.LazyBuckets(); n != 7 {
+		t.Errorf("err LazyBuckets after an Update. got %d want 7", n)
+	}
+
+	// merges and stats read the index of every bucket.
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.FileStats(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.LazyBuckets(); n != 0 {
+		t.Errorf("err LazyBuckets after FileStats. got %d want 0", n)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		for b := 3; b < 10; b++ {
+			entries, err := tx.GetAll(bucketName(b))
+			if err != nil {
+				return err
+			}
+			if len(entries) != 20 {
+				t.Errorf("err GetAll %s. got %d entries want 20", bucketName(b), len(entries))
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_LazyIndexLoadIgnored(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestlazyindex", true)
+	opt.LazyIndexLoad = true
+	opt.MaxIndexMemory = 1024
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.lazyBuckets != nil {
+		t.Error("err LazyIndexLoad enabled with MaxIndexMemory")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "syscall"
+
+// madvise gives the hint a to the OS for the mapped memory b.
+func madvise(b []byte, a fileAdvice) error {
+	advice := syscall.MADV_SEQUENTIAL
+	switch a {
+	case adviceRandom:
+		advice = syscall.MADV_RANDOM
+	case adviceDontNeed:
+		advice = syscall.MADV_DONTNEED
+	}
+
+	return syscall.Madvise(b, advice)
+}
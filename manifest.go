@@ -0,0 +1,137 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"path"
+	"strings"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// Manifest describes the data files of a database, so that the external tools, e.g. of
+// retention, tiering or monitoring, can reason about them without parsing them. It is
+// serialized as JSON.
+type Manifest struct {
+	// Dir is the dir of the database.
+	Dir string `json:"dir"`
+
+	// SegmentSize is the size of the data files, see Options.SegmentSize.
+	SegmentSize int64 `json:"segment_size"`
+
+	// ActiveFileID is the ID of the active file, the one the entries are written to, and
+	// ActiveFileSize the size in bytes of its entries.
+	ActiveFileID   int64 `json:"active_file_id"`
+	ActiveFileSize int64 `json:"active_file_size"`
+
+	// LastTxID is the ID of the last committed transaction.
+	LastTxID uint64 `json:"last_tx_id"`
+
+	// Files are the data files, in ascending order of their IDs.
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a data file of a Manifest.
+type ManifestFile struct {
+	FileID int64  `json:"file_id"`
+	Name   string `json:"name"`
+
+	// Size is the size in bytes of the file on disk.
+	Size int64 `json:"size"`
+
+	// Sealed represents if no entry is written to the file anymore, all but the active one.
+	Sealed bool `json:"sealed"`
+
+	// MinTxID and MaxTxID are the range of the txIDs of the entries of the file, and
+	// MinTimestamp and MaxTimestamp the one of their timestamps in Unix seconds, zero
+	// for a file without entries.
+	MinTxID      uint64 `json:"min_tx_id"`
+	MaxTxID      uint64 `json:"max_tx_id"`
+	MinTimestamp uint64 `json:"min_timestamp"`
+	MaxTimestamp uint64 `json:"max_timestamp"`
+
+	// Entries, LiveEntries, ExpiredEntries, DeadEntries and Tombstones are the counts of
+	// the FileStat of the file.
+	Entries        int64 `json:"entries"`
+	LiveEntries    int64 `json:"live_entries"`
+	ExpiredEntries int64 `json:"expired_entries"`
+	DeadEntries    int64 `json:"dead_entries"`
+	Tombstones     int64 `json:"tombstones"`
+
+	// DirtyRatio is the part of the file a merge would free, see FileStat.DirtyRatio.
+	DirtyRatio float64 `json:"dirty_ratio"`
+}
+
+// Manifest returns the Manifest of the data files. Like FileStats, the first call loads
+// the counters of the files, and it is not supported in HintBPTSparseIdxMode.
+func (db *DB) Manifest() (*Manifest, error) {
+	stats, err := db.FileStats()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := db.readDir(db.opt.Dir)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[int64]int64, len(files))
+	for _, f := range files {
+		name := f.Name()
+		if path.Ext(name) != DataSuffix {
+			continue
+		}
+		if fID, err := strconv2.StrToInt64(strings.TrimSuffix(name, DataSuffix)); err == nil {
+			sizes[fID] = f.Size()
+		}
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	m := &Manifest{
+		Dir:            db.opt.Dir,
+		SegmentSize:    db.opt.SegmentSize,
+		ActiveFileID:   db.ActiveFile.fileID,
+		ActiveFileSize: db.ActiveFile.writeOff,
+		LastTxID:       db.LastCommittedTxID(),
+		Files:          make([]ManifestFile, 0, len(stats)),
+	}
+
+	for _, s := range stats {
+		f := ManifestFile{
+			FileID:         s.FileID,
+			Name:           getFileName(s.FileID, DataSuffix),
+			Size:           sizes[s.FileID],
+			Sealed:         s.FileID != db.ActiveFile.fileID,
+			Entries:        s.Entries,
+			LiveEntries:    s.LiveEntries,
+			ExpiredEntries: s.ExpiredEntries,
+			DeadEntries:    s.DeadEntries,
+			Tombstones:     s.Tombstones,
+			DirtyRatio:     s.DirtyRatio(),
+		}
+		if c, ok := db.fileCounters[s.FileID]; ok {
+			f.MinTxID, f.MaxTxID = c.minTxID, c.maxTxID
+			f.MinTimestamp, f.MaxTimestamp = c.minTimestamp, c.maxTimestamp
+		}
+		m.Files = append(m.Files, f)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDB_Manifest(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestmanifest", true)
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 1024
+		start := time.Unix(1600000000, 0)
+		clock := NewManualClock(start)
+		opt.Clock = clock
+
+		db, err := Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 20; i++ {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i%5)), make([]byte, 100), Persistent)
+			}); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(time.Minute)
+		}
+
+		m, err := db.Manifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Dir != opt.Dir || m.SegmentSize != 1024 || m.ActiveFileID != db.ActiveFile.fileID ||
+			m.ActiveFileSize != db.ActiveFile.writeOff || m.LastTxID != db.LastCommittedTxID() || len(m.Files) < 2 {
+			t.Fatalf("mode %d: err manifest %+v", mode, m)
+		}
+
+		var entries, live int64
+		for i, f := range m.Files {
+			if i > 0 && (f.FileID <= m.Files[i-1].FileID || f.MinTxID <= m.Files[i-1].MaxTxID ||
+				f.MinTimestamp < m.Files[i-1].MaxTimestamp) {
+				t.Errorf("mode %d: err files %+v and %+v are not in order", mode, m.Files[i-1], f)
+			}
+			if f.Sealed != (f.FileID != m.ActiveFileID) || f.Name != getFileName(f.FileID, DataSuffix) || f.Size != 1024 {
+				t.Errorf("mode %d: err file %+v", mode, f)
+			}
+			if f.MinTxID > f.MaxTxID || f.MinTimestamp > f.MaxTimestamp || f.MaxTimestamp > clock.Now() {
+				t.Errorf("mode %d: err ranges of file %+v", mode, f)
+			}
+			entries += f.Entries
+			live += f.LiveEntries
+		}
+		if entries != 20 || live != 5 {
+			t.Errorf("mode %d: err entries %d, live %d", mode, entries, live)
+		}
+		if first := m.Files[0]; first.MinTimestamp != uint64(start.Unix()) || first.DirtyRatio != 1 {
+			t.Errorf("mode %d: err first file %+v", mode, first)
+		}
+
+		buf, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Manifest
+		if err := json.Unmarshal(buf, &decoded); err != nil || !reflect.DeepEqual(&decoded, m) {
+			t.Errorf("mode %d: err JSON %s: %v", mode, buf, err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Manifest(); err != ErrDBClosed {
+			t.Errorf("mode %d: err Manifest after Close: %v", mode, err)
+		}
+	}
+}
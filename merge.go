@@ -0,0 +1,347 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// MergeOptions represents the options of MergeWithOptions.
+type MergeOptions struct {
+	// MaxFiles represents the max number of files merged, the dirtiest first.
+	// Default MaxFiles is 0, which means no limit.
+	MaxFiles int
+
+	// MinDirtyRatio represents the min part of the size of a file a merge would free,
+	// see FileStat.DirtyRatio, for the file to be merged.
+	// Default MinDirtyRatio is 0, which means every file with some garbage.
+	MinDirtyRatio float64
+
+	// OnProgress represents the function called after every merged file.
+	// Default OnProgress is nil.
+	OnProgress func(MergeProgress)
+
+	// Parallelism represents the max number of files merged at the same time: the files
+	// are read by up to Parallelism workers, ahead of their rewrites, which are committed
+	// and removed one by one in order. The entries written since a file was read are
+	// not rewritten.
+	// Default Parallelism is 0, which means 1, merging the files one by one.
+	Parallelism int
+}
+
+// MergeProgress represents the progress of a merge.
+type MergeProgress struct {
+	// FileID is the ID of the file just merged.
+	FileID int64
+
+	// FilesDone and FilesTotal are the numbers of merged and of chosen files.
+	FilesDone  int
+	FilesTotal int
+
+	// BytesReclaimed estimates the freed bytes so far, the size of the merged
+	// files minus the size of the entries rewritten from them.
+	BytesReclaimed int64
+}
+
+// mergeStep names a step of the merge of a file, see afterMergeStep.
+type mergeStep string
+
+const (
+	mergeStepSegmentCreated    mergeStep = "segment created"      // the temporary file of the new data file
+	mergeStepActiveSealed      mergeStep = "active file sealed"   // and replaced by the temporary file
+	mergeStepSegmentPublished  mergeStep = "segment published"    // the entries written and the file renamed
+	mergeStepCommitted         mergeStep = "committed"            // the entries indexed
+	mergeStepLastTxIDPersisted mergeStep = "last tx ID persisted" // before the merged file is removed
+	mergeStepFileRemoved       mergeStep = "data file removed"
+	mergeStepCheckpointRemoved mergeStep = "checkpoint removed"
+	mergeStepHintRemoved       mergeStep = "hint file removed"
+)
+
+// afterMergeStep is called after every step of the merge of a file by Merge.
+// It is a variable so that tests can copy the files as a crash would leave them.
+var afterMergeStep = func(step mergeStep) {}
+
+// MergeWorst merges the n dirtiest sealed data files, see MergeWithOptions.
+func (db *DB) MergeWorst(n int) error {
+	return db.MergeWithOptions(MergeOptions{MaxFiles: n})
+}
+
+// MergeWithOptions merges the dirtiest sealed data files chosen by the options,
+// so the work is proportional to the garbage rather than to the size of the db.
+// Unlike Merge, the live entries are rewritten into the active file, and the
+// files holding list or sorted set entries are skipped, as rewriting them out of
+// order would change the lists and the scores.
+// It returns ErrMergeFileCount if no file is chosen.
+func (db *DB) MergeWithOptions(opts MergeOptions) error {
+	return db.MergeWithContext(context.Background(), opts)
+}
+
+// MergeWithContext merges like MergeWithOptions, stopping at the next file
+// boundary when ctx is done and returning ctx.Err(), the files merged so far
+// staying merged.
+func (db *DB) MergeWithContext(ctx context.Context, opts MergeOptions) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if db.opt.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if err := db.checkClosed(); err != nil {
+		return err
+	}
+
+	fIDs, err := db.dirtiestFiles(opts.MaxFiles, opts.MinDirtyRatio)
+	if err != nil {
+		return err
+	}
+
+	if len(fIDs) == 0 {
+		return ErrMergeFileCount
+	}
+
+	if err := db.startMerge(); err != nil {
+		return err
+	}
+	defer db.endMerge()
+
+	return db.mergeFiles(ctx, fIDs, true, opts.Parallelism, opts.OnProgress)
+}
+
+// startMerge marks a merge as running, or returns ErrMergeInProgress if one
+// already is, as two merges would rewrite the same files.
+func (db *DB) startMerge() error {
+	if !atomic.CompareAndSwapInt32(&db.merging, 0, 1) {
+		return ErrMergeInProgress
+	}
+	db.isMerging = true
+
+	return nil
+}
+
+// endMerge marks the merge started by startMerge as done.
+func (db *DB) endMerge() {
+	db.isMerging = false
+	atomic.StoreInt32(&db.merging, 0)
+}
+
+// getPendingLiveMergeEntries appends the entry at off of the data file fID to
+// pendingMergeEntries if it is live. A BPTree entry is live only if the index
+// points to it, as the newer entries of its key may be in files not merged yet
+// or, after a crash mid-merge, in the segments already rewritten.
+func (db *DB) getPendingLiveMergeEntries(entry *Entry, fID int64, off int64, pendingMergeEntries []*Entry) []*Entry {
+	if entry.Meta.ds != DataStructureBPTree {
+		return db.getPendingMergeEntries(entry, pendingMergeEntries)
+	}
+
+	t, ok := db.bptreeIdx(string(entry.Meta.bucket))
+	if !ok {
+		return pendingMergeEntries
+	}
+
+	r, err := t.Find(entry.Key)
+	if err != nil || r.H.fileID != fID || r.H.dataPos != uint64(off) || r.H.meta.Flag != DataSetFlag {
+		return pendingMergeEntries
+	}
+
+	return append(pendingMergeEntries, entry)
+}
+
+// keepOnCompaction returns if the entry is kept by the CompactionFilter of the options.
+func (db *DB) keepOnCompaction(entry *Entry) bool {
+	if db.opt.CompactionFilter == nil || entry.Meta.ds != DataStructureBPTree {
+		return true
+	}
+
+	return db.opt.CompactionFilter(string(entry.Meta.bucket), entry)
+}
+
+// getCompactionTombstone appends the deletion of the key of the entry at off of the data
+// file fID, dropped by the CompactionFilter, to pendingMergeEntries if the index points to
+// the entry. Otherwise the entry is not live and dropping it is enough.
+func (db *DB) getCompactionTombstone(entry *Entry, fID int64, off int64, pendingMergeEntries []*Entry) []*Entry {
+	t, ok := db.bptreeIdx(string(entry.Meta.bucket))
+	if !ok {
+		return pendingMergeEntries
+	}
+
+	r, err := t.Find(entry.Key)
+	if err != nil || r.H.fileID != fID || r.H.dataPos != uint64(off) || r.H.meta.Flag != DataSetFlag {
+		return pendingMergeEntries
+	}
+
+	return append(pendingMergeEntries, &Entry{
+		Key: entry.Key,
+		Meta: &MetaData{
+			Flag:      DataDeleteFlag,
+			TTL:       Persistent,
+			timestamp: entry.Meta.timestamp,
+			bucket:    entry.Meta.bucket,
+			ds:        DataStructureBPTree,
+			siteID:    entry.Meta.siteID,
+			seq:       entry.Meta.seq,
+		},
+	})
+}
+
+// putEntries puts the merged entries, keeping their stamps and site IDs.
+func (tx *Tx) putEntries(entries []*Entry) error {
+	for _, e := range entries {
+		if err := tx.putKeeping(string(e.Meta.bucket), e, e.Meta.ds); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putKeeping puts the entry in the bucket, keeping its timestamp, its seq and its site ID.
+// The stamps are only kept by the transactions of a merge, a sync or a repair, see stampWrites.
+func (tx *Tx) putKeeping(bucket string, e *Entry, ds uint16) error {
+	if err := tx.put(bucket, e.Key, e.Value, e.Meta.TTL, e.Meta.Flag, e.Meta.timestamp, ds); err != nil {
+		return err
+	}
+
+	meta := tx.pendingWrites[len(tx.pendingWrites)-1].Meta
+	meta.siteID, meta.seq = e.Meta.siteID, e.Meta.seq
+
+	return nil
+}
+
+// mergeScan represents the entries of a data file to rewrite, read by scanMergeFile.
+type mergeScan struct {
+	fID       int
+	entries   []*Entry
+	positions []indexPosition // the position the index had for the key of every entry
+}
+
+// indexPosition represents the position of the entry of a key the index points to.
+type indexPosition struct {
+	fileID  int64
+	dataPos uint64
+	ok      bool
+}
+
+// indexPosition returns the position the index has for the key of the BPTree entry e,
+// not ok for the other data structures. The caller holds db.mu.
+func (db *DB) indexPosition(e *Entry) indexPosition {
+	if e.Meta.ds != DataStructureBPTree {
+		return indexPosition{}
+	}
+
+	t, ok := db.bptreeIdx(string(e.Meta.bucket))
+	if !ok {
+		return indexPosition{}
+	}
+
+	r, err := t.Find(e.Key)
+	if err != nil {
+		return indexPosition{}
+	}
+
+	return indexPosition{fileID: r.H.fileID, dataPos: r.H.dataPos, ok: true}
+}
+
+// liveEntries returns the entries of the scan whose key the index still points to at
+// the position it had when they were read, dropping the keys written since.
+// The caller holds db.mu.
+func (s *mergeScan) liveEntries(db *DB) []*Entry {
+	live := make([]*Entry, 0, len(s.entries))
+	for i, e := range s.entries {
+		if p := s.positions[i]; p.ok && db.indexPosition(e) != p {
+			continue
+		}
+		live = append(live, e)
+	}
+
+	return live
+}
+
+// mergeScanner reads the data files of a merge ahead of their rewrites, see
+// MergeOptions.Parallelism. A file takes a slot from its read to the end of its
+// rewrite, so that at most parallelism files are held in memory.
+type mergeScanner struct {
+	results []chan mergeScanResult
+	slots   chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// mergeScanResult represents the result of scanMergeFile.
+type mergeScanResult struct {
+	s   *mergeScan
+	err error
+}
+
+// scanMergeFiles starts reading the data files fIDs, in order, with up to parallelism
+// files at a time. The scanner must be stopped.
+func (db *DB) scanMergeFiles(fIDs []int, parallelism int) *mergeScanner {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	sc := &mergeScanner{
+		results: make([]chan mergeScanResult, len(fIDs)),
+		slots:   make(chan struct{}, parallelism),
+		done:    make(chan struct{}),
+	}
+	for i := range sc.results {
+		sc.results[i] = make(chan mergeScanResult, 1)
+	}
+
+	sc.wg.Add(1)
+	go func() {
+		defer sc.wg.Done()
+
+		for i, fID := range fIDs {
+			select {
+			case sc.slots <- struct{}{}:
+			case <-sc.done:
+				return
+			}
+
+			sc.wg.Add(1)
+			go func(i, fID int) {
+				defer sc.wg.Done()
+
+				s, err := db.scanMergeFile(fID)
+				sc.results[i] <- mergeScanResult{s: s, err: err}
+			}(i, fID)
+		}
+	}()
+
+	return sc
+}
+
+// next returns the entries of the i-th file, waiting for its read.
+func (sc *mergeScanner) next(i int) (*mergeScan, error) {
+	r := <-sc.results[i]
+
+	return r.s, r.err
+}
+
+// release releases the slot of the file returned by next, once rewritten.
+func (sc *mergeScanner) release() {
+	<-sc.slots
+}
+
+// stop stops reading the files, waiting for the ones being read.
+func (sc *mergeScanner) stop() {
+	close(sc.done)
+	sc.wg.Wait()
+}
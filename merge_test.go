@@ -0,0 +1,474 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDB_MergeWorst(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestmergeworst", true)
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 1024
+
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		put := func(i, version int) {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%080d", i, version)), Persistent)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		latest := make(map[int]int)
+		for i := 0; i < 20; i++ {
+			put(i, 0)
+			latest[i] = 0
+		}
+		// overwrite some keys, so the older files hold the dirty and the live versions.
+		for i := 0; i < 20; i += 3 {
+			put(i, 1)
+			latest[i] = 1
+		}
+
+		// HintKeyAndRAMIdxMode does not support lists.
+		withList := mode == HintKeyValAndRAMIdxMode
+		if withList {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.RPush("list", []byte("key"), []byte("a"))
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		before, err := db.FileStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.MergeWorst(1); err != nil {
+			t.Fatal(err)
+		}
+
+		after, err := db.FileStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		removed := 0
+		for _, b := range before {
+			found := false
+			for _, a := range after {
+				found = found || a.FileID == b.FileID
+			}
+			if !found {
+				removed++
+				if b.DeadEntries == 0 {
+					t.Errorf("mode %d: merged the clean file %d", mode, b.FileID)
+				}
+			}
+		}
+		if removed != 1 {
+			t.Fatalf("mode %d: expected one file to be merged, got %d", mode, removed)
+		}
+
+		check := func() {
+			if err := db.View(func(tx *Tx) error {
+				for i, version := range latest {
+					e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+					if err != nil {
+						return err
+					}
+					if want := fmt.Sprintf("val_%d_%080d", i, version); string(e.Value) != want {
+						t.Errorf("mode %d: key_%d: got %s, want %s", mode, i, e.Value, want)
+					}
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		check()
+
+		if err := db.MergeWithOptions(MergeOptions{MinDirtyRatio: 1.1}); err != ErrMergeFileCount {
+			t.Fatalf("mode %d: expected ErrMergeFileCount, got %v", mode, err)
+		}
+
+		if err := db.MergeWithOptions(MergeOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		check()
+
+		if h := db.Health(); h.Merging {
+			t.Fatal("expected the merge to be done")
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check()
+
+		if err := db.View(func(tx *Tx) error {
+			if !withList {
+				return nil
+			}
+			items, err := tx.LRange("list", []byte("key"), 0, -1)
+			if err == nil && len(items) != 1 {
+				t.Errorf("mode %d: expected the list to be kept, got %d items", mode, len(items))
+			}
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_MergeContext(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmergecontext", true)
+	opt.SegmentSize = 1024
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 40; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), make([]byte, 100), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var progress []MergeProgress
+	err := db.MergeWithContext(ctx, MergeOptions{OnProgress: func(p MergeProgress) {
+		progress = append(progress, p)
+		cancel()
+	}})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if len(progress) != 1 || progress[0].FilesDone != 1 || progress[0].FilesTotal < 2 || progress[0].BytesReclaimed <= 0 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+
+	if h := db.Health(); h.Merging {
+		t.Fatal("expected the cancelled merge to be done")
+	}
+
+	progress = nil
+	if err := db.MergeContext(context.Background(), func(p MergeProgress) {
+		progress = append(progress, p)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(progress) == 0 || progress[len(progress)-1].FilesDone != progress[len(progress)-1].FilesTotal {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get("bucket", []byte("key"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_MergeCompactionFilter(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcompactionfilter", true)
+	opt.SegmentSize = 1024
+	var filtered int
+	opt.CompactionFilter = func(bucket string, e *Entry) bool {
+		if bucket == "users" && strings.HasPrefix(string(e.Key), "user1:") {
+			filtered++
+			return false
+		}
+		return true
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for version := 0; version < 2; version++ {
+		for i := 0; i < 10; i++ {
+			for _, user := range []string{"user1", "user2"} {
+				key := []byte(fmt.Sprintf("%s:%d", user, i))
+				if err := db.Update(func(tx *Tx) error {
+					return tx.Put("users", key, []byte(fmt.Sprintf("%080d", version)), Persistent)
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if filtered == 0 {
+		t.Error("TestDB_MergeCompactionFilter err: the filter was not called")
+	}
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				if _, err := tx.Get("users", []byte(fmt.Sprintf("user1:%d", i))); err == nil {
+					t.Errorf("TestDB_MergeCompactionFilter err: user1:%d not purged", i)
+				}
+				if _, err := tx.Get("users", []byte(fmt.Sprintf("user2:%d", i))); err != nil {
+					t.Errorf("TestDB_MergeCompactionFilter err: user2:%d: %v", i, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_MergeParallelism(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmergeparallelism", true)
+	opt.SegmentSize = 1024
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	put := func(i, version int) {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%080d", i, version)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest := make(map[int]int)
+	for i := 0; i < 40; i++ {
+		put(i, 0)
+		latest[i] = 0
+	}
+	for i := 0; i < 40; i += 2 {
+		put(i, 1)
+		latest[i] = 1
+	}
+
+	files := 0
+	err := db.MergeWithOptions(MergeOptions{
+		Parallelism: 4,
+		OnProgress: func(p MergeProgress) {
+			files = p.FilesDone
+			if p.FilesDone > 1 {
+				return
+			}
+			// the files read ahead hold the entries overwritten here.
+			for i := 1; i < 40; i += 2 {
+				put(i, 2)
+				latest[i] = 2
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files < 4 {
+		t.Fatalf("expected at least 4 files to be merged, got %d", files)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		for i, version := range latest {
+			e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+			if err != nil {
+				return err
+			}
+			if want := fmt.Sprintf("val_%d_%080d", i, version); string(e.Value) != want {
+				t.Errorf("key_%d: got %s, want %s", i, e.Value, want)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_MergeCrash(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestmergecrash", true)
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 1024
+
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for version := 0; version < 5; version++ {
+			for i := 0; i < 10; i++ {
+				if err := db.Update(func(tx *Tx) error {
+					return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%080d", i, version)), Persistent)
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := db.CheckpointIndex(); err != nil {
+			t.Fatal(err)
+		}
+
+		// every step copies the files as a crash at that point would leave them,
+		// with the MaxFileID expected after reopening the copy.
+		type crash struct {
+			step       mergeStep
+			dir        string
+			maxFileID  int64
+			maxFileIDs []int
+		}
+		var crashes []crash
+		snapshots := t.TempDir()
+
+		defer func(hook func(mergeStep)) { afterMergeStep = hook }(afterMergeStep)
+		afterMergeStep = func(step mergeStep) {
+			c := crash{step: step, dir: fmt.Sprintf("%s/%d", snapshots, len(crashes)), maxFileID: db.MaxFileID}
+			if step == mergeStepActiveSealed {
+				c.maxFileID--
+			}
+			if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			files, err := ioutil.ReadDir(opt.Dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				if f.IsDir() {
+					continue
+				}
+				buf, err := ioutil.ReadFile(opt.Dir + "/" + f.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(c.dir+"/"+f.Name(), buf, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			crashes = append(crashes, c)
+		}
+
+		if err := db.Merge(); err != nil {
+			t.Fatal(err)
+		}
+		afterMergeStep = func(mergeStep) {}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		steps := make(map[mergeStep]bool)
+		for _, c := range crashes {
+			steps[c.step] = true
+
+			crashOpt := opt
+			crashOpt.Dir = c.dir
+			crashDB, err := Open(crashOpt)
+			if err != nil {
+				t.Fatalf("mode %d, crash after %s: %v", mode, c.step, err)
+			}
+
+			if crashDB.MaxFileID != c.maxFileID {
+				t.Errorf("mode %d, crash after %s: got MaxFileID %d want %d", mode, c.step, crashDB.MaxFileID, c.maxFileID)
+			}
+
+			err = crashDB.View(func(tx *Tx) error {
+				for i := 0; i < 10; i++ {
+					e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+					if err != nil {
+						return err
+					}
+					if want := fmt.Sprintf("val_%d_%080d", i, 4); string(e.Value) != want {
+						t.Errorf("mode %d, crash after %s: got %s want %s", mode, c.step, e.Value, want)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("mode %d, crash after %s: %v", mode, c.step, err)
+			}
+
+			files, _ := ioutil.ReadDir(c.dir)
+			for _, f := range files {
+				if strings.HasSuffix(f.Name(), TempSuffix) {
+					t.Errorf("mode %d, crash after %s: temporary file %s left", mode, c.step, f.Name())
+				}
+			}
+
+			crashDB.Close()
+		}
+
+		for _, step := range []mergeStep{
+			mergeStepSegmentCreated, mergeStepActiveSealed, mergeStepSegmentPublished, mergeStepCommitted,
+			mergeStepLastTxIDPersisted, mergeStepFileRemoved, mergeStepCheckpointRemoved, mergeStepHintRemoved,
+		} {
+			if !steps[step] {
+				t.Errorf("mode %d: no crash after %s", mode, step)
+			}
+		}
+	}
+}
@@ -0,0 +1,478 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mobile provides a facade of nutsdb restricted to the types supported by
+// gomobile bind, so that nutsdb can be used as the local store of Android and iOS apps:
+//
+//	gomobile bind -target=android github.com/xujiajun/nutsdb/mobile
+//
+// The keys and values are byte slices, the integers int or int64, and the results
+// of the scans are returned as KeyValues or ByteList read by index. A missing key
+// or bucket is returned as an error matched by IsNotFound.
+package mobile
+
+import (
+	"errors"
+	"math"
+
+	"github.com/xujiajun/nutsdb"
+	"github.com/xujiajun/nutsdb/ds/list"
+)
+
+// The index modes of Options.EntryIdxMode, see nutsdb.EntryIdxMode.
+const (
+	HintKeyValAndRAMIdxMode = int(nutsdb.HintKeyValAndRAMIdxMode)
+	HintKeyAndRAMIdxMode    = int(nutsdb.HintKeyAndRAMIdxMode)
+	HintBPTSparseIdxMode    = int(nutsdb.HintBPTSparseIdxMode)
+)
+
+// ErrInvalidTTL is returned when a TTL is negative or does not fit in 32 bits.
+var ErrInvalidTTL = errors.New("invalid ttl")
+
+// Options represents the options of Open, a subset of nutsdb.Options.
+type Options struct {
+	// Dir represents the dir of the database, e.g. in the files dir of the app.
+	Dir string
+
+	// EntryIdxMode represents the index mode, one of the IdxMode constants.
+	EntryIdxMode int
+
+	// SegmentSize represents the size in bytes of the data files.
+	SegmentSize int64
+
+	// SyncEnable represents if the commits are synced to the disk.
+	SyncEnable bool
+
+	// ReadOnly represents if the database is opened read-only.
+	ReadOnly bool
+}
+
+// NewOptions returns the options of the database at dir, with the values of nutsdb.DefaultOptions.
+func NewOptions(dir string) *Options {
+	return &Options{
+		Dir:          dir,
+		EntryIdxMode: int(nutsdb.DefaultOptions.EntryIdxMode),
+		SegmentSize:  nutsdb.DefaultOptions.SegmentSize,
+		SyncEnable:   nutsdb.DefaultOptions.SyncEnable,
+	}
+}
+
+// DB represents an open database.
+type DB struct {
+	db *nutsdb.DB
+}
+
+// Open opens the database with the given options.
+func Open(opts *Options) (*DB, error) {
+	opt := nutsdb.DefaultOptions
+	opt.Dir = opts.Dir
+	opt.EntryIdxMode = nutsdb.EntryIdxMode(opts.EntryIdxMode)
+	opt.SegmentSize = opts.SegmentSize
+	opt.SyncEnable = opts.SyncEnable
+	opt.ReadOnly = opts.ReadOnly
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{db: db}, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Merge merges the data files, see nutsdb.DB.Merge.
+func (d *DB) Merge() error {
+	return d.db.Merge()
+}
+
+// Backup copies the database to the dir, see nutsdb.DB.Backup.
+func (d *DB) Backup(dir string) error {
+	return d.db.Backup(dir)
+}
+
+// TxFunc represents the function run in a transaction by Update or View,
+// implemented by the apps in Java or Objective-C.
+type TxFunc interface {
+	Run(tx *Tx) error
+}
+
+// txFunc adapts a func to TxFunc.
+type txFunc func(tx *Tx) error
+
+// Run calls f.
+func (f txFunc) Run(tx *Tx) error {
+	return f(tx)
+}
+
+// Update runs fn in a read-write transaction, committed if fn returns nil.
+func (d *DB) Update(fn TxFunc) error {
+	return d.db.Update(func(tx *nutsdb.Tx) error {
+		return fn.Run(&Tx{tx: tx})
+	})
+}
+
+// View runs fn in a read-only transaction.
+func (d *DB) View(fn TxFunc) error {
+	return d.db.View(func(tx *nutsdb.Tx) error {
+		return fn.Run(&Tx{tx: tx})
+	})
+}
+
+// Put sets the value of the key in the bucket, expiring after ttl seconds if not 0.
+func (d *DB) Put(bucket string, key, value []byte, ttl int64) error {
+	return d.Update(txFunc(func(tx *Tx) error {
+		return tx.Put(bucket, key, value, ttl)
+	}))
+}
+
+// Get returns the value of the key in the bucket.
+func (d *DB) Get(bucket string, key []byte) (value []byte, err error) {
+	err = d.View(txFunc(func(tx *Tx) error {
+		value, err = tx.Get(bucket, key)
+		return err
+	}))
+
+	return value, err
+}
+
+// Has returns if the key is in the bucket.
+func (d *DB) Has(bucket string, key []byte) (ok bool, err error) {
+	err = d.View(txFunc(func(tx *Tx) error {
+		ok, err = tx.Has(bucket, key)
+		return err
+	}))
+
+	return ok, err
+}
+
+// Delete deletes the key in the bucket.
+func (d *DB) Delete(bucket string, key []byte) error {
+	return d.Update(txFunc(func(tx *Tx) error {
+		return tx.Delete(bucket, key)
+	}))
+}
+
+// PrefixScan returns up to limit keys of the bucket starting with prefix and their values.
+func (d *DB) PrefixScan(bucket string, prefix []byte, limit int) (kvs *KeyValues, err error) {
+	err = d.View(txFunc(func(tx *Tx) error {
+		kvs, err = tx.PrefixScan(bucket, prefix, limit)
+		return err
+	}))
+
+	return kvs, err
+}
+
+// RangeScan returns the keys of the bucket between start and end, inclusive, and their values.
+func (d *DB) RangeScan(bucket string, start, end []byte) (kvs *KeyValues, err error) {
+	err = d.View(txFunc(func(tx *Tx) error {
+		kvs, err = tx.RangeScan(bucket, start, end)
+		return err
+	}))
+
+	return kvs, err
+}
+
+// RPush appends the value to the list of the key in the bucket.
+func (d *DB) RPush(bucket string, key, value []byte) error {
+	return d.Update(txFunc(func(tx *Tx) error {
+		return tx.RPush(bucket, key, value)
+	}))
+}
+
+// LPop removes and returns the first value of the list of the key in the bucket.
+func (d *DB) LPop(bucket string, key []byte) (value []byte, err error) {
+	err = d.Update(txFunc(func(tx *Tx) error {
+		value, err = tx.LPop(bucket, key)
+		return err
+	}))
+
+	return value, err
+}
+
+// LRange returns the values of the list of the key in the bucket between start and end,
+// inclusive, negative indexes counting from the end.
+func (d *DB) LRange(bucket string, key []byte, start, end int) (values *ByteList, err error) {
+	err = d.View(txFunc(func(tx *Tx) error {
+		values, err = tx.LRange(bucket, key, start, end)
+		return err
+	}))
+
+	return values, err
+}
+
+// SAdd adds the member to the set of the key in the bucket.
+func (d *DB) SAdd(bucket string, key, member []byte) error {
+	return d.Update(txFunc(func(tx *Tx) error {
+		return tx.SAdd(bucket, key, member)
+	}))
+}
+
+// SRem removes the member from the set of the key in the bucket.
+func (d *DB) SRem(bucket string, key, member []byte) error {
+	return d.Update(txFunc(func(tx *Tx) error {
+		return tx.SRem(bucket, key, member)
+	}))
+}
+
+// SIsMember returns if the member is in the set of the key in the bucket.
+func (d *DB) SIsMember(bucket string, key, member []byte) (ok bool, err error) {
+	err = d.View(txFunc(func(tx *Tx) error {
+		ok, err = tx.SIsMember(bucket, key, member)
+		return err
+	}))
+
+	return ok, err
+}
+
+// SMembers returns the members of the set of the key in the bucket.
+func (d *DB) SMembers(bucket string, key []byte) (members *ByteList, err error) {
+	err = d.View(txFunc(func(tx *Tx) error {
+		members, err = tx.SMembers(bucket, key)
+		return err
+	}))
+
+	return members, err
+}
+
+// ZAdd sets the score and value of the member of the sorted set of the bucket.
+func (d *DB) ZAdd(bucket string, member []byte, score float64, value []byte) error {
+	return d.Update(txFunc(func(tx *Tx) error {
+		return tx.ZAdd(bucket, member, score, value)
+	}))
+}
+
+// ZScore returns the score of the member of the sorted set of the bucket.
+func (d *DB) ZScore(bucket string, member []byte) (score float64, err error) {
+	err = d.View(txFunc(func(tx *Tx) error {
+		score, err = tx.ZScore(bucket, member)
+		return err
+	}))
+
+	return score, err
+}
+
+// ZRem removes the member of the sorted set of the bucket.
+func (d *DB) ZRem(bucket string, member []byte) error {
+	return d.Update(txFunc(func(tx *Tx) error {
+		return tx.ZRem(bucket, member)
+	}))
+}
+
+// Tx represents a transaction run by Update or View, valid until fn returns.
+type Tx struct {
+	tx *nutsdb.Tx
+}
+
+// Put sets the value of the key in the bucket, expiring after ttl seconds if not 0.
+func (tx *Tx) Put(bucket string, key, value []byte, ttl int64) error {
+	if ttl < 0 || ttl > math.MaxUint32 {
+		return ErrInvalidTTL
+	}
+
+	return tx.tx.Put(bucket, key, value, uint32(ttl))
+}
+
+// Get returns a copy of the value of the key in the bucket.
+func (tx *Tx) Get(bucket string, key []byte) ([]byte, error) {
+	e, err := tx.tx.Get(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return clone(e.Value), nil
+}
+
+// Has returns if the key is in the bucket.
+func (tx *Tx) Has(bucket string, key []byte) (bool, error) {
+	_, err := tx.tx.Get(bucket, key)
+	if IsNotFound(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Delete deletes the key in the bucket.
+func (tx *Tx) Delete(bucket string, key []byte) error {
+	return tx.tx.Delete(bucket, key)
+}
+
+// PrefixScan returns up to limit keys of the bucket starting with prefix and their values.
+func (tx *Tx) PrefixScan(bucket string, prefix []byte, limit int) (*KeyValues, error) {
+	es, err := tx.tx.PrefixScan(bucket, prefix, limit)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+
+	return newKeyValues(es), nil
+}
+
+// RangeScan returns the keys of the bucket between start and end, inclusive, and their values.
+func (tx *Tx) RangeScan(bucket string, start, end []byte) (*KeyValues, error) {
+	es, err := tx.tx.RangeScan(bucket, start, end)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+
+	return newKeyValues(es), nil
+}
+
+// RPush appends the value to the list of the key in the bucket.
+func (tx *Tx) RPush(bucket string, key, value []byte) error {
+	return tx.tx.RPush(bucket, key, value)
+}
+
+// LPop removes and returns the first value of the list of the key in the bucket.
+func (tx *Tx) LPop(bucket string, key []byte) ([]byte, error) {
+	value, err := tx.tx.LPop(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return clone(value), nil
+}
+
+// LRange returns the values of the list of the key in the bucket between start and end,
+// inclusive, negative indexes counting from the end.
+func (tx *Tx) LRange(bucket string, key []byte, start, end int) (*ByteList, error) {
+	values, err := tx.tx.LRange(bucket, key, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return newByteList(values), nil
+}
+
+// SAdd adds the member to the set of the key in the bucket.
+func (tx *Tx) SAdd(bucket string, key, member []byte) error {
+	return tx.tx.SAdd(bucket, key, member)
+}
+
+// SRem removes the member from the set of the key in the bucket.
+func (tx *Tx) SRem(bucket string, key, member []byte) error {
+	return tx.tx.SRem(bucket, key, member)
+}
+
+// SIsMember returns if the member is in the set of the key in the bucket.
+func (tx *Tx) SIsMember(bucket string, key, member []byte) (bool, error) {
+	return tx.tx.SIsMember(bucket, key, member)
+}
+
+// SMembers returns the members of the set of the key in the bucket.
+func (tx *Tx) SMembers(bucket string, key []byte) (*ByteList, error) {
+	members, err := tx.tx.SMembers(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return newByteList(members), nil
+}
+
+// ZAdd sets the score and value of the member of the sorted set of the bucket.
+func (tx *Tx) ZAdd(bucket string, member []byte, score float64, value []byte) error {
+	return tx.tx.ZAdd(bucket, member, score, value)
+}
+
+// ZScore returns the score of the member of the sorted set of the bucket.
+func (tx *Tx) ZScore(bucket string, member []byte) (float64, error) {
+	return tx.tx.ZScore(bucket, member)
+}
+
+// ZRem removes the member of the sorted set of the bucket.
+func (tx *Tx) ZRem(bucket string, member []byte) error {
+	return tx.tx.ZRem(bucket, string(member))
+}
+
+// KeyValues represents the keys and values returned by a scan.
+type KeyValues struct {
+	keys   [][]byte
+	values [][]byte
+}
+
+// newKeyValues returns the KeyValues of copies of the keys and values of es.
+func newKeyValues(es nutsdb.Entries) *KeyValues {
+	kvs := &KeyValues{
+		keys:   make([][]byte, len(es)),
+		values: make([][]byte, len(es)),
+	}
+	for i, e := range es {
+		kvs.keys[i] = clone(e.Key)
+		kvs.values[i] = clone(e.Value)
+	}
+
+	return kvs
+}
+
+// Len returns the number of keys.
+func (kvs *KeyValues) Len() int {
+	return len(kvs.keys)
+}
+
+// Key returns the key at index i.
+func (kvs *KeyValues) Key(i int) []byte {
+	return kvs.keys[i]
+}
+
+// Value returns the value at index i.
+func (kvs *KeyValues) Value(i int) []byte {
+	return kvs.values[i]
+}
+
+// ByteList represents the values returned by a list or set read.
+type ByteList struct {
+	items [][]byte
+}
+
+// newByteList returns the ByteList of copies of items.
+func newByteList(items [][]byte) *ByteList {
+	l := &ByteList{items: make([][]byte, len(items))}
+	for i, item := range items {
+		l.items[i] = clone(item)
+	}
+
+	return l
+}
+
+// Len returns the number of values.
+func (l *ByteList) Len() int {
+	return len(l.items)
+}
+
+// Get returns the value at index i.
+func (l *ByteList) Get(i int) []byte {
+	return l.items[i]
+}
+
+// IsNotFound returns if err is caused by a missing key or bucket, or an empty result.
+func IsNotFound(err error) bool {
+	return errors.Is(err, nutsdb.ErrKeyNotFound) ||
+		errors.Is(err, nutsdb.ErrBucketNotFound) ||
+		errors.Is(err, nutsdb.ErrBucketEmpty) ||
+		errors.Is(err, nutsdb.ErrPrefixScan) ||
+		errors.Is(err, nutsdb.ErrRangeScan) ||
+		errors.Is(err, list.ErrListNotFound)
+}
+
+// clone returns a copy of b, the values read are only valid during the transaction.
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mobile
+
+import (
+	"errors"
+	"testing"
+)
+
+func openDB(t *testing.T) *DB {
+	opts := NewOptions(t.TempDir())
+	opts.SegmentSize = 8 * 1024
+
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// putAll is a TxFunc writing two keys, as implemented by an app.
+type putAll struct {
+	fail bool
+}
+
+func (p *putAll) Run(tx *Tx) error {
+	if err := tx.Put("bucket", []byte("k1"), []byte("v1"), 0); err != nil {
+		return err
+	}
+	if err := tx.Put("bucket", []byte("k2"), []byte("v2"), 0); err != nil {
+		return err
+	}
+	if p.fail {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func TestDB_KeyValue(t *testing.T) {
+	db := openDB(t)
+
+	if _, err := db.Get("bucket", []byte("k1")); !IsNotFound(err) {
+		t.Errorf("err Get of a missing bucket. got %v", err)
+	}
+
+	if err := db.Update(&putAll{fail: true}); err == nil {
+		t.Fatal("expected the error of the TxFunc")
+	}
+	if ok, err := db.Has("bucket", []byte("k1")); ok || err != nil {
+		t.Errorf("expected the failed transaction rolled back, got %v %v", ok, err)
+	}
+
+	if err := db.Update(&putAll{}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("bucket", []byte("k2")); err != nil || string(value) != "v2" {
+		t.Errorf("err Get. got %q %v", value, err)
+	}
+
+	if err := db.Put("bucket", []byte("k3"), []byte("v3"), -1); err != ErrInvalidTTL {
+		t.Errorf("err Put with a negative ttl. got %v want %v", err, ErrInvalidTTL)
+	}
+
+	kvs, err := db.PrefixScan("bucket", []byte("k"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kvs.Len() != 2 || string(kvs.Key(0)) != "k1" || string(kvs.Value(1)) != "v2" {
+		t.Errorf("err PrefixScan. got %d keys", kvs.Len())
+	}
+	if kvs, err := db.PrefixScan("bucket", []byte("x"), 10); err != nil || kvs.Len() != 0 {
+		t.Errorf("expected an empty PrefixScan, got %v", err)
+	}
+
+	if err := db.Delete("bucket", []byte("k1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("bucket", []byte("k1")); !IsNotFound(err) {
+		t.Errorf("err Get of a deleted key. got %v", err)
+	}
+}
+
+func TestDB_DataStructures(t *testing.T) {
+	db := openDB(t)
+
+	for _, v := range []string{"a", "b", "c"} {
+		if err := db.RPush("list", []byte("l"), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, err := db.LPop("list", []byte("l")); err != nil || string(value) != "a" {
+		t.Errorf("err LPop. got %q %v", value, err)
+	}
+	values, err := db.LRange("list", []byte("l"), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.Len() != 2 || string(values.Get(0)) != "b" || string(values.Get(1)) != "c" {
+		t.Errorf("err LRange. got %d values", values.Len())
+	}
+
+	if err := db.SAdd("set", []byte("s"), []byte("m")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.SIsMember("set", []byte("s"), []byte("m")); !ok || err != nil {
+		t.Errorf("err SIsMember. got %v %v", ok, err)
+	}
+	if members, err := db.SMembers("set", []byte("s")); err != nil || members.Len() != 1 {
+		t.Errorf("err SMembers. got %v", err)
+	}
+
+	if err := db.ZAdd("zset", []byte("z"), 1.5, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if score, err := db.ZScore("zset", []byte("z")); score != 1.5 || err != nil {
+		t.Errorf("err ZScore. got %v %v", score, err)
+	}
+	if err := db.ZRem("zset", []byte("z")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZScore("zset", []byte("z")); !IsNotFound(err) {
+		t.Errorf("err ZScore of a removed member. got %v", err)
+	}
+}
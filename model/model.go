@@ -0,0 +1,280 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package model persists tagged structs in nutsdb buckets.
+//
+// A struct field tagged `nutsdb:"key"` is used as the primary key, and every
+// field tagged `nutsdb:"index"` is maintained in a secondary index bucket
+// so records can be found by that field:
+//
+//	type User struct {
+//		ID    string `nutsdb:"key"`
+//		Email string `nutsdb:"index"`
+//	}
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+const (
+	// TagName is the struct tag name read by the Store.
+	TagName = "nutsdb"
+
+	// TagKey marks the primary key field.
+	TagKey = "key"
+
+	// TagIndex marks a secondary indexed field.
+	TagIndex = "index"
+
+	// IndexBucketSeparator separates the type name and the field name of an index bucket.
+	IndexBucketSeparator = "_idx_"
+
+	// indexValueSizeLen is the size of the length of the field value prefixing an index key.
+	indexValueSizeLen = 4
+)
+
+var (
+	// ErrNotStructPtr is returned when the given value is not a pointer to a struct.
+	ErrNotStructPtr = errors.New("model: value must be a pointer to a struct")
+
+	// ErrNotSlicePtr is returned when the Find result is not a pointer to a slice of structs.
+	ErrNotSlicePtr = errors.New("model: result must be a pointer to a slice of structs")
+
+	// ErrNoKeyField is returned when the struct has no field tagged `nutsdb:"key"`.
+	ErrNoKeyField = errors.New("model: struct has no `nutsdb:\"key\"` field")
+
+	// ErrNotIndexed is returned when Find is called with a field not tagged `nutsdb:"index"`.
+	ErrNotIndexed = errors.New("model: field is not indexed")
+)
+
+// Store saves and finds tagged structs.
+type Store struct {
+	codec nutsdb.Codec
+}
+
+// New returns a newly initialized Store at given codec.
+// If codec is nil, nutsdb.JSONCodec is used.
+func New(codec nutsdb.Codec) *Store {
+	if codec == nil {
+		codec = nutsdb.JSONCodec{}
+	}
+
+	return &Store{codec: codec}
+}
+
+// schema records the bucket and tagged fields of a struct type.
+type schema struct {
+	bucket  string
+	key     int
+	indexes map[string]int
+}
+
+func parseSchema(t reflect.Type) (*schema, error) {
+	s := &schema{bucket: t.Name(), key: -1, indexes: make(map[string]int)}
+
+	for i := 0; i < t.NumField(); i++ {
+		switch t.Field(i).Tag.Get(TagName) {
+		case TagKey:
+			s.key = i
+		case TagIndex:
+			s.indexes[t.Field(i).Name] = i
+		}
+	}
+
+	if s.key == -1 {
+		return nil, ErrNoKeyField
+	}
+
+	return s, nil
+}
+
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, ErrNotStructPtr
+	}
+
+	return rv.Elem(), nil
+}
+
+// encodeField returns the bytes of a field value used in keys.
+func encodeField(v reflect.Value) []byte {
+	if b, ok := v.Interface().([]byte); ok {
+		return b
+	}
+
+	return []byte(fmt.Sprint(v.Interface()))
+}
+
+func indexBucket(s *schema, field string) string {
+	return s.bucket + IndexBucketSeparator + field
+}
+
+// indexKey returns the key of the index entry of the record at key whose field is value:
+// the length of value, big-endian, value and then key, so that the index keys of a value
+// share a prefix which the ones of no other value start with, whatever bytes they hold.
+func indexKey(value, key []byte) []byte {
+	buf := make([]byte, indexValueSizeLen+len(value)+len(key))
+	binary.BigEndian.PutUint32(buf, uint32(len(value)))
+	copy(buf[indexValueSizeLen:], value)
+	copy(buf[indexValueSizeLen+len(value):], key)
+
+	return buf
+}
+
+// Save persists v and updates its secondary indexes.
+func (s *Store) Save(tx *nutsdb.Tx, v interface{}) error {
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+
+	sc, err := parseSchema(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	key := encodeField(rv.Field(sc.key))
+
+	if err := s.removeIndexes(tx, sc, rv.Type(), key); err != nil {
+		return err
+	}
+
+	data, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Put(sc.bucket, key, data, nutsdb.Persistent); err != nil {
+		return err
+	}
+
+	for field, i := range sc.indexes {
+		if err := tx.Put(indexBucket(sc, field), indexKey(encodeField(rv.Field(i)), key), key, nutsdb.Persistent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeIndexes removes the index entries of the stored record at given key, if any.
+func (s *Store) removeIndexes(tx *nutsdb.Tx, sc *schema, t reflect.Type, key []byte) error {
+	old := reflect.New(t)
+	if err := s.get(tx, sc, key, old.Interface()); err != nil {
+		// no stored record, nothing to remove.
+		if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	for field, i := range sc.indexes {
+		if err := tx.Delete(indexBucket(sc, field), indexKey(encodeField(old.Elem().Field(i)), key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) get(tx *nutsdb.Tx, sc *schema, key []byte, v interface{}) error {
+	e, err := tx.Get(sc.bucket, key)
+	if err != nil {
+		return err
+	}
+
+	return s.codec.Unmarshal(e.Value, v)
+}
+
+// Get loads the record at given primary key into v.
+func (s *Store) Get(tx *nutsdb.Tx, key interface{}, v interface{}) error {
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+
+	sc, err := parseSchema(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	return s.get(tx, sc, encodeField(reflect.ValueOf(key)), v)
+}
+
+// Delete removes v and its secondary index entries.
+func (s *Store) Delete(tx *nutsdb.Tx, v interface{}) error {
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+
+	sc, err := parseSchema(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	key := encodeField(rv.Field(sc.key))
+
+	if err := s.removeIndexes(tx, sc, rv.Type(), key); err != nil {
+		return err
+	}
+
+	return tx.Delete(sc.bucket, key)
+}
+
+// Find loads into result (a pointer to a slice of structs) every record
+// whose indexed field equals value.
+func (s *Store) Find(tx *nutsdb.Tx, field string, value interface{}, result interface{}) error {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice || rv.Elem().Type().Elem().Kind() != reflect.Struct {
+		return ErrNotSlicePtr
+	}
+
+	elemType := rv.Elem().Type().Elem()
+	sc, err := parseSchema(elemType)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := sc.indexes[field]; !ok {
+		return ErrNotIndexed
+	}
+
+	slice := reflect.MakeSlice(rv.Elem().Type(), 0, 0)
+
+	prefix := indexKey(encodeField(reflect.ValueOf(value)), nil)
+	entries, err := tx.PrefixScan(indexBucket(sc, field), prefix, nutsdb.ScanNoLimit)
+	if err != nil && err != nutsdb.ErrPrefixScan {
+		return err
+	}
+
+	for _, e := range entries {
+		elem := reflect.New(elemType)
+		if err := s.get(tx, sc, e.Value, elem.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem.Elem())
+	}
+
+	rv.Elem().Set(slice)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"os"
+	"testing"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+type User struct {
+	ID   string `nutsdb:"key"`
+	City string `nutsdb:"index"`
+	Age  int
+}
+
+func openDB(t *testing.T) *nutsdb.DB {
+	fileDir := "/tmp/nutsdbtestmodel"
+	if err := os.RemoveAll(fileDir); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = fileDir
+	opt.SegmentSize = 8 * 1024
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestStore_SaveFind(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	s := New(nil)
+
+	users := []*User{
+		{ID: "1", City: "paris", Age: 20},
+		{ID: "2", City: "tokyo", Age: 30},
+		{ID: "3", City: "paris", Age: 40},
+	}
+
+	for _, u := range users {
+		if err := db.Update(func(tx *nutsdb.Tx) error {
+			return s.Save(tx, u)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var found []User
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		return s.Find(tx, "City", "paris", &found)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("err Find. got %d want 2", len(found))
+	}
+
+	// moving user 1 to tokyo updates the index
+	users[0].City = "tokyo"
+	if err := db.Update(func(tx *nutsdb.Tx) error {
+		return s.Save(tx, users[0])
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		return s.Find(tx, "City", "paris", &found)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ID != "3" {
+		t.Errorf("err Find after update. got %+v", found)
+	}
+
+	if err := db.Update(func(tx *nutsdb.Tx) error {
+		return s.Delete(tx, users[2])
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		var u User
+		if err := s.Get(tx, "2", &u); err != nil || u.Age != 30 {
+			t.Errorf("err Get. got %+v %v", u, err)
+		}
+		return s.Find(tx, "City", "paris", &found)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("err Find after delete. got %d want 0", len(found))
+	}
+}
+
+func TestStore_Err(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	s := New(nil)
+
+	err := db.View(func(tx *nutsdb.Tx) error {
+		var users []User
+		return s.Find(tx, "Age", 1, &users)
+	})
+	if err != ErrNotIndexed {
+		t.Errorf("err Find. got %v want %v", err, ErrNotIndexed)
+	}
+
+	err = db.Update(func(tx *nutsdb.Tx) error {
+		return s.Save(tx, User{ID: "1"})
+	})
+	if err != ErrNotStructPtr {
+		t.Errorf("err Save. got %v want %v", err, ErrNotStructPtr)
+	}
+}
+
+func TestStore_IndexKey(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	s := New(nil)
+
+	// a value holding the bytes of another value and a key matches only itself.
+	for _, u := range []*User{{ID: "1", City: "paris"}, {ID: "2", City: "paris\x00x"}} {
+		if err := db.Update(func(tx *nutsdb.Tx) error {
+			return s.Save(tx, u)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for city, want := range map[string]string{"paris": "1", "paris\x00x": "2"} {
+		var found []User
+		if err := db.View(func(tx *nutsdb.Tx) error {
+			return s.Find(tx, "City", city, &found)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].ID != want {
+			t.Errorf("err Find %q. got %+v want user %s", city, found, want)
+		}
+	}
+}
+
+func TestStore_SaveCorrupt(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	s := New(nil)
+
+	if err := db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put("User", []byte("1"), []byte("not json"), nutsdb.Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index entries of a stored record failing to decode can not be removed.
+	err := db.Update(func(tx *nutsdb.Tx) error {
+		return s.Save(tx, &User{ID: "1", City: "paris"})
+	})
+	if err == nil {
+		t.Error("err Save over a corrupt record. got nil want the decoding error")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ErrNoSpace is returned when the volume of the database runs out of space.
+// The failed commit is rolled back and writable transactions return ErrNoSpace
+// until space is freed, which is checked by every Begin(true).
+var ErrNoSpace = errors.New("no space left on device")
+
+// noSpaceProbeSize is the size of the file written to check whether space was freed.
+const noSpaceProbeSize = 64 * 1024
+
+// probeSpace returns nil if a file of noSpaceProbeSize bytes can be written in the dir of s.
+// It is a variable so that tests can simulate a full volume.
+var probeSpace = func(s Storage, dir string) error {
+	p := dir + "/nospace.probe" + TempSuffix
+	defer s.Remove(p)
+
+	return writeStorageFileAtomic(s, p, make([]byte, noSpaceProbeSize), true, DefaultFilePerm)
+}
+
+// isNoSpace reports whether err is caused by a full volume.
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// writeStart records where a commit started writing in the active file.
+type writeStart struct {
+	fileID   int64
+	writeOff int64
+	hintsLen int
+}
+
+// writeStart returns the position of the next write in the active file.
+// It must be called with the db.writeMu lock held.
+func (db *DB) writeStart() writeStart {
+	return writeStart{fileID: db.ActiveFile.fileID, writeOff: db.ActiveFile.writeOff, hintsLen: len(db.activeHints)}
+}
+
+// rollbackNoSpace rolls the active file back to start after a commit failed with
+// a full volume, and switches the db to read-only until space is freed.
+// It must be called with the db.writeMu lock held.
+func (tx *Tx) rollbackNoSpace(start writeStart) {
+	db := tx.db
+	db.health.setNoSpace(true)
+
+	// the counters of the rolled back entries are reloaded by the next FileStats.
+	db.fileCounters = nil
+
+	// the rotation of the active file failed, the new file is opened by recoverSpace.
+	if db.ActiveFile.fileID != db.MaxFileID {
+		return
+	}
+
+	// the entries written before a rotation are in a sealed file, and are
+	// ignored when loading it as the tx is not committed.
+	if db.ActiveFile.fileID != start.fileID {
+		start = writeStart{fileID: db.ActiveFile.fileID}
+	}
+
+	// a frame is smaller than its entries written one by one but for its header, its
+	// commit marker and the header of the file, and the varint entry headers at most.
+	size := RecordFormatV2.overhead()
+	for _, e := range tx.pendingWrites {
+		if n := RecordFormatV3.entrySize(e); n > e.Size() {
+			size += n
+		} else {
+			size += e.Size()
+		}
+	}
+
+	// zero the written entries, the partial one included, so that they are not read
+	// when the db is reopened. This writes over blocks already allocated by the
+	// entries, but may still fail on a full volume, which leaves the entries
+	// uncommitted.
+	end := db.ActiveFile.writeOff + size
+	if end > db.opt.SegmentSize {
+		end = db.opt.SegmentSize
+	}
+	if end > start.writeOff {
+		_, _ = db.ActiveFile.WriteAt(make([]byte, end-start.writeOff), start.writeOff)
+	}
+
+	db.ActiveFile.writeOff = start.writeOff
+	db.ActiveFile.ActualSize = start.writeOff
+	if start.writeOff == 0 {
+		db.ActiveFile.resetRecordFormat()
+	}
+	if start.hintsLen <= len(db.activeHints) {
+		db.activeHints = db.activeHints[:start.hintsLen]
+	}
+}
+
+// recoverSpace returns ErrNoSpace if the db ran out of space and no space was freed since.
+// It must be called with the db.writeMu lock held.
+func (db *DB) recoverSpace() error {
+	if !db.health.isNoSpace() {
+		return nil
+	}
+
+	if err := probeSpace(db.storage, db.opt.Dir); err != nil {
+		return ErrNoSpace
+	}
+
+	if db.ActiveFile.fileID != db.MaxFileID {
+		df, err := db.openDataFile(db.MaxFileID, db.opt.RWMode)
+		if err != nil {
+			return err
+		}
+		db.ActiveFile = df
+	}
+
+	db.health.setNoSpace(false)
+
+	return nil
+}
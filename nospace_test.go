@@ -0,0 +1,119 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+// fullRWManager fails every write with syscall.ENOSPC while full is set.
+type fullRWManager struct {
+	RWManager
+	full *bool
+}
+
+func (fm *fullRWManager) WriteAt(b []byte, off int64) (int, error) {
+	if *fm.full {
+		n, _ := fm.RWManager.WriteAt(b[:len(b)/2], off)
+		return n, syscall.ENOSPC
+	}
+
+	return fm.RWManager.WriteAt(b, off)
+}
+
+func TestDB_NoSpace(t *testing.T) {
+	full := false
+	InitOpt("/tmp/nutsdbtestnospace", true)
+	opt.RWManagerFactory = func(path string, capacity int64, rwMode RWMode) (RWManager, error) {
+		rw, err := NewRWManager(path, capacity, rwMode)
+		if err != nil {
+			return nil, err
+		}
+		return &fullRWManager{RWManager: rw, full: &full}, nil
+	}
+
+	defer func(probe func(Storage, string) error) { probeSpace = probe }(probeSpace)
+	probeSpace = func(Storage, string) error {
+		if full {
+			return syscall.ENOSPC
+		}
+		return nil
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	put := func(key string) error {
+		return db.Update(func(tx *Tx) error {
+			if err := tx.Put("bucket", []byte(key), []byte("val"), Persistent); err != nil {
+				return err
+			}
+			return tx.Put("bucket", []byte(key+"_2"), []byte("val"), Persistent)
+		})
+	}
+
+	if err := put("k1"); err != nil {
+		t.Fatal(err)
+	}
+	writeOff := db.ActiveFile.writeOff
+
+	full = true
+	if err := put("k2"); !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("expected ErrNoSpace, got %v", err)
+	}
+
+	if db.ActiveFile.writeOff != writeOff {
+		t.Fatalf("expected the write offset to be rolled back to %d, got %d", writeOff, db.ActiveFile.writeOff)
+	}
+
+	if h := db.Health(); !h.NoSpace || !h.ReadOnly {
+		t.Fatalf("expected the db to be read-only, got %+v", h)
+	}
+
+	if _, err := db.Begin(true); err != ErrNoSpace {
+		t.Fatalf("expected ErrNoSpace, got %v", err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get("bucket", []byte("k1"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	full = false
+	if err := put("k3"); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := db.Health(); h.NoSpace || h.ReadOnly {
+		t.Fatalf("expected the db to recover, got %+v", h)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		if _, err := tx.Get("bucket", []byte("k2")); err == nil {
+			t.Error("expected the failed commit to be rolled back")
+		}
+		_, err := tx.Get("bucket", []byte("k3_2"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
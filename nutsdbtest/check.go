@@ -0,0 +1,320 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdbtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+var (
+	// ErrDirNotEmpty is returned when Check is run on a non-empty directory.
+	ErrDirNotEmpty = errors.New("nutsdbtest: check dir must be empty")
+
+	// ErrDivergence is returned when nutsdb and the reference model disagree.
+	ErrDivergence = errors.New("nutsdbtest: divergence from model")
+)
+
+const checkKeyPrefix = "key_"
+
+// CheckOptions represents the options of a model-based consistency check.
+type CheckOptions struct {
+	// Seed seeds the random operation sequence. The same Seed reproduces the same run.
+	Seed int64
+
+	// Steps represents the number of operations. Default Steps is 1000.
+	Steps int
+
+	// Keys represents the size of the key space. Default Keys is 64.
+	Keys int
+
+	// Bucket represents the bucket the operations run in. Default Bucket is "check".
+	Bucket string
+
+	// ReopenRate represents the probability of a clean close/reopen step.
+	ReopenRate float64
+
+	// CrashRate represents the probability of a crash step: a write whose
+	// fsync fails, followed by a restart. Crash steps need Options.SyncEnable
+	// to inject the failure, otherwise they are a plain restart.
+	CrashRate float64
+}
+
+// DefaultCheckOptions represents the default options of Check.
+var DefaultCheckOptions = CheckOptions{
+	Seed:       1,
+	Steps:      1000,
+	Keys:       64,
+	Bucket:     "check",
+	ReopenRate: 0.02,
+	CrashRate:  0.02,
+}
+
+// DivergenceError describes the first operation where nutsdb and the model disagree.
+type DivergenceError struct {
+	Seed int64
+	Step int
+	Op   string
+	Key  string
+	Got  string
+	Want string
+}
+
+// Error returns the description of the divergence.
+func (e *DivergenceError) Error() string {
+	return fmt.Sprintf("nutsdbtest: seed %d step %d: %s %q: got %q want %q", e.Seed, e.Step, e.Op, e.Key, e.Got, e.Want)
+}
+
+// Unwrap returns ErrDivergence.
+func (e *DivergenceError) Unwrap() error {
+	return ErrDivergence
+}
+
+// checker runs the random operations against a DB and the in-memory model.
+type checker struct {
+	opt   nutsdb.Options
+	co    CheckOptions
+	r     *rand.Rand
+	db    *nutsdb.DB
+	in    *Injector
+	model map[string]string
+
+	// uncertain records the keys of failed writes, which may or may not
+	// have been persisted, with the value the write tried to set.
+	uncertain map[string]*string
+}
+
+// Check runs a random sequence of puts, deletes, gets and scans against a
+// database opened at given options and against an in-memory reference model,
+// interleaving clean reopen and crash/restart steps, and returns a
+// *DivergenceError at the first disagreement.
+// opt.Dir must be empty or not exist; its contents are left for inspection.
+func Check(opt nutsdb.Options, co CheckOptions) error {
+	if files, err := ioutil.ReadDir(opt.Dir); err == nil && len(files) > 0 {
+		return ErrDirNotEmpty
+	}
+
+	co = withCheckDefaults(co)
+
+	c := &checker{
+		opt:       opt,
+		co:        co,
+		r:         rand.New(rand.NewSource(co.Seed)),
+		in:        NewInjector(),
+		model:     make(map[string]string),
+		uncertain: make(map[string]*string),
+	}
+
+	faultyOpt := opt
+	faultyOpt.RWManagerFactory = c.in.Factory()
+	c.opt = faultyOpt
+
+	db, err := nutsdb.Open(c.opt)
+	if err != nil {
+		return err
+	}
+	c.db = db
+	defer func() {
+		_ = c.db.Close()
+	}()
+
+	for step := 0; step < co.Steps; step++ {
+		if err := c.step(step); err != nil {
+			return err
+		}
+	}
+
+	return c.checkScan(co.Steps)
+}
+
+func withCheckDefaults(co CheckOptions) CheckOptions {
+	if co.Steps <= 0 {
+		co.Steps = DefaultCheckOptions.Steps
+	}
+	if co.Keys <= 0 {
+		co.Keys = DefaultCheckOptions.Keys
+	}
+	if co.Bucket == "" {
+		co.Bucket = DefaultCheckOptions.Bucket
+	}
+
+	return co
+}
+
+func (c *checker) step(step int) error {
+	key := fmt.Sprintf("%s%04d", checkKeyPrefix, c.r.Intn(c.co.Keys))
+	value := fmt.Sprintf("v_%d", step)
+
+	p := c.r.Float64()
+	switch {
+	case p < c.co.CrashRate:
+		return c.crash(step, key, value)
+	case p < c.co.CrashRate+c.co.ReopenRate:
+		return c.restart(step)
+	}
+
+	switch n := c.r.Intn(100); {
+	case n < 45:
+		if err := c.put(key, value); err != nil {
+			return err
+		}
+		c.model[key] = value
+	case n < 60:
+		if err := c.delete(key); err != nil {
+			return err
+		}
+		delete(c.model, key)
+	case n < 90:
+		return c.checkGet(step, key)
+	default:
+		return c.checkScan(step)
+	}
+
+	return nil
+}
+
+func (c *checker) put(key, value string) error {
+	return c.db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(c.co.Bucket, []byte(key), []byte(value), nutsdb.Persistent)
+	})
+}
+
+func (c *checker) delete(key string) error {
+	return c.db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Delete(c.co.Bucket, []byte(key))
+	})
+}
+
+// crash writes the key with an fsync failure injected, then restarts.
+func (c *checker) crash(step int, key, value string) error {
+	if c.opt.SyncEnable {
+		c.in.FailAt(SyncFailure, 1)
+
+		err := c.put(key, value)
+		c.in.Reset()
+
+		if err == nil {
+			c.model[key] = value
+		} else if errors.Is(err, ErrInjected) {
+			c.uncertain[key] = &value
+		} else {
+			return err
+		}
+	}
+
+	return c.restart(step)
+}
+
+// restart reopens the database and resolves the uncertain keys.
+func (c *checker) restart(step int) error {
+	db, err := Restart(c.db, c.opt)
+	if err != nil {
+		return fmt.Errorf("nutsdbtest: seed %d step %d: restart: %w", c.co.Seed, step, err)
+	}
+	c.db = db
+
+	for key, value := range c.uncertain {
+		got, found, err := c.get(key)
+		if err != nil {
+			return err
+		}
+
+		want, ok := c.model[key]
+		switch {
+		case found && got == *value:
+			c.model[key] = got
+		case found == ok && got == want:
+		default:
+			return &DivergenceError{Seed: c.co.Seed, Step: step, Op: "restart", Key: key, Got: got, Want: want + " or " + *value}
+		}
+
+		delete(c.uncertain, key)
+	}
+
+	return nil
+}
+
+func (c *checker) get(key string) (value string, found bool, err error) {
+	err = c.db.View(func(tx *nutsdb.Tx) error {
+		e, err := tx.Get(c.co.Bucket, []byte(key))
+		if err != nil {
+			return err
+		}
+		value, found = string(e.Value), true
+		return nil
+	})
+	if isNotFound(err) {
+		err = nil
+	}
+
+	return
+}
+
+func (c *checker) checkGet(step int, key string) error {
+	got, found, err := c.get(key)
+	if err != nil {
+		return err
+	}
+
+	want, ok := c.model[key]
+	if found != ok || got != want {
+		return &DivergenceError{Seed: c.co.Seed, Step: step, Op: "get", Key: key, Got: got, Want: want}
+	}
+
+	return nil
+}
+
+func (c *checker) checkScan(step int) error {
+	var entries nutsdb.Entries
+	err := c.db.View(func(tx *nutsdb.Tx) (err error) {
+		entries, err = tx.PrefixScan(c.co.Bucket, []byte(checkKeyPrefix), nutsdb.ScanNoLimit)
+		return
+	})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	keys := make([]string, 0, len(c.model))
+	for key := range c.model {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var got, want bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&got, "%s=%s,", e.Key, e.Value)
+	}
+	for _, key := range keys {
+		fmt.Fprintf(&want, "%s=%s,", key, c.model[key])
+	}
+
+	if got.String() != want.String() {
+		return &DivergenceError{Seed: c.co.Seed, Step: step, Op: "scan", Key: checkKeyPrefix, Got: got.String(), Want: want.String()}
+	}
+
+	return nil
+}
+
+// isNotFound reports whether err means the key or bucket has no entries.
+func isNotFound(err error) bool {
+	return errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrBucketNotFound) ||
+		err == nutsdb.ErrPrefixScan || err == nutsdb.ErrBucketEmpty
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdbtest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+func TestCheck(t *testing.T) {
+	modes := []nutsdb.EntryIdxMode{nutsdb.HintKeyValAndRAMIdxMode, nutsdb.HintKeyAndRAMIdxMode}
+	rwModes := []nutsdb.RWMode{nutsdb.FileIO, nutsdb.MMap}
+
+	for _, mode := range modes {
+		for _, rwMode := range rwModes {
+			opt := newOptions(t, fmt.Sprintf("/tmp/nutsdbtestcheck_%d_%d", mode, rwMode))
+			opt.EntryIdxMode = mode
+			opt.RWMode = rwMode
+
+			co := DefaultCheckOptions
+			co.Steps = 300
+			co.CrashRate = 0.05
+			co.ReopenRate = 0.05
+
+			if err := Check(opt, co); err != nil {
+				t.Errorf("err Check. mode %d rwMode %d: %v", mode, rwMode, err)
+			}
+		}
+	}
+}
+
+func TestCheck_DirNotEmpty(t *testing.T) {
+	opt := newOptions(t, "/tmp/nutsdbtestchecknotempty")
+	if err := os.MkdirAll(opt.Dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(opt.Dir+"/0.dat", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Check(opt, DefaultCheckOptions); err != ErrDirNotEmpty {
+		t.Errorf("err Check. got %v want %v", err, ErrDirNotEmpty)
+	}
+}
+
+func TestDivergenceError(t *testing.T) {
+	var err error = &DivergenceError{Step: 1, Op: "get", Key: "k"}
+	if !errors.Is(err, ErrDivergence) {
+		t.Errorf("err Is. got %v want %v", err, ErrDivergence)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdbtest
+
+import "github.com/xujiajun/nutsdb"
+
+// Restart closes db, ignoring its error as a crashed process would,
+// and opens the database again at given options.
+func Restart(db *nutsdb.DB, opt nutsdb.Options) (*nutsdb.DB, error) {
+	if db != nil {
+		_ = db.Close()
+	}
+
+	return nutsdb.Open(opt)
+}
+
+// CrashCycle opens the database at given options with the faults of in injected,
+// runs fn against it, then simulates a crash and restart: the database is
+// abandoned and reopened at the original options, without faults.
+// Errors caused by the injected faults are expected inside fn, so fn handles them itself.
+func CrashCycle(opt nutsdb.Options, in *Injector, fn func(db *nutsdb.DB)) (*nutsdb.DB, error) {
+	faultyOpt := opt
+	faultyOpt.RWManagerFactory = in.Factory()
+
+	db, err := nutsdb.Open(faultyOpt)
+	if err != nil {
+		return nil, err
+	}
+
+	fn(db)
+
+	return Restart(db, opt)
+}
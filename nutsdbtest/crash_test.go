@@ -0,0 +1,105 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdbtest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+func TestCrashCycle_SyncFailure(t *testing.T) {
+	opt := newOptions(t, "/tmp/nutsdbtestcrashsync")
+	in := NewInjector()
+	in.FailAt(SyncFailure, 2)
+
+	db, err := CrashCycle(opt, in, func(db *nutsdb.DB) {
+		if err := put(db, "k1", "v1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := put(db, "k2", "v2"); !errors.Is(err, ErrInjected) {
+			t.Errorf("err Sync. got %v want %v", err, ErrInjected)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		_, err := tx.Get(bucket, []byte("k1"))
+		return err
+	}); err != nil {
+		t.Errorf("err Get after restart. got %v", err)
+	}
+}
+
+func TestCrashCycle_TornWrite(t *testing.T) {
+	opt := newOptions(t, "/tmp/nutsdbtestcrashwrite")
+	in := NewInjector()
+
+	db, err := CrashCycle(opt, in, func(db *nutsdb.DB) {
+		if err := put(db, "k1", "v1"); err != nil {
+			t.Fatal(err)
+		}
+		in.FailAt(TornWrite, 1)
+		if err := put(db, "k2", "v2"); !errors.Is(err, ErrInjected) {
+			t.Errorf("err WriteAt. got %v want %v", err, ErrInjected)
+		}
+	})
+
+	// a torn tail is either tolerated or reported as corruption, never silently misread.
+	if err != nil {
+		if !errors.Is(err, nutsdb.ErrCorrupted) {
+			t.Fatalf("err Restart. got %v want %v", err, nutsdb.ErrCorrupted)
+		}
+		return
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		_, err := tx.Get(bucket, []byte("k1"))
+		return err
+	}); err != nil {
+		t.Errorf("err Get after restart. got %v", err)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	opt := newOptions(t, "/tmp/nutsdbtestrestart")
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := put(db, "k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Restart(db, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		_, err := tx.Get(bucket, []byte("k1"))
+		return err
+	}); err != nil {
+		t.Errorf("err Get after restart. got %v", err)
+	}
+}
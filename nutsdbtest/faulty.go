@@ -0,0 +1,197 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nutsdbtest provides fault injection and crash/restart helpers
+// for testing code built on nutsdb.
+//
+// An Injector creates FaultyRWManagers through Options.RWManagerFactory and
+// fires torn writes, short reads, fsync failures and full disks at configurable points:
+//
+//	in := nutsdbtest.NewInjector()
+//	in.FailAt(nutsdbtest.TornWrite, 3)
+//	db, err := nutsdbtest.CrashCycle(opt, in, func(db *nutsdb.DB) {
+//		// writes here hit the torn write on the third WriteAt.
+//	})
+package nutsdbtest
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// ErrInjected is returned by a FaultyRWManager when a fault fires.
+var ErrInjected = errors.New("nutsdbtest: injected fault")
+
+// Fault represents a kind of injected fault.
+type Fault int
+
+const (
+	// TornWrite writes only the first half of the buffer and then fails.
+	TornWrite Fault = iota
+
+	// ShortRead reads only the first half of the buffer and then fails.
+	ShortRead
+
+	// SyncFailure fails the fsync without flushing.
+	SyncFailure
+
+	// DiskFull writes only the first half of the buffer and then fails with syscall.ENOSPC.
+	DiskFull
+
+	faultNum
+)
+
+// Injector decides when faults fire. It is shared by every FaultyRWManager
+// created by its Factory, so operations are counted across all data files.
+type Injector struct {
+	mu     sync.Mutex
+	counts [faultNum]int
+	at     [faultNum]int
+	fired  [faultNum]int
+}
+
+// NewInjector returns a newly initialized Injector with no fault armed.
+func NewInjector() *Injector {
+	return &Injector{}
+}
+
+// FailAt arms the fault to fire on the nth operation of its kind counted from now.
+// n <= 0 disarms the fault.
+func (in *Injector) FailAt(f Fault, n int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.counts[f] = 0
+	if n < 0 {
+		n = 0
+	}
+	in.at[f] = n
+}
+
+// Reset disarms every fault and clears the counters.
+func (in *Injector) Reset() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.counts = [faultNum]int{}
+	in.at = [faultNum]int{}
+	in.fired = [faultNum]int{}
+}
+
+// Count returns the number of operations of the fault kind seen since it was armed.
+func (in *Injector) Count(f Fault) int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return in.counts[f]
+}
+
+// Fired returns the number of times the fault has fired.
+func (in *Injector) Fired(f Fault) int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return in.fired[f]
+}
+
+// fire counts an operation of the fault kind and reports whether the fault fires.
+// An armed fault fires once and is then disarmed.
+func (in *Injector) fire(f Fault) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.counts[f]++
+	if in.at[f] == 0 || in.counts[f] != in.at[f] {
+		return false
+	}
+
+	in.at[f] = 0
+	in.fired[f]++
+
+	return true
+}
+
+// Factory returns the nutsdb.RWManagerFactory wrapping the built-in
+// RWManagers into FaultyRWManagers driven by the Injector.
+func (in *Injector) Factory() nutsdb.RWManagerFactory {
+	return func(path string, capacity int64, rwMode nutsdb.RWMode) (nutsdb.RWManager, error) {
+		rw, err := nutsdb.NewRWManager(path, capacity, rwMode)
+		if err != nil {
+			return nil, err
+		}
+
+		return &FaultyRWManager{rw: rw, in: in}, nil
+	}
+}
+
+// FaultyRWManager represents the RWManager which injects the faults of its Injector.
+type FaultyRWManager struct {
+	rw nutsdb.RWManager
+	in *Injector
+}
+
+// WriteAt writes len(b) bytes starting at byte offset off.
+// On a TornWrite only the first half of b is written and ErrInjected is returned,
+// on a DiskFull syscall.ENOSPC.
+func (fm *FaultyRWManager) WriteAt(b []byte, off int64) (n int, err error) {
+	if fm.in.fire(TornWrite) {
+		n, err = fm.rw.WriteAt(b[:len(b)/2], off)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrInjected
+	}
+
+	if fm.in.fire(DiskFull) {
+		n, err = fm.rw.WriteAt(b[:len(b)/2], off)
+		if err != nil {
+			return n, err
+		}
+		return n, syscall.ENOSPC
+	}
+
+	return fm.rw.WriteAt(b, off)
+}
+
+// ReadAt reads len(b) bytes starting at byte offset off.
+// On a ShortRead only the first half of b is read and ErrInjected is returned.
+func (fm *FaultyRWManager) ReadAt(b []byte, off int64) (n int, err error) {
+	if fm.in.fire(ShortRead) {
+		n, err = fm.rw.ReadAt(b[:len(b)/2], off)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrInjected
+	}
+
+	return fm.rw.ReadAt(b, off)
+}
+
+// Sync commits the current contents of the file to stable storage.
+// On a SyncFailure nothing is flushed and ErrInjected is returned.
+func (fm *FaultyRWManager) Sync() (err error) {
+	if fm.in.fire(SyncFailure) {
+		return ErrInjected
+	}
+
+	return fm.rw.Sync()
+}
+
+// Close closes the wrapped RWManager.
+func (fm *FaultyRWManager) Close() (err error) {
+	return fm.rw.Close()
+}
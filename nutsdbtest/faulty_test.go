@@ -0,0 +1,175 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdbtest
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+const bucket = "bucket"
+
+func newOptions(t *testing.T, dir string) nutsdb.Options {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = dir
+	opt.SegmentSize = 8 * 1024
+
+	return opt
+}
+
+func put(db *nutsdb.DB, key, value string) error {
+	return db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(bucket, []byte(key), []byte(value), nutsdb.Persistent)
+	})
+}
+
+func TestFaultyRWManager_SyncFailure(t *testing.T) {
+	opt := newOptions(t, "/tmp/nutsdbtestfaultysync")
+	in := NewInjector()
+	opt.RWManagerFactory = in.Factory()
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	in.FailAt(SyncFailure, 2)
+
+	if err := put(db, "k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := put(db, "k2", "v2"); !errors.Is(err, ErrInjected) {
+		t.Errorf("err Sync. got %v want %v", err, ErrInjected)
+	}
+
+	if n := in.Fired(SyncFailure); n != 1 {
+		t.Errorf("err Fired. got %d want 1", n)
+	}
+
+	// the fault fires once
+	if err := put(db, "k3", "v3"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFaultyRWManager_ShortRead(t *testing.T) {
+	opt := newOptions(t, "/tmp/nutsdbtestfaultyread")
+	opt.EntryIdxMode = nutsdb.HintKeyAndRAMIdxMode
+	in := NewInjector()
+	opt.RWManagerFactory = in.Factory()
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := put(db, "k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	in.FailAt(ShortRead, 1)
+
+	err = db.View(func(tx *nutsdb.Tx) error {
+		_, err := tx.Get(bucket, []byte("k1"))
+		return err
+	})
+	if !errors.Is(err, ErrInjected) {
+		t.Errorf("err ReadAt. got %v want %v", err, ErrInjected)
+	}
+}
+
+func TestFaultyRWManager_TornWrite(t *testing.T) {
+	opt := newOptions(t, "/tmp/nutsdbtestfaultywrite")
+	in := NewInjector()
+	opt.RWManagerFactory = in.Factory()
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	in.FailAt(TornWrite, 1)
+
+	if err := put(db, "k1", "v1"); !errors.Is(err, ErrInjected) {
+		t.Errorf("err WriteAt. got %v want %v", err, ErrInjected)
+	}
+
+	if n := in.Count(TornWrite); n != 1 {
+		t.Errorf("err Count. got %d want 1", n)
+	}
+
+	in.Reset()
+	if n := in.Count(TornWrite); n != 0 {
+		t.Errorf("err Reset. got %d want 0", n)
+	}
+}
+
+func TestFaultyRWManager_DiskFull(t *testing.T) {
+	opt := newOptions(t, "/tmp/nutsdbtestfaultyfull")
+	in := NewInjector()
+	opt.RWManagerFactory = in.Factory()
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := put(db, "k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	in.FailAt(DiskFull, 1)
+
+	if err := put(db, "k2", "v2"); !errors.Is(err, nutsdb.ErrNoSpace) {
+		t.Errorf("err WriteAt. got %v want %v", err, nutsdb.ErrNoSpace)
+	}
+
+	// space is available again, so the next write succeeds over the rolled back entry.
+	if err := put(db, "k3", "v3"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opt.RWManagerFactory = nil
+	db, err = nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for key, want := range map[string]bool{"k1": true, "k2": false, "k3": true} {
+		err := db.View(func(tx *nutsdb.Tx) error {
+			_, err := tx.Get(bucket, []byte(key))
+			return err
+		})
+		if got := err == nil; got != want {
+			t.Errorf("err Get %s. got %v", key, err)
+		}
+	}
+}
@@ -14,6 +14,11 @@
 
 package nutsdb
 
+import (
+	"os"
+	"time"
+)
+
 // EntryIdxMode represents entry index mode.
 type EntryIdxMode int
 
@@ -54,16 +59,267 @@ type Options struct {
 
 	// StartFileLoadingMode represents when open a database which RWMode to load files.
 	StartFileLoadingMode RWMode
+
+	// RWManagerFactory represents the factory creating the RWManager of every data file.
+	// Default RWManagerFactory is nil, which means using the built-in FileIO and MMap managers.
+	RWManagerFactory RWManagerFactory
+
+	// Storage represents the file system storing the files of the database, e.g. a
+	// MemStorage to run it under GOOS=js and GOARCH=wasm. The data files are read and
+	// written through FileIO managers of its files, StartFileLoadingMode is ignored.
+	// Backup, BackupTo, Checkpoint and HintBPTSparseIdxMode are not supported.
+	// Default Storage is nil, which means the files of the OS.
+	Storage Storage
+
+	// DirPerm represents the permission bits, before the umask, of the dirs created by
+	// the database, e.g. its dir and the bpt, root and txid dirs of HintBPTSparseIdxMode.
+	// Default DirPerm is 0, which means DefaultDirPerm.
+	DirPerm os.FileMode
+
+	// FilePerm represents the permission bits, before the umask, of the files created by
+	// the database, e.g. the data, hint and bpt index files.
+	// Default FilePerm is 0, which means DefaultFilePerm.
+	FilePerm os.FileMode
+
+	// UseDirectIO represents if the data files read from start to end, by the merges, Backup,
+	// BackupTo and Open loading the sealed files, bypass the page cache, with O_DIRECT on
+	// Linux and F_NOCACHE on macOS, so that a compaction does not evict the pages of the hot
+	// reads. The reads are aligned internally. It is ignored on the other systems, on the
+	// file systems not supporting it, e.g. tmpfs, and with a Storage.
+	// Default UseDirectIO is false.
+	UseDirectIO bool
+
+	// AdviseRandomReads represents if the data files opened for the point lookups, e.g. by
+	// Get, are advised random to the OS, disabling the readahead which would read the
+	// neighbouring entries along with each value. The merges, Backup, BackupTo and Open
+	// always advise their sequential scans and drop the scanned pages from the page cache.
+	// The advices are only given on Linux, with fadvise on amd64 and arm64 and with madvise
+	// to the MMap RWMode, and ignored with a Storage.
+	// Default AdviseRandomReads is false.
+	AdviseRandomReads bool
+
+	// RecoveryReadBufferSize represents the buffer size in bytes of the sequential
+	// readers used to load the data files when opening a database.
+	// Default RecoveryReadBufferSize is 256KB.
+	RecoveryReadBufferSize int
+
+	// RecoveryMode represents how opening a database handles the records of the data files
+	// torn, not written completely, e.g. by a crash, or corrupt, failing their checksum:
+	// RecoveryDefault ignores the torn records ending a data file and fails on the corrupt
+	// ones, RecoveryTolerateTail truncates the last data file at a torn record and fails on
+	// the others, RecoverySkipCorrupt skips them, reported by DB.SkippedRecords, and
+	// RecoveryStrict fails on any of them, as well as on an entry failing to decode, e.g. the
+	// score of a sorted set or the index of a list, with a *RecoveryError, instead of
+	// skipping it or decoding it as zero, which makes the index diverge from the data files
+	// silently.
+	// Default RecoveryMode is RecoveryDefault.
+	RecoveryMode RecoveryMode
+
+	// MaxIndexMemory represents the max bytes of the values kept in RAM in HintKeyValAndRAMIdxMode.
+	// When it is exceeded, only the values of the hot keys stay in RAM and the others are read from disk.
+	// Default MaxIndexMemory is 0, which means no limit.
+	MaxIndexMemory int64
+
+	// FrontCodedKeys represents if the B+ tree leaves store their keys front-coded in
+	// HintKeyAndRAMIdxMode: the prefix shared by the keys of a leaf is stored once, and
+	// the keys are copied out of the buffers they were read from. It uses much less RAM
+	// on long, similar keys, e.g. "user:1234:orders:5678", trading some CPU on every
+	// lookup and insert. It is ignored in the other modes.
+	// Default FrontCodedKeys is false.
+	FrontCodedKeys bool
+
+	// DupBucket represents the function returning if the bucket allows duplicate keys, i.e.
+	// multiple sorted values by key written by PutDup, instead of Put. The values are stored
+	// in the keys of the bucket, so it must be ordered bytewise.
+	// Default DupBucket is nil, which means no bucket allows duplicate keys.
+	DupBucket func(bucket string) bool
+
+	// LazyIndexLoad represents if the B+ tree index of a bucket is built on its first
+	// access instead of when opening the database, so that a database with many buckets
+	// but few hot ones opens faster. The data files are still read when opening it, and
+	// the set, list and sorted set indexes are still built. It is ignored in
+	// HintBPTSparseIdxMode and when MaxIndexMemory is set. See DB.LazyBuckets.
+	// Default LazyIndexLoad is false.
+	LazyIndexLoad bool
+
+	// IndexCacheSize represents the number of B+ tree nodes of the index files of
+	// HintBPTSparseIdxMode cached in RAM. When set, the index files are memory-mapped
+	// and their nodes served from the mappings through the cache, instead of opening
+	// a file for every node read, keeping the index off the Go heap. It is ignored in
+	// the other modes. See DB.IndexCacheStats.
+	// Default IndexCacheSize is 0, which means reading the nodes from the files.
+	IndexCacheSize int
+
+	// SiteID represents the ID of the database recorded in every entry it writes, returned
+	// by MetaData.SiteID, so that an application merging the writes of several databases
+	// can tell their origin apart. The writes of a database with a SiteID are stamped in
+	// commit order, see MetaData.Seq, a write being stamped after the writes it has seen
+	// even when Clock goes backwards. The merges keep the site IDs and the stamps of the
+	// rewritten entries. The data files written with SiteID cannot be read by the versions
+	// without it.
+	// Default SiteID is 0, which means no site.
+	SiteID uint16
+
+	// Clock represents the source of the entry timestamps and of the time used by TTL.
+	// Default Clock is nil, which means using SystemClock.
+	Clock Clock
+
+	// KeyComparator represents the function returning the key order of the bucket.
+	// Returning nil means the default bytewise order. The comparator names are persisted,
+	// and opening the database with another comparator for a bucket fails.
+	// Default KeyComparator is nil, which means bytewise for every bucket.
+	KeyComparator func(bucket string) KeyComparator
+
+	// BucketOptions represents the function returning the hooks of the bucket, e.g. for
+	// using it as a read-through and write-through cache in front of a slower source,
+	// see BucketOptions and GetThrough.
+	// Default BucketOptions is nil, which means no hooks for every bucket.
+	BucketOptions func(bucket string) BucketOptions
+
+	// ReadOnly represents if the database is opened for reading only, e.g. a
+	// directory written by DB.Checkpoint. Writable transactions, Merge and
+	// CheckpointIndex return ErrReadOnly.
+	// Default ReadOnly is false.
+	ReadOnly bool
+
+	// MaxDiskUsage represents the max size in bytes of the data files, every sealed
+	// file counting SegmentSize. A commit growing them over it returns a
+	// *DiskQuotaError, which unwraps to ErrDiskQuotaExceeded; Merge may free space.
+	// Default MaxDiskUsage is 0, which means no limit.
+	MaxDiskUsage int64
+
+	// MaxWriteBytesPerSecond represents the max bytes of entries written per second.
+	// Commits over it sleep, holding the write lock but not blocking the readers.
+	// Default MaxWriteBytesPerSecond is 0, which means no limit.
+	MaxWriteBytesPerSecond int64
+
+	// MaxWritesPerSecond represents the max entries written per second, throttled
+	// like MaxWriteBytesPerSecond.
+	// Default MaxWritesPerSecond is 0, which means no limit.
+	MaxWritesPerSecond int64
+
+	// WriteStall represents the backpressure applied to the writable transactions when
+	// the merges fall behind, see WriteStallOptions. The stalls are reported by Health.
+	// Default WriteStall is the zero value, which means no stall.
+	WriteStall WriteStallOptions
+
+	// ParanoidChecks represents if Get checks the index against the entry read from the
+	// data file. In HintKeyValAndRAMIdxMode, a value diverging from the data file is
+	// replaced by the one read from it, repaired in the index by the next commit. An index
+	// not pointing to the entry of the key returns an *IndexDivergenceError instead of
+	// wrong data. Both are counted and reported by Health.
+	// Default ParanoidChecks is false, as every Get reads the data file.
+	ParanoidChecks bool
+
+	// CompactionFilter represents the function called by the merges for every key/value
+	// entry rewritten, dropping the entry when it returns false, e.g. for purging the keys
+	// of a user without deleting them one by one. A dropped entry the index points to is
+	// replaced by a deletion, so the key is deleted and older entries do not come back.
+	// The list, set and sorted set entries are not filtered.
+	// Default CompactionFilter is nil, which means keeping every entry.
+	CompactionFilter func(bucket string, e *Entry) (keep bool)
+
+	// IdempotencyTTL represents the TTL in seconds of the operation IDs recorded by
+	// PutIdempotent, the time a retried operation is detected as a duplicate.
+	// Default IdempotencyTTL is 0, which means Persistent.
+	IdempotencyTTL uint32
+
+	// TrashRetention represents the TTL in seconds of the keys deleted by SoftDelete
+	// in TrashBucket, the time Undelete can restore them.
+	// Default TrashRetention is 0, which means Persistent.
+	TrashRetention uint32
+
+	// MaxActiveTransactions represents the max transactions open at the same time. Beginning
+	// one more returns ErrTooManyTransactions, e.g. instead of piling up the read-only
+	// transactions leaked by a missing Commit or Rollback.
+	// Default MaxActiveTransactions is 0, which means no limit.
+	MaxActiveTransactions int
+
+	// TxLeakDetection represents the detection of the transactions left open, reported with
+	// the stack trace of their Begin, see TxLeakDetectionOptions and DB.OpenTxs.
+	// Default TxLeakDetection.Threshold is 0, which means no detection.
+	TxLeakDetection TxLeakDetectionOptions
+
+	// FollowInterval represents the time between two polls of the data files by a
+	// database opened with OpenFollower.
+	// Default FollowInterval is 0, which means DefaultFollowInterval.
+	FollowInterval time.Duration
+
+	// CDC represents the change data capture shipping the entries of the committed
+	// transactions to a sink, see CDCOptions. It is ignored when ReadOnly is set.
+	// Default CDC.Sink is nil, which means no capture.
+	CDC CDCOptions
+
+	// AutoBackup represents the backups taken on a timer in a background goroutine.
+	// Default AutoBackup.Interval is 0, which means no automatic backups.
+	AutoBackup AutoBackupOptions
+
+	// AdminToken represents the token required by the admin endpoint of DB.ServeAdmin and
+	// DB.AdminHandler, in an "Authorization: Bearer" header of every request.
+	// Default AdminToken is "", which means ServeAdmin returns ErrAdminToken and
+	// AdminHandler rejects every request.
+	AdminToken string
+
+	// AdminBackupDir represents the dir the backups run by the admin endpoint are written
+	// in: the dir of a backup request is relative to it, and one resolving outside of it
+	// is rejected with ErrAdminBackupDir.
+	// Default AdminBackupDir is "", which means the admin endpoint runs no backup.
+	AdminBackupDir string
+
+	// MergeOnClose represents the merge run by Close of the files dirty enough, see
+	// AutoMergeOptions, so that short-lived processes, e.g. CLI tools, do not leave an
+	// ever-growing dir behind. Close returns its error after closing the database.
+	// Default MergeOnClose.MinDirtyRatio is 0, which means no merge.
+	MergeOnClose AutoMergeOptions
+
+	// CompactOnOpen represents the merge run by Open of the files dirty enough, see
+	// AutoMergeOptions. Open fails with its error.
+	// Default CompactOnOpen.MinDirtyRatio is 0, which means no merge.
+	CompactOnOpen AutoMergeOptions
+
+	// WAL represents the write-ahead log of the commits with SyncEnable, see WALOptions:
+	// a transaction is durable after one sequential append to the WAL, synced once, while
+	// the data files are synced by the checkpoints of a background goroutine, so that the
+	// commit latency does not grow with the number and the size of the entries.
+	// Default WAL.Enabled is false, which means syncing the data file after every entry.
+	WAL WALOptions
+
+	// RecordFormat represents the format the entries are written to the new data files in,
+	// see RecordFormat. The data files are read in the format they were written in, the
+	// active file being written in its own until it is rotated, so the format of a db can
+	// be changed between two Opens. The versions before RecordFormatV2 cannot read it,
+	// nor the ones before RecordFormatV3 its files.
+	// Default RecordFormat is RecordFormatV1.
+	RecordFormat RecordFormat
+
+	// BucketDictionary represents whether the entries written in frames, see RecordFormat,
+	// store the 4-byte ID of their bucket instead of its name, the IDs being persisted in
+	// the dictionary of the file BucketDictFileName, shrinking the data files of the long
+	// bucket names. The entries written before are read as they are, and rewritten with
+	// the IDs by Merge. It is ignored with RecordFormatV1.
+	// Default BucketDictionary is false.
+	BucketDictionary bool
 }
 
 var defaultSegmentSize int64 = 8 * 1024 * 1024
 
+var defaultRecoveryReadBufferSize = 256 * 1024
+
+const (
+	// DefaultDirPerm is the permission of the dirs created when Options.DirPerm is 0.
+	DefaultDirPerm os.FileMode = os.ModePerm
+
+	// DefaultFilePerm is the permission of the files created when Options.FilePerm is 0.
+	DefaultFilePerm os.FileMode = 0644
+)
+
 // DefaultOptions represents the default options.
 var DefaultOptions = Options{
-	EntryIdxMode:         HintKeyValAndRAMIdxMode,
-	SegmentSize:          defaultSegmentSize,
-	NodeNum:              1,
-	RWMode:               FileIO,
-	SyncEnable:           true,
-	StartFileLoadingMode: MMap,
+	EntryIdxMode:           HintKeyValAndRAMIdxMode,
+	SegmentSize:            defaultSegmentSize,
+	NodeNum:                1,
+	RWMode:                 FileIO,
+	SyncEnable:             true,
+	StartFileLoadingMode:   MMap,
+	RecoveryReadBufferSize: defaultRecoveryReadBufferSize,
 }
@@ -0,0 +1,128 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// ErrIndexDivergence is returned when the index does not point to the entry of the key in the data files.
+var ErrIndexDivergence = wrapError("index diverged from the data files", ErrCorrupted)
+
+// IndexDivergenceError records the index lookup found diverging from the data files by ParanoidChecks.
+// It unwraps to ErrIndexDivergence.
+type IndexDivergenceError struct {
+	Bucket  string
+	Key     []byte
+	FileID  int64
+	DataPos uint64
+	Err     error
+}
+
+// Error implements the error interface.
+func (e *IndexDivergenceError) Error() string {
+	msg := fmt.Sprintf("bucket %s, key %s: index diverged from the data file %d at %d", e.Bucket, e.Key, e.FileID, e.DataPos)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+// Unwrap returns ErrIndexDivergence.
+func (e *IndexDivergenceError) Unwrap() error {
+	return ErrIndexDivergence
+}
+
+// indexRepair represents the value of the record read from the data file, replacing the diverged one in the index.
+type indexRepair struct {
+	bucket string
+	key    []byte
+	hint   *Hint
+	entry  *Entry
+}
+
+// indexRepairs records the repairs found by the readers, applied by the next commit,
+// which is the only one modifying the index.
+type indexRepairs struct {
+	mu      sync.Mutex
+	pending []indexRepair
+}
+
+// checkRecord returns the entry of the record r of the key in the bucket read from the
+// data file. It returns an *IndexDivergenceError if it is not the entry of the key,
+// and queues the repair of r if the value in RAM diverges from the data file.
+func (tx *Tx) checkRecord(bucket string, key []byte, r *Record) (*Entry, error) {
+	divergence := &IndexDivergenceError{Bucket: bucket, Key: key, FileID: r.H.fileID, DataPos: r.H.dataPos}
+
+	df, err := tx.db.openDataFile(r.H.fileID, tx.db.opt.RWMode)
+	if err != nil {
+		divergence.Err = err
+		tx.db.health.recordDivergence(divergence)
+		return nil, divergence
+	}
+	defer df.rwManager.Close()
+
+	item, err := df.ReadAt(int(r.H.dataPos))
+	if err != nil {
+		divergence.Err = err
+		tx.db.health.recordDivergence(divergence)
+		return nil, divergence
+	}
+
+	if item == nil || string(item.Meta.bucket) != bucket || !bytes.Equal(item.Key, key) ||
+		item.Meta.txID != r.H.meta.txID || item.Meta.Flag != r.H.meta.Flag {
+		tx.db.health.recordDivergence(divergence)
+		return nil, divergence
+	}
+
+	if r.E != nil && !bytes.Equal(r.E.Value, item.Value) {
+		tx.db.health.recordDivergence(divergence)
+		tx.db.repairs.add(indexRepair{bucket: bucket, key: key, hint: r.H, entry: item})
+	}
+
+	return item, nil
+}
+
+func (rs *indexRepairs) add(repair indexRepair) {
+	rs.mu.Lock()
+	rs.pending = append(rs.pending, repair)
+	rs.mu.Unlock()
+}
+
+// applyIndexRepairs replaces the diverged values of the index by the ones read from the
+// data files, if the records were not updated since. It must be called with db.mu held.
+func (db *DB) applyIndexRepairs() {
+	db.repairs.mu.Lock()
+	pending := db.repairs.pending
+	db.repairs.pending = nil
+	db.repairs.mu.Unlock()
+
+	for _, repair := range pending {
+		idx, ok := db.bptreeIdx(repair.bucket)
+		if !ok {
+			continue
+		}
+
+		r, err := idx.Find(repair.key)
+		if err != nil || r.H != repair.hint || r.E == nil {
+			continue
+		}
+
+		r.E = repair.entry
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDB_ParanoidChecks(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestparanoid", true)
+	opt.ParanoidChecks = true
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bucket := "bucket"
+	for _, key := range []string{"key1", "key2"} {
+		key := key
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(key), []byte("val_"+key), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(key string) (*Entry, error) {
+		var e *Entry
+		err := db.View(func(tx *Tx) error {
+			var err error
+			e, err = tx.Get(bucket, []byte(key))
+			return err
+		})
+		return e, err
+	}
+
+	// a value in RAM diverging from the data file.
+	r, err := db.BPTreeIdx[bucket].Find([]byte("key1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.E = &Entry{Key: r.E.Key, Value: []byte("wrong"), Meta: r.E.Meta}
+
+	e, err := get("key1")
+	if err != nil || string(e.Value) != "val_key1" {
+		t.Fatalf("TestDB_ParanoidChecks err: got %v, %v", e, err)
+	}
+	if n := db.Health().IndexDivergences; n != 1 {
+		t.Errorf("TestDB_ParanoidChecks err: %d divergences", n)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key3"), []byte("val_key3"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := db.BPTreeIdx[bucket].Find([]byte("key1")); string(r.E.Value) != "val_key1" {
+		t.Errorf("TestDB_ParanoidChecks err: value not repaired, got %s", r.E.Value)
+	}
+
+	// an index pointing to the entry of another key.
+	r1, _ := db.BPTreeIdx[bucket].Find([]byte("key1"))
+	r2, _ := db.BPTreeIdx[bucket].Find([]byte("key2"))
+	h := *r2.H
+	h.dataPos = r1.H.dataPos
+	r2.H = &h
+
+	_, err = get("key2")
+	var divergence *IndexDivergenceError
+	if !errors.As(err, &divergence) || !errors.Is(err, ErrIndexDivergence) || !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("expected an IndexDivergenceError, got %v", err)
+	}
+	if divergence.Bucket != bucket || string(divergence.Key) != "key2" {
+		t.Errorf("TestDB_ParanoidChecks err: got %+v", divergence)
+	}
+
+	health := db.Health()
+	if health.IndexDivergences != 2 || !errors.Is(health.LastError, ErrIndexDivergence) {
+		t.Errorf("TestDB_ParanoidChecks err: got health %+v", health)
+	}
+}
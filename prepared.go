@@ -0,0 +1,212 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// PreparedDir is the directory of the data dir in which the prepared transactions are stored.
+const PreparedDir = "prepared"
+
+// PreparedSuffix is the suffix of the files of the prepared transactions.
+const PreparedSuffix = ".prepared"
+
+var (
+	// ErrTxNotPrepared is returned when preparing a transaction not begun by BeginPrepared.
+	ErrTxNotPrepared = errors.New("tx not begun by BeginPrepared")
+
+	// ErrPreparedTxNotFound is returned when the prepared transaction does not exist.
+	ErrPreparedTxNotFound = errors.New("prepared tx not found")
+)
+
+// BeginPrepared opens a new writable transaction for a two-phase commit. Prepare
+// persists its writes and ends it, so that CommitPrepared applies them, or
+// RollbackPrepared discards them, even after a restart. It can also be committed
+// directly by Commit.
+func (db *DB) BeginPrepared() (*Tx, error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.prepared = true
+
+	return tx, nil
+}
+
+// Prepare persists the writes of the transaction, releases the write lock, and returns
+// the ID of the prepared transaction. The writes are not visible until CommitPrepared.
+// Other transactions may write the same keys meanwhile: CommitPrepared applies the
+// prepared writes after them.
+func (tx *Tx) Prepare() (uint64, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return 0, err
+	}
+
+	if !tx.prepared {
+		return 0, ErrTxNotPrepared
+	}
+
+	var buf []byte
+	for _, e := range tx.pendingWrites {
+		buf = append(buf, e.Encode()...)
+	}
+
+	db := tx.db
+	if err := db.storage.MkdirAll(db.getPreparedDir(), db.dirPerm()); err != nil {
+		return 0, err
+	}
+
+	if err := db.writeFileAtomic(db.getPreparedPath(tx.id), buf, true); err != nil {
+		return 0, err
+	}
+
+	db.openTxs.remove(tx)
+	tx.unlock()
+	tx.db = nil
+	tx.pendingWrites = nil
+
+	return tx.id, nil
+}
+
+// CommitPrepared applies the writes of the prepared transaction id in a new transaction.
+// Committing a transaction again, e.g. after a crash, applies its writes only once.
+// It returns ErrPreparedTxNotFound if the transaction is not prepared.
+func (db *DB) CommitPrepared(id uint64) error {
+	if err := db.checkClosed(); err != nil {
+		return err
+	}
+
+	buf, err := db.readFile(db.getPreparedPath(id))
+	if os.IsNotExist(err) {
+		return ErrPreparedTxNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	entries, err := decodePreparedEntries(buf)
+	if err != nil {
+		return err
+	}
+
+	opID := []byte("prepared:" + strconv2.Int64ToStr(int64(id)))
+	err = db.Update(func(tx *Tx) error {
+		applied, err := tx.isApplied(opID)
+		if err != nil || applied {
+			return err
+		}
+
+		if err := tx.putEntries(entries); err != nil {
+			return err
+		}
+
+		return tx.Put(IdempotencyBucket, opID, nil, db.opt.IdempotencyTTL)
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.storage.Remove(db.getPreparedPath(id))
+}
+
+// RollbackPrepared discards the writes of the prepared transaction id.
+// It returns ErrPreparedTxNotFound if the transaction is not prepared.
+func (db *DB) RollbackPrepared(id uint64) error {
+	if db.opt.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if err := db.checkClosed(); err != nil {
+		return err
+	}
+
+	err := db.storage.Remove(db.getPreparedPath(id))
+	if os.IsNotExist(err) {
+		return ErrPreparedTxNotFound
+	}
+
+	return err
+}
+
+// PreparedTxIDs returns the IDs of the prepared transactions neither committed nor rolled
+// back, e.g. for resolving them with the coordinator after a restart.
+func (db *DB) PreparedTxIDs() ([]uint64, error) {
+	if err := db.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	files, err := db.readDir(db.getPreparedDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, f := range files {
+		name := f.Name()
+		if path.Ext(name) != PreparedSuffix {
+			continue
+		}
+
+		id, err := strconv2.StrToInt64(strings.TrimSuffix(name, PreparedSuffix))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint64(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+// decodePreparedEntries decodes the entries of a prepared transaction.
+func decodePreparedEntries(buf []byte) ([]*Entry, error) {
+	r := &DataFileReader{r: bufio.NewReader(bytes.NewReader(buf))}
+
+	var entries []*Entry
+	for {
+		e, err := r.Next()
+		if errors.Is(err, io.EOF) || (err == nil && e == nil) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+func (db *DB) getPreparedDir() string {
+	return db.opt.Dir + "/" + PreparedDir
+}
+
+func (db *DB) getPreparedPath(id uint64) string {
+	return db.getPreparedDir() + "/" + strconv2.Int64ToStr(int64(id)) + PreparedSuffix
+}
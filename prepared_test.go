@@ -0,0 +1,142 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestDB_CommitPrepared(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestprepared", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Prepare(); err != ErrTxNotPrepared {
+		t.Errorf("expected ErrTxNotPrepared, got %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	prepare := func(value string) uint64 {
+		tx, err := db.BeginPrepared()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put("bucket", []byte("key"), []byte(value), Persistent); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.RPush("list", []byte("key"), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		id, err := tx.Prepare()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != ErrDBClosed {
+			t.Errorf("expected ErrDBClosed, got %v", err)
+		}
+		return id
+	}
+
+	committed, rolledBack := prepare("val1"), prepare("val2")
+
+	check := func(want string, items int) {
+		err := db.View(func(tx *Tx) error {
+			e, err := tx.Get("bucket", []byte("key"))
+			if want == "" {
+				if err == nil {
+					t.Errorf("TestDB_CommitPrepared err: prepared writes visible, got %s", e.Value)
+				}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if string(e.Value) != want {
+				t.Errorf("TestDB_CommitPrepared err: got %s, want %s", e.Value, want)
+			}
+			if n, _ := tx.LSize("list", []byte("key")); n != items {
+				t.Errorf("TestDB_CommitPrepared err: got %d list items, want %d", n, items)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check("", 0)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ids, err := db.PreparedTxIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != committed || ids[1] != rolledBack {
+		t.Fatalf("TestDB_CommitPrepared err: got prepared %v, want [%d %d]", ids, committed, rolledBack)
+	}
+
+	// a crash after the commit before the file is removed does not apply the writes twice.
+	buf, err := ioutil.ReadFile(db.getPreparedPath(committed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CommitPrepared(committed); err != nil {
+		t.Fatal(err)
+	}
+	check("val1", 1)
+
+	if err := ioutil.WriteFile(db.getPreparedPath(committed), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CommitPrepared(committed); err != nil {
+		t.Fatal(err)
+	}
+	check("val1", 1)
+
+	if err := db.CommitPrepared(committed); err != ErrPreparedTxNotFound {
+		t.Errorf("expected ErrPreparedTxNotFound, got %v", err)
+	}
+
+	if err := db.RollbackPrepared(rolledBack); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RollbackPrepared(rolledBack); err != ErrPreparedTxNotFound {
+		t.Errorf("expected ErrPreparedTxNotFound, got %v", err)
+	}
+	check("val1", 1)
+
+	if ids, _ := db.PreparedTxIDs(); len(ids) != 0 {
+		t.Errorf("TestDB_CommitPrepared err: got prepared %v", ids)
+	}
+}
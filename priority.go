@@ -0,0 +1,92 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "sync"
+
+// Priority represents the priority of a writable transaction waiting for the write lock.
+type Priority int
+
+const (
+	// PriorityHigh represents the default priority, e.g. of interactive requests.
+	PriorityHigh Priority = iota
+
+	// PriorityLow represents the priority of maintenance work, e.g. bulk loads and merges.
+	// A low-priority transaction only gets the write lock when no high-priority one is waiting.
+	PriorityLow
+)
+
+// TxOptions represents the options of a transaction.
+type TxOptions struct {
+	// Priority represents the priority of a writable transaction.
+	// Default Priority is PriorityHigh.
+	Priority Priority
+}
+
+// writeLock represents the lock serializing the writers, which lets the
+// high-priority writers go before the low-priority ones.
+// The zero value is an unlocked writeLock.
+type writeLock struct {
+	mu          sync.Mutex // guards the fields
+	cond        *sync.Cond
+	held        bool
+	highWaiting int
+}
+
+// wait waits for the lock to change. It must be called with l.mu held.
+func (l *writeLock) wait() {
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mu)
+	}
+
+	l.cond.Wait()
+}
+
+// Lock locks l with PriorityHigh.
+func (l *writeLock) Lock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.highWaiting++
+	for l.held {
+		l.wait()
+	}
+	l.highWaiting--
+
+	l.held = true
+}
+
+// LockLow locks l with PriorityLow, waiting while a high-priority writer holds or waits for it.
+func (l *writeLock) LockLow() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.held || l.highWaiting > 0 {
+		l.wait()
+	}
+
+	l.held = true
+}
+
+// Unlock unlocks l.
+func (l *writeLock) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.held = false
+	if l.cond != nil {
+		l.cond.Broadcast()
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteLock_Priority(t *testing.T) {
+	var l writeLock
+	l.Lock()
+
+	order := make(chan Priority, 2)
+
+	go func() {
+		l.LockLow()
+		order <- PriorityLow
+		l.Unlock()
+	}()
+
+	// let the low-priority writer wait first.
+	time.Sleep(50 * time.Millisecond)
+
+	go func() {
+		l.Lock()
+		order <- PriorityHigh
+		l.Unlock()
+	}()
+
+	// wait for the high-priority writer to be waiting.
+	for {
+		l.mu.Lock()
+		waiting := l.highWaiting
+		l.mu.Unlock()
+		if waiting > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	l.Unlock()
+
+	if p := <-order; p != PriorityHigh {
+		t.Fatal("expected the high-priority writer to get the lock first")
+	}
+
+	if p := <-order; p != PriorityLow {
+		t.Fatal("expected the low-priority writer to get the lock")
+	}
+}
+
+func TestDB_UpdateWithOptions(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestpriority", true)
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.UpdateWithOptions(TxOptions{Priority: PriorityLow}, func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.UpdateWithOptions(TxOptions{}, nil); err != ErrFn {
+		t.Fatalf("expected ErrFn, got %v", err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get("bucket", []byte("key"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// PurgeReport represents the result of Purge, the evidence of the erasure.
+type PurgeReport struct {
+	// KeysDeleted is the number of live keys deleted.
+	KeysDeleted int
+
+	// EntriesErased is the number of entries of the keys, every version and
+	// deletion, removed from the data files.
+	EntriesErased int
+
+	// FilesRewritten are the IDs of the data files merged.
+	FilesRewritten []int64
+
+	// Remaining is the number of entries of the keys still in the data files after
+	// the purge, i.e. written while purging. It is 0 when the erasure is complete.
+	Remaining int
+}
+
+// Purge deletes the keys with the prefix in the bucket, and merges every data file
+// holding an entry of them, so that their keys and values are physically removed
+// from the data files, not only deleted. If such a file holds list or sorted set
+// entries, all the data files are merged like Merge. Only the key/value entries are
+// purged. The backups and the copies of the files made by the file system are not.
+func (db *DB) Purge(bucket string, prefix []byte) (*PurgeReport, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if db.opt.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	report := &PurgeReport{}
+
+	// delete the keys, then seal the active file so that every entry is in a sealed file.
+	err := db.Update(func(tx *Tx) error {
+		idx, ok := db.bptreeIdx(bucket)
+		if !ok {
+			return nil
+		}
+
+		_, keys, pointers := idx.prefixScan(prefix, 0)
+		for i, p := range pointers {
+			if r := p.(*Record); r.H.meta.Flag != DataSetFlag {
+				continue
+			}
+			if err := tx.Delete(bucket, keys[i]); err != nil {
+				return err
+			}
+			report.KeysDeleted++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		if db.ActiveFile.writeOff == 0 {
+			return nil
+		}
+
+		return tx.rotateActiveFile()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts, ordered, activeFileID, err := db.countPurgeEntries(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// the entries written to the active file since it was sealed are not purged.
+	delete(counts, activeFileID)
+	if len(counts) == 0 {
+		return report, nil
+	}
+
+	var fIDs []int
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	for _, fID := range dataFileIds {
+		if _, ok := counts[int64(fID)]; (ok || ordered) && int64(fID) < activeFileID {
+			fIDs = append(fIDs, fID)
+		}
+	}
+
+	if err := db.startMerge(); err != nil {
+		return nil, err
+	}
+	err = db.mergeFiles(context.Background(), fIDs, !ordered, 1, nil)
+	db.endMerge()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fID := range fIDs {
+		report.FilesRewritten = append(report.FilesRewritten, int64(fID))
+	}
+
+	for _, n := range counts {
+		report.EntriesErased += n
+	}
+
+	remaining, _, _, err := db.countPurgeEntries(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range remaining {
+		report.Remaining += n
+	}
+
+	return report, nil
+}
+
+// countPurgeEntries returns the number of key/value entries with the prefix in the bucket
+// of every data file holding some, if one of them holds list or sorted set entries, and
+// the ID of the active file.
+func (db *DB) countPurgeEntries(bucket string, prefix []byte) (counts map[int64]int, ordered bool, activeFileID int64, err error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	if err := db.loadFileCounters(); err != nil {
+		return nil, false, 0, err
+	}
+
+	counts = make(map[int64]int)
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	for _, fID := range dataFileIds {
+		n, err := db.countFilePurgeEntries(int64(fID), bucket, prefix)
+		if err != nil {
+			return nil, false, 0, err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		counts[int64(fID)] = n
+		if c, ok := db.fileCounters[int64(fID)]; ok && c.orderedEntries > 0 && int64(fID) != db.MaxFileID {
+			ordered = true
+		}
+	}
+
+	return counts, ordered, db.MaxFileID, nil
+}
+
+// countFilePurgeEntries returns the number of key/value entries with the prefix in the bucket of the data file fID.
+func (db *DB) countFilePurgeEntries(fID int64, bucket string, prefix []byte) (int, error) {
+	f, err := db.openDataFile(fID, db.opt.RWMode)
+	if err != nil {
+		return 0, err
+	}
+	defer f.rwManager.Close()
+
+	n := 0
+	r := db.newDataFileReader(f)
+	for {
+		entry, err := r.Next()
+		if errors.Is(err, io.EOF) || (err == nil && entry == nil) {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if entry.Meta.ds == DataStructureBPTree && string(entry.Meta.bucket) == bucket && bytes.HasPrefix(entry.Key, prefix) {
+			n++
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDB_Purge(t *testing.T) {
+	for _, withList := range []bool{false, true} {
+		InitOpt("/tmp/nutsdbtestpurge", true)
+		opt.SegmentSize = 1024
+
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for version := 0; version < 2; version++ {
+			for i := 0; i < 10; i++ {
+				for _, user := range []string{"user1", "user2"} {
+					key := []byte(fmt.Sprintf("%s:%d", user, i))
+					if err := db.Update(func(tx *Tx) error {
+						return tx.Put("users", key, []byte(fmt.Sprintf("%s%080d", user, version)), Persistent)
+					}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if withList {
+				if err := db.Update(func(tx *Tx) error {
+					return tx.RPush("list", []byte("key"), []byte(fmt.Sprint(version)))
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		report, err := db.Purge("users", []byte("user1:"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.KeysDeleted != 10 || report.EntriesErased != 30 || report.Remaining != 0 || len(report.FilesRewritten) == 0 {
+			t.Errorf("TestDB_Purge err: got report %+v", report)
+		}
+
+		files, _ := ioutil.ReadDir(opt.Dir)
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), DataSuffix) {
+				continue
+			}
+			b, err := ioutil.ReadFile(opt.Dir + "/" + f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(b, []byte("user1")) {
+				t.Errorf("TestDB_Purge err: %s holds the purged bytes", f.Name())
+			}
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.View(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				if _, err := tx.Get("users", []byte(fmt.Sprintf("user1:%d", i))); err == nil {
+					t.Errorf("TestDB_Purge err: user1:%d not purged", i)
+				}
+				e, err := tx.Get("users", []byte(fmt.Sprintf("user2:%d", i)))
+				if err != nil || string(e.Value) != fmt.Sprintf("user2%080d", 1) {
+					t.Errorf("TestDB_Purge err: user2:%d: %v", i, err)
+				}
+			}
+			if withList {
+				items, err := tx.LRange("list", []byte("key"), 0, -1)
+				if err != nil || len(items) != 2 || string(items[0]) != "0" {
+					t.Errorf("TestDB_Purge err: got list %q, %v", items, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report, err := db.Purge("users", []byte("user1:")); err != nil || report.EntriesErased != 0 {
+			t.Errorf("TestDB_Purge err: purging again got %+v, %v", report, err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
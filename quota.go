@@ -0,0 +1,127 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDiskQuotaExceeded is returned when a commit would grow the data files over Options.MaxDiskUsage.
+var ErrDiskQuotaExceeded = errors.New("disk quota exceeded")
+
+// DiskQuotaError records the commit refused by Options.MaxDiskUsage.
+// It unwraps to ErrDiskQuotaExceeded.
+type DiskQuotaError struct {
+	// Limit is Options.MaxDiskUsage.
+	Limit int64
+
+	// Usage is the size in bytes of the data files before the commit.
+	Usage int64
+
+	// Size is the size in bytes of the entries of the commit.
+	Size int64
+
+	// Reclaimable is the estimated size in bytes a Merge would free. Only the
+	// live entries of the BPTree buckets are counted, so it is an upper bound
+	// when the db holds sets, sorted sets or lists, or buckets not loaded yet
+	// by Options.LazyIndexLoad.
+	Reclaimable int64
+}
+
+// Error implements the error interface.
+func (e *DiskQuotaError) Error() string {
+	return fmt.Sprintf("disk quota exceeded: %d bytes used, %d to write, limit %d (about %d reclaimable by merge)",
+		e.Usage, e.Size, e.Limit, e.Reclaimable)
+}
+
+// Unwrap returns ErrDiskQuotaExceeded.
+func (e *DiskQuotaError) Unwrap() error {
+	return ErrDiskQuotaExceeded
+}
+
+// diskUsage returns the size in bytes of the data files, counting SegmentSize
+// for every sealed file and the write offset for the active one.
+// It must be called with the db.writeMu lock held.
+func (db *DB) diskUsage() int64 {
+	if db.ActiveFile == nil {
+		return db.sealedSize
+	}
+
+	return db.sealedSize + db.ActiveFile.writeOff
+}
+
+// reclaimableSize estimates the size in bytes of the sealed data files a Merge would free,
+// i.e. all of it but the live entries of the BPTree buckets. The buckets whose indexes are
+// not built yet by LazyIndexLoad are not counted, so that a commit does not build them.
+// It must be called with the db.mu lock held.
+func (db *DB) reclaimableSize() int64 {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return 0
+	}
+
+	var live int64
+	for bucket, t := range db.BPTreeIdx {
+		if lb, ok := db.lazyBuckets[bucket]; ok && !lb.loaded.Load() {
+			continue
+		}
+
+		_, _, pointers := t.getAll()
+		for _, p := range pointers {
+			r, ok := p.(*Record)
+			if !ok || r.H == nil || r.H.meta == nil || r.H.fileID == db.MaxFileID {
+				continue
+			}
+
+			if r.H.meta.Flag == DataDeleteFlag || r.IsExpired() {
+				continue
+			}
+
+			live += int64(DataEntryHeaderSize + r.H.meta.keySize + r.H.meta.valueSize + r.H.meta.bucketSize)
+		}
+	}
+
+	if live > db.sealedSize {
+		return 0
+	}
+
+	return db.sealedSize - live
+}
+
+// checkDiskQuota returns a *DiskQuotaError if committing the pending writes would
+// grow the data files over Options.MaxDiskUsage. The writes of a merge are allowed,
+// as the merge frees the space of the merged files.
+func (tx *Tx) checkDiskQuota() error {
+	limit := tx.db.opt.MaxDiskUsage
+	if limit <= 0 || tx.merge {
+		return nil
+	}
+
+	var size int64
+	for _, e := range tx.pendingWrites {
+		size += e.Size()
+	}
+
+	usage := tx.db.diskUsage()
+	if usage+size <= limit {
+		return nil
+	}
+
+	tx.db.mu.RLock()
+	reclaimable := tx.db.reclaimableSize()
+	tx.db.mu.RUnlock()
+
+	return &DiskQuotaError{Limit: limit, Usage: usage, Size: size, Reclaimable: reclaimable}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDB_MaxDiskUsage(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestquota", true)
+	opt.SegmentSize = 1024
+	opt.MaxDiskUsage = 4 * 1024
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// overwrite the same key until the quota is hit, so most of the data is reclaimable.
+	put := func() error {
+		return db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), make([]byte, 100), Persistent)
+		})
+	}
+
+	var quotaErr *DiskQuotaError
+	for i := 0; ; i++ {
+		err := put()
+		if err == nil {
+			if i > 1000 {
+				t.Fatal("expected the quota to be exceeded")
+			}
+			continue
+		}
+
+		if !errors.Is(err, ErrDiskQuotaExceeded) || !errors.As(err, &quotaErr) {
+			t.Fatalf("expected a DiskQuotaError, got %v", err)
+		}
+		break
+	}
+
+	if quotaErr.Limit != opt.MaxDiskUsage || quotaErr.Usage+quotaErr.Size <= quotaErr.Limit {
+		t.Fatalf("unexpected quota error %+v", quotaErr)
+	}
+
+	if quotaErr.Reclaimable < 2*opt.SegmentSize {
+		t.Fatalf("expected the overwritten entries to be reclaimable, got %+v", quotaErr)
+	}
+
+	// a full Merge rewrites every file into a new one, merge the sealed files only.
+	if err := db.MergeWithOptions(MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := put(); err != nil {
+		t.Fatalf("expected the merge to free space, got %v", err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get("bucket", []byte("key"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the usage is restored when reopening.
+	opt.MaxDiskUsage = 1
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := put(); !errors.Is(err, ErrDiskQuotaExceeded) {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got %v", err)
+	}
+
+	// only the writes of the merge are allowed while it runs.
+	if err := db.startMerge(); err != nil {
+		t.Fatal(err)
+	}
+	err = put()
+	db.endMerge()
+	if !errors.Is(err, ErrDiskQuotaExceeded) {
+		t.Fatalf("expected ErrDiskQuotaExceeded during a merge, got %v", err)
+	}
+}
+
+func TestDB_MaxDiskUsageLazyIndexLoad(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestquotalazy", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opt.LazyIndexLoad = true
+	opt.MaxDiskUsage = 1
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the quota check of a commit does not build the indexes.
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("other", []byte("key"), []byte("val"), Persistent)
+	}); !errors.Is(err, ErrDiskQuotaExceeded) {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got %v", err)
+	}
+	if n := db.LazyBuckets(); n != 1 {
+		t.Errorf("err LazyBuckets after a commit over the quota. got %d want 1", n)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package raftstore implements hashicorp/raft's LogStore and StableStore on top of nutsdb.
+package raftstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/xujiajun/nutsdb"
+)
+
+const (
+	// LogsBucket is the bucket that stores the raft logs.
+	LogsBucket = "raft_logs"
+
+	// StableBucket is the bucket that stores the raft stable values.
+	StableBucket = "raft_stable"
+
+	// logHeaderSize is the size of Term, Type, AppendedAt and the Data length of an encoded log.
+	logHeaderSize = 8 + 1 + 8 + 4
+)
+
+var (
+	// ErrKeyNotFound is returned when a stable key is not found.
+	// Its message is the one checked by raft.
+	ErrKeyNotFound = errors.New("not found")
+
+	// ErrLogCorrupted is returned when a stored log or its index can not be decoded.
+	ErrLogCorrupted = errors.New("raft log corrupted")
+)
+
+var (
+	_ raft.LogStore    = (*Store)(nil)
+	_ raft.StableStore = (*Store)(nil)
+)
+
+// Store represents the raft LogStore and StableStore backed by a nutsdb DB.
+// Each log is stored under its big-endian index so that the B+ tree keeps them in order.
+type Store struct {
+	db         *nutsdb.DB
+	mu         sync.Mutex
+	firstIndex uint64
+	lastIndex  uint64
+}
+
+// New returns a newly initialized Store at given db.
+func New(db *nutsdb.DB) (*Store, error) {
+	s := &Store{db: db}
+
+	err := db.View(func(tx *nutsdb.Tx) error {
+		entries, err := tx.GetAll(LogsBucket)
+		if errors.Is(err, nutsdb.ErrBucketEmpty) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if len(e.Key) != 8 {
+				return ErrLogCorrupted
+			}
+		}
+
+		s.firstIndex = binary.BigEndian.Uint64(entries[0].Key)
+		s.lastIndex = binary.BigEndian.Uint64(entries[len(entries)-1].Key)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func uint64ToBytes(u uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, u)
+	return buf
+}
+
+// FirstIndex returns the first index written. 0 for no entries.
+func (s *Store) FirstIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.firstIndex, nil
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (s *Store) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastIndex, nil
+}
+
+// GetLog gets a log entry at a given index.
+func (s *Store) GetLog(index uint64, log *raft.Log) error {
+	return s.db.View(func(tx *nutsdb.Tx) error {
+		e, err := tx.Get(LogsBucket, uint64ToBytes(index))
+		if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrBucketNotFound) {
+			return raft.ErrLogNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("raftstore: get log %d: %w", index, err)
+		}
+
+		// the value is only valid for the life of the transaction.
+		return decodeLog(index, append([]byte(nil), e.Value...), log)
+	})
+}
+
+// StoreLog stores a log entry.
+func (s *Store) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries in one transaction.
+func (s *Store) StoreLogs(logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Update(func(tx *nutsdb.Tx) error {
+		for _, log := range logs {
+			if err := tx.Put(LogsBucket, uint64ToBytes(log.Index), encodeLog(log), nutsdb.Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, log := range logs {
+		if s.firstIndex == 0 || log.Index < s.firstIndex {
+			s.firstIndex = log.Index
+		}
+		if log.Index > s.lastIndex {
+			s.lastIndex = log.Index
+		}
+	}
+
+	return nil
+}
+
+// DeleteRange deletes the logs in the range [min, max] in one transaction, the stored
+// ones being found by a range scan of their indexes.
+func (s *Store) DeleteRange(min, max uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the ranges out of the stored logs leave their bounds as is.
+	if s.lastIndex == 0 || min > max || max < s.firstIndex || min > s.lastIndex {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *nutsdb.Tx) error {
+		entries, err := tx.RangeScan(LogsBucket, uint64ToBytes(min), uint64ToBytes(max))
+		if errors.Is(err, nutsdb.ErrRangeScan) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if err := tx.Delete(LogsBucket, e.Key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case min <= s.firstIndex && max >= s.lastIndex:
+		s.firstIndex, s.lastIndex = 0, 0
+	case min <= s.firstIndex:
+		s.firstIndex = max + 1
+	case max >= s.lastIndex:
+		s.lastIndex = min - 1
+	}
+
+	return nil
+}
+
+// Set stores a stable value.
+func (s *Store) Set(key []byte, val []byte) error {
+	return s.db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(StableBucket, key, val, nutsdb.Persistent)
+	})
+}
+
+// Get returns the stable value for key, or ErrKeyNotFound.
+func (s *Store) Get(key []byte) (val []byte, err error) {
+	err = s.db.View(func(tx *nutsdb.Tx) error {
+		e, err := tx.Get(StableBucket, key)
+		if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrBucketNotFound) {
+			return ErrKeyNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("raftstore: get %q: %w", key, err)
+		}
+		val = append([]byte(nil), e.Value...)
+		return nil
+	})
+
+	return
+}
+
+// SetUint64 stores a stable uint64 value.
+func (s *Store) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, uint64ToBytes(val))
+}
+
+// GetUint64 returns the stable uint64 value for key, or ErrKeyNotFound.
+func (s *Store) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(val) != 8 {
+		return 0, ErrLogCorrupted
+	}
+
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// encodeLog returns the slice after the log be encoded.
+//
+//	|------------------------------------------------------------------|
+//	|  term  | type  | appendedAt | dataSize |  data  |   extensions   |
+//	|------------------------------------------------------------------|
+//	| uint64 | uint8 |   int64    |  uint32  | []byte |     []byte     |
+//	|------------------------------------------------------------------|
+func encodeLog(log *raft.Log) []byte {
+	buf := make([]byte, logHeaderSize+len(log.Data)+len(log.Extensions))
+
+	binary.BigEndian.PutUint64(buf[0:8], log.Term)
+	buf[8] = byte(log.Type)
+
+	var appendedAt int64
+	if !log.AppendedAt.IsZero() {
+		appendedAt = log.AppendedAt.UnixNano()
+	}
+	binary.BigEndian.PutUint64(buf[9:17], uint64(appendedAt))
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(log.Data)))
+
+	copy(buf[logHeaderSize:], log.Data)
+	copy(buf[logHeaderSize+len(log.Data):], log.Extensions)
+
+	return buf
+}
+
+// decodeLog decodes buf into log at given index.
+func decodeLog(index uint64, buf []byte, log *raft.Log) error {
+	if len(buf) < logHeaderSize {
+		return ErrLogCorrupted
+	}
+
+	dataSize := int(binary.BigEndian.Uint32(buf[17:21]))
+	if len(buf) < logHeaderSize+dataSize {
+		return ErrLogCorrupted
+	}
+
+	log.Index = index
+	log.Term = binary.BigEndian.Uint64(buf[0:8])
+	log.Type = raft.LogType(buf[8])
+	log.AppendedAt = time.Time{}
+	if appendedAt := int64(binary.BigEndian.Uint64(buf[9:17])); appendedAt != 0 {
+		log.AppendedAt = time.Unix(0, appendedAt)
+	}
+	log.Data = nil
+	if dataSize > 0 {
+		log.Data = buf[logHeaderSize : logHeaderSize+dataSize]
+	}
+	log.Extensions = nil
+	if len(buf) > logHeaderSize+dataSize {
+		log.Extensions = buf[logHeaderSize+dataSize:]
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/xujiajun/nutsdb"
+)
+
+func openStore(t *testing.T, clean bool) (*nutsdb.DB, *Store) {
+	fileDir := "/tmp/nutsdbtestraftstore"
+	if clean {
+		if err := os.RemoveAll(fileDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opt := nutsdb.DefaultOptions
+	opt.Dir = fileDir
+	opt.SegmentSize = 64 * 1024
+
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, s
+}
+
+func TestStore_Logs(t *testing.T) {
+	db, s := openStore(t, true)
+
+	var logs []*raft.Log
+	for i := uint64(1); i <= 10; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Type: raft.LogCommand, Data: []byte("data")})
+	}
+
+	if err := s.StoreLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	var log raft.Log
+	if err := s.GetLog(5, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Index != 5 || log.Term != 1 || string(log.Data) != "data" {
+		t.Errorf("err GetLog. got %+v", log)
+	}
+
+	if err := s.DeleteRange(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GetLog(2, &log); err != raft.ErrLogNotFound {
+		t.Errorf("err GetLog after DeleteRange. got %v want %v", err, raft.ErrLogNotFound)
+	}
+
+	db.Close()
+
+	db, s = openStore(t, false)
+	defer db.Close()
+
+	if first, _ := s.FirstIndex(); first != 4 {
+		t.Errorf("err FirstIndex. got %d want 4", first)
+	}
+	if last, _ := s.LastIndex(); last != 10 {
+		t.Errorf("err LastIndex. got %d want 10", last)
+	}
+
+	if err := s.DeleteRange(8, 10); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := s.LastIndex(); last != 7 {
+		t.Errorf("err LastIndex after DeleteRange. got %d want 7", last)
+	}
+
+	// a range without stored logs deletes nothing.
+	if err := s.DeleteRange(100, 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRange(5, 6); err != nil {
+		t.Fatal(err)
+	}
+	for i, found := range map[uint64]bool{4: true, 5: false, 6: false, 7: true} {
+		if err := s.GetLog(i, &log); (err == nil) != found {
+			t.Errorf("err GetLog %d after DeleteRange. got %v", i, err)
+		}
+	}
+}
+
+func TestStore_NewError(t *testing.T) {
+	db, _ := openStore(t, true)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(db); err != nutsdb.ErrDBClosed {
+		t.Errorf("err New on a closed DB. got %v want %v", err, nutsdb.ErrDBClosed)
+	}
+
+	db, _ = openStore(t, false)
+	defer db.Close()
+	if err := db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(LogsBucket, []byte("index"), []byte("log"), nutsdb.Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(db); err != ErrLogCorrupted {
+		t.Errorf("err New with a corrupt index. got %v want %v", err, ErrLogCorrupted)
+	}
+}
+
+func TestStore_Stable(t *testing.T) {
+	db, s := openStore(t, true)
+	defer db.Close()
+
+	if _, err := s.Get([]byte("foo")); err != ErrKeyNotFound {
+		t.Errorf("err Get. got %v want %v", err, ErrKeyNotFound)
+	}
+
+	if err := s.SetUint64([]byte("CurrentTerm"), 42); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := s.GetUint64([]byte("CurrentTerm")); err != nil || v != 42 {
+		t.Errorf("err GetUint64. got %d %v", v, err)
+	}
+}
+
+func TestStore_Bounds(t *testing.T) {
+	db, s := openStore(t, true)
+	defer db.Close()
+
+	var logs []*raft.Log
+	for i := uint64(10); i <= 20; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Type: raft.LogCommand, Data: []byte("data")})
+	}
+	if err := s.StoreLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	// the ranges out of the stored logs leave the bounds as is.
+	for _, r := range [][2]uint64{{1, 5}, {30, 40}} {
+		if err := s.DeleteRange(r[0], r[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, _ := s.FirstIndex()
+	last, _ := s.LastIndex()
+	if first != 10 || last != 20 {
+		t.Errorf("err bounds after DeleteRange out of the logs. got %d %d want 10 20", first, last)
+	}
+
+	// the logs returned do not share the memory of the database.
+	var log raft.Log
+	if err := s.GetLog(last, &log); err != nil {
+		t.Fatal(err)
+	}
+	copy(log.Data, "XXXX")
+	if err := s.GetLog(last, &log); err != nil || string(log.Data) != "data" {
+		t.Errorf("err GetLog after modifying a log returned. got %s %v", log.Data, err)
+	}
+
+	if err := s.Set([]byte("key"), []byte("val")); err != nil {
+		t.Fatal(err)
+	}
+	val, err := s.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(val, "XXX")
+	if val, err := s.Get([]byte("key")); err != nil || string(val) != "val" {
+		t.Errorf("err Get after modifying a value returned. got %s %v", val, err)
+	}
+}
@@ -29,14 +29,33 @@ func (r *Record) IsExpired() bool {
 
 // IsExpired checks the ttl if expired or not.
 func IsExpired(ttl uint32, timestamp uint64) bool {
-	now := time.Now().Unix()
-	if ttl > 0 && uint64(ttl)+timestamp > uint64(now) || ttl == Persistent {
+	return isExpiredAt(ttl, timestamp, uint64(time.Now().Unix()))
+}
+
+// isExpiredAt checks the ttl if expired or not at given now.
+func isExpiredAt(ttl uint32, timestamp uint64, now uint64) bool {
+	if ttl > 0 && uint64(ttl)+timestamp > now || ttl == Persistent {
 		return false
 	}
 
 	return true
 }
 
+// remainingTTL returns the TTL of an entry written at now expiring with the entry of
+// given ttl and timestamp. An entry expired at now gets the shortest TTL, as 0 means
+// Persistent.
+func remainingTTL(ttl uint32, timestamp uint64, now uint64) uint32 {
+	if ttl == Persistent {
+		return Persistent
+	}
+
+	if expiry := uint64(ttl) + timestamp; expiry > now {
+		return uint32(expiry - now)
+	}
+
+	return 1
+}
+
 // UpdateRecord updates the record.
 func (r *Record) UpdateRecord(h *Hint, e *Entry) error {
 	r.E = e
@@ -0,0 +1,165 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"io"
+)
+
+// RecoveryMode represents how opening a database handles the torn and corrupt records of
+// the data files, see Options.RecoveryMode.
+type RecoveryMode int
+
+const (
+	// RecoveryDefault represents a torn record, e.g. written by a commit interrupted by a
+	// crash, silently ending its data file, nothing being truncated nor reported, while the
+	// corrupt records fail opening the database, like the versions without RecoveryMode.
+	RecoveryDefault RecoveryMode = iota
+
+	// RecoveryTolerateTail represents a torn record of the last data file ending it, the
+	// file being truncated there and the record reported by DB.SkippedRecords, while the
+	// torn records of the other data files and the corrupt records fail opening the database.
+	RecoveryTolerateTail
+
+	// RecoveryStrict represents a torn or corrupt record of any data file failing opening
+	// the database, like an entry failing to decode, see RecoveryError.
+	RecoveryStrict
+
+	// RecoverySkipCorrupt represents the corrupt records being skipped, the ones whose
+	// header is corrupt and the torn ones ending their data file, the last one being
+	// truncated there. Every record skipped is reported by DB.SkippedRecords.
+	RecoverySkipCorrupt
+)
+
+// ErrTornRecord is returned when opening a database with RecoveryStrict and a record of
+// a data file was not written completely.
+var ErrTornRecord = wrapError("torn record", ErrCorrupted)
+
+// tailZeroChunkSize is the size of the chunks zeroed by truncateTail.
+const tailZeroChunkSize = 64 * 1024
+
+// SkippedRecord records a torn or corrupt record of a data file skipped when opening
+// the database, see Options.RecoveryMode.
+type SkippedRecord struct {
+	FileID int64
+	Offset int64
+
+	// Size represents the bytes skipped, -1 for the rest of the data file.
+	Size int64
+
+	// Truncated represents if the last data file was truncated at Offset.
+	Truncated bool
+
+	// Err represents the *EntryError reading the record.
+	Err error
+}
+
+// SkippedRecords returns the records skipped when opening the database, see Options.RecoveryMode.
+func (db *DB) SkippedRecords() []SkippedRecord {
+	return append([]SkippedRecord(nil), db.skippedRecords...)
+}
+
+// recoverNext returns the next entry of r reading df when opening the database, handling
+// its torn and corrupt records by Options.RecoveryMode, and nil at the end of the file.
+// The records skipped are recorded if report is set, the last data file being truncated
+// by the first read of it only.
+func (db *DB) recoverNext(r *DataFileReader, df *DataFile, report bool) (*Entry, error) {
+	for {
+		off := r.Offset()
+		e, err := r.Next()
+		if err == nil && e != nil {
+			return e, nil
+		}
+		if err == nil || err == io.EOF {
+			if !r.torn {
+				return nil, nil
+			}
+			err = newEntryError(df.fileID, off, ErrTornRecord)
+		}
+
+		mode := db.opt.RecoveryMode
+		switch mode {
+		case RecoveryDefault:
+			if r.torn {
+				return nil, nil
+			}
+			return nil, err
+		case RecoveryStrict:
+			return nil, err
+		case RecoveryTolerateTail:
+			if !r.torn {
+				return nil, err
+			}
+		case RecoverySkipCorrupt:
+			if size, ok := r.skipCorrupt(); ok {
+				if report {
+					db.skippedRecords = append(db.skippedRecords, SkippedRecord{FileID: df.fileID, Offset: off, Size: size, Err: err})
+				}
+				continue
+			}
+		}
+
+		last := df.fileID == db.MaxFileID
+		if !last && mode != RecoverySkipCorrupt {
+			return nil, err
+		}
+
+		if report {
+			skipped := SkippedRecord{FileID: df.fileID, Offset: off, Size: -1, Err: err}
+			if last && !db.opt.ReadOnly && db.fsys == nil {
+				if err := db.truncateTail(df, off); err != nil {
+					return nil, err
+				}
+				skipped.Truncated = true
+			}
+			db.skippedRecords = append(db.skippedRecords, skipped)
+		}
+
+		return nil, nil
+	}
+}
+
+// truncateTail zeroes the tail of the last data file df from off, up to its zero tail, so
+// that the commits written from off are not followed by the bytes of the torn record.
+func (db *DB) truncateTail(df *DataFile, off int64) error {
+	buf := make([]byte, tailZeroChunkSize)
+	zero := make([]byte, tailZeroChunkSize)
+	for ; off < db.opt.SegmentSize; off += tailZeroChunkSize {
+		chunk := buf
+		if rest := db.opt.SegmentSize - off; rest < tailZeroChunkSize {
+			chunk = buf[:rest]
+		}
+		n, err := df.rwManager.ReadAt(chunk, off)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if bytes.Equal(chunk[:n], zero[:n]) {
+			break
+		}
+		if _, err := df.rwManager.WriteAt(zero[:n], off); err != nil {
+			return err
+		}
+		if n < len(chunk) {
+			break
+		}
+	}
+
+	if db.opt.SyncEnable {
+		return df.rwManager.Sync()
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// writeTornFrame commits n entries in frames and one of 100 entries whose commit marker
+// is lost, returning the offset of the torn frame in the active file.
+func writeTornFrame(t *testing.T, n int) int64 {
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFrames(t, db, n, 5, 0)
+	start := db.ActiveFile.writeOff
+	if err := db.Update(func(tx *Tx) error {
+		for i := 0; i < 100; i++ {
+			if err := tx.Put("bucket", []byte(fmt.Sprintf("torn_%d", i)), []byte("value"), Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fID, end := db.ActiveFile.fileID, db.ActiveFile.writeOff
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := os.OpenFile(db.getDataPath(fID), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if _, err := fd.WriteAt(make([]byte, frameMarkerSize), end-frameMarkerSize); err != nil {
+		t.Fatal(err)
+	}
+
+	return start
+}
+
+func TestDB_RecoveryDefault(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestrecovery", true)
+	opt.SegmentSize = 64 * 1024
+	opt.RecordFormat = RecordFormatV2
+	start := writeTornFrame(t, 10)
+
+	// the torn frame ends the file, left as is.
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkFrames(t, db, 10, 0)
+	if skipped := db.SkippedRecords(); len(skipped) != 0 {
+		t.Errorf("err SkippedRecords %+v", skipped)
+	}
+	if db.ActiveFile.writeOff != start {
+		t.Errorf("err writeOff %d want %d", db.ActiveFile.writeOff, start)
+	}
+
+	buf, err := ioutil.ReadFile(db.getDataPath(db.ActiveFile.fileID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(buf[start:], make([]byte, len(buf)-int(start))) {
+		t.Error("err the torn frame is zeroed")
+	}
+}
+
+func TestDB_RecoveryTolerateTail(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestrecovery", true)
+	opt.SegmentSize = 64 * 1024
+	opt.RecordFormat = RecordFormatV2
+	start := writeTornFrame(t, 10)
+
+	opt.RecoveryMode = RecoveryTolerateTail
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFrames(t, db, 10, 0)
+	skipped := db.SkippedRecords()
+	if len(skipped) != 1 || skipped[0].Offset != start || skipped[0].Size != -1 || !skipped[0].Truncated ||
+		!errors.Is(skipped[0].Err, ErrTornRecord) {
+		t.Fatalf("err SkippedRecords %+v", skipped)
+	}
+	if db.ActiveFile.writeOff != start {
+		t.Errorf("err writeOff %d want %d", db.ActiveFile.writeOff, start)
+	}
+
+	// the tail is zeroed, so a commit smaller than the torn frame is not followed by its bytes.
+	buf, err := ioutil.ReadFile(db.getDataPath(db.ActiveFile.fileID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[start:], make([]byte, len(buf)-int(start))) {
+		t.Error("err the torn frame is not zeroed")
+	}
+	putFrames(t, db, 1, 1, 1)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkFrames(t, db, 1, 1)
+	if skipped := db.SkippedRecords(); len(skipped) != 0 {
+		t.Errorf("err SkippedRecords %+v", skipped)
+	}
+}
+
+func TestDB_RecoveryStrict(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestrecovery", true)
+	opt.SegmentSize = 64 * 1024
+	opt.RecordFormat = RecordFormatV2
+	start := writeTornFrame(t, 10)
+
+	opt.RecoveryMode = RecoveryStrict
+	_, err := Open(opt)
+	var entryErr *EntryError
+	if !errors.As(err, &entryErr) || !errors.Is(err, ErrTornRecord) || !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("err Open with a torn record. got %v want an EntryError", err)
+	}
+	if entryErr.Offset != start {
+		t.Errorf("err EntryError offset %d want %d", entryErr.Offset, start)
+	}
+}
+
+// writeCorruptEntry commits 3 entries and corrupts the value of the second one, returning its offset and size.
+func writeCorruptEntry(t *testing.T) (int64, int64) {
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var off, size int64
+	for i := 0; i < 3; i++ {
+		start := db.ActiveFile.writeOff
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte("value"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			off, size = start, db.ActiveFile.writeOff-start
+		}
+	}
+	fID := db.ActiveFile.fileID
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := os.OpenFile(db.getDataPath(fID), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	b := make([]byte, 1)
+	if _, err := fd.ReadAt(b, off+size-1); err != nil {
+		t.Fatal(err)
+	}
+	b[0]++
+	if _, err := fd.WriteAt(b, off+size-1); err != nil {
+		t.Fatal(err)
+	}
+
+	return off, size
+}
+
+func TestDB_RecoverySkipCorrupt(t *testing.T) {
+	for _, format := range []RecordFormat{RecordFormatV1, RecordFormatV2} {
+		t.Run(fmt.Sprintf("RecordFormat %d", format), func(t *testing.T) {
+			InitOpt("/tmp/nutsdbtestrecovery", true)
+			opt.RecordFormat = format
+			off, size := writeCorruptEntry(t)
+
+			// the corrupt record fails opening the database but with RecoverySkipCorrupt.
+			if _, err := Open(opt); !errors.Is(err, ErrCrc) {
+				t.Fatalf("err Open with a corrupt record. got %v want ErrCrc", err)
+			}
+
+			opt.RecoveryMode = RecoverySkipCorrupt
+			db, err := Open(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			skipped := db.SkippedRecords()
+			if len(skipped) != 1 || skipped[0].Offset != off || skipped[0].Size != size || skipped[0].Truncated ||
+				!errors.Is(skipped[0].Err, ErrCrc) {
+				t.Fatalf("err SkippedRecords %+v want offset %d size %d", skipped, off, size)
+			}
+			if err := db.View(func(tx *Tx) error {
+				for i, found := range []bool{true, false, true} {
+					if _, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i))); (err == nil) != found {
+						t.Errorf("err key_%d: %v", i, err)
+					}
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if db.ActiveFile.writeOff != off+2*size {
+				t.Errorf("err writeOff %d want %d", db.ActiveFile.writeOff, off+2*size)
+			}
+		})
+	}
+}
+
+func TestDB_RecoverySealedFile(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestrecovery", true)
+	opt.RecordFormat = RecordFormatV2
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFrames(t, db, 300, 10, 0)
+	if db.ActiveFile.fileID == 0 {
+		t.Fatal("err no sealed file")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first data file is cut in the middle of a record.
+	if err := os.Truncate(db.getDataPath(0), 4*1024+1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []RecoveryMode{RecoveryTolerateTail, RecoveryStrict} {
+		opt.RecoveryMode = mode
+		if _, err := Open(opt); !errors.Is(err, ErrTornRecord) {
+			t.Errorf("err Open in RecoveryMode %d. got %v want ErrTornRecord", mode, err)
+		}
+	}
+
+	opt.RecoveryMode = RecoveryDefault
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped := db.SkippedRecords(); len(skipped) != 0 {
+		t.Errorf("err SkippedRecords %+v", skipped)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opt.RecoveryMode = RecoverySkipCorrupt
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	skipped := db.SkippedRecords()
+	if len(skipped) != 1 || skipped[0].FileID != 0 || skipped[0].Size != -1 || skipped[0].Truncated {
+		t.Errorf("err SkippedRecords %+v", skipped)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync"
+)
+
+// ErrOpenOnceOptions is returned when OpenOnce is called for an open dir with another
+// EntryIdxMode or SegmentSize than the shared DB.
+var ErrOpenOnceOptions = errors.New("options mismatch the shared db of the dir")
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*DB)
+)
+
+// OpenOnce returns the DB of the dir shared within the process, opening it
+// with opt on the first call. Every call must be paired with a Close, the
+// database is only closed by the last one, so that independent components
+// of a process never open the same dir twice.
+func OpenOnce(dir string, opt Options) (*DB, error) {
+	key, err := registryKey(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if db, ok := registry[key]; ok {
+		if db.opt.EntryIdxMode != opt.EntryIdxMode || db.opt.SegmentSize != opt.SegmentSize {
+			return nil, ErrOpenOnceOptions
+		}
+
+		db.refs++
+
+		return db, nil
+	}
+
+	opt.Dir = dir
+	db, err := Open(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	db.registryKey = key
+	db.refs = 1
+	registry[key] = db
+
+	return db, nil
+}
+
+// registryKey returns the absolute clean path of the dir.
+func registryKey(dir string) (string, error) {
+	if !path.IsAbs(dir) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		dir = wd + "/" + dir
+	}
+
+	return path.Clean(dir), nil
+}
+
+// release drops a reference to a DB returned by OpenOnce, and reports
+// whether it is the last one, or the DB was opened by Open, and so must be closed.
+func (db *DB) release() bool {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if db.registryKey == "" {
+		return true
+	}
+
+	db.refs--
+	if db.refs > 0 {
+		return false
+	}
+
+	delete(registry, db.registryKey)
+	db.registryKey = ""
+
+	return true
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestOpenOnce(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestopenonce", true)
+
+	var wg sync.WaitGroup
+	dbs := make([]*DB, 8)
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if dbs[i], err = OpenOnce(opt.Dir, opt); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, shared := range dbs {
+		if shared != dbs[0] {
+			t.Fatal("err OpenOnce. got different DBs for the same dir")
+		}
+	}
+
+	if same, err := OpenOnce(opt.Dir+"/", opt); err != nil || same != dbs[0] {
+		t.Errorf("err OpenOnce with trailing slash. got %p %v want %p", same, err, dbs[0])
+	} else {
+		same.Close()
+	}
+
+	other := opt
+	other.SegmentSize++
+	if _, err := OpenOnce(opt.Dir, other); err != ErrOpenOnceOptions {
+		t.Errorf("err OpenOnce with other options. got %v want %v", err, ErrOpenOnceOptions)
+	}
+
+	for _, shared := range dbs[1:] {
+		if err := shared.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := dbs[0].View(func(tx *Tx) error { return nil }); err != nil {
+		t.Errorf("err View on shared db with one reference left. got %v", err)
+	}
+
+	if err := dbs[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbs[0].Close(); err != ErrDBClosed {
+		t.Errorf("err Close after the last reference. got %v want %v", err, ErrDBClosed)
+	}
+
+	reopened, err := OpenOnce(opt.Dir, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened == dbs[0] {
+		t.Error("err OpenOnce after close. got the closed DB")
+	}
+	reopened.Close()
+}
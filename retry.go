@@ -0,0 +1,111 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultRetryMaxAttempts is the default number of attempts of UpdateWithRetry.
+	DefaultRetryMaxAttempts = 5
+
+	// DefaultRetryInitialBackoff is the default wait before the first retry of UpdateWithRetry.
+	DefaultRetryInitialBackoff = 10 * time.Millisecond
+
+	// DefaultRetryMaxBackoff is the default max wait between two attempts of UpdateWithRetry.
+	DefaultRetryMaxBackoff = time.Second
+)
+
+// RetryPolicy represents the retries of UpdateWithRetry.
+type RetryPolicy struct {
+	// MaxAttempts represents the max number of attempts, the first one included.
+	// Default MaxAttempts is 0, which means DefaultRetryMaxAttempts.
+	MaxAttempts int
+
+	// InitialBackoff represents the wait before the first retry, doubled for every next one
+	// up to MaxBackoff. Every wait is randomized between its half and itself, so that the
+	// retries of concurrent callers spread out.
+	// Default InitialBackoff is 0, which means DefaultRetryInitialBackoff.
+	InitialBackoff time.Duration
+
+	// MaxBackoff represents the max wait between two attempts.
+	// Default MaxBackoff is 0, which means DefaultRetryMaxBackoff.
+	MaxBackoff time.Duration
+
+	// Retryable represents the function returning if the error of an attempt is transient.
+	// Default Retryable is nil, which means IsRetryable.
+	Retryable func(err error) bool
+}
+
+// IsRetryable returns if err is transient, so that retrying the transaction may succeed:
+// ErrWriteStalled, ErrTooManyTransactions, or a timeout.
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrWriteStalled) || errors.Is(err, ErrTooManyTransactions) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+
+	var timeout interface{ Timeout() bool }
+
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// UpdateWithRetry executes a function within a managed read/write transaction like Update,
+// beginning a new transaction and calling fn again when the attempt fails with a transient
+// error, see RetryPolicy. fn must not have side effects outside of the transaction. It
+// returns the error of the last attempt.
+func (db *DB) UpdateWithRetry(fn func(tx *Tx) error, policy RetryPolicy) error {
+	if fn == nil {
+		return ErrFn
+	}
+
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRetryMaxAttempts
+	}
+
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryInitialBackoff
+	}
+
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := db.Update(fn)
+		if err == nil || attempt >= maxAttempts || !retryable(err) {
+			return err
+		}
+
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)))
+		backoff *= 2
+	}
+}
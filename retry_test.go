@@ -0,0 +1,91 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{ErrWriteStalled, true},
+		{&WriteStallError{}, true},
+		{ErrTooManyTransactions, true},
+		{context.DeadlineExceeded, true},
+		{ErrKeyNotFound, false},
+		{ErrCrc, false},
+	}
+
+	for _, c := range cases {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("err IsRetryable(%v) got %v want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestDB_UpdateWithRetry(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestupdatewithretry", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	// the writes of the failed attempts are rolled back.
+	attempts := 0
+	if err := db.UpdateWithRetry(func(tx *Tx) error {
+		attempts++
+		if err := tx.Put("bucket", []byte("key"), []byte("val"), Persistent); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return ErrWriteStalled
+		}
+		return nil
+	}, policy); err != nil || attempts != 3 {
+		t.Errorf("err UpdateWithRetry of transient errors. got %v after %d attempts", err, attempts)
+	}
+
+	attempts = 0
+	if err := db.UpdateWithRetry(func(tx *Tx) error {
+		attempts++
+		return ErrWriteStalled
+	}, policy); !errors.Is(err, ErrWriteStalled) || attempts != 3 {
+		t.Errorf("err UpdateWithRetry over MaxAttempts. got %v after %d attempts", err, attempts)
+	}
+
+	attempts = 0
+	if err := db.UpdateWithRetry(func(tx *Tx) error {
+		attempts++
+		return ErrKeyNotFound
+	}, policy); err != ErrKeyNotFound || attempts != 1 {
+		t.Errorf("err UpdateWithRetry of a permanent error. got %v after %d attempts", err, attempts)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get("bucket", []byte("key"))
+		return err
+	}); err != nil {
+		t.Errorf("err Get the key written by UpdateWithRetry: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// RootHashSize is the size in bytes of the hashes returned by RootHash.
+const RootHashSize = sha256.Size
+
+// RootHash returns the Merkle root hash of the keys and values of the BPTree bucket,
+// see Tx.RootHash.
+func (db *DB) RootHash(bucket string) (root []byte, err error) {
+	err = db.View(func(tx *Tx) error {
+		root, err = tx.RootHash(bucket)
+		return err
+	})
+
+	return
+}
+
+// RootHash returns the Merkle root hash of the keys and values of the BPTree bucket, so
+// that two replicas, or a database and its backup, are compared by exchanging a hash.
+// It is computed on demand, reading every value, and is deterministic: the leaves are
+// the SHA-256 hashes of the live entries in the order of the keys, the deleted and
+// expired ones excluded, and the nodes the hashes of their two children, an odd node
+// being promoted as is. The hash of an empty or missing bucket is the hash of no data.
+// The replicas must use the same KeyComparator for the bucket. It is not supported in
+// HintBPTSparseIdxMode.
+func (tx *Tx) RootHash(bucket string) ([]byte, error) {
+	mr, err := tx.merkleRange(bucket, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return mr.Hash, nil
+}
+
+// rootHashes returns the RootHash of every BPTree bucket.
+func (tx *Tx) rootHashes() (map[string][]byte, error) {
+	roots := make(map[string][]byte, len(tx.db.BPTreeIdx))
+	for bucket := range tx.db.BPTreeIdx {
+		root, err := tx.RootHash(bucket)
+		if err != nil {
+			return nil, err
+		}
+		roots[bucket] = root
+	}
+
+	return roots, nil
+}
+
+// merkleLeaf returns the hash of the leaf of given key and value, prefixed with 0 and
+// the length of the key so that no leaf is the hash of another leaf or of a node.
+func merkleLeaf(h hash.Hash, key, value []byte) []byte {
+	var buf [1 + binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[1:], uint64(len(key)))
+
+	h.Reset()
+	h.Write(buf[:1+n])
+	h.Write(key)
+	h.Write(value)
+
+	return h.Sum(nil)
+}
+
+// merkleRoot returns the root hash of the tree of the leaves, the nodes being the hashes
+// of 1 and their two children, or the hash of no data if there are no leaves.
+func merkleRoot(h hash.Hash, level [][]byte) []byte {
+	if len(level) == 0 {
+		h.Reset()
+		return h.Sum(nil)
+	}
+
+	for len(level) > 1 {
+		next := level[:0]
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+
+			h.Reset()
+			h.Write([]byte{1})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+
+	return level[0]
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	h := sha256.New()
+	node := func(l, r []byte) []byte {
+		sum := sha256.Sum256(append(append([]byte{1}, l...), r...))
+		return sum[:]
+	}
+
+	l0, l1, l2 := merkleLeaf(h, []byte("a"), []byte("1")), merkleLeaf(h, []byte("b"), []byte("2")), merkleLeaf(h, []byte("c"), []byte("3"))
+	if want := sha256.Sum256([]byte("\x00\x01a1")); !bytes.Equal(l0, want[:]) {
+		t.Errorf("err merkleLeaf. got %x want %x", l0, want)
+	}
+
+	empty := sha256.Sum256(nil)
+	for _, c := range []struct {
+		leaves [][]byte
+		want   []byte
+	}{
+		{nil, empty[:]},
+		{[][]byte{l0}, l0},
+		{[][]byte{l0, l1}, node(l0, l1)},
+		{[][]byte{l0, l1, l2}, node(node(l0, l1), l2)},
+	} {
+		if got := merkleRoot(h, append([][]byte(nil), c.leaves...)); !bytes.Equal(got, c.want) {
+			t.Errorf("err merkleRoot of %d leaves. got %x want %x", len(c.leaves), got, c.want)
+		}
+	}
+}
+
+func TestDB_RootHash(t *testing.T) {
+	bucket := "bucket"
+	open := func(dir string, mode EntryIdxMode) *DB {
+		InitOpt(dir, true)
+		opt.EntryIdxMode = mode
+		db, err := Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	put := func(db *DB, key, val string) {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(key), []byte(val), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := func(db *DB) []byte {
+		root, err := db.RootHash(bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+
+	a := open("/tmp/nutsdbtestroothash_a", HintKeyValAndRAMIdxMode)
+	defer a.Close()
+	b := open("/tmp/nutsdbtestroothash_b", HintKeyAndRAMIdxMode)
+	defer b.Close()
+
+	empty := sha256.Sum256(nil)
+	if got := root(a); !bytes.Equal(got, empty[:]) {
+		t.Errorf("err RootHash of a missing bucket. got %x want %x", got, empty)
+	}
+
+	for i := 0; i < 10; i++ {
+		put(a, fmt.Sprintf("key_%d", i), fmt.Sprintf("val_%d", i))
+		put(b, fmt.Sprintf("key_%d", 9-i), fmt.Sprintf("val_%d", 9-i))
+	}
+	if ra, rb := root(a), root(b); !bytes.Equal(ra, rb) || len(ra) != RootHashSize {
+		t.Errorf("err RootHash of the same keys written in another order. got %x and %x", ra, rb)
+	}
+
+	put(b, "key_3", "other")
+	if bytes.Equal(root(a), root(b)) {
+		t.Error("err RootHash of a changed value: equal")
+	}
+
+	put(b, "key_3", "val_3")
+	put(b, "key_10", "val_10")
+	if err := b.Update(func(tx *Tx) error {
+		return tx.Delete(bucket, []byte("key_10"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root(a), root(b)) {
+		t.Error("err RootHash after restoring the value and deleting the added key: different")
+	}
+}
@@ -14,6 +14,8 @@
 
 package nutsdb
 
+import "os"
+
 // RWMode represents the read and write mode.
 type RWMode int
 
@@ -32,3 +34,22 @@ type RWManager interface {
 	Sync() (err error)
 	Close() (err error)
 }
+
+// RWManagerFactory returns the RWManager of the data file at given path, capacity and rwMode.
+// It allows wrapping or replacing the built-in FileIO and MMap managers, e.g. to inject faults in tests.
+type RWManagerFactory func(path string, capacity int64, rwMode RWMode) (RWManager, error)
+
+// NewRWManager returns the built-in RWManager at given path, capacity and rwMode.
+func NewRWManager(path string, capacity int64, rwMode RWMode) (RWManager, error) {
+	return newRWManager(path, capacity, rwMode, DefaultFilePerm)
+}
+
+// newRWManager returns the built-in RWManager at given path, capacity and rwMode,
+// creating the file with perm if not exist.
+func newRWManager(path string, capacity int64, rwMode RWMode, perm os.FileMode) (RWManager, error) {
+	if rwMode == MMap {
+		return newMMapRWManager(path, capacity, perm)
+	}
+
+	return newStorageFileIORWManager(osStorage{}, path, capacity, perm)
+}
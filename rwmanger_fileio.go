@@ -14,26 +14,14 @@
 
 package nutsdb
 
-import "os"
-
 // FileIORWManager represents the RWManager which using standard I/O.
 type FileIORWManager struct {
-	fd *os.File
+	fd StorageFile
 }
 
 // NewFileIORWManager returns a newly initialized FileIORWManager.
 func NewFileIORWManager(path string, capacity int64) (*FileIORWManager, error) {
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		return nil, err
-	}
-
-	err = Truncate(path, capacity, fd)
-	if err != nil {
-		return nil, err
-	}
-
-	return &FileIORWManager{fd: fd}, nil
+	return newStorageFileIORWManager(osStorage{}, path, capacity, DefaultFilePerm)
 }
 
 // WriteAt writes len(b) bytes to the File starting at byte offset off.
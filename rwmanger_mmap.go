@@ -12,6 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+
 package nutsdb
 
 import (
@@ -36,7 +38,12 @@ var (
 
 // NewMMapRWManager returns a newly initialized MMapRWManager.
 func NewMMapRWManager(path string, capacity int64) (*MMapRWManager, error) {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	return newMMapRWManager(path, capacity, DefaultFilePerm)
+}
+
+// newMMapRWManager returns a newly initialized MMapRWManager, creating the file with perm if not exist.
+func newMMapRWManager(path string, capacity int64, perm os.FileMode) (*MMapRWManager, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, perm)
 	defer f.Close()
 
 	if err != nil {
@@ -85,7 +92,12 @@ func (mm *MMapRWManager) Sync() (err error) {
 	return mm.m.Flush()
 }
 
-//Close deletes the memory mapped region, flushes any remaining changes
+// Close deletes the memory mapped region, flushes any remaining changes
 func (mm *MMapRWManager) Close() (err error) {
 	return mm.m.Unmap()
 }
+
+// advise gives the hint a to the OS for the mapped region.
+func (mm *MMapRWManager) advise(a fileAdvice) error {
+	return madvise(mm.m, a)
+}
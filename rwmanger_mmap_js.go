@@ -0,0 +1,49 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"os"
+)
+
+// MMapRWManager represents the RWManager of the MMap mode, there is no mmap
+// under GOOS=js so it uses standard I/O as the FileIORWManager.
+type MMapRWManager struct {
+	*FileIORWManager
+}
+
+var (
+	// ErrUnmappedMemory is returned when a function is called on unmapped memory
+	ErrUnmappedMemory = errors.New("unmapped memory")
+
+	// ErrIndexOutOfBound is returned when given offset out of mapped region
+	ErrIndexOutOfBound = errors.New("offset out of mapped region")
+)
+
+// NewMMapRWManager returns a newly initialized MMapRWManager.
+func NewMMapRWManager(path string, capacity int64) (*MMapRWManager, error) {
+	return newMMapRWManager(path, capacity, DefaultFilePerm)
+}
+
+// newMMapRWManager returns a newly initialized MMapRWManager, creating the file with perm if not exist.
+func newMMapRWManager(path string, capacity int64, perm os.FileMode) (*MMapRWManager, error) {
+	fm, err := newStorageFileIORWManager(osStorage{}, path, capacity, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MMapRWManager{FileIORWManager: fm}, nil
+}
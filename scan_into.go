@@ -0,0 +1,99 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "fmt"
+
+// ValueReader reads the value of the key passed to the callback of ScanInto, calling fn
+// with it, and must be called during the callback. The value is only read from the data
+// file when ValueReader is called, and is only valid during fn: in HintKeyValAndRAMIdxMode
+// it is the value of the index, not copied, so fn must not modify or retain it.
+type ValueReader func(fn func(value []byte) error) error
+
+// ScanInto calls fn for every key of the bucket starting with prefix, in the order of the
+// keys, with the ValueReader of its value, until fn returns an error, which is returned.
+// Unlike PrefixScan, the values of the keys filtered out by fn are neither read nor copied.
+// It is not supported in HintBPTSparseIdxMode.
+func (tx *Tx) ScanInto(bucket string, prefix []byte, fn func(key []byte, readValue ValueReader) error) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	idx, ok := tx.bptreeIdx(bucket)
+	if !ok || idx.root == nil {
+		return nil
+	}
+
+	// the data files are opened once for all the values read from them.
+	files := make(map[int64]*DataFile)
+	defer func() {
+		for _, df := range files {
+			df.rwManager.Close()
+		}
+	}()
+
+	n := 0
+	defer func() { tx.recordRead(bucket, n) }()
+
+	_, keys, pointers := idx.prefixScan(prefix, ScanNoLimit)
+	for i, p := range pointers {
+		r := p.(*Record)
+		if r.H.meta.Flag == DataDeleteFlag || tx.db.isExpired(r.H.meta.TTL, r.H.meta.timestamp) {
+			continue
+		}
+
+		n++
+		if err := fn(keys[i], func(use func(value []byte) error) error {
+			e, err := tx.readRecordEntry(r, files)
+			if err != nil {
+				return err
+			}
+			return use(e.Value)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readRecordEntry returns the entry of r, from the index in HintKeyValAndRAMIdxMode or
+// read from its data file, opened in files if needed, its deltas resolved.
+func (tx *Tx) readRecordEntry(r *Record, files map[int64]*DataFile) (*Entry, error) {
+	if tx.db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode && r.E != nil {
+		tx.db.indexMemory.touch(r)
+		return tx.db.resolveDeltas(r.E)
+	}
+
+	df, ok := files[r.H.fileID]
+	if !ok {
+		var err error
+		if df, err = tx.db.openDataFile(r.H.fileID, tx.db.opt.RWMode); err != nil {
+			return nil, err
+		}
+		files[r.H.fileID] = df
+	}
+
+	e, err := df.ReadAt(int(r.H.dataPos))
+	if err != nil {
+		return nil, fmt.Errorf("read err. pos %d, key %s, err %w", r.H.dataPos, r.H.key, err)
+	}
+
+	return tx.db.resolveDeltas(e)
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// countingRWManager counts the reads of the data files.
+type countingRWManager struct {
+	RWManager
+	reads *int64
+}
+
+func (cm *countingRWManager) ReadAt(b []byte, off int64) (int, error) {
+	atomic.AddInt64(cm.reads, 1)
+	return cm.RWManager.ReadAt(b, off)
+}
+
+func TestTx_ScanInto(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestscaninto", true)
+		opt.EntryIdxMode = mode
+		var reads int64
+		opt.RWManagerFactory = func(path string, capacity int64, rwMode RWMode) (RWManager, error) {
+			rw, err := NewRWManager(path, capacity, rwMode)
+			if err != nil {
+				return nil, err
+			}
+			return &countingRWManager{RWManager: rw, reads: &reads}, nil
+		}
+
+		db, err := Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Update(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				if err := tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d", i)), Persistent); err != nil {
+					return err
+				}
+			}
+			if err := tx.Put("bucket", []byte("other"), []byte("val"), Persistent); err != nil {
+				return err
+			}
+			return tx.Delete("bucket", []byte("key_9"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		var keys []string
+		var values []string
+		atomic.StoreInt64(&reads, 0)
+		if err := db.View(func(tx *Tx) error {
+			return tx.ScanInto("bucket", []byte("key_"), func(key []byte, readValue ValueReader) error {
+				keys = append(keys, string(key))
+				if string(key) != "key_3" && string(key) != "key_5" {
+					return nil
+				}
+				return readValue(func(value []byte) error {
+					values = append(values, string(value))
+					return nil
+				})
+			})
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != 9 || keys[0] != "key_0" || keys[8] != "key_8" {
+			t.Errorf("mode %d: err keys %v", mode, keys)
+		}
+		if len(values) != 2 || values[0] != "val_3" || values[1] != "val_5" {
+			t.Errorf("mode %d: err values %v", mode, values)
+		}
+
+		// only the values read by the callback are read from the data file.
+		scanInto := atomic.SwapInt64(&reads, 0)
+		if err := db.View(func(tx *Tx) error {
+			_, err := tx.PrefixScan("bucket", []byte("key_"), ScanNoLimit)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		prefixScan := atomic.LoadInt64(&reads)
+		if mode == HintKeyValAndRAMIdxMode && scanInto != 0 || mode == HintKeyAndRAMIdxMode && (scanInto == 0 || scanInto*3 > prefixScan) {
+			t.Errorf("mode %d: err %d reads, %d by PrefixScan", mode, scanInto, prefixScan)
+		}
+
+		// the error of the callback stops the scan.
+		stop := errors.New("stop")
+		n := 0
+		if err := db.View(func(tx *Tx) error {
+			return tx.ScanInto("bucket", nil, func(key []byte, readValue ValueReader) error {
+				n++
+				return stop
+			})
+		}); err != stop || n != 1 {
+			t.Errorf("mode %d: err stop %v after %d keys", mode, err, n)
+		}
+
+		// a missing bucket has no keys.
+		if err := db.View(func(tx *Tx) error {
+			return tx.ScanInto("missing", nil, func(key []byte, readValue ValueReader) error {
+				return stop
+			})
+		}); err != nil {
+			t.Errorf("mode %d: err missing bucket %v", mode, err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// scoreMarker starts the encoded scores. The scores written as decimal text before
+// never start with it.
+const scoreMarker = 0xff
+
+// encodeScore returns the score of ZAdd as stored in the entry key: the IEEE-754 bits
+// of the score, the sign bit flipped for the positive scores and every bit flipped for
+// the negative ones, so the encoded scores are ordered bytewise like the scores and
+// round-trip exactly.
+//
+//	|-----------------------------|
+//	| marker (0xff) |    bits     |
+//	|-----------------------------|
+//	|     uint8     | uint64 (BE) |
+//	|-----------------------------|
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	buf := make([]byte, 9)
+	buf[0] = scoreMarker
+	binary.BigEndian.PutUint64(buf[1:], bits)
+
+	return buf
+}
+
+// decodeScore returns the score encoded by encodeScore, also decoding the scores
+// written as decimal text before.
+func decodeScore(buf []byte) (float64, error) {
+	if len(buf) == 9 && buf[0] == scoreMarker {
+		bits := binary.BigEndian.Uint64(buf[1:])
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return math.Float64frombits(bits), nil
+	}
+
+	return strconv2.StrToFloat64(string(buf))
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestScoreEncoding(t *testing.T) {
+	scores := []float64{math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64, 0,
+		math.SmallestNonzeroFloat64, 0.1 + 0.2, 1, 1 << 53, math.MaxFloat64, math.Inf(1)}
+
+	for i, score := range scores {
+		got, err := decodeScore(encodeScore(score))
+		if err != nil || got != score {
+			t.Errorf("err decodeScore of %v. got %v %v", score, got, err)
+		}
+
+		if i > 0 && bytes.Compare(encodeScore(scores[i-1]), encodeScore(score)) >= 0 {
+			t.Errorf("err encodeScore. %v not ordered before %v", scores[i-1], score)
+		}
+	}
+
+	// the scores written as decimal text before.
+	if got, err := decodeScore([]byte("-12.25")); err != nil || got != -12.25 {
+		t.Errorf("err decodeScore of a legacy score. got %v %v", got, err)
+	}
+}
+
+func TestDB_ZAddScorePrecision(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestscore", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "zset"
+	scores := map[string]float64{
+		"a": 0.1 + 0.2,
+		"b": math.MaxFloat64,
+		"c": -math.SmallestNonzeroFloat64,
+		"d": 1<<53 + 2,
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		for key, score := range scores {
+			if err := tx.ZAdd(bucket, []byte(key), score, []byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.View(func(tx *Tx) error {
+		for key, score := range scores {
+			n, err := tx.ZGetByKey(bucket, []byte(key))
+			if err != nil {
+				return err
+			}
+			if float64(n.Score()) != score {
+				t.Errorf("err ZGetByKey %s. got score %v want %v", key, n.Score(), score)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// SequenceBucket is the bucket in which the sequence counters are stored, keyed by bucket name.
+const SequenceBucket = "__nutsdb_sequence__"
+
+var (
+	// ErrZeroBandwidth is returned when a Sequence is requested with a zero bandwidth.
+	ErrZeroBandwidth = errors.New("sequence bandwidth must be greater than zero")
+
+	// ErrSequenceReleased is returned when Next is called on a released Sequence.
+	ErrSequenceReleased = errors.New("sequence released")
+
+	// ErrSequenceCorrupted is returned when a stored sequence counter is not 8 bytes long.
+	ErrSequenceCorrupted = wrapError("sequence counter corrupted", ErrCorrupted)
+)
+
+// Sequence hands out monotonically increasing IDs of a bucket, reserving
+// them from the database in ranges of bandwidth IDs so that only one
+// write per range is needed. IDs of a range that are not handed out
+// are lost on a crash.
+type Sequence struct {
+	mu        sync.Mutex
+	db        *DB
+	bucket    string
+	next      uint64
+	leased    uint64
+	bandwidth uint64
+	released  bool
+}
+
+// NextSequence returns the next ID of the bucket's sequence in its own
+// write transaction. The first ID is 1.
+func (db *DB) NextSequence(bucket string) (id uint64, err error) {
+	err = db.Update(func(tx *Tx) error {
+		last, err := tx.getSequence(bucket)
+		if err != nil {
+			return err
+		}
+
+		id = last + 1
+
+		return tx.putSequence(bucket, id)
+	})
+
+	return
+}
+
+// GetSequence returns a Sequence of the bucket which reserves bandwidth IDs at a time.
+// It shares its counter with NextSequence. Call Release when done with it.
+func (db *DB) GetSequence(bucket string, bandwidth uint64) (*Sequence, error) {
+	if bandwidth == 0 {
+		return nil, ErrZeroBandwidth
+	}
+
+	seq := &Sequence{db: db, bucket: bucket, bandwidth: bandwidth}
+	if err := seq.updateLease(); err != nil {
+		return nil, err
+	}
+
+	return seq, nil
+}
+
+// Next returns the next ID of the sequence, reserving a new range when the current one is used up.
+func (seq *Sequence) Next() (uint64, error) {
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+
+	if seq.released {
+		return 0, ErrSequenceReleased
+	}
+
+	if seq.next > seq.leased {
+		if err := seq.updateLease(); err != nil {
+			return 0, err
+		}
+	}
+
+	id := seq.next
+	seq.next++
+
+	return id, nil
+}
+
+// Release gives back the reserved IDs that were not handed out,
+// unless the counter has moved on since the last reservation.
+func (seq *Sequence) Release() error {
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+
+	if seq.released {
+		return nil
+	}
+
+	err := seq.db.Update(func(tx *Tx) error {
+		last, err := tx.getSequence(seq.bucket)
+		if err != nil || last != seq.leased {
+			return err
+		}
+
+		return tx.putSequence(seq.bucket, seq.next-1)
+	})
+	if err != nil {
+		return err
+	}
+
+	seq.released = true
+
+	return nil
+}
+
+// updateLease reserves the next bandwidth IDs of the sequence. The sequence is left
+// unchanged unless the reservation is committed.
+func (seq *Sequence) updateLease() error {
+	var next, leased uint64
+	err := seq.db.Update(func(tx *Tx) error {
+		last, err := tx.getSequence(seq.bucket)
+		if err != nil {
+			return err
+		}
+
+		next, leased = last+1, last+seq.bandwidth
+
+		return tx.putSequence(seq.bucket, leased)
+	})
+	if err != nil {
+		return err
+	}
+
+	seq.next, seq.leased = next, leased
+
+	return nil
+}
+
+// getSequence returns the last reserved ID of the bucket's sequence, 0 if none.
+func (tx *Tx) getSequence(bucket string) (uint64, error) {
+	e, err := tx.Get(SequenceBucket, []byte(bucket))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrBucketNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	if len(e.Value) != 8 {
+		return 0, ErrSequenceCorrupted
+	}
+
+	return binary.BigEndian.Uint64(e.Value), nil
+}
+
+// putSequence stores the last reserved ID of the bucket's sequence.
+func (tx *Tx) putSequence(bucket string, last uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, last)
+
+	return tx.Put(SequenceBucket, []byte(bucket), value, Persistent)
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDB_NextSequence(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestsequence", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for want := uint64(1); want <= 3; want++ {
+		id, err := db.NextSequence("users")
+		if err != nil || id != want {
+			t.Fatalf("err NextSequence. got %d %v want %d", id, err, want)
+		}
+	}
+
+	if id, err := db.NextSequence("orders"); err != nil || id != 1 {
+		t.Errorf("err NextSequence of other bucket. got %d %v want 1", id, err)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestsequence", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetSequence("users", 0); err != ErrZeroBandwidth {
+		t.Errorf("err GetSequence. got %v want %v", err, ErrZeroBandwidth)
+	}
+
+	seq, err := db.GetSequence("users", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for want := uint64(1); want <= 25; want++ {
+		id, err := seq.Next()
+		if err != nil || id != want {
+			t.Fatalf("err Next. got %d %v want %d", id, err, want)
+		}
+	}
+
+	if err := seq.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seq.Next(); err != ErrSequenceReleased {
+		t.Errorf("err Next after Release. got %v want %v", err, ErrSequenceReleased)
+	}
+
+	if id, err := db.NextSequence("users"); err != nil || id != 26 {
+		t.Errorf("err NextSequence after Release. got %d %v want 26", id, err)
+	}
+
+	seq, err = db.GetSequence("users", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seq.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// crash without Release: the rest of the range is skipped after reopening.
+	db.Close()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if id, err := db.NextSequence("users"); err != nil || id != 37 {
+		t.Errorf("err NextSequence after reopen. got %d %v want 37", id, err)
+	}
+}
+
+func TestSequence_FailedLease(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestsequence", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	seq, err := db.GetSequence("users", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := uint64(1); want <= 2; want++ {
+		if id, err := seq.Next(); err != nil || id != want {
+			t.Fatalf("err Next. got %d %v want %d", id, err, want)
+		}
+	}
+
+	// a reservation failing to commit hands out no ID.
+	db.opt.MaxDiskUsage = 1
+	if _, err := seq.Next(); !errors.Is(err, ErrDiskQuotaExceeded) {
+		t.Fatalf("err Next over quota. got %v want %v", err, ErrDiskQuotaExceeded)
+	}
+	db.opt.MaxDiskUsage = 0
+
+	for want := uint64(3); want <= 5; want++ {
+		if id, err := seq.Next(); err != nil || id != want {
+			t.Fatalf("err Next after a failed reservation. got %d %v want %d", id, err, want)
+		}
+	}
+}
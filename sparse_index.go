@@ -0,0 +1,217 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"os"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// ErrNotSparseIdxMode is returned when the operation is only supported in HintBPTSparseIdxMode.
+var ErrNotSparseIdxMode = wrapError("only support mode `HintBPTSparseIdxMode`", ErrUnsupportedMode)
+
+// RebuildSparseIndex regenerates the on-disk B+ tree index files of the
+// sealed data files of the database at opt.Dir from the data files. Use it
+// when the bpt, bpt/root or bpt/txid files are out of sync with the data
+// files, e.g. after a partial copy, and Open fails or keys are missing.
+// The database must not be open, see DB.RebuildSparseIndex otherwise.
+func RebuildSparseIndex(opt Options) error {
+	if opt.EntryIdxMode != HintBPTSparseIdxMode {
+		return ErrNotSparseIdxMode
+	}
+
+	db := &DB{opt: opt, storage: osStorage{}}
+	if err := db.openBucketDict(); err != nil {
+		return err
+	}
+
+	return db.rebuildSparseIndex()
+}
+
+// RebuildSparseIndex regenerates the on-disk B+ tree index files of the open database and reloads them.
+func (db *DB) RebuildSparseIndex() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	if db.opt.EntryIdxMode != HintBPTSparseIdxMode {
+		return ErrNotSparseIdxMode
+	}
+
+	db.health.setRecovering(true)
+	defer db.health.setRecovering(false)
+
+	if err := db.rebuildSparseIndex(); err != nil {
+		return err
+	}
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	db.BPTreeRootIdxes = nil
+
+	return db.buildBPTreeRootIdxes(dataFileIds)
+}
+
+// rebuildSparseIndex writes the index files of every data file but the active one.
+func (db *DB) rebuildSparseIndex() error {
+	for _, dir := range []string{db.getBPTDir() + "/root", db.getBPTDir() + "/txid"} {
+		if err := os.MkdirAll(dir, db.dirPerm()); err != nil {
+			return err
+		}
+	}
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	if len(dataFileIds) < 2 {
+		return db.persistSparseIndexFormat()
+	}
+
+	// a transaction is committed when its last entry, possibly in a later file, is.
+	committedTxIds := make(map[uint64]struct{})
+	for _, dataID := range dataFileIds {
+		err := db.scanDataFile(int64(dataID), func(entry *Entry, off int64) {
+			if entry.Meta.status == Committed {
+				committedTxIds[entry.Meta.txID] = struct{}{}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dataID := range dataFileIds[:len(dataFileIds)-1] {
+		if err := db.rebuildSparseIndexFile(int64(dataID), committedTxIds); err != nil {
+			return err
+		}
+	}
+
+	return db.persistSparseIndexFormat()
+}
+
+// rebuildSparseIndexFile writes the index files of the data file at given fid,
+// as rotateActiveFile and buildTxIDRootIdx do when the file is sealed.
+func (db *DB) rebuildSparseIndexFile(fID int64, committedTxIds map[uint64]struct{}) error {
+	for _, path := range []string{db.getBPTPath(fID), db.getBPTRootPath(fID), db.getBPTTxIdPath(fID), db.getBPTRootTxIdPath(fID)} {
+		if err := db.nodeCache.forget(path); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	keyIdx := NewTree()
+	txIDIdx := NewTree()
+	keyEntryPosMap := make(map[string]int64)
+
+	err := db.scanDataFile(fID, func(entry *Entry, off int64) {
+		if _, ok := committedTxIds[entry.Meta.txID]; !ok {
+			return
+		}
+
+		txIDIdx.Insert([]byte(strconv2.Int64ToStr(int64(entry.Meta.txID))), nil, &Hint{meta: &MetaData{Flag: DataSetFlag}}, CountFlagEnabled)
+
+		if entry.Meta.ds != DataStructureBPTree {
+			return
+		}
+
+		newKey := getNewKey(string(entry.Meta.bucket), entry.Key)
+		keyIdx.Insert(newKey, nil, &Hint{
+			fileID:  fID,
+			key:     newKey,
+			meta:    entry.Meta,
+			dataPos: uint64(off),
+		}, CountFlagEnabled)
+		keyEntryPosMap[string(newKey)] = off
+	})
+	if err != nil {
+		return err
+	}
+
+	if keyIdx.root != nil {
+		keyIdx.Filepath = db.getBPTPath(fID)
+		keyIdx.enabledKeyPosMap = true
+		keyIdx.SetKeyPosMap(keyEntryPosMap)
+		if err := keyIdx.writeNodes(db.opt.RWMode, db.opt.SyncEnable, 1, db.filePerm()); err != nil {
+			return err
+		}
+
+		rootIdx := &BPTreeRootIdx{
+			rootOff:   uint64(keyIdx.root.Address),
+			fID:       uint64(fID),
+			startSize: uint32(len(keyIdx.FirstKey)),
+			endSize:   uint32(len(keyIdx.LastKey)),
+			start:     keyIdx.FirstKey,
+			end:       keyIdx.LastKey,
+		}
+		if _, err := rootIdx.persistence(db.getBPTRootPath(fID), 0, db.opt.SyncEnable, db.filePerm()); err != nil {
+			return err
+		}
+	}
+
+	if txIDIdx.root != nil {
+		txIDIdx.Filepath = db.getBPTTxIdPath(fID)
+		if err := txIDIdx.writeNodes(db.opt.RWMode, db.opt.SyncEnable, 2, db.filePerm()); err != nil {
+			return err
+		}
+
+		txIDRootIdx := NewTree()
+		txIDRootIdx.Insert([]byte(strconv2.Int64ToStr(txIDIdx.root.Address)), nil, &Hint{meta: &MetaData{Flag: DataSetFlag}}, CountFlagEnabled)
+		txIDRootIdx.Filepath = db.getBPTRootTxIdPath(fID)
+		if err := txIDRootIdx.writeNodes(db.opt.RWMode, db.opt.SyncEnable, 2, db.filePerm()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanDataFile calls fn for every entry of the data file at given fid with its offset.
+func (db *DB) scanDataFile(fID int64, fn func(entry *Entry, off int64)) error {
+	return db.scanDataFileTo(fID, db.opt.SegmentSize, fn)
+}
+
+// scanDataFileTo calls fn for every entry of the first size bytes of the data file at
+// given fid with its offset.
+func (db *DB) scanDataFileTo(fID int64, size int64, fn func(entry *Entry, off int64)) error {
+	f, err := db.openDataFile(fID, db.opt.StartFileLoadingMode)
+	if err != nil {
+		return err
+	}
+	defer f.rwManager.Close()
+
+	bufSize := db.opt.RecoveryReadBufferSize
+	if bufSize <= 0 {
+		bufSize = defaultRecoveryReadBufferSize
+	}
+	r := newDataFileReaderAt(f, 0, size, bufSize)
+	for {
+		entry, err := r.Next()
+		if err == io.EOF || err == nil && entry == nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fn(entry, r.EntryOffset())
+	}
+}
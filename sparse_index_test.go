@@ -0,0 +1,115 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func openSparseTestDB(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestrebuildsparse", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 300; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%03d", i)), []byte(fmt.Sprintf("val_%03d", i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkSparseTestDB(t *testing.T) {
+	if err := db.View(func(tx *Tx) error {
+		for i := 0; i < 300; i++ {
+			e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%03d", i)))
+			if err != nil {
+				return fmt.Errorf("key_%03d: %w", i, err)
+			}
+			if string(e.Value) != fmt.Sprintf("val_%03d", i) {
+				t.Errorf("err value of key_%03d. got %s", i, e.Value)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRebuildSparseIndex(t *testing.T) {
+	openSparseTestDB(t)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt a root index file
+	rootDir := opt.Dir + "/" + bptDir + "/root"
+	files, err := ioutil.ReadDir(rootDir)
+	if err != nil || len(files) == 0 {
+		t.Fatalf("err no root index files: %v", err)
+	}
+	buf, err := ioutil.ReadFile(rootDir + "/" + files[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[0]++
+	if err := ioutil.WriteFile(rootDir+"/"+files[0].Name(), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(opt); err == nil || !strings.Contains(err.Error(), "RebuildSparseIndex") {
+		t.Fatalf("err Open with corrupted index. got %v", err)
+	}
+
+	// and lose the txid index files
+	if err := os.RemoveAll(opt.Dir + "/" + bptDir + "/txid"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RebuildSparseIndex(opt); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	checkSparseTestDB(t)
+}
+
+func TestDB_RebuildSparseIndex(t *testing.T) {
+	openSparseTestDB(t)
+	defer db.Close()
+
+	if err := db.RebuildSparseIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSparseTestDB(t)
+
+	if err := RebuildSparseIndex(DefaultOptions); err != ErrNotSparseIdxMode {
+		t.Errorf("err RebuildSparseIndex. got %v want %v", err, ErrNotSparseIdxMode)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+const (
+	// stampSeqShift is the shift of the seq of an entry in the timestamp stored on disk,
+	// whose low bits hold the Unix seconds.
+	stampSeqShift = 40
+
+	// maxStampSeq is the largest seq of an entry.
+	maxStampSeq = 1<<(64-stampSeqShift) - 1
+)
+
+// packTimestamp returns the timestamp stored on disk for an entry of given timestamp and
+// seq, the seq in its high bits. The timestamp of an entry without seq is stored as is.
+func packTimestamp(timestamp uint64, seq uint32) uint64 {
+	return timestamp | uint64(seq)<<stampSeqShift
+}
+
+// unpackTimestamp returns the timestamp and the seq of an entry from the timestamp
+// stored on disk, see packTimestamp.
+func unpackTimestamp(stored uint64) (timestamp uint64, seq uint32) {
+	return stored & (1<<stampSeqShift - 1), uint32(stored >> stampSeqShift)
+}
+
+// stampClock orders the writes of a database: the stamp of a write, its timestamp then
+// its seq, is after the stamps of the writes committed before it, those written by a
+// sync or a repair included, so that the last-writer-wins of ApplySyncDelta and
+// CompareAndRepair follows the commit order even within a second. It is a hybrid logical
+// clock in seconds, the seq counting the writes of a second.
+type stampClock struct {
+	last uint64 // the last stamp, the timestamp in the high bits
+}
+
+// stampOf returns the stamp of an entry of given timestamp and seq.
+func stampOf(timestamp uint64, seq uint32) uint64 {
+	return timestamp<<(64-stampSeqShift) | uint64(seq)
+}
+
+// next returns the stamp of a write at timestamp.
+func (c *stampClock) next(timestamp uint64) (uint64, uint32) {
+	if s := stampOf(timestamp, 0); s > c.last {
+		c.last = s
+	} else {
+		c.last++
+	}
+
+	return c.last >> (64 - stampSeqShift), uint32(c.last & maxStampSeq)
+}
+
+// observe moves the clock past the stamp of a write of another site, or of a write
+// read when opening the database.
+func (c *stampClock) observe(timestamp uint64, seq uint32) {
+	if s := stampOf(timestamp, seq); s > c.last {
+		c.last = s
+	}
+}
+
+// compareStamps compares the stamps of two entries, their timestamps then their seqs
+// then their site IDs, returning -1, 0 or 1.
+func compareStamps(a, b *MetaData) int {
+	switch {
+	case a.timestamp != b.timestamp:
+		return compareUint64(a.timestamp, b.timestamp)
+	case a.seq != b.seq:
+		return compareUint64(uint64(a.seq), uint64(b.seq))
+	default:
+		return compareUint64(uint64(a.siteID), uint64(b.siteID))
+	}
+}
+
+// compareUint64 returns -1, 0 or 1 as a is less than, equal to or greater than b.
+func compareUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// stampWrites stamps the writes of the transaction before they are written. The writes
+// of a merge, a sync or a repair keep their stamps, which the clock is moved past. The
+// others are stamped in commit order when the database has a SiteID, a write stamped
+// after its timestamp keeping its expiry. It must be called with the db.writeMu lock held.
+func (tx *Tx) stampWrites(writesLen int) {
+	c := &tx.db.stamps
+	for _, e := range tx.pendingWrites[:writesLen] {
+		if tx.merge || tx.keepStamps {
+			c.observe(e.Meta.timestamp, e.Meta.seq)
+			continue
+		}
+
+		if tx.db.opt.SiteID == 0 {
+			continue
+		}
+
+		timestamp, seq := c.next(e.Meta.timestamp)
+		if timestamp != e.Meta.timestamp {
+			e.Meta.TTL = remainingTTL(e.Meta.TTL, e.Meta.timestamp, timestamp)
+			e.Meta.timestamp = timestamp
+		}
+		e.Meta.seq = seq
+	}
+}
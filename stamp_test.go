@@ -0,0 +1,100 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStampClock(t *testing.T) {
+	var c stampClock
+	for i, want := range [][2]uint64{{1000, 0}, {1000, 1}, {1001, 0}, {1001, 1}} {
+		if ts, seq := c.next(1000 + uint64(i/2)); ts != want[0] || uint64(seq) != want[1] {
+			t.Errorf("expected %v, got %d.%d", want, ts, seq)
+		}
+	}
+
+	// a clock behind the last stamp keeps counting after it.
+	if ts, seq := c.next(900); ts != 1001 || seq != 2 {
+		t.Errorf("expected 1001.2, got %d.%d", ts, seq)
+	}
+
+	c.observe(1001, 7)
+	if ts, seq := c.next(1001); ts != 1001 || seq != 8 {
+		t.Errorf("expected 1001.8, got %d.%d", ts, seq)
+	}
+
+	if ts, seq := unpackTimestamp(packTimestamp(1001, 8)); ts != 1001 || seq != 8 {
+		t.Errorf("expected 1001.8 unpacked, got %d.%d", ts, seq)
+	}
+}
+
+func TestDB_Stamps(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	db := openSyncDB(t, "/tmp/nutsdbteststamps", 1, clock)
+
+	put := func(db *DB, key string) {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(key), []byte("v"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put(db, "a")
+	put(db, "b")
+	if meta := metaOfKey(t, db, "b"); meta.Timestamp() != 1000 || meta.Seq() != 1 {
+		t.Errorf("expected b stamped 1000.1, got %d.%d", meta.Timestamp(), meta.Seq())
+	}
+
+	o := db.opt
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the stamps are read back, and the clock moved past them.
+	if meta := metaOfKey(t, db, "b"); meta.Timestamp() != 1000 || meta.Seq() != 1 {
+		t.Errorf("expected b stamped 1000.1 after reopening, got %d.%d", meta.Timestamp(), meta.Seq())
+	}
+	put(db, "c")
+	if meta := metaOfKey(t, db, "c"); meta.Timestamp() != 1000 || meta.Seq() != 2 {
+		t.Errorf("expected c stamped 1000.2, got %d.%d", meta.Timestamp(), meta.Seq())
+	}
+}
+
+func TestDB_Stamps_NoSiteID(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	db := openSyncDB(t, "/tmp/nutsdbteststamps", 0, clock)
+	defer db.Close()
+
+	for _, key := range []string{"a", "b"} {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(key), []byte("v"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the entries are written as by the versions without stamps.
+	if meta := metaOfKey(t, db, "b"); meta.Timestamp() != 1000 || meta.Seq() != 0 {
+		t.Errorf("expected b stamped 1000.0, got %d.%d", meta.Timestamp(), meta.Seq())
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+// FileStat represents the statistics of a data file.
+//
+// Entries, Size and Tombstones are tracked as the entries are written. The live
+// and expired entries are those of the BPTree index pointing into the file, so
+// the entries of sets, sorted sets and lists are always counted as live.
+type FileStat struct {
+	FileID int64
+
+	// Entries and Size count every entry written to the file, committed or not.
+	Entries int64
+	Size    int64
+
+	// Tombstones counts the delete entries of the BPTree buckets.
+	Tombstones int64
+
+	// LiveEntries and LiveSize count the entries a merge keeps.
+	LiveEntries int64
+	LiveSize    int64
+
+	// ExpiredEntries and ExpiredSize count the entries whose TTL has passed.
+	ExpiredEntries int64
+	ExpiredSize    int64
+
+	// DeadEntries and DeadSize count the overwritten, deleted and uncommitted
+	// entries, and the tombstones.
+	DeadEntries int64
+	DeadSize    int64
+}
+
+// DirtyRatio returns the part of the size of the file a merge would free, from 0 to 1.
+func (s FileStat) DirtyRatio() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+
+	return float64(s.DeadSize+s.ExpiredSize) / float64(s.Size)
+}
+
+// fileCounter represents the counters of a data file tracked as the entries are written.
+type fileCounter struct {
+	entries    int64
+	size       int64
+	tombstones int64
+
+	// the entries of sets, sorted sets and lists, counted as live.
+	otherEntries int64
+	otherSize    int64
+
+	// the entries of sorted sets and lists, which a partial merge cannot reorder.
+	orderedEntries int64
+
+	// the range of the txIDs and the timestamps of the entries, see ManifestFile.
+	minTxID, maxTxID           uint64
+	minTimestamp, maxTimestamp uint64
+
+	// the entries of every bucket, by data structure, see BucketStats.
+	buckets map[string]*bucketCounter
+}
+
+// bucketCounter represents the counters of the entries of a bucket in a data file,
+// indexed by data structure.
+type bucketCounter struct {
+	entries [DataStructureList + 1]int64
+	size    [DataStructureList + 1]int64
+}
+
+func (c *fileCounter) add(meta *MetaData) {
+	size := int64(DataEntryHeaderSize + meta.keySize + meta.valueSize + meta.bucketSize)
+
+	if c.entries == 0 || meta.txID < c.minTxID {
+		c.minTxID = meta.txID
+	}
+	if meta.txID > c.maxTxID {
+		c.maxTxID = meta.txID
+	}
+	if c.entries == 0 || meta.timestamp < c.minTimestamp {
+		c.minTimestamp = meta.timestamp
+	}
+	if meta.timestamp > c.maxTimestamp {
+		c.maxTimestamp = meta.timestamp
+	}
+
+	c.entries++
+	c.size += size
+
+	if meta.ds <= DataStructureList {
+		b, ok := c.buckets[string(meta.bucket)]
+		if !ok {
+			if c.buckets == nil {
+				c.buckets = make(map[string]*bucketCounter)
+			}
+			b = &bucketCounter{}
+			c.buckets[string(meta.bucket)] = b
+		}
+		b.entries[meta.ds]++
+		b.size[meta.ds] += size
+	}
+
+	if meta.ds == DataStructureList || meta.ds == DataStructureSortedSet {
+		c.orderedEntries++
+	}
+
+	if meta.ds != DataStructureBPTree {
+		c.otherEntries++
+		c.otherSize += size
+	} else if meta.Flag == DataDeleteFlag {
+		c.tombstones++
+	}
+}
+
+// countWrite counts the entry written to the data file fID, if the counters are loaded.
+// It must be called with the db.writeMu lock held.
+func (db *DB) countWrite(fID int64, e *Entry) {
+	if db.fileCounters == nil {
+		return
+	}
+
+	c, ok := db.fileCounters[fID]
+	if !ok {
+		c = &fileCounter{}
+		db.fileCounters[fID] = c
+	}
+
+	c.add(e.Meta)
+}
+
+// loadFileCounters loads the counters of the data files from the hint files,
+// or from the data files when there are none. The counters are then tracked by
+// countWrite, so they are only loaded by the first FileStats.
+// It must be called with the db.writeMu lock held.
+func (db *DB) loadFileCounters() error {
+	if db.fileCounters != nil {
+		return nil
+	}
+
+	counters := make(map[int64]*fileCounter)
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	for _, id := range dataFileIds {
+		fID := int64(id)
+		c := &fileCounter{}
+		counters[fID] = c
+
+		if db.isHintEnabled() && fID != db.MaxFileID {
+			if hints, err := db.readHintFile(fID, nil); err == nil {
+				for _, h := range hints {
+					c.add(h.meta)
+				}
+				continue
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if fID == db.MaxFileID {
+			// the active file is only read up to its write offset.
+			if err := db.scanActiveFile(func(e *Entry) { c.add(e.Meta) }); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := db.scanDataFile(fID, func(e *Entry, _ int64) { c.add(e.Meta) }); err != nil {
+			return err
+		}
+	}
+
+	db.fileCounters = counters
+
+	return nil
+}
+
+// scanActiveFile calls fn for every entry of the active file.
+func (db *DB) scanActiveFile(fn func(e *Entry)) error {
+	r := newDataFileReaderAt(db.ActiveFile, 0, db.ActiveFile.writeOff, defaultRecoveryReadBufferSize)
+	for {
+		e, err := r.Next()
+		if err == io.EOF || err == nil && e == nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fn(e)
+	}
+}
+
+// liveStats fills the live and expired counts of the stats from the BPTree index.
+// It must be called with the db.mu lock held.
+func (db *DB) liveStats(stats map[int64]*FileStat) {
+	db.loadBPTreeIdxes()
+	for _, t := range db.BPTreeIdx {
+		_, _, pointers := t.getAll()
+		for _, p := range pointers {
+			r, ok := p.(*Record)
+			if !ok || r.H == nil || r.H.meta == nil || r.H.meta.Flag == DataDeleteFlag {
+				continue
+			}
+
+			s, ok := stats[r.H.fileID]
+			if !ok {
+				continue
+			}
+
+			size := int64(DataEntryHeaderSize + r.H.meta.keySize + r.H.meta.valueSize + r.H.meta.bucketSize)
+			if r.IsExpired() {
+				s.ExpiredEntries++
+				s.ExpiredSize += size
+			} else {
+				s.LiveEntries++
+				s.LiveSize += size
+			}
+		}
+	}
+}
+
+// FileStats returns the statistics of the data files, sorted by file ID.
+// The first call loads the counters of the files, reading the hint files or,
+// in HintKeyValAndRAMIdxMode, the data files.
+func (db *DB) FileStats() ([]FileStat, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if err := db.loadFileCounters(); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[int64]*FileStat, len(db.fileCounters))
+	for fID, c := range db.fileCounters {
+		stats[fID] = &FileStat{
+			FileID:      fID,
+			Entries:     c.entries,
+			Size:        c.size,
+			Tombstones:  c.tombstones,
+			LiveEntries: c.otherEntries,
+			LiveSize:    c.otherSize,
+		}
+	}
+
+	db.mu.RLock()
+	unlock := db.rlockAllBuckets()
+	db.liveStats(stats)
+	unlock()
+	db.mu.RUnlock()
+
+	result := make([]FileStat, 0, len(stats))
+	for _, s := range stats {
+		s.DeadEntries = s.Entries - s.LiveEntries - s.ExpiredEntries
+		s.DeadSize = s.Size - s.LiveSize - s.ExpiredSize
+		result = append(result, *s)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].FileID < result[j].FileID })
+
+	return result, nil
+}
+
+// dirtiestFiles returns the IDs of at most n sealed data files with a dirty ratio of
+// at least minRatio and some garbage, the dirtiest first. n <= 0 means no limit.
+// The files holding list or sorted set entries are skipped.
+func (db *DB) dirtiestFiles(n int, minRatio float64) ([]int, error) {
+	stats, err := db.FileStats()
+	if err != nil {
+		return nil, err
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	var dirty []FileStat
+	for _, s := range stats {
+		if s.FileID == db.MaxFileID || s.DeadSize+s.ExpiredSize == 0 || s.DirtyRatio() < minRatio {
+			continue
+		}
+		if c, ok := db.fileCounters[s.FileID]; !ok || c.orderedEntries > 0 {
+			continue
+		}
+		dirty = append(dirty, s)
+	}
+
+	sort.SliceStable(dirty, func(i, j int) bool { return dirty[i].DirtyRatio() > dirty[j].DirtyRatio() })
+
+	if n > 0 && len(dirty) > n {
+		dirty = dirty[:n]
+	}
+
+	fIDs := make([]int, 0, len(dirty))
+	for _, s := range dirty {
+		fIDs = append(fIDs, int(s.FileID))
+	}
+
+	return fIDs, nil
+}
+
+// BucketStat represents the statistics of a bucket of a data structure, e.g. for
+// telling which data structure of which bucket the data files grow with.
+type BucketStat struct {
+	Bucket string
+	DS     uint16 // the data structure, see DataStructureBPTree
+
+	// Keys counts the keys of the bucket: the keys of a BPTree bucket neither deleted
+	// nor expired, the keys of the sets and the lists, and the members of a sorted set.
+	Keys int64
+
+	// Entries and Size count the entries of the bucket in the data files, live or not,
+	// tracked as the entries are written like those of FileStat.
+	Entries int64
+	Size    int64
+}
+
+// BucketStats returns the statistics of every bucket of every data structure, sorted by
+// bucket and data structure. The first call loads the counters of the files, see FileStats.
+func (db *DB) BucketStats() ([]BucketStat, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if err := db.loadFileCounters(); err != nil {
+		return nil, err
+	}
+
+	type bucketDS struct {
+		bucket string
+		ds     uint16
+	}
+	stats := make(map[bucketDS]*BucketStat)
+	stat := func(bucket string, ds uint16) *BucketStat {
+		s, ok := stats[bucketDS{bucket, ds}]
+		if !ok {
+			s = &BucketStat{Bucket: bucket, DS: ds}
+			stats[bucketDS{bucket, ds}] = s
+		}
+		return s
+	}
+
+	for _, c := range db.fileCounters {
+		for bucket, b := range c.buckets {
+			for ds := range b.entries {
+				if b.entries[ds] == 0 {
+					continue
+				}
+				s := stat(bucket, uint16(ds))
+				s.Entries += b.entries[ds]
+				s.Size += b.size[ds]
+			}
+		}
+	}
+
+	db.mu.RLock()
+	unlock := db.rlockAllBuckets()
+	db.loadBPTreeIdxes()
+	for bucket, t := range db.BPTreeIdx {
+		_, _, pointers := t.getAll()
+		for _, p := range pointers {
+			r, ok := p.(*Record)
+			if ok && r.H != nil && r.H.meta != nil && r.H.meta.Flag != DataDeleteFlag && !r.IsExpired() {
+				stat(bucket, DataStructureBPTree).Keys++
+			}
+		}
+	}
+	for bucket, set := range db.SetIdx {
+		stat(bucket, DataStructureSet).Keys += int64(len(set.M))
+	}
+	for bucket, list := range db.ListIdx {
+		stat(bucket, DataStructureList).Keys += int64(len(list.Items))
+	}
+	for bucket, sortedSet := range db.SortedSetIdx {
+		stat(bucket, DataStructureSortedSet).Keys += int64(sortedSet.Size())
+	}
+	unlock()
+	db.mu.RUnlock()
+
+	result := make([]BucketStat, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, *s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Bucket != result[j].Bucket {
+			return result[i].Bucket < result[j].Bucket
+		}
+		return result[i].DS < result[j].DS
+	})
+
+	return result, nil
+}
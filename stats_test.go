@@ -0,0 +1,193 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDB_FileStats(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestfilestats", true)
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 1024
+		// the entries are an hour old, so the TTL ones are expired.
+		opt.Clock = NewManualClock(time.Now().Add(-time.Hour))
+
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update := func(fn func(tx *Tx) error) {
+			if err := db.Update(fn); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < 20; i++ {
+			update(func(tx *Tx) error {
+				return tx.Put("bucket", []byte("key"), make([]byte, 100), Persistent)
+			})
+		}
+		update(func(tx *Tx) error { return tx.Delete("bucket", []byte("key")) })
+		update(func(tx *Tx) error { return tx.Put("bucket", []byte("ttl"), []byte("val"), 60) })
+		update(func(tx *Tx) error { return tx.Put("bucket", []byte("live"), []byte("val"), Persistent) })
+
+		check := func(entries int64) []FileStat {
+			stats, err := db.FileStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(stats) < 2 {
+				t.Fatalf("expected several data files, got %d", len(stats))
+			}
+
+			var sum FileStat
+			for _, s := range stats {
+				sum.Entries += s.Entries
+				sum.Size += s.Size
+				sum.Tombstones += s.Tombstones
+				sum.LiveEntries += s.LiveEntries
+				sum.ExpiredEntries += s.ExpiredEntries
+				sum.DeadEntries += s.DeadEntries
+				sum.DeadSize += s.DeadSize + s.LiveSize + s.ExpiredSize
+			}
+
+			if sum.Entries != entries || sum.Tombstones != 1 || sum.LiveEntries != entries-22 ||
+				sum.ExpiredEntries != 1 || sum.DeadEntries != 21 || sum.DeadSize != sum.Size {
+				t.Fatalf("mode %d: unexpected stats %+v", mode, sum)
+			}
+
+			return stats
+		}
+
+		check(23)
+
+		// the counters are tracked after the first FileStats.
+		update(func(tx *Tx) error { return tx.Put("bucket", []byte("live2"), []byte("val"), Persistent) })
+		stats := check(24)
+
+		fIDs, err := db.dirtiestFiles(1, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fIDs) != 1 || int64(fIDs[0]) == db.MaxFileID || stats[fIDs[0]].DirtyRatio() < 0.5 {
+			t.Fatalf("mode %d: unexpected dirtiest files %v", mode, fIDs)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the counters are loaded again when reopening.
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(24)
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_BucketStats(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbucketstats", true)
+	opt.SegmentSize = 1024
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(fn func(tx *Tx) error) {
+		if err := db.Update(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		update(func(tx *Tx) error {
+			return tx.Put("kv", []byte{'k', byte('0' + i%3)}, make([]byte, 100), Persistent)
+		})
+	}
+	update(func(tx *Tx) error { return tx.Delete("kv", []byte("k0")) })
+	update(func(tx *Tx) error { return tx.SAdd("mixed", []byte("set"), []byte("a"), []byte("b")) })
+	update(func(tx *Tx) error { return tx.RPush("mixed", []byte("list1"), []byte("a")) })
+	update(func(tx *Tx) error { return tx.RPush("mixed", []byte("list2"), []byte("a"), []byte("b")) })
+	update(func(tx *Tx) error { return tx.ZAdd("mixed", []byte("m1"), 1, []byte("a")) })
+
+	check := func() {
+		stats, err := db.BucketStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []BucketStat{
+			{Bucket: "kv", DS: DataStructureBPTree, Keys: 2, Entries: 11},
+			{Bucket: "mixed", DS: DataStructureSet, Keys: 1, Entries: 2},
+			{Bucket: "mixed", DS: DataStructureSortedSet, Keys: 1, Entries: 1},
+			{Bucket: "mixed", DS: DataStructureList, Keys: 2, Entries: 3},
+		}
+		if len(stats) != len(want) {
+			t.Fatalf("TestDB_BucketStats err: got %+v", stats)
+		}
+		for i, s := range stats {
+			w := want[i]
+			if s.Bucket != w.Bucket || s.DS != w.DS || s.Keys != w.Keys || s.Entries != w.Entries || s.Size <= 0 {
+				t.Errorf("TestDB_BucketStats err: got %+v, want %+v", s, w)
+			}
+		}
+		if stats[0].Size < 10*100 {
+			t.Errorf("TestDB_BucketStats err: size %d of the values", stats[0].Size)
+		}
+	}
+
+	check()
+
+	// the counters are tracked after the first BucketStats.
+	update(func(tx *Tx) error { return tx.Put("kv", []byte("k3"), []byte("val"), Persistent) })
+	stats, err := db.BucketStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[0].Keys != 3 || stats[0].Entries != 12 {
+		t.Errorf("TestDB_BucketStats err: got %+v", stats[0])
+	}
+	update(func(tx *Tx) error { return tx.Delete("kv", []byte("k3")) })
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the counters are loaded again when reopening, the two writes of k3 included.
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stats, err = db.BucketStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[0].Keys != 2 || stats[0].Entries != 13 || len(stats) != 4 {
+		t.Errorf("TestDB_BucketStats err: got %+v", stats)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrNotSupportStorage is returned when the operation does not support databases
+// stored in the Storage of the options, e.g. because it copies their files with the OS.
+var ErrNotSupportStorage = errors.New("not support databases stored in Options.Storage")
+
+// Storage represents the file system storing the files of a database, see Options.Storage.
+// The paths are the ones of the OS layout, rooted at Options.Dir and slash separated.
+// The errors of missing files must satisfy os.IsNotExist.
+type Storage interface {
+	// OpenFile opens the named file with the flags of os.OpenFile,
+	// only O_RDONLY, O_RDWR, O_CREATE, O_EXCL and O_TRUNC are used.
+	OpenFile(name string, flag int, perm os.FileMode) (StorageFile, error)
+
+	// ReadDir returns the files of the named dir sorted by name.
+	ReadDir(dir string) ([]os.FileInfo, error)
+
+	// MkdirAll creates the named dir and its parents, if not exist.
+	MkdirAll(dir string, perm os.FileMode) error
+
+	// Remove removes the named file or empty dir.
+	Remove(name string) error
+
+	// Rename replaces the file newpath with the file oldpath.
+	Rename(oldpath, newpath string) error
+
+	// Stat returns the FileInfo of the named file or dir.
+	Stat(name string) (os.FileInfo, error)
+}
+
+// StorageFile represents a file opened by a Storage. Opening a dir read-only
+// returns a StorageFile only used to Sync it.
+type StorageFile interface {
+	io.Reader
+	io.Writer
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+
+	// Stat returns the FileInfo of the file.
+	Stat() (os.FileInfo, error)
+
+	// Sync commits the content of the file to stable storage.
+	Sync() error
+
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
+}
+
+// osStorage represents the Storage of the OS file system, the default one.
+type osStorage struct{}
+
+// OpenFile is a wrapper of os.OpenFile.
+func (osStorage) OpenFile(name string, flag int, perm os.FileMode) (StorageFile, error) {
+	fd, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return fd, nil
+}
+
+// ReadDir is a wrapper of ioutil.ReadDir.
+func (osStorage) ReadDir(dir string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dir)
+}
+
+// MkdirAll is a wrapper of os.MkdirAll.
+func (osStorage) MkdirAll(dir string, perm os.FileMode) error {
+	return os.MkdirAll(dir, perm)
+}
+
+// Remove is a wrapper of os.Remove.
+func (osStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Rename is a wrapper of os.Rename.
+func (osStorage) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Stat is a wrapper of os.Stat.
+func (osStorage) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// readStorageFile returns the content of the named file of s.
+func readStorageFile(s Storage, name string) ([]byte, error) {
+	fd, err := s.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return ioutil.ReadAll(fd)
+}
+
+// newStorageFileIORWManager returns a newly initialized FileIORWManager of the file of s at given path,
+// created with perm if not exist.
+func newStorageFileIORWManager(s Storage, path string, capacity int64, perm os.FileMode) (*FileIORWManager, error) {
+	fd, err := s.OpenFile(path, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := truncateFile(fd, capacity); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return &FileIORWManager{fd: fd}, nil
+}
+
+// truncateFile grows fd to capacity, if smaller.
+func truncateFile(fd StorageFile, capacity int64) error {
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() < capacity {
+		return fd.Truncate(capacity)
+	}
+
+	return nil
+}
+
+// writeStorageFileAtomic writes buf to the file of s at given path, created with perm, see writeFileAtomic.
+func writeStorageFileAtomic(s Storage, path string, buf []byte, sync bool, perm os.FileMode) error {
+	return writeStorageAtomic(s, path, sync, perm, func(fd StorageFile) error {
+		_, err := fd.Write(buf)
+		return err
+	})
+}
+
+// writeStorageAtomic calls write with a temporary file of s created with perm, then renames it
+// to the given path. If sync is true, the file is synced before and its dir after the rename.
+func writeStorageAtomic(s Storage, p string, sync bool, perm os.FileMode, write func(fd StorageFile) error) error {
+	tmpPath := p + TempSuffix
+
+	fd, err := s.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := write(fd); err != nil {
+		fd.Close()
+		return err
+	}
+
+	if sync {
+		if err := fd.Sync(); err != nil {
+			fd.Close()
+			return err
+		}
+	}
+
+	if err := fd.Close(); err != nil {
+		return err
+	}
+
+	if err := s.Rename(tmpPath, p); err != nil {
+		return err
+	}
+
+	if sync {
+		return syncStorageDir(s, path.Dir(p))
+	}
+
+	return nil
+}
+
+// syncStorageDir syncs the dir of s, making the renames and creations of its files durable.
+func syncStorageDir(s Storage, dir string) error {
+	fd, err := s.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	return fd.Sync()
+}
+
+// syncStorageFile syncs the file at given path of s.
+func syncStorageFile(s Storage, name string) error {
+	fd, err := s.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	return fd.Sync()
+}
+
+// removeStorageTempFiles removes the temporary files left in the dir of s by an interrupted writeAtomic.
+func removeStorageTempFiles(s Storage, dir string) error {
+	files, err := s.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), TempSuffix) {
+			if err := s.Remove(dir + "/" + f.Name()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeStorageAll removes the dir of s and the files and dirs it contains.
+func removeStorageAll(s Storage, dir string) error {
+	files, err := s.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, f := range files {
+		p := dir + "/" + f.Name()
+		if f.IsDir() {
+			err = removeStorageAll(s, p)
+		} else {
+			err = s.Remove(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Remove(dir)
+}
+
+// writeFileAtomic writes buf to the file of the database at given path, see writeFileAtomic.
+func (db *DB) writeFileAtomic(path string, buf []byte, sync bool) error {
+	return writeStorageFileAtomic(db.storage, path, buf, sync, db.filePerm())
+}
+
+// checkOSFiles returns an error if the files of the database are not the ones of the OS,
+// for the operations copying them.
+func (db *DB) checkOSFiles() error {
+	if db.fsys != nil {
+		return ErrNotSupportFS
+	}
+
+	if db.opt.Storage != nil {
+		return ErrNotSupportStorage
+	}
+
+	return nil
+}
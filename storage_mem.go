@@ -0,0 +1,419 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemStorage represents a Storage keeping the files in memory, e.g. to run a database
+// in a browser with GOOS=js and GOARCH=wasm, or in tests. The files live as long as
+// the MemStorage, a database opened again with it finds the ones of the previous one.
+// A file only holds the bytes written to it, growing it to the SegmentSize is free.
+type MemStorage struct {
+	mu    sync.Mutex
+	files map[string]*memFileData
+	dirs  map[string]*memFileInfo
+}
+
+// memFileData represents the content of a file of a MemStorage.
+type memFileData struct {
+	mu      sync.RWMutex
+	buf     []byte
+	size    int64
+	perm    os.FileMode
+	modTime time.Time
+}
+
+// NewMemStorage returns a newly initialized MemStorage with the root dir only.
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		files: make(map[string]*memFileData),
+		dirs: map[string]*memFileInfo{
+			"/": newMemDirInfo("/", DefaultDirPerm),
+			".": newMemDirInfo(".", DefaultDirPerm),
+		},
+	}
+}
+
+// memPathError returns the *os.PathError of op on name.
+func memPathError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// OpenFile opens the named file with the flags of os.OpenFile.
+func (s *MemStorage) OpenFile(name string, flag int, perm os.FileMode) (StorageFile, error) {
+	name = path.Clean(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dirs[name]; ok {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, memPathError("open", name, os.ErrInvalid)
+		}
+		return &memFile{name: name, dir: true}, nil
+	}
+
+	d, ok := s.files[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, memPathError("open", name, os.ErrExist)
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, memPathError("open", name, os.ErrNotExist)
+	case !ok:
+		if _, ok := s.dirs[path.Dir(name)]; !ok {
+			return nil, memPathError("open", name, os.ErrNotExist)
+		}
+		d = &memFileData{perm: perm.Perm(), modTime: time.Now()}
+		s.files[name] = d
+	}
+
+	f := &memFile{name: name, d: d, writable: flag&(os.O_WRONLY|os.O_RDWR) != 0}
+	if flag&os.O_TRUNC != 0 && f.writable {
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// ReadDir returns the files of the named dir sorted by name.
+func (s *MemStorage) ReadDir(dir string) ([]os.FileInfo, error) {
+	dir = path.Clean(dir)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dirs[dir]; !ok {
+		return nil, memPathError("readdir", dir, os.ErrNotExist)
+	}
+
+	var files []os.FileInfo
+	for name, d := range s.files {
+		if path.Dir(name) == dir {
+			files = append(files, d.stat(name))
+		}
+	}
+	for name, info := range s.dirs {
+		if name != dir && path.Dir(name) == dir {
+			files = append(files, info)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	return files, nil
+}
+
+// MkdirAll creates the named dir and its parents, if not exist.
+func (s *MemStorage) MkdirAll(dir string, perm os.FileMode) error {
+	dir = path.Clean(dir)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p := dir; ; p = path.Dir(p) {
+		if _, ok := s.files[p]; ok {
+			return memPathError("mkdir", p, os.ErrExist)
+		}
+		if _, ok := s.dirs[p]; ok {
+			break
+		}
+		s.dirs[p] = newMemDirInfo(p, perm)
+	}
+
+	return nil
+}
+
+// Remove removes the named file or empty dir.
+func (s *MemStorage) Remove(name string) error {
+	name = path.Clean(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[name]; ok {
+		delete(s.files, name)
+		return nil
+	}
+
+	if _, ok := s.dirs[name]; !ok {
+		return memPathError("remove", name, os.ErrNotExist)
+	}
+
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p := range s.files {
+		if strings.HasPrefix(p, prefix) {
+			return memPathError("remove", name, os.ErrExist)
+		}
+	}
+	for p := range s.dirs {
+		if strings.HasPrefix(p, prefix) {
+			return memPathError("remove", name, os.ErrExist)
+		}
+	}
+	delete(s.dirs, name)
+
+	return nil
+}
+
+// Rename replaces the file newpath with the file oldpath.
+func (s *MemStorage) Rename(oldpath, newpath string) error {
+	oldpath, newpath = path.Clean(oldpath), path.Clean(newpath)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if _, ok := s.dirs[newpath]; ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+	}
+	if _, ok := s.dirs[path.Dir(newpath)]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+
+	delete(s.files, oldpath)
+	s.files[newpath] = d
+
+	return nil
+}
+
+// Stat returns the FileInfo of the named file or dir.
+func (s *MemStorage) Stat(name string) (os.FileInfo, error) {
+	name = path.Clean(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.files[name]; ok {
+		return d.stat(name), nil
+	}
+
+	if info, ok := s.dirs[name]; ok {
+		return info, nil
+	}
+
+	return nil, memPathError("stat", name, os.ErrNotExist)
+}
+
+// stat returns the FileInfo of the file at given name.
+func (d *memFileData) stat(name string) os.FileInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return &memFileInfo{name: path.Base(name), size: d.size, mode: d.perm, modTime: d.modTime}
+}
+
+// memFile represents a file opened by a MemStorage.
+type memFile struct {
+	name     string
+	d        *memFileData
+	dir      bool
+	writable bool
+	off      int64
+	closed   bool
+}
+
+// check returns an error if f is closed or a dir, or not writable when write is true.
+func (f *memFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return memPathError(op, f.name, os.ErrClosed)
+	case f.dir:
+		return memPathError(op, f.name, os.ErrInvalid)
+	case write && !f.writable:
+		return memPathError(op, f.name, os.ErrPermission)
+	}
+
+	return nil
+}
+
+// Read reads up to len(b) bytes at the offset of f and advances it.
+func (f *memFile) Read(b []byte) (n int, err error) {
+	n, err = f.ReadAt(b, f.off)
+	f.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Write writes b at the offset of f and advances it.
+func (f *memFile) Write(b []byte) (n int, err error) {
+	n, err = f.WriteAt(b, f.off)
+	f.off += int64(n)
+
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the file starting at byte offset off.
+// The bytes never written up to the size of the file are zero.
+func (f *memFile) ReadAt(b []byte, off int64) (n int, err error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, memPathError("read", f.name, os.ErrInvalid)
+	}
+
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+
+	if off >= f.d.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(b))
+	if end > f.d.size {
+		end = f.d.size
+	}
+	n = int(end - off)
+
+	copied := 0
+	if off < int64(len(f.d.buf)) {
+		copied = copy(b[:n], f.d.buf[off:])
+	}
+	for i := copied; i < n; i++ {
+		b[i] = 0
+	}
+
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// WriteAt writes len(b) bytes to the file starting at byte offset off.
+func (f *memFile) WriteAt(b []byte, off int64) (n int, err error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, memPathError("write", f.name, os.ErrInvalid)
+	}
+
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	end := off + int64(len(b))
+	if end > int64(len(f.d.buf)) {
+		if end > int64(cap(f.d.buf)) {
+			buf := make([]byte, end, 2*end)
+			copy(buf, f.d.buf)
+			f.d.buf = buf
+		} else {
+			grown := f.d.buf[len(f.d.buf):end]
+			for i := range grown {
+				grown[i] = 0
+			}
+			f.d.buf = f.d.buf[:end]
+		}
+	}
+	copy(f.d.buf[off:], b)
+
+	if end > f.d.size {
+		f.d.size = end
+	}
+	f.d.modTime = time.Now()
+
+	return len(b), nil
+}
+
+// Close closes the file.
+func (f *memFile) Close() error {
+	if f.closed {
+		return memPathError("close", f.name, os.ErrClosed)
+	}
+	f.closed = true
+
+	return nil
+}
+
+// Stat returns the FileInfo of the file.
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, memPathError("stat", f.name, os.ErrClosed)
+	}
+	if f.dir {
+		return &memFileInfo{name: path.Base(f.name), mode: os.ModeDir}, nil
+	}
+
+	return f.d.stat(f.name), nil
+}
+
+// Sync does nothing, the content is in memory.
+func (f *memFile) Sync() error {
+	if f.closed {
+		return memPathError("sync", f.name, os.ErrClosed)
+	}
+
+	return nil
+}
+
+// Truncate changes the size of the file.
+func (f *memFile) Truncate(size int64) error {
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return memPathError("truncate", f.name, os.ErrInvalid)
+	}
+
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if size < int64(len(f.d.buf)) {
+		f.d.buf = f.d.buf[:size]
+	}
+	f.d.size = size
+	f.d.modTime = time.Now()
+
+	return nil
+}
+
+// memFileInfo represents the FileInfo of a file or dir of a MemStorage.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// newMemDirInfo returns the FileInfo of a dir created at given name with perm.
+func newMemDirInfo(name string, perm os.FileMode) *memFileInfo {
+	return &memFileInfo{name: path.Base(name), mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
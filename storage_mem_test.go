@@ -0,0 +1,111 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMemStorage(t *testing.T) {
+	s := NewMemStorage()
+
+	if _, err := s.OpenFile("/db/a", os.O_CREATE|os.O_RDWR, 0644); !os.IsNotExist(err) {
+		t.Errorf("err OpenFile without dir. got %v want not exist", err)
+	}
+
+	if err := s.MkdirAll("/db/sub", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := s.OpenFile("/db/a", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := truncateFile(fd, 16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.WriteAt([]byte("abc"), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 16)
+	if n, err := fd.ReadAt(buf, 0); n != 16 || err != nil {
+		t.Fatalf("err ReadAt. got %d %v", n, err)
+	}
+	if want := append(append(make([]byte, 4), "abc"...), make([]byte, 9)...); !bytes.Equal(buf, want) {
+		t.Errorf("err ReadAt. got %v want %v", buf, want)
+	}
+	if n, err := fd.ReadAt(buf, 8); n != 8 || err != io.EOF {
+		t.Errorf("err ReadAt past the end. got %d %v", n, err)
+	}
+
+	if err := fd.Truncate(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.Truncate(8); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := fd.ReadAt(buf[:8], 0); n != 8 || !bytes.Equal(buf[:8], make([]byte, 8)) {
+		t.Errorf("expected the truncated bytes to read as zero, got %v", buf[:8])
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.Write([]byte("x")); err == nil {
+		t.Error("expected an error writing a closed file")
+	}
+
+	if _, err := s.OpenFile("/db/a", os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644); !os.IsExist(err) {
+		t.Errorf("err OpenFile O_EXCL. got %v want exist", err)
+	}
+
+	if err := writeStorageFileAtomic(s, "/db/b", []byte("content"), true, DefaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readStorageFile(s, "/db/b"); err != nil || string(got) != "content" {
+		t.Errorf("err readStorageFile. got %q %v", got, err)
+	}
+
+	files, err := s.ReadDir("/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if got := len(names); got != 3 || names[0] != "a" || names[1] != "b" || names[2] != "sub" || !files[2].IsDir() {
+		t.Errorf("err ReadDir. got %v", names)
+	}
+
+	if err := s.Remove("/db"); err == nil {
+		t.Error("expected an error removing a dir not empty")
+	}
+	if err := s.Remove("/db/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("/db/a"); !os.IsNotExist(err) {
+		t.Errorf("err Stat of the removed file. got %v want not exist", err)
+	}
+	if err := s.Rename("/db/b", "/db/sub/c"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Stat("/db/sub/c"); err != nil || info.Size() != int64(len("content")) {
+		t.Errorf("err Stat of the renamed file. got %v %v", info, err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestOpen_MemStorage(t *testing.T) {
+	dir := "/tmp/nutsdbtestmemstorage"
+	os.RemoveAll(dir)
+
+	memOpt := DefaultOptions
+	memOpt.Dir = dir
+	memOpt.SegmentSize = 8 * 1024
+	memOpt.StartFileLoadingMode = MMap
+	memOpt.Storage = NewMemStorage()
+
+	db, err := Open(memOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 100; j++ {
+			key := []byte(fmt.Sprintf("key_%03d", j))
+			value := []byte(fmt.Sprintf("value_%d_%03d", i, j))
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put("bucket", key, value, Persistent)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Delete("bucket", []byte("key_000"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Backup(t.TempDir()); err != ErrNotSupportStorage {
+		t.Errorf("err Backup. got %v want %v", err, ErrNotSupportStorage)
+	}
+	if err := db.Checkpoint(t.TempDir()); err != ErrNotSupportStorage {
+		t.Errorf("err Checkpoint. got %v want %v", err, ErrNotSupportStorage)
+	}
+
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memOpt.Storage.Stat(dir + "/0" + DataSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected the merge to remove the data file 0, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected no file in the OS dir, got %v", err)
+	}
+
+	db, err = Open(memOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *Tx) error {
+		if _, err := tx.Get("bucket", []byte("key_000")); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("err Get of the deleted key. got %v want %v", err, ErrKeyNotFound)
+		}
+
+		for j := 1; j < 100; j++ {
+			e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%03d", j)))
+			if err != nil {
+				return err
+			}
+			if want := fmt.Sprintf("value_9_%03d", j); string(e.Value) != want {
+				t.Errorf("err Get. got %s want %s", e.Value, want)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpen_MemStorageSparseIdxMode(t *testing.T) {
+	memOpt := DefaultOptions
+	memOpt.Dir = "/nutsdb"
+	memOpt.EntryIdxMode = HintBPTSparseIdxMode
+	memOpt.Storage = NewMemStorage()
+
+	var modeErr *ModeError
+	if _, err := Open(memOpt); !errors.As(err, &modeErr) {
+		t.Errorf("err Open. got %v want a *ModeError", err)
+	}
+}
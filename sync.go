@@ -0,0 +1,550 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"sort"
+)
+
+// SyncBucket is the bucket in which the last transaction ID applied by ApplySyncDelta is
+// stored for every source site.
+const SyncBucket = "__nutsdb_sync__"
+
+// DefaultSyncMaxEntries is the max entries of a SyncDelta asked by SyncManifest.
+const DefaultSyncMaxEntries = 1024
+
+var (
+	// ErrSiteIDRequired is returned when Diff, ApplySyncDelta or SyncFrom is called on a
+	// database, or with a manifest or a delta, without a site ID.
+	ErrSiteIDRequired = errors.New("sync requires a site id")
+
+	// ErrSameSiteID is returned when two databases with the same site ID are synced.
+	ErrSameSiteID = errors.New("sync between databases with the same site id")
+
+	// ErrSyncDataCorrupted is returned when a SyncManifest or a SyncDelta cannot be decoded.
+	ErrSyncDataCorrupted = wrapError("sync data corrupted", ErrCorrupted)
+
+	// ErrSyncConflict is returned by ApplySyncDelta when an entry relayed by a third site has
+	// the stamp of the committed value of its key but another value, so that neither wins,
+	// e.g. for the entries written within a second before the writes were stamped.
+	ErrSyncConflict = errors.New("sync entry conflicts with a value of the same stamp")
+)
+
+// SyncManifest represents the sync progress of a database: for every source site, the
+// last transaction ID of the source applied to it. It is sent to the source, whose Diff
+// returns the entries committed since.
+//
+//	|--------------------------------------------------------------|
+//	| siteID | maxEntries | count | siteID | txID | siteID | txID ...
+//	|--------------------------------------------------------------|
+//	| uint16 |   uint32   | uint32| uint16 |uint64| uint16 |uint64 ...
+//	|--------------------------------------------------------------|
+type SyncManifest struct {
+	// SiteID is the site ID of the database.
+	SiteID uint16
+
+	// Synced is the last transaction ID applied by source site ID.
+	Synced map[uint16]uint64
+
+	// MaxEntries is the max entries of the SyncDelta returned by Diff, which ends at a
+	// transaction boundary, so it may hold more. 0 means no limit.
+	MaxEntries int
+}
+
+// MarshalBinary encodes the manifest.
+func (m SyncManifest) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 10+len(m.Synced)*10)
+	binary.LittleEndian.PutUint16(buf[0:2], m.SiteID)
+	binary.LittleEndian.PutUint32(buf[2:6], uint32(m.MaxEntries))
+	binary.LittleEndian.PutUint32(buf[6:10], uint32(len(m.Synced)))
+
+	off := 10
+	for siteID, txID := range m.Synced {
+		binary.LittleEndian.PutUint16(buf[off:], siteID)
+		binary.LittleEndian.PutUint64(buf[off+2:], txID)
+		off += 10
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a manifest encoded by MarshalBinary.
+func (m *SyncManifest) UnmarshalBinary(data []byte) error {
+	if len(data) < 10 {
+		return ErrSyncDataCorrupted
+	}
+
+	n := int(binary.LittleEndian.Uint32(data[6:10]))
+	if len(data) != 10+n*10 {
+		return ErrSyncDataCorrupted
+	}
+
+	m.SiteID = binary.LittleEndian.Uint16(data[0:2])
+	m.MaxEntries = int(binary.LittleEndian.Uint32(data[2:6]))
+	m.Synced = make(map[uint16]uint64, n)
+	for off := 10; off < len(data); off += 10 {
+		m.Synced[binary.LittleEndian.Uint16(data[off:])] = binary.LittleEndian.Uint64(data[off+2:])
+	}
+
+	return nil
+}
+
+// SyncDelta represents the key/value entries of a source database committed after the
+// transaction ID of a SyncManifest, returned by Diff and applied by ApplySyncDelta.
+//
+//	|------------------------------------------------------|
+//	| siteID | lastTxID | more | count | entry | entry ...
+//	|------------------------------------------------------|
+//	| uint16 |  uint64  | uint8| uint32| Entry.Encode ...
+//	|------------------------------------------------------|
+type SyncDelta struct {
+	// SiteID is the site ID of the source database.
+	SiteID uint16
+
+	// LastTxID is the transaction ID of the source the delta syncs up to.
+	LastTxID uint64
+
+	// Entries are the entries, in the order of their transactions.
+	Entries []*Entry
+
+	// More is true if the source has entries committed after LastTxID.
+	More bool
+}
+
+// MarshalBinary encodes the delta.
+func (d *SyncDelta) MarshalBinary() ([]byte, error) {
+	size := 15
+	for _, e := range d.Entries {
+		size += int(e.Size())
+	}
+
+	buf := make([]byte, 15, size)
+	binary.LittleEndian.PutUint16(buf[0:2], d.SiteID)
+	binary.LittleEndian.PutUint64(buf[2:10], d.LastTxID)
+	if d.More {
+		buf[10] = 1
+	}
+	binary.LittleEndian.PutUint32(buf[11:15], uint32(len(d.Entries)))
+
+	for _, e := range d.Entries {
+		buf = append(buf, e.Encode()...)
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a delta encoded by MarshalBinary, checking the crc of every entry.
+func (d *SyncDelta) UnmarshalBinary(data []byte) error {
+	if len(data) < 15 {
+		return ErrSyncDataCorrupted
+	}
+
+	n := int(binary.LittleEndian.Uint32(data[11:15]))
+	entries := make([]*Entry, 0, n)
+
+	buf := data[15:]
+	for i := 0; i < n; i++ {
+		if len(buf) < DataEntryHeaderSize {
+			return ErrSyncDataCorrupted
+		}
+
+		meta := readMetaData(buf)
+		size := DataEntryHeaderSize + uint64(meta.bucketSize) + uint64(meta.keySize) + uint64(meta.valueSize)
+		if uint64(len(buf)) < size {
+			return ErrSyncDataCorrupted
+		}
+
+		payload := buf[DataEntryHeaderSize:size]
+		if checksum(buf[:DataEntryHeaderSize], payload) != binary.LittleEndian.Uint32(buf[0:4]) {
+			return ErrSyncDataCorrupted
+		}
+
+		meta.bucket = payload[:meta.bucketSize]
+		entries = append(entries, &Entry{
+			Key:   payload[meta.bucketSize : meta.bucketSize+meta.keySize],
+			Value: payload[meta.bucketSize+meta.keySize:],
+			Meta:  meta,
+		})
+		buf = buf[size:]
+	}
+
+	if len(buf) != 0 {
+		return ErrSyncDataCorrupted
+	}
+
+	d.SiteID = binary.LittleEndian.Uint16(data[0:2])
+	d.LastTxID = binary.LittleEndian.Uint64(data[2:10])
+	d.More = data[10] == 1
+	d.Entries = entries
+
+	return nil
+}
+
+// SyncManifest returns the sync progress of the database, to send to a source for Diff.
+func (db *DB) SyncManifest() (SyncManifest, error) {
+	m := SyncManifest{
+		SiteID:     db.opt.SiteID,
+		Synced:     make(map[uint16]uint64),
+		MaxEntries: DefaultSyncMaxEntries,
+	}
+
+	err := db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll(SyncBucket)
+		if err == ErrBucketEmpty {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if len(e.Key) != 2 || len(e.Value) != 8 {
+				return ErrSyncDataCorrupted
+			}
+			m.Synced[binary.BigEndian.Uint16(e.Key)] = binary.BigEndian.Uint64(e.Value)
+		}
+
+		return nil
+	})
+
+	return m, err
+}
+
+// Diff returns the key/value entries committed by the database after the last transaction
+// of it applied to the database of the manifest, at most other.MaxEntries rounded up to a
+// transaction boundary, so that two databases with different Options.SiteID converge over
+// any transport: the receiver sends its SyncManifest and applies the returned SyncDelta
+// with ApplySyncDelta, until SyncDelta.More is false. Only the current value and the
+// deletions of a key are sent, the entries written by the receiver are not sent back.
+// The list, set and sorted set entries are not synced. The data files are read up to the
+// end of the last committed transaction, only blocking the writable transactions while
+// it is found. Diff is not supported in HintBPTSparseIdxMode.
+func (db *DB) Diff(other SyncManifest) (*SyncDelta, error) {
+	if err := db.checkSync(other.SiteID); err != nil {
+		return nil, err
+	}
+
+	db.writeMu.Lock()
+	if db.closed {
+		db.writeMu.Unlock()
+		return nil, ErrDBClosed
+	}
+	lastTxID := db.LastCommittedTxID()
+	activeID, activeSize := db.ActiveFile.fileID, db.ActiveFile.writeOff
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	db.writeMu.Unlock()
+
+	since := other.Synced[db.opt.SiteID]
+
+	// the entries of a key written or rewritten by a merge after the files are listed are
+	// committed after lastTxID, so they are sent by the next Diff.
+	var (
+		entries []*Entry
+		closed  bool
+	)
+	for _, dataID := range dataFileIds {
+		fID := int64(dataID)
+		if fID > activeID {
+			continue
+		}
+
+		size := db.opt.SegmentSize
+		if fID == activeID {
+			size = activeSize
+		}
+
+		err := db.scanDataFileTo(fID, size, func(e *Entry, off int64) {
+			// the index is read while the commits of other transactions update it,
+			// and is dropped by Close.
+			db.mu.RLock()
+			defer db.mu.RUnlock()
+
+			if db.closed {
+				closed = true
+				return
+			}
+			if db.isSyncEntry(e, fID, off, since, other.SiteID) {
+				entries = append(entries, e)
+			}
+		})
+		if closed {
+			return nil, ErrDBClosed
+		}
+		// a file removed by a running Merge has its live entries rewritten to newer files.
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Meta.txID < entries[j].Meta.txID
+	})
+
+	d := &SyncDelta{SiteID: db.opt.SiteID, LastTxID: since}
+	if lastTxID > since {
+		d.LastTxID = lastTxID
+	}
+
+	if n := other.MaxEntries; n > 0 && len(entries) > n {
+		for n < len(entries) && entries[n].Meta.txID == entries[n-1].Meta.txID {
+			n++
+		}
+		if n < len(entries) {
+			entries = entries[:n]
+			d.LastTxID = entries[n-1].Meta.txID
+			d.More = true
+		}
+	}
+
+	resolved := entries[:0]
+	for _, e := range entries {
+		// the data files of the deltas are removed by a merge under db.mu.
+		db.mu.RLock()
+		e, err := db.resolveDeltas(e)
+		db.mu.RUnlock()
+		// a delta whose previous entries are merged is rewritten, sent by the next Diff.
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, e)
+	}
+	d.Entries = resolved
+
+	return d, nil
+}
+
+// isSyncEntry returns if the entry at off in the data file fID is sent by Diff to the
+// site siteID, which applied the transactions of the database up to since. The caller
+// holds db.mu for reading.
+func (db *DB) isSyncEntry(e *Entry, fID, off int64, since uint64, siteID uint16) bool {
+	if e.Meta.txID <= since || e.Meta.ds != DataStructureBPTree || e.Meta.siteID == siteID {
+		return false
+	}
+
+	if !db.isCommittedTx(e.Meta.txID) {
+		return false
+	}
+
+	bucket := string(e.Meta.bucket)
+	if bucket == SyncBucket {
+		return false
+	}
+
+	if e.Meta.Flag == DataDeleteFlag {
+		return true
+	}
+
+	mu := db.bucketLocks.get(bucket)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	// the older values of a key are overwritten by the current one, also sent.
+	idx, ok := db.bptreeIdx(bucket)
+	if !ok {
+		return false
+	}
+
+	r, err := idx.Find(e.Key)
+	if err != nil {
+		return false
+	}
+
+	return r.H.fileID == fID && r.H.dataPos == uint64(off)
+}
+
+// ApplySyncDelta applies the entries of a delta returned by the Diff of a source database,
+// and records the progress of the source in SyncBucket in the same transaction, so an
+// interrupted sync resumes after the last delta applied. An entry is applied if it is
+// newer than the committed value of its key: the last writer wins, by the stamps of the
+// writes, i.e. the timestamps of Options.Clock, then the order of the writes of a second,
+// then the site IDs, see MetaData.Seq. The entries keep their stamps, TTLs and site IDs,
+// so a deleted key whose deletion is merged away can be written back by an older value.
+// The progress is only recorded past the entries applied or older than the committed
+// values: an entry conflicting with the value of the same stamp returns ErrSyncConflict,
+// the entries of the transactions before it being applied.
+func (db *DB) ApplySyncDelta(d *SyncDelta) error {
+	if err := db.checkSync(d.SiteID); err != nil {
+		return err
+	}
+
+	for _, e := range d.Entries {
+		if e.Meta.ds != DataStructureBPTree || e.Meta.Flag != DataSetFlag && e.Meta.Flag != DataDeleteFlag {
+			return ErrSyncDataCorrupted
+		}
+	}
+
+	var conflict bool
+	err := db.Update(func(tx *Tx) error {
+		tx.keepStamps = true
+		lastTxID := d.LastTxID
+
+		// the writes of the entries of the transactions of the source before the current one.
+		var txID uint64
+		applied := 0
+		for _, e := range d.Entries {
+			if e.Meta.txID != txID {
+				txID, applied = e.Meta.txID, len(tx.pendingWrites)
+			}
+
+			bucket := string(e.Meta.bucket)
+			if bucket == SyncBucket {
+				continue
+			}
+
+			cmp, meta := tx.compareSyncEntry(bucket, e)
+			// the source only sends the current value of a key, which wins over the values
+			// of the same stamp it sent before.
+			if cmp == 0 && e.Meta.siteID == d.SiteID {
+				cmp = 1
+			}
+
+			if cmp == 0 && !tx.isSameSyncEntry(bucket, e, meta) {
+				// the entries of the transaction are resent by the next Diff.
+				conflict = true
+				lastTxID = txID - 1
+				tx.pendingWrites = tx.pendingWrites[:applied]
+				break
+			}
+
+			if cmp > 0 {
+				if err := tx.putKeeping(bucket, e, DataStructureBPTree); err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.putSyncProgress(d.SiteID, lastTxID)
+	})
+	if err != nil {
+		return err
+	}
+
+	if conflict {
+		return ErrSyncConflict
+	}
+
+	return nil
+}
+
+// compareSyncEntry compares the stamp of the entry with the one of the value of its key in
+// the bucket, written by the transaction or committed, deleted or expired included, which
+// it also returns. The entry of a key without value is newer.
+func (tx *Tx) compareSyncEntry(bucket string, e *Entry) (int, *MetaData) {
+	var meta *MetaData
+	if pending := tx.pendingWrite(bucket, e.Key); pending != nil {
+		meta = pending.Meta
+	} else if idx, ok := tx.bptreeIdx(bucket); ok {
+		if r, err := idx.Find(e.Key); err == nil {
+			if tx.db.isCommittedTx(r.H.meta.txID) {
+				meta = r.H.meta
+			}
+		}
+	}
+
+	if meta == nil {
+		return 1, nil
+	}
+
+	return compareStamps(e.Meta, meta), meta
+}
+
+// isNewerSyncEntry returns if the entry wins over the value of its key in the bucket, see
+// compareSyncEntry.
+func (tx *Tx) isNewerSyncEntry(bucket string, e *Entry) bool {
+	cmp, _ := tx.compareSyncEntry(bucket, e)
+	return cmp > 0
+}
+
+// isSameSyncEntry returns if meta, the value of the key of the entry with the same stamp,
+// is the entry, already applied.
+func (tx *Tx) isSameSyncEntry(bucket string, e *Entry, meta *MetaData) bool {
+	if isDeltaFlag(meta.Flag) || meta.Flag != e.Meta.Flag {
+		return false
+	}
+
+	if meta.Flag == DataDeleteFlag || tx.db.isExpired(meta.TTL, meta.timestamp) {
+		return true
+	}
+
+	value, _, err := tx.currentValue(bucket, e.Key)
+
+	return err == nil && bytes.Equal(value, e.Value)
+}
+
+// putSyncProgress records txID as the last transaction applied of the site siteID,
+// unless a later one is.
+func (tx *Tx) putSyncProgress(siteID uint16, txID uint64) error {
+	key := make([]byte, 2)
+	binary.BigEndian.PutUint16(key, siteID)
+
+	e, err := tx.Get(SyncBucket, key)
+	if err == nil && len(e.Value) == 8 && binary.BigEndian.Uint64(e.Value) >= txID {
+		return nil
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, txID)
+
+	return tx.Put(SyncBucket, key, value, Persistent)
+}
+
+// SyncFrom copies the key/value entries of src missing in the database, see Diff and
+// ApplySyncDelta, in deltas of DefaultSyncMaxEntries. Syncing both ways makes the two
+// databases converge.
+func (db *DB) SyncFrom(src *DB) error {
+	for {
+		m, err := db.SyncManifest()
+		if err != nil {
+			return err
+		}
+
+		d, err := src.Diff(m)
+		if err != nil {
+			return err
+		}
+
+		if err := db.ApplySyncDelta(d); err != nil {
+			return err
+		}
+
+		if !d.More {
+			return nil
+		}
+	}
+}
+
+// checkSync returns an error unless the database and the site siteID can be synced.
+func (db *DB) checkSync(siteID uint16) error {
+	if db.opt.SiteID == 0 || siteID == 0 {
+		return ErrSiteIDRequired
+	}
+
+	if db.opt.SiteID == siteID {
+		return ErrSameSiteID
+	}
+
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	return nil
+}
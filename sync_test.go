@@ -0,0 +1,421 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func openSyncDB(t *testing.T, dir string, siteID uint16, clock Clock) *DB {
+	InitOpt(dir, true)
+	o := opt
+	o.SiteID = siteID
+	o.Clock = clock
+
+	db, err := Open(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func getSyncValue(t *testing.T, db *DB, key string) string {
+	var value string
+	err := db.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket", []byte(key))
+		if err != nil {
+			return err
+		}
+		value = string(e.Value)
+		return nil
+	})
+	if err == ErrNotFoundKey {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func metaOfKey(t *testing.T, db *DB, key string) *MetaData {
+	var meta *MetaData
+	if err := db.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket", []byte(key))
+		if err != nil {
+			return err
+		}
+		meta = e.Meta
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return meta
+}
+
+func TestDB_SyncFrom(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	db1 := openSyncDB(t, "/tmp/nutsdbtestsync1", 1, clock)
+	defer db1.Close()
+	db2 := openSyncDB(t, "/tmp/nutsdbtestsync2", 2, clock)
+	defer db2.Close()
+
+	put := func(db *DB, key, value string) {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(key), []byte(value), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put(db1, "a", "a1")
+	put(db1, "b", "b1")
+	put(db2, "c", "c2")
+	if err := db1.Update(func(tx *Tx) error {
+		return tx.Append("bucket", []byte("b"), []byte("+"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Second)
+	put(db2, "a", "a2")
+
+	for _, pair := range [][2]*DB{{db1, db2}, {db2, db1}} {
+		if err := pair[0].SyncFrom(pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, db := range []*DB{db1, db2} {
+		for key, want := range map[string]string{"a": "a2", "b": "b1+", "c": "c2"} {
+			if got := getSyncValue(t, db, key); got != want {
+				t.Errorf("site %d: expected %s=%q, got %q", db.opt.SiteID, key, want, got)
+			}
+		}
+	}
+
+	// a write behind the clock is still ordered after the writes its site
+	// has seen, an older concurrent write loses, a deletion propagates.
+	clock.Set(time.Unix(900, 0))
+	put(db1, "a", "old")
+	if meta := metaOfKey(t, db1, "a"); meta.Timestamp() != 1001 || meta.Seq() == 0 {
+		t.Errorf("expected the write stamped after 1001, got %d.%d", meta.Timestamp(), meta.Seq())
+	}
+	clock.Set(time.Unix(1100, 0))
+	put(db2, "a", "new")
+	if err := db2.Update(func(tx *Tx) error {
+		return tx.Delete("bucket", []byte("c"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db2.SyncFrom(db1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db1.SyncFrom(db2); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, db := range []*DB{db1, db2} {
+		if got := getSyncValue(t, db, "a"); got != "new" {
+			t.Errorf("site %d: expected the older write to lose, got %q", db.opt.SiteID, got)
+		}
+	}
+	if got := getSyncValue(t, db1, "c"); got != "" {
+		t.Errorf("expected c deleted, got %q", got)
+	}
+
+	m, err := db1.SyncManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := db2.Diff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Entries) != 0 || d.More {
+		t.Errorf("expected an empty delta once synced, got %d entries", len(d.Entries))
+	}
+}
+
+func TestDB_SyncFrom_SameSecond(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	a := openSyncDB(t, "/tmp/nutsdbtestsync1", 1, clock)
+	defer a.Close()
+	b := openSyncDB(t, "/tmp/nutsdbtestsync2", 2, clock)
+	defer b.Close()
+
+	if err := a.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("k"), []byte("v1"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SyncFrom(a); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writes of the same second are ordered after the synced one.
+	if err := a.Update(func(tx *Tx) error {
+		return tx.Append("bucket", []byte("k"), []byte("+more"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Update(func(tx *Tx) error {
+		return tx.Copy("bucket", []byte("k"), "bucket", []byte("dst"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SyncFrom(a); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, db := range []*DB{a, b} {
+		for _, key := range []string{"k", "dst"} {
+			if got := getSyncValue(t, db, key); got != "v1+more" {
+				t.Errorf("site %d: expected %s=%q, got %q", db.opt.SiteID, key, "v1+more", got)
+			}
+		}
+	}
+
+	m, err := b.SyncManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Synced[1] != a.LastCommittedTxID() {
+		t.Errorf("expected the progress of site 1 at %d, got %d", a.LastCommittedTxID(), m.Synced[1])
+	}
+}
+
+func TestDB_ApplySyncDelta_Conflict(t *testing.T) {
+	src := openSyncDB(t, "/tmp/nutsdbtestsync1", 1, nil)
+	defer src.Close()
+	dst := openSyncDB(t, "/tmp/nutsdbtestsync2", 2, nil)
+	defer dst.Close()
+	third := openSyncDB(t, "/tmp/nutsdbtestsync3", 3, nil)
+	defer third.Close()
+
+	if err := src.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("j"), []byte("v1"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := third.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("k"), []byte("v3"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*DB{src, dst} {
+		if err := db.SyncFrom(third); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := dst.SyncManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := src.Diff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Entries) != 2 || d.Entries[1].Meta.siteID != 3 {
+		t.Fatalf("expected 2 entries, the second relayed, got %d", len(d.Entries))
+	}
+
+	// a relayed entry different from the value of the same stamp is neither applied
+	// nor skipped.
+	d.Entries[1].Value = []byte("forged")
+	if err := dst.ApplySyncDelta(d); !errors.Is(err, ErrSyncConflict) {
+		t.Fatalf("expected ErrSyncConflict, got %v", err)
+	}
+	if got := getSyncValue(t, dst, "j"); got != "v1" {
+		t.Errorf("expected the first transaction applied, got %q", got)
+	}
+	if got := getSyncValue(t, dst, "k"); got != "v3" {
+		t.Errorf("expected the conflicting entry not applied, got %q", got)
+	}
+
+	m, err = dst.SyncManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := d.Entries[1].Meta.txID - 1; m.Synced[1] != want {
+		t.Errorf("expected the progress of site 1 at %d, got %d", want, m.Synced[1])
+	}
+}
+
+func TestDB_Diff_Resumable(t *testing.T) {
+	src := openSyncDB(t, "/tmp/nutsdbtestsync1", 1, nil)
+	defer src.Close()
+	dst := openSyncDB(t, "/tmp/nutsdbtestsync2", 2, nil)
+	defer dst.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := src.Update(func(tx *Tx) error {
+			if err := tx.Put("bucket", []byte{'k', byte('0' + i)}, []byte("v"), Persistent); err != nil {
+				return err
+			}
+			return tx.Put("bucket", []byte{'l', byte('0' + i)}, []byte("v"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deltas := 0
+	for {
+		m, err := dst.SyncManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.MaxEntries = 3
+
+		d, err := src.Diff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Entries)%2 != 0 {
+			t.Fatalf("expected the delta to end at a transaction boundary, got %d entries", len(d.Entries))
+		}
+
+		// the deltas go through the wire format.
+		buf, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var received SyncDelta
+		if err := received.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.ApplySyncDelta(&received); err != nil {
+			t.Fatal(err)
+		}
+
+		deltas++
+		if !d.More {
+			break
+		}
+	}
+
+	if deltas != 5 {
+		t.Errorf("expected 5 deltas, got %d", deltas)
+	}
+
+	if err := dst.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("bucket")
+		if len(entries) != 20 {
+			t.Errorf("expected 20 entries synced, got %d", len(entries))
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := dst.SyncManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Synced[1] != src.LastCommittedTxID() {
+		t.Errorf("expected the progress of site 1 at %d, got %d", src.LastCommittedTxID(), m.Synced[1])
+	}
+
+	buf, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SyncManifest
+	if err := decoded.UnmarshalBinary(buf); err != nil || decoded.Synced[1] != m.Synced[1] || decoded.SiteID != 2 {
+		t.Errorf("expected the manifest decoded, got %+v, %v", decoded, err)
+	}
+
+	buf[len(buf)-1] ^= 0xff
+	if err := decoded.UnmarshalBinary(buf[:len(buf)-1]); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestDB_Diff_SiteID(t *testing.T) {
+	db := openSyncDB(t, "/tmp/nutsdbtestsync1", 0, nil)
+	defer db.Close()
+
+	if _, err := db.Diff(SyncManifest{SiteID: 2}); err != ErrSiteIDRequired {
+		t.Errorf("expected ErrSiteIDRequired, got %v", err)
+	}
+
+	db.opt.SiteID = 2
+	if _, err := db.Diff(SyncManifest{SiteID: 2}); err != ErrSameSiteID {
+		t.Errorf("expected ErrSameSiteID, got %v", err)
+	}
+}
+
+func TestDB_Diff_ConcurrentWrites(t *testing.T) {
+	db1 := openSyncDB(t, "/tmp/nutsdbtestsync1", 1, nil)
+	defer db1.Close()
+	db2 := openSyncDB(t, "/tmp/nutsdbtestsync2", 2, nil)
+	defer db2.Close()
+
+	const n = 200
+
+	// the writes and merges of db1 go on while its data files are read by Diff.
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%d", i%50))
+			if err := db1.Update(func(tx *Tx) error {
+				if i < 50 {
+					return tx.Put("bucket", key, []byte("v"), Persistent)
+				}
+				return tx.Append("bucket", key, []byte("+"))
+			}); err != nil {
+				done <- err
+				return
+			}
+			if i%50 == 49 {
+				if err := db1.Merge(); err != nil && err != ErrMergeFileCount && err != ErrMergeInProgress {
+					done <- err
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+
+	for writing := true; writing; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			writing = false
+		default:
+		}
+
+		if err := db2.SyncFrom(db1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if got, want := getSyncValue(t, db2, key), "v+++"; got != want {
+			t.Errorf("expected %s=%q, got %q", key, want, got)
+		}
+	}
+}
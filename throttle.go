@@ -0,0 +1,106 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"time"
+)
+
+// tokenBucket represents a token bucket refilled at rate tokens per second,
+// holding at most one second of tokens.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full tokenBucket at given rate, or nil if rate is not positive.
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// take takes n tokens at now and returns how long to wait until the bucket is no longer
+// in debt. Taking more tokens than the bucket holds is allowed, so a large commit
+// is delayed instead of refused.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// writeThrottle enforces Options.MaxWriteBytesPerSecond and Options.MaxWritesPerSecond.
+// It is used with the db.writeMu lock held, so a throttled commit delays the
+// other writers but not the readers.
+type writeThrottle struct {
+	bytes  *tokenBucket
+	writes *tokenBucket
+}
+
+// newWriteThrottle returns the writeThrottle of the options, or nil if they set no limit.
+func newWriteThrottle(opt Options) *writeThrottle {
+	t := &writeThrottle{
+		bytes:  newTokenBucket(opt.MaxWriteBytesPerSecond),
+		writes: newTokenBucket(opt.MaxWritesPerSecond),
+	}
+
+	if t.bytes == nil && t.writes == nil {
+		return nil
+	}
+
+	return t
+}
+
+// wait blocks until the pending writes of tx may be written.
+func (t *writeThrottle) wait(tx *Tx) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+
+	var d time.Duration
+	if t.bytes != nil {
+		var size int64
+		for _, e := range tx.pendingWrites {
+			size += e.Size()
+		}
+		d = t.bytes.take(float64(size), now)
+	}
+
+	if t.writes != nil {
+		if w := t.writes.take(float64(len(tx.pendingWrites)), now); w > d {
+			d = w
+		}
+	}
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{rate: 10, tokens: 10, last: now}
+
+	if d := b.take(10, now); d != 0 {
+		t.Fatalf("expected the burst to be free, got %v", d)
+	}
+
+	if d := b.take(5, now); d != 500*time.Millisecond {
+		t.Fatalf("expected to wait 500ms, got %v", d)
+	}
+
+	// the debt is paid after 500ms, and 2 tokens are refilled after 200ms more.
+	if d := b.take(2, now.Add(700*time.Millisecond)); d != 0 {
+		t.Fatalf("expected the refilled tokens to be free, got %v", d)
+	}
+
+	// the bucket holds at most one second of tokens.
+	if d := b.take(20, now.Add(time.Hour)); d != time.Second {
+		t.Fatalf("expected to wait 1s, got %v", d)
+	}
+
+	if newTokenBucket(0) != nil {
+		t.Fatal("expected no bucket without a rate")
+	}
+}
+
+func TestDB_MaxWritesPerSecond(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestthrottle", true)
+	opt.MaxWritesPerSecond = 20
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 20 writes are the burst, the other 10 take 500ms.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("expected the writes to be throttled, took %v", elapsed)
+	}
+}
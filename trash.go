@@ -0,0 +1,149 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// TrashBucket is the bucket in which the keys deleted by SoftDelete are kept.
+const TrashBucket = "__nutsdb_trash__"
+
+// ErrNotInTrash is returned when Undelete is called with a key which is not in the trash.
+var ErrNotInTrash = wrapError("key not in the trash", ErrKeyNotFound)
+
+// TrashedKey represents a key deleted by SoftDelete, listed by Trash.
+type TrashedKey struct {
+	Key       []byte
+	Value     []byte
+	DeletedAt time.Time
+}
+
+// SoftDelete deletes the key in the bucket like Delete, but keeps its value in
+// TrashBucket for Options.TrashRetention, so that Undelete can restore it until
+// then. The key is permanently removed by the first merge after the retention.
+func (tx *Tx) SoftDelete(bucket string, key []byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	e, err := tx.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	ttl := e.Meta.TTL
+	if ttl != Persistent {
+		// the key is restored with the time it had left.
+		elapsed := tx.now() - e.Meta.timestamp
+		if elapsed >= uint64(ttl) {
+			return ErrNotFoundKey
+		}
+		ttl -= uint32(elapsed)
+	}
+
+	value := make([]byte, 4+len(e.Value))
+	binary.BigEndian.PutUint32(value[0:4], ttl)
+	copy(value[4:], e.Value)
+
+	if err := tx.Put(TrashBucket, encodeTrashKey(bucket, key), value, tx.db.opt.TrashRetention); err != nil {
+		return err
+	}
+
+	return tx.Delete(bucket, key)
+}
+
+// Undelete restores the key in the bucket deleted by SoftDelete, with the value and
+// the TTL left it had then, unless the retention is over. It returns ErrNotInTrash if
+// the key is not in the trash.
+func (tx *Tx) Undelete(bucket string, key []byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	trashKey := encodeTrashKey(bucket, key)
+	e, err := tx.Get(TrashBucket, trashKey)
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrBucketNotFound) {
+		return ErrNotInTrash
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(e.Value) < 4 {
+		return ErrCorrupted
+	}
+
+	if err := tx.Put(bucket, key, e.Value[4:], binary.BigEndian.Uint32(e.Value[0:4])); err != nil {
+		return err
+	}
+
+	return tx.Delete(TrashBucket, trashKey)
+}
+
+// Trash returns the keys of the bucket deleted by SoftDelete, which Undelete can restore.
+// It is not supported in HintBPTSparseIdxMode.
+func (db *DB) Trash(bucket string) ([]TrashedKey, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	prefix := encodeTrashKey(bucket, nil)
+
+	var keys []TrashedKey
+	err := db.View(func(tx *Tx) error {
+		entries, err := tx.PrefixScan(TrashBucket, prefix, ScanNoLimit)
+		if err == ErrPrefixScan {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if len(e.Value) < 4 {
+				return ErrCorrupted
+			}
+
+			keys = append(keys, TrashedKey{
+				Key:       append([]byte(nil), e.Key[len(prefix):]...),
+				Value:     append([]byte(nil), e.Value[4:]...),
+				DeletedAt: time.Unix(int64(e.Meta.timestamp), 0),
+			})
+		}
+		return nil
+	})
+
+	return keys, err
+}
+
+// encodeTrashKey returns the key in TrashBucket of the key in the bucket,
+// prefixed by the bucket so that Trash lists the keys of a bucket.
+//
+//	|------------------------------|
+//	| bucketSize | bucket |   key  |
+//	|------------------------------|
+//	|   uint32   | []byte | []byte |
+//	|------------------------------|
+func encodeTrashKey(bucket string, key []byte) []byte {
+	buf := make([]byte, 4+len(bucket)+len(key))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(bucket)))
+	copy(buf[4:], bucket)
+	copy(buf[4+len(bucket):], key)
+
+	return buf
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTx_SoftDelete(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttrash", true)
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	opt.Clock = clock
+	opt.TrashRetention = 60
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.Put("bucket", []byte("key1"), []byte("val1"), Persistent); err != nil {
+			return err
+		}
+		if err := tx.Put("bucket", []byte("key2"), []byte("val2"), 100); err != nil {
+			return err
+		}
+		return tx.Put("bucket2", []byte("key1"), []byte("other"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Set(time.Unix(1600000010, 0))
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.SoftDelete("bucket", []byte("key1")); err != nil {
+			return err
+		}
+		return tx.SoftDelete("bucket", []byte("key2"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.SoftDelete("bucket", []byte("key3"))
+	}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("err SoftDelete of a missing key. got %v want ErrKeyNotFound", err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		if _, err := tx.Get("bucket", []byte("key1")); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("err Get of a soft deleted key. got %v want ErrKeyNotFound", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	trash, err := db.Trash("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 2 || string(trash[0].Key) != "key1" || string(trash[0].Value) != "val1" ||
+		string(trash[1].Key) != "key2" || !trash[0].DeletedAt.Equal(time.Unix(1600000010, 0)) {
+		t.Errorf("err Trash. got %+v", trash)
+	}
+	if trash, err := db.Trash("bucket2"); err != nil || len(trash) != 0 {
+		t.Errorf("err Trash of a bucket without deleted keys. got %v, %v", trash, err)
+	}
+
+	// the keys and values returned are copies of the trash records.
+	copy(trash[0].Key, "XXXX")
+	copy(trash[0].Value, "XXXX")
+
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.Undelete("bucket", []byte("key1")); err != nil {
+			return err
+		}
+		return tx.Undelete("bucket", []byte("key2"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket", []byte("key1"))
+		if err != nil || string(e.Value) != "val1" || e.Meta.TTL != Persistent {
+			t.Errorf("err Get of an undeleted key. got %v, %v", e, err)
+		}
+		e, err = tx.Get("bucket", []byte("key2"))
+		if err != nil || string(e.Value) != "val2" || e.Meta.TTL != 90 {
+			t.Errorf("err Get of an undeleted key with a TTL. got %v, %v", e, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if trash, err := db.Trash("bucket"); err != nil || len(trash) != 0 {
+		t.Errorf("err Trash after Undelete. got %v, %v", trash, err)
+	}
+
+	// the key is not restored after the retention.
+	if err := db.Update(func(tx *Tx) error {
+		return tx.SoftDelete("bucket", []byte("key1"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(time.Unix(1600000100, 0))
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Undelete("bucket", []byte("key1"))
+	}); err != ErrNotInTrash {
+		t.Errorf("err Undelete after the retention. got %v want ErrNotInTrash", err)
+	}
+	if trash, err := db.Trash("bucket"); err != nil || len(trash) != 0 {
+		t.Errorf("err Trash after the retention. got %v, %v", trash, err)
+	}
+}
@@ -16,10 +16,12 @@ package nutsdb
 
 import (
 	"errors"
-	"strings"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/bwmarrin/snowflake"
 	"github.com/xujiajun/nutsdb/ds/list"
 	"github.com/xujiajun/nutsdb/ds/set"
 	"github.com/xujiajun/nutsdb/ds/zset"
@@ -28,7 +30,7 @@ import (
 
 var (
 	// ErrKeyAndValSize is returned when given key and value size is too big.
-	ErrKeyAndValSize = errors.New("key and value size too big")
+	ErrKeyAndValSize = wrapError("key and value size too big", ErrCapacity)
 
 	// ErrTxClosed is returned when committing or rolling back a transaction
 	// that has already been committed or rolled back.
@@ -51,7 +53,7 @@ var (
 	ErrPrefixScan = errors.New("prefix scans not found")
 
 	// ErrNotFoundKey is returned when key not found int the bucket on an view function.
-	ErrNotFoundKey = errors.New("key not found in the bucket")
+	ErrNotFoundKey = wrapError("key not found in the bucket", ErrKeyNotFound)
 )
 
 // Tx represents a transaction.
@@ -59,8 +61,23 @@ type Tx struct {
 	id                     uint64
 	db                     *DB
 	writable               bool
+	priority               Priority
+	prepared               bool
+	merge                  bool         // rewrites the entries of a merge, not shipped to Options.CDC
+	publish                func() error // called by Commit once the writes are on disk, before they are indexed
+	readThrough            bool         // stores a value loaded by OnMissing, not passed to OnWrite
+	keepStamps             bool         // writes the entries of a sync or a repair, keeping their stamps
 	pendingWrites          []*Entry
 	ReservedStoreTxIDIdxes map[int64]*BPTree
+	statsMu                sync.Mutex // guards the stats, as the reads may be concurrent
+	keysRead               int
+	bucketRead             string              // the first bucket read
+	bucketsRead            map[string]struct{} // the other buckets read, see recordRead
+	open                   *openTx             // the registration in DB.OpenTxs, not referencing the tx
+	bucketLocksMu          sync.Mutex
+	bucketLocks            []heldBucketLock // the buckets read-locked by a View, see lockBucket
+	bucketLockBuf          [2]heldBucketLock
+	inUse                  atomic.Bool // set by the writes and Commit, see ErrTxConcurrentUse
 }
 
 // Begin opens a new transaction.
@@ -70,11 +87,36 @@ type Tx struct {
 // the current read/write transaction is completed.
 // All transactions must be closed by calling Commit() or Rollback() when done.
 func (db *DB) Begin(writable bool) (tx *Tx, err error) {
-	tx, err = newTx(db, writable)
-	if err != nil {
+	return db.BeginWithOptions(writable, TxOptions{})
+}
+
+// BeginWithOptions opens a new transaction with the options, see Begin.
+// A writable transaction with PriorityLow waits for the write lock while
+// high-priority ones are waiting for it.
+func (db *DB) BeginWithOptions(writable bool, opts TxOptions) (tx *Tx, err error) {
+	if tx, err = db.begin(writable, opts); err != nil {
 		return nil, err
 	}
 
+	// a transaction collected without Commit or Rollback would hold its lock forever.
+	if db.opt.TxLeakDetection.Threshold > 0 {
+		runtime.SetFinalizer(tx, (*Tx).finalize)
+	}
+
+	return
+}
+
+// begin opens a new transaction like BeginWithOptions, the caller closing it.
+func (db *DB) begin(writable bool, opts TxOptions) (tx *Tx, err error) {
+	if writable && db.opt.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	tx = newTx(db, writable)
+	tx.priority = opts.Priority
+	if db.opt.TxLeakDetection.Threshold > 0 {
+		tx.open.stack = debug.Stack()
+	}
 	tx.lock()
 
 	if db.closed {
@@ -82,122 +124,197 @@ func (db *DB) Begin(writable bool) (tx *Tx, err error) {
 		return nil, ErrDBClosed
 	}
 
+	if err = db.waitWriteStall(tx); err != nil {
+		tx.unlock()
+		return nil, err
+	}
+
+	// the ID is taken with the lock held, so that the writable transactions
+	// commit in the order of their IDs.
+	if tx.id, err = tx.getTxID(); err != nil {
+		tx.unlock()
+		return nil, err
+	}
+
+	if writable {
+		if err = db.recoverSpace(); err != nil {
+			tx.unlock()
+			return nil, err
+		}
+	}
+
+	if !db.openTxs.add(tx, db.opt.MaxActiveTransactions) {
+		tx.unlock()
+		return nil, ErrTooManyTransactions
+	}
+
 	return
 }
 
 // newTx returns a newly initialized Tx object at given writable.
-func newTx(db *DB, writable bool) (tx *Tx, err error) {
-	var txID uint64
-
-	tx = &Tx{
+func newTx(db *DB, writable bool) *Tx {
+	return &Tx{
 		db:                     db,
 		writable:               writable,
 		pendingWrites:          []*Entry{},
 		ReservedStoreTxIDIdxes: make(map[int64]*BPTree),
+		open:                   &openTx{writable: writable, started: time.Now()},
 	}
-
-	txID, err = tx.getTxID()
-	if err != nil {
-		return nil, err
-	}
-
-	tx.id = txID
-
-	return
 }
 
 // getTxID returns the tx id.
 func (tx *Tx) getTxID() (id uint64, err error) {
-	node, err := snowflake.NewNode(tx.db.opt.NodeNum)
-	if err != nil {
-		return 0, err
-	}
-
-	id = uint64(node.Generate().Int64())
-
-	return
+	return tx.db.ids.next()
 }
 
 // Commit commits the transaction, following these steps:
 //
 // 1. check the length of pendingWrites.If there are no writes, return immediately.
 //
-// 2. check if the ActiveFile has not enough space to store entry. if not, call rotateActiveFile function.
+// 2. lock the indexes of the buckets written, so that View transactions only wait while the writes are applied.
 //
-// 3. write pendingWrites to disk, if a non-nil error,return the error.
+// 3. check if the ActiveFile has not enough space to store entry. if not, call rotateActiveFile function.
 //
-// 4. build Hint index.
+// 4. write pendingWrites to disk, if a non-nil error,return the error.
 //
-// 5. Unlock the database and clear the db field.
+// 5. build Hint index.
+//
+// 6. Unlock the database and clear the db field.
 func (tx *Tx) Commit() error {
-	var off int64
-	var e *Entry
-
 	if tx.db == nil {
 		return ErrDBClosed
 	}
 
+	if err := tx.acquire(); err != nil {
+		return err
+	}
+	defer tx.release()
+
 	writesLen := len(tx.pendingWrites)
 
 	if writesLen == 0 {
+		tx.db.openTxs.remove(tx)
 		tx.unlock()
 		tx.db = nil
 		return nil
 	}
 
+	if err := tx.checkDiskQuota(); err != nil {
+		return err
+	}
+
+	if err := tx.writeThrough(); err != nil {
+		return err
+	}
+
+	tx.stampWrites(writesLen)
+
+	tx.db.throttle.wait(tx)
+
+	var (
+		err   error
+		batch *indexBatch
+		start = tx.db.writeStart()
+	)
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		// the sparse index of the active file is flushed when the file rotates,
+		// so it is updated entry by entry while the entries are written.
+		tx.db.mu.Lock()
+		_, err = tx.applyPendingWrites(writesLen)
+		tx.db.mu.Unlock()
+	} else {
+		batch, err = tx.applyPendingWrites(writesLen)
+	}
+
+	if err != nil && isNoSpace(err) {
+		tx.rollbackNoSpace(start)
+		err = wrapError(ErrNoSpace.Error()+": "+err.Error(), ErrNoSpace)
+	}
+
+	if err == nil && tx.publish != nil {
+		err = tx.publish()
+	}
+
+	if err == nil && batch != nil {
+		unlock := tx.lockIndexes(writesLen)
+		err = tx.applyIndexBatch(batch)
+		unlock()
+	}
+
+	if err != nil {
+		tx.db.health.recordError(err)
+		return err
+	}
+
+	if tx.db.cdc != nil && !tx.merge {
+		tx.db.cdc.enqueue(tx.pendingWrites[:writesLen])
+	}
+
+	tx.db.openTxs.remove(tx)
+	tx.unlock()
+
+	tx.db = nil
+
+	tx.pendingWrites = nil
+	tx.ReservedStoreTxIDIdxes = nil
+
+	return nil
+}
+
+// applyPendingWrites writes pendingWrites to disk. In HintBPTSparseIdxMode it
+// builds the indexes and must be called with the db.mu lock held, otherwise
+// it returns the index updates, applied by applyIndexBatch with the indexes
+// locked by lockIndexes.
+func (tx *Tx) applyPendingWrites(writesLen int) (*indexBatch, error) {
+	var off int64
+	var e *Entry
+
 	lastIndex := writesLen - 1
 	countFlag := CountFlagEnabled
+	var arena *recordArena
 	if tx.db.isMerging {
 		countFlag = CountFlagDisabled
+		// a merge rewrites a whole file, so its hints are allocated in slabs.
+		arena = newRecordArena(writesLen)
+	}
+
+	sparse := tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode
+	batch := &indexBatch{countFlag: countFlag, writesLen: writesLen}
+
+	tx.db.wal.reset()
+	w, err := tx.newRecordWriter()
+	if err != nil {
+		return nil, err
 	}
 
 	for i := 0; i < writesLen; i++ {
 		entry := tx.pendingWrites[i]
-		entrySize := entry.Size()
-		if entrySize > tx.db.opt.SegmentSize {
-			return ErrKeyAndValSize
-		}
-
 		bucket := string(entry.Meta.bucket)
 
-		if tx.db.ActiveFile.ActualSize+entrySize > tx.db.opt.SegmentSize {
-			if err := tx.rotateActiveFile(); err != nil {
-				return err
-			}
-		}
-
-		if entry.Meta.ds == DataStructureBPTree {
-			tx.db.BPTreeKeyEntryPosMap[string(entry.Meta.bucket)+string(entry.Key)] = tx.db.ActiveFile.writeOff
-		}
-
 		if i == lastIndex {
 			entry.Meta.status = Committed
 		}
 
-		off = tx.db.ActiveFile.writeOff
-
-		if _, err := tx.db.ActiveFile.WriteAt(entry.Encode(), tx.db.ActiveFile.writeOff); err != nil {
-			return err
+		if off, err = w.write(entry); err != nil {
+			return nil, err
 		}
 
-		if tx.db.opt.SyncEnable {
-			if err := tx.db.ActiveFile.rwManager.Sync(); err != nil {
-				return err
-			}
+		if entry.Meta.ds == DataStructureBPTree {
+			tx.db.BPTreeKeyEntryPosMap[string(getNewKey(string(entry.Meta.bucket), entry.Key))] = off
 		}
 
-		tx.db.ActiveFile.ActualSize += entrySize
-
-		tx.db.ActiveFile.writeOff += entrySize
+		tx.db.addActiveHint(entry, off)
+		tx.db.countWrite(tx.db.ActiveFile.fileID, entry)
 
 		if i == lastIndex {
 			txId := entry.Meta.txID
-			if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+			if sparse {
 				if err := tx.buildTxIDRootIdx(txId, countFlag); err != nil {
-					return err
+					return nil, err
 				}
+				tx.db.recordCommittedTxID(txId)
 			} else {
-				tx.db.committedTxIds[txId] = struct{}{}
+				batch.txID = txId
 			}
 		}
 
@@ -207,20 +324,65 @@ func (tx *Tx) Commit() error {
 		}
 
 		if entry.Meta.ds == DataStructureBPTree {
-			tx.buildBPTreeIdx(bucket, entry, e, off, countFlag)
+			if sparse {
+				tx.buildActiveBPTreeIdx(bucket, entry, e, off, countFlag)
+			} else {
+				h := arena.newHint()
+				*h = Hint{
+					fileID:  tx.db.ActiveFile.fileID,
+					key:     entry.Key,
+					meta:    entry.Meta,
+					dataPos: uint64(off),
+				}
+				batch.add(bucket, entry.Key, e, h)
+			}
 		}
 	}
 
+	if err := w.flush(true); err != nil {
+		return nil, err
+	}
+
+	if err := tx.db.wal.commit(); err != nil {
+		return nil, err
+	}
+
+	if !sparse {
+		return batch, nil
+	}
+
 	tx.buildIdxes(writesLen)
 
-	tx.unlock()
+	return nil, tx.db.persistKeyComparators()
+}
 
-	tx.db = nil
+// applyIndexBatch applies the index updates of the written entries.
+func (tx *Tx) applyIndexBatch(batch *indexBatch) error {
+	tx.db.addCommittedTxID(batch.txID)
 
-	tx.pendingWrites = nil
-	tx.ReservedStoreTxIDIdxes = nil
+	for _, bucket := range batch.buckets {
+		t, ok := tx.db.bptreeIdx(bucket)
+		if !ok {
+			t = tx.db.newBPTree(bucket)
+			tx.db.BPTreeIdx[bucket] = t
+		}
+		items := batch.items[bucket]
+		if err := t.InsertBatch(items, batch.countFlag); err != nil {
+			return err
+		}
 
-	return nil
+		if tx.db.indexMemory != nil {
+			for _, item := range items {
+				r, _ := t.Find(item.Key)
+				tx.db.indexMemory.admit(r)
+			}
+		}
+	}
+
+	tx.buildIdxes(batch.writesLen)
+	tx.db.applyIndexRepairs()
+
+	return tx.db.persistKeyComparators()
 }
 
 func (tx *Tx) buildTxIDRootIdx(txId uint64, countFlag bool) error {
@@ -234,7 +396,7 @@ func (tx *Tx) buildTxIDRootIdx(txId uint64, countFlag bool) error {
 			txIDIdx.Insert([]byte(txIdStr), nil, &Hint{meta: &MetaData{Flag: DataSetFlag}}, countFlag)
 			txIDIdx.Filepath = filePath
 
-			err := txIDIdx.WriteNodes(tx.db.opt.RWMode, tx.db.opt.SyncEnable, 2)
+			err := txIDIdx.writeNodes(tx.db.opt.RWMode, tx.db.opt.SyncEnable, 2, tx.db.filePerm())
 			if err != nil {
 				return err
 			}
@@ -246,7 +408,7 @@ func (tx *Tx) buildTxIDRootIdx(txId uint64, countFlag bool) error {
 			txIDRootIdx.Insert([]byte(rootAddress), nil, &Hint{meta: &MetaData{Flag: DataSetFlag}}, countFlag)
 			txIDRootIdx.Filepath = filePath
 
-			err = txIDRootIdx.WriteNodes(tx.db.opt.RWMode, tx.db.opt.SyncEnable, 2)
+			err = txIDRootIdx.writeNodes(tx.db.opt.RWMode, tx.db.opt.SyncEnable, 2, tx.db.filePerm())
 			if err != nil {
 				return err
 			}
@@ -278,31 +440,14 @@ func (tx *Tx) buildIdxes(writesLen int) {
 	}
 }
 
-func (tx *Tx) buildBPTreeIdx(bucket string, entry, e *Entry, off int64, countFlag bool) {
-	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
-		newKey := []byte(bucket)
-		newKey = append(newKey, entry.Key...)
-		tx.db.ActiveBPTreeIdx.Insert(newKey, e, &Hint{
-			fileID:  tx.db.ActiveFile.fileID,
-			key:     newKey,
-			meta:    entry.Meta,
-			dataPos: uint64(off),
-		}, countFlag)
-	} else {
-		if _, ok := tx.db.BPTreeIdx[bucket]; !ok {
-			tx.db.BPTreeIdx[bucket] = NewTree()
-		}
-
-		if tx.db.BPTreeIdx[bucket] == nil {
-			tx.db.BPTreeIdx[bucket] = NewTree()
-		}
-		_ = tx.db.BPTreeIdx[bucket].Insert(entry.Key, e, &Hint{
-			fileID:  tx.db.ActiveFile.fileID,
-			key:     entry.Key,
-			meta:    entry.Meta,
-			dataPos: uint64(off),
-		}, countFlag)
-	}
+func (tx *Tx) buildActiveBPTreeIdx(bucket string, entry, e *Entry, off int64, countFlag bool) {
+	newKey := getNewKey(bucket, entry.Key)
+	tx.db.ActiveBPTreeIdx.Insert(newKey, e, &Hint{
+		fileID:  tx.db.ActiveFile.fileID,
+		key:     newKey,
+		meta:    entry.Meta,
+		dataPos: uint64(off),
+	}, countFlag)
 }
 
 func (tx *Tx) buildSetIdx(bucket string, entry *Entry) {
@@ -326,10 +471,10 @@ func (tx *Tx) buildSortedSetIdx(bucket string, entry *Entry) {
 
 	switch entry.Meta.Flag {
 	case DataZAddFlag:
-		keyAndScore := strings.Split(string(entry.Key), SeparatorForZSetKey)
-		key := keyAndScore[0]
-		score, _ := strconv2.StrToFloat64(keyAndScore[1])
-		_ = tx.db.SortedSetIdx[bucket].Put(key, zset.SCORE(score), entry.Value)
+		if key, scoreBytes, err := decodeCompositeKey(entry.Key, SeparatorForZSetKey); err == nil {
+			score, _ := decodeScore(scoreBytes)
+			_ = tx.db.SortedSetIdx[bucket].Put(string(key), zset.SCORE(score), entry.Value)
+		}
 	case DataZRemFlag:
 		_ = tx.db.SortedSetIdx[bucket].Remove(string(entry.Key))
 	case DataZRemRangeByRankFlag:
@@ -340,6 +485,10 @@ func (tx *Tx) buildSortedSetIdx(bucket string, entry *Entry) {
 		_ = tx.db.SortedSetIdx[bucket].PopMax()
 	case DataZPopMinFlag:
 		_ = tx.db.SortedSetIdx[bucket].PopMin()
+	case DataZRemRangeByLexFlag:
+		if lexRange, err := zset.ParseLexRange(string(entry.Key), string(entry.Value)); err == nil {
+			_ = tx.db.SortedSetIdx[bucket].GetByLexRange(lexRange, true)
+		}
 	}
 }
 
@@ -363,16 +512,24 @@ func (tx *Tx) buildListIdx(bucket string, entry *Entry) {
 	case DataRPopFlag:
 		_, _ = tx.db.ListIdx[bucket].RPop(string(key))
 	case DataLSetFlag:
-		keyAndIndex := strings.Split(string(key), SeparatorForListKey)
-		newKey := keyAndIndex[0]
-		index, _ := strconv2.StrToInt(keyAndIndex[1])
-		_ = tx.db.ListIdx[bucket].LSet(newKey, index, value)
+		if newKey, indexBytes, err := decodeCompositeKey(key, SeparatorForListKey); err == nil {
+			index, _ := strconv2.StrToInt(string(indexBytes))
+			_ = tx.db.ListIdx[bucket].LSet(string(newKey), index, value)
+		}
 	case DataLTrimFlag:
-		keyAndStartIndex := strings.Split(string(key), SeparatorForListKey)
-		newKey := keyAndStartIndex[0]
-		start, _ := strconv2.StrToInt(keyAndStartIndex[1])
-		end, _ := strconv2.StrToInt(string(value))
-		_ = tx.db.ListIdx[bucket].Ltrim(newKey, start, end)
+		if newKey, startBytes, err := decodeCompositeKey(key, SeparatorForListKey); err == nil {
+			start, _ := strconv2.StrToInt(string(startBytes))
+			end, _ := strconv2.StrToInt(string(value))
+			_ = tx.db.ListIdx[bucket].Ltrim(string(newKey), start, end)
+		}
+	case DataLInsertFlag:
+		if newKey, before, pivot, value, err := decodeLInsert(key, value); err == nil {
+			_, _ = tx.db.ListIdx[bucket].LInsert(newKey, before, pivot, value)
+		}
+	case DataLMoveFlag:
+		if m, err := decodeLMove(value); err == nil {
+			_ = tx.db.applyLMove(bucket, string(key), m)
+		}
 	}
 }
 
@@ -382,22 +539,21 @@ func (tx *Tx) rotateActiveFile() error {
 	fID := tx.db.MaxFileID
 	tx.db.MaxFileID++
 
-	if !tx.db.opt.SyncEnable && tx.db.opt.RWMode == MMap {
-		if err := tx.db.ActiveFile.rwManager.Sync(); err != nil {
-			return err
-		}
+	if err := tx.db.closeActiveFile(); err != nil {
+		return err
 	}
 
-	if err := tx.db.ActiveFile.rwManager.Close(); err != nil {
+	if err := tx.db.sealActiveFile(); err != nil {
 		return err
 	}
+	tx.db.sealedSize += tx.db.opt.SegmentSize
 
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		tx.db.ActiveBPTreeIdx.Filepath = tx.db.getBPTPath(fID)
 		tx.db.ActiveBPTreeIdx.enabledKeyPosMap = true
 		tx.db.ActiveBPTreeIdx.SetKeyPosMap(tx.db.BPTreeKeyEntryPosMap)
 
-		err = tx.db.ActiveBPTreeIdx.WriteNodes(tx.db.opt.RWMode, tx.db.opt.SyncEnable, 1)
+		err = tx.db.ActiveBPTreeIdx.writeNodes(tx.db.opt.RWMode, tx.db.opt.SyncEnable, 1, tx.db.filePerm())
 		if err != nil {
 			return err
 		}
@@ -411,8 +567,8 @@ func (tx *Tx) rotateActiveFile() error {
 			end:       tx.db.ActiveBPTreeIdx.LastKey,
 		}
 
-		_, err := BPTreeRootIdx.Persistence(tx.db.getBPTRootPath(fID),
-			0, tx.db.opt.SyncEnable)
+		_, err := BPTreeRootIdx.persistence(tx.db.getBPTRootPath(fID),
+			0, tx.db.opt.SyncEnable, tx.db.filePerm())
 		if err != nil {
 			return err
 		}
@@ -436,7 +592,7 @@ func (tx *Tx) rotateActiveFile() error {
 
 	// reset ActiveFile
 	path := tx.db.getDataPath(tx.db.MaxFileID)
-	tx.db.ActiveFile, err = NewDataFile(path, tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+	tx.db.ActiveFile, err = tx.db.newDataFile(path, tx.db.opt.RWMode)
 	if err != nil {
 		return err
 	}
@@ -451,6 +607,12 @@ func (tx *Tx) Rollback() error {
 		return ErrDBClosed
 	}
 
+	if err := tx.acquire(); err != nil {
+		return err
+	}
+	defer tx.release()
+
+	tx.db.openTxs.remove(tx)
 	tx.unlock()
 
 	tx.db = nil
@@ -460,9 +622,15 @@ func (tx *Tx) Rollback() error {
 }
 
 // lock locks the database based on the transaction type.
+// Writable transactions are serialized by db.writeMu and only lock the
+// indexes while committing, so View transactions are not blocked by their
+// reads. A View transaction also read-locks the buckets it reads, see
+// lockBucket, only waiting for the commits of these buckets.
 func (tx *Tx) lock() {
-	if tx.writable {
-		tx.db.mu.Lock()
+	if tx.writable && tx.priority == PriorityLow {
+		tx.db.writeMu.LockLow()
+	} else if tx.writable {
+		tx.db.writeMu.Lock()
 	} else {
 		tx.db.mu.RLock()
 	}
@@ -471,8 +639,9 @@ func (tx *Tx) lock() {
 // unlock unlocks the database based on the transaction type.
 func (tx *Tx) unlock() {
 	if tx.writable {
-		tx.db.mu.Unlock()
+		tx.db.writeMu.Unlock()
 	} else {
+		tx.unlockBuckets()
 		tx.db.mu.RUnlock()
 	}
 }
@@ -480,7 +649,21 @@ func (tx *Tx) unlock() {
 // Put sets the value for a key in the bucket.
 // a wrapper of the function put.
 func (tx *Tx) Put(bucket string, key, value []byte, ttl uint32) error {
-	return tx.put(bucket, key, value, ttl, DataSetFlag, uint64(time.Now().Unix()), DataStructureBPTree)
+	if tx.db != nil && tx.db.isDupBucket(bucket) {
+		return ErrDupBucket
+	}
+
+	return tx.put(bucket, key, value, ttl, DataSetFlag, tx.now(), DataStructureBPTree)
+}
+
+// now returns the timestamp of the entries put by the transaction.
+// A closed transaction falls back to SystemClock, its put fails anyway.
+func (tx *Tx) now() uint64 {
+	if tx.db == nil {
+		return SystemClock{}.Now()
+	}
+
+	return tx.db.now()
 }
 
 func (tx *Tx) checkTxIsClosed() error {
@@ -490,6 +673,24 @@ func (tx *Tx) checkTxIsClosed() error {
 	return nil
 }
 
+// PendingSize returns the size in bytes of the entries written by the transaction so far,
+// e.g. for splitting the writes into several transactions before the quota or a segment
+// is exceeded. The writes of a transaction are committed atomically across the buckets
+// and the data structures, whatever its size.
+func (tx *Tx) PendingSize() int64 {
+	var size int64
+	for _, entry := range tx.pendingWrites {
+		size += entry.Size()
+	}
+
+	return size
+}
+
+// PendingCount returns the number of entries written by the transaction so far.
+func (tx *Tx) PendingCount() int {
+	return len(tx.pendingWrites)
+}
+
 // put sets the value for a key in the bucket.
 // Returns an error if tx is closed, if performing a write operation on a read-only transaction, if the key is empty.
 func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, timestamp uint64, ds uint16) error {
@@ -501,10 +702,23 @@ func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, tim
 		return ErrTxNotWritable
 	}
 
+	if err := tx.acquire(); err != nil {
+		return err
+	}
+	defer tx.release()
+
 	if len(key) == 0 {
 		return ErrKeyEmpty
 	}
 
+	if err := checkBucketName(bucket); err != nil {
+		return err
+	}
+
+	if err := tx.db.checkBucketInDB(bucket); err != nil {
+		return err
+	}
+
 	tx.pendingWrites = append(tx.pendingWrites, &Entry{
 		Key:   key,
 		Value: value,
@@ -519,6 +733,7 @@ func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, tim
 			status:     UnCommitted,
 			ds:         ds,
 			txID:       tx.id,
+			siteID:     tx.db.opt.SiteID,
 		},
 	})
 
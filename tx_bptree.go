@@ -16,30 +16,21 @@ package nutsdb
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
-	"time"
 
 	"github.com/xujiajun/utils/strconv2"
 )
 
-func getNewKey(bucket string, key []byte) []byte {
-	newKey := []byte(bucket)
-	newKey = append(newKey, key...)
-	return newKey
-}
-
 func (tx *Tx) getByHintBPTSparseIdxInMem(bucket string, key []byte) (e *Entry, err error) {
 	// Read in memory.
 	r, err := tx.db.ActiveBPTreeIdx.Find(key)
 	if err == nil && r != nil {
-		if r.H.meta.Flag == DataDeleteFlag || r.IsExpired() {
+		if r.H.meta.Flag == DataDeleteFlag || tx.db.isExpired(r.H.meta.TTL, r.H.meta.timestamp) {
 			return nil, ErrNotFoundKey
 		}
 
 		if _, err := tx.db.ActiveCommittedTxIdsIdx.Find([]byte(strconv2.Int64ToStr(int64(r.H.meta.txID)))); err == nil {
-			path := tx.db.getDataPath(r.H.fileID)
-			df, err := NewDataFile(path, tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+			df, err := tx.db.openDataFile(r.H.fileID, tx.db.opt.RWMode)
 			defer df.rwManager.Close()
 			if err != nil {
 				return nil, err
@@ -78,7 +69,7 @@ func (tx *Tx) getByHintBPTSparseIdxOnDisk(bucket string, key []byte) (e *Entry,
 
 			e, err = tx.FindOnDisk(fID, rootOff, key)
 			if err == nil && e != nil {
-				if e.Meta.Flag == DataDeleteFlag || IsExpired(e.Meta.TTL, e.Meta.timestamp) {
+				if e.Meta.Flag == DataDeleteFlag || tx.db.isExpired(e.Meta.TTL, e.Meta.timestamp) {
 					return nil, ErrNotFoundKey
 				}
 
@@ -122,6 +113,8 @@ func (tx *Tx) Get(bucket string, key []byte) (e *Entry, err error) {
 		return nil, err
 	}
 
+	tx.recordRead(bucket, 1)
+
 	idxMode := tx.db.opt.EntryIdxMode
 
 	if idxMode == HintBPTSparseIdxMode {
@@ -129,55 +122,62 @@ func (tx *Tx) Get(bucket string, key []byte) (e *Entry, err error) {
 	}
 
 	if idxMode == HintKeyValAndRAMIdxMode || idxMode == HintKeyAndRAMIdxMode {
-		if idx, ok := tx.db.BPTreeIdx[bucket]; ok {
+		if idx, ok := tx.bptreeIdx(bucket); ok {
 			r, err := idx.Find(key)
 			if err != nil {
 				return nil, err
 			}
 
-			if _, ok := tx.db.committedTxIds[r.H.meta.txID]; !ok {
+			if !tx.db.isCommittedTx(r.H.meta.txID) {
 				return nil, ErrNotFoundKey
 			}
 
-			if r.H.meta.Flag == DataDeleteFlag || r.IsExpired() {
+			if r.H.meta.Flag == DataDeleteFlag || tx.db.isExpired(r.H.meta.TTL, r.H.meta.timestamp) {
 				return nil, ErrNotFoundKey
 			}
 
-			if idxMode == HintKeyValAndRAMIdxMode {
-				return r.E, nil
-			}
-
-			if idxMode == HintKeyAndRAMIdxMode {
-				path := tx.db.getDataPath(r.H.fileID)
-				df, err := NewDataFile(path, tx.db.opt.SegmentSize, tx.db.opt.RWMode)
-				defer df.rwManager.Close()
-
+			if tx.db.opt.ParanoidChecks {
+				e, err := tx.checkRecord(bucket, key, r)
 				if err != nil {
 					return nil, err
 				}
+				return tx.db.resolveDeltas(e)
+			}
 
-				item, err := df.ReadAt(int(r.H.dataPos))
-				if err != nil {
-					return nil, fmt.Errorf("read err. pos %d, key %s, err %s", r.H.dataPos, string(key), err)
-				}
+			if idxMode == HintKeyValAndRAMIdxMode && r.E != nil {
+				tx.db.indexMemory.touch(r)
+				return tx.db.resolveDeltas(r.E)
+			}
+
+			df, err := tx.db.openDataFile(r.H.fileID, tx.db.opt.RWMode)
+			if err != nil {
+				return nil, err
+			}
+			defer df.rwManager.Close()
 
-				return item, nil
+			item, err := df.ReadAt(int(r.H.dataPos))
+			if err != nil {
+				return nil, fmt.Errorf("read err. pos %d, key %s, err %w", r.H.dataPos, string(key), err)
 			}
+
+			return tx.db.resolveDeltas(item)
 		}
 	}
 
-	return nil, errors.New("not found bucket:" + bucket + ",key:" + string(key))
+	return nil, ErrBucketAndKey(bucket, key)
 }
 
-//GetAll returns all keys and values of the bucket stored at given bucket.
+// GetAll returns all keys and values of the bucket stored at given bucket.
 func (tx *Tx) GetAll(bucket string) (entries Entries, err error) {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return nil, err
 	}
 
+	defer func() { tx.recordRead(bucket, len(entries)) }()
+
 	entries = Entries{}
 
-	if index, ok := tx.db.BPTreeIdx[bucket]; ok {
+	if index, ok := tx.bptreeIdx(bucket); ok {
 		records, err := index.All()
 		if err != nil {
 			return nil, ErrBucketEmpty
@@ -202,13 +202,14 @@ func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error
 		return nil, err
 	}
 
+	defer func() { tx.recordRead(bucket, len(es)) }()
+
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		newStart, newEnd := getNewKey(bucket, start), getNewKey(bucket, end)
 		records, err := tx.db.ActiveBPTreeIdx.Range(newStart, newEnd)
 		if err == nil && records != nil {
 			for _, r := range records {
-				path := tx.db.getDataPath(r.H.fileID)
-				df, err := NewDataFile(path, tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+				df, err := tx.db.openDataFile(r.H.fileID, tx.db.opt.RWMode)
 				if err != nil {
 					df.rwManager.Close()
 					return nil, err
@@ -217,7 +218,7 @@ func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error
 					es = append(es, item)
 				} else {
 					df.rwManager.Close()
-					return nil, fmt.Errorf("HintIdx r.Hi.dataPos %d, err %s", r.H.dataPos, err)
+					return nil, fmt.Errorf("HintIdx r.Hi.dataPos %d, err %w", r.H.dataPos, err)
 				}
 				df.rwManager.Close()
 			}
@@ -232,10 +233,10 @@ func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error
 		if len(es) == 0 {
 			return nil, ErrRangeScan
 		}
-		return processEntriesScanOnDisk(es), nil
+		return tx.processEntriesScanOnDisk(es), nil
 	}
 
-	if index, ok := tx.db.BPTreeIdx[bucket]; ok {
+	if index, ok := tx.bptreeIdx(bucket); ok {
 		records, err := index.Range(start, end)
 		if err != nil {
 			return nil, ErrRangeScan
@@ -308,20 +309,20 @@ func (tx *Tx) prefixScanOnDisk(bucket string, prefix []byte, limitNum int) ([]*E
 	return result, nil
 }
 
-func processEntriesScanOnDisk(entriesTemp []*Entry) (result []*Entry) {
+func (tx *Tx) processEntriesScanOnDisk(entriesTemp []*Entry) (result []*Entry) {
 	var entriesMap map[string]*Entry
 	entriesMap = make(map[string]*Entry)
 
 	for _, entry := range entriesTemp {
 		if tempEntry, ok := entriesMap[string(entry.Key)]; ok {
 			if tempEntry.Meta.timestamp < entry.Meta.timestamp {
-				if !IsExpired(entry.Meta.TTL, entry.Meta.timestamp) || entry.Meta.Flag != DataDeleteFlag {
+				if !tx.db.isExpired(entry.Meta.TTL, entry.Meta.timestamp) || entry.Meta.Flag != DataDeleteFlag {
 					delete(entriesMap, string(entry.Key))
 				}
 				entriesMap[string(entry.Key)] = entry
 			}
 		} else {
-			if !IsExpired(entry.Meta.TTL, entry.Meta.timestamp) && entry.Meta.Flag != DataDeleteFlag {
+			if !tx.db.isExpired(entry.Meta.TTL, entry.Meta.timestamp) && entry.Meta.Flag != DataDeleteFlag {
 				entriesMap[string(entry.Key)] = entry
 			}
 		}
@@ -341,7 +342,7 @@ func (tx *Tx) getStartIndexForFindPrefix(fID int64, curr *BinaryNode, prefix []b
 	var entry *Entry
 
 	for j = 0; j < curr.KeysNum; j++ {
-		df, err := NewDataFile(tx.db.getDataPath(fID), tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+		df, err := tx.db.openDataFile(fID, tx.db.opt.RWMode)
 		if err != nil {
 			return 0, err
 		}
@@ -382,7 +383,7 @@ func (tx *Tx) findPrefixOnDisk(fID, rootOff int64, prefix []byte, limitNum int)
 
 	for curr != nil && scanFlag {
 		for i = j; i < curr.KeysNum; i++ {
-			df, err := NewDataFile(tx.db.getDataPath(int64(fID)), tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+			df, err := tx.db.openDataFile(int64(fID), tx.db.opt.RWMode)
 			if err != nil {
 				return nil, err
 			}
@@ -411,7 +412,7 @@ func (tx *Tx) findPrefixOnDisk(fID, rootOff int64, prefix []byte, limitNum int)
 		if address == DefaultInvalidAddress {
 			break
 		}
-		curr, err = ReadNode(filepath, address)
+		curr, err = tx.db.readIndexNode(filepath, address)
 		if err != nil {
 			return nil, err
 		}
@@ -426,7 +427,7 @@ func (tx *Tx) getStartIndexForFindRange(fID int64, curr *BinaryNode, start []byt
 	var j uint16
 
 	for j = 0; j < curr.KeysNum; j++ {
-		df, err := NewDataFile(tx.db.getDataPath(int64(fID)), tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+		df, err := tx.db.openDataFile(int64(fID), tx.db.opt.RWMode)
 		if err != nil {
 			return 0, err
 		}
@@ -468,7 +469,7 @@ func (tx *Tx) findRangeOnDisk(fID, rootOff int64, start, end []byte) (es []*Entr
 
 	for curr != nil && scanFlag {
 		for i = j; i < curr.KeysNum; i++ {
-			df, err := NewDataFile(tx.db.getDataPath(int64(fID)), tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+			df, err := tx.db.openDataFile(int64(fID), tx.db.opt.RWMode)
 			if err != nil {
 				return nil, err
 			}
@@ -495,7 +496,7 @@ func (tx *Tx) findRangeOnDisk(fID, rootOff int64, start, end []byte) (es []*Entr
 		if address == DefaultInvalidAddress {
 			break
 		}
-		curr, err = ReadNode(filepath, address)
+		curr, err = tx.db.readIndexNode(filepath, address)
 		if err != nil {
 			return nil, err
 		}
@@ -511,8 +512,7 @@ func (tx *Tx) prefixScanByHintBPTSparseIdx(bucket string, prefix []byte, limitNu
 	records, err := tx.db.ActiveBPTreeIdx.PrefixScan(newPrefix, limitNum)
 	if err == nil && records != nil {
 		for _, r := range records {
-			path := tx.db.getDataPath(r.H.fileID)
-			df, err := NewDataFile(path, tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+			df, err := tx.db.openDataFile(r.H.fileID, tx.db.opt.RWMode)
 			if err != nil {
 				df.rwManager.Close()
 				return nil, err
@@ -524,7 +524,7 @@ func (tx *Tx) prefixScanByHintBPTSparseIdx(bucket string, prefix []byte, limitNu
 				}
 			} else {
 				df.rwManager.Close()
-				return nil, fmt.Errorf("HintIdx r.Hi.dataPos %d, err %s", r.H.dataPos, err)
+				return nil, fmt.Errorf("HintIdx r.Hi.dataPos %d, err %w", r.H.dataPos, err)
 			}
 			df.rwManager.Close()
 		}
@@ -542,7 +542,7 @@ func (tx *Tx) prefixScanByHintBPTSparseIdx(bucket string, prefix []byte, limitNu
 	if len(es) == 0 {
 		return nil, ErrPrefixScan
 	}
-	return processEntriesScanOnDisk(es), nil
+	return tx.processEntriesScanOnDisk(es), nil
 }
 
 // PrefixScan iterates over a key prefix at given bucket, prefix and limitNum.
@@ -552,11 +552,13 @@ func (tx *Tx) PrefixScan(bucket string, prefix []byte, limitNum int) (es Entries
 		return nil, err
 	}
 
+	defer func() { tx.recordRead(bucket, len(es)) }()
+
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return tx.prefixScanByHintBPTSparseIdx(bucket, prefix, limitNum)
 	}
 
-	if idx, ok := tx.db.BPTreeIdx[bucket]; ok {
+	if idx, ok := tx.bptreeIdx(bucket); ok {
 		records, err := idx.PrefixScan(prefix, limitNum)
 		if err != nil {
 			return nil, ErrPrefixScan
@@ -581,36 +583,82 @@ func (tx *Tx) Delete(bucket string, key []byte) error {
 		return err
 	}
 
-	return tx.put(bucket, key, nil, Persistent, DataDeleteFlag, uint64(time.Now().Unix()), DataStructureBPTree)
+	return tx.put(bucket, key, nil, Persistent, DataDeleteFlag, tx.now(), DataStructureBPTree)
+}
+
+// Rename moves the value of oldKey in the bucket to newKey, overwriting it, with its TTL
+// and timestamp, so that it expires at the same time. Both writes are committed atomically
+// by the transaction. It returns ErrNotFoundKey if oldKey does not exist when the
+// transaction begins.
+func (tx *Tx) Rename(bucket string, oldKey, newKey []byte) error {
+	if bytes.Equal(oldKey, newKey) {
+		_, err := tx.Get(bucket, oldKey)
+		return err
+	}
+
+	if err := tx.Copy(bucket, oldKey, bucket, newKey); err != nil {
+		return err
+	}
+
+	return tx.Delete(bucket, oldKey)
+}
+
+// Copy sets the value of srcKey in srcBucket for dstKey in dstBucket, overwriting it,
+// with the remaining TTL of srcKey, so that the copy expires at the same time.
+// It returns ErrNotFoundKey if srcKey does not exist when the transaction begins.
+func (tx *Tx) Copy(srcBucket string, srcKey []byte, dstBucket string, dstKey []byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+
+	e, err := tx.Get(srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+
+	value := make([]byte, len(e.Value))
+	copy(value, e.Value)
+
+	now := tx.now()
+	ttl := remainingTTL(e.Meta.TTL, e.Meta.timestamp, now)
+
+	return tx.put(dstBucket, dstKey, value, ttl, DataSetFlag, now, DataStructureBPTree)
 }
 
 // getHintIdxDataItemsWrapper returns wrapped entries when prefix scanning or range scanning.
 func (tx *Tx) getHintIdxDataItemsWrapper(records Records, limitNum int, es Entries, scanMode string) (Entries, error) {
 	for _, r := range records {
-		if r.H.meta.Flag == DataDeleteFlag || r.IsExpired() {
+		if r.H.meta.Flag == DataDeleteFlag || tx.db.isExpired(r.H.meta.TTL, r.H.meta.timestamp) {
 			continue
 		}
 
 		if limitNum > 0 && len(es) < limitNum || limitNum == ScanNoLimit {
 			idxMode := tx.db.opt.EntryIdxMode
-			if idxMode == HintKeyAndRAMIdxMode {
-				path := tx.db.getDataPath(r.H.fileID)
-				df, err := NewDataFile(path, tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+			var item *Entry
+			if idxMode == HintKeyValAndRAMIdxMode && r.E != nil {
+				tx.db.indexMemory.touch(r)
+				item = r.E
+			} else {
+				df, err := tx.db.openDataFile(r.H.fileID, tx.db.opt.RWMode)
 				if err != nil {
 					return nil, err
 				}
-				if item, err := df.ReadAt(int(r.H.dataPos)); err == nil {
-					es = append(es, item)
-				} else {
+				if item, err = df.ReadAt(int(r.H.dataPos)); err != nil {
 					df.rwManager.Close()
-					return nil, fmt.Errorf("HintIdx r.Hi.dataPos %d, err %s", r.H.dataPos, err)
+					return nil, fmt.Errorf("HintIdx r.Hi.dataPos %d, err %w", r.H.dataPos, err)
 				}
 				df.rwManager.Close()
 			}
 
-			if idxMode == HintKeyValAndRAMIdxMode {
-				es = append(es, r.E)
+			item, err := tx.db.resolveDeltas(item)
+			if err != nil {
+				return nil, err
 			}
+			es = append(es, item)
 		}
 	}
 
@@ -619,6 +667,10 @@ func (tx *Tx) getHintIdxDataItemsWrapper(records Records, limitNum int, es Entri
 
 // FindTxIdOnDisk returns if txId on disk at given fid and txID.
 func (tx *Tx) FindTxIdOnDisk(fID, txId uint64) (ok bool, err error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return false, err
+	}
+
 	var i uint16
 
 	filepath := tx.db.getBPTRootTxIdPath(int64(fID))
@@ -671,6 +723,10 @@ func (tx *Tx) FindTxIdOnDisk(fID, txId uint64) (ok bool, err error) {
 
 // FindOnDisk returns entry on disk at given fID, rootOff and key.
 func (tx *Tx) FindOnDisk(fID uint64, rootOff uint64, key []byte) (entry *Entry, err error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+
 	var (
 		bnLeaf *BinaryNode
 		i      uint16
@@ -684,7 +740,7 @@ func (tx *Tx) FindOnDisk(fID uint64, rootOff uint64, key []byte) (entry *Entry,
 	}
 
 	for i = 0; i < bnLeaf.KeysNum; i++ {
-		df, err = NewDataFile(tx.db.getDataPath(int64(fID)), tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+		df, err = tx.db.openDataFile(int64(fID), tx.db.opt.RWMode)
 		if err != nil {
 			return nil, err
 		}
@@ -711,11 +767,15 @@ func (tx *Tx) FindOnDisk(fID uint64, rootOff uint64, key []byte) (entry *Entry,
 
 // FindLeafOnDisk returns binary leaf node on disk at given fId, rootOff and key.
 func (tx *Tx) FindLeafOnDisk(fId int64, rootOff int64, key []byte) (bn *BinaryNode, err error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+
 	var i uint16
 	var curr *BinaryNode
 
 	filepath := tx.db.getBPTPath(fId)
-	curr, err = ReadNode(filepath, rootOff)
+	curr, err = tx.db.readIndexNode(filepath, rootOff)
 	if err != nil {
 		return nil, err
 	}
@@ -723,7 +783,7 @@ func (tx *Tx) FindLeafOnDisk(fId int64, rootOff int64, key []byte) (bn *BinaryNo
 	for curr.IsLeaf != 1 {
 		i = 0
 		for i < curr.KeysNum {
-			df, err := NewDataFile(tx.db.getDataPath(fId), tx.db.opt.SegmentSize, tx.db.opt.RWMode)
+			df, err := tx.db.openDataFile(fId, tx.db.opt.RWMode)
 			if err != nil {
 				return nil, err
 			}
@@ -744,7 +804,7 @@ func (tx *Tx) FindLeafOnDisk(fId int64, rootOff int64, key []byte) (bn *BinaryNo
 		}
 		address := curr.Pointers[i]
 
-		curr, err = ReadNode(filepath, int64(address))
+		curr, err = tx.db.readIndexNode(filepath, int64(address))
 	}
 
 	return curr, nil
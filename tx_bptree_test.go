@@ -15,10 +15,12 @@
 package nutsdb
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/xujiajun/utils/strconv2"
 )
@@ -868,3 +870,114 @@ func TestTx_Notfound_For_BPTSparseIdxMode(t *testing.T) {
 	}
 	tx.Commit()
 }
+
+func TestTx_GetCorruptedValue(t *testing.T) {
+	Init()
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bucket := "bucket_crc"
+	key, val := []byte("key"), []byte("value")
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, key, val, Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip the last byte of the value on disk, the header staying intact.
+	f, err := os.OpenFile(db.getDataPath(db.ActiveFile.fileID), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{'X'}, db.ActiveFile.writeOff-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, key)
+		return err
+	})
+	if !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestTx_RenameAndCopy(t *testing.T) {
+	Init()
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	opt.Clock = clock
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket1", []byte("key1"), []byte("val1"), 100)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Set(time.Unix(1600000010, 0))
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.Copy("bucket1", []byte("key1"), "bucket2", []byte("key2")); err != nil {
+			return err
+		}
+		return tx.Rename("bucket1", []byte("key1"), []byte("key3"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		if _, err := tx.Get("bucket1", []byte("key1")); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("err Get of a renamed key. got %v want ErrKeyNotFound", err)
+		}
+
+		for _, k := range []struct {
+			bucket string
+			key    string
+		}{{"bucket1", "key3"}, {"bucket2", "key2"}} {
+			e, err := tx.Get(k.bucket, []byte(k.key))
+			if err != nil {
+				return err
+			}
+			// the copy is written now, with the remaining TTL.
+			if string(e.Value) != "val1" || e.Meta.TTL != 90 || e.Meta.timestamp != 1600000010 {
+				t.Errorf("err Get %s %s. got %s ttl %d timestamp %d", k.bucket, k.key, e.Value, e.Meta.TTL, e.Meta.timestamp)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Rename("bucket1", []byte("key1"), []byte("key4"))
+	}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("err Rename of a missing key. got %v want ErrKeyNotFound", err)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		return tx.Copy("bucket1", []byte("key3"), "bucket1", []byte("key4"))
+	}); err != ErrTxNotWritable {
+		t.Errorf("err Copy in a read-only tx. got %v want ErrTxNotWritable", err)
+	}
+
+	// the copy expires with the source.
+	clock.Set(time.Unix(1600000101, 0))
+	if err := db.View(func(tx *Tx) error {
+		if _, err := tx.Get("bucket2", []byte("key2")); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("err Get of an expired copy. got %v want ErrKeyNotFound", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
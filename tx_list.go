@@ -15,16 +15,15 @@
 package nutsdb
 
 import (
-	"bytes"
+	"encoding/binary"
 	"errors"
-	"strings"
-	"time"
 
 	"github.com/xujiajun/nutsdb/ds/list"
 	"github.com/xujiajun/utils/strconv2"
 )
 
-// SeparatorForListKey represents separator for listKey
+// SeparatorForListKey represents separator for listKey.
+// It is only used to decode the entries written before the list keys may contain it.
 const SeparatorForListKey = "|"
 
 // RPop removes and returns the last element of the list stored in the bucket at given bucket and key.
@@ -43,6 +42,8 @@ func (tx *Tx) RPeek(bucket string, key []byte) (item []byte, err error) {
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.ListIdx[bucket]; !ok {
 		return nil, ErrBucket
 	}
@@ -55,7 +56,7 @@ func (tx *Tx) RPeek(bucket string, key []byte) (item []byte, err error) {
 // push sets values for list stored in the bucket at given bucket, key, flag and values.
 func (tx *Tx) push(bucket string, key []byte, flag uint16, values ...[]byte) error {
 	for _, value := range values {
-		err := tx.put(bucket, key, value, Persistent, flag, uint64(time.Now().Unix()), DataStructureList)
+		err := tx.put(bucket, key, value, Persistent, flag, tx.now(), DataStructureList)
 		if err != nil {
 			return err
 		}
@@ -70,10 +71,6 @@ func (tx *Tx) RPush(bucket string, key []byte, values ...[]byte) error {
 		return err
 	}
 
-	if strings.Contains(string(key), SeparatorForListKey) {
-		return ErrSeparatorForListKey()
-	}
-
 	return tx.push(bucket, key, DataRPushFlag, values...)
 }
 
@@ -83,10 +80,6 @@ func (tx *Tx) LPush(bucket string, key []byte, values ...[]byte) error {
 		return err
 	}
 
-	if strings.Contains(string(key), SeparatorForListKey) {
-		return ErrSeparatorForListKey()
-	}
-
 	return tx.push(bucket, key, DataLPushFlag, values...)
 }
 
@@ -106,6 +99,8 @@ func (tx *Tx) LPeek(bucket string, key []byte) (item []byte, err error) {
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.ListIdx[bucket]; !ok {
 		return nil, ErrBucket
 	}
@@ -121,6 +116,8 @@ func (tx *Tx) LSize(bucket string, key []byte) (int, error) {
 		return 0, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.ListIdx[bucket]; !ok {
 		return 0, ErrBucket
 	}
@@ -138,6 +135,8 @@ func (tx *Tx) LRange(bucket string, key []byte, start, end int) (list [][]byte,
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.ListIdx[bucket]; !ok {
 		return nil, ErrBucket
 	}
@@ -174,15 +173,12 @@ func (tx *Tx) LRem(bucket string, key []byte, count int) error {
 
 // LSet sets the list element at index to value.
 func (tx *Tx) LSet(bucket string, key []byte, index int, value []byte) error {
-	var (
-		err    error
-		buffer bytes.Buffer
-	)
-
-	if err = tx.checkTxIsClosed(); err != nil {
+	if err := tx.checkTxIsClosed(); err != nil {
 		return err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.ListIdx[bucket]; !ok {
 		return ErrBucket
 	}
@@ -196,11 +192,7 @@ func (tx *Tx) LSet(bucket string, key []byte, index int, value []byte) error {
 		return list.ErrIndexOutOfRange
 	}
 
-	buffer.Write(key)
-	buffer.Write([]byte(SeparatorForListKey))
-	indexBytes := []byte(strconv2.IntToStr(index))
-	buffer.Write(indexBytes)
-	newKey := buffer.Bytes()
+	newKey := encodeCompositeKey(key, []byte(strconv2.IntToStr(index)))
 
 	return tx.push(bucket, newKey, DataLSetFlag, value)
 }
@@ -211,15 +203,12 @@ func (tx *Tx) LSet(bucket string, key []byte, index int, value []byte) error {
 // start and end can also be negative numbers indicating offsets from the end of the list,
 // where -1 is the last element of the list, -2 the penultimate element and so on.
 func (tx *Tx) LTrim(bucket string, key []byte, start, end int) error {
-	var (
-		err    error
-		buffer bytes.Buffer
-	)
-
-	if err = tx.checkTxIsClosed(); err != nil {
+	if err := tx.checkTxIsClosed(); err != nil {
 		return err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.ListIdx[bucket]; !ok {
 		return ErrBucket
 	}
@@ -232,15 +221,256 @@ func (tx *Tx) LTrim(bucket string, key []byte, start, end int) error {
 		return err
 	}
 
-	buffer.Write(key)
-	buffer.Write([]byte(SeparatorForListKey))
-	buffer.Write([]byte(strconv2.IntToStr(start)))
-	newKey := buffer.Bytes()
+	newKey := encodeCompositeKey(key, []byte(strconv2.IntToStr(start)))
 
 	return tx.push(bucket, newKey, DataLTrimFlag, []byte(strconv2.IntToStr(end)))
 }
 
+// LInsert inserts value before or after the first element equal to pivot in the list
+// stored in the bucket at given bucket and key. It returns list.ErrPivotNotFound when
+// no element equals to pivot.
+func (tx *Tx) LInsert(bucket string, key []byte, before bool, pivot, value []byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	tx.lockBucket(bucket)
+
+	if _, ok := tx.db.ListIdx[bucket]; !ok {
+		return ErrBucket
+	}
+
+	if _, ok := tx.db.ListIdx[bucket].Items[string(key)]; !ok {
+		return ErrKeyNotFound
+	}
+
+	positions, err := tx.db.ListIdx[bucket].LPos(string(key), pivot, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(positions) == 0 {
+		return list.ErrPivotNotFound
+	}
+
+	newKey, newValue := encodeLInsert(key, before, pivot, value)
+
+	return tx.push(bucket, newKey, DataLInsertFlag, newValue)
+}
+
+// LPos returns the indexes of the elements equal to value in the list stored in the bucket
+// at given bucket and key. If opts is nil, only the index of the first match is returned.
+// See list.LPosOptions for the rank, count and max length options.
+func (tx *Tx) LPos(bucket string, key []byte, value []byte, opts *list.LPosOptions) ([]int, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+
+	tx.lockBucket(bucket)
+
+	if _, ok := tx.db.ListIdx[bucket]; !ok {
+		return nil, ErrBucket
+	}
+
+	return tx.db.ListIdx[bucket].LPos(string(key), value, opts)
+}
+
+const (
+	listInsertBefore = "before"
+	listInsertAfter  = "after"
+)
+
+// encodeLInsert returns the key and the value of the LInsert entry.
+//
+//	key:   composite key of | key | before or after |, see encodeCompositeKey
+//	value: | pivotSize (uint32, big endian) | pivot | value |
+func encodeLInsert(key []byte, before bool, pivot, value []byte) ([]byte, []byte) {
+	where := listInsertAfter
+	if before {
+		where = listInsertBefore
+	}
+
+	newValue := make([]byte, 4, 4+len(pivot)+len(value))
+	binary.BigEndian.PutUint32(newValue, uint32(len(pivot)))
+	newValue = append(newValue, pivot...)
+	newValue = append(newValue, value...)
+
+	return encodeCompositeKey(key, []byte(where)), newValue
+}
+
+// decodeLInsert decodes the key and the value of the LInsert entry.
+func decodeLInsert(key, value []byte) (newKey string, before bool, pivot, newValue []byte, err error) {
+	newKeyBytes, where, err := decodeCompositeKey(key, SeparatorForListKey)
+	if err != nil || len(value) < 4 {
+		return "", false, nil, nil, ErrCorrupted
+	}
+
+	pivotSize := binary.BigEndian.Uint32(value[:4])
+	if uint64(len(value)-4) < uint64(pivotSize) {
+		return "", false, nil, nil, ErrCorrupted
+	}
+
+	return string(newKeyBytes), string(where) == listInsertBefore, value[4 : 4+pivotSize], value[4+pivotSize:], nil
+}
+
+// LMove atomically removes the first (fromLeft) or last element of the list stored in the
+// bucket at given srcBucket and srcKey, inserts it at the head (toLeft) or tail of the list
+// stored at given dstBucket and dstKey, and returns it. The move is written as a single
+// entry, so it is replayed as a whole, e.g. for moving a job to a processing list.
+func (tx *Tx) LMove(srcBucket string, srcKey []byte, dstBucket string, dstKey []byte, fromLeft, toLeft bool) (item []byte, err error) {
+	if fromLeft {
+		item, err = tx.LPeek(srcBucket, srcKey)
+	} else {
+		item, err = tx.RPeek(srcBucket, srcKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &listMove{
+		fromLeft:  fromLeft,
+		toLeft:    toLeft,
+		dstBucket: dstBucket,
+		dstKey:    string(dstKey),
+		item:      item,
+	}
+
+	return item, tx.push(srcBucket, srcKey, DataLMoveFlag, m.encode())
+}
+
+// RPopLPush atomically removes the last element of the list stored at given srcBucket and
+// srcKey, inserts it at the head of the list stored at given dstBucket and dstKey, and returns it.
+func (tx *Tx) RPopLPush(srcBucket string, srcKey []byte, dstBucket string, dstKey []byte) ([]byte, error) {
+	return tx.LMove(srcBucket, srcKey, dstBucket, dstKey, false, true)
+}
+
+// listMove represents the value of the LMove entry, whose key is the source key.
+type listMove struct {
+	fromLeft  bool
+	toLeft    bool
+	dstBucket string
+	dstKey    string
+	item      []byte
+}
+
+const (
+	listMoveFromLeft = 1 << iota
+	listMoveToLeft
+)
+
+// encode returns the value of the LMove entry.
+//
+//	| where | dstBucketSize | dstBucket | dstKeySize | dstKey | item   |
+//	| byte  |    uint32     |  []byte   |   uint32   | []byte | []byte |
+func (m *listMove) encode() []byte {
+	buf := make([]byte, 1, 9+len(m.dstBucket)+len(m.dstKey)+len(m.item))
+	if m.fromLeft {
+		buf[0] |= listMoveFromLeft
+	}
+	if m.toLeft {
+		buf[0] |= listMoveToLeft
+	}
+
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(m.dstBucket)))
+	buf = append(buf, size...)
+	buf = append(buf, m.dstBucket...)
+	binary.BigEndian.PutUint32(size, uint32(len(m.dstKey)))
+	buf = append(buf, size...)
+	buf = append(buf, m.dstKey...)
+
+	return append(buf, m.item...)
+}
+
+// decodeLMove decodes the value of the LMove entry.
+func decodeLMove(value []byte) (*listMove, error) {
+	m := &listMove{}
+	if len(value) < 1 {
+		return nil, ErrCorrupted
+	}
+	m.fromLeft = value[0]&listMoveFromLeft != 0
+	m.toLeft = value[0]&listMoveToLeft != 0
+	value = value[1:]
+
+	for _, field := range []*string{&m.dstBucket, &m.dstKey} {
+		if len(value) < 4 {
+			return nil, ErrCorrupted
+		}
+		size := binary.BigEndian.Uint32(value[:4])
+		if uint64(len(value)-4) < uint64(size) {
+			return nil, ErrCorrupted
+		}
+		*field = string(value[4 : 4+size])
+		value = value[4+size:]
+	}
+	m.item = value
+
+	return m, nil
+}
+
+// applyLMove applies the LMove entry of the source list stored at given bucket and key to the list index.
+func (db *DB) applyLMove(bucket, key string, m *listMove) (err error) {
+	if m.fromLeft {
+		_, err = db.ListIdx[bucket].LPop(key)
+	} else {
+		_, err = db.ListIdx[bucket].RPop(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, ok := db.ListIdx[m.dstBucket]; !ok {
+		db.ListIdx[m.dstBucket] = list.New()
+	}
+
+	if m.toLeft {
+		_, err = db.ListIdx[m.dstBucket].LPush(m.dstKey, m.item)
+	} else {
+		_, err = db.ListIdx[m.dstBucket].RPush(m.dstKey, m.item)
+	}
+
+	return err
+}
+
+// lMoveMergeEntry returns the push entry keeping the moved item in the destination list
+// when merging the LMove entry, or nil if the item is not there any more.
+func (db *DB) lMoveMergeEntry(entry *Entry) *Entry {
+	m, err := decodeLMove(entry.Value)
+	if err != nil {
+		return nil
+	}
+
+	l, ok := db.ListIdx[m.dstBucket]
+	if !ok {
+		return nil
+	}
+
+	if positions, _ := l.LPos(m.dstKey, m.item, nil); len(positions) == 0 {
+		return nil
+	}
+
+	flag := DataRPushFlag
+	if m.toLeft {
+		flag = DataLPushFlag
+	}
+
+	return &Entry{
+		Key:   []byte(m.dstKey),
+		Value: m.item,
+		Meta: &MetaData{
+			Flag:      flag,
+			TTL:       entry.Meta.TTL,
+			timestamp: entry.Meta.timestamp,
+			bucket:    []byte(m.dstBucket),
+			ds:        DataStructureList,
+			siteID:    entry.Meta.siteID,
+		},
+	}
+}
+
 // ErrSeparatorForListKey returns when list key contains the SeparatorForListKey.
+//
+// Deprecated: the list keys may contain SeparatorForListKey.
 func ErrSeparatorForListKey() error {
 	return errors.New("contain separator (" + SeparatorForListKey + ") for List key")
 }
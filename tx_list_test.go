@@ -18,6 +18,8 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"github.com/xujiajun/nutsdb/ds/list"
 )
 
 func InitForList() {
@@ -54,9 +56,9 @@ func TestTx_RPush(t *testing.T) {
 	bucket := "myBucket"
 	key := []byte("myList")
 
-	if err := tx.RPush(bucket, []byte("myList"+SeparatorForListKey), []byte("a"), []byte("b"), []byte("c"), []byte("d")); err == nil {
+	if err := tx.RPush(bucket, []byte("myList"+SeparatorForListKey), []byte("a"), []byte("b"), []byte("c"), []byte("d")); err != nil {
 		tx.Rollback()
-		t.Fatal("TestTx_RPush err")
+		t.Fatal(err)
 	}
 
 	if err := tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c"), []byte("d")); err != nil {
@@ -93,8 +95,8 @@ func TestTx_LPush(t *testing.T) {
 
 	bucket := "myBucket"
 	key := []byte("myList")
-	if err := tx.LPush(bucket, []byte("myList"+SeparatorForListKey), []byte("d"), []byte("c"), []byte("b"), []byte("a")); err == nil {
-		t.Error("TestTx_LPush err")
+	if err := tx.LPush(bucket, []byte("myList"+SeparatorForListKey), []byte("d"), []byte("c"), []byte("b"), []byte("a")); err != nil {
+		t.Error(err)
 	}
 
 	if err := tx.LPush(bucket, key, []byte("d"), []byte("c"), []byte("b"), []byte("a")); err != nil {
@@ -600,3 +602,162 @@ func TestTx_LSize(t *testing.T) {
 		}
 	}
 }
+
+func TestTx_LInsertAndLPos(t *testing.T) {
+	InitForList()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "myBucket"
+	key := []byte("myList")
+
+	InitDataForList(bucket, key, t)
+
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.LInsert(bucket, key, true, []byte("z"), []byte("x")); err != list.ErrPivotNotFound {
+			t.Errorf("expected ErrPivotNotFound, got %v", err)
+		}
+		if err := tx.LInsert(bucket, []byte("fake_key"), true, []byte("a"), []byte("x")); err != ErrKeyNotFound {
+			t.Errorf("expected ErrKeyNotFound, got %v", err)
+		}
+		if err := tx.LInsert(bucket, key, true, []byte("b"), []byte("x")); err != nil {
+			return err
+		}
+		return tx.LInsert(bucket, key, false, []byte("c"), []byte("b"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			items, err := tx.LRange(bucket, key, 0, -1)
+			if err != nil {
+				return err
+			}
+			var got string
+			for _, item := range items {
+				got += string(item)
+			}
+			if got != "axbcb" {
+				t.Errorf("TestTx_LInsertAndLPos err: got %s", got)
+			}
+
+			positions, err := tx.LPos(bucket, key, []byte("b"), &list.LPosOptions{})
+			if err != nil {
+				return err
+			}
+			if len(positions) != 2 || positions[0] != 2 || positions[1] != 4 {
+				t.Errorf("TestTx_LInsertAndLPos err: got %v", positions)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check()
+
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTx_LMove(t *testing.T) {
+	InitForList()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket, dstBucket := "myBucket", "myProcessingBucket"
+	key, dstKey := []byte("myList"), []byte("myProcessingList")
+
+	InitDataForList(bucket, key, t)
+
+	err = db.Update(func(tx *Tx) error {
+		if _, err := tx.LMove("fake_bucket", key, dstBucket, dstKey, true, true); err != ErrBucket {
+			t.Errorf("expected ErrBucket, got %v", err)
+		}
+
+		item, err := tx.RPopLPush(bucket, key, dstBucket, dstKey)
+		if err != nil {
+			return err
+		}
+		if string(item) != "c" {
+			t.Errorf("TestTx_LMove err: got %s", item)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		_, err := tx.LMove(bucket, key, dstBucket, dstKey, true, false)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			for _, c := range []struct {
+				bucket string
+				key    []byte
+				want   string
+			}{
+				{bucket, key, "b"},
+				{dstBucket, dstKey, "ca"},
+			} {
+				items, err := tx.LRange(c.bucket, c.key, 0, -1)
+				if err != nil {
+					return err
+				}
+				var got string
+				for _, item := range items {
+					got += string(item)
+				}
+				if got != c.want {
+					t.Errorf("TestTx_LMove err: got %s, want %s", got, c.want)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check()
+
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -15,15 +15,12 @@
 package nutsdb
 
 import (
-	"errors"
-	"time"
-
 	"github.com/xujiajun/nutsdb/ds/set"
 )
 
 func (tx *Tx) sPut(bucket string, key []byte, dataFlag uint16, items ...[]byte) error {
 	for _, item := range items {
-		err := tx.put(bucket, key, item, Persistent, dataFlag, uint64(time.Now().Unix()), DataStructureSet)
+		err := tx.put(bucket, key, item, Persistent, dataFlag, tx.now(), DataStructureSet)
 		if err != nil {
 			return err
 		}
@@ -37,6 +34,34 @@ func (tx *Tx) SAdd(bucket string, key []byte, items ...[]byte) error {
 	return tx.sPut(bucket, key, DataSetFlag, items...)
 }
 
+// SAddCapped adds the specified members to the set stored in the bucket at given bucket and key,
+// evicting members by policy so that the set holds at most max members, and returns the evicted
+// members. The evictions are written as removals, so recovery rebuilds the same bounded set.
+// They are computed from the committed set, not from the other writes of the transaction.
+func (tx *Tx) SAddCapped(bucket string, key []byte, max int, policy set.EvictPolicy, items ...[]byte) ([][]byte, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+
+	tx.lockBucket(bucket)
+
+	s, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		s = set.New()
+	}
+
+	added, evicted, err := s.CapEvictions(string(key), max, policy, items...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.sPut(bucket, key, DataDeleteFlag, evicted...); err != nil {
+		return nil, err
+	}
+
+	return evicted, tx.sPut(bucket, key, DataSetFlag, added...)
+}
+
 // SRem removes the specified members from the set stored int the bucket at given bucket,key and items.
 func (tx *Tx) SRem(bucket string, key []byte, items ...[]byte) error {
 	return tx.sPut(bucket, key, DataDeleteFlag, items...)
@@ -48,6 +73,8 @@ func (tx *Tx) SAreMembers(bucket string, key []byte, items ...[]byte) (bool, err
 		return false, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if sets, ok := tx.db.SetIdx[bucket]; ok {
 		return sets.SAreMembers(string(key), items...)
 	}
@@ -61,6 +88,8 @@ func (tx *Tx) SIsMember(bucket string, key, item []byte) (bool, error) {
 		return false, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		if !set.SIsMember(string(key), item) {
 			return false, ErrBucketAndKey(bucket, key)
@@ -77,6 +106,8 @@ func (tx *Tx) SMembers(bucket string, key []byte) (list [][]byte, err error) {
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		return set.SMembers(string(key))
 	}
@@ -91,6 +122,8 @@ func (tx *Tx) SHasKey(bucket string, key []byte) (bool, error) {
 		return false, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		return set.SHasKey(string(key)), nil
 	}
@@ -104,6 +137,8 @@ func (tx *Tx) SPop(bucket string, key []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SetIdx[bucket]; ok {
 		for item := range tx.db.SetIdx[bucket].M[string(key)] {
 			return []byte(item), tx.sPut(bucket, key, DataDeleteFlag, []byte(item))
@@ -113,12 +148,33 @@ func (tx *Tx) SPop(bucket string, key []byte) ([]byte, error) {
 	return nil, ErrBucketAndKey(bucket, key)
 }
 
+// SScan returns a page of at most count members of the set stored in the bucket at given
+// bucket and key, in bytewise order from the member following cursor, and the cursor of
+// the next page. The first page is at the nil cursor, and the next cursor is nil after
+// the last page. When match is not empty, only the members matching the pattern are
+// returned, see set.SScan.
+func (tx *Tx) SScan(bucket string, key, cursor []byte, match string, count int) (list [][]byte, next []byte, err error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, nil, err
+	}
+
+	tx.lockBucket(bucket)
+
+	if set, ok := tx.db.SetIdx[bucket]; ok {
+		return set.SScan(string(key), cursor, match, count)
+	}
+
+	return nil, nil, ErrBucketAndKey(bucket, key)
+}
+
 // SCard returns the set cardinality (number of elements) of the set stored in the bucket at given bucket and key.
 func (tx *Tx) SCard(bucket string, key []byte) (int, error) {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return 0, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		return set.SCard(string(key)), nil
 	}
@@ -133,6 +189,8 @@ func (tx *Tx) SDiffByOneBucket(bucket string, key1, key2 []byte) (list [][]byte,
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		return set.SDiff(string(key1), string(key2))
 	}
@@ -147,6 +205,9 @@ func (tx *Tx) SDiffByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key
 		return nil, err
 	}
 
+	tx.lockBucket(bucket1)
+	tx.lockBucket(bucket2)
+
 	var (
 		set1, set2 *set.Set
 		ok         bool
@@ -175,6 +236,8 @@ func (tx *Tx) SMoveByOneBucket(bucket string, key1, key2, item []byte) (bool, er
 		return false, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		return set.SMove(string(key1), string(key2), item)
 	}
@@ -188,6 +251,9 @@ func (tx *Tx) SMoveByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key
 		return false, err
 	}
 
+	tx.lockBucket(bucket1)
+	tx.lockBucket(bucket2)
+
 	var (
 		set1, set2 *set.Set
 		ok         bool
@@ -224,6 +290,8 @@ func (tx *Tx) SUnionByOneBucket(bucket string, key1, key2 []byte) (list [][]byte
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		return set.SUnion(string(key1), string(key2))
 	}
@@ -237,6 +305,9 @@ func (tx *Tx) SUnionByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, ke
 		return nil, err
 	}
 
+	tx.lockBucket(bucket1)
+	tx.lockBucket(bucket2)
+
 	var (
 		set1, set2 *set.Set
 		ok         bool
@@ -273,10 +344,10 @@ func (tx *Tx) SUnionByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, ke
 
 // ErrBucketAndKey returns when bucket or key not found.
 func ErrBucketAndKey(bucket string, key []byte) error {
-	return errors.New("not found bucket:" + bucket + ",key:" + string(key))
+	return &BucketError{Bucket: bucket, Key: key, Err: ErrBucketNotFound}
 }
 
 // ErrNotFoundKeyInBucket returns when key not in the bucket.
 func ErrNotFoundKeyInBucket(bucket string, key []byte) error {
-	return errors.New(string(key) + " is not in the" + bucket)
+	return &BucketError{Bucket: bucket, Key: key, Err: ErrKeyNotFound}
 }
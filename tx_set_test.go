@@ -15,9 +15,12 @@
 package nutsdb
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"github.com/xujiajun/nutsdb/ds/set"
 )
 
 func InitForSet() {
@@ -966,3 +969,137 @@ func TestTx_SAreMembers(t *testing.T) {
 	tx.Commit()
 	opSAreMembersForTest(bucket, key, t)
 }
+
+func TestTx_SScan(t *testing.T) {
+	InitForSet()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "bucket1"
+	key := []byte("key1")
+
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 25; i++ {
+			if err := tx.SAdd(bucket, key, []byte(fmt.Sprintf("member%02d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.View(func(tx *Tx) error {
+		if _, _, err := tx.SScan("fake_bucket", key, nil, "", 10); err == nil {
+			t.Error("TestTx_SScan err: expected the bucket error")
+		}
+
+		var (
+			cursor []byte
+			n      int
+		)
+		for {
+			list, next, err := tx.SScan(bucket, key, cursor, "member1*", 3)
+			if err != nil {
+				return err
+			}
+			n += len(list)
+			if next == nil {
+				break
+			}
+			cursor = next
+		}
+		if n != 10 {
+			t.Errorf("TestTx_SScan err: got %d members", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTx_SAddCapped(t *testing.T) {
+	InitForSet()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "bucket1"
+	key := []byte("key1")
+
+	for i := 0; i < 5; i++ {
+		err = db.Update(func(tx *Tx) error {
+			_, err := tx.SAddCapped(bucket, key, 3, set.EvictFIFO, []byte(fmt.Sprint(i)))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		if _, err := tx.SAddCapped(bucket, key, 0, set.EvictFIFO, []byte("x")); err != set.ErrCapacity {
+			t.Errorf("expected ErrCapacity, got %v", err)
+		}
+		evicted, err := tx.SAddCapped(bucket, key, 3, set.EvictFIFO, []byte("5"))
+		if len(evicted) != 1 || string(evicted[0]) != "2" {
+			t.Errorf("TestTx_SAddCapped err: evicted %s", evicted)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			if ok, err := tx.SAreMembers(bucket, key, []byte("3"), []byte("4"), []byte("5")); !ok {
+				t.Errorf("TestTx_SAddCapped err: %v", err)
+			}
+			if n, _ := tx.SCard(bucket, key); n != 3 {
+				t.Errorf("TestTx_SAddCapped err: got %d members", n)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check()
+
+	err = db.Update(func(tx *Tx) error {
+		evicted, err := tx.SAddCapped(bucket, key, 3, set.EvictFIFO, []byte("6"))
+		if len(evicted) != 1 || string(evicted[0]) != "3" {
+			t.Errorf("TestTx_SAddCapped err: evicted %s after reopening", evicted)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
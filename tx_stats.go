@@ -0,0 +1,175 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TxStats represents the reads and writes of a transaction so far.
+type TxStats struct {
+	// KeysRead is the number of keys read by Get, and returned by GetAll, RangeScan and PrefixScan.
+	KeysRead int
+
+	// KeysWritten is the number of entries written, see PendingCount.
+	KeysWritten int
+
+	// BytesPending is the size in bytes of the entries written, see PendingSize.
+	BytesPending int64
+
+	// Buckets are the buckets read or written, sorted.
+	Buckets []string
+}
+
+// TxInfo represents a transaction not committed or rolled back yet, see DB.OpenTxs.
+type TxInfo struct {
+	ID       uint64
+	Writable bool
+	Started  time.Time
+	Age      time.Duration
+
+	// Stack is the stack trace of the goroutine beginning the transaction,
+	// only recorded when Options.TxLeakDetection is enabled.
+	Stack []byte
+}
+
+// Stats returns the reads and writes of the transaction so far.
+// It must not be called concurrently with the writes of the transaction.
+func (tx *Tx) Stats() TxStats {
+	tx.statsMu.Lock()
+	defer tx.statsMu.Unlock()
+
+	stats := TxStats{
+		KeysRead:     tx.keysRead,
+		KeysWritten:  tx.PendingCount(),
+		BytesPending: tx.PendingSize(),
+	}
+
+	// the buckets written are taken from the pending writes, only when requested.
+	buckets := make(map[string]struct{}, len(tx.bucketsRead)+1)
+	if tx.bucketRead != "" {
+		buckets[tx.bucketRead] = struct{}{}
+	}
+	for bucket := range tx.bucketsRead {
+		buckets[bucket] = struct{}{}
+	}
+	for _, e := range tx.pendingWrites {
+		buckets[string(e.Meta.bucket)] = struct{}{}
+	}
+
+	stats.Buckets = make([]string, 0, len(buckets))
+	for bucket := range buckets {
+		stats.Buckets = append(stats.Buckets, bucket)
+	}
+	sort.Strings(stats.Buckets)
+
+	return stats
+}
+
+// recordRead counts n keys read in the bucket. The first bucket read is kept without
+// allocating, most transactions reading a single bucket.
+func (tx *Tx) recordRead(bucket string, n int) {
+	tx.statsMu.Lock()
+	defer tx.statsMu.Unlock()
+
+	tx.keysRead += n
+	if tx.bucketRead == "" || tx.bucketRead == bucket {
+		tx.bucketRead = bucket
+		return
+	}
+
+	if tx.bucketsRead == nil {
+		tx.bucketsRead = make(map[string]struct{})
+	}
+	tx.bucketsRead[bucket] = struct{}{}
+}
+
+// openTxs represents the registry of the transactions not committed or rolled back yet.
+// It takes no lock, as every transaction registers in it.
+type openTxs struct {
+	txs sync.Map // *openTx -> struct{}
+	n   int64    // the number of the txs, see MaxActiveTransactions
+}
+
+// openTx represents the registration of a transaction in the openTxs. It does not reference
+// the transaction, so that a leaked one can be collected.
+type openTx struct {
+	id           uint64
+	writable     bool
+	started      time.Time
+	stack        []byte // the stack trace of Begin, see Options.TxLeakDetection
+	leakReported bool   // only accessed by reportTxLeaks
+}
+
+// add registers the opened tx, unless max transactions are open, max 0 meaning no limit.
+func (o *openTxs) add(tx *Tx, max int) bool {
+	if max > 0 {
+		for {
+			n := atomic.LoadInt64(&o.n)
+			if n >= int64(max) {
+				return false
+			}
+			if atomic.CompareAndSwapInt64(&o.n, n, n+1) {
+				break
+			}
+		}
+	} else {
+		atomic.AddInt64(&o.n, 1)
+	}
+
+	tx.open.id = tx.id
+	o.txs.Store(tx.open, struct{}{})
+
+	return true
+}
+
+// remove unregisters the closed tx.
+func (o *openTxs) remove(tx *Tx) {
+	if _, ok := o.txs.LoadAndDelete(tx.open); ok {
+		atomic.AddInt64(&o.n, -1)
+	}
+}
+
+// OpenTxs returns the transactions not committed or rolled back yet, the oldest first,
+// e.g. for finding the transactions leaked or stuck holding the locks of the database.
+func (db *DB) OpenTxs() []TxInfo {
+	now := time.Now()
+
+	var infos []TxInfo
+	db.openTxs.txs.Range(func(o, _ interface{}) bool {
+		infos = append(infos, o.(*openTx).info(now))
+		return true
+	})
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+
+	return infos
+}
+
+// info returns the TxInfo of the transaction at given time.
+func (o *openTx) info(now time.Time) TxInfo {
+	return TxInfo{
+		ID:       o.id,
+		Writable: o.writable,
+		Started:  o.started,
+		Age:      now.Sub(o.started),
+		Stack:    o.stack,
+	}
+}
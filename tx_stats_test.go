@@ -0,0 +1,102 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTx_Stats(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttxstats", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *Tx) error {
+		for _, key := range []string{"key1", "key2", "key3"} {
+			if err := tx.Put("bucket1", []byte(key), []byte("val"), Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tx.Get("bucket1", []byte("key1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.PrefixScan("bucket1", []byte("key"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put("bucket2", []byte("key"), []byte("val"), Persistent); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := tx.Stats()
+	if stats.KeysRead != 4 || stats.KeysWritten != 1 || stats.BytesPending != tx.PendingSize() ||
+		!reflect.DeepEqual(stats.Buckets, []string{"bucket1", "bucket2"}) {
+		t.Errorf("err Stats. got %+v", stats)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_OpenTxs(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestopentxs", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	writeTx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txs := db.OpenTxs()
+	if len(txs) != 2 || txs[0].ID != writeTx.id || !txs[0].Writable || txs[1].ID != readTx.id || txs[1].Writable {
+		t.Fatalf("err OpenTxs. got %+v", txs)
+	}
+	if txs[0].Age < txs[1].Age {
+		t.Errorf("err OpenTxs. the oldest is not first: %+v", txs)
+	}
+
+	if err := writeTx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := readTx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if txs := db.OpenTxs(); len(txs) != 0 {
+		t.Errorf("err OpenTxs after closing the transactions. got %+v", txs)
+	}
+}
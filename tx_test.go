@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestTx_Rollback(t *testing.T) {
@@ -150,3 +151,167 @@ func TestTx_Close(t *testing.T) {
 		t.Error("err TestTx_Close")
 	}
 }
+
+func TestTx_ViewNotBlockedByOpenWriter(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttxlock", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bucket := "bucket_tx_lock"
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Put(bucket, []byte("key"), []byte("new"), Persistent); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, []byte("key"))
+			if err == nil && string(e.Value) != "val" {
+				err = fmt.Errorf("got %s want val", e.Value)
+			}
+			return err
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("err View blocked by an open writable tx")
+	}
+
+	if err := writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTx_MultiBucketAtomicity(t *testing.T) {
+	Init()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(tx *Tx) error {
+		if err := tx.Put("kvBucket", []byte("key"), []byte("val"), Persistent); err != nil {
+			return err
+		}
+		if err := tx.RPush("listBucket", []byte("list"), []byte("a"), []byte("b")); err != nil {
+			return err
+		}
+		if err := tx.SAdd("setBucket", []byte("set"), []byte("a")); err != nil {
+			return err
+		}
+		return tx.ZAdd("zsetBucket", []byte("zset"), 1, []byte("a"))
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write(tx); err != nil {
+		t.Fatal(err)
+	}
+	if tx.PendingCount() != 5 {
+		t.Errorf("TestTx_MultiBucketAtomicity err: %d pending entries", tx.PendingCount())
+	}
+	var size int64
+	for _, entry := range tx.pendingWrites {
+		size += entry.Size()
+	}
+	if tx.PendingSize() != size {
+		t.Errorf("TestTx_MultiBucketAtomicity err: pending size %d, want %d", tx.PendingSize(), size)
+	}
+	commitSize := tx.pendingWrites[len(tx.pendingWrites)-1].Size()
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole transaction is discarded when its last entry, marking it committed, is lost.
+	if err := db.Update(write); err != nil {
+		t.Fatal(err)
+	}
+	fID, end := db.ActiveFile.fileID, db.ActiveFile.writeOff
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(db.getDataPath(fID), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(make([]byte, commitSize), end-commitSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.View(func(tx *Tx) error {
+		if _, err := tx.Get("kvBucket", []byte("key")); err == nil {
+			t.Error("TestTx_MultiBucketAtomicity err: found the key of the uncommitted transaction")
+		}
+		if n, _ := tx.LSize("listBucket", []byte("list")); n != 0 {
+			t.Errorf("TestTx_MultiBucketAtomicity err: found %d list items", n)
+		}
+		if ok, _ := tx.SIsMember("setBucket", []byte("set"), []byte("a")); ok {
+			t.Error("TestTx_MultiBucketAtomicity err: found the set member")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(write); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *Tx) error {
+		if _, err := tx.Get("kvBucket", []byte("key")); err != nil {
+			return err
+		}
+		if n, err := tx.LSize("listBucket", []byte("list")); err != nil || n != 2 {
+			t.Errorf("TestTx_MultiBucketAtomicity err: got %d list items, %v", n, err)
+		}
+		if ok, err := tx.SIsMember("setBucket", []byte("set"), []byte("a")); !ok {
+			t.Errorf("TestTx_MultiBucketAtomicity err: %v", err)
+		}
+		if n, err := tx.ZCard("zsetBucket"); err != nil || n != 1 {
+			t.Errorf("TestTx_MultiBucketAtomicity err: got %d zset members, %v", n, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTxConcurrentUse is returned when a writable transaction is written, committed or rolled
+	// back from several goroutines at the same time.
+	ErrTxConcurrentUse = errors.New("writable tx used from multiple goroutines")
+
+	// ErrTxLeaked is recorded as the LastError of DB.Health when a transaction of Begin is
+	// garbage collected without Commit or Rollback while Options.TxLeakDetection is enabled,
+	// wrapped with its ID and the stack trace of its Begin.
+	ErrTxLeaked = errors.New("tx collected without Commit or Rollback")
+)
+
+// Closed returns if the transaction is committed or rolled back, e.g. for an embedder managing
+// the transactions with Begin to check that every one is closed.
+func (tx *Tx) Closed() bool {
+	return tx.db == nil
+}
+
+// acquire marks the writable tx in use by the calling goroutine until release,
+// returning ErrTxConcurrentUse if another goroutine is using it.
+func (tx *Tx) acquire() error {
+	if !tx.writable {
+		return nil
+	}
+
+	if !tx.inUse.CompareAndSwap(false, true) {
+		return ErrTxConcurrentUse
+	}
+
+	return nil
+}
+
+// release marks the tx marked by acquire not in use.
+func (tx *Tx) release() {
+	if tx.writable {
+		tx.inUse.Store(false)
+	}
+}
+
+// finalize rolls back the tx collected without Commit or Rollback, releasing its lock, and
+// records it as an ErrTxLeaked.
+func (tx *Tx) finalize() {
+	db := tx.db
+	if db == nil {
+		return
+	}
+
+	err := fmt.Errorf("%w: transaction %d (writable: %v) rolled back", ErrTxLeaked, tx.id, tx.writable)
+	if tx.open.stack != nil {
+		err = fmt.Errorf("%w, begun at:\n%s", err, tx.open.stack)
+	}
+	db.health.recordError(err)
+
+	_ = tx.Rollback()
+}
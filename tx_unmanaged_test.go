@@ -0,0 +1,104 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTx_Closed(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttxunmanaged", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Closed() {
+		t.Error("err Closed of an open transaction")
+	}
+
+	// another goroutine is writing the transaction.
+	tx.inUse.Store(true)
+	if err := tx.Put("bucket", []byte("key"), []byte("val"), Persistent); err != ErrTxConcurrentUse {
+		t.Errorf("err Put of a transaction in use. got %v want ErrTxConcurrentUse", err)
+	}
+	if err := tx.Commit(); err != ErrTxConcurrentUse {
+		t.Errorf("err Commit of a transaction in use. got %v want ErrTxConcurrentUse", err)
+	}
+	tx.inUse.Store(false)
+
+	if err := tx.Put("bucket", []byte("key"), []byte("val"), Persistent); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !tx.Closed() {
+		t.Error("err Closed of a committed transaction")
+	}
+}
+
+func TestTx_FinalizeLeaked(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttxunmanaged", true)
+	opt.TxLeakDetection.Threshold = time.Hour
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	func() {
+		if _, err := db.Begin(true); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// the leaked transaction holds the write lock until it is collected.
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+		})
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if txs := db.OpenTxs(); len(txs) != 0 {
+				t.Errorf("err OpenTxs after the leaked transaction is collected. got %+v", txs)
+			}
+			if err := db.Health().LastError; !errors.Is(err, ErrTxLeaked) {
+				t.Errorf("err LastError after the leaked transaction is collected. got %v want %v", err, ErrTxLeaked)
+			}
+			return
+		case <-deadline:
+			t.Fatal("err leaked transaction not rolled back by its finalizer")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
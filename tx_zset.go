@@ -15,34 +15,50 @@
 package nutsdb
 
 import (
-	"bytes"
 	"errors"
-	"strconv"
-	"strings"
-	"time"
+	"math"
 
 	"github.com/xujiajun/nutsdb/ds/zset"
 	"github.com/xujiajun/utils/strconv2"
 )
 
 // SeparatorForZSetKey represents separator for zSet key.
+// It is only used to decode the entries written before the zSet keys may contain it.
 const SeparatorForZSetKey = "|"
 
-// ZAdd adds the specified member key with the specified score and specified val to the sorted set stored at bucket.
-func (tx *Tx) ZAdd(bucket string, key []byte, score float64, val []byte) error {
-	var buffer bytes.Buffer
+// ErrZStoreWeights is returned when the number of weights differs from the number of sorted sets.
+var ErrZStoreWeights = errors.New("the number of weights must equal the number of sorted sets")
 
-	if strings.Contains(string(key), SeparatorForZSetKey) {
-		return ErrSeparatorForZSetKey()
-	}
+// ZAggregate represents how ZUnionStore and ZInterStore combine the scores of a member.
+type ZAggregate int
+
+const (
+	// ZAggregateSum sums the scores.
+	ZAggregateSum ZAggregate = iota
+
+	// ZAggregateMin keeps the min score.
+	ZAggregateMin
 
-	buffer.Write(key)
-	buffer.Write([]byte(SeparatorForZSetKey))
-	scoreBytes := []byte(strconv.FormatFloat(score, 'f', -1, 64))
-	buffer.Write(scoreBytes)
-	newKey := buffer.Bytes()
+	// ZAggregateMax keeps the max score.
+	ZAggregateMax
+)
+
+// ZStoreOptions represents the options of ZUnionStore and ZInterStore.
+type ZStoreOptions struct {
+	// Weights represents the factors of the scores of every sorted set.
+	// Default Weights is nil, which means 1 for every sorted set.
+	Weights []float64
 
-	return tx.put(bucket, newKey, val, Persistent, DataZAddFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	// Aggregate represents how the weighted scores of a member are combined.
+	// Default Aggregate is ZAggregateSum.
+	Aggregate ZAggregate
+}
+
+// ZAdd adds the specified member key with the specified score and specified val to the sorted set stored at bucket.
+func (tx *Tx) ZAdd(bucket string, key []byte, score float64, val []byte) error {
+	newKey := encodeCompositeKey(key, encodeScore(score))
+
+	return tx.put(bucket, newKey, val, Persistent, DataZAddFlag, tx.now(), DataStructureSortedSet)
 }
 
 // ZMembers returns all the members of the set value stored at bucket.
@@ -51,6 +67,8 @@ func (tx *Tx) ZMembers(bucket string) (map[string]*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	return tx.db.SortedSetIdx[bucket].Dict, nil
 }
 
@@ -85,7 +103,7 @@ func (tx *Tx) ZPopMax(bucket string) (*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
-	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMaxFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMaxFlag, tx.now(), DataStructureSortedSet)
 }
 
 // ZPopMin removes and returns the member with the lowest score in the sorted set stored at bucket.
@@ -95,7 +113,7 @@ func (tx *Tx) ZPopMin(bucket string) (*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
-	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMinFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMinFlag, tx.now(), DataStructureSortedSet)
 }
 
 // ZPeekMax returns the member with the highest score in the sorted set stored at bucket.
@@ -104,6 +122,8 @@ func (tx *Tx) ZPeekMax(bucket string) (*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return nil, ErrBucket
 	}
@@ -117,6 +137,8 @@ func (tx *Tx) ZPeekMin(bucket string) (*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return nil, ErrBucket
 	}
@@ -130,6 +152,8 @@ func (tx *Tx) ZRangeByScore(bucket string, start, end float64, opts *zset.GetByS
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return nil, ErrBucket
 	}
@@ -144,6 +168,8 @@ func (tx *Tx) ZRangeByRank(bucket string, start, end int) ([]*zset.SortedSetNode
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return nil, ErrBucket
 	}
@@ -157,11 +183,13 @@ func (tx *Tx) ZRem(bucket, key string) error {
 		return err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return ErrBucket
 	}
 
-	return tx.put(bucket, []byte(key), []byte(""), Persistent, DataZRemFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return tx.put(bucket, []byte(key), []byte(""), Persistent, DataZRemFlag, tx.now(), DataStructureSortedSet)
 }
 
 // ZRemRangeByRank removes all elements in the sorted set stored in one bucket at given bucket with rank between start and end.
@@ -171,13 +199,159 @@ func (tx *Tx) ZRemRangeByRank(bucket string, start, end int) error {
 		return err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return ErrBucket
 	}
 
 	newKey := strconv2.IntToStr(start)
 	newVal := strconv2.IntToStr(end)
-	return tx.put(bucket, []byte(newKey), []byte(newVal), Persistent, DataZRemRangeByRankFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return tx.put(bucket, []byte(newKey), []byte(newVal), Persistent, DataZRemRangeByRankFlag, tx.now(), DataStructureSortedSet)
+}
+
+// ZRangeByLex returns the elements in the sorted set at bucket with a key between min and max,
+// with the bounds of the Redis ZRANGEBYLEX: "[a" includes a, "(a" excludes a, "-" and "+"
+// are the lowest and the highest keys. It is meant for the members sharing a score.
+func (tx *Tx) ZRangeByLex(bucket string, min, max string) ([]*zset.SortedSetNode, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+
+	tx.lockBucket(bucket)
+
+	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+		return nil, ErrBucket
+	}
+
+	lexRange, err := zset.ParseLexRange(min, max)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx.db.SortedSetIdx[bucket].GetByLexRange(lexRange, false), nil
+}
+
+// ZRemRangeByLex removes all elements in the sorted set stored in one bucket at given bucket
+// with a key between min and max, see ZRangeByLex for the bounds.
+func (tx *Tx) ZRemRangeByLex(bucket string, min, max string) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	tx.lockBucket(bucket)
+
+	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+		return ErrBucket
+	}
+
+	if _, err := zset.ParseLexRange(min, max); err != nil {
+		return err
+	}
+
+	return tx.put(bucket, []byte(min), []byte(max), Persistent, DataZRemRangeByLexFlag, tx.now(), DataStructureSortedSet)
+}
+
+// ZUnionStore stores into the sorted set dst the union of the sorted sets srcs, the
+// score of a member being the aggregate of its weighted scores, and returns the
+// number of members of dst. A missing sorted set is empty. The value of a member
+// is the one of the first sorted set holding it. dst is replaced, and the members
+// are written as entries of the transaction, so the result is committed atomically.
+func (tx *Tx) ZUnionStore(dst string, srcs []string, opts *ZStoreOptions) (int, error) {
+	return tx.zStore(dst, srcs, opts, false)
+}
+
+// ZInterStore stores into the sorted set dst the intersection of the sorted sets srcs,
+// and returns the number of members of dst, see ZUnionStore.
+func (tx *Tx) ZInterStore(dst string, srcs []string, opts *ZStoreOptions) (int, error) {
+	return tx.zStore(dst, srcs, opts, true)
+}
+
+// zStoreMember represents a member of the result of ZUnionStore and ZInterStore.
+type zStoreMember struct {
+	score float64
+	value []byte
+	count int
+}
+
+func (tx *Tx) zStore(dst string, srcs []string, opts *ZStoreOptions, inter bool) (int, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return 0, err
+	}
+
+	if opts == nil {
+		opts = &ZStoreOptions{}
+	}
+
+	if opts.Weights != nil && len(opts.Weights) != len(srcs) {
+		return 0, ErrZStoreWeights
+	}
+
+	members := make(map[string]*zStoreMember)
+	var order []string
+
+	for i, src := range srcs {
+		weight := 1.0
+		if opts.Weights != nil {
+			weight = opts.Weights[i]
+		}
+
+		tx.lockBucket(src)
+		ss, ok := tx.db.SortedSetIdx[src]
+		if !ok {
+			continue
+		}
+
+		for _, node := range ss.GetByRankRange(1, -1, false) {
+			score := float64(node.Score()) * weight
+			if math.IsNaN(score) {
+				score = 0
+			}
+
+			m, ok := members[node.Key()]
+			if !ok {
+				members[node.Key()] = &zStoreMember{score: score, value: node.Value, count: 1}
+				order = append(order, node.Key())
+				continue
+			}
+
+			m.count++
+			switch opts.Aggregate {
+			case ZAggregateMin:
+				m.score = math.Min(m.score, score)
+			case ZAggregateMax:
+				m.score = math.Max(m.score, score)
+			default:
+				if m.score += score; math.IsNaN(m.score) {
+					m.score = 0
+				}
+			}
+		}
+	}
+
+	tx.lockBucket(dst)
+	if ss, ok := tx.db.SortedSetIdx[dst]; ok {
+		for key := range ss.Dict {
+			if err := tx.ZRem(dst, key); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n := 0
+	for _, key := range order {
+		m := members[key]
+		if inter && m.count != len(srcs) {
+			continue
+		}
+
+		if err := tx.ZAdd(dst, []byte(key), m.score, m.value); err != nil {
+			return 0, err
+		}
+		n++
+	}
+
+	return n, nil
 }
 
 // ZRank returns the rank of member in the sorted set stored in the bucket at given bucket and key,
@@ -187,6 +361,8 @@ func (tx *Tx) ZRank(bucket string, key []byte) (int, error) {
 		return 0, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return 0, ErrBucket
 	}
@@ -201,6 +377,8 @@ func (tx *Tx) ZRevRank(bucket string, key []byte) (int, error) {
 		return 0, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return 0, ErrBucket
 	}
@@ -214,6 +392,8 @@ func (tx *Tx) ZScore(bucket string, key []byte) (float64, error) {
 		return 0, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return 0, ErrBucket
 	}
@@ -231,6 +411,8 @@ func (tx *Tx) ZGetByKey(bucket string, key []byte) (*zset.SortedSetNode, error)
 		return nil, err
 	}
 
+	tx.lockBucket(bucket)
+
 	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
 		return nil, ErrBucket
 	}
@@ -243,6 +425,8 @@ func (tx *Tx) ZGetByKey(bucket string, key []byte) (*zset.SortedSetNode, error)
 }
 
 // ErrSeparatorForZSetKey returns when zSet key contains the SeparatorForZSetKey flag.
+//
+// Deprecated: the zSet keys may contain SeparatorForZSetKey.
 func ErrSeparatorForZSetKey() error {
 	return errors.New("contain separator (" + SeparatorForZSetKey + ") for ZSet key")
 }
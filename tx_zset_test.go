@@ -19,6 +19,8 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"github.com/xujiajun/nutsdb/ds/zset"
 )
 
 var tx *Tx
@@ -57,9 +59,9 @@ func TestTx_ZAdd(t *testing.T) {
 		tx.Rollback()
 	} else {
 		err := tx.ZAdd(bucket, []byte("key1"+SeparatorForZSetKey), 100, []byte("val1"))
-		if err == nil {
+		if err != nil {
 			tx.Rollback()
-			t.Fatal("TestTx_ZAdd err")
+			t.Fatal(err)
 		}
 
 		tx.Commit()
@@ -71,7 +73,7 @@ func TestTx_ZAdd(t *testing.T) {
 	}
 
 	num, err := tx.ZCard(bucket)
-	if num != 1 || err != nil {
+	if num != 2 || err != nil {
 		t.Error("TestTx_ZAdd err")
 	}
 
@@ -600,3 +602,169 @@ func TestTx_ZGetByKey(t *testing.T) {
 		t.Error("TestTx_ZGetByKey err")
 	}
 }
+
+func TestTx_ZRangeByLex(t *testing.T) {
+	InitForZSet()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := "myZSet"
+	if err := db.Update(func(tx *Tx) error {
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			if err := tx.ZAdd(bucket, []byte(key), 0, []byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(min, max string) string {
+		var got string
+		if err := db.View(func(tx *Tx) error {
+			nodes, err := tx.ZRangeByLex(bucket, min, max)
+			for _, n := range nodes {
+				got += n.Key()
+			}
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := keys("[b", "(e"); got != "bcd" {
+		t.Errorf("TestTx_ZRangeByLex err: got %q", got)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.ZRangeByLex(bucket, "b", "+")
+		return err
+	}); err != zset.ErrInvalidLexRange {
+		t.Errorf("expected ErrInvalidLexRange, got %v", err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.ZRemRangeByLex("bucket_fake", "-", "+"); err != ErrBucket {
+			t.Errorf("expected ErrBucket, got %v", err)
+		}
+		return tx.ZRemRangeByLex(bucket, "(a", "[c")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := keys("-", "+"); got != "ade" {
+		t.Errorf("TestTx_ZRemRangeByLex err: got %q", got)
+	}
+
+	// the removal is replayed when reopening.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if got := keys("-", "+"); got != "ade" {
+		t.Errorf("TestTx_ZRemRangeByLex err after reopening: got %q", got)
+	}
+}
+
+func TestTx_ZUnionStore(t *testing.T) {
+	InitForZSet()
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		for key, score := range map[string]float64{"a": 1, "b": 2, "c": 3} {
+			if err := tx.ZAdd("zset1", []byte(key), score, []byte("v1")); err != nil {
+				return err
+			}
+		}
+		for key, score := range map[string]float64{"b": 10, "c": 20, "d": 30} {
+			if err := tx.ZAdd("zset2", []byte(key), score, []byte("v2")); err != nil {
+				return err
+			}
+		}
+		// dst is replaced.
+		return tx.ZAdd("dst", []byte("old"), 1, []byte("old"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	scores := func(bucket string) map[string]float64 {
+		got := make(map[string]float64)
+		if err := db.View(func(tx *Tx) error {
+			members, err := tx.ZMembers(bucket)
+			for key, node := range members {
+				got[key] = float64(node.Score())
+			}
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	check := func(got, want map[string]float64) {
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		n, err := tx.ZUnionStore("dst", []string{"zset1", "zset2", "missing"}, &ZStoreOptions{Weights: []float64{1, 2, 1}})
+		if n != 4 {
+			t.Errorf("expected 4 members, got %d", n)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	check(scores("dst"), map[string]float64{"a": 1, "b": 22, "c": 43, "d": 60})
+
+	if err := db.Update(func(tx *Tx) error {
+		n, err := tx.ZInterStore("dst", []string{"zset1", "zset2"}, &ZStoreOptions{Aggregate: ZAggregateMin})
+		if n != 2 {
+			t.Errorf("expected 2 members, got %d", n)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	check(scores("dst"), map[string]float64{"b": 2, "c": 3})
+
+	if err := db.Update(func(tx *Tx) error {
+		_, err := tx.ZUnionStore("dst", []string{"zset1", "zset2"}, &ZStoreOptions{Aggregate: ZAggregateMax})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	check(scores("dst"), map[string]float64{"a": 1, "b": 10, "c": 20, "d": 30})
+
+	if err := db.Update(func(tx *Tx) error {
+		_, err := tx.ZUnionStore("dst", []string{"zset1"}, &ZStoreOptions{Weights: []float64{1, 2}})
+		return err
+	}); err != ErrZStoreWeights {
+		t.Errorf("expected ErrZStoreWeights, got %v", err)
+	}
+
+	// the result is replayed when reopening.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	check(scores("dst"), map[string]float64{"a": 1, "b": 10, "c": 20, "d": 30})
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// LastTxIDFileName is the name of the file holding the ID of the last committed transaction,
+// written when closing the DB and before a merge removes a data file.
+const LastTxIDFileName = "tx.last"
+
+// ErrLastTxIDCorrupted is returned when the last tx ID file is not 8 bytes long.
+var ErrLastTxIDCorrupted = wrapError("last tx id corrupted", ErrCorrupted)
+
+// ID returns the ID of the transaction. The IDs of the writable transactions
+// increase in the order of their commits, also across restarts, so the ID of a
+// committed transaction can key an idempotent applier or a replication offset.
+func (tx *Tx) ID() uint64 {
+	return tx.id
+}
+
+// LastCommittedTxID returns the ID of the last committed transaction, 0 if none.
+func (db *DB) LastCommittedTxID() uint64 {
+	db.txIDsMu.RLock()
+	defer db.txIDsMu.RUnlock()
+
+	return db.lastTxID
+}
+
+// recordCommittedTxID records the ID of a committed transaction.
+func (db *DB) recordCommittedTxID(txID uint64) {
+	db.txIDsMu.Lock()
+	defer db.txIDsMu.Unlock()
+
+	if txID > db.lastTxID {
+		db.lastTxID = txID
+	}
+}
+
+// addCommittedTxID records the committed transaction of the ID, see isCommittedTx.
+func (db *DB) addCommittedTxID(txID uint64) {
+	db.txIDsMu.Lock()
+	db.committedTxIds[txID] = struct{}{}
+	db.txIDsMu.Unlock()
+
+	db.recordCommittedTxID(txID)
+}
+
+// isCommittedTx returns if the transaction of the ID is committed. The commits only
+// updating the indexes of their buckets hold db.mu for reading, so the committed
+// transactions are guarded apart.
+func (db *DB) isCommittedTx(txID uint64) bool {
+	db.txIDsMu.RLock()
+	defer db.txIDsMu.RUnlock()
+
+	_, ok := db.committedTxIds[txID]
+
+	return ok
+}
+
+// loadLastTxID records the ID persisted by persistLastTxID, the data files holding
+// the IDs of the transactions committed since.
+func (db *DB) loadLastTxID() error {
+	buf, err := db.readFile(db.getLastTxIDPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(buf) != 8 {
+		return ErrLastTxIDCorrupted
+	}
+
+	db.recordCommittedTxID(binary.LittleEndian.Uint64(buf))
+
+	return nil
+}
+
+// persistLastTxID writes the ID of the last committed transaction, unless the db is read-only.
+// It must be called with db.mu held.
+func (db *DB) persistLastTxID() error {
+	lastTxID := db.LastCommittedTxID()
+	if db.opt.ReadOnly || lastTxID == 0 {
+		return nil
+	}
+
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, lastTxID)
+
+	return db.writeFileAtomic(db.getLastTxIDPath(), buf, db.opt.SyncEnable)
+}
+
+func (db *DB) getLastTxIDPath() string {
+	return db.opt.Dir + "/" + LastTxIDFileName
+}
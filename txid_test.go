@@ -0,0 +1,116 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTx_ID(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttxid", true)
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id := db.LastCommittedTxID(); id != 0 {
+		t.Errorf("TestTx_ID err: got last tx id %d of an empty db", id)
+	}
+
+	// the IDs of the concurrent writers increase in the order of their commits.
+	var (
+		ids  []uint64
+		wg   sync.WaitGroup
+		errs = make(chan error, 20)
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- db.Update(func(tx *Tx) error {
+				// the writers are serialized, so ids is in the commit order.
+				ids = append(ids, tx.ID())
+				return tx.Put("bucket", []byte("key"), []byte{byte(i)}, Persistent)
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Errorf("TestTx_ID err: tx id %d committed after %d", ids[i], ids[i-1])
+		}
+	}
+
+	max := ids[len(ids)-1]
+	if last := db.LastCommittedTxID(); last != max {
+		t.Errorf("TestTx_ID err: last tx id %d, want %d", last, max)
+	}
+
+	var readID uint64
+	if err := db.View(func(tx *Tx) error {
+		readID = tx.ID()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if readID <= max || db.LastCommittedTxID() != max {
+		t.Errorf("TestTx_ID err: read tx id %d, last tx id %d", readID, db.LastCommittedTxID())
+	}
+
+	// the last tx id survives deleting and merging every entry.
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Delete("bucket", []byte("key"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	last := db.LastCommittedTxID()
+
+	if err := db.Merge(); err != nil && err != ErrMergeFileCount {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if got := db.LastCommittedTxID(); got != last {
+		t.Errorf("TestTx_ID err: last tx id %d after reopening, want %d", got, last)
+	}
+
+	var tx *Tx
+	if err := db.Update(func(t *Tx) error {
+		tx = t
+		return t.Put("bucket", []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if tx.ID() <= last || db.LastCommittedTxID() != tx.ID() {
+		t.Errorf("TestTx_ID err: tx id %d after %d", tx.ID(), last)
+	}
+}
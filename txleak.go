@@ -0,0 +1,111 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrTooManyTransactions is returned when beginning a transaction while MaxActiveTransactions are open.
+var ErrTooManyTransactions = errors.New("too many active transactions")
+
+// TxLeakDetectionOptions represents the detection of the transactions left open of Options.TxLeakDetection.
+type TxLeakDetectionOptions struct {
+	// Threshold represents the age of an open transaction reported as leaked. 0 disables the detection.
+	Threshold time.Duration
+
+	// Interval represents the time between two checks of the open transactions.
+	// Default Interval is 0, which means Threshold.
+	Interval time.Duration
+
+	// OnLeak is called once for every transaction found open longer than Threshold.
+	// Default OnLeak is nil, which means logging the transaction and its creation stack
+	// trace with the standard logger.
+	OnLeak func(info TxInfo)
+}
+
+// startTxLeakDetection starts the background goroutine detecting the leaked transactions, if enabled.
+func (db *DB) startTxLeakDetection() {
+	opt := db.opt.TxLeakDetection
+	if opt.Threshold <= 0 {
+		return
+	}
+
+	interval := opt.Interval
+	if interval <= 0 {
+		interval = opt.Threshold
+	}
+
+	db.txLeakStop = make(chan struct{})
+	db.txLeakDone = make(chan struct{})
+
+	go func() {
+		defer close(db.txLeakDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				db.reportTxLeaks(now)
+			case <-db.txLeakStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopTxLeakDetection stops the detection of the leaked transactions.
+func (db *DB) stopTxLeakDetection() {
+	if db.txLeakStop == nil {
+		return
+	}
+
+	select {
+	case <-db.txLeakStop:
+	default:
+		close(db.txLeakStop)
+	}
+
+	<-db.txLeakDone
+}
+
+// reportTxLeaks reports the transactions open longer than the threshold at given time not reported yet.
+func (db *DB) reportTxLeaks(now time.Time) {
+	opt := db.opt.TxLeakDetection
+
+	var leaks []TxInfo
+	db.openTxs.txs.Range(func(v, _ interface{}) bool {
+		o := v.(*openTx)
+		if age := now.Sub(o.started); age >= opt.Threshold && !o.leakReported {
+			o.leakReported = true
+			leaks = append(leaks, o.info(now))
+		}
+		return true
+	})
+
+	for _, info := range leaks {
+		if opt.OnLeak != nil {
+			opt.OnLeak(info)
+			continue
+		}
+
+		log.Printf("nutsdb: transaction %d (writable: %v) open for %s, begun at:\n%s",
+			info.ID, info.Writable, info.Age, info.Stack)
+	}
+}
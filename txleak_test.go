@@ -0,0 +1,89 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDB_MaxActiveTransactions(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmaxactivetxs", true)
+	opt.MaxActiveTransactions = 1
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Begin(false); err != ErrTooManyTransactions {
+		t.Errorf("err Begin over MaxActiveTransactions. got %v want ErrTooManyTransactions", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *Tx) error { return nil }); err != nil {
+		t.Errorf("err View after closing the transaction: %v", err)
+	}
+}
+
+func TestDB_TxLeakDetection(t *testing.T) {
+	leaks := make(chan TxInfo, 10)
+
+	InitOpt("/tmp/nutsdbtesttxleak", true)
+	opt.TxLeakDetection = TxLeakDetectionOptions{
+		Threshold: 20 * time.Millisecond,
+		Interval:  5 * time.Millisecond,
+		OnLeak:    func(info TxInfo) { leaks <- info },
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case info := <-leaks:
+		if info.ID != tx.id || info.Age < opt.TxLeakDetection.Threshold ||
+			!strings.Contains(string(info.Stack), "TestDB_TxLeakDetection") {
+			t.Errorf("err leaked transaction. got %+v", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("err leaked transaction not reported")
+	}
+
+	// every leaked transaction is reported once.
+	time.Sleep(50 * time.Millisecond)
+	if len(leaks) != 0 {
+		t.Errorf("err leaked transaction reported %d more times", len(leaks))
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
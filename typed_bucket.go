@@ -0,0 +1,122 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+type (
+	// Bucket represents a bucket whose values are all of type T,
+	// (de)serialized with the Codec on top of a Tx.
+	Bucket[T any] struct {
+		name  string
+		codec Codec
+	}
+
+	// TypedEntry represents a decoded key/value pair of a typed bucket.
+	TypedEntry[T any] struct {
+		Key   []byte
+		Value T
+	}
+)
+
+// NewBucket returns a newly initialized Bucket at given name and codec.
+// If codec is nil, JSONCodec is used.
+func NewBucket[T any](name string, codec Codec) *Bucket[T] {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
+	return &Bucket[T]{name: name, codec: codec}
+}
+
+// Name returns the name of the bucket.
+func (b *Bucket[T]) Name() string {
+	return b.name
+}
+
+// Put encodes the value and sets it for a key in the bucket.
+func (b *Bucket[T]) Put(tx *Tx, key []byte, value T, ttl uint32) error {
+	data, err := b.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return tx.Put(b.name, key, data, ttl)
+}
+
+// Get retrieves the value for a key in the bucket and decodes it.
+func (b *Bucket[T]) Get(tx *Tx, key []byte) (value T, err error) {
+	e, err := tx.Get(b.name, key)
+	if err != nil {
+		return value, err
+	}
+
+	err = b.codec.Unmarshal(e.Value, &value)
+
+	return
+}
+
+// Delete removes a key from the bucket.
+func (b *Bucket[T]) Delete(tx *Tx, key []byte) error {
+	return tx.Delete(b.name, key)
+}
+
+// RangeScan returns the decoded entries of the bucket between start and end.
+func (b *Bucket[T]) RangeScan(tx *Tx, start, end []byte) ([]TypedEntry[T], error) {
+	entries, err := tx.RangeScan(b.name, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.decode(entries)
+}
+
+// PrefixScan returns the decoded entries of the bucket at given prefix and limitNum.
+func (b *Bucket[T]) PrefixScan(tx *Tx, prefix []byte, limitNum int) ([]TypedEntry[T], error) {
+	entries, err := tx.PrefixScan(b.name, prefix, limitNum)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.decode(entries)
+}
+
+// Scan calls fn for every decoded entry of the bucket at given prefix,
+// stopping at the first non-nil error returned by fn.
+func (b *Bucket[T]) Scan(tx *Tx, prefix []byte, fn func(key []byte, value T) error) error {
+	entries, err := b.PrefixScan(tx, prefix, ScanNoLimit)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := fn(e.Key, e.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Bucket[T]) decode(entries Entries) ([]TypedEntry[T], error) {
+	result := make([]TypedEntry[T], 0, len(entries))
+	for _, e := range entries {
+		var value T
+		if err := b.codec.Unmarshal(e.Value, &value); err != nil {
+			return nil, err
+		}
+		result = append(result, TypedEntry[T]{Key: e.Key, Value: value})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+type typedUser struct {
+	Name string
+	Age  int
+}
+
+func TestBucket_PutGetScan(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, GobCodec{}} {
+		InitOpt("/tmp/nutsdbtesttypedbucket", true)
+		db, err = Open(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		users := NewBucket[typedUser]("users", codec)
+
+		if err := db.Update(func(tx *Tx) error {
+			for i := 0; i < 3; i++ {
+				key := []byte(fmt.Sprintf("user_%d", i))
+				if err := users.Put(tx, key, typedUser{Name: string(key), Age: i}, Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.View(func(tx *Tx) error {
+			u, err := users.Get(tx, []byte("user_1"))
+			if err != nil {
+				return err
+			}
+			if u.Name != "user_1" || u.Age != 1 {
+				t.Errorf("err Bucket Get. got %+v", u)
+			}
+
+			entries, err := users.RangeScan(tx, []byte("user_0"), []byte("user_1"))
+			if err != nil {
+				return err
+			}
+			if len(entries) != 2 {
+				t.Errorf("err Bucket RangeScan. got %d want 2", len(entries))
+			}
+
+			num := 0
+			err = users.Scan(tx, []byte("user_"), func(key []byte, value typedUser) error {
+				if value.Name != string(key) {
+					t.Errorf("err Bucket Scan. got %s want %s", value.Name, key)
+				}
+				num++
+				return nil
+			})
+			if num != 3 {
+				t.Errorf("err Bucket Scan. got %d want 3", num)
+			}
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		db.Close()
+	}
+}
@@ -40,3 +40,31 @@ func Truncate(path string, capacity int64, f *os.File) error {
 	}
 	return nil
 }
+
+// writeFileAtomic writes buf to the file at given path.
+// It writes a temporary file first and renames it, so the file is never partially written.
+func writeFileAtomic(path string, buf []byte, sync bool) error {
+	return writeStorageFileAtomic(osStorage{}, path, buf, sync, DefaultFilePerm)
+}
+
+// TempSuffix is the suffix of the temporary files renamed over the index files,
+// those left by a crash are removed by Open.
+const TempSuffix = ".tmp"
+
+// writeAtomic calls write with a temporary file, then renames it to the given path.
+// If sync is true, the file is synced before and its dir after the rename.
+func writeAtomic(p string, sync bool, write func(fd *os.File) error) error {
+	return writeAtomicPerm(p, sync, DefaultFilePerm, write)
+}
+
+// writeAtomicPerm is writeAtomic with the temporary file created with perm.
+func writeAtomicPerm(p string, sync bool, perm os.FileMode, write func(fd *os.File) error) error {
+	return writeStorageAtomic(osStorage{}, p, sync, perm, func(fd StorageFile) error {
+		return write(fd.(*os.File))
+	})
+}
+
+// removeTempFiles removes the temporary files left in dir by an interrupted writeAtomic.
+func removeTempFiles(dir string) error {
+	return removeStorageTempFiles(osStorage{}, dir)
+}
@@ -15,6 +15,9 @@
 package nutsdb
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/xujiajun/utils/strconv2"
@@ -36,3 +39,39 @@ func TestPrintSortedMap(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteAtomic(t *testing.T) {
+	dir := "/tmp/nutsdbtestwriteatomic"
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	path := dir + "/file"
+	if err := writeFileAtomic(path, []byte("old"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// an interrupted write leaves the file intact and a temporary file behind.
+	failure := errors.New("failure")
+	if err := writeAtomic(path, true, func(fd *os.File) error {
+		fd.Write([]byte("ne"))
+		return failure
+	}); err != failure {
+		t.Fatalf("err writeAtomic. got %v want %v", err, failure)
+	}
+
+	if buf, _ := ioutil.ReadFile(path); string(buf) != "old" {
+		t.Errorf("err writeAtomic. got %s want old", buf)
+	}
+
+	if err := removeTempFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + TempSuffix); !os.IsNotExist(err) {
+		t.Errorf("err removeTempFiles. temporary file not removed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("err removeTempFiles. file removed: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+)
+
+var (
+	// ErrBackupIncomplete is returned by VerifyBackup when the backup has no BackupManifest,
+	// e.g. the Backup writing it was interrupted.
+	ErrBackupIncomplete = errors.New("backup is incomplete")
+
+	// ErrBackupMismatch is returned by VerifyBackup when the backup does not match its
+	// BackupManifest or the source database.
+	ErrBackupMismatch = errors.New("backup does not match")
+)
+
+// KeyCounts counts the keys of a database. The sets and sorted sets count their members
+// and the lists their items, the deleted and expired keys of the BPTree buckets are not counted.
+type KeyCounts struct {
+	BPTree    int64
+	Set       int64
+	SortedSet int64
+	List      int64
+}
+
+// BackupVerification is the report of a VerifyBackup.
+type BackupVerification struct {
+	Manifest *BackupManifest
+
+	// DataFiles and Entries count the data files of the backup and their entries, whose
+	// checksums were verified.
+	DataFiles int
+	Entries   int64
+
+	// Keys counts the keys of the backup, its indexes replayed.
+	Keys KeyCounts
+
+	// RootHashes are the RootHash of the BPTree buckets of the backup.
+	RootHashes map[string][]byte
+
+	// SourceCompared reports if Keys and RootHashes were compared with the ones of the
+	// source database, which is only done while its last committed transaction is the
+	// one of the backup.
+	SourceCompared bool
+}
+
+// VerifyBackup verifies the backup of the database at given dir, written by Backup or
+// BackupTo, before trusting it, e.g. to delete the older ones. The backup is opened
+// read-only with RecoveryStrict, replaying its indexes, the checksums of all the entries
+// of its data files are verified, and it is checked against its BackupManifest. While
+// no transaction has been committed to the database since the backup, its key counts
+// and the RootHash of its buckets are compared with the ones of the database too. It returns an error matching
+// ErrCorrupted, ErrBackupIncomplete or ErrBackupMismatch when the backup cannot be trusted.
+func (db *DB) VerifyBackup(dir string) (*BackupVerification, error) {
+	buf, err := ioutil.ReadFile(dir + "/" + BackupManifestFileName)
+
+	return db.verifyBackup(buf, err, func() (*DB, error) {
+		return Open(db.verifyBackupOptions(dir))
+	})
+}
+
+// VerifyBackupFS verifies the backup at dir in fsys like VerifyBackup, e.g. a backup
+// read from an archive or object storage without extracting it, see OpenFS.
+func (db *DB) VerifyBackupFS(fsys fs.FS, dir string) (*BackupVerification, error) {
+	buf, err := fs.ReadFile(fsys, fsPath(dir+"/"+BackupManifestFileName))
+
+	return db.verifyBackup(buf, err, func() (*DB, error) {
+		return OpenFS(fsys, db.verifyBackupOptions(dir))
+	})
+}
+
+// verifyBackupOptions returns the options opening the backup at dir for VerifyBackup:
+// the ones of the db, read-only and strict, without the features writing files.
+func (db *DB) verifyBackupOptions(dir string) Options {
+	o := db.opt
+	o.Dir = dir
+	o.ReadOnly = true
+	o.RecoveryMode = RecoveryStrict
+	o.LazyIndexLoad = false
+	o.Storage = nil
+	o.RWManagerFactory = nil
+	o.CDC = CDCOptions{}
+	o.AutoBackup = AutoBackupOptions{}
+	o.MergeOnClose = AutoMergeOptions{}
+	o.CompactOnOpen = AutoMergeOptions{}
+
+	return o
+}
+
+// verifyBackup verifies the backup opened by open, whose manifest buf was read with err.
+func (db *DB) verifyBackup(buf []byte, err error, open func() (*DB, error)) (*BackupVerification, error) {
+	if err := db.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBackupIncomplete
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := decodeBackupManifest(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: backup manifest: %v", ErrCorrupted, err)
+	}
+
+	backup, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer backup.Close()
+
+	v := &BackupVerification{Manifest: m}
+	if err := backup.verifyDataFiles(m, v); err != nil {
+		return nil, err
+	}
+
+	if txID := backup.LastCommittedTxID(); txID > m.LastTxID {
+		return nil, fmt.Errorf("%w: transaction %d committed after the last one %d of the manifest", ErrBackupMismatch, txID, m.LastTxID)
+	}
+
+	now := db.now()
+
+	if err := backup.View(func(tx *Tx) (err error) {
+		v.Keys = backup.keyCounts(now)
+		v.RootHashes, err = tx.rootHashes()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		if db.lastTxID != m.LastTxID {
+			return nil
+		}
+		v.SourceCompared = true
+
+		if keys := db.keyCounts(now); keys != v.Keys {
+			return fmt.Errorf("%w: key counts %+v, the source has %+v", ErrBackupMismatch, v.Keys, keys)
+		}
+
+		roots, err := tx.rootHashes()
+		if err != nil {
+			return err
+		}
+		// a bucket missing on one side, e.g. with its keys all deleted, hashes as empty.
+		empty := merkleRoot(sha256.New(), nil)
+		for _, pair := range [][2]map[string][]byte{{roots, v.RootHashes}, {v.RootHashes, roots}} {
+			for bucket, root := range pair[0] {
+				other, ok := pair[1][bucket]
+				if !ok {
+					other = empty
+				}
+				if !bytes.Equal(root, other) {
+					return fmt.Errorf("%w: root hash of bucket %s", ErrBackupMismatch, bucket)
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// verifyDataFiles verifies the checksums of the entries of the data files of the backup
+// db, which must be the ones of the manifest m, counting them in v.
+func (db *DB) verifyDataFiles(m *BackupManifest, v *BackupVerification) error {
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+
+	files := make(map[int64]struct{}, len(dataFileIds))
+	for _, id := range dataFileIds {
+		files[int64(id)] = struct{}{}
+	}
+	for _, id := range m.DataFileIDs {
+		if _, ok := files[id]; !ok {
+			return fmt.Errorf("%w: data file %d of the manifest is missing", ErrBackupMismatch, id)
+		}
+		delete(files, id)
+	}
+	for id := range files {
+		return fmt.Errorf("%w: data file %d is not in the manifest", ErrBackupMismatch, id)
+	}
+
+	for _, id := range m.DataFileIDs {
+		n, err := db.verifyDataFile(id)
+		if err != nil {
+			return err
+		}
+		v.DataFiles++
+		v.Entries += n
+	}
+
+	return nil
+}
+
+// verifyDataFile verifies the checksums of the entries of the data file fID and returns
+// their number.
+func (db *DB) verifyDataFile(fID int64) (int64, error) {
+	f, err := db.openScanDataFile(fID, FileIO)
+	if err != nil {
+		return 0, err
+	}
+	defer closeScanDataFile(f)
+
+	var n, off int64
+	r := db.newDataFileReader(f)
+	for {
+		entry, err := r.Next()
+		if err == io.EOF || (err == nil && entry == nil) {
+			return n, nil
+		}
+		if err != nil {
+			if off >= db.opt.SegmentSize {
+				return n, nil
+			}
+			return n, newEntryError(fID, off, err)
+		}
+
+		n++
+		off = r.Offset()
+	}
+}
+
+// keyCounts returns the KeyCounts of the db at given time in Unix seconds.
+// It must be called with db.mu held, e.g. in a View.
+func (db *DB) keyCounts(now uint64) KeyCounts {
+	var c KeyCounts
+
+	db.loadBPTreeIdxes()
+	for _, tree := range db.BPTreeIdx {
+		_, _, pointers := tree.getAll()
+		for _, p := range pointers {
+			meta := p.(*Record).H.meta
+			if meta.Flag != DataDeleteFlag && !isExpiredAt(meta.TTL, meta.timestamp, now) {
+				c.BPTree++
+			}
+		}
+	}
+
+	for _, s := range db.SetIdx {
+		for _, members := range s.M {
+			c.Set += int64(len(members))
+		}
+	}
+
+	for _, ss := range db.SortedSetIdx {
+		c.SortedSet += int64(len(ss.Dict))
+	}
+
+	for _, l := range db.ListIdx {
+		for _, items := range l.Items {
+			c.List += int64(len(items))
+		}
+	}
+
+	return c
+}
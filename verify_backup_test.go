@@ -0,0 +1,149 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDB_VerifyBackup(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestverifybackup", true)
+	opt.SegmentSize = 8 * 1024
+	backup := "/tmp/nutsdbtestverifybackup_backup"
+	os.RemoveAll(backup)
+	defer os.RemoveAll(backup)
+
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 200; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%040d", i, i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.Delete("bucket", []byte("key_0")); err != nil {
+			return err
+		}
+		if err := tx.SAdd("set", []byte("key"), []byte("a"), []byte("b")); err != nil {
+			return err
+		}
+		if err := tx.ZAdd("zset", []byte("key"), 1, []byte("a")); err != nil {
+			return err
+		}
+		return tx.RPush("list", []byte("key"), []byte("a"), []byte("b"), []byte("c"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := db.VerifyBackup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (KeyCounts{BPTree: 199, Set: 2, SortedSet: 1, List: 3}); v.Keys != want {
+		t.Errorf("err Keys. got %+v want %+v", v.Keys, want)
+	}
+	if !v.SourceCompared {
+		t.Error("err SourceCompared. got false want true")
+	}
+	if root, err := db.RootHash("bucket"); err != nil || !bytes.Equal(v.RootHashes["bucket"], root) {
+		t.Errorf("err RootHashes. got %x want %x, %v", v.RootHashes["bucket"], root, err)
+	}
+	if v.DataFiles != len(v.Manifest.DataFileIDs) || v.DataFiles < 2 || v.Entries < 200 {
+		t.Errorf("err %d data files, %d entries", v.DataFiles, v.Entries)
+	}
+
+	v, err = db.VerifyBackupFS(os.DirFS(backup), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.SourceCompared || v.Keys.BPTree != 199 {
+		t.Errorf("err VerifyBackupFS %+v", v)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key_0"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	v, err = db.VerifyBackup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.SourceCompared {
+		t.Error("err SourceCompared after a commit. got true want false")
+	}
+
+	t.Run("corrupted", func(t *testing.T) {
+		path := backup + "/" + getFileName(v.Manifest.DataFileIDs[0], DataSuffix)
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ioutil.WriteFile(path, buf, 0644)
+
+		corrupted := append([]byte(nil), buf...)
+		corrupted[DataEntryHeaderSize+20] ^= 0xff
+		if err := ioutil.WriteFile(path, corrupted, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.VerifyBackup(backup); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("err VerifyBackup. got %v want %v", err, ErrCorrupted)
+		}
+	})
+
+	t.Run("missing data file", func(t *testing.T) {
+		path := backup + "/" + getFileName(v.Manifest.DataFileIDs[0], DataSuffix)
+		if err := os.Rename(path, path+".moved"); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Rename(path+".moved", path)
+
+		if _, err := db.VerifyBackup(backup); !errors.Is(err, ErrBackupMismatch) {
+			t.Errorf("err VerifyBackup. got %v want %v", err, ErrBackupMismatch)
+		}
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		path := backup + "/" + BackupManifestFileName
+		if err := os.Rename(path, path+".moved"); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Rename(path+".moved", path)
+
+		if _, err := db.VerifyBackup(backup); err != ErrBackupIncomplete {
+			t.Errorf("err VerifyBackup. got %v want %v", err, ErrBackupIncomplete)
+		}
+	})
+
+	if _, err := db.VerifyBackup(backup); err != nil {
+		t.Fatal(err)
+	}
+}
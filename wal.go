@@ -0,0 +1,414 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+const (
+	// WALSuffix is the suffix of the files of the write-ahead log, see Options.WAL.
+	WALSuffix = ".wal"
+
+	// DefaultWALCheckpointInterval is the default WALOptions.CheckpointInterval.
+	DefaultWALCheckpointInterval = time.Second
+
+	// DefaultWALMaxSize is the default WALOptions.MaxSize.
+	DefaultWALMaxSize = 64 << 20
+
+	// walRecordHeaderSize is the size of the header of a record: its crc and payload size.
+	walRecordHeaderSize = 8
+
+	// walEntryHeaderSize is the size of the header of an entry of a record: its data file
+	// ID, its offset in it and its size.
+	walEntryHeaderSize = 20
+)
+
+// ErrWALCorrupted is returned when opening a database whose WAL holds a record which
+// passes its crc but fails to decode.
+var ErrWALCorrupted = wrapError("wal corrupted", ErrCorrupted)
+
+// WALOptions represents the write-ahead log of Options.WAL.
+type WALOptions struct {
+	// Enabled represents if the entries of a transaction are appended to the WAL, synced
+	// once, instead of syncing the data file after every entry. It is only used with
+	// SyncEnable, and ignored when ReadOnly is set.
+	Enabled bool
+
+	// CheckpointInterval represents the time between two checkpoints of the WAL, which
+	// sync the data files in a background goroutine and truncate the WAL.
+	// Default CheckpointInterval is 0, which means DefaultWALCheckpointInterval.
+	CheckpointInterval time.Duration
+
+	// MaxSize represents the size in bytes of the WAL starting a checkpoint before the
+	// next CheckpointInterval.
+	// Default MaxSize is 0, which means DefaultWALMaxSize.
+	MaxSize int64
+}
+
+// wal is the write-ahead log of the entries committed to the data files not synced yet.
+// A record holds the entries of a transaction, each with the data file and offset it is
+// written at:
+//
+//	|  crc  | size | fileID | offset | entrySize | entry | fileID | ...
+//
+// The crc covers the payload after the size. A checkpoint starts a new file, of the next
+// generation, syncs the data files and removes the older one.
+type wal struct {
+	db     *DB
+	gen    int64
+	fd     StorageFile
+	size   int64
+	record []byte
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openWAL replays the WAL left by a crash to the data files and starts a new one, if
+// Options.WAL is enabled. It must be called before the data files are read.
+func (db *DB) openWAL() error {
+	if !db.opt.WAL.Enabled || !db.opt.SyncEnable || db.opt.ReadOnly || db.fsys != nil {
+		return nil
+	}
+
+	gen, err := db.replayWAL()
+	if err != nil {
+		return err
+	}
+
+	w := &wal{db: db, gen: gen, kick: make(chan struct{}, 1)}
+	if w.fd, err = w.create(gen); err != nil {
+		return err
+	}
+	db.wal = w
+
+	return nil
+}
+
+// replayWAL writes the entries of the records of the WAL files to the data files, syncs
+// them, removes the WAL files and returns the next generation. A torn record, whose
+// commit did not return, ends its file. The entries of the data files removed by a merge
+// since are skipped.
+func (db *DB) replayWAL() (int64, error) {
+	gens, err := db.getWALGens()
+	if err != nil || len(gens) == 0 {
+		return 0, err
+	}
+
+	maxFileID, _ := db.getMaxFileIDAndFileIDs()
+	files := make(map[int64]*DataFile)
+	defer func() {
+		for _, df := range files {
+			df.rwManager.Close()
+		}
+	}()
+
+	for _, gen := range gens {
+		buf, err := readStorageFile(db.storage, db.getWALPath(gen))
+		if err != nil {
+			return 0, err
+		}
+
+		for len(buf) >= walRecordHeaderSize {
+			size := int(binary.LittleEndian.Uint32(buf[4:8]))
+			if size > len(buf)-walRecordHeaderSize {
+				break
+			}
+			payload := buf[walRecordHeaderSize : walRecordHeaderSize+size]
+			if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(buf[:4]) {
+				break
+			}
+			buf = buf[walRecordHeaderSize+size:]
+
+			for len(payload) >= walEntryHeaderSize {
+				fID := int64(binary.LittleEndian.Uint64(payload[:8]))
+				off := int64(binary.LittleEndian.Uint64(payload[8:16]))
+				n := int(binary.LittleEndian.Uint32(payload[16:20]))
+				if n > len(payload)-walEntryHeaderSize {
+					return 0, ErrWALCorrupted
+				}
+				entry := payload[walEntryHeaderSize : walEntryHeaderSize+n]
+				payload = payload[walEntryHeaderSize+n:]
+
+				df, ok := files[fID]
+				if !ok {
+					p := db.getDataPath(fID)
+					if _, err := db.storage.Stat(p); os.IsNotExist(err) && fID < maxFileID {
+						continue
+					}
+					if df, err = db.newDataFile(p, db.opt.RWMode); err != nil {
+						return 0, err
+					}
+					files[fID] = df
+				}
+
+				if _, err := df.WriteAt(entry, off); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	for _, df := range files {
+		if err := df.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	if err := syncStorageDir(db.storage, db.opt.Dir); err != nil {
+		return 0, err
+	}
+
+	for _, gen := range gens {
+		if err := db.storage.Remove(db.getWALPath(gen)); err != nil {
+			return 0, err
+		}
+	}
+
+	return gens[len(gens)-1] + 1, nil
+}
+
+// getWALGens returns the generations of the WAL files, in ascending order.
+func (db *DB) getWALGens() ([]int64, error) {
+	files, err := db.storage.ReadDir(db.opt.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var gens []int64
+	for _, f := range files {
+		if path.Ext(f.Name()) != WALSuffix {
+			continue
+		}
+		gen, err := strconv2.StrToInt64(strings.TrimSuffix(f.Name(), WALSuffix))
+		if err != nil {
+			continue
+		}
+		gens = append(gens, gen)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+
+	return gens, nil
+}
+
+// getWALPath returns the path of the WAL file of the generation gen.
+func (db *DB) getWALPath(gen int64) string {
+	return db.opt.Dir + "/" + strconv2.Int64ToStr(gen) + WALSuffix
+}
+
+// create creates the WAL file of the generation gen and syncs the dir, so that the file
+// survives a crash.
+func (w *wal) create(gen int64) (StorageFile, error) {
+	fd, err := w.db.storage.OpenFile(w.db.getWALPath(gen), os.O_CREATE|os.O_RDWR|os.O_TRUNC, w.db.filePerm())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncStorageDir(w.db.storage, w.db.opt.Dir); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return fd, nil
+}
+
+// reset discards the entries added to the record of the transaction.
+// It must be called with db.writeMu held, like add and commit.
+func (w *wal) reset() {
+	if w != nil {
+		w.record = w.record[:0]
+	}
+}
+
+// add adds the encoded entry written to the data file fID at off to the record of the transaction.
+func (w *wal) add(fID int64, off int64, entry []byte) {
+	if w == nil {
+		return
+	}
+
+	if len(w.record) == 0 {
+		w.record = append(w.record, make([]byte, walRecordHeaderSize)...)
+	}
+
+	var h [walEntryHeaderSize]byte
+	binary.LittleEndian.PutUint64(h[:8], uint64(fID))
+	binary.LittleEndian.PutUint64(h[8:16], uint64(off))
+	binary.LittleEndian.PutUint32(h[16:20], uint32(len(entry)))
+	w.record = append(w.record, h[:]...)
+	w.record = append(w.record, entry...)
+}
+
+// commit appends the record of the transaction to the WAL and syncs it, making the
+// transaction durable. A failed append is truncated, so that it does not tear the WAL.
+func (w *wal) commit() error {
+	if w == nil || len(w.record) == 0 {
+		return nil
+	}
+
+	payload := w.record[walRecordHeaderSize:]
+	binary.LittleEndian.PutUint32(w.record[:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(w.record[4:8], uint32(len(payload)))
+
+	_, err := w.fd.WriteAt(w.record, w.size)
+	if err == nil {
+		err = w.fd.Sync()
+	}
+	if err != nil {
+		_ = w.fd.Truncate(w.size)
+		return err
+	}
+	w.db.health.recordSync()
+
+	w.size += int64(len(w.record))
+	w.record = w.record[:0]
+
+	if w.size >= w.maxSize() {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (w *wal) maxSize() int64 {
+	if w.db.opt.WAL.MaxSize > 0 {
+		return w.db.opt.WAL.MaxSize
+	}
+
+	return DefaultWALMaxSize
+}
+
+// checkpoint syncs the data files and truncates the WAL. The commits are only blocked
+// while the next WAL file is created, the data files are synced after: the files the
+// active file replaced were synced when closed, see closeActiveFile.
+func (w *wal) checkpoint() error {
+	db := w.db
+
+	db.writeMu.Lock()
+	if db.closed || w.size == 0 {
+		db.writeMu.Unlock()
+		return nil
+	}
+
+	fd, err := w.create(w.gen + 1)
+	if err != nil {
+		db.writeMu.Unlock()
+		return err
+	}
+	old, oldGen := w.fd, w.gen
+	w.fd, w.gen, w.size = fd, w.gen+1, 0
+	activePath := db.ActiveFile.path
+	db.writeMu.Unlock()
+
+	if err := syncStorageFile(db.storage, activePath); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		// the active file was renamed by a merge, see publishDataFile.
+		db.writeMu.Lock()
+		if !db.closed {
+			err = db.ActiveFile.Sync()
+		}
+		db.writeMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	db.health.recordSync()
+
+	old.Close()
+
+	return db.storage.Remove(db.getWALPath(oldGen))
+}
+
+// close syncs the active file and removes the WAL. It must be called with db.writeMu held.
+func (w *wal) close() error {
+	if w == nil {
+		return nil
+	}
+
+	err := w.db.ActiveFile.Sync()
+	if cerr := w.fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.db.storage.Remove(w.db.getWALPath(w.gen))
+}
+
+// startWAL starts the background goroutine of the checkpoints of the WAL, if enabled.
+func (db *DB) startWAL() {
+	w := db.wal
+	if w == nil {
+		return
+	}
+
+	interval := db.opt.WAL.CheckpointInterval
+	if interval <= 0 {
+		interval = DefaultWALCheckpointInterval
+	}
+
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-w.kick:
+			case <-w.stop:
+				return
+			}
+
+			if err := w.checkpoint(); err != nil {
+				db.health.recordError(err)
+			}
+		}
+	}()
+}
+
+// stopWAL stops the background goroutine of the checkpoints of the WAL.
+func (db *DB) stopWAL() {
+	if db.wal == nil || db.wal.stop == nil {
+		return
+	}
+
+	select {
+	case <-db.wal.stop:
+	default:
+		close(db.wal.stop)
+	}
+
+	<-db.wal.done
+}
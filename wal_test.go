@@ -0,0 +1,175 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func openWALDB(t *testing.T, dir string, o WALOptions) *DB {
+	InitOpt(dir, true)
+	opt.SyncEnable = true
+	opt.SegmentSize = 8 * 1024
+	opt.WAL = o
+	opt.WAL.Enabled = true
+
+	db, err := Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func putWAL(t *testing.T, db *DB, from, to int) {
+	for i := from; i < to; i++ {
+		if err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("val_%d_%040d", i, i)), Persistent)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkWAL(t *testing.T, db *DB, n int) {
+	if err := db.View(func(tx *Tx) error {
+		for i := 0; i < n; i++ {
+			e, err := tx.Get("bucket", []byte(fmt.Sprintf("key_%d", i)))
+			if err != nil {
+				return fmt.Errorf("key_%d: %w", i, err)
+			}
+			if want := fmt.Sprintf("val_%d_%040d", i, i); string(e.Value) != want {
+				t.Errorf("key_%d: got %s, want %s", i, e.Value, want)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_WALReplay(t *testing.T) {
+	db := openWALDB(t, "/tmp/nutsdbtestwal", WALOptions{CheckpointInterval: time.Hour})
+	crash := "/tmp/nutsdbtestwal_crash"
+	os.RemoveAll(crash)
+	defer os.RemoveAll(crash)
+
+	putWAL(t, db, 0, 200)
+	if db.ActiveFile.fileID < 2 {
+		t.Fatalf("err %d data files, want several", db.ActiveFile.fileID+1)
+	}
+
+	// the image of a crash losing the writes of the active file not synced yet.
+	if err := copyScanDir(opt.Dir, crash, DefaultDirPerm, DefaultFilePerm, false); err != nil {
+		t.Fatal(err)
+	}
+	active := crash + "/" + getFileName(db.ActiveFile.fileID, DataSuffix)
+	if err := ioutil.WriteFile(active, make([]byte, opt.SegmentSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a torn record, whose commit did not return.
+	fd, err := os.OpenFile(crash+"/0"+WALSuffix, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.Write([]byte{1, 2, 3, 4, 100, 0, 0, 0, 5}); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gens, err := db.getWALGens(); err != nil || len(gens) != 0 {
+		t.Errorf("err WAL files after Close. got %v, %v", gens, err)
+	}
+
+	o := opt
+	o.Dir = crash
+	db, err = Open(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	checkWAL(t, db, 200)
+	if gens, err := db.getWALGens(); err != nil || len(gens) != 1 || gens[0] != 1 {
+		t.Errorf("err WAL files after the replay. got %v, %v want [1]", gens, err)
+	}
+}
+
+func TestDB_WALReplaySkipsMergedFiles(t *testing.T) {
+	db := openWALDB(t, "/tmp/nutsdbtestwal", WALOptions{CheckpointInterval: time.Hour})
+	crash := "/tmp/nutsdbtestwal_crash"
+	os.RemoveAll(crash)
+	defer os.RemoveAll(crash)
+
+	putWAL(t, db, 0, 200)
+	if err := copyScanDir(opt.Dir, crash, DefaultDirPerm, DefaultFilePerm, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first file removed by a merge after the last checkpoint.
+	first := crash + "/" + getFileName(0, DataSuffix)
+	os.Remove(first)
+	os.Remove(crash + "/" + getFileName(0, HintSuffix))
+
+	o := opt
+	o.Dir = crash
+	db, err := Open(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("err the merged data file is written back: %v", err)
+	}
+}
+
+func TestDB_WALCheckpoint(t *testing.T) {
+	db := openWALDB(t, "/tmp/nutsdbtestwal", WALOptions{CheckpointInterval: time.Hour, MaxSize: 1})
+	defer db.Close()
+
+	putWAL(t, db, 0, 10)
+
+	// the WAL outgrowing MaxSize starts a checkpoint.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		db.writeMu.Lock()
+		gen, size := db.wal.gen, db.wal.size
+		db.writeMu.Unlock()
+		gens, err := db.getWALGens()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gen > 0 && size == 0 && len(gens) == 1 && gens[0] == gen {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("err no checkpoint. gen %d size %d files %v", gen, size, gens)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	checkWAL(t, db, 10)
+}
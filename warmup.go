@@ -0,0 +1,155 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+// Warmup builds and reads in the indexes and the values of the buckets, all of them
+// if none is given, in a background goroutine, so that the first transactions after
+// opening the database do not pay for it:
+//
+// 1. The B+ tree indexes deferred by LazyIndexLoad are built.
+//
+// 2. The values not kept in RAM are read from the data files, filling the page cache.
+//
+// 3. In HintBPTSparseIdxMode, the top nodes of every index file are read, filling the
+// node cache of IndexCacheSize, and the buckets are ignored as the files mix them.
+//
+// The returned channel receives the first error, if any, and is closed when it is done.
+// Warmup runs View transactions, so it does not block the writable transactions for long.
+func (db *DB) Warmup(buckets ...string) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		defer close(done)
+
+		var err error
+		if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+			err = db.warmupIndexFiles()
+		} else {
+			err = db.warmupBuckets(buckets)
+		}
+
+		if err != nil {
+			done <- err
+		}
+	}()
+
+	return done
+}
+
+// warmupBuckets builds the indexes and reads the values of the buckets, one View by bucket.
+func (db *DB) warmupBuckets(buckets []string) error {
+	if len(buckets) == 0 {
+		if err := db.View(func(tx *Tx) error {
+			for bucket := range tx.db.BPTreeIdx {
+				buckets = append(buckets, bucket)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, bucket := range buckets {
+		if err := db.View(func(tx *Tx) error {
+			return tx.warmupBucket(bucket)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// warmupBucket builds the index of the bucket and reads the values of its live keys not
+// kept in RAM, opening every data file once.
+func (tx *Tx) warmupBucket(bucket string) error {
+	t, ok := tx.bptreeIdx(bucket)
+	if !ok {
+		return nil
+	}
+
+	files := make(map[int64]*DataFile)
+	defer func() {
+		for _, df := range files {
+			df.rwManager.Close()
+		}
+	}()
+
+	_, _, pointers := t.getAll()
+	for _, p := range pointers {
+		r, ok := p.(*Record)
+		if !ok || r.E != nil || r.H.meta.Flag == DataDeleteFlag || tx.db.isExpired(r.H.meta.TTL, r.H.meta.timestamp) {
+			continue
+		}
+
+		df, ok := files[r.H.fileID]
+		if !ok {
+			var err error
+			if df, err = tx.db.openDataFile(r.H.fileID, tx.db.opt.RWMode); err != nil {
+				return err
+			}
+			files[r.H.fileID] = df
+		}
+
+		if _, err := df.ReadAt(int(r.H.dataPos)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// warmupIndexFiles reads the root node and its children of every index file, one View by file.
+func (db *DB) warmupIndexFiles() error {
+	var roots []*BPTreeRootIdx
+	if err := db.View(func(tx *Tx) error {
+		roots = append(roots, tx.db.BPTreeRootIdxes...)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		if err := db.View(func(tx *Tx) error {
+			return tx.warmupIndexFile(root)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// warmupIndexFile reads the root node at the root index and its children.
+func (tx *Tx) warmupIndexFile(root *BPTreeRootIdx) error {
+	path := tx.db.getBPTPath(int64(root.fID))
+
+	node, err := tx.db.readIndexNode(path, int64(root.rootOff))
+	if err != nil {
+		return err
+	}
+
+	if node.IsLeaf == 1 {
+		return nil
+	}
+
+	for i := 0; i <= int(node.KeysNum) && i < len(node.Pointers); i++ {
+		if _, err := tx.db.readIndexNode(path, node.Pointers[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
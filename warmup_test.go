@@ -0,0 +1,116 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func putWarmupTestData(t *testing.T) {
+	for b := 0; b < 3; b++ {
+		for i := 0; i < 100; i++ {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put(fmt.Sprintf("bucket_%d", b), []byte(fmt.Sprintf("key_%03d", i)), []byte(fmt.Sprintf("val_%03d", i)), Persistent)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestDB_Warmup(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwarmup", true)
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	opt.LazyIndexLoad = true
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putWarmupTestData(t)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-db.Warmup("bucket_0", "bucket_unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.LazyBuckets(); n != 2 {
+		t.Errorf("err LazyBuckets after warming a bucket up. got %d want 2", n)
+	}
+
+	if err := <-db.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.LazyBuckets(); n != 0 {
+		t.Errorf("err LazyBuckets after warming all up. got %d want 0", n)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-db.Warmup(); err != ErrDBClosed {
+		t.Errorf("err Warmup on a closed db. got %v want ErrDBClosed", err)
+	}
+}
+
+func TestDB_WarmupSparseIndex(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwarmup", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+	opt.IndexCacheSize = 64
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putWarmupTestData(t)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := <-db.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := db.IndexCacheStats()
+	if stats.Nodes == 0 || stats.MappedFiles == 0 {
+		t.Errorf("err IndexCacheStats after Warmup. got %+v", stats)
+	}
+
+	if err := db.View(func(tx *Tx) error {
+		for i := 0; i < 100; i++ {
+			e, err := tx.Get("bucket_1", []byte(fmt.Sprintf("key_%03d", i)))
+			if err != nil {
+				return fmt.Errorf("key_%03d: %w", i, err)
+			}
+			if string(e.Value) != fmt.Sprintf("val_%03d", i) {
+				t.Errorf("err value of key_%03d. got %s", i, e.Value)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultSlowdownDelay is the delay of the writable transactions when
+// WriteStallOptions.SlowdownDelay is not set.
+const defaultSlowdownDelay = time.Millisecond
+
+// ErrWriteStalled is returned when a writable transaction is refused by Options.WriteStall.
+var ErrWriteStalled = errors.New("write stalled")
+
+// WriteStallOptions represents the backpressure of Options.WriteStall, applied to the
+// writable transactions when the sealed data files, the ones the merges have to process,
+// pile up. The low-priority transactions, e.g. those of the merges, are never stalled.
+type WriteStallOptions struct {
+	// SlowdownTrigger represents the number of sealed data files from which every
+	// writable transaction is delayed by SlowdownDelay when it begins.
+	// Default SlowdownTrigger is 0, which means no slowdown.
+	SlowdownTrigger int
+
+	// SlowdownDelay represents the delay of a writable transaction slowed down.
+	// Default SlowdownDelay is 1ms.
+	SlowdownDelay time.Duration
+
+	// StopTrigger represents the number of sealed data files from which the writable
+	// transactions wait for a merge to remove some, up to StopTimeout. MergeWorst removes
+	// the files it merges, while Merge rewrites every file into a new one.
+	// Default StopTrigger is 0, which means no stop.
+	StopTrigger int
+
+	// StopTimeout represents the max time a writable transaction waits while the writes
+	// are stopped, before Begin returns a *WriteStallError.
+	// Default StopTimeout is 0, which means returning it at once.
+	StopTimeout time.Duration
+}
+
+// WriteStallError records the writable transaction refused by Options.WriteStall.
+// It unwraps to ErrWriteStalled.
+type WriteStallError struct {
+	// SealedFiles is the number of sealed data files.
+	SealedFiles int
+
+	// StopTrigger is Options.WriteStall.StopTrigger.
+	StopTrigger int
+
+	// Waited is the time the transaction waited for a merge.
+	Waited time.Duration
+}
+
+// Error implements the error interface.
+func (e *WriteStallError) Error() string {
+	return fmt.Sprintf("write stalled: %d sealed data files, stop trigger %d, waited %s",
+		e.SealedFiles, e.StopTrigger, e.Waited)
+}
+
+// Unwrap returns ErrWriteStalled.
+func (e *WriteStallError) Unwrap() error {
+	return ErrWriteStalled
+}
+
+// enabled returns if the options stall any write.
+func (o WriteStallOptions) enabled() bool {
+	return o.SlowdownTrigger > 0 || o.StopTrigger > 0
+}
+
+// sealedFiles returns the number of data files but the active one.
+// It must be called with the db.writeMu lock held.
+func (db *DB) sealedFiles() int {
+	return int(db.sealedSize / db.opt.SegmentSize)
+}
+
+// notifyFilesRemoved wakes up the transactions stopped by Options.WriteStall.
+// It must be called with the db.writeMu lock held.
+func (db *DB) notifyFilesRemoved() {
+	if db.filesRemoved != nil {
+		close(db.filesRemoved)
+		db.filesRemoved = nil
+	}
+}
+
+// waitWriteStall applies Options.WriteStall to the writable transaction tx, called
+// with its lock held. The lock is released while tx is delayed or waits for a merge,
+// and held again when it returns.
+func (db *DB) waitWriteStall(tx *Tx) error {
+	opt := db.opt.WriteStall
+	if !tx.writable || tx.priority == PriorityLow || !opt.enabled() {
+		return nil
+	}
+
+	var (
+		start   = time.Now()
+		slowed  bool
+		stopped bool
+	)
+
+	defer func() {
+		if slowed || stopped {
+			db.health.recordWriteStall(time.Since(start))
+		}
+	}()
+
+	for {
+		if db.closed {
+			return ErrDBClosed
+		}
+
+		files := db.sealedFiles()
+
+		switch {
+		case opt.StopTrigger > 0 && files >= opt.StopTrigger:
+			if !stopped {
+				stopped = true
+				db.health.recordWriteStop()
+			}
+
+			wait := opt.StopTimeout - time.Since(start)
+			if wait <= 0 {
+				return &WriteStallError{SealedFiles: files, StopTrigger: opt.StopTrigger, Waited: time.Since(start)}
+			}
+
+			if db.filesRemoved == nil {
+				db.filesRemoved = make(chan struct{})
+			}
+			removed := db.filesRemoved
+
+			tx.unlock()
+			timer := time.NewTimer(wait)
+			select {
+			case <-removed:
+			case <-timer.C:
+			}
+			timer.Stop()
+			tx.lock()
+		case opt.SlowdownTrigger > 0 && files >= opt.SlowdownTrigger && !slowed:
+			slowed = true
+			db.health.recordWriteSlowdown()
+
+			delay := opt.SlowdownDelay
+			if delay <= 0 {
+				delay = defaultSlowdownDelay
+			}
+
+			tx.unlock()
+			time.Sleep(delay)
+			tx.lock()
+		default:
+			return nil
+		}
+	}
+}
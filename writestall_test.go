@@ -0,0 +1,127 @@
+// Copyright 2019 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fillSealedFiles writes to the db until it has n sealed data files.
+func fillSealedFiles(t *testing.T, n int) {
+	t.Helper()
+
+	for i := 0; db.sealedFiles() < n; i++ {
+		err := db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), make([]byte, 100), Persistent)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDB_WriteStall(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwritestall", true)
+	opt.SegmentSize = 1024
+	opt.WriteStall = WriteStallOptions{SlowdownTrigger: 2, StopTrigger: 4}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fillSealedFiles(t, 2)
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if h := db.Health(); h.WriteSlowdowns == 0 || h.WriteStops != 0 {
+		t.Errorf("TestDB_WriteStall err: got %d slowdowns and %d stops", h.WriteSlowdowns, h.WriteStops)
+	}
+
+	fillSealedFiles(t, 4)
+
+	_, err := db.Begin(true)
+	var stallErr *WriteStallError
+	if !errors.As(err, &stallErr) || !errors.Is(err, ErrWriteStalled) {
+		t.Fatalf("expected a *WriteStallError, got %v", err)
+	}
+	if stallErr.SealedFiles != 4 || stallErr.StopTrigger != 4 {
+		t.Errorf("TestDB_WriteStall err: got %+v", stallErr)
+	}
+
+	if h := db.Health(); h.WriteStops != 1 || h.WriteStallTime <= 0 {
+		t.Errorf("TestDB_WriteStall err: got %d stops, stalled %s", h.WriteStops, h.WriteStallTime)
+	}
+
+	// the reads and the merges go on, a partial merge removing the merged files.
+	if err := db.View(func(tx *Tx) error {
+		_, err := tx.Get("bucket", []byte("key"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.MergeWorst(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_WriteStallWaitsForMerge(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwritestall", true)
+	opt.SegmentSize = 1024
+	opt.WriteStall = WriteStallOptions{StopTrigger: 4, StopTimeout: 10 * time.Second}
+	db, err = Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fillSealedFiles(t, 4)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), []byte("val"), Persistent)
+		})
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("TestDB_WriteStallWaitsForMerge err: not stopped, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := db.MergeWorst(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if h := db.Health(); h.WriteStops != 1 || h.WriteStallTime < 50*time.Millisecond {
+		t.Errorf("TestDB_WriteStallWaitsForMerge err: got %d stops, stalled %s", h.WriteStops, h.WriteStallTime)
+	}
+}